@@ -0,0 +1,166 @@
+// Package archive reads and writes the archive format produced by mongodump --archive and consumed
+// by mongorestore --archive.
+//
+// An archive starts with a prelude: the magic number 0x8199e26d as a little-endian uint32, a
+// header document, a metadata document for each collection, and a terminator, the four bytes
+// 0xffffffff. The prelude is followed by the documents of the collections, multiplexed into
+// blocks. Each block is a namespace header document naming a collection, the documents of that
+// collection, and a terminator. Once a collection has no more documents, a block with a namespace
+// header whose EOF field is true and whose CRC field holds the CRC-64 (ECMA) of all of its
+// documents, and no documents, ends it.
+//
+// Archives written with mongodump --archive --gzip are compressed as a whole with gzip. A Reader
+// detects and decompresses them, and a Writer compresses the archive when Options.Gzip is set.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// Magic is the number at the start of an archive.
+const Magic uint32 = 0x8199e26d
+
+// FormatVersion is the version of the archive format written by a Writer.
+const FormatVersion = "0.1"
+
+// terminator ends the prelude and each block.
+const terminator uint32 = 0xffffffff
+
+// maxDocumentSize is the size of the largest document in an archive, which is the largest size
+// of a document stored by the server.
+const maxDocumentSize = 16*1024*1024 + 16*1024
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// ErrNotArchive is returned by NewReader when the input does not start with the magic number.
+var ErrNotArchive = errors.New("archive: not an archive")
+
+// ErrTruncated is returned when an archive ends before all of its collections are complete.
+var ErrTruncated = errors.New("archive: unexpected end of archive")
+
+// Header is the first document of the prelude.
+type Header struct {
+	// ConcurrentCollections is the number of collections whose blocks may be interleaved.
+	ConcurrentCollections int32
+	// FormatVersion is the version of the archive format.
+	FormatVersion string
+	ServerVersion string
+	ToolVersion   string
+}
+
+// CollectionMetadata describes a collection of an archive in the prelude.
+type CollectionMetadata struct {
+	Database   string
+	Collection string
+	// Metadata is the content of the .metadata.json file written by mongodump for the collection,
+	// which holds its options, indexes, and UUID as extended JSON.
+	Metadata string
+	// Size is the total size of the documents of the collection.
+	Size int64
+	// Type is "collection", "view", or "timeseries", and may be empty in older archives.
+	Type string
+}
+
+// Namespace returns the namespace of the collection, such as "db.coll".
+func (cm CollectionMetadata) Namespace() string {
+	return cm.Database + "." + cm.Collection
+}
+
+// Prelude is the part of an archive describing its contents.
+type Prelude struct {
+	Header      Header
+	Collections []CollectionMetadata
+}
+
+// namespaceHeader is the document at the start of a block.
+type namespaceHeader struct {
+	database   string
+	collection string
+	eof        bool
+	crc        int64
+}
+
+// FormatError is returned when a document of an archive does not have the expected fields.
+type FormatError struct {
+	// Document is the kind of document, such as "header" or "namespace header".
+	Document string
+	Message  string
+}
+
+func (fe FormatError) Error() string {
+	return fmt.Sprintf("archive: invalid %s: %s", fe.Document, fe.Message)
+}
+
+// splitNamespace returns the database and collection of the namespace ns, which are separated by
+// the first dot.
+func splitNamespace(ns string) (string, string, error) {
+	i := strings.IndexByte(ns, '.')
+	if i <= 0 || i == len(ns)-1 {
+		return "", "", fmt.Errorf("archive: invalid namespace %q", ns)
+	}
+
+	return ns[:i], ns[i+1:], nil
+}
+
+// fields reads the fields of a document of the kind named by document.
+type fields struct {
+	document string
+	r        bson.Reader
+}
+
+// value returns the value of key with type t, or nil if the document has no field key.
+func (f fields) value(key string, t bson.Type) (*bson.Value, error) {
+	elem, err := f.r.Lookup(key)
+	if err != nil || elem == nil {
+		return nil, err
+	}
+	if elem.Value().Type() != t {
+		return nil, FormatError{
+			Document: f.document,
+			Message:  fmt.Sprintf("%s must be of type %s, not %s", key, t.Alias(), elem.Value().Type().Alias()),
+		}
+	}
+
+	return elem.Value(), nil
+}
+
+func (f fields) string(key string) (string, error) {
+	v, err := f.value(key, bson.TypeString)
+	if v == nil {
+		return "", err
+	}
+	return v.StringValue(), nil
+}
+
+func (f fields) boolean(key string) (bool, error) {
+	v, err := f.value(key, bson.TypeBoolean)
+	if v == nil {
+		return false, err
+	}
+	return v.Boolean(), nil
+}
+
+// integer returns the value of key, which may be an int32 or an int64.
+func (f fields) integer(key string) (int64, error) {
+	elem, err := f.r.Lookup(key)
+	if err != nil || elem == nil {
+		return 0, err
+	}
+
+	switch v := elem.Value(); v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), nil
+	case bson.TypeInt64:
+		return v.Int64(), nil
+	default:
+		return 0, FormatError{
+			Document: f.document,
+			Message:  fmt.Sprintf("%s must be an integer, not %s", key, v.Type().Alias()),
+		}
+	}
+}
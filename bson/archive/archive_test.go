@@ -0,0 +1,264 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc64"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+var prelude = Prelude{
+	Header: Header{ConcurrentCollections: 4, FormatVersion: "0.1", ServerVersion: "6.0.0", ToolVersion: "100.7.0"},
+	Collections: []CollectionMetadata{
+		{Database: "db", Collection: "a", Metadata: `{"indexes":[]}`, Size: 40, Type: "collection"},
+		{Database: "db", Collection: "b.c", Metadata: `{}`, Size: 20},
+		{Database: "other", Collection: "empty", Metadata: `{}`},
+	},
+}
+
+type write struct {
+	ns  string
+	doc []byte
+}
+
+// writes returns documents of db.a and db.b.c interleaved.
+func writes(t *testing.T) []write {
+	return []write{
+		{"db.a", bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 1), C.String("s", "aaaa")))},
+		{"db.a", bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 2)))},
+		{"db.b.c", bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 1)))},
+		{"db.a", bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 3)))},
+	}
+}
+
+func writeArchive(t *testing.T, opts *Options) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, prelude, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, wr := range writes(t) {
+		if err := w.WriteDocument(wr.ns, wr.doc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := w.End("db.b.c"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestFormat(t *testing.T) {
+	var want []byte
+	appendUint32 := func(n uint32) {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], n)
+		want = append(want, b[:]...)
+	}
+	appendDoc := func(elems ...*bson.Element) {
+		want = append(want, bsontest.Marshal(t, bson.NewDocument(elems...))...)
+	}
+	crcs := map[string]uint64{}
+	block := func(db, coll string, docs ...[]byte) {
+		appendDoc(C.String("db", db), C.String("collection", coll), C.Boolean("EOF", false), C.Int64("CRC", 0))
+		for _, doc := range docs {
+			want = append(want, doc...)
+			crcs[db+"."+coll] = crc64.Update(crcs[db+"."+coll], crcTable, doc)
+		}
+		appendUint32(0xffffffff)
+	}
+	eof := func(db, coll string) {
+		appendDoc(C.String("db", db), C.String("collection", coll), C.Boolean("EOF", true), C.Int64("CRC", int64(crcs[db+"."+coll])))
+		appendUint32(0xffffffff)
+	}
+
+	appendUint32(0x8199e26d)
+	appendDoc(C.Int32("concurrent_collections", 4), C.String("version", "0.1"), C.String("server_version", "6.0.0"), C.String("tool_version", "100.7.0"))
+	appendDoc(C.String("db", "db"), C.String("collection", "a"), C.String("metadata", `{"indexes":[]}`), C.Int64("size", 40), C.String("type", "collection"))
+	appendDoc(C.String("db", "db"), C.String("collection", "b.c"), C.String("metadata", `{}`), C.Int64("size", 20))
+	appendDoc(C.String("db", "other"), C.String("collection", "empty"), C.String("metadata", `{}`), C.Int64("size", 0))
+	appendUint32(0xffffffff)
+	w := writes(t)
+	block("db", "a", w[0].doc, w[1].doc)
+	block("db", "b.c", w[2].doc)
+	block("db", "a", w[3].doc)
+	eof("db", "b.c")
+	eof("db", "a")
+	eof("other", "empty")
+
+	if got := writeArchive(t, nil); !bytes.Equal(got, want) {
+		t.Errorf("Unexpected archive.\ngot  %x\nwant %x", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, opts := range []*Options{nil, {Gzip: true}} {
+		data := writeArchive(t, opts)
+		if opts != nil && (data[0] != 0x1f || data[1] != 0x8b) {
+			t.Errorf("Expected a gzip stream")
+		}
+
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got := r.Prelude()
+		if got.Header != prelude.Header || len(got.Collections) != len(prelude.Collections) {
+			t.Fatalf("Unexpected prelude. got %+v; want %+v", got, prelude)
+		}
+		for i := range got.Collections {
+			if got.Collections[i] != prelude.Collections[i] {
+				t.Errorf("Unexpected collection metadata. got %+v; want %+v", got.Collections[i], prelude.Collections[i])
+			}
+		}
+
+		var i int
+		want := writes(t)
+		for r.Next() {
+			if i >= len(want) {
+				t.Fatalf("Too many documents")
+			}
+			if r.Namespace() != want[i].ns || !bytes.Equal(r.Document(), want[i].doc) {
+				t.Errorf("Unexpected document %d. got %s %v; want %s %v", i, r.Namespace(), r.Document(), want[i].ns, bson.Reader(want[i].doc))
+			}
+			i++
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if i != len(want) {
+			t.Errorf("Unexpected number of documents. got %d; want %d", i, len(want))
+		}
+	}
+}
+
+// buffer is an io.WriteCloser which records whether it was closed.
+type buffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *buffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestDemultiplex(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(writeArchive(t, &Options{Gzip: true})))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	streams := make(map[string]*buffer)
+	err = r.Demultiplex(func(ns string) (io.WriteCloser, error) {
+		if streams[ns] != nil {
+			t.Errorf("%s opened twice", ns)
+		}
+		streams[ns] = new(buffer)
+		return streams[ns], nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := make(map[string][]byte)
+	for _, wr := range writes(t) {
+		want[wr.ns] = append(want[wr.ns], wr.doc...)
+	}
+	var namespaces []string
+	for ns, b := range streams {
+		namespaces = append(namespaces, ns)
+		if !b.closed {
+			t.Errorf("Expected the stream of %s to be closed", ns)
+		}
+		if !bytes.Equal(b.Bytes(), want[ns]) {
+			t.Errorf("Unexpected stream for %s. got %x; want %x", ns, b.Bytes(), want[ns])
+		}
+	}
+	sort.Strings(namespaces)
+	if len(namespaces) != 2 || namespaces[0] != "db.a" || namespaces[1] != "db.b.c" {
+		t.Errorf("Unexpected namespaces. got %v", namespaces)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	data := writeArchive(t, nil)
+
+	corrupted := append([]byte(nil), data...)
+	i := bytes.Index(corrupted, []byte("aaaa"))
+	corrupted[i] = 'b'
+
+	// The EOF blocks of the three collections end the archive.
+	var eofSize int
+	for _, cm := range prelude.Collections {
+		eofSize += len(encodeNamespaceHeader(namespaceHeader{database: cm.Database, collection: cm.Collection, eof: true})) + 4
+	}
+
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"not an archive", []byte("hello, world"), ErrNotArchive},
+		{"empty", nil, ErrNotArchive},
+		{"torn prelude", data[:20], io.ErrUnexpectedEOF},
+		{"torn document", data[:len(data)-eofSize-6], io.ErrUnexpectedEOF},
+		{"missing EOF", data[:len(data)-eofSize], ErrTruncated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := ReadAll(bytes.NewReader(tc.data))
+			if err != tc.err {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+		})
+	}
+
+	if _, _, err := ReadAll(bytes.NewReader(corrupted)); err == nil {
+		t.Errorf("Expected a checksum error")
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	w, err := NewWriter(io.Discard, Prelude{}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	doc := bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 1)))
+
+	if err := w.WriteDocument("nodot", doc); err == nil {
+		t.Errorf("Expected an error for an invalid namespace")
+	}
+	if err := w.WriteDocument("db.c", doc[:len(doc)-1]); err == nil {
+		t.Errorf("Expected an error for an invalid document")
+	}
+	if err := w.End("db.c"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := w.WriteDocument("db.c", doc); err == nil {
+		t.Errorf("Expected an error for a collection which has ended")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := w.WriteDocument("db.d", doc); err != ErrClosed {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,379 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// Reader reads the documents of an archive in the order they were written.
+type Reader struct {
+	r       *bufio.Reader
+	gz      *gzip.Reader
+	prelude Prelude
+
+	// block is the namespace header of the current block, or nil between blocks.
+	block *namespaceHeader
+	// crcs holds the running CRC of each collection which has not ended.
+	crcs map[string]hash.Hash64
+	done map[string]bool
+
+	ns  string
+	doc bson.Reader
+	err error
+}
+
+// NewReader reads the prelude of the archive in r, which may be compressed with gzip, and returns a
+// Reader positioned at its first document.
+func NewReader(r io.Reader) (*Reader, error) {
+	ar := &Reader{
+		r:    bufio.NewReader(r),
+		crcs: make(map[string]hash.Hash64),
+		done: make(map[string]bool),
+	}
+
+	if b, err := ar.r.Peek(2); err == nil && b[0] == 0x1f && b[1] == 0x8b {
+		gz, err := gzip.NewReader(ar.r)
+		if err != nil {
+			return nil, err
+		}
+		ar.gz, ar.r = gz, bufio.NewReader(gz)
+	}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(ar.r, magic[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotArchive
+		}
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(magic[:]) != Magic {
+		return nil, ErrNotArchive
+	}
+
+	if err := ar.readPrelude(); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+func (ar *Reader) readPrelude() error {
+	doc, err := ar.readDocument()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if doc == nil {
+		return FormatError{Document: "prelude", Message: "missing header"}
+	}
+	if ar.prelude.Header, err = decodeHeader(doc); err != nil {
+		return err
+	}
+
+	for {
+		doc, err := ar.readDocument()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if doc == nil {
+			return nil
+		}
+
+		cm, err := decodeCollectionMetadata(doc)
+		if err != nil {
+			return err
+		}
+		ar.prelude.Collections = append(ar.prelude.Collections, cm)
+	}
+}
+
+// Prelude returns the prelude of the archive.
+func (ar *Reader) Prelude() Prelude {
+	return ar.prelude
+}
+
+// Next advances the reader to the next document and reports whether there is one. It returns false
+// at the end of the archive or when an error occurs. The checksum of each collection is verified
+// when the collection ends.
+func (ar *Reader) Next() bool {
+	if ar.err != nil {
+		return false
+	}
+
+	for {
+		if ar.block == nil {
+			doc, err := ar.readDocument()
+			if err == io.EOF {
+				ar.finish()
+				return false
+			}
+			if err == nil && doc == nil {
+				err = FormatError{Document: "archive", Message: "terminator outside of a block"}
+			}
+			if err == nil {
+				err = ar.startBlock(doc)
+			}
+			if err != nil {
+				ar.fail(unexpectedEOF(err))
+				return false
+			}
+		}
+
+		doc, err := ar.readDocument()
+		if err != nil {
+			ar.fail(unexpectedEOF(err))
+			return false
+		}
+		if doc == nil {
+			ar.block = nil
+			continue
+		}
+		if ar.block.eof {
+			ar.fail(FormatError{Document: "archive", Message: fmt.Sprintf("document after the end of %s", ar.ns)})
+			return false
+		}
+
+		ar.crcs[ar.ns].Write(doc)
+		ar.doc = doc
+		return true
+	}
+}
+
+// startBlock reads the namespace header doc at the start of a block, and verifies the checksum of
+// the collection if it ends the collection.
+func (ar *Reader) startBlock(doc bson.Reader) error {
+	nh, err := decodeNamespaceHeader(doc)
+	if err != nil {
+		return err
+	}
+	ns := nh.database + "." + nh.collection
+	if ar.done[ns] {
+		return FormatError{Document: "namespace header", Message: fmt.Sprintf("%s has already ended", ns)}
+	}
+
+	crc, ok := ar.crcs[ns]
+	if !ok {
+		crc = crc64.New(crcTable)
+		ar.crcs[ns] = crc
+	}
+	if nh.eof {
+		if sum := int64(crc.Sum64()); sum != nh.crc {
+			return fmt.Errorf("archive: checksum mismatch for %s: got %d; want %d", ns, sum, nh.crc)
+		}
+		delete(ar.crcs, ns)
+		ar.done[ns] = true
+	}
+
+	ar.block, ar.ns, ar.doc = &nh, ns, nil
+	return nil
+}
+
+// finish ends the archive, which fails if a collection has not ended.
+func (ar *Reader) finish() {
+	ar.doc = nil
+	if len(ar.crcs) > 0 {
+		ar.err = ErrTruncated
+		return
+	}
+	if ar.gz != nil {
+		ar.err = ar.gz.Close()
+	}
+}
+
+func (ar *Reader) fail(err error) {
+	ar.err, ar.doc = err, nil
+}
+
+// Namespace returns the namespace of the current document, such as "db.coll".
+func (ar *Reader) Namespace() string {
+	return ar.ns
+}
+
+// Document returns the current document.
+func (ar *Reader) Document() bson.Reader {
+	return ar.doc
+}
+
+// Err returns the error which stopped the reader, if any.
+func (ar *Reader) Err() error {
+	return ar.err
+}
+
+// Demultiplex reads the rest of the archive and writes the documents of each collection, as a
+// sequence of BSON documents, to the writer returned by open for its namespace. open is called
+// when the first document of a collection is read, and the writer is closed when the collection
+// ends. Writers which are still open when an error occurs are closed as well.
+func (ar *Reader) Demultiplex(open func(ns string) (io.WriteCloser, error)) error {
+	writers := make(map[string]io.WriteCloser)
+	closeAll := func() {
+		for _, w := range writers {
+			w.Close()
+		}
+	}
+
+	for ar.Next() {
+		w, ok := writers[ar.ns]
+		if !ok {
+			var err error
+			if w, err = open(ar.ns); err != nil {
+				closeAll()
+				return err
+			}
+			writers[ar.ns] = w
+		}
+		if _, err := w.Write(ar.doc); err != nil {
+			closeAll()
+			return err
+		}
+
+		// Close the writers of the collections which have ended.
+		for ns, w := range writers {
+			if ar.done[ns] {
+				delete(writers, ns)
+				if err := w.Close(); err != nil {
+					closeAll()
+					return err
+				}
+			}
+		}
+	}
+	if ar.err != nil {
+		closeAll()
+		return ar.err
+	}
+
+	for ns, w := range writers {
+		delete(writers, ns)
+		if err := w.Close(); err != nil {
+			closeAll()
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAll reads the archive in r and returns its prelude and the documents of each collection by
+// namespace.
+func ReadAll(r io.Reader) (Prelude, map[string][]bson.Reader, error) {
+	ar, err := NewReader(r)
+	if err != nil {
+		return Prelude{}, nil, err
+	}
+
+	docs := make(map[string][]bson.Reader)
+	for ar.Next() {
+		docs[ar.ns] = append(docs[ar.ns], ar.doc)
+	}
+
+	return ar.prelude, docs, ar.err
+}
+
+// readDocument reads a document, or returns nil if it reads a terminator. It returns io.EOF only if
+// the archive ends before the document.
+func (ar *Reader) readDocument() (bson.Reader, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(ar.r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(size[:])
+	if n == terminator {
+		return nil, nil
+	}
+	if n < 5 || n > maxDocumentSize {
+		return nil, FormatError{Document: "archive", Message: fmt.Sprintf("invalid document size %d", n)}
+	}
+
+	doc := make(bson.Reader, n)
+	copy(doc, size[:])
+	if _, err := io.ReadFull(ar.r, doc[4:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := doc.Validate(); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func decodeHeader(doc bson.Reader) (Header, error) {
+	var h Header
+	f := fields{document: "header", r: doc}
+
+	n, err := f.integer("concurrent_collections")
+	if err != nil {
+		return h, err
+	}
+	h.ConcurrentCollections = int32(n)
+	if h.FormatVersion, err = f.string("version"); err != nil {
+		return h, err
+	}
+	if h.ServerVersion, err = f.string("server_version"); err != nil {
+		return h, err
+	}
+	if h.ToolVersion, err = f.string("tool_version"); err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+func decodeCollectionMetadata(doc bson.Reader) (CollectionMetadata, error) {
+	var cm CollectionMetadata
+	f := fields{document: "collection metadata", r: doc}
+
+	var err error
+	if cm.Database, err = f.string("db"); err != nil {
+		return cm, err
+	}
+	if cm.Collection, err = f.string("collection"); err != nil {
+		return cm, err
+	}
+	if cm.Metadata, err = f.string("metadata"); err != nil {
+		return cm, err
+	}
+	if cm.Size, err = f.integer("size"); err != nil {
+		return cm, err
+	}
+	if cm.Type, err = f.string("type"); err != nil {
+		return cm, err
+	}
+
+	return cm, nil
+}
+
+func decodeNamespaceHeader(doc bson.Reader) (namespaceHeader, error) {
+	var nh namespaceHeader
+	f := fields{document: "namespace header", r: doc}
+
+	var err error
+	if nh.database, err = f.string("db"); err != nil {
+		return nh, err
+	}
+	if nh.collection, err = f.string("collection"); err != nil {
+		return nh, err
+	}
+	if nh.eof, err = f.boolean("EOF"); err != nil {
+		return nh, err
+	}
+	if nh.crc, err = f.integer("CRC"); err != nil {
+		return nh, err
+	}
+	if nh.database == "" && nh.collection == "" {
+		return nh, FormatError{Document: "namespace header", Message: "missing namespace"}
+	}
+
+	return nh, nil
+}
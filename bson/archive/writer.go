@@ -0,0 +1,228 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/builder"
+)
+
+// ErrClosed is returned when the writer has been closed.
+var ErrClosed = errors.New("archive: writer is closed")
+
+// Options configures a Writer.
+type Options struct {
+	// Gzip compresses the archive with gzip, as mongodump --archive --gzip does.
+	Gzip bool
+}
+
+// Writer writes an archive. Documents of different collections may be written in any order, and
+// consecutive documents of the same collection are written in one block.
+type Writer struct {
+	w  *bufio.Writer
+	gz *gzip.Writer
+
+	// ns is the namespace of the current block, or empty between blocks.
+	ns      string
+	crcs    map[string]hash.Hash64
+	ended   map[string]bool
+	pending []string
+	closed  bool
+}
+
+// NewWriter writes the magic number and prelude of an archive to w and returns a Writer for its
+// documents. If opts is nil the defaults are used. Each collection in the prelude which is not
+// ended with End is ended by Close.
+func NewWriter(w io.Writer, prelude Prelude, opts *Options) (*Writer, error) {
+	aw := &Writer{crcs: make(map[string]hash.Hash64), ended: make(map[string]bool)}
+	if opts != nil && opts.Gzip {
+		aw.gz = gzip.NewWriter(w)
+		w = aw.gz
+	}
+	aw.w = bufio.NewWriter(w)
+
+	header := prelude.Header
+	if header.FormatVersion == "" {
+		header.FormatVersion = FormatVersion
+	}
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], Magic)
+	if _, err := aw.w.Write(magic[:]); err != nil {
+		return nil, err
+	}
+	if err := aw.write(encodeHeader(header)); err != nil {
+		return nil, err
+	}
+	for _, cm := range prelude.Collections {
+		if err := aw.write(encodeCollectionMetadata(cm)); err != nil {
+			return nil, err
+		}
+		aw.collection(cm.Namespace())
+	}
+	if err := aw.terminate(); err != nil {
+		return nil, err
+	}
+
+	return aw, nil
+}
+
+// collection returns the running CRC of the collection ns, adding the collection to the ones to end
+// if it is new.
+func (aw *Writer) collection(ns string) hash.Hash64 {
+	crc, ok := aw.crcs[ns]
+	if !ok {
+		crc = crc64.New(crcTable)
+		aw.crcs[ns] = crc
+		aw.pending = append(aw.pending, ns)
+	}
+	return crc
+}
+
+// WriteDocument writes the valid document doc to the collection with the namespace ns, such as
+// "db.coll".
+func (aw *Writer) WriteDocument(ns string, doc bson.Reader) error {
+	if aw.closed {
+		return ErrClosed
+	}
+	if _, err := doc.Validate(); err != nil {
+		return err
+	}
+	if aw.ended[ns] {
+		return fmt.Errorf("archive: %s has already ended", ns)
+	}
+
+	if ns != aw.ns {
+		db, coll, err := splitNamespace(ns)
+		if err != nil {
+			return err
+		}
+		if err := aw.endBlock(); err != nil {
+			return err
+		}
+		if err := aw.write(encodeNamespaceHeader(namespaceHeader{database: db, collection: coll})); err != nil {
+			return err
+		}
+		aw.ns = ns
+	}
+
+	aw.collection(ns).Write(doc)
+	return aw.write(doc)
+}
+
+// End ends the collection with the namespace ns, after which no more of its documents may be
+// written.
+func (aw *Writer) End(ns string) error {
+	if aw.closed {
+		return ErrClosed
+	}
+	if aw.ended[ns] {
+		return fmt.Errorf("archive: %s has already ended", ns)
+	}
+	db, coll, err := splitNamespace(ns)
+	if err != nil {
+		return err
+	}
+	if err := aw.endBlock(); err != nil {
+		return err
+	}
+
+	crc := aw.collection(ns)
+	nh := namespaceHeader{database: db, collection: coll, eof: true, crc: int64(crc.Sum64())}
+	if err := aw.write(encodeNamespaceHeader(nh)); err != nil {
+		return err
+	}
+	aw.ended[ns] = true
+
+	return aw.terminate()
+}
+
+// endBlock terminates the current block, if any.
+func (aw *Writer) endBlock() error {
+	if aw.ns == "" {
+		return nil
+	}
+	aw.ns = ""
+	return aw.terminate()
+}
+
+// Close ends every collection which has not been ended, in the order they were first written or
+// listed in the prelude, and flushes the archive. It does not close the underlying writer.
+func (aw *Writer) Close() error {
+	if aw.closed {
+		return ErrClosed
+	}
+
+	for _, ns := range aw.pending {
+		if aw.ended[ns] {
+			continue
+		}
+		if err := aw.End(ns); err != nil {
+			return err
+		}
+	}
+	aw.closed = true
+
+	if err := aw.w.Flush(); err != nil {
+		return err
+	}
+	if aw.gz != nil {
+		return aw.gz.Close()
+	}
+	return nil
+}
+
+func (aw *Writer) write(doc []byte) error {
+	_, err := aw.w.Write(doc)
+	return err
+}
+
+func (aw *Writer) terminate() error {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], terminator)
+	_, err := aw.w.Write(b[:])
+	return err
+}
+
+func encode(elems ...builder.Elementer) []byte {
+	db := builder.NewDocumentBuilder().Append(elems...)
+	b := make([]byte, db.RequiredBytes())
+	// The elements are strings and numbers, so writing them into a buffer of the required size
+	// cannot fail.
+	db.WriteDocument(b)
+	return b
+}
+
+func encodeHeader(h Header) []byte {
+	return encode(
+		builder.C.Int32("concurrent_collections", h.ConcurrentCollections),
+		builder.C.String("version", h.FormatVersion),
+		builder.C.String("server_version", h.ServerVersion),
+		builder.C.String("tool_version", h.ToolVersion),
+	)
+}
+
+func encodeCollectionMetadata(cm CollectionMetadata) []byte {
+	return encode(
+		builder.C.String("db", cm.Database),
+		builder.C.String("collection", cm.Collection),
+		builder.C.String("metadata", cm.Metadata),
+		builder.C.Int64("size", cm.Size),
+		builder.C.If(cm.Type != "", builder.C.String("type", cm.Type)),
+	)
+}
+
+func encodeNamespaceHeader(nh namespaceHeader) []byte {
+	return encode(
+		builder.C.String("db", nh.database),
+		builder.C.String("collection", nh.collection),
+		builder.C.Boolean("EOF", nh.eof),
+		builder.C.Int64("CRC", nh.crc),
+	)
+}
@@ -177,7 +177,7 @@ func (a *Array) Delete(index uint) *Value {
 	}
 
 	elem := a.doc.elems[index]
-	a.doc.elems = append(a.doc.elems[:index], a.doc.elems[index+1:]...)
+	a.doc.deleteAt(uint32(index))
 
 	return elem.value
 }
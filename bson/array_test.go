@@ -216,6 +216,22 @@ func TestArray(t *testing.T) {
 			})
 		}
 	})
+	t.Run("MarshalBSON", func(t *testing.T) {
+		t.Run("code with scope", func(t *testing.T) {
+			a := NewArray(AC.CodeWithScope("x", NewDocument(C.Int32("a", 1))))
+			got, err := a.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			want, err := NewDocument(C.CodeWithScope("0", "x", NewDocument(C.Int32("a", 1)))).MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Marshaled array does not match. got %v; want %v", got, want)
+			}
+		})
+	})
 }
 
 type testArrayPrependAppendGenerator struct{}
@@ -88,3 +88,55 @@ func (bt Type) String() string {
 		return "invalid"
 	}
 }
+
+// Alias returns the string alias of the BSON type used by MongoDB, as in the $type query operator
+// and the bsonType keyword of $jsonSchema, such as "objectId" or "long". It returns an empty
+// string for an invalid type.
+func (bt Type) Alias() string {
+	switch bt {
+	case TypeDouble:
+		return "double"
+	case TypeString:
+		return "string"
+	case TypeEmbeddedDocument:
+		return "object"
+	case TypeArray:
+		return "array"
+	case TypeBinary:
+		return "binData"
+	case TypeUndefined:
+		return "undefined"
+	case TypeObjectID:
+		return "objectId"
+	case TypeBoolean:
+		return "bool"
+	case TypeDateTime:
+		return "date"
+	case TypeNull:
+		return "null"
+	case TypeRegex:
+		return "regex"
+	case TypeDBPointer:
+		return "dbPointer"
+	case TypeJavaScript:
+		return "javascript"
+	case TypeSymbol:
+		return "symbol"
+	case TypeCodeWithScope:
+		return "javascriptWithScope"
+	case TypeInt32:
+		return "int"
+	case TypeTimestamp:
+		return "timestamp"
+	case TypeInt64:
+		return "long"
+	case TypeDecimal128:
+		return "decimal"
+	case TypeMinKey:
+		return "minKey"
+	case TypeMaxKey:
+		return "maxKey"
+	default:
+		return ""
+	}
+}
@@ -1,6 +1,7 @@
 package bson
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math"
 	"testing"
@@ -95,4 +96,50 @@ func TestValue(t *testing.T) {
 		}
 	})
 	t.Run("document", func(t *testing.T) {})
+	t.Run("bytes", func(t *testing.T) {
+		buf := []byte{
+			'\x00', '\x00', '\x00', '\x00',
+			'\x02', 'f', 'o', 'o', '\x00',
+			'\x04', '\x00', '\x00', '\x00',
+			'b', 'a', 'r', '\x00',
+			'\x00',
+		}
+		e := &Element{&Value{start: 4, offset: 9, data: buf}}
+		want := buf[9:17]
+		got := e.value.Bytes()
+		if !bytes.Equal(got, want) {
+			t.Errorf("Unexpected result. got %v; want %v", got, want)
+		}
+
+		doc := NewDocument(C.Null("x"))
+		want = []byte{'\x08', '\x00', '\x00', '\x00', '\x0a', 'x', '\x00', '\x00'}
+		got = AC.Document(doc).Bytes()
+		if !bytes.Equal(got, want) {
+			t.Errorf("Unexpected result. got %v; want %v", got, want)
+		}
+	})
+}
+
+func TestTypeAlias(t *testing.T) {
+	testCases := []struct {
+		t    Type
+		want string
+	}{
+		{TypeEmbeddedDocument, "object"},
+		{TypeBinary, "binData"},
+		{TypeObjectID, "objectId"},
+		{TypeCodeWithScope, "javascriptWithScope"},
+		{TypeInt32, "int"},
+		{TypeInt64, "long"},
+		{TypeMinKey, "minKey"},
+		{Type(0x20), ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.t.String(), func(t *testing.T) {
+			if got := tc.t.Alias(); got != tc.want {
+				t.Errorf("Unexpected alias. got %q; want %q", got, tc.want)
+			}
+		})
+	}
 }
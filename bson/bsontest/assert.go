@@ -0,0 +1,68 @@
+package bsontest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// AssertEqual reports an error if got and want do not have the same bytes, listing each path at
+// which they differ with the values found there.
+func AssertEqual(t testing.TB, got, want *bson.Document) {
+	t.Helper()
+
+	if diffs := bson.Diff(got, want, nil); len(diffs) > 0 {
+		t.Errorf("Documents are not equal:\n%s", formatDiffs(diffs))
+	}
+}
+
+// AssertEquivalent reports an error if got and want are not equivalent, as described by
+// bson.EquivalenceOptions, listing each path at which they differ with the values found there.
+// If opts is nil, the zero bson.EquivalenceOptions are used.
+func AssertEquivalent(t testing.TB, got, want *bson.Document, opts *bson.EquivalenceOptions) {
+	t.Helper()
+
+	if opts == nil {
+		opts = &bson.EquivalenceOptions{}
+	}
+	if diffs := bson.Diff(got, want, opts); len(diffs) > 0 {
+		t.Errorf("Documents are not equivalent:\n%s", formatDiffs(diffs))
+	}
+}
+
+// formatDiffs returns a line for each of the differences between got and want.
+func formatDiffs(diffs []bson.Difference) string {
+	var lines []string
+	for _, d := range diffs {
+		path := d.Path
+		if path == "" {
+			path = "(root)"
+		}
+
+		switch {
+		case d.A == nil && d.B == nil:
+			lines = append(lines, "\t"+path+": "+d.Message)
+		case d.A == nil:
+			lines = append(lines, "\t"+path+": missing; want "+formatValue(d.B))
+		case d.B == nil:
+			lines = append(lines, "\t"+path+": unexpected "+formatValue(d.A))
+		default:
+			lines = append(lines, "\t"+path+": "+d.Message+"; got "+formatValue(d.A)+"; want "+formatValue(d.B))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// formatValue returns the canonical extended JSON of v.
+func formatValue(v *bson.Value) string {
+	b, err := bson.NewArray(v).MarshalBSON()
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+
+	// The array {"0":<v>} is written as a document.
+	s := format(b)
+	return strings.TrimSuffix(strings.TrimPrefix(s, `{"0":`), "}")
+}
@@ -0,0 +1,403 @@
+// Package bsontest generates random BSON documents for property-based tests.
+//
+// A Generator produces documents containing values of every BSON type. Each document is returned
+// both as a *bson.Document, built with the bson package's constructors, and as bytes, built
+// independently with the builder package, so that the two can be compared against each other and
+// against the other representations of a document in this library. Generators are
+// deterministic: two Generators created with the same seed and Options produce the same
+// documents.
+//
+// When a property fails for a generated document, Shrink and Minimize reduce the document to a
+// smaller one for which the property still fails. Check combines generation and minimization.
+//
+// The package also provides helpers for writing tests against documents: AssertEqual and
+// AssertEquivalent compare documents, and Marshal and ToJSON convert them to bytes and extended
+// JSON.
+package bsontest
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/builder"
+	"github.com/skriptble/wilson/bson/decimal"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+// DefaultKeyAlphabet is the alphabet used for keys when Options.KeyAlphabet is empty.
+const DefaultKeyAlphabet = "abcdefghijklmnopqrstuvwxyz"
+
+// stringAlphabet is the alphabet used for strings. It includes characters which must be escaped
+// in JSON and multi-byte characters.
+var stringAlphabet = []rune("abcxyzABC019 _.\"\\\n\t\x00\x1féñ☆日本𝄞")
+
+// regexOptions are the valid regular expression options, in the order they must appear.
+const regexOptions = "ilmsux"
+
+// Options configures the documents produced by a Generator. The zero value of each field
+// selects its default.
+type Options struct {
+	// MaxDepth is the maximum nesting depth of the documents. A document with no embedded
+	// documents or arrays has a depth of 1. The scope of JavaScript code with scope counts as
+	// an embedded document. The default is 3.
+	MaxDepth int
+
+	// MaxWidth is the maximum number of elements in each document or array. The default is 5.
+	MaxWidth int
+
+	// KeyAlphabet is the set of characters keys are made from. It must not contain a null
+	// byte. The default is DefaultKeyAlphabet.
+	KeyAlphabet string
+
+	// MaxKeyLength is the maximum length of a key in characters. The default is 8.
+	MaxKeyLength int
+
+	// MaxStringLength is the maximum length in characters of strings, binary data, and
+	// JavaScript code. The default is 16.
+	MaxStringLength int
+
+	// TypeWeights is the relative frequency of each type. Types which are not in the map are
+	// never generated. If nil, every type has a weight of 1.
+	TypeWeights map[bson.Type]int
+}
+
+// Types is every BSON type, in the order of their type bytes.
+var Types = []bson.Type{
+	bson.TypeDouble, bson.TypeString, bson.TypeEmbeddedDocument, bson.TypeArray, bson.TypeBinary,
+	bson.TypeUndefined, bson.TypeObjectID, bson.TypeBoolean, bson.TypeDateTime, bson.TypeNull,
+	bson.TypeRegex, bson.TypeDBPointer, bson.TypeJavaScript, bson.TypeSymbol,
+	bson.TypeCodeWithScope, bson.TypeInt32, bson.TypeTimestamp, bson.TypeInt64,
+	bson.TypeDecimal128, bson.TypeMinKey, bson.TypeMaxKey,
+}
+
+// Generator generates random documents. A Generator is not safe for concurrent use.
+type Generator struct {
+	rand *rand.Rand
+	opts Options
+	keys []rune
+}
+
+// NewGenerator returns a Generator which generates documents from the given seed. If opts is nil,
+// the defaults are used. It panics if opts.KeyAlphabet contains a null byte.
+func NewGenerator(seed int64, opts *Options) *Generator {
+	g := &Generator{rand: rand.New(rand.NewSource(seed))}
+	if opts != nil {
+		g.opts = *opts
+	}
+
+	if g.opts.MaxDepth <= 0 {
+		g.opts.MaxDepth = 3
+	}
+	if g.opts.MaxWidth <= 0 {
+		g.opts.MaxWidth = 5
+	}
+	if g.opts.KeyAlphabet == "" {
+		g.opts.KeyAlphabet = DefaultKeyAlphabet
+	}
+	if strings.IndexByte(g.opts.KeyAlphabet, 0x00) >= 0 {
+		panic("bsontest: key alphabet contains a null byte")
+	}
+	if g.opts.MaxKeyLength <= 0 {
+		g.opts.MaxKeyLength = 8
+	}
+	if g.opts.MaxStringLength <= 0 {
+		g.opts.MaxStringLength = 16
+	}
+	if g.opts.TypeWeights == nil {
+		g.opts.TypeWeights = make(map[bson.Type]int, len(Types))
+		for _, t := range Types {
+			g.opts.TypeWeights[t] = 1
+		}
+	}
+	g.keys = []rune(g.opts.KeyAlphabet)
+
+	return g
+}
+
+// Generate returns a document generated from the given seed and options, both as a
+// *bson.Document and as bytes.
+func Generate(seed int64, opts *Options) (*bson.Document, []byte) {
+	return NewGenerator(seed, opts).Document()
+}
+
+// Document returns the next document, both as a *bson.Document and as bytes. The two are
+// built independently and represent the same document.
+func (g *Generator) Document() (*bson.Document, []byte) {
+	elems, builders := g.elements(1, false)
+
+	db := builder.NewDocumentBuilder().Append(builders...)
+	return bson.NewDocument(elems...), writeDocument(db)
+}
+
+// elements generates the elements of a document or array nested at the given depth. The keys of
+// the elements of an array are their indexes.
+func (g *Generator) elements(depth int, array bool) ([]*bson.Element, []builder.Elementer) {
+	n := g.rand.Intn(g.opts.MaxWidth + 1)
+	elems := make([]*bson.Element, 0, n)
+	builders := make([]builder.Elementer, 0, n)
+	seen := make(map[string]struct{}, n)
+
+	for i := 0; i < n; i++ {
+		t, ok := g.chooseType(depth)
+		if !ok {
+			break
+		}
+
+		key := strconv.Itoa(i)
+		if !array {
+			key, ok = g.key(seen)
+			if !ok {
+				break
+			}
+		}
+
+		elem, b := g.element(key, t, depth)
+		elems = append(elems, elem)
+		builders = append(builders, b)
+	}
+
+	return elems, builders
+}
+
+// chooseType chooses the type of an element in a document nested at the given depth. It returns
+// false if no type can be chosen.
+func (g *Generator) chooseType(depth int) (bson.Type, bool) {
+	total := 0
+	for _, t := range Types {
+		total += g.weight(t, depth)
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	n := g.rand.Intn(total)
+	for _, t := range Types {
+		n -= g.weight(t, depth)
+		if n < 0 {
+			return t, true
+		}
+	}
+
+	panic("unreachable")
+}
+
+// weight returns the weight of t for an element in a document nested at the given depth.
+// Types which contain documents cannot be chosen at the maximum depth.
+func (g *Generator) weight(t bson.Type, depth int) int {
+	switch t {
+	case bson.TypeEmbeddedDocument, bson.TypeArray, bson.TypeCodeWithScope:
+		if depth >= g.opts.MaxDepth {
+			return 0
+		}
+	}
+
+	w := g.opts.TypeWeights[t]
+	if w < 0 {
+		return 0
+	}
+
+	return w
+}
+
+// key generates a key which is not in seen and adds it to seen. It returns false if it cannot
+// find a new key.
+func (g *Generator) key(seen map[string]struct{}) (string, bool) {
+	for attempt := 0; attempt < 10; attempt++ {
+		n := 1 + g.rand.Intn(g.opts.MaxKeyLength)
+		key := make([]rune, n)
+		for i := range key {
+			key[i] = g.keys[g.rand.Intn(len(g.keys))]
+		}
+
+		if _, ok := seen[string(key)]; !ok {
+			seen[string(key)] = struct{}{}
+			return string(key), true
+		}
+	}
+
+	return "", false
+}
+
+// element generates an element of type t nested at the given depth.
+func (g *Generator) element(key string, t bson.Type, depth int) (*bson.Element, builder.Elementer) {
+	switch t {
+	case bson.TypeDouble:
+		f := g.double()
+		return bson.C.Double(key, f), builder.C.Double(key, f)
+	case bson.TypeString:
+		s := g.string(true)
+		return bson.C.String(key, s), builder.C.String(key, s)
+	case bson.TypeEmbeddedDocument:
+		elems, builders := g.elements(depth+1, false)
+		return bson.C.SubDocumentFromElements(key, elems...),
+			builder.C.SubDocumentWithElements(key, builders...)
+	case bson.TypeArray:
+		elems, builders := g.elements(depth+1, true)
+		values := make([]*bson.Value, 0, len(elems))
+		for _, elem := range elems {
+			values = append(values, elem.Value())
+		}
+		arrayElems := make([]builder.ArrayElementer, 0, len(builders))
+		for _, b := range builders {
+			b := b
+			arrayElems = append(arrayElems, builder.ArrayElementFunc(func(uint) builder.Elementer { return b }))
+		}
+		return bson.C.ArrayFromElements(key, values...), builder.C.ArrayWithElements(key, arrayElems...)
+	case bson.TypeBinary:
+		subtypes := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x80}
+		subtype := subtypes[g.rand.Intn(len(subtypes))]
+		b := g.bytes(g.rand.Intn(g.opts.MaxStringLength + 1))
+		return bson.C.BinaryWithSubtype(key, b, subtype), builder.C.BinaryWithSubtype(key, b, subtype)
+	case bson.TypeUndefined:
+		return bson.C.Undefined(key), builder.C.Undefined(key)
+	case bson.TypeObjectID:
+		oid := g.objectID()
+		return bson.C.ObjectID(key, oid), builder.C.ObjectID(key, oid)
+	case bson.TypeBoolean:
+		b := g.rand.Intn(2) == 1
+		return bson.C.Boolean(key, b), builder.C.Boolean(key, b)
+	case bson.TypeDateTime:
+		dt := g.int64()
+		return bson.C.DateTime(key, dt), builder.C.DateTime(key, dt)
+	case bson.TypeNull:
+		return bson.C.Null(key), builder.C.Null(key)
+	case bson.TypeRegex:
+		pattern := g.string(false)
+		var options []byte
+		for i := 0; i < len(regexOptions); i++ {
+			if g.rand.Intn(4) == 0 {
+				options = append(options, regexOptions[i])
+			}
+		}
+		return bson.C.Regex(key, pattern, string(options)), builder.C.Regex(key, pattern, string(options))
+	case bson.TypeDBPointer:
+		ns, oid := g.string(false), g.objectID()
+		return bson.C.DBPointer(key, ns, oid), builder.C.DBPointer(key, ns, oid)
+	case bson.TypeJavaScript:
+		code := g.string(true)
+		return bson.C.JavaScript(key, code), builder.C.JavaScriptCode(key, code)
+	case bson.TypeSymbol:
+		s := g.string(true)
+		return bson.C.Symbol(key, s), builder.C.Symbol(key, s)
+	case bson.TypeCodeWithScope:
+		code := g.string(true)
+		elems, builders := g.elements(depth+1, false)
+		scope := writeDocument(builder.NewDocumentBuilder().Append(builders...))
+		return bson.C.CodeWithScope(key, code, bson.NewDocument(elems...)),
+			builder.C.CodeWithScope(key, code, scope)
+	case bson.TypeInt32:
+		i := int32(g.int64())
+		return bson.C.Int32(key, i), builder.C.Int32(key, i)
+	case bson.TypeTimestamp:
+		t, i := g.rand.Uint32(), g.rand.Uint32()
+		return bson.C.Timestamp(key, t, i), builder.C.Timestamp(key, t, i)
+	case bson.TypeInt64:
+		i := g.int64()
+		return bson.C.Int64(key, i), builder.C.Int64(key, i)
+	case bson.TypeDecimal128:
+		d := g.decimal128()
+		return bson.C.Decimal128(key, d), builder.C.Decimal(key, d)
+	case bson.TypeMinKey:
+		return bson.C.MinKey(key), builder.C.MinKey(key)
+	case bson.TypeMaxKey:
+		return bson.C.MaxKey(key), builder.C.MaxKey(key)
+	}
+
+	panic("bsontest: unknown type " + t.String())
+}
+
+// double generates a float64, favoring values which are often handled specially.
+func (g *Generator) double() float64 {
+	special := []float64{0, math.Copysign(0, -1), 1, -1, 0.5, math.MaxFloat64,
+		math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1), math.NaN()}
+	if g.rand.Intn(4) == 0 {
+		return special[g.rand.Intn(len(special))]
+	}
+
+	return g.rand.NormFloat64() * math.Pow(10, float64(g.rand.Intn(40)-20))
+}
+
+// int64 generates an int64, favoring small values.
+func (g *Generator) int64() int64 {
+	switch g.rand.Intn(4) {
+	case 0:
+		return int64(g.rand.Intn(201) - 100)
+	case 1:
+		return int64(int32(g.rand.Uint32()))
+	default:
+		return int64(g.rand.Uint64())
+	}
+}
+
+// decimal128 generates a Decimal128 with a canonical significand.
+func (g *Generator) decimal128() decimal.Decimal128 {
+	switch g.rand.Intn(8) {
+	case 0:
+		return decimal.NewDecimal128(0x7C00000000000000, 0) // NaN
+	case 1:
+		return decimal.NewDecimal128(0x7800000000000000, 0) // Infinity
+	case 2:
+		return decimal.NewDecimal128(0xF800000000000000, 0) // -Infinity
+	}
+
+	// The significand is less than 10^34, so the high 49 bits are less than 0x1ED09BEAD87C0.
+	var h uint64
+	if g.rand.Intn(2) == 0 {
+		h = g.rand.Uint64() % 0x1ED09BEAD87C0
+	}
+	l := g.rand.Uint64()
+	if g.rand.Intn(2) == 0 {
+		l %= 1000000
+	}
+
+	exp := uint64(6176 + g.rand.Intn(61) - 30)
+	h |= exp << 49
+	if g.rand.Intn(2) == 0 {
+		h |= 1 << 63
+	}
+
+	return decimal.NewDecimal128(h, l)
+}
+
+// string generates a valid UTF-8 string. If null is false, it does not contain a null byte.
+func (g *Generator) string(null bool) string {
+	n := g.rand.Intn(g.opts.MaxStringLength + 1)
+	s := make([]rune, 0, n)
+	for len(s) < n {
+		r := stringAlphabet[g.rand.Intn(len(stringAlphabet))]
+		if r == 0 && !null {
+			continue
+		}
+		s = append(s, r)
+	}
+
+	return string(s)
+}
+
+// bytes generates n random bytes.
+func (g *Generator) bytes(n int) []byte {
+	b := make([]byte, n)
+	g.rand.Read(b)
+	return b
+}
+
+// objectID generates a random ObjectID.
+func (g *Generator) objectID() objectid.ObjectID {
+	var oid objectid.ObjectID
+	g.rand.Read(oid[:])
+	return oid
+}
+
+// writeDocument writes the document built by db to bytes.
+func writeDocument(db *builder.DocumentBuilder) []byte {
+	b := make([]byte, db.RequiredBytes())
+	_, err := db.WriteDocument(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
@@ -0,0 +1,369 @@
+package bsontest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/extjson"
+	"github.com/skriptble/wilson/bson/parser"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Run("deterministic", func(t *testing.T) {
+		_, a := Generate(42, nil)
+		_, b := Generate(42, nil)
+		if !bytes.Equal(a, b) {
+			t.Errorf("Documents generated from the same seed differ. got %v; want %v", b, a)
+		}
+	})
+	t.Run("every type", func(t *testing.T) {
+		seen := make(map[bson.Type]bool)
+		g := NewGenerator(1, nil)
+		for i := 0; i < 200; i++ {
+			_, b := g.Document()
+			collectTypes(t, bson.Reader(b), seen)
+		}
+		for _, typ := range Types {
+			if !seen[typ] {
+				t.Errorf("Type %s was never generated", typ)
+			}
+		}
+	})
+	t.Run("options", func(t *testing.T) {
+		opts := &Options{
+			MaxDepth:     2,
+			MaxWidth:     3,
+			KeyAlphabet:  "xy",
+			MaxKeyLength: 2,
+			TypeWeights:  map[bson.Type]int{bson.TypeInt32: 1, bson.TypeArray: 1},
+		}
+		Check(t, 100, opts, func(doc *bson.Document, b []byte) error {
+			return checkOptions(bson.Reader(b), opts, 1)
+		})
+	})
+}
+
+func TestProperties(t *testing.T) {
+	testCases := []struct {
+		name string
+		f    func(doc *bson.Document, b []byte) error
+	}{
+		{"Document", func(doc *bson.Document, b []byte) error {
+			got, err := doc.MarshalBSON()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, b) {
+				return fmt.Errorf("marshaled document does not match. got %x; want %x", got, b)
+			}
+			return nil
+		}},
+		{"Reader", func(doc *bson.Document, b []byte) error {
+			size, err := bson.Reader(b).Validate()
+			if err != nil {
+				return err
+			}
+			if int(size) != len(b) {
+				return fmt.Errorf("unexpected size. got %d; want %d", size, len(b))
+			}
+			return nil
+		}},
+		{"ReadDocument", func(doc *bson.Document, b []byte) error {
+			read, err := bson.ReadDocument(b)
+			if err != nil {
+				return err
+			}
+			got, err := read.MarshalBSON()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, b) {
+				return fmt.Errorf("round trip does not match. got %x; want %x", got, b)
+			}
+			return nil
+		}},
+		{"extjson", func(doc *bson.Document, b []byte) error {
+			s, err := extjson.BsonToExtJSON(true, b)
+			if err != nil {
+				return err
+			}
+			db, err := extjson.ParseObjectToBuilder(s)
+			if err != nil {
+				return err
+			}
+			got := make([]byte, db.RequiredBytes())
+			_, err = db.WriteDocument(got)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, b) {
+				return fmt.Errorf("round trip of %s does not match. got %x; want %x", s, got, b)
+			}
+			return nil
+		}},
+		{"Canonicalize", func(doc *bson.Document, b []byte) error {
+			c, err := bson.Canonicalize(b)
+			if err != nil {
+				return err
+			}
+			cc, err := bson.Canonicalize(c)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(c, cc) {
+				return fmt.Errorf("canonical form is not canonical. got %x; want %x", cc, c)
+			}
+
+			h := sha256.New()
+			err = bson.Hash(b, h)
+			if err != nil {
+				return err
+			}
+			if sum := sha256.Sum256(c); !bytes.Equal(h.Sum(nil), sum[:]) {
+				return fmt.Errorf("hash is not the hash of the canonical form. got %x; want %x", h.Sum(nil), sum)
+			}
+			return nil
+		}},
+		{"parser", func(doc *bson.Document, b []byte) error {
+			p, err := parser.NewBSONParser(bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+			ast, err := p.ParseDocument()
+			if err != nil {
+				return err
+			}
+			if int(ast.Length) != len(b) || len(ast.EList) != doc.Len() {
+				return fmt.Errorf("unexpected document. got %d bytes and %d elements; want %d and %d",
+					ast.Length, len(ast.EList), len(b), doc.Len())
+			}
+			return nil
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			Check(t, 500, nil, tc.f)
+		})
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	// Find a document containing a non-empty string nested in an array.
+	var doc *bson.Document
+	for seed := int64(1); doc == nil; seed++ {
+		d, b := Generate(seed, &Options{MaxDepth: 4})
+		if hasNestedString(bson.Reader(b), false) {
+			doc = d
+		}
+	}
+
+	fails := func(d *bson.Document) bool {
+		b, err := d.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error marshaling document: %v", err)
+		}
+		return hasNestedString(bson.Reader(b), false)
+	}
+	got, err := Minimize(doc, fails).MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling document: %v", err)
+	}
+
+	// The smallest such document is an array with a single string of one character.
+	r := bson.Reader(got)
+	keys, err := r.Keys(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Document was not minimized. got %s", format(got))
+	}
+	elem, err := r.Lookup(keys[1].Prefix[0], keys[1].Name)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := elem.Value().StringValue(); len([]rune(s)) != 1 {
+		t.Errorf("String was not minimized. got %q; want a single character", s)
+	}
+}
+
+// collectTypes adds the types of the values in r, including embedded documents, to seen.
+func collectTypes(t *testing.T, r bson.Reader, seen map[bson.Type]bool) {
+	itr, err := r.Iterator()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for itr.Next() {
+		v := itr.Element().Value()
+		seen[v.Type()] = true
+		switch v.Type() {
+		case bson.TypeEmbeddedDocument:
+			collectTypes(t, v.ReaderDocument(), seen)
+		case bson.TypeArray:
+			collectTypes(t, v.ReaderArray(), seen)
+		case bson.TypeCodeWithScope:
+			_, scope := v.ReaderJavaScriptWithScope()
+			collectTypes(t, scope, seen)
+		}
+	}
+}
+
+// checkOptions checks that the document r, nested at the given depth, was generated with opts.
+func checkOptions(r bson.Reader, opts *Options, depth int) error {
+	if depth > opts.MaxDepth {
+		return errors.New("document is too deep")
+	}
+
+	keys, err := r.Keys(false)
+	if err != nil {
+		return err
+	}
+	if len(keys) > opts.MaxWidth {
+		return fmt.Errorf("document has %d elements", len(keys))
+	}
+
+	return iterate(r, func(elem *bson.Element) error {
+		if len(elem.Key()) > opts.MaxKeyLength || len(bytes.Trim([]byte(elem.Key()), "xy0123456789")) > 0 {
+			return fmt.Errorf("unexpected key %q", elem.Key())
+		}
+
+		switch v := elem.Value(); v.Type() {
+		case bson.TypeInt32:
+			return nil
+		case bson.TypeArray:
+			return checkOptions(v.ReaderArray(), opts, depth+1)
+		default:
+			return fmt.Errorf("unexpected type %s", v.Type())
+		}
+	})
+}
+
+// hasNestedString reports whether r contains a non-empty string in an array.
+func hasNestedString(r bson.Reader, inArray bool) bool {
+	found := false
+	_ = iterate(r, func(elem *bson.Element) error {
+		switch v := elem.Value(); v.Type() {
+		case bson.TypeString:
+			found = found || inArray && v.StringValue() != ""
+		case bson.TypeEmbeddedDocument:
+			found = found || hasNestedString(v.ReaderDocument(), false)
+		case bson.TypeArray:
+			found = found || hasNestedString(v.ReaderArray(), true)
+		}
+		return nil
+	})
+
+	return found
+}
+
+// iterate calls f with each element of r until f returns an error.
+func iterate(r bson.Reader, f func(elem *bson.Element) error) error {
+	itr, err := r.Iterator()
+	if err != nil {
+		return err
+	}
+	for itr.Next() {
+		err = f(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// recorder is a testing.TB that records the errors reported to it.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertEqual(t *testing.T) {
+	got := bson.NewDocument(
+		bson.C.Int32("a", 1),
+		bson.C.SubDocumentFromElements("b", bson.C.String("c", "x")),
+		bson.C.Boolean("d", true),
+	)
+	want := bson.NewDocument(
+		bson.C.Int64("a", 1),
+		bson.C.SubDocumentFromElements("b", bson.C.String("c", "y")),
+		bson.C.Null("e"),
+	)
+
+	testCases := []struct {
+		name   string
+		assert func(t testing.TB)
+		want   []string
+	}{
+		{
+			"equal",
+			func(t testing.TB) { AssertEqual(t, got, got) },
+			nil,
+		},
+		{
+			"not equal",
+			func(t testing.TB) { AssertEqual(t, got, want) },
+			[]string{"Documents are not equal:\n" +
+				"\ta: types differ; got {\"$numberInt\":\"1\"}; want {\"$numberLong\":\"1\"}\n" +
+				"\tb.c: values differ; got \"x\"; want \"y\"\n" +
+				"\td: unexpected true\n" +
+				"\te: missing; want null"},
+		},
+		{
+			"equivalent",
+			func(t testing.TB) {
+				AssertEquivalent(t, got, bson.NewDocument(
+					bson.C.Boolean("d", true),
+					bson.C.SubDocumentFromElements("b", bson.C.String("c", "x")),
+					bson.C.Double("a", 1),
+				), &bson.EquivalenceOptions{IgnoreKeyOrder: true})
+			},
+			nil,
+		},
+		{
+			"not equivalent",
+			func(t testing.TB) { AssertEquivalent(t, got, want, nil) },
+			[]string{"Documents are not equivalent:\n" +
+				"\tb.c: values differ; got \"x\"; want \"y\"\n" +
+				"\td: unexpected true\n" +
+				"\te: missing; want null"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &recorder{TB: t}
+			tc.assert(r)
+			if fmt.Sprint(r.errors) != fmt.Sprint(tc.want) {
+				t.Errorf("Unexpected errors. got %q; want %q", r.errors, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	doc := bson.NewDocument(bson.C.Int32("a", 1), bson.C.String("b", "x"))
+	want, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	b := Marshal(t, doc)
+	if !bytes.Equal(b, want) {
+		t.Errorf("Unexpected result. got %v; want %v", b, want)
+	}
+	if got := ToJSON(t, b); got != `{"a":1,"b":"x"}` {
+		t.Errorf("Unexpected result. got %s; want %s", got, `{"a":1,"b":"x"}`)
+	}
+}
@@ -0,0 +1,46 @@
+package bsontest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/extjson"
+)
+
+// Check generates n documents with the given options, using the seeds 1 through n, and calls f
+// with each document and its bytes. If f returns an error, the document is minimized to the
+// smallest document for which f still returns an error, and the test fails with the seed and
+// the minimized document.
+func Check(t testing.TB, n int, opts *Options, f func(doc *bson.Document, b []byte) error) {
+	t.Helper()
+
+	fails := func(doc *bson.Document) bool {
+		b, err := doc.MarshalBSON()
+		return err == nil && f(doc, b) != nil
+	}
+
+	for seed := int64(1); seed <= int64(n); seed++ {
+		doc, b := Generate(seed, opts)
+		err := f(doc, b)
+		if err == nil {
+			continue
+		}
+
+		doc = Minimize(doc, fails)
+		b, _ = doc.MarshalBSON()
+		t.Fatalf("Property failed for seed %d: %v\nminimized document: %s\nerror: %v",
+			seed, err, format(b), f(doc, b))
+	}
+}
+
+// format returns the canonical extended JSON of the document b, or its bytes if it cannot be
+// converted.
+func format(b []byte) string {
+	s, err := extjson.BsonToExtJSON(true, b)
+	if err != nil {
+		return fmt.Sprintf("%x", b)
+	}
+
+	return s
+}
@@ -0,0 +1,33 @@
+package bsontest
+
+import (
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/extjson"
+)
+
+// Marshal returns the bytes of d, failing the test if d cannot be marshaled.
+func Marshal(t testing.TB, d *bson.Document) bson.Reader {
+	t.Helper()
+
+	b, err := d.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return b
+}
+
+// ToJSON returns the relaxed extended JSON of the document b, failing the test if b cannot be
+// converted.
+func ToJSON(t testing.TB, b []byte) string {
+	t.Helper()
+
+	s, err := extjson.BsonToExtJSON(false, b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return s
+}
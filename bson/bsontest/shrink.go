@@ -0,0 +1,152 @@
+package bsontest
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+// Shrink returns the documents which are one step simpler than doc. Each has either one element
+// of doc removed, or one value of doc, including the values of embedded documents and arrays,
+// made simpler. Strings, binary data, and JavaScript code are shortened and numbers are replaced
+// with zero. The returned documents share elements with doc, which must not be modified.
+func Shrink(doc *bson.Document) []*bson.Document {
+	elems := make([]*bson.Element, doc.Len())
+	for i := range elems {
+		elem, err := doc.ElementAt(uint(i))
+		if err != nil {
+			panic(err)
+		}
+		elems[i] = elem
+	}
+
+	var shrinks []*bson.Document
+	for i := range elems {
+		shrinks = append(shrinks, bson.NewDocument(without(elems, i)...))
+	}
+	for i, elem := range elems {
+		for _, shrink := range shrinkValue(elem.Value()) {
+			replaced := append([]*bson.Element(nil), elems...)
+			replaced[i] = shrink(elem.Key())
+			shrinks = append(shrinks, bson.NewDocument(replaced...))
+		}
+	}
+
+	return shrinks
+}
+
+// Minimize repeatedly replaces doc with the first of its shrinks for which fails returns true,
+// and returns the document once none of its shrinks fail. fails should return true for doc.
+func Minimize(doc *bson.Document, fails func(*bson.Document) bool) *bson.Document {
+Outer:
+	for {
+		for _, shrink := range Shrink(doc) {
+			if fails(shrink) {
+				doc = shrink
+				continue Outer
+			}
+		}
+
+		return doc
+	}
+}
+
+// shrinkValue returns constructors for the values which are one step simpler than v.
+func shrinkValue(v *bson.Value) []func(key string) *bson.Element {
+	var shrinks []func(key string) *bson.Element
+
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		for _, doc := range Shrink(v.MutableDocument()) {
+			doc := doc
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.SubDocument(key, doc) })
+		}
+	case bson.TypeArray:
+		for _, arr := range shrinkArray(v.MutableArray()) {
+			arr := arr
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.Array(key, arr) })
+		}
+	case bson.TypeCodeWithScope:
+		code, scope := v.MutableJavaScriptWithScope()
+		if s, ok := shorten(code); ok {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.CodeWithScope(key, s, scope) })
+		}
+		for _, doc := range Shrink(scope) {
+			doc := doc
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.CodeWithScope(key, code, doc) })
+		}
+	case bson.TypeString:
+		if s, ok := shorten(v.StringValue()); ok {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.String(key, s) })
+		}
+	case bson.TypeJavaScript:
+		if s, ok := shorten(v.JavaScript()); ok {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.JavaScript(key, s) })
+		}
+	case bson.TypeSymbol:
+		if s, ok := shorten(v.Symbol()); ok {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.Symbol(key, s) })
+		}
+	case bson.TypeBinary:
+		subtype, data := v.Binary()
+		if len(data) > 0 {
+			shrinks = append(shrinks, func(key string) *bson.Element {
+				return bson.C.BinaryWithSubtype(key, data[:len(data)/2], subtype)
+			})
+		}
+	case bson.TypeDouble:
+		if v.Double() != 0 {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.Double(key, 0) })
+		}
+	case bson.TypeInt32:
+		if v.Int32() != 0 {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.Int32(key, 0) })
+		}
+	case bson.TypeInt64:
+		if v.Int64() != 0 {
+			shrinks = append(shrinks, func(key string) *bson.Element { return bson.C.Int64(key, 0) })
+		}
+	}
+
+	return shrinks
+}
+
+// shrinkArray returns the arrays which are one step simpler than arr.
+func shrinkArray(arr *bson.Array) []*bson.Array {
+	values := make([]*bson.Value, arr.Len())
+	for i := range values {
+		v, err := arr.Lookup(uint(i))
+		if err != nil {
+			panic(err)
+		}
+		values[i] = v
+	}
+
+	var shrinks []*bson.Array
+	for i := range values {
+		removed := append(append([]*bson.Value(nil), values[:i]...), values[i+1:]...)
+		shrinks = append(shrinks, bson.NewArray(removed...))
+	}
+	for i, v := range values {
+		for _, shrink := range shrinkValue(v) {
+			replaced := append([]*bson.Value(nil), values...)
+			replaced[i] = shrink("").Value()
+			shrinks = append(shrinks, bson.NewArray(replaced...))
+		}
+	}
+
+	return shrinks
+}
+
+// without returns a copy of elems without the element at index i.
+func without(elems []*bson.Element, i int) []*bson.Element {
+	return append(append([]*bson.Element(nil), elems[:i]...), elems[i+1:]...)
+}
+
+// shorten returns the first half of the characters of s. It returns false if s is empty.
+func shorten(s string) (string, bool) {
+	r := []rune(s)
+	if len(r) == 0 {
+		return "", false
+	}
+
+	return string(r[:len(r)/2]), true
+}
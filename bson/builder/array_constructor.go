@@ -31,14 +31,16 @@ type ArrayBuilder struct {
 	current uint
 }
 
-// Append adds the given elements to the BSON array.
+// Append adds the given elements to the BSON array. Nil elements are ignored and do not consume
+// an index.
 func (ab *ArrayBuilder) Append(elems ...ArrayElementer) *ArrayBuilder {
 	ab.init()
 	for _, arrelem := range elems {
-		sizer, f := arrelem.ArrayElement(ab.current).Element()
+		if arrelem == nil {
+			continue
+		}
+		ab.appendElement(arrelem.ArrayElement(ab.current))
 		ab.current++
-		ab.funcs = append(ab.funcs, f)
-		ab.sizers = append(ab.sizers, sizer)
 	}
 	return ab
 }
@@ -63,7 +65,9 @@ func (ArrayConstructor) SubDocumentWithElements(elems ...Elementer) ArrayElement
 func (ArrayConstructor) Array(arr *ArrayBuilder) ArrayElementFunc {
 	return func(pos uint) Elementer {
 		key := strconv.FormatUint(uint64(pos), 10)
-		return C.Array(key, arr)
+		// The element is returned as an arrayElement rather than an ElementFunc so that the
+		// DocumentBuilder of the enclosing array can stream it.
+		return &arrayElement{key: key, array: arr}
 	}
 }
 
@@ -71,7 +75,7 @@ func (ArrayConstructor) Array(arr *ArrayBuilder) ArrayElementFunc {
 func (ArrayConstructor) ArrayWithElements(elems ...ArrayElementer) ArrayElementFunc {
 	return func(pos uint) Elementer {
 		key := strconv.FormatUint(uint64(pos), 10)
-		return C.ArrayWithElements(key, elems...)
+		return &arrayElement{key: key, array: newArrayBuilder(elems...)}
 	}
 }
 
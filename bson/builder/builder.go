@@ -2,6 +2,7 @@ package builder
 
 import (
 	"errors"
+	"io"
 
 	"github.com/skriptble/wilson/bson/decimal"
 	"github.com/skriptble/wilson/bson/elements"
@@ -52,6 +53,7 @@ type DocumentBuilder struct {
 	Key         string
 	funcs       []ElementWriter
 	sizers      []ElementSizer
+	streamers   []ElementStreamWriter
 	required    uint // number of required bytes. Should start at 4
 	initialized bool
 }
@@ -70,23 +72,38 @@ func (db *DocumentBuilder) init() {
 	}
 	db.funcs = make([]ElementWriter, 0, 5)
 	db.sizers = make([]ElementSizer, 0, 5)
+	db.streamers = make([]ElementStreamWriter, 0, 5)
 	sizer, f := db.documentHeader()
 	db.funcs = append(db.funcs, f)
 	db.sizers = append(db.sizers, sizer)
+	db.streamers = append(db.streamers, nil)
 	db.initialized = true
 }
 
-// Append adds the given elements to the BSON document.
+// Append adds the given elements to the BSON document. Nil elements are ignored.
 func (db *DocumentBuilder) Append(elems ...Elementer) *DocumentBuilder {
 	db.init()
 	for _, elem := range elems {
-		sizer, f := elem.Element()
-		db.funcs = append(db.funcs, f)
-		db.sizers = append(db.sizers, sizer)
+		if elem == nil {
+			continue
+		}
+		db.appendElement(elem)
 	}
 	return db
 }
 
+func (db *DocumentBuilder) appendElement(elem Elementer) {
+	sizer, f := elem.Element()
+	db.funcs = append(db.funcs, f)
+	db.sizers = append(db.sizers, sizer)
+
+	var streamer ElementStreamWriter
+	if se, ok := elem.(StreamElementer); ok {
+		_, streamer = se.StreamElement()
+	}
+	db.streamers = append(db.streamers, streamer)
+}
+
 func (db *DocumentBuilder) documentHeader() (ElementSizer, ElementWriter) {
 	return func() uint { return 5 },
 		func(start uint, writer []byte) (n int, err error) {
@@ -193,33 +210,71 @@ func (c Constructor) SubDocumentWithElements(key string, elems ...Elementer) Ele
 	return (&DocumentBuilder{Key: key}).Append(elems...)
 }
 
-// Array creates an array element with the given key and value.
+// Array creates an array element with the given key and value. When the document is written
+// with WriteTo, an array larger than the write buffer is written through a temporary slice of its
+// size; arrays nested with ArrayConstructor.Array are streamed.
 func (c Constructor) Array(key string, array *ArrayBuilder) ElementFunc {
-	return func() (ElementSizer, ElementWriter) {
-		// A subdocument will always take (1 + key length + 1) + len(subdoc) bytes
-		return func() uint {
-				return 2 + uint(len(key)) + array.RequiredBytes()
-			},
-			func(start uint, writer []byte) (int, error) {
-				arrayBytes := make([]byte, array.RequiredBytes())
-				_, err := array.WriteDocument(arrayBytes)
-				if err != nil {
-					return 0, err
-				}
+	return (&arrayElement{key: key, array: array}).Element
+}
+
+type arrayElement struct {
+	key   string
+	array *ArrayBuilder
+}
 
-				return elements.Array.Element(start, writer, key, arrayBytes)
+// size returns the size of the array element. An array will always take
+// (1 + key length + 1) + len(array) bytes.
+func (ae *arrayElement) size() uint {
+	return 2 + uint(len(ae.key)) + ae.array.RequiredBytes()
+}
+
+// Element implements the Elementer interface.
+func (ae *arrayElement) Element() (ElementSizer, ElementWriter) {
+	return ae.size, func(start uint, writer []byte) (int, error) {
+		if uint(len(writer)) < start+ae.size() {
+			return 0, ErrTooShort
+		}
+
+		n, err := writeElementHeader(start, writer, '\x04', ae.key)
+		if err != nil {
+			return n, err
+		}
+
+		nn, err := ae.array.writeDocument(start+uint(n), writer, false)
+		return n + nn, err
+	}
+}
+
+// StreamElement implements the StreamElementer interface.
+func (ae *arrayElement) StreamElement() (ElementSizer, ElementStreamWriter) {
+	return ae.size, func(w io.Writer) (int64, error) {
+		return streamTo(w, func(sw *streamWriter) (int64, error) {
+			n, err := sw.writeElement(uint(len(ae.key))+2, func(start uint, writer []byte) (int, error) {
+				return writeElementHeader(start, writer, '\x04', ae.key)
+			})
+			if err != nil {
+				return n, err
 			}
+
+			nn, err := ae.array.streamDocument(sw, false)
+			return n + nn, err
+		})
 	}
 }
 
 // ArrayWithElements creates an element with the given key. The elements passed as
 // arguments will be used to create a new array as the value.
 func (c Constructor) ArrayWithElements(key string, elems ...ArrayElementer) ElementFunc {
+	return C.Array(key, newArrayBuilder(elems...))
+}
+
+// newArrayBuilder returns an ArrayBuilder holding elems.
+func newArrayBuilder(elems ...ArrayElementer) *ArrayBuilder {
 	var b ArrayBuilder
 	b.init()
 	b.Append(elems...)
 
-	return C.Array(key, &b)
+	return &b
 }
 
 // Double creates a double element with the given key and value.
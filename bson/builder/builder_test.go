@@ -2,6 +2,7 @@ package builder
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"runtime"
 	"testing"
@@ -60,14 +61,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Double(tc.key, tc.f), tc.repr)
 					})
 				})
 			}
@@ -117,14 +112,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).String(tc.key, tc.s), tc.repr)
 					})
 				})
 			}
@@ -190,14 +179,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).SubDocument(tc.key, tc.subdoc), tc.repr)
 					})
 				})
 			}
@@ -259,14 +242,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).SubDocumentWithElements(tc.key, tc.subdoc...), tc.repr)
 					})
 				})
 			}
@@ -332,14 +309,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Array(tc.key, tc.array), tc.repr)
 					})
 				})
 			}
@@ -401,14 +372,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).ArrayWithElements(tc.key, tc.array...), tc.repr)
 					})
 				})
 			}
@@ -460,14 +425,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Binary(tc.key, tc.b), tc.repr)
 					})
 				})
 			}
@@ -522,14 +481,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).BinaryWithSubtype(tc.key, tc.b, tc.btype), tc.repr)
 					})
 				})
 			}
@@ -574,14 +527,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Undefined(tc.key), tc.repr)
 					})
 				})
 			}
@@ -629,14 +576,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).ObjectID(tc.key, tc.oid), tc.repr)
 					})
 				})
 			}
@@ -684,14 +625,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Boolean(tc.key, tc.b), tc.repr)
 					})
 				})
 			}
@@ -739,14 +674,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).DateTime(tc.key, tc.t), tc.repr)
 					})
 				})
 			}
@@ -791,14 +720,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Null(tc.key), tc.repr)
 					})
 				})
 			}
@@ -849,14 +772,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Regex(tc.key, tc.pattern, tc.options), tc.repr)
 					})
 				})
 			}
@@ -909,14 +826,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).DBPointer(tc.key, tc.ns, tc.oid), tc.repr)
 					})
 				})
 			}
@@ -966,14 +877,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).JavaScriptCode(tc.key, tc.code), tc.repr)
 					})
 				})
 			}
@@ -1023,14 +928,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Symbol(tc.key, tc.s), tc.repr)
 					})
 				})
 			}
@@ -1102,14 +1001,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).CodeWithScope(tc.key, tc.code, tc.scope), tc.repr)
 					})
 				})
 			}
@@ -1157,14 +1050,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Int32(tc.key, tc.i), tc.repr)
 					})
 				})
 			}
@@ -1213,14 +1100,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Timestamp(tc.key, tc.t, tc.i), tc.repr)
 					})
 				})
 			}
@@ -1268,14 +1149,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Int64(tc.key, tc.i), tc.repr)
 					})
 				})
 			}
@@ -1326,14 +1201,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).Decimal(tc.key, tc.d), tc.repr)
 					})
 				})
 			}
@@ -1378,14 +1247,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).MinKey(tc.key), tc.repr)
 					})
 				})
 			}
@@ -1430,14 +1293,8 @@ func TestDocumentBuilder(t *testing.T) {
 							t.Errorf("Written bytes do not match. got %#v; want %#v", b, tc.repr)
 						}
 					})
-					t.Run("io.WriterAt", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.WriteSeeker", func(t *testing.T) {
-						t.Skip("not implemented")
-					})
-					t.Run("io.Writer", func(t *testing.T) {
-						t.Skip("not implemented")
+					t.Run("WriteTo", func(t *testing.T) {
+						testWriteTo(t, (Constructor{}).MaxKey(tc.key), tc.repr)
 					})
 				})
 			}
@@ -1500,3 +1357,28 @@ func ExampleDocumentBuilder() {
 
 	// Output: [177 0 0 0 3 100 114 105 118 101 114 0 52 0 0 0 2 110 97 109 101 0 16 0 0 0 109 111 110 103 111 45 103 111 45 100 114 105 118 101 114 0 2 118 101 114 115 105 111 110 0 8 0 0 0 49 50 51 52 53 54 55 0 0 3 111 115 0 46 0 0 0 2 116 121 112 101 0 7 0 0 0 100 97 114 119 105 110 0 2 97 114 99 104 105 116 101 99 116 117 114 101 0 6 0 0 0 97 109 100 54 52 0 0 2 112 108 97 116 102 111 114 109 0 8 0 0 0 103 111 49 46 57 46 50 0 3 97 112 112 108 105 99 97 116 105 111 110 0 27 0 0 0 2 110 97 109 101 0 12 0 0 0 104 101 108 108 111 45 119 111 114 108 100 0 0 0]
 }
+
+// testWriteTo checks that WriteTo streams a document holding only elem, whose bytes are repr, for
+// several buffer sizes.
+func testWriteTo(t *testing.T, elem Elementer, repr []byte) {
+	t.Helper()
+
+	want := make([]byte, 4, len(repr)+5)
+	want = append(want, repr...)
+	want = append(want, 0x00)
+	binary.LittleEndian.PutUint32(want, uint32(len(want)))
+
+	for _, size := range []int{0, 8, DefaultStreamBufferSize} {
+		var buf bytes.Buffer
+		n, err := NewDocumentBuilder().Append(elem).WriteToWithBuffer(&buf, make([]byte, size))
+		if err != nil {
+			t.Errorf("Unexpected error with buffer size %d: %v", size, err)
+		}
+		if n != int64(len(want)) {
+			t.Errorf("Number of bytes written incorrect with buffer size %d. got %d; want %d", size, n, len(want))
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Written bytes do not match with buffer size %d. got %#v; want %#v", size, buf.Bytes(), want)
+		}
+	}
+}
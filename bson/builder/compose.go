@@ -0,0 +1,263 @@
+package builder
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrInvalidDocument indicates that the bytes provided to ElementsFromReader are not a valid BSON
+// document.
+var ErrInvalidDocument = errors.New("builder: invalid BSON document")
+
+// ErrDocumentChanged indicates that the document passed to ElementsFromDocument changed size
+// between when the enclosing document was sized and when it was written.
+var ErrDocumentChanged = errors.New("builder: document changed after it was sized")
+
+// DocumentWriter is implemented by types that can report their size and write themselves as a
+// BSON document into a byte slice, such as *bson.Document.
+type DocumentWriter interface {
+	Validate() (uint32, error)
+	WriteDocument(start uint, writer interface{}) (int64, error)
+}
+
+// If returns elem if cond is true and nil otherwise. Since Append ignores nil elements, this can
+// be used to conditionally add an element while constructing a document:
+//
+//	builder.NewDocumentBuilder().Append(
+//	    builder.C.String("name", name),
+//	    builder.C.If(age > 0, builder.C.Int32("age", age)),
+//	)
+//
+// Note that the element is constructed regardless of cond. Use Computed to defer the
+// construction of an expensive element.
+func (Constructor) If(cond bool, elem Elementer) Elementer {
+	if !cond {
+		return nil
+	}
+
+	return elem
+}
+
+// Computed creates an element whose value is computed by calling f when the document is
+// written. f is called at most once per write of the enclosing document: the value is computed
+// when the document is first sized, such as by RequiredBytes, and kept until the write that
+// follows completes, so the bytes written match the size. If f returns nil, no element is
+// written.
+//
+// The element holds the value between sizing and writing, so it must be appended to a single
+// document, and that document must not be written concurrently.
+func (Constructor) Computed(f func() Elementer) Elementer {
+	return &computedElement{f: f}
+}
+
+type computedElement struct {
+	f      func() Elementer
+	elem   Elementer
+	sizer  ElementSizer
+	writer ElementWriter
+}
+
+func (ce *computedElement) resolve() {
+	if ce.sizer != nil {
+		return
+	}
+
+	ce.elem = ce.f()
+	if ce.elem == nil {
+		ce.sizer = func() uint { return 0 }
+		ce.writer = func(uint, []byte) (int, error) { return 0, nil }
+		return
+	}
+
+	ce.sizer, ce.writer = ce.elem.Element()
+}
+
+// reset clears the computed value so that f is called again on the next write.
+func (ce *computedElement) reset() {
+	ce.elem, ce.sizer, ce.writer = nil, nil, nil
+}
+
+func (ce *computedElement) size() uint {
+	ce.resolve()
+	return ce.sizer()
+}
+
+// Element implements the Elementer interface. A value computed before the element was appended,
+// such as while sizing a document it was previously appended to, is discarded.
+func (ce *computedElement) Element() (ElementSizer, ElementWriter) {
+	ce.reset()
+	return ce.size, func(start uint, writer []byte) (int, error) {
+		ce.resolve()
+		defer ce.reset()
+
+		return ce.writer(start, writer)
+	}
+}
+
+// StreamElement implements the StreamElementer interface.
+func (ce *computedElement) StreamElement() (ElementSizer, ElementStreamWriter) {
+	return ce.size, func(w io.Writer) (int64, error) {
+		ce.resolve()
+		defer ce.reset()
+
+		if se, ok := ce.elem.(StreamElementer); ok {
+			_, streamer := se.StreamElement()
+			return streamer(w)
+		}
+
+		return streamTo(w, func(sw *streamWriter) (int64, error) {
+			return sw.writeElement(ce.sizer(), ce.writer)
+		})
+	}
+}
+
+// ElementsFromReader creates an element that writes each of the elements of the BSON document r,
+// in order, into the enclosing document. A bson.Reader can be passed directly. The elements of r
+// are copied as-is and are not validated.
+func (Constructor) ElementsFromReader(r []byte) Elementer {
+	return ElementFunc(func() (ElementSizer, ElementWriter) {
+		return func() uint {
+				if len(r) < 5 {
+					return 0
+				}
+				return uint(len(r)) - 5
+			},
+			func(start uint, writer []byte) (int, error) {
+				if len(r) < 5 || int(readi32(r)) != len(r) || r[len(r)-1] != '\x00' {
+					return 0, ErrInvalidDocument
+				}
+				if uint(len(writer)) < start+uint(len(r))-5 {
+					return 0, ErrTooShort
+				}
+
+				return copy(writer[start:], r[4:len(r)-1]), nil
+			}
+	})
+}
+
+// ElementsFromDocument creates an element that writes each of the elements of d, in order, into
+// the enclosing document. A *bson.Document can be passed directly. d is read when the enclosing
+// document is written, so changes made to d after it is appended are reflected in the output.
+//
+// d is validated once per write of the enclosing document, when the document is first sized, and
+// its size is kept until the write that follows completes. d must not change in between, or
+// ErrDocumentChanged is returned. As with Computed, the element must be appended to a single
+// document, and that document must not be written concurrently.
+func (Constructor) ElementsFromDocument(d DocumentWriter) Elementer {
+	return &documentElements{d: d}
+}
+
+type documentElements struct {
+	d     DocumentWriter
+	size  uint32
+	err   error
+	sized bool
+}
+
+func (de *documentElements) validate() {
+	if de.sized {
+		return
+	}
+
+	de.size, de.err = de.d.Validate()
+	if de.err == nil && de.size < 5 {
+		de.err = ErrInvalidDocument
+	}
+	de.sized = true
+}
+
+func (de *documentElements) elementsSize() uint {
+	de.validate()
+	if de.err != nil {
+		return 0
+	}
+	return uint(de.size) - 5
+}
+
+// Element implements the Elementer interface.
+func (de *documentElements) Element() (ElementSizer, ElementWriter) {
+	de.sized = false
+	return de.elementsSize, func(start uint, writer []byte) (int, error) {
+		de.validate()
+		// The size is only kept for one write, so changes made to d before the next are seen.
+		size, err := de.size, de.err
+		de.sized = false
+		if err != nil {
+			return 0, err
+		}
+		if uint(len(writer)) < start+uint(size)-5 {
+			return 0, ErrTooShort
+		}
+
+		b := make([]byte, size)
+		n, err := de.d.WriteDocument(0, b)
+		if n != int64(size) {
+			// A document which grew does not fit in b, so WriteDocument reports an error instead.
+			if now, verr := de.d.Validate(); verr == nil && now != size {
+				return 0, ErrDocumentChanged
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return copy(writer[start:], b[4:size-1]), nil
+	}
+}
+
+// If returns elem if cond is true and nil otherwise. Since Append ignores nil elements, a skipped
+// element does not consume an index in the array.
+func (ArrayConstructor) If(cond bool, elem ArrayElementer) ArrayElementer {
+	if !cond {
+		return nil
+	}
+
+	return elem
+}
+
+// Computed creates an array element whose value is computed by calling f when the array is
+// written. f is called at most once per write of the enclosing array. The index of the element
+// is assigned when it is appended, so if f returns nil a null is written in its place rather
+// than leaving a gap in the indexes.
+func (ArrayConstructor) Computed(f func() ArrayElementer) ArrayElementFunc {
+	return func(pos uint) Elementer {
+		return C.Computed(func() Elementer {
+			elem := f()
+			if elem == nil {
+				elem = AC.Null()
+			}
+
+			return elem.ArrayElement(pos)
+		})
+	}
+}
+
+// Reset clears the elements of the builder so it can be reused. The underlying storage is kept,
+// which allows a DocumentBuilder to be stored in a sync.Pool and reused without reallocating. The
+// Key is also cleared.
+func (db *DocumentBuilder) Reset() {
+	if !db.initialized {
+		return
+	}
+
+	for idx := 1; idx < len(db.funcs); idx++ {
+		db.funcs[idx], db.sizers[idx], db.streamers[idx] = nil, nil, nil
+	}
+
+	db.funcs = db.funcs[:1]
+	db.sizers = db.sizers[:1]
+	db.streamers = db.streamers[:1]
+	db.required = 0
+	db.Key = ""
+}
+
+// Reset clears the elements of the array so it can be reused.
+func (ab *ArrayBuilder) Reset() {
+	ab.DocumentBuilder.Reset()
+	ab.current = 0
+}
+
+// readi32 is a helper function for reading an int32 from slice of bytes.
+func readi32(b []byte) int32 {
+	return int32(b[0]) | int32(b[1])<<8 | int32(b[2])<<16 | int32(b[3])<<24
+}
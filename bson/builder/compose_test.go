@@ -0,0 +1,207 @@
+package builder
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+func writeBuilder(t *testing.T, db *DocumentBuilder) []byte {
+	t.Helper()
+
+	b := make([]byte, db.RequiredBytes())
+	_, err := db.WriteDocument(b)
+	if err != nil {
+		t.Fatalf("Unexpected error writing document: %v", err)
+	}
+
+	return b
+}
+
+func TestConditionalElements(t *testing.T) {
+	t.Run("If", func(t *testing.T) {
+		got := writeBuilder(t, NewDocumentBuilder().Append(
+			C.String("a", "foo"),
+			C.If(false, C.String("b", "bar")),
+			C.If(true, C.String("c", "baz")),
+		))
+		want := writeBuilder(t, NewDocumentBuilder().Append(C.String("a", "foo"), C.String("c", "baz")))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+	t.Run("ArrayIf", func(t *testing.T) {
+		got := writeBuilder(t, NewDocumentBuilder().Append(
+			C.ArrayWithElements("a", AC.If(false, AC.Int32(1)), AC.Int32(2), AC.If(true, AC.Int32(3))),
+		))
+		want := writeBuilder(t, NewDocumentBuilder().Append(C.ArrayWithElements("a", AC.Int32(2), AC.Int32(3))))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+	t.Run("Computed", func(t *testing.T) {
+		var calls int32
+		db := NewDocumentBuilder().Append(
+			C.Computed(func() Elementer {
+				calls++
+				return C.Int32("calls", calls)
+			}),
+			C.Computed(func() Elementer { return nil }),
+			C.ArrayWithElements("arr",
+				AC.Computed(func() ArrayElementer { return AC.String("qux") }),
+				AC.Computed(func() ArrayElementer { return nil }),
+				AC.Int32(1),
+			),
+		)
+
+		for i := int32(1); i <= 2; i++ {
+			got := writeBuilder(t, db)
+			want := writeBuilder(t, NewDocumentBuilder().Append(
+				C.Int32("calls", i),
+				C.ArrayWithElements("arr", AC.String("qux"), AC.Null(), AC.Int32(1)),
+			))
+			if !bytes.Equal(got, want) {
+				t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+			}
+			if calls != i {
+				t.Errorf("Computed function called an unexpected number of times. got %d; want %d", calls, i)
+			}
+		}
+
+		var buf bytes.Buffer
+		_, err := db.WriteToWithBuffer(&buf, make([]byte, 8))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := writeBuilder(t, NewDocumentBuilder().Append(
+			C.Int32("calls", 3),
+			C.ArrayWithElements("arr", AC.String("qux"), AC.Null(), AC.Int32(1)),
+		))
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", buf.Bytes(), want)
+		}
+	})
+	t.Run("Computed-Reset", func(t *testing.T) {
+		size := 1
+		elem := C.Computed(func() Elementer { return C.Binary("b", make([]byte, size)) })
+		db := NewDocumentBuilder().Append(elem)
+		_ = db.RequiredBytes()
+
+		// Appending the element again discards the value computed while sizing.
+		db.Reset()
+		size = 2
+		got := writeBuilder(t, db.Append(elem))
+		want := writeBuilder(t, NewDocumentBuilder().Append(C.Binary("b", make([]byte, 2))))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+}
+
+// countingDocument counts the calls to Validate of a *bson.Document.
+type countingDocument struct {
+	*bson.Document
+	validations int
+}
+
+func (cd *countingDocument) Validate() (uint32, error) {
+	cd.validations++
+	return cd.Document.Validate()
+}
+
+func TestElementsFrom(t *testing.T) {
+	want := writeBuilder(t, NewDocumentBuilder().Append(
+		C.String("a", "foo"),
+		C.Int32("b", 1),
+		C.SubDocumentWithElements("c", C.Boolean("d", true)),
+		C.Null("e"),
+	))
+
+	t.Run("Reader", func(t *testing.T) {
+		r := bson.Reader(writeBuilder(t, NewDocumentBuilder().Append(
+			C.Int32("b", 1),
+			C.SubDocumentWithElements("c", C.Boolean("d", true)),
+		)))
+		got := writeBuilder(t, NewDocumentBuilder().Append(C.String("a", "foo"), C.ElementsFromReader(r), C.Null("e")))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+	t.Run("Reader-invalid", func(t *testing.T) {
+		db := NewDocumentBuilder().Append(C.ElementsFromReader([]byte{0x07, 0x00, 0x00, 0x00, 0x0A, 0x00}))
+		_, err := db.WriteDocument(make([]byte, db.RequiredBytes()))
+		if err != ErrInvalidDocument {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidDocument)
+		}
+	})
+	t.Run("Document", func(t *testing.T) {
+		doc := bson.NewDocument(bson.C.Int32("b", 1))
+		db := NewDocumentBuilder().Append(C.String("a", "foo"), C.ElementsFromDocument(doc), C.Null("e"))
+
+		// The document is read at write time.
+		doc.Append(bson.C.SubDocumentFromElements("c", bson.C.Boolean("d", true)))
+
+		got := writeBuilder(t, db)
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+	t.Run("Document-validations", func(t *testing.T) {
+		cd := &countingDocument{Document: bson.NewDocument(bson.C.Int32("b", 1))}
+		db := NewDocumentBuilder().Append(C.SubDocumentWithElements("x", C.SubDocumentWithElements("y", C.ElementsFromDocument(cd))))
+		for i := 1; i <= 2; i++ {
+			_ = writeBuilder(t, db)
+			if cd.validations != i {
+				t.Errorf("Unexpected number of validations. got %d; want %d", cd.validations, i)
+			}
+		}
+	})
+	t.Run("Document-changed", func(t *testing.T) {
+		doc := bson.NewDocument(bson.C.Int32("b", 1))
+		db := NewDocumentBuilder().Append(C.ElementsFromDocument(doc))
+		b := make([]byte, db.RequiredBytes())
+		doc.Append(bson.C.Int32("c", 2))
+		if _, err := db.WriteDocument(b); err != ErrDocumentChanged {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrDocumentChanged)
+		}
+	})
+}
+
+func TestDocumentBuilderReset(t *testing.T) {
+	pool := sync.Pool{New: func() interface{} { return NewDocumentBuilder() }}
+
+	db := pool.Get().(*DocumentBuilder)
+	db.Append(C.String("foo", "bar"), C.SubDocumentWithElements("baz", C.Int32("qux", 1)))
+	_ = writeBuilder(t, db)
+	db.Reset()
+	pool.Put(db)
+
+	db = pool.Get().(*DocumentBuilder)
+	got := writeBuilder(t, db.Append(C.Int64("a", 2)))
+	want := writeBuilder(t, NewDocumentBuilder().Append(C.Int64("a", 2)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+	}
+
+	t.Run("ArrayBuilder", func(t *testing.T) {
+		ab := new(ArrayBuilder).Append(AC.Int32(1), AC.Int32(2))
+		ab.Reset()
+		ab.Append(AC.Int32(3))
+
+		got := writeBuilder(t, NewDocumentBuilder().Append(C.Array("a", ab)))
+		want := writeBuilder(t, NewDocumentBuilder().Append(C.ArrayWithElements("a", AC.Int32(3))))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+	t.Run("Uninitialized", func(t *testing.T) {
+		var db DocumentBuilder
+		db.Reset()
+		got := writeBuilder(t, db.Append(C.Int64("a", 2)))
+		if !bytes.Equal(got, want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+}
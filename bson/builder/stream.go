@@ -0,0 +1,367 @@
+package builder
+
+import (
+	"errors"
+	"io"
+
+	"github.com/skriptble/wilson/bson/elements"
+)
+
+// DefaultStreamBufferSize is the size of the buffer used by DocumentBuilder.WriteTo.
+const DefaultStreamBufferSize = 4096
+
+// ErrShortRead indicates that an io.Reader provided to a streaming constructor returned fewer
+// bytes than the length it was declared with.
+var ErrShortRead = errors.New("builder: the provided io.Reader returned fewer bytes than declared")
+
+// StreamElementer is the interface implemented by types that can serialize themselves into a
+// BSON element directly to an io.Writer, instead of into a preallocated byte slice. When a
+// DocumentBuilder is written with WriteTo, elements implementing this interface are streamed,
+// which allows elements larger than the write buffer to be written without allocating a slice
+// large enough to hold them.
+type StreamElementer interface {
+	Elementer
+	StreamElement() (ElementSizer, ElementStreamWriter)
+}
+
+// ElementStreamWriter handles writing an element's BSON representation to an io.Writer.
+type ElementStreamWriter func(w io.Writer) (n int64, err error)
+
+// streamWriter is a bounded buffer that sits in front of an io.Writer. Elements that fit into
+// the buffer are written into it using their ElementWriter, and the buffer is flushed to the
+// underlying io.Writer when it fills up.
+type streamWriter struct {
+	w     io.Writer
+	buf   []byte
+	n     int
+	total int64
+}
+
+func newStreamWriter(w io.Writer, buf []byte) *streamWriter {
+	return &streamWriter{w: w, buf: buf[:cap(buf)]}
+}
+
+// Write implements the io.Writer interface. Bytes are copied into the buffer, which is flushed
+// as needed. Writes larger than the buffer bypass it entirely.
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if sw.n == 0 && len(p) >= len(sw.buf) {
+			n, err := sw.w.Write(p)
+			total += n
+			sw.total += int64(n)
+			return total, err
+		}
+
+		n := copy(sw.buf[sw.n:], p)
+		sw.n += n
+		total += n
+		p = p[n:]
+
+		if sw.n == len(sw.buf) {
+			if err := sw.flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// ReadFrom implements the io.ReaderFrom interface. Data is read directly into the buffer so that
+// io.Copy doesn't allocate an intermediate buffer of its own.
+func (sw *streamWriter) ReadFrom(r io.Reader) (int64, error) {
+	if len(sw.buf) == 0 {
+		return io.Copy(struct{ io.Writer }{sw.w}, r)
+	}
+
+	var total int64
+	for {
+		if sw.n == len(sw.buf) {
+			if err := sw.flush(); err != nil {
+				return total, err
+			}
+		}
+
+		n, err := r.Read(sw.buf[sw.n:])
+		sw.n += n
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// writeElement writes a single element using its ElementWriter. If the element fits into the
+// buffer it is written in place, otherwise it is written into a temporary slice of exactly the
+// element's size.
+func (sw *streamWriter) writeElement(size uint, f ElementWriter) (int64, error) {
+	if size > uint(len(sw.buf)) {
+		tmp := make([]byte, size)
+		n, err := f(0, tmp)
+		if err != nil {
+			return int64(n), err
+		}
+
+		written, err := sw.Write(tmp[:n])
+		return int64(written), err
+	}
+
+	if size > uint(len(sw.buf)-sw.n) {
+		if err := sw.flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f(uint(sw.n), sw.buf)
+	sw.n += n
+	return int64(n), err
+}
+
+func (sw *streamWriter) flush() error {
+	if sw.n == 0 {
+		return nil
+	}
+
+	n, err := sw.w.Write(sw.buf[:sw.n])
+	sw.total += int64(n)
+	if err == nil && n < sw.n {
+		err = io.ErrShortWrite
+	}
+	sw.n = 0
+
+	return err
+}
+
+// WriteTo implements the io.WriterTo interface. The document is written through a buffer of
+// DefaultStreamBufferSize bytes, so the memory used is independent of the size of the document.
+func (db *DocumentBuilder) WriteTo(w io.Writer) (int64, error) {
+	return db.WriteToWithBuffer(w, make([]byte, DefaultStreamBufferSize))
+}
+
+// WriteToWithBuffer writes the document to w using buf as the write buffer. The sizes of
+// elements and subdocuments are computed from their sizers as they are needed, so the document
+// is never fully materialized. Elements larger than buf that do not implement StreamElementer
+// are written using a temporary slice the size of that element.
+func (db *DocumentBuilder) WriteToWithBuffer(w io.Writer, buf []byte) (int64, error) {
+	sw := newStreamWriter(w, buf)
+
+	_, err := db.streamDocument(sw, false)
+	if err != nil {
+		return sw.total, err
+	}
+
+	err = sw.flush()
+	return sw.total, err
+}
+
+// StreamElement implements the StreamElementer interface.
+func (db *DocumentBuilder) StreamElement() (ElementSizer, ElementStreamWriter) {
+	return db.embeddedSize, func(w io.Writer) (int64, error) {
+		return streamTo(w, func(sw *streamWriter) (int64, error) {
+			return db.streamDocument(sw, true)
+		})
+	}
+}
+
+// streamTo runs f against w, wrapping w in a streamWriter if it is not one already.
+func streamTo(w io.Writer, f func(sw *streamWriter) (int64, error)) (int64, error) {
+	if sw, ok := w.(*streamWriter); ok {
+		return f(sw)
+	}
+
+	sw := newStreamWriter(w, make([]byte, DefaultStreamBufferSize))
+	n, err := f(sw)
+	if err != nil {
+		return n, err
+	}
+
+	return n, sw.flush()
+}
+
+func (db *DocumentBuilder) streamDocument(sw *streamWriter, embedded bool) (int64, error) {
+	db.init()
+
+	var total int64
+
+	if embedded {
+		n, err := sw.writeElement(uint(len(db.Key))+2, func(start uint, writer []byte) (int, error) {
+			return writeElementHeader(start, writer, '\x03', db.Key)
+		})
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	n, err := db.streamElements(sw)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	n, err = sw.writeElement(1, func(start uint, writer []byte) (int, error) {
+		return elements.Byte.Encode(start, writer, '\x00')
+	})
+	total += n
+	return total, err
+}
+
+func (db *DocumentBuilder) streamElements(sw *streamWriter) (int64, error) {
+	var total int64
+	for idx := range db.funcs {
+		var n int64
+		var err error
+
+		if streamer := db.streamers[idx]; streamer != nil {
+			n, err = streamer(sw)
+		} else {
+			n, err = sw.writeElement(db.sizers[idx](), db.funcs[idx])
+		}
+
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// writeElementHeader writes the type byte and key of an element.
+func writeElementHeader(start uint, writer []byte, t byte, key string) (int, error) {
+	var total int
+
+	n, err := elements.Byte.Encode(start, writer, t)
+	start += uint(n)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	n, err = elements.CString.Encode(start, writer, key)
+	total += n
+	return total, err
+}
+
+// BinaryFromReader creates a binary element with the given key whose data is read from r. The
+// length parameter must be the exact number of bytes r will return. Since the data is consumed
+// from r, a document containing this element can only be written once.
+//
+// When the document is written with WriteTo, the data is copied from r through the write buffer
+// instead of being held in memory.
+func (Constructor) BinaryFromReader(key string, r io.Reader, length uint32, btype byte) Elementer {
+	return &readerBinaryElement{key: key, r: r, length: length, btype: btype}
+}
+
+type readerBinaryElement struct {
+	key    string
+	r      io.Reader
+	length uint32
+	btype  byte
+}
+
+// size returns the size of the binary element. A binary of subtype 2 has length
+// (1 + key length + 1) + (4 + 1 + 4 + length). All other binary subtypes have length
+// (1 + key length + 1) + (4 + 1 + length).
+func (rb *readerBinaryElement) size() uint {
+	return rb.headerSize() + uint(rb.length)
+}
+
+func (rb *readerBinaryElement) headerSize() uint {
+	if rb.btype == 2 {
+		return uint(11 + len(rb.key))
+	}
+
+	return uint(7 + len(rb.key))
+}
+
+func (rb *readerBinaryElement) writeHeader(start uint, writer []byte) (int, error) {
+	var total int
+
+	n, err := elements.Byte.Encode(start, writer, '\x05')
+	start += uint(n)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	n, err = elements.CString.Encode(start, writer, rb.key)
+	start += uint(n)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	length := int32(rb.length)
+	if rb.btype == 2 {
+		length += 4
+	}
+
+	n, err = elements.Int32.Encode(start, writer, length)
+	start += uint(n)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	n, err = elements.Byte.Encode(start, writer, rb.btype)
+	start += uint(n)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	if rb.btype == 2 {
+		n, err = elements.Int32.Encode(start, writer, int32(rb.length))
+		total += n
+	}
+
+	return total, err
+}
+
+// Element implements the Elementer interface.
+func (rb *readerBinaryElement) Element() (ElementSizer, ElementWriter) {
+	return rb.size, func(start uint, writer []byte) (int, error) {
+		if uint(len(writer)) < start+rb.size() {
+			return 0, ErrTooShort
+		}
+
+		total, err := rb.writeHeader(start, writer)
+		if err != nil {
+			return total, err
+		}
+		start += uint(total)
+
+		n, err := io.ReadFull(rb.r, writer[start:start+uint(rb.length)])
+		total += n
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = ErrShortRead
+		}
+
+		return total, err
+	}
+}
+
+// StreamElement implements the StreamElementer interface.
+func (rb *readerBinaryElement) StreamElement() (ElementSizer, ElementStreamWriter) {
+	return rb.size, func(w io.Writer) (int64, error) {
+		return streamTo(w, func(sw *streamWriter) (int64, error) {
+			total, err := sw.writeElement(rb.headerSize(), rb.writeHeader)
+			if err != nil {
+				return total, err
+			}
+
+			n, err := io.CopyN(sw, rb.r, int64(rb.length))
+			total += n
+			if err == io.EOF {
+				err = ErrShortRead
+			}
+
+			return total, err
+		})
+	}
+}
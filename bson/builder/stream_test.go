@@ -0,0 +1,119 @@
+package builder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+func TestDocumentBuilderWriteTo(t *testing.T) {
+	newBuilder := func() *DocumentBuilder {
+		return NewDocumentBuilder().Append(
+			C.String("foo", "bar"),
+			C.SubDocumentWithElements("baz",
+				C.Int32("qux", 42),
+				C.ArrayWithElements("quux", AC.String("corge"), AC.Double(3.14159), AC.SubDocumentWithElements(C.Null("grault"))),
+			),
+			C.ObjectID("_id", objectid.ObjectID{0x01, 0x02, 0x03}),
+			C.Binary("garply", bytes.Repeat([]byte{0xAB}, 100)),
+			C.Array("waldo", (&ArrayBuilder{}).Append(AC.Int64(1), AC.Int64(2))),
+		)
+	}
+
+	db := newBuilder()
+	want := make([]byte, db.RequiredBytes())
+	_, err := db.WriteDocument(want)
+	if err != nil {
+		t.Fatalf("Unexpected error writing document: %v", err)
+	}
+
+	for _, size := range []int{0, 1, 7, 16, 64, DefaultStreamBufferSize} {
+		var buf bytes.Buffer
+		n, err := newBuilder().WriteToWithBuffer(&buf, make([]byte, size))
+		if err != nil {
+			t.Errorf("Unexpected error with buffer size %d: %v", size, err)
+		}
+		if n != int64(len(want)) {
+			t.Errorf("Incorrect number of bytes written with buffer size %d. got %d; want %d", size, n, len(want))
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Documents do not match with buffer size %d.\ngot  %v\nwant %v", size, buf.Bytes(), want)
+		}
+	}
+
+	t.Run("WriteTo", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := newBuilder().WriteTo(&buf)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Documents do not match.\ngot  %v\nwant %v", buf.Bytes(), want)
+		}
+	})
+}
+
+type maxWriteRecorder struct {
+	bytes.Buffer
+	max int
+}
+
+func (mwr *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > mwr.max {
+		mwr.max = len(p)
+	}
+	return mwr.Buffer.Write(p)
+}
+
+func TestBinaryFromReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for _, btype := range []byte{0x00, 0x02, 0x80} {
+		want := make([]byte, NewDocumentBuilder().Append(C.BinaryWithSubtype("blob", data, btype)).RequiredBytes())
+		_, err := NewDocumentBuilder().Append(C.BinaryWithSubtype("blob", data, btype)).WriteDocument(want)
+		if err != nil {
+			t.Fatalf("Unexpected error writing document: %v", err)
+		}
+
+		t.Run("WriteTo", func(t *testing.T) {
+			var w maxWriteRecorder
+			db := NewDocumentBuilder().Append(
+				C.SubDocumentWithElements("blob", C.BinaryFromReader("blob", bytes.NewReader(data), uint32(len(data)), btype)),
+			)
+			_, err := db.WriteToWithBuffer(&w, make([]byte, 64))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if w.max > 64 {
+				t.Errorf("Write larger than the buffer was made. got %d; want <= 64", w.max)
+			}
+
+			got := w.Bytes()
+			// The binary is nested one level deeper than in want, so compare the embedded value.
+			if !bytes.Equal(got[4+1+5:len(got)-1], want) {
+				t.Errorf("Documents do not match")
+			}
+		})
+		t.Run("WriteDocument", func(t *testing.T) {
+			db := NewDocumentBuilder().Append(C.BinaryFromReader("blob", bytes.NewReader(data), uint32(len(data)), btype))
+			got := make([]byte, db.RequiredBytes())
+			_, err := db.WriteDocument(got)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Documents do not match")
+			}
+		})
+	}
+
+	t.Run("ShortRead", func(t *testing.T) {
+		db := NewDocumentBuilder().Append(C.BinaryFromReader("blob", bytes.NewReader(data[:10]), 20, 0))
+		_, err := db.WriteTo(ioutil.Discard)
+		if err != ErrShortRead {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrShortRead)
+		}
+	})
+}
@@ -0,0 +1,397 @@
+package bson
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/skriptble/wilson/bson/decimal"
+)
+
+// NumberForm is the representation that Canonicalize converts numbers to.
+type NumberForm uint8
+
+// These constants are the supported number forms.
+const (
+	// NumberCanonical stores each number in a single representation chosen by its value, so
+	// that numbers which are equivalent by Value.Equivalent have the same bytes. Integers in
+	// the range of int64 become int64 values, other numbers which a double represents exactly
+	// become doubles, and the remaining decimal128 values have the trailing zeros removed from
+	// their significand. NaN and the infinities become doubles, and -0 becomes 0.
+	NumberCanonical NumberForm = iota
+
+	// NumberDouble converts every number to the nearest double. Distinct int64 and decimal128
+	// values may have the same canonical form. NaN is converted to a single NaN and -0 to 0.
+	NumberDouble
+
+	// NumberUnchanged leaves numbers unchanged.
+	NumberUnchanged
+)
+
+// CanonicalOptions configures the canonical form produced by Canonicalize and Hash. The zero
+// value sorts keys and uses NumberCanonical.
+type CanonicalOptions struct {
+	// PreserveKeyOrder leaves the elements of documents in their original order. By default
+	// the elements of documents, including embedded documents and the scopes of JavaScript code
+	// with scope, are sorted by key. Elements with the same key keep their relative order. The
+	// elements of arrays are never reordered.
+	PreserveKeyOrder bool
+
+	// Numbers is the representation that double, int32, int64, and decimal128 values are
+	// converted to.
+	Numbers NumberForm
+}
+
+// Canonicalize returns the canonical form of the document r, with keys sorted recursively,
+// numbers in their NumberCanonical form, and binary values of the old binary subtype 2
+// converted to subtype 0. Documents which are equivalent, ignoring key order, have the same
+// canonical form. The converse does not hold: since the subtype is converted, documents which
+// differ only in a binary value of subtype 2 rather than 0 share a canonical form, although
+// their bytes differ and they are not equal by CompareValues or Value.Equivalent.
+func Canonicalize(r Reader) (Reader, error) {
+	return CanonicalizeWithOptions(r, nil)
+}
+
+// CanonicalizeWithOptions returns the canonical form of the document r described by opts. If
+// opts is nil it behaves like Canonicalize.
+func CanonicalizeWithOptions(r Reader, opts *CanonicalOptions) (Reader, error) {
+	c := newCanonicalizer(opts)
+	size, err := c.documentSize(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	c.w = buf
+	err = c.document(r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return Reader(buf.Bytes()), nil
+}
+
+// Hash writes the canonical form of the document r, as returned by Canonicalize, to h, so that
+// equivalent documents produce the same digest. As described for Canonicalize, documents with
+// the same digest need not be equivalent. The canonical form is written as it is computed,
+// without making a copy of the document. If r is invalid, an error is returned and h may have
+// been partially written to.
+func Hash(r Reader, h hash.Hash) error {
+	return HashWithOptions(r, h, nil)
+}
+
+// HashWithOptions writes the canonical form of the document r described by opts to h. If opts
+// is nil it behaves like Hash.
+func HashWithOptions(r Reader, h hash.Hash, opts *CanonicalOptions) error {
+	c := newCanonicalizer(opts)
+	c.w = h
+	return c.document(r, false)
+}
+
+// canonicalizer writes the canonical form of documents to w. The lengths of documents are
+// computed before they are written, so the canonical form is never held in memory.
+type canonicalizer struct {
+	opts    *CanonicalOptions
+	w       io.Writer
+	scratch [16]byte
+}
+
+func newCanonicalizer(opts *CanonicalOptions) *canonicalizer {
+	if opts == nil {
+		opts = &CanonicalOptions{}
+	}
+
+	return &canonicalizer{opts: opts}
+}
+
+// elements returns the elements of the document or array r in canonical order.
+func (c *canonicalizer) elements(r Reader, array bool) ([]*Element, error) {
+	elems, err := readerElements(r)
+	if err != nil {
+		return nil, err
+	}
+	if !array && !c.opts.PreserveKeyOrder {
+		sort.SliceStable(elems, func(i, j int) bool {
+			return bytes.Compare(keyBytes(elems[i]), keyBytes(elems[j])) < 0
+		})
+	}
+
+	return elems, nil
+}
+
+// documentSize returns the size of the canonical form of the document or array r.
+func (c *canonicalizer) documentSize(r Reader, array bool) (uint32, error) {
+	elems, err := readerElements(r)
+	if err != nil {
+		return 0, err
+	}
+
+	size := uint32(5)
+	for _, elem := range elems {
+		n, err := c.valueSize(elem.value)
+		if err != nil {
+			return 0, err
+		}
+		size += 1 + uint32(len(keyBytes(elem))) + 1 + n
+	}
+
+	return size, nil
+}
+
+// document writes the canonical form of the document or array r.
+func (c *canonicalizer) document(r Reader, array bool) error {
+	size, err := c.documentSize(r, array)
+	if err != nil {
+		return err
+	}
+	elems, err := c.elements(r, array)
+	if err != nil {
+		return err
+	}
+
+	err = c.writeUint32(size)
+	if err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		c.scratch[0] = byte(c.valueType(elem.value))
+		err = c.write(c.scratch[:1])
+		if err != nil {
+			return err
+		}
+		err = c.write(elem.value.data[elem.value.start+1 : elem.value.offset])
+		if err != nil {
+			return err
+		}
+		err = c.value(elem.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.scratch[0] = 0
+	return c.write(c.scratch[:1])
+}
+
+// valueType returns the type of the canonical form of v.
+func (c *canonicalizer) valueType(v *Value) Type {
+	switch t := v.Type(); t {
+	case TypeDouble, TypeInt32, TypeInt64, TypeDecimal128:
+		if c.opts.Numbers != NumberUnchanged {
+			t, _ = c.number(v)
+		}
+		return t
+	default:
+		return t
+	}
+}
+
+// valueSize returns the size of the canonical form of v.
+func (c *canonicalizer) valueSize(v *Value) (uint32, error) {
+	switch t := v.Type(); t {
+	case TypeDouble, TypeInt32, TypeInt64, TypeDecimal128:
+		if c.opts.Numbers == NumberUnchanged {
+			break
+		}
+		if t, _ = c.number(v); t == TypeDecimal128 {
+			return 16, nil
+		}
+		return 8, nil
+	case TypeEmbeddedDocument, TypeArray:
+		return c.documentSize(v.data[v.offset:], t == TypeArray)
+	case TypeCodeWithScope:
+		code := readi32(v.data[v.offset+4:])
+		n, err := c.documentSize(v.data[v.offset+8+uint32(code):], false)
+		return 8 + uint32(code) + n, err
+	case TypeBinary:
+		if v.data[v.offset+4] == '\x02' {
+			l := readi32(v.data[v.offset:])
+			if l < 4 || readi32(v.data[v.offset+5:]) != l-4 {
+				return 0, ErrInvalidLength
+			}
+			return 5 + uint32(l) - 4, nil
+		}
+	}
+
+	return v.valueSize()
+}
+
+// value writes the canonical form of v.
+func (c *canonicalizer) value(v *Value) error {
+	switch t := v.Type(); t {
+	case TypeDouble, TypeInt32, TypeInt64, TypeDecimal128:
+		if c.opts.Numbers == NumberUnchanged {
+			break
+		}
+		_, b := c.number(v)
+		return c.write(b)
+	case TypeEmbeddedDocument, TypeArray:
+		return c.document(v.data[v.offset:], t == TypeArray)
+	case TypeCodeWithScope:
+		size, err := c.valueSize(v)
+		if err != nil {
+			return err
+		}
+		err = c.writeUint32(size)
+		if err != nil {
+			return err
+		}
+		code := readi32(v.data[v.offset+4:])
+		err = c.write(v.data[v.offset+4 : v.offset+8+uint32(code)])
+		if err != nil {
+			return err
+		}
+		return c.document(v.data[v.offset+8+uint32(code):], false)
+	case TypeBinary:
+		if v.data[v.offset+4] == '\x02' {
+			l := readi32(v.data[v.offset:])
+			err := c.writeUint32(uint32(l) - 4)
+			if err != nil {
+				return err
+			}
+			c.scratch[0] = '\x00'
+			err = c.write(c.scratch[:1])
+			if err != nil {
+				return err
+			}
+			return c.write(v.data[v.offset+9 : v.offset+5+uint32(l)])
+		}
+	}
+
+	size, err := v.valueSize()
+	if err != nil {
+		return err
+	}
+	return c.write(v.data[v.offset : v.offset+size])
+}
+
+// number returns the type and bytes of the canonical form of the number v. The bytes are stored
+// in c.scratch.
+func (c *canonicalizer) number(v *Value) (Type, []byte) {
+	if c.opts.Numbers == NumberDouble {
+		return TypeDouble, c.double(toDouble(v))
+	}
+
+	switch v.Type() {
+	case TypeInt32:
+		return TypeInt64, c.int64(int64(v.Int32()))
+	case TypeInt64:
+		return TypeInt64, c.int64(v.Int64())
+	case TypeDouble:
+		f := v.Double()
+		if f == math.Trunc(f) && f >= -(1<<63) && f < 1<<63 {
+			return TypeInt64, c.int64(int64(f))
+		}
+		return TypeDouble, c.double(f)
+	}
+
+	n, _ := numberOf(v)
+	switch {
+	case n.nan:
+		return TypeDouble, c.double(math.NaN())
+	case n.inf && n.neg:
+		return TypeDouble, c.double(math.Inf(-1))
+	case n.inf:
+		return TypeDouble, c.double(math.Inf(1))
+	case n.r.IsInt() && n.r.Num().IsInt64():
+		return TypeInt64, c.int64(n.r.Num().Int64())
+	}
+	if f, exact := n.r.Float64(); exact {
+		return TypeDouble, c.double(f)
+	}
+
+	h, l := normalizeDecimal(v.Decimal128()).GetBytes()
+	binary.LittleEndian.PutUint64(c.scratch[:8], l)
+	binary.LittleEndian.PutUint64(c.scratch[8:], h)
+	return TypeDecimal128, c.scratch[:16]
+}
+
+func (c *canonicalizer) int64(i int64) []byte {
+	binary.LittleEndian.PutUint64(c.scratch[:8], uint64(i))
+	return c.scratch[:8]
+}
+
+// double stores f in c.scratch with a single NaN and without a negative zero.
+func (c *canonicalizer) double(f float64) []byte {
+	bits := math.Float64bits(f)
+	switch {
+	case math.IsNaN(f):
+		bits = 0x7FF8000000000000
+	case f == 0:
+		bits = 0
+	}
+	binary.LittleEndian.PutUint64(c.scratch[:8], bits)
+	return c.scratch[:8]
+}
+
+func (c *canonicalizer) writeUint32(i uint32) error {
+	binary.LittleEndian.PutUint32(c.scratch[:4], i)
+	return c.write(c.scratch[:4])
+}
+
+func (c *canonicalizer) write(b []byte) error {
+	_, err := c.w.Write(b)
+	return err
+}
+
+// toDouble returns the nearest double to the number v.
+func toDouble(v *Value) float64 {
+	switch v.Type() {
+	case TypeInt32:
+		return float64(v.Int32())
+	case TypeInt64:
+		return float64(v.Int64())
+	case TypeDouble:
+		return v.Double()
+	}
+
+	n, _ := numberOf(v)
+	switch {
+	case n.nan:
+		return math.NaN()
+	case n.inf && n.neg:
+		return math.Inf(-1)
+	case n.inf:
+		return math.Inf(1)
+	}
+	f, _ := n.r.Float64()
+	return f
+}
+
+// normalizeDecimal removes the trailing zeros from the significand of the finite decimal d,
+// increasing its exponent to keep its value.
+func normalizeDecimal(d decimal.Decimal128) decimal.Decimal128 {
+	h, l := d.GetBytes()
+	if h>>61&3 == 3 {
+		// The significand is out of range, so the value is zero.
+		return decimal.NewDecimal128(h&(1<<63)|uint64(6176)<<49, 0)
+	}
+
+	exp := int(h>>49&(1<<14-1)) - 6176
+	sig := new(big.Int).SetUint64(h & (1<<49 - 1))
+	sig.Lsh(sig, 64).Or(sig, new(big.Int).SetUint64(l))
+
+	ten := big.NewInt(10)
+	q, m := new(big.Int), new(big.Int)
+	for sig.Sign() != 0 && exp < 6111 {
+		q.QuoRem(sig, ten, m)
+		if m.Sign() != 0 {
+			break
+		}
+		sig.Set(q)
+		exp++
+	}
+
+	low := new(big.Int).And(sig, new(big.Int).SetUint64(math.MaxUint64)).Uint64()
+	high := new(big.Int).Rsh(sig, 64).Uint64()
+	return decimal.NewDecimal128(h&(1<<63)|uint64(exp+6176)<<49|high, low)
+}
+
+// keyBytes returns the key of e without its null terminator.
+func keyBytes(e *Element) []byte {
+	return e.value.data[e.value.start+1 : e.value.offset-1]
+}
@@ -0,0 +1,196 @@
+package bson
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math"
+	"testing"
+
+	"github.com/skriptble/wilson/bson/decimal"
+)
+
+func TestCanonicalize(t *testing.T) {
+	dec := func(s string) decimal.Decimal128 {
+		d, err := decimal.ParseDecimal128(s)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %q: %v", s, err)
+		}
+		return d
+	}
+
+	testCases := []struct {
+		name string
+		opts *CanonicalOptions
+		doc  *Document
+		want *Document
+	}{
+		{
+			"sorted keys",
+			nil,
+			NewDocument(
+				C.Null("b"),
+				C.SubDocumentFromElements("a", C.Null("d"), C.Null("c")),
+				C.ArrayFromElements("arr", AC.DocumentFromElements(C.Null("y"), C.Null("x")), AC.Null()),
+				C.CodeWithScope("cws", "x", NewDocument(C.Null("n"), C.Null("m"))),
+				C.String("b", "dup"),
+			),
+			NewDocument(
+				C.SubDocumentFromElements("a", C.Null("c"), C.Null("d")),
+				C.ArrayFromElements("arr", AC.DocumentFromElements(C.Null("x"), C.Null("y")), AC.Null()),
+				C.Null("b"),
+				C.String("b", "dup"),
+				C.CodeWithScope("cws", "x", NewDocument(C.Null("m"), C.Null("n"))),
+			),
+		},
+		{
+			"preserve key order",
+			&CanonicalOptions{PreserveKeyOrder: true},
+			NewDocument(C.Null("b"), C.SubDocumentFromElements("a", C.Null("d"), C.Null("c"))),
+			NewDocument(C.Null("b"), C.SubDocumentFromElements("a", C.Null("d"), C.Null("c"))),
+		},
+		{
+			"canonical numbers",
+			nil,
+			NewDocument(
+				C.Int32("a", 1),
+				C.Double("b", 2),
+				C.Double("c", 1.5),
+				C.Double("d", math.Copysign(0, -1)),
+				C.Double("e", 1<<63),
+				C.Decimal128("f", dec("1.00")),
+				C.Decimal128("g", dec("0.50")),
+				C.Decimal128("h", dec("0.10")),
+				C.Decimal128("i", dec("-NaN")),
+				C.Decimal128("j", dec("-Infinity")),
+				C.Decimal128("k", dec("-0.0")),
+				C.Decimal128("l", dec("12345678901234567891.00")),
+			),
+			NewDocument(
+				C.Int64("a", 1),
+				C.Int64("b", 2),
+				C.Double("c", 1.5),
+				C.Int64("d", 0),
+				C.Double("e", 1<<63),
+				C.Int64("f", 1),
+				C.Double("g", 0.5),
+				C.Decimal128("h", dec("0.1")),
+				C.Double("i", math.Float64frombits(0x7FF8000000000000)),
+				C.Double("j", math.Inf(-1)),
+				C.Int64("k", 0),
+				C.Decimal128("l", dec("12345678901234567891")),
+			),
+		},
+		{
+			"double numbers",
+			&CanonicalOptions{Numbers: NumberDouble},
+			NewDocument(
+				C.Int32("a", 1),
+				C.Int64("b", 2),
+				C.Decimal128("c", dec("0.10")),
+				C.Double("d", math.Copysign(0, -1)),
+			),
+			NewDocument(
+				C.Double("a", 1),
+				C.Double("b", 2),
+				C.Double("c", 0.1),
+				C.Double("d", 0),
+			),
+		},
+		{
+			"unchanged numbers",
+			&CanonicalOptions{Numbers: NumberUnchanged},
+			NewDocument(C.Int32("a", 1), C.Decimal128("b", dec("1.00")), C.Double("c", math.Copysign(0, -1))),
+			NewDocument(C.Int32("a", 1), C.Decimal128("b", dec("1.00")), C.Double("c", math.Copysign(0, -1))),
+		},
+		{
+			"old binary subtype",
+			nil,
+			NewDocument(C.BinaryWithSubtype("a", []byte{1, 2, 3}, 2), C.BinaryWithSubtype("b", []byte{4}, 4)),
+			NewDocument(C.BinaryWithSubtype("a", []byte{1, 2, 3}, 0), C.BinaryWithSubtype("b", []byte{4}, 4)),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.doc.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			want, err := tc.want.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			got, err := CanonicalizeWithOptions(b, tc.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Canonical form does not match. got %v; want %v", got, want)
+			}
+
+			h := sha256.New()
+			err = HashWithOptions(b, h, tc.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sum := sha256.Sum256(want); !bytes.Equal(h.Sum(nil), sum[:]) {
+				t.Errorf("Hash is not the hash of the canonical form. got %x; want %x", h.Sum(nil), sum)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	hash := func(doc *Document) []byte {
+		b, err := doc.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		h := sha256.New()
+		err = Hash(b, h)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return h.Sum(nil)
+	}
+
+	testCases := []struct {
+		name string
+		a, b *Document
+		want bool
+	}{
+		{
+			"equivalent",
+			NewDocument(C.Int32("a", 1), C.SubDocumentFromElements("b", C.Double("c", 2), C.Double("d", math.NaN()))),
+			NewDocument(C.SubDocumentFromElements("b", C.Double("d", -math.NaN()), C.Int64("c", 2)), C.Double("a", 1)),
+			true,
+		},
+		{
+			"different",
+			NewDocument(C.Int32("a", 1)),
+			NewDocument(C.Int32("a", 2)),
+			false,
+		},
+		{
+			"array order",
+			NewDocument(C.ArrayFromElements("a", AC.Int32(1), AC.Int32(2))),
+			NewDocument(C.ArrayFromElements("a", AC.Int32(2), AC.Int32(1))),
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bytes.Equal(hash(tc.a), hash(tc.b)); got != tc.want {
+				t.Errorf("Unexpected hash comparison. got %t; want %t", got, tc.want)
+			}
+		})
+	}
+	t.Run("invalid", func(t *testing.T) {
+		err := Hash(Reader{0x05, 0x00, 0x00, 0x00}, sha256.New())
+		if err != ErrTooSmall {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrTooSmall)
+		}
+	})
+}
@@ -0,0 +1,591 @@
+// Package collection provides an in-memory MongoDB collection, intended as a fake for tests.
+//
+// A Collection stores documents keyed by their _id field, which is generated with objectid.New
+// when an inserted document does not have one. Documents are matched by the query package,
+// sorted by query.Sort, projected by the projection package, and modified by the update package,
+// so filters, sort specifications, projections, and update documents have the same meaning as
+// they do in MongoDB.
+//
+// Secondary indexes may be created on one or more fields with CreateIndex. An index may be unique,
+// in which case inserts and updates which would give two documents the same key fail with a
+// DuplicateKeyError. When an indexed field holds an array the index is multikey and has an entry
+// for each element. Indexes are maintained with the keystring package, and when a filter
+// constrains the first field of an index with an equality, $in, or range condition, Find, Count,
+// Update, and Delete only examine the documents within the index bounds.
+//
+// Documents are returned in insertion order unless a sort is given. A Collection is safe for
+// concurrent use.
+package collection
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/keystring"
+	"github.com/skriptble/wilson/bson/objectid"
+	"github.com/skriptble/wilson/bson/projection"
+	"github.com/skriptble/wilson/bson/query"
+	"github.com/skriptble/wilson/bson/update"
+)
+
+// ErrIDChanged is returned when an update would change the _id of a document.
+var ErrIDChanged = errors.New("collection: the _id field cannot be changed")
+
+// ErrArrayID is returned when the _id of a document is an array.
+var ErrArrayID = errors.New("collection: the _id field cannot be an array")
+
+// DuplicateKeyError is returned when an insert, update, or index creation would give two documents
+// the same key in a unique index.
+type DuplicateKeyError struct {
+	// Index is the name of the unique index.
+	Index string
+	// Key holds the duplicated values of the fields of the index.
+	Key *bson.Document
+}
+
+func (dke DuplicateKeyError) Error() string {
+	return fmt.Sprintf("collection: duplicate key in index %q", dke.Index)
+}
+
+// UpdateResult describes the outcome of Update.
+type UpdateResult struct {
+	// Matched is the number of documents which matched the filter.
+	Matched int
+	// Modified is the number of documents which were changed by the update.
+	Modified int
+	// UpsertedID is the _id of the document inserted by an upsert, or nil if none was inserted.
+	UpsertedID *bson.Value
+}
+
+// Collection is an in-memory collection of documents.
+type Collection struct {
+	mu   sync.RWMutex
+	docs map[string]*record
+	seq  uint64
+	// indexes[0] is the index on _id.
+	indexes []*index
+}
+
+// record is a stored document.
+type record struct {
+	id  string
+	doc bson.Reader
+	// seq is the position of the record in insertion order.
+	seq uint64
+}
+
+// New returns an empty collection with an index on _id.
+func New() *Collection {
+	id, err := newIndex(Index{Keys: bson.NewDocument(bson.C.Int32("_id", 1)), Name: "_id_", Unique: true})
+	if err != nil {
+		panic(err)
+	}
+
+	return &Collection{docs: make(map[string]*record), indexes: []*index{id}}
+}
+
+// Insert inserts docs in order and returns their _id values. A document without an _id is stored
+// with a new ObjectID as its first field, and docs themselves are not modified. If a document
+// cannot be inserted, Insert returns the _id values of the documents inserted before it and the
+// error.
+func (c *Collection) Insert(docs ...*bson.Document) ([]*bson.Value, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]*bson.Value, 0, len(docs))
+	for _, d := range docs {
+		b, err := d.MarshalBSON()
+		if err != nil {
+			return ids, err
+		}
+		id, b, err := withID(b)
+		if err != nil {
+			return ids, err
+		}
+		if err := c.insert(b); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Find returns the documents matching filter, ordered by sort and with the first skip documents
+// omitted. If limit is positive at most limit documents are returned. If projection is not nil it
+// is applied to each document. A nil filter matches every document and a nil sort returns the
+// documents in insertion order.
+func (c *Collection) Find(filter, sort *bson.Document, skip, limit int, projection *bson.Document) ([]bson.Reader, error) {
+	m, f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	s, err := compileSort(sort)
+	if err != nil {
+		return nil, err
+	}
+	p, err := compileProjection(projection)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	matched, err := c.match(m, f)
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if s != nil {
+		sortRecords(matched, s)
+	}
+	if skip > len(matched) {
+		skip = len(matched)
+	}
+	if skip > 0 {
+		matched = matched[skip:]
+	}
+	if limit > 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+
+	docs := make([]bson.Reader, 0, len(matched))
+	for _, rec := range matched {
+		if p == nil {
+			docs = append(docs, append(bson.Reader(nil), rec.doc...))
+			continue
+		}
+		doc, err := p.Apply(rec.doc)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// Count returns the number of documents matching filter. A nil filter matches every document.
+func (c *Collection) Count(filter *bson.Document) (int, error) {
+	m, f, err := compileFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	matched, err := c.match(m, f)
+	return len(matched), err
+}
+
+// Update applies the update document upd to the first document matching filter, or to every
+// matching document if multi is true. If no document matches and upsert is true, a document is
+// inserted instead: the fields of the filter which are compared for equality, such as a in
+// {"a": 1} or {"a": {"$eq": 1}}, are set in a new document, and the update is applied to it with
+// $setOnInsert. A replacement document only keeps the _id of the filter.
+//
+// If updating a document fails, the documents updated before it remain updated.
+func (c *Collection) Update(filter, upd *bson.Document, upsert, multi bool) (UpdateResult, error) {
+	var res UpdateResult
+
+	m, f, err := compileFilter(filter)
+	if err != nil {
+		return res, err
+	}
+	u, err := update.Compile(upd)
+	if err != nil {
+		return res, err
+	}
+	if u.IsReplacement() && multi {
+		return res, errors.New("collection: a replacement document cannot update multiple documents")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.match(m, f)
+	if err != nil {
+		return res, err
+	}
+	if !multi && len(matched) > 1 {
+		matched = matched[:1]
+	}
+
+	for _, rec := range matched {
+		res.Matched++
+
+		// Copy the stored document so that a failed update leaves it unchanged.
+		d, err := bson.ReadDocument(append([]byte(nil), rec.doc...))
+		if err != nil {
+			return res, err
+		}
+		if err := u.Apply(d); err != nil {
+			return res, err
+		}
+		b, err := d.MarshalBSON()
+		if err != nil {
+			return res, err
+		}
+		if bytes.Equal(b, rec.doc) {
+			continue
+		}
+
+		if err := c.replace(rec, b); err != nil {
+			return res, err
+		}
+		res.Modified++
+	}
+
+	if res.Matched > 0 || !upsert {
+		return res, nil
+	}
+
+	d, err := upsertDocument(f)
+	if err != nil {
+		return res, err
+	}
+	if u.IsReplacement() {
+		id, err := d.Lookup("_id")
+		d = bson.NewDocument()
+		if err == nil {
+			d.Append(id)
+		}
+		err = u.Apply(d)
+	} else {
+		err = u.Upsert(d)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	b, err := d.MarshalBSON()
+	if err != nil {
+		return res, err
+	}
+	id, b, err := withID(b)
+	if err != nil {
+		return res, err
+	}
+	if err := c.insert(b); err != nil {
+		return res, err
+	}
+	res.UpsertedID = id
+
+	return res, nil
+}
+
+// Delete removes the first document matching filter, or every matching document if multi is true,
+// and returns the number of documents removed.
+func (c *Collection) Delete(filter *bson.Document, multi bool) (int, error) {
+	m, f, err := compileFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.match(m, f)
+	if err != nil {
+		return 0, err
+	}
+	if !multi && len(matched) > 1 {
+		matched = matched[:1]
+	}
+
+	for _, rec := range matched {
+		if err := c.remove(rec); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(matched), nil
+}
+
+// match returns the records matching the filter f, compiled as m, in insertion order.
+func (c *Collection) match(m *query.Matcher, f bson.Reader) ([]*record, error) {
+	var matched []*record
+	for _, rec := range c.candidates(f) {
+		ok, err := m.Matches(rec.doc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, rec)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].seq < matched[j].seq })
+	return matched, nil
+}
+
+// insert stores the document b, which has an _id.
+func (c *Collection) insert(b bson.Reader) error {
+	keys, err := c.keys(b)
+	if err != nil {
+		return err
+	}
+
+	id := string(keys[0][0])
+	if _, ok := c.docs[id]; ok {
+		return c.indexes[0].duplicate(keys[0][0])
+	}
+	if err := c.checkUnique(keys, id); err != nil {
+		return err
+	}
+
+	c.seq++
+	c.docs[id] = &record{id: id, doc: b, seq: c.seq}
+	for i, idx := range c.indexes {
+		idx.insert(keys[i], id)
+	}
+
+	return nil
+}
+
+// replace replaces the document of rec with b, which must have the same _id.
+func (c *Collection) replace(rec *record, b bson.Reader) error {
+	keys, err := c.keys(b)
+	if err != nil {
+		return err
+	}
+	if string(keys[0][0]) != rec.id {
+		return ErrIDChanged
+	}
+	if err := c.checkUnique(keys, rec.id); err != nil {
+		return err
+	}
+
+	old, err := c.keys(rec.doc)
+	if err != nil {
+		return err
+	}
+	for i, idx := range c.indexes {
+		idx.remove(old[i], rec.id)
+		idx.insert(keys[i], rec.id)
+	}
+	rec.doc = b
+
+	return nil
+}
+
+// remove removes rec from the collection.
+func (c *Collection) remove(rec *record) error {
+	keys, err := c.keys(rec.doc)
+	if err != nil {
+		return err
+	}
+
+	for i, idx := range c.indexes {
+		idx.remove(keys[i], rec.id)
+	}
+	delete(c.docs, rec.id)
+
+	return nil
+}
+
+// keys returns the keys of the document b in each index.
+func (c *Collection) keys(b bson.Reader) ([][][]byte, error) {
+	keys := make([][][]byte, len(c.indexes))
+	for i, idx := range c.indexes {
+		k, err := idx.keys(b)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+
+	return keys, nil
+}
+
+// checkUnique returns a DuplicateKeyError if a document other than the one with the _id key id
+// has any of keys in a unique index.
+func (c *Collection) checkUnique(keys [][][]byte, id string) error {
+	for i, idx := range c.indexes {
+		if !idx.spec.Unique {
+			continue
+		}
+		for _, key := range keys[i] {
+			if idx.contains(key, id) {
+				return idx.duplicate(key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withID returns the _id of the document b, adding a new ObjectID as its first field if it has
+// none.
+func withID(b bson.Reader) (*bson.Value, bson.Reader, error) {
+	elem, err := b.Lookup("_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	if elem != nil {
+		if elem.Value().Type() == bson.TypeArray {
+			return nil, nil, ErrArrayID
+		}
+		return elem.Clone().Value(), b, nil
+	}
+
+	d, err := bson.ReadDocument(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	id := bson.AC.ObjectID(objectid.New())
+	d.Prepend(bson.C.Value("_id", id))
+	b, err = d.MarshalBSON()
+	return id, b, err
+}
+
+// upsertDocument returns the document inserted by an upsert with the filter f, which contains the
+// fields of f compared for equality.
+func upsertDocument(f bson.Reader) (*bson.Document, error) {
+	d := bson.NewDocument()
+	err := equalityFields(f, func(path string, v *bson.Value) error {
+		return d.SetPath(path, v)
+	})
+
+	return d, err
+}
+
+// equalityFields calls set with each path and value which the filter f compares for equality at
+// its top level or within $and.
+func equalityFields(f bson.Reader, set func(path string, v *bson.Value) error) error {
+	itr, err := f.Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+
+		switch {
+		case key == "$and":
+			if v.Type() != bson.TypeArray {
+				continue
+			}
+			aitr, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return err
+			}
+			for aitr.Next() {
+				clause := aitr.Element().Value()
+				if clause.Type() != bson.TypeEmbeddedDocument {
+					continue
+				}
+				if err := equalityFields(clause.ReaderDocument(), set); err != nil {
+					return err
+				}
+			}
+			if aitr.Err() != nil {
+				return aitr.Err()
+			}
+		case len(key) > 0 && key[0] == '$':
+		case isOperators(v):
+			eq, err := v.ReaderDocument().Lookup("$eq")
+			if err != nil {
+				return err
+			}
+			if eq != nil {
+				if err := set(key, eq.Clone().Value()); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := set(key, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return itr.Err()
+}
+
+// isOperators reports whether v is a document of query operators, such as {"$gt": 1}.
+func isOperators(v *bson.Value) bool {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return false
+	}
+
+	elem, err := v.ReaderDocument().ElementAt(0)
+	return err == nil && len(elem.Key()) > 0 && elem.Key()[0] == '$'
+}
+
+// compileFilter compiles filter, which matches every document if it is nil, and returns it as a
+// Reader.
+func compileFilter(filter *bson.Document) (*query.Matcher, bson.Reader, error) {
+	if filter == nil {
+		filter = bson.NewDocument()
+	}
+	b, err := filter.MarshalBSON()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := query.CompileReader(b)
+	return m, b, err
+}
+
+func compileSort(spec *bson.Document) (*query.Sort, error) {
+	if spec == nil || spec.Len() == 0 {
+		return nil, nil
+	}
+	b, err := spec.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return query.CompileSort(b)
+}
+
+func compileProjection(spec *bson.Document) (*projection.Projection, error) {
+	if spec == nil || spec.Len() == 0 {
+		return nil, nil
+	}
+
+	return projection.Compile(spec)
+}
+
+// sortRecords sorts records by s, keeping records which s does not order in their current order.
+func sortRecords(records []*record, s *query.Sort) {
+	type keyed struct {
+		rec *record
+		key []*bson.Value
+	}
+
+	sorted := make([]keyed, len(records))
+	for i, rec := range records {
+		sorted[i] = keyed{rec: rec, key: s.Key(rec.doc)}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return s.CompareKeys(sorted[i].key, sorted[j].key) < 0
+	})
+
+	for i, k := range sorted {
+		records[i] = k.rec
+	}
+}
+
+// decodeKey returns the values of the fields of idx in the key.
+func decodeKey(idx *index, key []byte) *bson.Document {
+	values, err := keystring.Decode(key, idx.dirs)
+	d := bson.NewDocument()
+	if err != nil {
+		return d
+	}
+	for i, f := range idx.fields {
+		if i < len(values) {
+			d.Append(bson.C.Value(f, values[i]))
+		}
+	}
+
+	return d
+}
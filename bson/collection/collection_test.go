@@ -0,0 +1,471 @@
+package collection
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func doc(elems ...*bson.Element) *bson.Document {
+	return bson.NewDocument(elems...)
+}
+
+// fixture returns a collection holding the documents used by the tests.
+func fixture(t *testing.T) *Collection {
+	t.Helper()
+
+	c := New()
+	_, err := c.Insert(
+		doc(C.Int32("_id", 1), C.String("name", "a"), C.Int32("qty", 5), C.ArrayFromElements("tags", AC.String("x"), AC.String("y"))),
+		doc(C.Int32("_id", 2), C.String("name", "b"), C.Int32("qty", 15), C.ArrayFromElements("tags", AC.String("y"))),
+		doc(C.Int32("_id", 3), C.String("name", "c"), C.Double("qty", 10), C.SubDocumentFromElements("size", C.Int32("h", 2))),
+		doc(C.Int32("_id", 4), C.String("name", "d"), C.String("qty", "many"), C.ArrayFromElements("size", AC.DocumentFromElements(C.Int32("h", 7)))),
+		doc(C.Int32("_id", 5), C.String("name", "e")),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return c
+}
+
+// ids returns the _id values of docs.
+func ids(t *testing.T, docs []bson.Reader) []int32 {
+	t.Helper()
+
+	var got []int32
+	for _, d := range docs {
+		elem, err := d.Lookup("_id")
+		if err != nil || elem == nil {
+			t.Fatalf("Document without _id: %v", err)
+		}
+		got = append(got, elem.Value().Int32())
+	}
+	return got
+}
+
+func TestInsert(t *testing.T) {
+	c := New()
+
+	d := doc(C.String("name", "a"))
+	got, err := c.Insert(d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].Type() != bson.TypeObjectID {
+		t.Fatalf("Expected a generated ObjectID. got %v", got)
+	}
+	if d.Len() != 1 {
+		t.Errorf("The inserted document was modified.")
+	}
+
+	docs, err := c.Find(nil, nil, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want, err := doc(C.Value("_id", got[0]), C.String("name", "a")).MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(docs) != 1 || !bytes.Equal(want, docs[0]) {
+		t.Errorf("Unexpected result. got %v; want %v", docs, bson.Reader(want))
+	}
+
+	inserted, err := c.Insert(doc(C.Int32("_id", 1)), doc(C.Double("_id", 1)), doc(C.Int32("_id", 2)))
+	if _, ok := err.(DuplicateKeyError); !ok {
+		t.Errorf("Did not get expected error. got %v; want a DuplicateKeyError", err)
+	}
+	if len(inserted) != 1 {
+		t.Errorf("Unexpected number of inserted documents. got %d; want %d", len(inserted), 1)
+	}
+
+	_, err = c.Insert(doc(C.ArrayFromElements("_id", AC.Int32(1))))
+	if err != ErrArrayID {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrArrayID)
+	}
+}
+
+func TestFind(t *testing.T) {
+	testCases := []struct {
+		name       string
+		filter     *bson.Document
+		sort       *bson.Document
+		skip       int
+		limit      int
+		projection *bson.Document
+		want       []int32
+	}{
+		{"all", nil, nil, 0, 0, nil, []int32{1, 2, 3, 4, 5}},
+		{"equality", doc(C.String("name", "c")), nil, 0, 0, nil, []int32{3}},
+		{"range", doc(C.SubDocumentFromElements("qty", C.Int32("$gte", 10))), nil, 0, 0, nil, []int32{2, 3}},
+		{"array element", doc(C.String("tags", "y")), nil, 0, 0, nil, []int32{1, 2}},
+		{"dotted path", doc(C.SubDocumentFromElements("size.h", C.Int32("$gt", 1))), nil, 0, 0, nil, []int32{3, 4}},
+		{"sort", nil, doc(C.Int32("qty", -1)), 0, 0, nil, []int32{4, 2, 3, 1, 5}},
+		{"skip and limit", nil, doc(C.Int32("_id", -1)), 1, 2, nil, []int32{4, 3}},
+		{"skip past end", nil, nil, 10, 0, nil, nil},
+		{"projection", doc(C.Int32("_id", 2)), nil, 0, 0, doc(C.Int32("name", 1)), []int32{2}},
+	}
+
+	c := fixture(t)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, err := c.Find(tc.filter, tc.sort, tc.skip, tc.limit, tc.projection)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := ids(t, docs); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("Unexpected result. got %v; want %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("projected fields", func(t *testing.T) {
+		docs, err := c.Find(doc(C.Int32("_id", 2)), nil, 0, 0, doc(C.Int32("name", 1)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got, err := bson.ReadDocument(docs[0])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		bsontest.AssertEqual(t, got, doc(C.Int32("_id", 2), C.String("name", "b")))
+	})
+}
+
+func TestCount(t *testing.T) {
+	c := fixture(t)
+
+	n, err := c.Count(doc(C.SubDocumentFromElements("qty", C.Boolean("$exists", true))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Unexpected count. got %d; want %d", n, 4)
+	}
+
+	_, err = c.Count(doc(C.Int32("$frob", 1)))
+	if err == nil {
+		t.Errorf("Expected an error for an invalid filter.")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter *bson.Document
+		update *bson.Document
+		upsert bool
+		multi  bool
+		want   UpdateResult
+		// after is a filter which count documents match after the update.
+		after *bson.Document
+		count int
+	}{
+		{
+			"single",
+			doc(C.String("tags", "y")),
+			doc(C.SubDocumentFromElements("$inc", C.Int32("qty", 1))),
+			false, false,
+			UpdateResult{Matched: 1, Modified: 1},
+			doc(C.Int32("_id", 1), C.Int32("qty", 6)),
+			1,
+		},
+		{
+			"multi",
+			doc(C.String("tags", "y")),
+			doc(C.SubDocumentFromElements("$set", C.Boolean("seen", true))),
+			false, true,
+			UpdateResult{Matched: 2, Modified: 2},
+			doc(C.Boolean("seen", true)),
+			2,
+		},
+		{
+			"not modified",
+			doc(C.Int32("_id", 1)),
+			doc(C.SubDocumentFromElements("$set", C.String("name", "a"))),
+			false, false,
+			UpdateResult{Matched: 1},
+			doc(C.String("name", "a")),
+			1,
+		},
+		{
+			"replacement",
+			doc(C.Int32("_id", 5)),
+			doc(C.String("name", "z")),
+			false, false,
+			UpdateResult{Matched: 1, Modified: 1},
+			doc(C.Int32("_id", 5), C.String("name", "z")),
+			1,
+		},
+		{
+			"no match",
+			doc(C.Int32("_id", 9)),
+			doc(C.SubDocumentFromElements("$set", C.String("name", "z"))),
+			false, false,
+			UpdateResult{},
+			doc(C.String("name", "z")),
+			0,
+		},
+		{
+			"upsert",
+			doc(C.Int32("_id", 9), C.SubDocumentFromElements("kind", C.String("$eq", "new")), C.SubDocumentFromElements("qty", C.Int32("$gt", 1))),
+			doc(C.SubDocumentFromElements("$set", C.String("name", "z")), C.SubDocumentFromElements("$setOnInsert", C.Int32("qty", 3))),
+			true, false,
+			UpdateResult{UpsertedID: AC.Int32(9)},
+			doc(C.Int32("_id", 9), C.String("kind", "new"), C.String("name", "z"), C.Int32("qty", 3)),
+			1,
+		},
+		{
+			"upsert replacement",
+			doc(C.Int32("_id", 9), C.String("kind", "new")),
+			doc(C.String("name", "z")),
+			true, false,
+			UpdateResult{UpsertedID: AC.Int32(9)},
+			doc(C.Int32("_id", 9), C.SubDocumentFromElements("kind", C.Boolean("$exists", false)), C.String("name", "z")),
+			1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := fixture(t)
+			got, err := c.Update(tc.filter, tc.update, tc.upsert, tc.multi)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got.Matched != tc.want.Matched || got.Modified != tc.want.Modified {
+				t.Errorf("Unexpected result. got %+v; want %+v", got, tc.want)
+			}
+			if (got.UpsertedID == nil) != (tc.want.UpsertedID == nil) || got.UpsertedID != nil && !got.UpsertedID.Equal(tc.want.UpsertedID) {
+				t.Errorf("Unexpected upserted _id. got %v; want %v", got.UpsertedID, tc.want.UpsertedID)
+			}
+
+			n, err := c.Count(tc.after)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if n != tc.count {
+				t.Errorf("Unexpected number of updated documents. got %d; want %d", n, tc.count)
+			}
+		})
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	c := fixture(t)
+	_, err := c.CreateIndex(Index{Keys: doc(C.Int32("name", 1)), Unique: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = c.Update(doc(C.Int32("_id", 1)), doc(C.SubDocumentFromElements("$set", C.Int32("_id", 7))), false, false)
+	if err != ErrIDChanged {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrIDChanged)
+	}
+
+	_, err = c.Update(doc(C.Int32("_id", 1)), doc(C.SubDocumentFromElements("$set", C.String("name", "b"))), false, false)
+	dke, ok := err.(DuplicateKeyError)
+	if !ok {
+		t.Fatalf("Did not get expected error. got %v; want a DuplicateKeyError", err)
+	}
+	if dke.Index != "name_1" {
+		t.Errorf("Unexpected index. got %q; want %q", dke.Index, "name_1")
+	}
+	bsontest.AssertEqual(t, dke.Key, doc(C.String("name", "b")))
+
+	n, err := c.Count(doc(C.String("name", "a")))
+	if err != nil || n != 1 {
+		t.Errorf("A failed update modified the document. got %d, %v", n, err)
+	}
+
+	_, err = c.Update(nil, doc(C.String("name", "q")), false, true)
+	if err == nil {
+		t.Errorf("Expected an error for a multi-document replacement.")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	c := fixture(t)
+
+	n, err := c.Delete(doc(C.String("tags", "y")), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Unexpected number of deleted documents. got %d; want %d", n, 1)
+	}
+
+	n, err = c.Delete(doc(C.SubDocumentFromElements("_id", C.Int32("$gte", 3))), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Unexpected number of deleted documents. got %d; want %d", n, 3)
+	}
+
+	docs, err := c.Find(nil, nil, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := ids(t, docs); fmt.Sprint(got) != "[2]" {
+		t.Errorf("Unexpected remaining documents. got %v; want [2]", got)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	filters := []*bson.Document{
+		doc(C.Int32("qty", 10)),
+		doc(C.SubDocumentFromElements("qty", C.Int32("$gt", 5))),
+		doc(C.SubDocumentFromElements("qty", C.Int32("$lte", 10), C.Int32("$gt", 5))),
+		doc(C.SubDocumentFromElements("qty", C.Int32("$lt", 5))),
+		doc(C.SubDocumentFromElements("qty", C.ArrayFromElements("$in", AC.Int32(5), AC.String("many"), AC.Double(15)))),
+		doc(C.Null("qty")),
+		doc(C.String("tags", "y")),
+		doc(C.SubDocumentFromElements("tags", C.String("$gte", "y"))),
+		doc(C.ArrayFromElements("tags", AC.String("y"))),
+		doc(C.Int32("size.h", 7)),
+		doc(C.SubDocumentFromElements("size", C.Int32("h", 2))),
+		doc(C.ArrayFromElements("$and", AC.DocumentFromElements(C.String("name", "a")), AC.DocumentFromElements(C.Int32("qty", 5)))),
+		doc(C.ArrayFromElements("$or", AC.DocumentFromElements(C.String("name", "a")), AC.DocumentFromElements(C.Int32("qty", 15)))),
+	}
+
+	plain := fixture(t)
+	indexed := fixture(t)
+	for _, keys := range []*bson.Document{
+		doc(C.Int32("qty", -1), C.Int32("name", 1)),
+		doc(C.Int32("tags", 1)),
+		doc(C.Int32("size.h", 1)),
+		doc(C.Int32("size", 1)),
+	} {
+		if _, err := indexed.CreateIndex(Index{Keys: keys}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	for _, f := range filters {
+		want, err := plain.Find(f, nil, 0, 0, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got, err := indexed.Find(f, nil, 0, 0, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if fmt.Sprint(ids(t, got)) != fmt.Sprint(ids(t, want)) {
+			t.Errorf("Unexpected result for %v. got %v; want %v", f, ids(t, got), ids(t, want))
+		}
+	}
+
+	b, err := doc(C.Int32("qty", 10)).MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := len(indexed.candidates(b)); n != 1 {
+		t.Errorf("The index on qty was not used. got %d candidates; want %d", n, 1)
+	}
+
+	specs := indexed.Indexes()
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	if fmt.Sprint(names) != "[_id_ qty_-1_name_1 tags_1 size.h_1 size_1]" {
+		t.Errorf("Unexpected indexes. got %v", names)
+	}
+
+	if err := indexed.DropIndex("tags_1"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := indexed.DropIndex("tags_1"); err != ErrIndexNotFound {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrIndexNotFound)
+	}
+	if err := indexed.DropIndex("_id_"); err == nil {
+		t.Errorf("Expected an error dropping the _id index.")
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	c := fixture(t)
+
+	_, err := c.CreateIndex(Index{Keys: doc(C.Int32("tags", 1)), Unique: true})
+	if _, ok := err.(DuplicateKeyError); !ok {
+		t.Fatalf("Did not get expected error. got %v; want a DuplicateKeyError", err)
+	}
+	if len(c.Indexes()) != 1 {
+		t.Errorf("An index was created despite duplicate keys.")
+	}
+
+	name, err := c.CreateIndex(Index{Keys: doc(C.Int32("name", 1), C.Int32("qty", 1)), Name: "nq", Unique: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if name != "nq" {
+		t.Errorf("Unexpected name. got %q; want %q", name, "nq")
+	}
+	if _, err := c.CreateIndex(Index{Keys: doc(C.Int32("name", 1), C.Int32("qty", 1)), Name: "nq", Unique: true}); err != nil {
+		t.Errorf("Unexpected error recreating an index: %v", err)
+	}
+	if _, err := c.CreateIndex(Index{Keys: doc(C.Int32("name", 1)), Name: "nq"}); err == nil {
+		t.Errorf("Expected an error for a conflicting index.")
+	}
+
+	if _, err := c.Insert(doc(C.String("name", "a"), C.Int32("qty", 6))); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := c.Insert(doc(C.String("name", "a"), C.Double("qty", 5))); err == nil {
+		t.Errorf("Expected a duplicate key error.")
+	}
+	if _, err := c.Insert(doc(C.String("name", "e"))); err == nil {
+		t.Errorf("Expected a duplicate key error for a missing field.")
+	}
+
+	if _, err := c.Delete(doc(C.String("name", "e")), false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := c.Insert(doc(C.String("name", "e"))); err != nil {
+		t.Errorf("Unexpected error after deleting the duplicate: %v", err)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	c := New()
+	if _, err := c.CreateIndex(Index{Keys: doc(C.Int32("n", 1))}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := c.Insert(doc(C.Int32("n", int32(i)))); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if _, err := c.Update(doc(C.Int32("n", int32(i))), doc(C.SubDocumentFromElements("$inc", C.Int32("c", 1))), false, false); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if _, err := c.Find(doc(C.Int32("n", int32(i))), nil, 0, 0, nil); err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	n, err := c.Count(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 400 {
+		t.Errorf("Unexpected count. got %d; want %d", n, 400)
+	}
+}
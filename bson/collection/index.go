@@ -0,0 +1,372 @@
+package collection
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/keystring"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+// ErrIndexNotFound is returned by DropIndex when there is no index with the given name.
+var ErrIndexNotFound = errors.New("collection: index not found")
+
+// Index describes an index of a collection.
+type Index struct {
+	// Keys holds the dotted path of each field of the index with the value 1 for ascending order
+	// or -1 for descending order, such as {"a": 1, "b.c": -1}.
+	Keys *bson.Document
+	// Name is the name of the index. CreateIndex generates a name from the keys if it is empty,
+	// such as "a_1_b.c_-1".
+	Name   string
+	Unique bool
+}
+
+// index is an index of a collection. Its entries are sorted by key, and then by the _id key of the
+// document they belong to.
+type index struct {
+	spec    Index
+	fields  []string
+	segs    [][]string
+	dirs    []keystring.Direction
+	entries []entry
+}
+
+// entry is an entry of an index for the document with the _id key id.
+type entry struct {
+	key []byte
+	id  string
+}
+
+// CreateIndex creates an index and returns its name. Creating an index which already exists with
+// the same keys and options does nothing. If the index is unique and two documents in the
+// collection have the same key, a DuplicateKeyError is returned and the index is not created.
+func (c *Collection) CreateIndex(spec Index) (string, error) {
+	idx, err := newIndex(spec)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, other := range c.indexes {
+		sameKeys := other.spec.Keys.Equal(idx.spec.Keys)
+		switch {
+		case other.spec.Name == idx.spec.Name && sameKeys && other.spec.Unique == idx.spec.Unique:
+			return idx.spec.Name, nil
+		case other.spec.Name == idx.spec.Name:
+			return "", fmt.Errorf("collection: an index named %q already exists with different keys or options", idx.spec.Name)
+		case sameKeys:
+			return "", fmt.Errorf("collection: an index with the same keys already exists with the name %q", other.spec.Name)
+		}
+	}
+
+	for id, rec := range c.docs {
+		keys, err := idx.keys(rec.doc)
+		if err != nil {
+			return "", err
+		}
+		for _, key := range keys {
+			if idx.spec.Unique && idx.contains(key, id) {
+				return "", idx.duplicate(key)
+			}
+			idx.insert([][]byte{key}, id)
+		}
+	}
+	c.indexes = append(c.indexes, idx)
+
+	return idx.spec.Name, nil
+}
+
+// DropIndex drops the index with the given name. The index on _id cannot be dropped.
+func (c *Collection) DropIndex(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, idx := range c.indexes {
+		if idx.spec.Name != name {
+			continue
+		}
+		if i == 0 {
+			return errors.New("collection: the _id index cannot be dropped")
+		}
+		c.indexes = append(c.indexes[:i], c.indexes[i+1:]...)
+		return nil
+	}
+
+	return ErrIndexNotFound
+}
+
+// Indexes returns the indexes of the collection, starting with the index on _id.
+func (c *Collection) Indexes() []Index {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	specs := make([]Index, len(c.indexes))
+	for i, idx := range c.indexes {
+		specs[i] = idx.spec
+		// The keys were read from valid BSON, so copying them cannot fail.
+		b, _ := idx.spec.Keys.MarshalBSON()
+		specs[i].Keys, _ = bson.ReadDocument(b)
+	}
+
+	return specs
+}
+
+// newIndex returns an empty index for spec.
+func newIndex(spec Index) (*index, error) {
+	if spec.Keys == nil || spec.Keys.Len() == 0 {
+		return nil, errors.New("collection: an index must have at least one key")
+	}
+	b, err := spec.Keys.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := bson.ReadDocument(b)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &index{spec: Index{Keys: keys, Name: spec.Name, Unique: spec.Unique}}
+	var name []string
+	itr := keys.Iterator()
+	for itr.Next() {
+		elem := itr.Element()
+		path := elem.Key()
+
+		segs := strings.Split(path, ".")
+		for _, seg := range segs {
+			if seg == "" || strings.HasPrefix(seg, "$") {
+				return nil, fmt.Errorf("collection: invalid index key %q", path)
+			}
+		}
+
+		n, ok := direction(elem.Value())
+		if !ok {
+			return nil, fmt.Errorf("collection: the order of index key %q must be 1 or -1", path)
+		}
+		dir := keystring.Ascending
+		if n < 0 {
+			dir = keystring.Descending
+		}
+
+		idx.fields = append(idx.fields, path)
+		idx.segs = append(idx.segs, segs)
+		idx.dirs = append(idx.dirs, dir)
+		name = append(name, path, strconv.Itoa(n))
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+
+	if idx.spec.Name == "" {
+		idx.spec.Name = strings.Join(name, "_")
+	}
+
+	return idx, nil
+}
+
+// direction returns the order of an index key, which is 1 or -1.
+func direction(v *bson.Value) (int, bool) {
+	var f float64
+	switch v.Type() {
+	case bson.TypeInt32:
+		f = float64(v.Int32())
+	case bson.TypeInt64:
+		f = float64(v.Int64())
+	case bson.TypeDouble:
+		f = v.Double()
+	default:
+		return 0, false
+	}
+
+	if f != 1 && f != -1 {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// keys returns the distinct keys of the valid document b. A document has a key for each
+// combination of the values of its fields, and a missing field has the value null.
+func (idx *index) keys(b bson.Reader) ([][]byte, error) {
+	root := bson.AC.DocumentFromReader(b)
+
+	keys := [][]byte{nil}
+	for i, segs := range idx.segs {
+		var values []*bson.Value
+		pathValues(root, segs, &values)
+		if len(values) == 0 {
+			values = append(values, bson.AC.Null())
+		}
+
+		next := make([][]byte, 0, len(keys)*len(values))
+		for _, key := range keys {
+			for _, v := range values {
+				k, err := keystring.AppendValue(append([]byte(nil), key...), v, idx.dirs[i])
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, k)
+			}
+		}
+		keys = next
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	distinct := keys[:0]
+	for i, key := range keys {
+		if i == 0 || !bytes.Equal(key, keys[i-1]) {
+			distinct = append(distinct, key)
+		}
+	}
+
+	return distinct, nil
+}
+
+// pathValues appends the values at the path segs within v to values, in the same way as a query
+// matches a path: arrays along the path are searched by searching each document in them or by
+// selecting the element at a numeric segment, and an array at the end of the path contributes
+// each of its elements, or undefined if it is empty.
+func pathValues(v *bson.Value, segs []string, values *[]*bson.Value) {
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		elem, err := v.ReaderDocument().Lookup(segs[0])
+		if err != nil || elem == nil {
+			return
+		}
+		leafValues(elem.Value(), segs[1:], values)
+	case bson.TypeArray:
+		itr, err := v.ReaderArray().Iterator()
+		if err != nil {
+			return
+		}
+		for itr.Next() {
+			elem := itr.Element().Clone()
+			if elem.Value().Type() == bson.TypeEmbeddedDocument {
+				pathValues(elem.Value(), segs, values)
+			}
+			if elem.Key() == segs[0] {
+				leafValues(elem.Value(), segs[1:], values)
+			}
+		}
+	}
+}
+
+// leafValues appends the values at the rest of a path within child, which is the value at the
+// path so far.
+func leafValues(child *bson.Value, rest []string, values *[]*bson.Value) {
+	if len(rest) > 0 {
+		pathValues(child, rest, values)
+		return
+	}
+	if child.Type() != bson.TypeArray {
+		*values = append(*values, child)
+		return
+	}
+
+	n := len(*values)
+	itr, err := child.ReaderArray().Iterator()
+	if err != nil {
+		return
+	}
+	for itr.Next() {
+		*values = append(*values, itr.Element().Clone().Value())
+	}
+	if len(*values) == n {
+		*values = append(*values, bson.AC.Undefined())
+	}
+}
+
+// search returns the position of the first entry which is not less than the entry for key and id.
+func (idx *index) search(key []byte, id string) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		e := idx.entries[i]
+		if c := bytes.Compare(e.key, key); c != 0 {
+			return c > 0
+		}
+		return e.id >= id
+	})
+}
+
+func (idx *index) insert(keys [][]byte, id string) {
+	for _, key := range keys {
+		i := idx.search(key, id)
+		idx.entries = append(idx.entries, entry{})
+		copy(idx.entries[i+1:], idx.entries[i:])
+		idx.entries[i] = entry{key: key, id: id}
+	}
+}
+
+func (idx *index) remove(keys [][]byte, id string) {
+	for _, key := range keys {
+		i := idx.search(key, id)
+		if i < len(idx.entries) && idx.entries[i].id == id && bytes.Equal(idx.entries[i].key, key) {
+			idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+		}
+	}
+}
+
+// contains reports whether a document other than the one with the _id key id has the key.
+func (idx *index) contains(key []byte, id string) bool {
+	for i := idx.search(key, ""); i < len(idx.entries) && bytes.Equal(idx.entries[i].key, key); i++ {
+		if idx.entries[i].id != id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (idx *index) duplicate(key []byte) DuplicateKeyError {
+	return DuplicateKeyError{Index: idx.spec.Name, Key: decodeKey(idx, key)}
+}
+
+// scan calls visit with the _id key of each document with an entry whose first field is between lo
+// and hi inclusive.
+func (idx *index) scan(lo, hi *bson.Value, visit func(id string)) {
+	start, err := keystring.AppendValue(nil, lo, idx.dirs[0])
+	if err != nil {
+		return
+	}
+	end, err := keystring.AppendValue(nil, hi, idx.dirs[0])
+	if err != nil {
+		return
+	}
+	if idx.dirs[0] == keystring.Descending {
+		start, end = end, start
+	}
+
+	// The encoding of a value is never a prefix of the encoding of another, so every key whose
+	// first field is at most hi either starts with end or is less than it within its length.
+	for i := idx.search(start, ""); i < len(idx.entries); i++ {
+		key := idx.entries[i].key
+		if len(key) > len(end) {
+			key = key[:len(end)]
+		}
+		if bytes.Compare(key, end) > 0 {
+			return
+		}
+		visit(idx.entries[i].id)
+	}
+}
+
+// brackets holds, for each type whose values may be bounded by range operators, the smallest value
+// of its type bracket and a value which is at least every value in the bracket.
+var brackets = map[bson.Type][2]*bson.Value{
+	bson.TypeInt32:      {bson.AC.Double(math.NaN()), bson.AC.Double(math.Inf(1))},
+	bson.TypeInt64:      {bson.AC.Double(math.NaN()), bson.AC.Double(math.Inf(1))},
+	bson.TypeDouble:     {bson.AC.Double(math.NaN()), bson.AC.Double(math.Inf(1))},
+	bson.TypeDecimal128: {bson.AC.Double(math.NaN()), bson.AC.Double(math.Inf(1))},
+	bson.TypeString:     {bson.AC.String(""), bson.AC.DocumentFromElements()},
+	bson.TypeSymbol:     {bson.AC.String(""), bson.AC.DocumentFromElements()},
+	bson.TypeObjectID:   {bson.AC.ObjectID(objectid.ObjectID{}), bson.AC.Boolean(false)},
+	bson.TypeDateTime:   {bson.AC.DateTime(math.MinInt64), bson.AC.Timestamp(0, 0)},
+	bson.TypeTimestamp:  {bson.AC.Timestamp(0, 0), bson.AC.Regex("", "")},
+}
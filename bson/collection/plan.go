@@ -0,0 +1,155 @@
+package collection
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+// interval is a range of values of the first field of an index. Both ends are inclusive.
+type interval struct {
+	lo, hi *bson.Value
+}
+
+// candidates returns the records which may match the filter f. If f constrains the first field of
+// an index, only the records with an entry within the bounds of the constraint are returned. The
+// first such index is used, so a filter on _id always uses the index on _id.
+func (c *Collection) candidates(f bson.Reader) []*record {
+	for _, idx := range c.indexes {
+		intervals, ok := bounds(f, idx.fields[0])
+		if !ok {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var records []*record
+		for _, iv := range intervals {
+			idx.scan(iv.lo, iv.hi, func(id string) {
+				if !seen[id] {
+					seen[id] = true
+					records = append(records, c.docs[id])
+				}
+			})
+		}
+		return records
+	}
+
+	records := make([]*record, 0, len(c.docs))
+	for _, rec := range c.docs {
+		records = append(records, rec)
+	}
+	return records
+}
+
+// bounds returns intervals which contain a value of the field path of every document matching the
+// filter f, and false if f has no condition on path which can be expressed as intervals. Only
+// conditions at the top level of f or within $and are used.
+func bounds(f bson.Reader, path string) ([]interval, bool) {
+	itr, err := f.Iterator()
+	if err != nil {
+		return nil, false
+	}
+
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+
+		switch {
+		case key == "$and" && v.Type() == bson.TypeArray:
+			aitr, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return nil, false
+			}
+			for aitr.Next() {
+				clause := aitr.Element().Clone().Value()
+				if clause.Type() != bson.TypeEmbeddedDocument {
+					continue
+				}
+				if intervals, ok := bounds(clause.ReaderDocument(), path); ok {
+					return intervals, true
+				}
+			}
+		case key == path:
+			if intervals, ok := fieldBounds(v); ok {
+				return intervals, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// fieldBounds returns the intervals of the values matched by the condition v on a field. Only
+// equality, $eq, $in, $gt, $gte, $lt, and $lte are used, and the other operators of v are
+// ignored, since the documents within the intervals are matched against the whole filter.
+func fieldBounds(v *bson.Value) ([]interval, bool) {
+	if !isOperators(v) {
+		return pointBounds(v)
+	}
+
+	itr, err := v.ReaderDocument().Iterator()
+	if err != nil {
+		return nil, false
+	}
+
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		op, operand := elem.Key(), elem.Value()
+
+		switch op {
+		case "$eq":
+			if intervals, ok := pointBounds(operand); ok {
+				return intervals, true
+			}
+		case "$in":
+			if intervals, ok := inBounds(operand); ok {
+				return intervals, true
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			// The bounds of a lower and an upper limit are not intersected, since different
+			// elements of an array may satisfy each limit.
+			bracket, ok := brackets[operand.Type()]
+			if !ok {
+				continue
+			}
+			if op == "$lt" || op == "$lte" {
+				return []interval{{lo: bracket[0], hi: operand}}, true
+			}
+			return []interval{{lo: operand, hi: bracket[1]}}, true
+		}
+	}
+
+	return nil, false
+}
+
+// inBounds returns an interval for each value of the operand of $in.
+func inBounds(v *bson.Value) ([]interval, bool) {
+	if v.Type() != bson.TypeArray {
+		return nil, false
+	}
+
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return nil, false
+	}
+
+	var intervals []interval
+	for itr.Next() {
+		point, ok := pointBounds(itr.Element().Clone().Value())
+		if !ok {
+			return nil, false
+		}
+		intervals = append(intervals, point...)
+	}
+
+	return intervals, itr.Err() == nil
+}
+
+// pointBounds returns the interval of the values equal to v. Arrays, regular expressions, null,
+// and undefined match values other than those equal to them, so no interval is returned for them.
+func pointBounds(v *bson.Value) ([]interval, bool) {
+	switch v.Type() {
+	case bson.TypeArray, bson.TypeRegex, bson.TypeNull, bson.TypeUndefined:
+		return nil, false
+	}
+
+	return []interval{{lo: v, hi: v}}, true
+}
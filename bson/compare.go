@@ -0,0 +1,218 @@
+package bson
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// typeOrder is the position of each type in the MongoDB sort order. Types with the same position,
+// such as the numeric types, are compared by value.
+var typeOrder = map[Type]int{
+	TypeMinKey:           0,
+	TypeUndefined:        1,
+	TypeNull:             2,
+	TypeDouble:           3,
+	TypeInt32:            3,
+	TypeInt64:            3,
+	TypeDecimal128:       3,
+	TypeString:           4,
+	TypeSymbol:           4,
+	TypeEmbeddedDocument: 5,
+	TypeArray:            6,
+	TypeBinary:           7,
+	TypeObjectID:         8,
+	TypeBoolean:          9,
+	TypeDateTime:         10,
+	TypeTimestamp:        11,
+	TypeRegex:            12,
+	TypeDBPointer:        13,
+	TypeJavaScript:       14,
+	TypeCodeWithScope:    15,
+	TypeMaxKey:           16,
+}
+
+// CompareTypes compares the positions of the types a and b in the MongoDB sort order and returns
+// -1, 0, or +1. The order is MinKey, undefined, null, numbers, strings and symbols, documents,
+// arrays, binary data, ObjectIDs, booleans, datetimes, timestamps, regular expressions,
+// DBPointers, JavaScript code, JavaScript code with scope, and MaxKey.
+func CompareTypes(a, b Type) int {
+	return compareInts(typeOrder[a], typeOrder[b])
+}
+
+// CompareValues compares a and b in the MongoDB sort order and returns -1, 0, or +1. Values of
+// different types are ordered as by CompareTypes. Numbers of different types are compared by
+// value, NaN is less than every other number, and -0 is equal to 0. Strings are compared
+// bytewise. Documents are compared element by element, first by the type of the values, then by
+// key, then by value, and a document which is a prefix of another is less. Arrays are compared
+// in the same way, except that their keys are ignored.
+func CompareValues(a, b *Value) int {
+	if c := CompareTypes(a.Type(), b.Type()); c != 0 {
+		return c
+	}
+
+	switch a.Type() {
+	case TypeDouble, TypeInt32, TypeInt64, TypeDecimal128:
+		return compareNumbers(a, b)
+	case TypeString, TypeSymbol:
+		return strings.Compare(stringOrSymbol(a), stringOrSymbol(b))
+	case TypeEmbeddedDocument:
+		return compareDocuments(a.ReaderDocument(), b.ReaderDocument(), true)
+	case TypeArray:
+		// Arrays built by the constructors may not have index keys, so only the values are compared.
+		return compareDocuments(a.ReaderArray(), b.ReaderArray(), false)
+	case TypeBinary:
+		ab, bb := a.Bytes(), b.Bytes()
+		if c := compareInts(len(ab), len(bb)); c != 0 {
+			return c
+		}
+		return bytes.Compare(ab[4:], bb[4:])
+	case TypeObjectID:
+		ao, bo := a.ObjectID(), b.ObjectID()
+		return bytes.Compare(ao[:], bo[:])
+	case TypeBoolean:
+		ab, bb := a.Boolean(), b.Boolean()
+		switch {
+		case ab == bb:
+			return 0
+		case bb:
+			return -1
+		}
+		return 1
+	case TypeDateTime:
+		return compareInts64(int64(binary.LittleEndian.Uint64(a.Bytes())), int64(binary.LittleEndian.Uint64(b.Bytes())))
+	case TypeTimestamp:
+		// The increment is stored in the low four bytes and the time in the high four bytes.
+		return compareUints(binary.LittleEndian.Uint64(a.Bytes()), binary.LittleEndian.Uint64(b.Bytes()))
+	case TypeRegex:
+		ap, ao := a.Regex()
+		bp, bo := b.Regex()
+		if c := strings.Compare(ap, bp); c != 0 {
+			return c
+		}
+		return strings.Compare(ao, bo)
+	case TypeDBPointer:
+		ab, bb := a.Bytes(), b.Bytes()
+		if c := compareInts(len(ab), len(bb)); c != 0 {
+			return c
+		}
+		return bytes.Compare(ab, bb)
+	case TypeJavaScript:
+		return strings.Compare(a.JavaScript(), b.JavaScript())
+	case TypeCodeWithScope:
+		ac, as := a.ReaderJavaScriptWithScope()
+		bc, bs := b.ReaderJavaScriptWithScope()
+		if c := strings.Compare(ac, bc); c != 0 {
+			return c
+		}
+		return compareDocuments(as, bs, true)
+	}
+
+	// MinKey, undefined, null, and MaxKey have no value.
+	return 0
+}
+
+// compareDocuments compares the documents or arrays a and b element by element, comparing the keys
+// of the elements if keys is true.
+func compareDocuments(a, b Reader, keys bool) int {
+	ea, err := readerElements(a)
+	if err != nil {
+		panic(err)
+	}
+	eb, err := readerElements(b)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < len(ea) && i < len(eb); i++ {
+		if c := CompareTypes(ea[i].value.Type(), eb[i].value.Type()); c != 0 {
+			return c
+		}
+		if keys {
+			if c := bytes.Compare(keyBytes(ea[i]), keyBytes(eb[i])); c != 0 {
+				return c
+			}
+		}
+		if c := CompareValues(ea[i].value, eb[i].value); c != 0 {
+			return c
+		}
+	}
+
+	return compareInts(len(ea), len(eb))
+}
+
+// compareNumbers compares the numbers a and b by value.
+func compareNumbers(a, b *Value) int {
+	if isInteger(a) && isInteger(b) {
+		return compareInts64(integer(a), integer(b))
+	}
+
+	na, _ := numberOf(a)
+	nb, _ := numberOf(b)
+	if c := compareInts(numberRank(na), numberRank(nb)); c != 0 || na.r == nil {
+		return c
+	}
+
+	return na.r.Cmp(nb.r)
+}
+
+// numberRank orders NaN, negative infinity, finite numbers, and positive infinity.
+func numberRank(n number) int {
+	switch {
+	case n.nan:
+		return 0
+	case n.inf && n.neg:
+		return 1
+	case n.inf:
+		return 3
+	}
+
+	return 2
+}
+
+func isInteger(v *Value) bool {
+	return v.Type() == TypeInt32 || v.Type() == TypeInt64
+}
+
+// integer returns the value of the int32 or int64 v.
+func integer(v *Value) int64 {
+	if v.Type() == TypeInt32 {
+		return int64(v.Int32())
+	}
+
+	return v.Int64()
+}
+
+func stringOrSymbol(v *Value) string {
+	if v.Type() == TypeSymbol {
+		return v.Symbol()
+	}
+
+	return v.StringValue()
+}
+
+func compareInts64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+func compareInts(a, b int) int {
+	return compareInts64(int64(a), int64(b))
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
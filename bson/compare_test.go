@@ -0,0 +1,114 @@
+package bson
+
+import (
+	"math"
+	"testing"
+
+	"github.com/skriptble/wilson/bson/decimal"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+func TestCompareValues(t *testing.T) {
+	dec := func(s string) decimal.Decimal128 {
+		d, err := decimal.ParseDecimal128(s)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %q: %v", s, err)
+		}
+		return d
+	}
+	// readerArray returns an array with the values vs read from a marshaled document, so that its
+	// elements have index keys.
+	readerArray := func(vs ...*Value) *Value {
+		b, err := NewDocument(C.ArrayFromElements("a", vs...)).MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		elem, err := Reader(b).Lookup("a")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return elem.Value()
+	}
+
+	testCases := []struct {
+		name string
+		a, b *Value
+		want int
+	}{
+		{"minKey and null", AC.MinKey(), AC.Null(), -1},
+		{"undefined and null", AC.Undefined(), AC.Null(), -1},
+		{"null and number", AC.Null(), AC.Int32(-100), -1},
+		{"number and string", AC.Double(math.Inf(1)), AC.String(""), -1},
+		{"string and document", AC.String("z"), AC.Document(NewDocument()), -1},
+		{"document and array", AC.Document(NewDocument()), AC.ArrayFromValues(), -1},
+		{"array and binary", AC.ArrayFromValues(), AC.BinaryWithSubtype(nil, 0), -1},
+		{"objectID and boolean", AC.ObjectID(objectid.ObjectID{}), AC.Boolean(false), -1},
+		{"datetime and timestamp", AC.DateTime(100), AC.Timestamp(0, 0), -1},
+		{"code with scope and maxKey", AC.CodeWithScope("x", NewDocument()), AC.MaxKey(), -1},
+		{"int32 and int64", AC.Int32(2), AC.Int64(1), 1},
+		{"int64 and double", AC.Int64(1), AC.Double(1), 0},
+		{"double and decimal", AC.Double(0.5), AC.Decimal128(dec("0.50")), 0},
+		{"large int64 and double", AC.Int64(math.MaxInt64), AC.Double(math.MaxInt64), -1},
+		{"NaN", AC.Double(math.NaN()), AC.Double(math.Inf(-1)), -1},
+		{"NaN and decimal NaN", AC.Double(math.NaN()), AC.Decimal128(dec("NaN")), 0},
+		{"negative zero", AC.Double(math.Copysign(0, -1)), AC.Int32(0), 0},
+		{"strings", AC.String("ab"), AC.String("b"), -1},
+		{"string prefix", AC.String("a"), AC.String("ab"), -1},
+		{"string and symbol", AC.String("a"), AC.Symbol("a"), 0},
+		{
+			"document values",
+			AC.DocumentFromElements(C.Int32("a", 1)),
+			AC.DocumentFromElements(C.Int64("a", 2)),
+			-1,
+		},
+		{
+			"document keys",
+			AC.DocumentFromElements(C.Int32("b", 1)),
+			AC.DocumentFromElements(C.Int32("a", 2)),
+			1,
+		},
+		{
+			"document types before keys",
+			AC.DocumentFromElements(C.String("a", "x")),
+			AC.DocumentFromElements(C.Int32("b", 1)),
+			1,
+		},
+		{
+			"document prefix",
+			AC.DocumentFromElements(C.Int32("a", 1)),
+			AC.DocumentFromElements(C.Int32("a", 1), C.Null("b")),
+			-1,
+		},
+		{"arrays", AC.ArrayFromValues(AC.Int32(1), AC.Int32(2)), AC.ArrayFromValues(AC.Int32(1), AC.Int32(3)), -1},
+		{
+			"reader and constructed arrays",
+			readerArray(AC.BinaryWithSubtype([]byte{1}, 0), AC.Int32(1)),
+			AC.ArrayFromValues(AC.BinaryWithSubtype([]byte{1}, 0), AC.Int32(1)),
+			0,
+		},
+		{
+			"reader and constructed arrays differ",
+			readerArray(AC.Int32(1), AC.Int32(2)),
+			AC.ArrayFromValues(AC.Int32(1), AC.Int32(3)),
+			-1,
+		},
+		{"binary length before data", AC.BinaryWithSubtype([]byte{9}, 0), AC.BinaryWithSubtype([]byte{1, 1}, 0), -1},
+		{"binary subtype", AC.BinaryWithSubtype([]byte{9}, 4), AC.BinaryWithSubtype([]byte{1}, 5), -1},
+		{"booleans", AC.Boolean(true), AC.Boolean(false), 1},
+		{"datetimes", AC.DateTime(-1), AC.DateTime(1), -1},
+		{"timestamps", AC.Timestamp(1, 5), AC.Timestamp(2, 0), -1},
+		{"regex options", AC.Regex("a", "i"), AC.Regex("a", "m"), -1},
+		{"code with scope", AC.CodeWithScope("x", NewDocument(C.Int32("a", 2))), AC.CodeWithScope("x", NewDocument(C.Int32("a", 1))), 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CompareValues(tc.a, tc.b); got != tc.want {
+				t.Errorf("Unexpected result. got %d; want %d", got, tc.want)
+			}
+			if got := CompareValues(tc.b, tc.a); got != -tc.want {
+				t.Errorf("Unexpected result for reversed arguments. got %d; want %d", got, -tc.want)
+			}
+		})
+	}
+}
@@ -391,6 +391,46 @@ func (Constructor) MaxKey(key string) *Element {
 	return elem
 }
 
+// Value creates an element with the given key and value. If v is an embedded document, array, or
+// JavaScript code with scope stored as a *Document, the element shares the *Document with v.
+// Otherwise the bytes of v are copied. It panics if v is invalid.
+func (c Constructor) Value(key string, v *Value) *Element {
+	if v.d != nil {
+		switch v.Type() {
+		case TypeEmbeddedDocument:
+			return c.SubDocument(key, v.d)
+		case TypeArray:
+			return c.Array(key, &Array{v.d})
+		case TypeCodeWithScope:
+			code, scope := v.MutableJavaScriptWithScope()
+			return c.CodeWithScope(key, code, scope)
+		}
+	}
+
+	n, err := v.valueSize()
+	if err != nil {
+		panic(err)
+	}
+
+	size := uint32(1+len(key)+1) + n
+	elem := newElement(0, uint32(1+len(key)+1))
+	elem.value.data = make([]byte, size)
+
+	_, err = elements.Byte.Encode(0, elem.value.data, v.data[v.start])
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = elements.CString.Encode(1, elem.value.data, key)
+	if err != nil {
+		panic(err)
+	}
+
+	copy(elem.value.data[elem.value.offset:], v.data[v.offset:v.offset+n])
+
+	return elem
+}
+
 // Double creates a double element with the given value.
 func (ArrayConstructor) Double(f float64) *Value {
 	return C.Double("", f).value
@@ -470,6 +470,29 @@ func TestConstructor(t *testing.T) {
 
 			requireElementsEqual(t, expected, actual)
 		})
+		t.Run("value", func(t *testing.T) {
+			buf := []byte{
+				// type
+				0x10,
+				// key
+				0x66, 0x6f, 0x6f, 0x0,
+				// value
+				0x2a, 0x0, 0x0, 0x0,
+			}
+
+			expected := &Element{&Value{start: 0, offset: 5, data: buf, d: nil}}
+			actual := C.Value("foo", C.Int32("bar", 42).Value())
+
+			requireElementsEqual(t, expected, actual)
+		})
+		t.Run("value with document", func(t *testing.T) {
+			doc := NewDocument(C.Null("x"))
+			actual := C.Value("foo", AC.Document(doc))
+
+			if actual.Key() != "foo" || actual.Value().MutableDocument() != doc {
+				t.Errorf("Element does not share the document. got %v; want %v", actual.Value().MutableDocument(), doc)
+			}
+		})
 	})
 
 	t.Run("Array", func(t *testing.T) {
@@ -0,0 +1,116 @@
+package decimal
+
+import (
+	"errors"
+	"math/big"
+)
+
+// ErrNotFinite is returned by BigInt when the value is NaN or infinite.
+var ErrNotFinite = errors.New("decimal128 value is not finite")
+
+// maxDigits is the maximum number of digits in the significand of a Decimal128.
+const maxDigits = 34
+
+const (
+	minExponent = -6176
+	maxExponent = 6111
+)
+
+var (
+	bigTen         = big.NewInt(10)
+	maxSignificand = new(big.Int).Sub(new(big.Int).Exp(bigTen, big.NewInt(maxDigits), nil), big.NewInt(1))
+)
+
+// IsNaN reports whether d is NaN.
+func (d Decimal128) IsNaN() bool {
+	return d.h>>58&(1<<5-1) == 0x1F
+}
+
+// IsInf reports whether d is an infinity, according to sign. If sign > 0, IsInf reports whether
+// d is positive infinity. If sign < 0, IsInf reports whether d is negative infinity. If sign ==
+// 0, IsInf reports whether d is either infinity.
+func (d Decimal128) IsInf(sign int) bool {
+	if d.h>>58&(1<<5-1) != 0x1E {
+		return false
+	}
+
+	neg := d.h>>63 == 1
+	return sign == 0 || sign > 0 && !neg || sign < 0 && neg
+}
+
+// BigInt returns the significand and exponent of d, such that the value of d is
+// significand × 10^exponent. The sign of a negative zero is lost. ErrNotFinite is returned if d is
+// NaN or infinite.
+func (d Decimal128) BigInt() (*big.Int, int, error) {
+	if d.IsNaN() || d.IsInf(0) {
+		return nil, 0, ErrNotFinite
+	}
+
+	var e int
+	var h, l uint64
+	if d.h>>61&3 == 3 {
+		// The significand has an implicit 0b100 prefix, which makes it larger than 10^34-1, so
+		// it is non-canonical and interpreted as zero.
+		e = int(d.h>>47&(1<<14-1)) + minExponent
+	} else {
+		e = int(d.h>>49&(1<<14-1)) + minExponent
+		h, l = d.h&(1<<49-1), d.l
+	}
+
+	bi := new(big.Int).SetUint64(h)
+	bi.Lsh(bi, 64)
+	bi.Or(bi, new(big.Int).SetUint64(l))
+	if bi.Cmp(maxSignificand) > 0 {
+		bi.SetInt64(0)
+	}
+	if d.h>>63 == 1 {
+		bi.Neg(bi)
+	}
+
+	return bi, e, nil
+}
+
+// ParseDecimal128FromBigInt returns the Decimal128 with the value bi × 10^exp. Trailing zeros of
+// bi are removed or added to bring the exponent into range. It returns false if the value cannot
+// be represented exactly.
+func ParseDecimal128FromBigInt(bi *big.Int, exp int) (Decimal128, bool) {
+	q := new(big.Int).Abs(bi)
+	r := new(big.Int)
+
+	for exp < minExponent || q.Cmp(maxSignificand) > 0 {
+		if q.Sign() == 0 {
+			exp = minExponent
+			break
+		}
+		q.QuoRem(q, bigTen, r)
+		if r.Sign() != 0 {
+			return Decimal128{}, false
+		}
+		exp++
+	}
+	for exp > maxExponent {
+		if q.Sign() == 0 {
+			exp = maxExponent
+			break
+		}
+		q.Mul(q, bigTen)
+		if q.Cmp(maxSignificand) > 0 {
+			return Decimal128{}, false
+		}
+		exp--
+	}
+
+	var h, l uint64
+	b := q.FillBytes(make([]byte, 16))
+	for i := 0; i < 8; i++ {
+		h = h<<8 | uint64(b[i])
+		l = l<<8 | uint64(b[i+8])
+	}
+
+	h |= uint64(exp-minExponent) & (1<<14 - 1) << 49
+	if bi.Sign() < 0 {
+		h |= 1 << 63
+	}
+
+	return Decimal128{h: h, l: l}, true
+}
@@ -77,6 +77,11 @@ func (d Decimal128) String() string {
 		// Bits: 1*sign 14*exponent 113*significand
 		e = int(d.h>>49&(1<<14-1)) - 6176
 		h = d.h & (1<<49 - 1)
+		// Spec says significands larger than 10^34-1 are non-canonical and are interpreted
+		// as zero.
+		if h > 0x1ED09BEAD87C0 || h == 0x1ED09BEAD87C0 && l >= 0x378D8E6400000000 {
+			h, l = 0, 0
+		}
 	}
 
 	// Would be handled by the logic below, but that's trivial and common.
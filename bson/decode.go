@@ -55,6 +55,7 @@ type DocumentUnmarshaler interface {
 type Decoder struct {
 	pReader    *peekLengthReader
 	bsonReader Reader
+	opts       *ValidateOptions
 }
 
 type peekLengthReader struct {
@@ -104,6 +105,13 @@ func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{pReader: newPeekLengthReader(r)}
 }
 
+// NewDecoderWithOptions constructs a new Decoder from the given io.Reader which validates each
+// document it reads with the given options. Because repairing invalid UTF-8 can lengthen a
+// document, documents decoded into a []byte or a Reader are validated but never repaired.
+func NewDecoderWithOptions(r io.Reader, opts *ValidateOptions) *Decoder {
+	return &Decoder{pReader: newPeekLengthReader(r), opts: opts}
+}
+
 // Decode decodes the BSON document from the underlying io.Reader into the given value.
 func (d *Decoder) Decode(v interface{}) error {
 	switch t := v.(type) {
@@ -123,7 +131,7 @@ func (d *Decoder) Decode(v interface{}) error {
 		_, err = t.Write(d.bsonReader)
 		return err
 	case []byte:
-		length, err := d.pReader.peekLength()
+		length, err := d.peekLength()
 		if err != nil {
 			return err
 		}
@@ -137,10 +145,10 @@ func (d *Decoder) Decode(v interface{}) error {
 			return err
 		}
 
-		_, err = Reader(t).Validate()
+		_, err = Reader(t).ValidateWithOptions(d.opts)
 		return err
 	case Reader:
-		length, err := d.pReader.peekLength()
+		length, err := d.peekLength()
 		if err != nil {
 			return err
 		}
@@ -154,7 +162,7 @@ func (d *Decoder) Decode(v interface{}) error {
 			return err
 		}
 
-		_, err = t.Validate()
+		_, err = t.ValidateWithOptions(d.opts)
 		return err
 
 	default:
@@ -163,8 +171,29 @@ func (d *Decoder) Decode(v interface{}) error {
 	}
 }
 
+func (d *Decoder) peekLength() (int32, error) {
+	length, err := d.pReader.peekLength()
+	if err != nil {
+		return 0, err
+	}
+
+	if d.opts != nil {
+		err = d.opts.checkLength(length)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return length, nil
+}
+
 func (d *Decoder) decodeToReader() error {
 	var err error
+	if d.opts != nil {
+		d.bsonReader, err = NewFromIOReaderWithOptions(d.pReader, d.opts)
+		return err
+	}
+
 	d.bsonReader, err = NewFromIOReader(d.pReader)
 	if err != nil {
 		return err
@@ -15,8 +15,8 @@
 // The Builder type is used to create a BSON document. The type only allows the
 // iterative building of a document, so there is no way to verify the contents
 // outside of writing the document. If you have a Builder and need to
-// conditionally add a field, you can write the document to a byte slice and use
-// the Reader type to lookup the desired document.
+// conditionally add a field, use the If or Computed constructors in the builder
+// package instead of writing the document out and reading it back.
 //
 // The Element and ReaderElement types represent BSON elements. The Element type
 // contains a superset of the ReaderElement functionality. Most of this
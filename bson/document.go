@@ -70,8 +70,16 @@ func NewDocument(elems ...*Element) *Document {
 // ReadDocument will create a Document using the provided slice of bytes. If the
 // slice of bytes is not a valid BSON document, this method will return an error.
 func ReadDocument(b []byte) (*Document, error) {
+	size, err := Reader(b).Validate()
+	if err != nil {
+		return nil, err
+	}
+	if int(size) != len(b) {
+		return nil, ErrInvalidLength
+	}
+
 	var doc = new(Document)
-	err := doc.UnmarshalBSON(b)
+	err = doc.UnmarshalBSON(b)
 	if err != nil {
 		return nil, err
 	}
@@ -224,7 +232,7 @@ func (d *Document) Set(elem *Element) *Document {
 	key := elem.Key() + "\x00"
 	i := sort.Search(len(d.index), func(i int) bool { return bytes.Compare(d.keyFromIndex(i), []byte(key)) >= 0 })
 	if i < len(d.index) && bytes.Compare(d.keyFromIndex(i), []byte(key)) == 0 {
-		d.elems[i] = elem
+		d.elems[d.index[i]] = elem
 		return d
 	}
 
@@ -299,8 +307,7 @@ func (d *Document) Delete(key ...string) *Element {
 		keyIndex := d.index[i]
 		elem = d.elems[keyIndex]
 		if len(key) == 1 {
-			d.index = append(d.index[:i], d.index[i+1:]...)
-			d.elems = append(d.elems[:keyIndex], d.elems[keyIndex+1:]...)
+			d.deleteAt(keyIndex)
 			return elem
 		}
 		switch elem.value.Type() {
@@ -315,6 +322,22 @@ func (d *Document) Delete(key ...string) *Element {
 	return elem
 }
 
+// deleteAt removes the element at the given position in d.elems and its entry in the index.
+func (d *Document) deleteAt(pos uint32) {
+	d.elems = append(d.elems[:pos], d.elems[pos+1:]...)
+
+	index := d.index[:0]
+	for _, i := range d.index {
+		switch {
+		case i > pos:
+			index = append(index, i-1)
+		case i < pos:
+			index = append(index, i)
+		}
+	}
+	d.index = index
+}
+
 // ElementAt retrieves the element at the given index in a Document.
 //
 // TODO(skriptble): This method could be variadic and return the element at the
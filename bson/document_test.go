@@ -308,6 +308,10 @@ func TestDocument(t *testing.T) {
 				C.Null("x"),
 				(&Document{}).Append(C.Null("w"), C.Null("y"), C.Null("z"), C.Null("x")),
 			},
+			{"unsorted", (&Document{}).Append(C.Null("y"), C.Null("x")),
+				C.Double("x", 1.2345),
+				(&Document{}).Append(C.Null("y"), C.Double("x", 1.2345)),
+			},
 		}
 
 		for _, tc := range testCases {
@@ -403,6 +407,24 @@ func TestDocument(t *testing.T) {
 				}
 			})
 		}
+		t.Run("reindex", func(t *testing.T) {
+			d := NewDocument(C.Int32("z", 0), C.Int32("x", 1), C.Int32("y", 2))
+			d.Delete("x")
+			for _, key := range []string{"y", "z"} {
+				elem, err := d.Lookup(key)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if elem.Key() != key {
+					t.Errorf("Unexpected element. got %s; want %s", elem.Key(), key)
+				}
+			}
+			d.Set(C.Int32("y", 3))
+			want := NewDocument(C.Int32("z", 0), C.Int32("y", 3))
+			if diff := cmp.Diff(d, want, cmp.AllowUnexported(Document{}, Element{}, Value{})); diff != "" {
+				t.Errorf("Documents differ: (-got +want)\n%s", diff)
+			}
+		})
 	})
 	t.Run("ElementAt", func(t *testing.T) {
 		t.Run("Out of bounds", func(t *testing.T) {
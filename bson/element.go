@@ -3,6 +3,7 @@ package bson
 import (
 	"errors"
 	"io"
+	"unicode/utf8"
 
 	"github.com/skriptble/wilson/bson/elements"
 )
@@ -118,6 +119,9 @@ func (e *Element) validateKey() (uint32, error) {
 	if pos == end || e.value.data[pos] != '\x00' {
 		return total, ErrInvalidKey
 	}
+	if !utf8.Valid(e.value.data[e.value.start+1 : pos]) {
+		return total, ErrInvalidUTF8
+	}
 	total++
 	return total, nil
 }
@@ -240,10 +244,7 @@ func (e *Element) writeByteSlice(key bool, start uint, size uint32, b []byte) (i
 				return int64(n), err
 			}
 
-			typeAndKeyLength := e.value.offset - e.value.start
-			n += copy(
-				b[start:start+uint(typeAndKeyLength)+uint(lengthWithoutScope)],
-				e.value.data[e.value.start:e.value.start+typeAndKeyLength+uint32(lengthWithoutScope)])
+			n += copy(b[start:], e.value.data[startToWrite:e.value.offset+uint32(lengthWithoutScope)])
 			start += uint(n)
 
 			nn, err := e.value.d.writeByteSlice(start, scopeLength, b)
@@ -115,7 +115,7 @@ func (str) Encode(start uint, writer []byte, s string) (int, error) {
 	written, err = CString.Encode(start+uint(total), writer, s)
 	total += written
 
-	return total, nil
+	return total, err
 }
 
 func (str) Element(start uint, writer []byte, key string, s string) (int, error) {
@@ -47,8 +47,17 @@ type DocumentEncoder interface {
 	EncodeDocument(interface{}) (*Document, error)
 }
 
+// EncodeOptions configures an Encoder or a DocumentEncoder.
+type EncodeOptions struct {
+	// UTF8 determines how map keys and strings which are not valid UTF-8 are handled. With
+	// UTF8Reject, encoding fails with ErrInvalidUTF8. Documents, Readers, and the output of
+	// Marshalers are never repaired and must contain valid UTF-8.
+	UTF8 UTF8Mode
+}
+
 type encoder struct {
-	w io.Writer
+	w    io.Writer
+	utf8 UTF8Mode
 }
 
 // NewEncoder creates an encoder that writes to w.
@@ -61,6 +70,22 @@ func NewDocumentEncoder() DocumentEncoder {
 	return &encoder{}
 }
 
+// NewEncoderWithOptions creates an encoder that writes to w and is configured by opts.
+func NewEncoderWithOptions(w io.Writer, opts *EncodeOptions) Encoder {
+	e := &encoder{w: w}
+	if opts != nil {
+		e.utf8 = opts.UTF8
+	}
+
+	return e
+}
+
+// NewDocumentEncoderWithOptions creates an encoder that encodes into a *Document and is
+// configured by opts.
+func NewDocumentEncoderWithOptions(opts *EncodeOptions) DocumentEncoder {
+	return NewEncoderWithOptions(nil, opts).(DocumentEncoder)
+}
+
 // Encode encodes a value from an io.Writer into the given value.
 func (e *encoder) Encode(v interface{}) error {
 	var err error
@@ -173,6 +198,7 @@ func (e *encoder) EncodeDocument(v interface{}) (*Document, error) {
 			return nil, err
 		}
 		d.Append(elems...)
+		_, err = d.Validate()
 	}
 
 	if err != nil {
@@ -239,6 +265,7 @@ func (e *encoder) encodeMap(val reflect.Value) ([]*Element, error) {
 		default:
 			return nil, fmt.Errorf("Unsupported map key type %s", rkey.Kind())
 		}
+		key = e.utf8.repair(key)
 
 		rval := val.MapIndex(rkey)
 
@@ -431,7 +458,7 @@ func (e *encoder) elemFromValue(key string, val reflect.Value, minsize bool) (*E
 		elem = C.Int32(key, int32(val.Int()))
 	case reflect.Int, reflect.Int64:
 		i := val.Int()
-		if minsize && i < math.MaxInt32 {
+		if minsize && i >= math.MinInt32 && i < math.MaxInt32 {
 			elem = C.Int32(key, int32(val.Int()))
 			break
 		}
@@ -452,7 +479,7 @@ func (e *encoder) elemFromValue(key string, val reflect.Value, minsize bool) (*E
 	case reflect.Float32, reflect.Float64:
 		elem = C.Double(key, val.Float())
 	case reflect.String:
-		elem = C.String(key, val.String())
+		elem = C.String(key, e.utf8.repair(val.String()))
 	case reflect.Map:
 		mapElems, err := e.encodeMap(val)
 		if err != nil {
@@ -504,7 +531,7 @@ func (e *encoder) valueFromValue(val reflect.Value, minsize bool) (*Value, error
 		elem = AC.Int32(int32(val.Int()))
 	case reflect.Int, reflect.Int64:
 		i := val.Int()
-		if minsize && i < math.MaxInt32 {
+		if minsize && i >= math.MinInt32 && i < math.MaxInt32 {
 			elem = AC.Int32(int32(val.Int()))
 			break
 		}
@@ -525,7 +552,7 @@ func (e *encoder) valueFromValue(val reflect.Value, minsize bool) (*Value, error
 	case reflect.Float32, reflect.Float64:
 		elem = AC.Double(val.Float())
 	case reflect.String:
-		elem = AC.String(val.String())
+		elem = AC.String(e.utf8.repair(val.String()))
 	case reflect.Map:
 		mapElems, err := e.encodeMap(val)
 		if err != nil {
@@ -3,6 +3,7 @@ package bson
 import (
 	"bytes"
 	"io"
+	"math"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -563,6 +564,16 @@ func reflectionEncoderTest(t *testing.T) {
 			docToBytes(NewDocument(C.Int32("a", 12345))),
 			nil,
 		},
+		{
+			"minsize too small",
+			struct {
+				A int64 `bson:",minsize"`
+			}{
+				A: math.MinInt64,
+			},
+			docToBytes(NewDocument(C.Int64("a", math.MinInt64))),
+			nil,
+		},
 		{
 			"inline",
 			struct {
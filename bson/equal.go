@@ -0,0 +1,417 @@
+package bson
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// EquivalenceOptions configures the comparison performed by the Equivalent methods and Diff.
+//
+// Equivalent values need not have the same bytes. Double, int32, int64, and decimal128 values
+// are equivalent if they have the same numeric value, so 1, int64(1), 1.0, and
+// NumberDecimal("1.00") are all equivalent. All other values must have the same type, and
+// embedded documents, arrays, and the scopes of JavaScript code with scope are compared
+// recursively.
+type EquivalenceOptions struct {
+	// IgnoreKeyOrder makes documents with the same elements in a different order equivalent.
+	// If a key appears more than once, the occurrences are matched in order. The order of the
+	// elements of arrays is always significant.
+	IgnoreKeyOrder bool
+
+	// StrictNaN makes NaN unequal to every value, including NaN, as in IEEE 754. By default
+	// NaN is equivalent to NaN.
+	StrictNaN bool
+
+	// SignedZero makes -0 unequal to 0. By default they are equivalent.
+	SignedZero bool
+}
+
+// Difference describes a way in which two documents compared by Diff differ.
+type Difference struct {
+	// Path is the dotted path of the differing value. The elements of arrays are identified by
+	// their index. The path of the document itself is empty.
+	Path string
+
+	// A and B are the values at Path in the first and second document. One of them is nil if
+	// the value is missing from that document, and both are nil if the difference concerns the
+	// document at Path as a whole.
+	A, B *Value
+
+	Message string
+}
+
+func (d Difference) String() string {
+	path := d.Path
+	if path == "" {
+		path = "(root)"
+	}
+
+	return path + ": " + d.Message
+}
+
+// Equal reports whether d and other have the same bytes. Two nil documents are equal.
+func (d *Document) Equal(other *Document) bool {
+	return len(Diff(d, other, nil)) == 0
+}
+
+// Equivalent reports whether d and other represent the same data, as described by
+// EquivalenceOptions. If opts is nil, the zero EquivalenceOptions are used.
+func (d *Document) Equivalent(other *Document, opts *EquivalenceOptions) bool {
+	if opts == nil {
+		opts = &EquivalenceOptions{}
+	}
+
+	return len(Diff(d, other, opts)) == 0
+}
+
+// Equal reports whether a and other have the same bytes. Two nil arrays are equal.
+func (a *Array) Equal(other *Array) bool {
+	return arraysEqual(a, other, nil)
+}
+
+// Equivalent reports whether a and other represent the same data, as described by
+// EquivalenceOptions. If opts is nil, the zero EquivalenceOptions are used.
+func (a *Array) Equivalent(other *Array, opts *EquivalenceOptions) bool {
+	if opts == nil {
+		opts = &EquivalenceOptions{}
+	}
+
+	return arraysEqual(a, other, opts)
+}
+
+// Equal reports whether e and other have the same key and the same value bytes. Two nil
+// elements are equal.
+func (e *Element) Equal(other *Element) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+
+	return e.Key() == other.Key() && e.value.Equal(other.value)
+}
+
+// Equivalent reports whether e and other have the same key and equivalent values, as described
+// by EquivalenceOptions. If opts is nil, the zero EquivalenceOptions are used.
+func (e *Element) Equivalent(other *Element, opts *EquivalenceOptions) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+
+	return e.Key() == other.Key() && e.value.Equivalent(other.value, opts)
+}
+
+// Equal reports whether v and other have the same type and the same bytes. Two nil values are
+// equal.
+func (v *Value) Equal(other *Value) bool {
+	return valuesEqual(v, other, nil)
+}
+
+// Equivalent reports whether v and other represent the same data, as described by
+// EquivalenceOptions. If opts is nil, the zero EquivalenceOptions are used.
+func (v *Value) Equivalent(other *Value, opts *EquivalenceOptions) bool {
+	if opts == nil {
+		opts = &EquivalenceOptions{}
+	}
+
+	return valuesEqual(v, other, opts)
+}
+
+// Diff returns the differences between the documents a and b. If opts is nil, the documents are
+// compared exactly, as by Equal, so a difference in the order of the keys or the type of a
+// number is reported. Otherwise they are compared as by Equivalent.
+func Diff(a, b *Document, opts *EquivalenceOptions) []Difference {
+	if a == nil || b == nil {
+		if a == b {
+			return nil
+		}
+		return []Difference{{Message: "document is nil"}}
+	}
+
+	ra, err := a.MarshalBSON()
+	if err != nil {
+		return []Difference{{Message: "invalid document: " + err.Error()}}
+	}
+	rb, err := b.MarshalBSON()
+	if err != nil {
+		return []Difference{{Message: "invalid document: " + err.Error()}}
+	}
+
+	c := &comparer{opts: opts}
+	c.documents("", ra, rb, false)
+	return c.diffs
+}
+
+func arraysEqual(a, b *Array, opts *EquivalenceOptions) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	ra, err := a.MarshalBSON()
+	if err != nil {
+		return false
+	}
+	rb, err := b.MarshalBSON()
+	if err != nil {
+		return false
+	}
+
+	c := &comparer{opts: opts}
+	c.documents("", ra, rb, true)
+	return len(c.diffs) == 0
+}
+
+func valuesEqual(a, b *Value, opts *EquivalenceOptions) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	a, err := readerValue(a)
+	if err != nil {
+		return false
+	}
+	b, err = readerValue(b)
+	if err != nil {
+		return false
+	}
+
+	c := &comparer{opts: opts}
+	c.values("", a, b)
+	return len(c.diffs) == 0
+}
+
+// readerValue returns a copy of v whose value is stored entirely in its data, so that embedded
+// documents are read from bytes rather than from a *Document.
+func readerValue(v *Value) (*Value, error) {
+	if v.d == nil {
+		_, err := v.validate(false)
+		return v, err
+	}
+
+	b, err := (&Element{v}).MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Value{start: 0, offset: v.offset - v.start, data: b}, nil
+}
+
+// comparer collects the differences between two documents. A nil opts compares them exactly.
+type comparer struct {
+	opts  *EquivalenceOptions
+	diffs []Difference
+}
+
+func (c *comparer) report(path string, a, b *Value, message string) {
+	c.diffs = append(c.diffs, Difference{Path: path, A: a, B: b, Message: message})
+}
+
+// documents compares the documents or arrays a and b, whose path is path.
+func (c *comparer) documents(path string, a, b Reader, array bool) {
+	ea, err := readerElements(a)
+	if err != nil {
+		c.report(path, nil, nil, "invalid document: "+err.Error())
+		return
+	}
+	eb, err := readerElements(b)
+	if err != nil {
+		c.report(path, nil, nil, "invalid document: "+err.Error())
+		return
+	}
+
+	switch {
+	case array:
+		for i := 0; i < len(ea) || i < len(eb); i++ {
+			ipath := joinPath(path, strconv.Itoa(i))
+			switch {
+			case i >= len(eb):
+				c.report(ipath, ea[i].value, nil, "missing from b")
+			case i >= len(ea):
+				c.report(ipath, nil, eb[i].value, "missing from a")
+			default:
+				c.values(ipath, ea[i].value, eb[i].value)
+			}
+		}
+	case c.opts != nil && c.opts.IgnoreKeyOrder:
+		c.unordered(path, ea, eb)
+	case sameKeys(ea, eb):
+		for i := range ea {
+			c.values(joinPath(path, ea[i].Key()), ea[i].value, eb[i].value)
+		}
+	default:
+		if !c.unordered(path, ea, eb) {
+			c.report(path, nil, nil, "keys are in a different order")
+		}
+	}
+}
+
+// unordered compares the elements ea and eb of the documents whose path is path, matching them
+// by key. It returns whether any element was missing from either document.
+func (c *comparer) unordered(path string, ea, eb []*Element) bool {
+	missing := false
+	matched := make([]bool, len(eb))
+
+Outer:
+	for _, a := range ea {
+		for j, b := range eb {
+			if !matched[j] && a.Key() == b.Key() {
+				matched[j] = true
+				c.values(joinPath(path, a.Key()), a.value, b.value)
+				continue Outer
+			}
+		}
+
+		c.report(joinPath(path, a.Key()), a.value, nil, "missing from b")
+		missing = true
+	}
+
+	for j, b := range eb {
+		if !matched[j] {
+			c.report(joinPath(path, b.Key()), nil, b.value, "missing from a")
+			missing = true
+		}
+	}
+
+	return missing
+}
+
+// values compares the values a and b, whose path is path.
+func (c *comparer) values(path string, a, b *Value) {
+	if c.opts != nil {
+		na, aok := numberOf(a)
+		nb, bok := numberOf(b)
+		if aok && bok {
+			if !c.numbersEqual(na, nb) {
+				c.report(path, a, b, "values differ")
+			}
+			return
+		}
+	}
+
+	if a.Type() != b.Type() {
+		c.report(path, a, b, "types differ")
+		return
+	}
+
+	switch a.Type() {
+	case TypeEmbeddedDocument:
+		c.documents(path, a.ReaderDocument(), b.ReaderDocument(), false)
+	case TypeArray:
+		c.documents(path, a.ReaderArray(), b.ReaderArray(), true)
+	case TypeCodeWithScope:
+		ac, as := a.ReaderJavaScriptWithScope()
+		bc, bs := b.ReaderJavaScriptWithScope()
+		if ac != bc {
+			c.report(path, a, b, "values differ")
+			return
+		}
+		c.documents(path, as, bs, false)
+	default:
+		if !bytes.Equal(valueBytes(a), valueBytes(b)) {
+			c.report(path, a, b, "values differ")
+		}
+	}
+}
+
+// number is the numeric value of a double, int32, int64, or decimal128 value.
+type number struct {
+	nan bool
+	inf bool
+	// neg is the sign of zero and infinity.
+	neg bool
+	r   *big.Rat
+}
+
+// numberOf returns the numeric value of v. It returns false if v is not a number.
+func numberOf(v *Value) (number, bool) {
+	switch v.Type() {
+	case TypeInt32:
+		return number{r: big.NewRat(int64(v.Int32()), 1)}, true
+	case TypeInt64:
+		return number{r: big.NewRat(v.Int64(), 1)}, true
+	case TypeDouble:
+		f := v.Double()
+		switch {
+		case math.IsNaN(f):
+			return number{nan: true}, true
+		case math.IsInf(f, 0):
+			return number{inf: true, neg: f < 0}, true
+		}
+		return number{neg: math.Signbit(f), r: new(big.Rat).SetFloat64(f)}, true
+	case TypeDecimal128:
+		d := v.Decimal128()
+		h, _ := d.GetBytes()
+		switch s := d.String(); s {
+		case "NaN":
+			return number{nan: true}, true
+		case "Infinity", "-Infinity":
+			return number{inf: true, neg: s[0] == '-'}, true
+		default:
+			r, ok := new(big.Rat).SetString(s)
+			if !ok {
+				return number{nan: true}, true
+			}
+			return number{neg: h>>63 == 1, r: r}, true
+		}
+	}
+
+	return number{}, false
+}
+
+func (c *comparer) numbersEqual(a, b number) bool {
+	switch {
+	case a.nan || b.nan:
+		return a.nan && b.nan && !c.opts.StrictNaN
+	case a.inf || b.inf:
+		return a.inf && b.inf && a.neg == b.neg
+	case a.r.Cmp(b.r) != 0:
+		return false
+	case a.r.Sign() == 0 && c.opts.SignedZero:
+		return a.neg == b.neg
+	}
+
+	return true
+}
+
+// readerElements returns the elements of the document r.
+func readerElements(r Reader) ([]*Element, error) {
+	var elems []*Element
+	_, err := r.readElements(func(elem *Element) error {
+		elems = append(elems, elem)
+		return nil
+	})
+
+	return elems, err
+}
+
+// sameKeys reports whether ea and eb have the same keys in the same order.
+func sameKeys(ea, eb []*Element) bool {
+	if len(ea) != len(eb) {
+		return false
+	}
+	for i := range ea {
+		if ea[i].Key() != eb[i].Key() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// valueBytes returns the bytes of the value v, which must be stored entirely in its data.
+func valueBytes(v *Value) []byte {
+	size, err := v.valueSize()
+	if err != nil {
+		return nil
+	}
+
+	return v.data[v.offset : v.offset+size]
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return parent + "." + key
+}
@@ -0,0 +1,191 @@
+package bson
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/skriptble/wilson/bson/decimal"
+)
+
+func TestEqual(t *testing.T) {
+	testCases := []struct {
+		name string
+		a, b *Document
+		want bool
+	}{
+		{"nil", nil, nil, true},
+		{"nil and empty", nil, NewDocument(), false},
+		{"empty", NewDocument(), NewDocument(), true},
+		{"same", NewDocument(C.Int32("a", 1), C.String("b", "x")), NewDocument(C.Int32("a", 1), C.String("b", "x")), true},
+		{"different value", NewDocument(C.Int32("a", 1)), NewDocument(C.Int32("a", 2)), false},
+		{"different type", NewDocument(C.Int32("a", 1)), NewDocument(C.Int64("a", 1)), false},
+		{"different order", NewDocument(C.Int32("a", 1), C.Int32("b", 2)), NewDocument(C.Int32("b", 2), C.Int32("a", 1)), false},
+		{"NaN", NewDocument(C.Double("a", math.NaN())), NewDocument(C.Double("a", math.NaN())), true},
+		{"negative zero", NewDocument(C.Double("a", 0)), NewDocument(C.Double("a", math.Copysign(0, -1))), false},
+		{
+			"mutable and read-only subdocument",
+			NewDocument(C.SubDocumentFromElements("a", C.Int32("b", 1))),
+			mustReadDocument(t, NewDocument(C.SubDocumentFromElements("a", C.Int32("b", 1)))),
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.a.Equal(tc.b)
+			if got != tc.want {
+				t.Errorf("Unexpected result. got %t; want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	dec := func(s string) decimal.Decimal128 {
+		d, err := decimal.ParseDecimal128(s)
+		if err != nil {
+			t.Fatalf("Unexpected error parsing %q: %v", s, err)
+		}
+		return d
+	}
+	negZero := math.Copysign(0, -1)
+
+	testCases := []struct {
+		name string
+		a, b *Value
+		opts EquivalenceOptions
+		want bool
+	}{
+		{"int32 and int64", AC.Int32(1), AC.Int64(1), EquivalenceOptions{}, true},
+		{"int32 and double", AC.Int32(1), AC.Double(1), EquivalenceOptions{}, true},
+		{"int64 and decimal", AC.Int64(100), AC.Decimal128(dec("1.00E+2")), EquivalenceOptions{}, true},
+		{"double and decimal", AC.Double(0.5), AC.Decimal128(dec("0.50")), EquivalenceOptions{}, true},
+		{"inexact double", AC.Double(0.1), AC.Decimal128(dec("0.1")), EquivalenceOptions{}, false},
+		{"large int64", AC.Int64(math.MaxInt64), AC.Double(math.MaxInt64), EquivalenceOptions{}, false},
+		{"different numbers", AC.Int32(1), AC.Int64(2), EquivalenceOptions{}, false},
+		{"number and string", AC.Int32(1), AC.String("1"), EquivalenceOptions{}, false},
+		{"NaN", AC.Double(math.NaN()), AC.Decimal128(dec("NaN")), EquivalenceOptions{}, true},
+		{"strict NaN", AC.Double(math.NaN()), AC.Double(math.NaN()), EquivalenceOptions{StrictNaN: true}, false},
+		{"infinity", AC.Double(math.Inf(1)), AC.Decimal128(dec("Infinity")), EquivalenceOptions{}, true},
+		{"opposite infinities", AC.Double(math.Inf(1)), AC.Double(math.Inf(-1)), EquivalenceOptions{}, false},
+		{"negative zero", AC.Double(negZero), AC.Int32(0), EquivalenceOptions{}, true},
+		{"signed zero", AC.Double(negZero), AC.Int32(0), EquivalenceOptions{SignedZero: true}, false},
+		{"signed decimal zero", AC.Double(negZero), AC.Decimal128(dec("-0.00")), EquivalenceOptions{SignedZero: true}, true},
+		{
+			"nested numbers",
+			AC.DocumentFromElements(C.ArrayFromElements("a", AC.Int32(1), AC.Double(2))),
+			AC.DocumentFromElements(C.ArrayFromElements("a", AC.Int64(1), AC.Int32(2))),
+			EquivalenceOptions{},
+			true,
+		},
+		{
+			"key order",
+			AC.DocumentFromElements(C.Int32("a", 1), C.Int32("b", 2)),
+			AC.DocumentFromElements(C.Int32("b", 2), C.Int32("a", 1)),
+			EquivalenceOptions{},
+			false,
+		},
+		{
+			"ignore key order",
+			AC.DocumentFromElements(C.Int32("a", 1), C.Int32("b", 2)),
+			AC.DocumentFromElements(C.Int32("b", 2), C.Int32("a", 1)),
+			EquivalenceOptions{IgnoreKeyOrder: true},
+			true,
+		},
+		{
+			"ignore key order duplicate keys",
+			AC.DocumentFromElements(C.Int32("a", 1), C.Int32("b", 2), C.Int32("a", 3)),
+			AC.DocumentFromElements(C.Int32("a", 1), C.Int32("a", 3), C.Int32("b", 2)),
+			EquivalenceOptions{IgnoreKeyOrder: true},
+			true,
+		},
+		{
+			"array order",
+			AC.ArrayFromValues(AC.Int32(1), AC.Int32(2)),
+			AC.ArrayFromValues(AC.Int32(2), AC.Int32(1)),
+			EquivalenceOptions{IgnoreKeyOrder: true},
+			false,
+		},
+		{
+			"code with scope",
+			AC.CodeWithScope("x", NewDocument(C.Int32("a", 1))),
+			AC.CodeWithScope("x", NewDocument(C.Double("a", 1))),
+			EquivalenceOptions{},
+			true,
+		},
+		{
+			"code with scope different code",
+			AC.CodeWithScope("x", NewDocument(C.Int32("a", 1))),
+			AC.CodeWithScope("y", NewDocument(C.Int32("a", 1))),
+			EquivalenceOptions{},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.a.Equivalent(tc.b, &tc.opts)
+			if got != tc.want {
+				t.Errorf("Unexpected result. got %t; want %t", got, tc.want)
+			}
+			if got := tc.b.Equivalent(tc.a, &tc.opts); got != tc.want {
+				t.Errorf("Equivalence is not symmetric. got %t; want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := NewDocument(
+		C.Int32("a", 1),
+		C.SubDocumentFromElements("b", C.String("c", "x"), C.Int32("d", 2)),
+		C.ArrayFromElements("e", AC.Int32(1), AC.Int32(2)),
+	)
+	b := NewDocument(
+		C.Int64("a", 1),
+		C.SubDocumentFromElements("b", C.String("c", "y")),
+		C.ArrayFromElements("e", AC.Int32(1), AC.Int32(2), AC.Int32(3)),
+	)
+
+	testCases := []struct {
+		name string
+		opts *EquivalenceOptions
+		want []string
+	}{
+		{"exact", nil, []string{"a: types differ", "b.c: values differ", "b.d: missing from b", "e.2: missing from a"}},
+		{"equivalent", &EquivalenceOptions{}, []string{"b.c: values differ", "b.d: missing from b", "e.2: missing from a"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, d := range Diff(a, b, tc.opts) {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Unexpected differences. got %q; want %q", got, tc.want)
+			}
+		})
+	}
+	t.Run("order", func(t *testing.T) {
+		got := Diff(NewDocument(C.Int32("a", 1), C.Int32("b", 2)), NewDocument(C.Int32("b", 2), C.Int32("a", 1)), nil)
+		want := []Difference{{Message: "keys are in a different order"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Unexpected differences. got %v; want %v", got, want)
+		}
+	})
+}
+
+func mustReadDocument(t *testing.T, doc *Document) *Document {
+	b, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	read, err := ReadDocument(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return read
+}
@@ -0,0 +1,308 @@
+package expression
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// dateFormat is the format of dates converted to strings.
+const dateFormat = "%Y-%m-%dT%H:%M:%S.%LZ"
+
+func evalToString(op string, args []*bson.Value) (*bson.Value, error) {
+	if nullish(args[0]) {
+		return bson.AC.Null(), nil
+	}
+
+	s, ok := stringOf(args[0])
+	if !ok {
+		return nil, EvalError{Operator: op, Message: fmt.Sprintf("unsupported conversion from %s to string", typeName(args[0]))}
+	}
+
+	return bson.AC.String(s), nil
+}
+
+// stringOf returns v converted to a string, or false if v cannot be converted.
+func stringOf(v *bson.Value) (string, bool) {
+	switch v.Type() {
+	case bson.TypeString:
+		return v.StringValue(), true
+	case bson.TypeBoolean:
+		return strconv.FormatBool(v.Boolean()), true
+	case bson.TypeInt32:
+		return strconv.FormatInt(int64(v.Int32()), 10), true
+	case bson.TypeInt64:
+		return strconv.FormatInt(v.Int64(), 10), true
+	case bson.TypeDouble:
+		f := v.Double()
+		switch {
+		case math.IsNaN(f):
+			return "NaN", true
+		case math.IsInf(f, 1):
+			return "Infinity", true
+		case math.IsInf(f, -1):
+			return "-Infinity", true
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), true
+	case bson.TypeDecimal128:
+		return v.Decimal128().String(), true
+	case bson.TypeObjectID:
+		id := v.ObjectID()
+		return id.Hex(), true
+	case bson.TypeDateTime:
+		s, _ := formatDate(dateFormat, timeOf(v))
+		return s, true
+	}
+
+	return "", false
+}
+
+func evalToInt(op string, args []*bson.Value) (*bson.Value, error) {
+	v := args[0]
+	if nullish(v) {
+		return bson.AC.Null(), nil
+	}
+
+	var i int64
+	switch v.Type() {
+	case bson.TypeBoolean:
+		if v.Boolean() {
+			i = 1
+		}
+	case bson.TypeString:
+		n, err := strconv.ParseInt(v.StringValue(), 10, 32)
+		if err != nil {
+			return nil, EvalError{Operator: op, Message: fmt.Sprintf("failed to parse number %q", v.StringValue())}
+		}
+		i = n
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		n, _ := numberOf(v)
+		if n.r == nil {
+			return nil, EvalError{Operator: op, Message: "cannot convert a non-finite number to int"}
+		}
+		t := new(big.Int).Quo(n.r.Num(), n.r.Denom())
+		if !t.IsInt64() {
+			return nil, EvalError{Operator: op, Message: "conversion would overflow the target type"}
+		}
+		i = t.Int64()
+	default:
+		return nil, EvalError{Operator: op, Message: fmt.Sprintf("unsupported conversion from %s to int", typeName(v))}
+	}
+
+	if int64(int32(i)) != i {
+		return nil, EvalError{Operator: op, Message: "conversion would overflow the target type"}
+	}
+
+	return bson.AC.Int32(int32(i)), nil
+}
+
+func evalType(op string, args []*bson.Value) (*bson.Value, error) {
+	return bson.AC.String(typeName(args[0])), nil
+}
+
+// dateMillis returns the milliseconds since the Unix epoch of the datetime v.
+func dateMillis(v *bson.Value) int64 {
+	return int64(binary.LittleEndian.Uint64(v.Bytes()))
+}
+
+// timeOf returns the time of a datetime, timestamp, or ObjectID in UTC.
+func timeOf(v *bson.Value) time.Time {
+	switch v.Type() {
+	case bson.TypeTimestamp:
+		return time.Unix(int64(binary.LittleEndian.Uint64(v.Bytes())>>32), 0).UTC()
+	case bson.TypeObjectID:
+		id := v.ObjectID()
+		return time.Unix(int64(binary.BigEndian.Uint32(id[0:4])), 0).UTC()
+	}
+
+	ms := dateMillis(v)
+	sec := ms / 1000
+	if ms%1000 < 0 {
+		sec--
+	}
+	return time.Unix(sec, (ms-sec*1000)*int64(time.Millisecond)).UTC()
+}
+
+// dateToStringNode is $dateToString. The format, timezone, and onNull nodes are nil if they were
+// not given.
+type dateToStringNode struct {
+	date, format, timezone, onNull node
+}
+
+func (dn dateToStringNode) eval(s *scope) (*bson.Value, error) {
+	const op = "$dateToString"
+
+	date, err := dn.date.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if nullish(date) {
+		if dn.onNull != nil {
+			return dn.onNull.eval(s)
+		}
+		return bson.AC.Null(), nil
+	}
+	switch date.Type() {
+	case bson.TypeDateTime, bson.TypeTimestamp, bson.TypeObjectID:
+	default:
+		return nil, EvalError{Operator: op, Message: fmt.Sprintf("can't convert from %s to date", typeName(date))}
+	}
+
+	format := dateFormat
+	if dn.format != nil {
+		v, err := dn.format.eval(s)
+		if err != nil || nullish(v) {
+			return bson.AC.Null(), err
+		}
+		if v.Type() != bson.TypeString {
+			return nil, EvalError{Operator: op, Message: fmt.Sprintf("format must be a string, not %s", typeName(v))}
+		}
+		format = v.StringValue()
+	}
+
+	loc := time.UTC
+	if dn.timezone != nil {
+		v, err := dn.timezone.eval(s)
+		if err != nil || nullish(v) {
+			return bson.AC.Null(), err
+		}
+		if v.Type() != bson.TypeString {
+			return nil, EvalError{Operator: op, Message: fmt.Sprintf("timezone must be a string, not %s", typeName(v))}
+		}
+		if loc, err = location(v.StringValue()); err != nil {
+			return nil, EvalError{Operator: op, Message: err.Error()}
+		}
+	}
+
+	out, err := formatDate(format, timeOf(date).In(loc))
+	if err != nil {
+		return nil, EvalError{Operator: op, Message: err.Error()}
+	}
+
+	return bson.AC.String(out), nil
+}
+
+// compileDateToString compiles $dateToString, which is of the form
+// {"date": <expr>, "format": <expr>, "timezone": <expr>, "onNull": <expr>}.
+func compileDateToString(v *bson.Value, path string, vars []string) (node, error) {
+	opts, err := options(v, path, []string{"date"}, []string{"format", "timezone", "onNull"})
+	if err != nil {
+		return nil, err
+	}
+
+	var dn dateToStringNode
+	for _, f := range []struct {
+		name string
+		n    *node
+	}{{"date", &dn.date}, {"format", &dn.format}, {"timezone", &dn.timezone}, {"onNull", &dn.onNull}} {
+		*f.n, err = compileOption(opts, f.name, path, vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check a constant format when compiling rather than for each document.
+	if l, ok := dn.format.(literal); ok && l.v.Type() == bson.TypeString {
+		if _, err := formatDate(l.v.StringValue(), time.Time{}); err != nil {
+			return nil, CompileError{Path: joinPath(path, "format"), Message: err.Error()}
+		}
+	}
+
+	return dn, nil
+}
+
+var offsetPattern = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})?$`)
+
+// location returns the time zone for an Olson time zone identifier such as "Europe/Oslo" or a UTC
+// offset such as "+02:00", "+0200", or "+02".
+func location(tz string) (*time.Location, error) {
+	if m := offsetPattern.FindStringSubmatch(tz); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3] + strings.Repeat("0", 2-len(m[3])))
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(tz, offset), nil
+	}
+
+	if tz == "" || tz == "Local" {
+		return nil, fmt.Errorf("unrecognized time zone identifier %q", tz)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unrecognized time zone identifier %q", tz)
+	}
+
+	return loc, nil
+}
+
+// formatDate formats t according to the MongoDB date format specifiers of format.
+func formatDate(format string, t time.Time) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("unmatched '%%' at end of format string")
+		}
+
+		switch format[i] {
+		case 'd':
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case 'G':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&sb, "%04d", year)
+		case 'H':
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case 'j':
+			fmt.Fprintf(&sb, "%03d", t.YearDay())
+		case 'L':
+			fmt.Fprintf(&sb, "%03d", t.Nanosecond()/int(time.Millisecond))
+		case 'm':
+			fmt.Fprintf(&sb, "%02d", int(t.Month()))
+		case 'M':
+			fmt.Fprintf(&sb, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&sb, "%02d", t.Second())
+		case 'w':
+			fmt.Fprintf(&sb, "%d", int(t.Weekday())+1)
+		case 'u':
+			fmt.Fprintf(&sb, "%d", (int(t.Weekday())+6)%7+1)
+		case 'U':
+			fmt.Fprintf(&sb, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&sb, "%02d", week)
+		case 'Y':
+			fmt.Fprintf(&sb, "%04d", t.Year())
+		case 'z':
+			_, offset := t.Zone()
+			sign := '+'
+			if offset < 0 {
+				sign, offset = '-', -offset
+			}
+			fmt.Fprintf(&sb, "%c%02d%02d", sign, offset/3600, offset%3600/60)
+		case 'Z':
+			_, offset := t.Zone()
+			fmt.Fprintf(&sb, "%d", offset/60)
+		case '%':
+			sb.WriteByte('%')
+		default:
+			return "", fmt.Errorf("invalid format character '%%%c' in format string", format[i])
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,408 @@
+// Package expression evaluates MongoDB aggregation expressions against BSON documents.
+//
+// An expression is any BSON value. A string starting with "$" is a field path such as "$a.b",
+// which evaluates to the value at that path within the current document, and a string starting
+// with "$$" is a variable, optionally followed by a path, such as "$$ROOT" or "$$item.price". A
+// document whose only field is an operator, such as {"$add": ["$a", 1]}, evaluates the operator.
+// Any other document or array evaluates to a document or array of the values of its expressions,
+// and all other values evaluate to themselves.
+//
+// The variables $$ROOT and $$CURRENT refer to the document the expression is evaluated against,
+// and $$REMOVE evaluates to a missing value. Further variables are defined by $let, $map,
+// $filter, and $reduce.
+//
+// The supported operators are:
+//
+//	Arithmetic:  $add, $subtract, $multiply, $divide, $mod
+//	String:      $concat, $substrCP, $toUpper, $toLower
+//	Conditional: $cond, $ifNull, $switch
+//	Boolean:     $and, $or, $not
+//	Comparison:  $eq, $ne, $gt, $gte, $lt, $lte, $cmp
+//	Array:       $arrayElemAt, $size, $map, $filter, $reduce
+//	Date:        $dateToString
+//	Type:        $toString, $toInt, $type
+//	Variable:    $let, $literal
+//
+// Arithmetic follows MongoDB's numeric promotion: the result has the widest type of the operands
+// in the order 32-bit integer, 64-bit integer, double, and decimal, and integer results which
+// overflow become 64-bit integers and then doubles. $divide always returns a double or decimal.
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// CompileError is returned when an expression is invalid.
+type CompileError struct {
+	// Path is the dotted path of the invalid value within the expression.
+	Path    string
+	Message string
+}
+
+func (ce CompileError) Error() string {
+	if ce.Path == "" {
+		return "expression: " + ce.Message
+	}
+
+	return "expression: " + ce.Path + ": " + ce.Message
+}
+
+// EvalError is returned when an operator cannot be evaluated, such as when it is given arguments
+// of the wrong type.
+type EvalError struct {
+	Operator string
+	Message  string
+}
+
+func (ee EvalError) Error() string {
+	return "expression: " + ee.Operator + ": " + ee.Message
+}
+
+// Expression is a compiled aggregation expression. An Expression is safe for concurrent use.
+type Expression struct {
+	root node
+}
+
+// Compile compiles the expression v.
+func Compile(v *bson.Value) (*Expression, error) {
+	// Copy the expression into a document of its own so that it can be validated and so that it
+	// is unaffected by later changes to v.
+	r, err := bson.NewDocument(bson.C.Value("", v)).MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = bson.Reader(r).Validate(); err != nil {
+		return nil, err
+	}
+	elem, err := bson.Reader(r).ElementAt(0)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := compile(elem.Value(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Expression{root: n}, nil
+}
+
+// Evaluate returns the value of the expression for the valid document root. The result is nil if
+// the expression evaluates to a missing value, such as a field path which is not present in root.
+// The result may refer to the bytes of root.
+func (e *Expression) Evaluate(root bson.Reader) (*bson.Value, error) {
+	return e.root.eval(&scope{root: bson.AC.DocumentFromReader(root)})
+}
+
+// Evaluate compiles the expression v and evaluates it for the document root. See Compile and
+// Expression.Evaluate.
+func Evaluate(v *bson.Value, root bson.Reader) (*bson.Value, error) {
+	if _, err := root.Validate(); err != nil {
+		return nil, err
+	}
+
+	e, err := Compile(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Evaluate(root)
+}
+
+// node is a compiled expression.
+type node interface {
+	// eval returns the value of the expression within s, or nil if it is missing.
+	eval(s *scope) (*bson.Value, error)
+}
+
+// scope holds the variables defined while evaluating an expression. Each scope defines one
+// variable in addition to those of its parent.
+type scope struct {
+	root   *bson.Value
+	name   string
+	value  *bson.Value
+	parent *scope
+}
+
+// with returns a scope which defines name as v in addition to the variables of s.
+func (s *scope) with(name string, v *bson.Value) *scope {
+	return &scope{root: s.root, name: name, value: v, parent: s}
+}
+
+// lookup returns the value of the variable name, or nil if it is missing.
+func (s *scope) lookup(name string) *bson.Value {
+	for sc := s; sc != nil; sc = sc.parent {
+		if sc.name == name {
+			return sc.value
+		}
+	}
+
+	switch name {
+	case "ROOT", "CURRENT":
+		return s.root
+	}
+
+	return nil
+}
+
+// literal is an expression which evaluates to a constant value.
+type literal struct {
+	v *bson.Value
+}
+
+func (l literal) eval(*scope) (*bson.Value, error) {
+	return l.v, nil
+}
+
+// fieldPath is an expression which evaluates to the value at path within a variable. The path
+// may be empty.
+type fieldPath struct {
+	variable string
+	path     []string
+}
+
+func (fp fieldPath) eval(s *scope) (*bson.Value, error) {
+	v := s.lookup(fp.variable)
+	if v == nil {
+		return nil, nil
+	}
+
+	return pathValue(v, fp.path), nil
+}
+
+// objectExpr is an expression which evaluates to a document of the values of its fields. Fields
+// which evaluate to missing values are omitted.
+type objectExpr struct {
+	keys   []string
+	values []node
+}
+
+func (oe objectExpr) eval(s *scope) (*bson.Value, error) {
+	doc := bson.NewDocument()
+	for i, n := range oe.values {
+		v, err := n.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			doc.Append(bson.C.Value(oe.keys[i], v))
+		}
+	}
+
+	return bson.AC.Document(doc), nil
+}
+
+// arrayExpr is an expression which evaluates to an array of the values of its elements. Elements
+// which evaluate to missing values are null.
+type arrayExpr []node
+
+func (ae arrayExpr) eval(s *scope) (*bson.Value, error) {
+	values, err := evalAll(s, ae)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if v == nil {
+			values[i] = bson.AC.Null()
+		}
+	}
+
+	return bson.AC.ArrayFromValues(values...), nil
+}
+
+// compile compiles the expression v found at path. vars are the names of the variables defined by
+// enclosing operators.
+func compile(v *bson.Value, path string, vars []string) (node, error) {
+	switch v.Type() {
+	case bson.TypeString:
+		s := v.StringValue()
+		switch {
+		case strings.HasPrefix(s, "$$"):
+			return compileVariable(s[2:], path, vars)
+		case strings.HasPrefix(s, "$"):
+			segs, err := compilePath(s[1:], path)
+			if err != nil {
+				return nil, err
+			}
+			return fieldPath{variable: "CURRENT", path: segs}, nil
+		}
+	case bson.TypeEmbeddedDocument:
+		return compileObject(v.ReaderDocument(), path, vars)
+	case bson.TypeArray:
+		values, err := elements(v)
+		if err != nil {
+			return nil, err
+		}
+
+		ae := make(arrayExpr, 0, len(values))
+		for i, ev := range values {
+			n, err := compile(ev, joinPath(path, strconv.Itoa(i)), vars)
+			if err != nil {
+				return nil, err
+			}
+			ae = append(ae, n)
+		}
+		return ae, nil
+	}
+
+	return literal{v}, nil
+}
+
+// compileVariable compiles a reference to a variable such as "ROOT" or "item.price".
+func compileVariable(s, path string, vars []string) (node, error) {
+	parts := strings.SplitN(s, ".", 2)
+	name := parts[0]
+
+	defined := name == "ROOT" || name == "CURRENT" || name == "REMOVE"
+	for _, v := range vars {
+		defined = defined || v == name
+	}
+	if !defined {
+		return nil, CompileError{Path: path, Message: fmt.Sprintf("undefined variable %q", name)}
+	}
+	if name == "REMOVE" {
+		return literal{nil}, nil
+	}
+
+	var segs []string
+	if len(parts) > 1 {
+		var err error
+		segs, err = compilePath(parts[1], path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fieldPath{variable: name, path: segs}, nil
+}
+
+// compilePath splits a dotted field path into its segments.
+func compilePath(s, path string) ([]string, error) {
+	segs := strings.Split(s, ".")
+	for _, seg := range segs {
+		if seg == "" || strings.HasPrefix(seg, "$") {
+			return nil, CompileError{Path: path, Message: fmt.Sprintf("invalid field path %q", "$"+s)}
+		}
+	}
+
+	return segs, nil
+}
+
+// compileObject compiles a document, which is either an operator or a document of expressions.
+func compileObject(r bson.Reader, path string, vars []string) (node, error) {
+	itr, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var oe objectExpr
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key := elem.Key()
+		kpath := joinPath(path, key)
+
+		if strings.HasPrefix(key, "$") {
+			if len(oe.keys) > 0 {
+				return nil, CompileError{Path: kpath, Message: "an operator must be the only field of its document"}
+			}
+			if _, err := r.ElementAt(1); err != bson.ErrOutOfBounds {
+				return nil, CompileError{Path: kpath, Message: "an operator must be the only field of its document"}
+			}
+			op, ok := operators[key]
+			if !ok {
+				return nil, CompileError{Path: kpath, Message: fmt.Sprintf("unknown operator %s", key)}
+			}
+			return op(elem.Value(), kpath, vars)
+		}
+		if key == "" || strings.Contains(key, ".") {
+			return nil, CompileError{Path: kpath, Message: "invalid field name"}
+		}
+
+		n, err := compile(elem.Value(), kpath, vars)
+		if err != nil {
+			return nil, err
+		}
+		oe.keys = append(oe.keys, key)
+		oe.values = append(oe.values, n)
+	}
+
+	return oe, itr.Err()
+}
+
+// pathValue returns the value at the path segs within v, or nil if there is none. When the path
+// traverses an array, the result is an array of the values at the rest of the path within each of
+// its elements.
+func pathValue(v *bson.Value, segs []string) *bson.Value {
+	for i, seg := range segs {
+		switch v.Type() {
+		case bson.TypeEmbeddedDocument:
+			elem, err := v.ReaderDocument().Lookup(seg)
+			if err != nil || elem == nil {
+				return nil
+			}
+			v = elem.Value()
+		case bson.TypeArray:
+			itr, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return nil
+			}
+
+			var values []*bson.Value
+			for itr.Next() {
+				ev := itr.Element().Value()
+				if ev.Type() != bson.TypeEmbeddedDocument && ev.Type() != bson.TypeArray {
+					continue
+				}
+				if pv := pathValue(bson.C.Value("", ev).Value(), segs[i:]); pv != nil {
+					values = append(values, pv)
+				}
+			}
+			return bson.AC.ArrayFromValues(values...)
+		default:
+			return nil
+		}
+	}
+
+	return v
+}
+
+// elements returns copies of the elements of the array v.
+func elements(v *bson.Value) ([]*bson.Value, error) {
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*bson.Value
+	for itr.Next() {
+		values = append(values, itr.Element().Clone().Value())
+	}
+
+	return values, itr.Err()
+}
+
+// evalAll evaluates each of nodes within s.
+func evalAll(s *scope, nodes []node) ([]*bson.Value, error) {
+	values := make([]*bson.Value, len(nodes))
+	for i, n := range nodes {
+		var err error
+		values[i], err = n.eval(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return parent + "." + key
+}
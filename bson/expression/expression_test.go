@@ -0,0 +1,342 @@
+package expression
+
+import (
+	"math"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/decimal"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+// op returns the expression {name: args}, where a single argument is not wrapped in an array.
+func op(name string, args ...*bson.Value) *bson.Value {
+	if len(args) == 1 {
+		return AC.DocumentFromElements(C.Value(name, args[0]))
+	}
+
+	return AC.DocumentFromElements(C.ArrayFromElements(name, args...))
+}
+
+func obj(elems ...*bson.Element) *bson.Value {
+	return AC.DocumentFromElements(elems...)
+}
+
+func dec(t *testing.T, s string) *bson.Value {
+	t.Helper()
+
+	d, err := decimal.ParseDecimal128(s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return AC.Decimal128(d)
+}
+
+func TestEvaluate(t *testing.T) {
+	id := objectid.ObjectID{0x5a, 0x00, 0x00, 0x00}
+	doc := bson.NewDocument(
+		C.Int32("i", 7),
+		C.Int64("l", 10),
+		C.Double("d", 2.5),
+		C.String("s", "Hello"),
+		C.Null("n"),
+		C.Boolean("t", true),
+		C.DateTime("date", 1500000000123),
+		C.ObjectID("id", id),
+		C.ArrayFromElements("arr", AC.Int32(1), AC.Int32(2), AC.Int32(3)),
+		C.ArrayFromElements("docs",
+			AC.DocumentFromElements(C.Int32("x", 1)),
+			AC.DocumentFromElements(C.Int32("x", 2)),
+		),
+		C.SubDocumentFromElements("sub", C.String("a", "b")),
+	)
+	rdr, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		expr *bson.Value
+		want *bson.Value
+	}{
+		{"literal", AC.Int32(5), AC.Int32(5)},
+		{"field path", AC.String("$sub.a"), AC.String("b")},
+		{"missing field", AC.String("$nope"), nil},
+		{"array field path", AC.String("$docs.x"), AC.ArrayFromValues(AC.Int32(1), AC.Int32(2))},
+		{"$$ROOT", AC.String("$$ROOT.i"), AC.Int32(7)},
+		{"$$CURRENT", AC.String("$$CURRENT.s"), AC.String("Hello")},
+		{"$$REMOVE", AC.String("$$REMOVE"), nil},
+		{"object", obj(C.String("a", "$i"), C.String("b", "$nope")), obj(C.Int32("a", 7))},
+		{"array", AC.ArrayFromValues(AC.String("$i"), AC.String("$nope")), AC.ArrayFromValues(AC.Int32(7), AC.Null())},
+		{"$literal", op("$literal", AC.String("$i")), AC.String("$i")},
+
+		{"$add int32", op("$add", AC.String("$i"), AC.Int32(1)), AC.Int32(8)},
+		{"$add int64", op("$add", AC.String("$i"), AC.String("$l")), AC.Int64(17)},
+		{"$add double", op("$add", AC.String("$i"), AC.String("$d")), AC.Double(9.5)},
+		{"$add decimal", op("$add", AC.String("$d"), dec(t, "0.1")), dec(t, "2.6")},
+		{"$add overflow", op("$add", AC.Int32(math.MaxInt32), AC.Int32(1)), AC.Int64(math.MaxInt32 + 1)},
+		{"$add int64 overflow", op("$add", AC.Int64(math.MaxInt64), AC.Int32(1)), AC.Double(math.MaxInt64)},
+		{"$add null", op("$add", AC.String("$i"), AC.String("$nope")), AC.Null()},
+		{"$add date", op("$add", AC.String("$date"), AC.Int32(877)), AC.DateTime(1500000001000)},
+		{"$subtract", op("$subtract", AC.String("$l"), AC.String("$d")), AC.Double(7.5)},
+		{"$subtract dates", op("$subtract", AC.String("$date"), AC.DateTime(1500000000000)), AC.Int64(123)},
+		{"$subtract from date", op("$subtract", AC.String("$date"), AC.Int64(123)), AC.DateTime(1500000000000)},
+		{"$multiply", op("$multiply", AC.String("$i"), AC.String("$l"), AC.Int32(2)), AC.Int64(140)},
+		{"$multiply overflow", op("$multiply", AC.Int32(1<<16), AC.Int32(1<<16)), AC.Int64(1 << 32)},
+		{"$divide", op("$divide", AC.String("$l"), AC.Int32(4)), AC.Double(2.5)},
+		{"$divide decimal", op("$divide", dec(t, "1"), AC.Int32(3)), dec(t, "0.3333333333333333333333333333333333")},
+		{"$mod", op("$mod", AC.String("$i"), AC.Int32(4)), AC.Int32(3)},
+		{"$mod negative", op("$mod", AC.Int32(-7), AC.Int64(4)), AC.Int64(-3)},
+		{"$mod double", op("$mod", AC.Double(7.5), AC.Int32(2)), AC.Double(1.5)},
+
+		{"$concat", op("$concat", AC.String("$s"), AC.String(", "), AC.String("$sub.a")), AC.String("Hello, b")},
+		{"$concat null", op("$concat", AC.String("$s"), AC.String("$nope")), AC.Null()},
+		{"$substrCP", op("$substrCP", AC.String("héllo"), AC.Int32(1), AC.Int32(3)), AC.String("éll")},
+		{"$substrCP past end", op("$substrCP", AC.String("$s"), AC.Int32(3), AC.Int32(10)), AC.String("lo")},
+		{"$toUpper", op("$toUpper", AC.String("$s")), AC.String("HELLO")},
+		{"$toUpper null", op("$toUpper", AC.String("$n")), AC.String("")},
+		{"$toLower number", op("$toLower", AC.String("$i")), AC.String("7")},
+
+		{"$cond array", op("$cond", AC.String("$t"), AC.String("yes"), AC.String("no")), AC.String("yes")},
+		{"$cond object", AC.DocumentFromElements(C.SubDocumentFromElements("$cond",
+			C.Value("if", op("$gt", AC.String("$i"), AC.Int32(10))), C.String("then", "big"), C.String("else", "small"),
+		)), AC.String("small")},
+		{"$cond zero is false", op("$cond", AC.Int32(0), AC.Int32(1), AC.Int32(2)), AC.Int32(2)},
+		{"$ifNull", op("$ifNull", AC.String("$n"), AC.String("$nope"), AC.String("$i")), AC.Int32(7)},
+		{"$ifNull missing replacement", op("$ifNull", AC.String("$n"), AC.String("$nope")), nil},
+		{"$switch", AC.DocumentFromElements(C.SubDocumentFromElements("$switch",
+			C.ArrayFromElements("branches",
+				obj(C.Value("case", op("$eq", AC.String("$i"), AC.Int32(1))), C.String("then", "one")),
+				obj(C.Value("case", op("$lt", AC.String("$i"), AC.Int32(10))), C.String("then", "few")),
+			),
+			C.String("default", "many"),
+		)), AC.String("few")},
+		{"$switch default", AC.DocumentFromElements(C.SubDocumentFromElements("$switch",
+			C.ArrayFromElements("branches", obj(C.Boolean("case", false), C.String("then", "no"))),
+			C.String("default", "yes"),
+		)), AC.String("yes")},
+
+		{"$and", op("$and", AC.String("$t"), AC.String("$i")), AC.Boolean(true)},
+		{"$and false", op("$and", AC.String("$t"), AC.String("$n")), AC.Boolean(false)},
+		{"$or", op("$or", AC.String("$nope"), AC.Int32(1)), AC.Boolean(true)},
+		{"$not", op("$not", AC.String("$n")), AC.Boolean(true)},
+
+		{"$eq numbers", op("$eq", AC.String("$i"), AC.Double(7)), AC.Boolean(true)},
+		{"$eq missing and null", op("$eq", AC.String("$nope"), AC.Null()), AC.Boolean(false)},
+		{"$ne", op("$ne", AC.String("$s"), AC.String("Hello")), AC.Boolean(false)},
+		{"$gt type order", op("$gt", AC.String("$s"), AC.Int32(100)), AC.Boolean(true)},
+		{"$gte", op("$gte", AC.String("$l"), AC.Int64(10)), AC.Boolean(true)},
+		{"$lt", op("$lt", AC.String("$d"), AC.String("$i")), AC.Boolean(true)},
+		{"$lte", op("$lte", AC.String("$i"), AC.Int32(6)), AC.Boolean(false)},
+		{"$cmp", op("$cmp", AC.String("$i"), AC.Int32(8)), AC.Int32(-1)},
+
+		{"$arrayElemAt", op("$arrayElemAt", AC.String("$arr"), AC.Int32(1)), AC.Int32(2)},
+		{"$arrayElemAt negative", op("$arrayElemAt", AC.String("$arr"), AC.Int64(-1)), AC.Int32(3)},
+		{"$arrayElemAt out of bounds", op("$arrayElemAt", AC.String("$arr"), AC.Int32(3)), nil},
+		{"$size", op("$size", AC.String("$arr")), AC.Int32(3)},
+		{"$map", AC.DocumentFromElements(C.SubDocumentFromElements("$map",
+			C.String("input", "$arr"), C.String("as", "n"),
+			C.Value("in", op("$multiply", AC.String("$$n"), AC.String("$i"))),
+		)), AC.ArrayFromValues(AC.Int32(7), AC.Int32(14), AC.Int32(21))},
+		{"$map this", AC.DocumentFromElements(C.SubDocumentFromElements("$map",
+			C.String("input", "$docs"), C.String("in", "$$this.x"),
+		)), AC.ArrayFromValues(AC.Int32(1), AC.Int32(2))},
+		{"$map null", AC.DocumentFromElements(C.SubDocumentFromElements("$map",
+			C.String("input", "$nope"), C.String("in", "$$this"),
+		)), AC.Null()},
+		{"$filter", AC.DocumentFromElements(C.SubDocumentFromElements("$filter",
+			C.String("input", "$arr"), C.Value("cond", op("$gte", AC.String("$$this"), AC.Int32(2))),
+		)), AC.ArrayFromValues(AC.Int32(2), AC.Int32(3))},
+		{"$filter limit", AC.DocumentFromElements(C.SubDocumentFromElements("$filter",
+			C.String("input", "$arr"), C.String("as", "e"), C.Boolean("cond", true), C.Int32("limit", 1),
+		)), AC.ArrayFromValues(AC.Int32(1))},
+		{"$reduce", AC.DocumentFromElements(C.SubDocumentFromElements("$reduce",
+			C.String("input", "$arr"), C.Int64("initialValue", 0),
+			C.Value("in", op("$add", AC.String("$$value"), AC.String("$$this"))),
+		)), AC.Int64(6)},
+
+		{"$dateToString", AC.DocumentFromElements(C.SubDocumentFromElements("$dateToString",
+			C.String("date", "$date"),
+		)), AC.String("2017-07-14T02:40:00.123Z")},
+		{"$dateToString format", AC.DocumentFromElements(C.SubDocumentFromElements("$dateToString",
+			C.String("date", "$date"), C.String("format", "%Y/%j %w %u %U %V %G %%"),
+		)), AC.String("2017/195 6 5 28 28 2017 %")},
+		{"$dateToString timezone", AC.DocumentFromElements(C.SubDocumentFromElements("$dateToString",
+			C.String("date", "$date"), C.String("format", "%H:%M %z %Z"), C.String("timezone", "+05:30"),
+		)), AC.String("08:10 +0530 330")},
+		{"$dateToString objectid", AC.DocumentFromElements(C.SubDocumentFromElements("$dateToString",
+			C.String("date", "$id"), C.String("format", "%Y-%m-%d"),
+		)), AC.String("2017-11-06")},
+		{"$dateToString onNull", AC.DocumentFromElements(C.SubDocumentFromElements("$dateToString",
+			C.String("date", "$nope"), C.String("onNull", "none"),
+		)), AC.String("none")},
+
+		{"$toString int", op("$toString", AC.String("$l")), AC.String("10")},
+		{"$toString double", op("$toString", AC.String("$d")), AC.String("2.5")},
+		{"$toString bool", op("$toString", AC.String("$t")), AC.String("true")},
+		{"$toString date", op("$toString", AC.String("$date")), AC.String("2017-07-14T02:40:00.123Z")},
+		{"$toString objectid", op("$toString", AC.String("$id")), AC.String("5a0000000000000000000000")},
+		{"$toString decimal", op("$toString", dec(t, "1.50")), AC.String("1.50")},
+		{"$toString null", op("$toString", AC.String("$nope")), AC.Null()},
+		{"$toInt string", op("$toInt", AC.String("-42")), AC.Int32(-42)},
+		{"$toInt double", op("$toInt", AC.Double(-2.9)), AC.Int32(-2)},
+		{"$toInt bool", op("$toInt", AC.String("$t")), AC.Int32(1)},
+		{"$toInt decimal", op("$toInt", dec(t, "12.7")), AC.Int32(12)},
+		{"$type", op("$type", AC.String("$l")), AC.String("long")},
+		{"$type missing", op("$type", AC.String("$nope")), AC.String("missing")},
+		{"$type array argument", op("$type", AC.ArrayFromValues(AC.ArrayFromValues())), AC.String("array")},
+
+		{"$let", AC.DocumentFromElements(C.SubDocumentFromElements("$let",
+			C.SubDocumentFromElements("vars", C.String("x", "$i"), C.Int32("y", 3)),
+			C.Value("in", op("$add", AC.String("$$x"), AC.String("$$y"))),
+		)), AC.Int32(10)},
+		{"nested $let", AC.DocumentFromElements(C.SubDocumentFromElements("$let",
+			C.SubDocumentFromElements("vars", C.Int32("x", 1)),
+			C.Value("in", AC.DocumentFromElements(C.SubDocumentFromElements("$let",
+				C.SubDocumentFromElements("vars", C.Int32("x", 2)),
+				C.String("in", "$$x"),
+			))),
+		)), AC.Int32(2)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := Compile(tc.expr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := e.Evaluate(rdr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			switch {
+			case got == nil && tc.want == nil:
+			case got == nil || tc.want == nil || !got.Equal(tc.want):
+				t.Errorf("Unexpected result. got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		expr *bson.Value
+		want error
+	}{
+		{"unknown operator", op("$bogus", AC.Int32(1)), CompileError{Path: "$bogus", Message: "unknown operator $bogus"}},
+		{"operator with fields", obj(C.Int32("$add", 1), C.Int32("a", 1)),
+			CompileError{Path: "$add", Message: "an operator must be the only field of its document"}},
+		{"argument count", op("$subtract", AC.Int32(1)), CompileError{Path: "$subtract", Message: "expected exactly 2 arguments, got 1"}},
+		{"nested argument", op("$add", AC.Int32(1), op("$divide", AC.Int32(1))),
+			CompileError{Path: "$add.1.$divide", Message: "expected exactly 2 arguments, got 1"}},
+		{"undefined variable", AC.String("$$x"), CompileError{Path: "", Message: `undefined variable "x"`}},
+		{"variable out of scope", obj(C.Value("a", AC.DocumentFromElements(C.SubDocumentFromElements("$map",
+			C.ArrayFromElements("input"), C.String("as", "v"), C.String("in", "$$this"),
+		)))), CompileError{Path: "a.$map.in", Message: `undefined variable "this"`}},
+		{"invalid field path", AC.String("$a..b"), CompileError{Path: "", Message: `invalid field path "$a..b"`}},
+		{"missing argument", AC.DocumentFromElements(C.SubDocumentFromElements("$cond", C.Boolean("if", true))),
+			CompileError{Path: "$cond", Message: `missing required argument "then"`}},
+		{"unknown argument", AC.DocumentFromElements(C.SubDocumentFromElements("$map",
+			C.ArrayFromElements("input"), C.String("in", "$$this"), C.Int32("extra", 1),
+		)), CompileError{Path: "$map.extra", Message: "unknown argument"}},
+		{"invalid variable name", AC.DocumentFromElements(C.SubDocumentFromElements("$let",
+			C.SubDocumentFromElements("vars", C.Int32("X", 1)), C.Int32("in", 1),
+		)), CompileError{Path: "$let.vars.X", Message: "invalid variable name"}},
+		{"invalid date format", AC.DocumentFromElements(C.SubDocumentFromElements("$dateToString",
+			C.String("date", "$d"), C.String("format", "%Q"),
+		)), CompileError{Path: "$dateToString.format", Message: "invalid format character '%Q' in format string"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.expr)
+			if err != tc.want {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		expr *bson.Value
+		want error
+	}{
+		{"divide by zero", op("$divide", AC.Int32(1), AC.Double(0)), EvalError{Operator: "$divide", Message: "can't divide by zero"}},
+		{"mod by zero", op("$mod", AC.Int32(1), AC.Int64(0)), EvalError{Operator: "$mod", Message: "can't $mod by zero"}},
+		{"add string", op("$add", AC.Int32(1), AC.String("a")),
+			EvalError{Operator: "$add", Message: "only supports numeric or date types, not string"}},
+		{"size of non-array", op("$size", AC.Int32(1)), EvalError{Operator: "$size", Message: "argument must be an array, not int"}},
+		{"toInt overflow", op("$toInt", AC.Int64(1<<40)), EvalError{Operator: "$toInt", Message: "conversion would overflow the target type"}},
+		{"toInt unparsable", op("$toInt", AC.String("1.5")), EvalError{Operator: "$toInt", Message: `failed to parse number "1.5"`}},
+		{"switch without match", AC.DocumentFromElements(C.SubDocumentFromElements("$switch",
+			C.ArrayFromElements("branches", obj(C.Boolean("case", false), C.Int32("then", 1))),
+		)), EvalError{Operator: "$switch", Message: "no branch matched and no default was specified"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Evaluate(tc.expr, bson.Reader{0x05, 0x00, 0x00, 0x00, 0x00})
+			if err != tc.want {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSum(t *testing.T) {
+	testCases := []struct {
+		name   string
+		values []*bson.Value
+		sum    *bson.Value
+		avg    *bson.Value
+	}{
+		{"none", nil, AC.Int32(0), AC.Null()},
+		{"int32", []*bson.Value{AC.Int32(1), AC.Int32(2)}, AC.Int32(3), AC.Double(1.5)},
+		{"int32 overflow", []*bson.Value{AC.Int32(math.MaxInt32), AC.Int32(1)}, AC.Int64(math.MaxInt32 + 1), AC.Double(1 << 30)},
+		{"int64 overflow", []*bson.Value{AC.Int64(math.MaxInt64), AC.Int64(1)}, AC.Double(math.MaxInt64 + 1.0), AC.Double(1 << 62)},
+		{"non-numeric ignored", []*bson.Value{AC.String("1"), AC.Int32(4), AC.Null(), nil}, AC.Int32(4), AC.Double(4)},
+		{"double", []*bson.Value{AC.Int32(1), AC.Double(0.5)}, AC.Double(1.5), AC.Double(0.75)},
+		{"infinity", []*bson.Value{AC.Double(math.Inf(1)), AC.Int32(1)}, AC.Double(math.Inf(1)), AC.Double(math.Inf(1))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var s Sum
+			for _, v := range tc.values {
+				s.Add(v)
+			}
+			if got := s.Value(); !got.Equal(tc.sum) {
+				t.Errorf("Unexpected sum. got %v; want %v", got, tc.sum)
+			}
+			if got := s.Average(); !got.Equal(tc.avg) {
+				t.Errorf("Unexpected average. got %v; want %v", got, tc.avg)
+			}
+		})
+	}
+
+	t.Run("decimal", func(t *testing.T) {
+		var s Sum
+		s.Add(dec(t, "0.1"))
+		s.Add(dec(t, "0.2"))
+		s.Add(AC.Int32(1))
+		if got, want := s.Value(), dec(t, "1.3"); !got.Equal(want) {
+			t.Errorf("Unexpected sum. got %v; want %v", got, want)
+		}
+		s.Add(AC.Int32(0))
+		s.Add(AC.Int32(0))
+		if got, want := s.Average(), dec(t, "0.26"); !got.Equal(want) {
+			t.Errorf("Unexpected average. got %v; want %v", got, want)
+		}
+	})
+}
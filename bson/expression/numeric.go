@@ -0,0 +1,323 @@
+package expression
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/decimal"
+)
+
+var (
+	decimalNaN    = decimal.NewDecimal128(0x7C00000000000000, 0)
+	decimalPosInf = decimal.NewDecimal128(0x7800000000000000, 0)
+	decimalNegInf = decimal.NewDecimal128(0xF800000000000000, 0)
+)
+
+// number is a numeric value and the BSON type of the result it contributes to. Finite values are
+// held exactly in r. Values which are NaN or infinite have a nil r and are held in f.
+type number struct {
+	kind bson.Type
+	r    *big.Rat
+	f    float64
+}
+
+// numberOf returns the number v represents, and false if v is not numeric.
+func numberOf(v *bson.Value) (number, bool) {
+	if v == nil {
+		return number{}, false
+	}
+
+	switch v.Type() {
+	case bson.TypeInt32:
+		return number{kind: bson.TypeInt32, r: new(big.Rat).SetInt64(int64(v.Int32()))}, true
+	case bson.TypeInt64:
+		return number{kind: bson.TypeInt64, r: new(big.Rat).SetInt64(v.Int64())}, true
+	case bson.TypeDouble:
+		return fromFloat(bson.TypeDouble, v.Double()), true
+	case bson.TypeDecimal128:
+		d := v.Decimal128()
+		switch {
+		case d.IsNaN():
+			return number{kind: bson.TypeDecimal128, f: math.NaN()}, true
+		case d.IsInf(1):
+			return number{kind: bson.TypeDecimal128, f: math.Inf(1)}, true
+		case d.IsInf(-1):
+			return number{kind: bson.TypeDecimal128, f: math.Inf(-1)}, true
+		}
+
+		bi, exp, _ := d.BigInt()
+		r := new(big.Rat).SetInt(bi)
+		scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exp))), nil))
+		if exp < 0 {
+			r.Quo(r, scale)
+		} else {
+			r.Mul(r, scale)
+		}
+		return number{kind: bson.TypeDecimal128, r: r}, true
+	}
+
+	return number{}, false
+}
+
+func fromFloat(kind bson.Type, f float64) number {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return number{kind: kind, f: f}
+	}
+
+	return number{kind: kind, r: new(big.Rat).SetFloat64(f)}
+}
+
+func (n number) float() float64 {
+	if n.r == nil {
+		return n.f
+	}
+
+	f, _ := n.r.Float64()
+	return f
+}
+
+func (n number) isZero() bool {
+	return n.r != nil && n.r.Sign() == 0
+}
+
+// rat returns the exact value of the finite number n when used in a result of type kind. A double
+// used in a decimal result is first rounded to 15 significant digits, as MongoDB does.
+func (n number) rat(kind bson.Type) *big.Rat {
+	if kind != bson.TypeDecimal128 || n.kind != bson.TypeDouble {
+		return n.r
+	}
+
+	f, _ := n.r.Float64()
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'e', 14, 64))
+	return r
+}
+
+// combine returns the result of an arithmetic operation on a and b with the type kind. The
+// operation is computed exactly by exact if both numbers are finite, and by approx otherwise.
+func combine(kind bson.Type, a, b number, exact func(z, x, y *big.Rat) *big.Rat, approx func(x, y float64) float64) number {
+	if a.r == nil || b.r == nil {
+		return fromFloat(kind, approx(a.float(), b.float()))
+	}
+
+	return number{kind: kind, r: exact(new(big.Rat), a.rat(kind), b.rat(kind))}
+}
+
+func add(a, b number) number {
+	return combine(widest(a.kind, b.kind), a, b, (*big.Rat).Add, func(x, y float64) float64 { return x + y })
+}
+
+func subtract(a, b number) number {
+	return combine(widest(a.kind, b.kind), a, b, (*big.Rat).Sub, func(x, y float64) float64 { return x - y })
+}
+
+func multiply(a, b number) number {
+	return combine(widest(a.kind, b.kind), a, b, (*big.Rat).Mul, func(x, y float64) float64 { return x * y })
+}
+
+// divide returns a / b, which is a decimal if either is a decimal and a double otherwise. b must
+// not be zero.
+func divide(a, b number) number {
+	return combine(widest(widest(a.kind, b.kind), bson.TypeDouble), a, b, (*big.Rat).Quo, func(x, y float64) float64 { return x / y })
+}
+
+// modulo returns the remainder of a / b truncated towards zero, which has the sign of a. b must not
+// be zero.
+func modulo(a, b number) number {
+	return combine(widest(a.kind, b.kind), a, b, func(z, x, y *big.Rat) *big.Rat {
+		q := new(big.Rat).Quo(x, y)
+		t := new(big.Int).Quo(q.Num(), q.Denom())
+		return z.Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(t)))
+	}, math.Mod)
+}
+
+// value returns n as a BSON value of its type. Integers which overflow a 32-bit integer become
+// 64-bit integers, and those which overflow a 64-bit integer become doubles. Decimals have no
+// trailing zeros after the decimal point.
+func (n number) value() *bson.Value {
+	if n.r == nil {
+		if n.kind != bson.TypeDecimal128 {
+			return bson.AC.Double(n.f)
+		}
+		switch {
+		case math.IsInf(n.f, 1):
+			return bson.AC.Decimal128(decimalPosInf)
+		case math.IsInf(n.f, -1):
+			return bson.AC.Decimal128(decimalNegInf)
+		}
+		return bson.AC.Decimal128(decimalNaN)
+	}
+
+	switch n.kind {
+	case bson.TypeInt32, bson.TypeInt64:
+		if n.r.IsInt() && n.r.Num().IsInt64() {
+			i := n.r.Num().Int64()
+			if n.kind == bson.TypeInt32 && int64(int32(i)) == i {
+				return bson.AC.Int32(int32(i))
+			}
+			return bson.AC.Int64(i)
+		}
+	case bson.TypeDecimal128:
+		return bson.AC.Decimal128(ratToDecimal(n.r))
+	}
+
+	f, _ := n.r.Float64()
+	return bson.AC.Double(f)
+}
+
+// Sum accumulates the sum of numeric values using the same type promotion as $add: the result has
+// the widest type of the values added, in the order 32-bit integer, 64-bit integer, double, and
+// decimal, and integer results which overflow are promoted. The zero value is an empty Sum.
+type Sum struct {
+	n     number
+	count int64
+}
+
+// Add adds v to the sum and reports whether it is a number. Values which are not numbers, including
+// nil, are ignored.
+func (s *Sum) Add(v *bson.Value) bool {
+	n, ok := numberOf(v)
+	if !ok {
+		return false
+	}
+
+	if s.count == 0 {
+		s.n = n
+	} else {
+		s.n = add(s.n, n)
+	}
+	s.count++
+	return true
+}
+
+// Value returns the sum, which is the 32-bit integer 0 if no numbers were added.
+func (s *Sum) Value() *bson.Value {
+	if s.count == 0 {
+		return bson.AC.Int32(0)
+	}
+
+	return s.n.value()
+}
+
+// Average returns the mean of the numbers added, which is a decimal if any of them were decimals
+// and a double otherwise, or null if no numbers were added.
+func (s *Sum) Average() *bson.Value {
+	if s.count == 0 {
+		return bson.AC.Null()
+	}
+
+	return divide(s.n, number{kind: bson.TypeInt64, r: new(big.Rat).SetInt64(s.count)}).value()
+}
+
+// Multiply returns the product of a and b with the same type promotion as $multiply, and false if
+// either is not a number.
+func Multiply(a, b *bson.Value) (*bson.Value, bool) {
+	x, ok := numberOf(a)
+	if !ok {
+		return nil, false
+	}
+	y, ok := numberOf(b)
+	if !ok {
+		return nil, false
+	}
+
+	return multiply(x, y).value(), true
+}
+
+// ratToDecimal returns the Decimal128 nearest to r, rounding half to even. Values too large to be
+// represented become infinities and values too small to be represented become zero.
+func ratToDecimal(r *big.Rat) decimal.Decimal128 {
+	zero, _ := decimal.ParseDecimal128FromBigInt(new(big.Int), 0)
+	if r.Sign() == 0 {
+		return zero
+	}
+
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	ten := big.NewInt(10)
+
+	// Scale the quotient so that it has 34 digits before rounding.
+	exp := len(num.String()) - len(den.String()) - 34
+	for {
+		n, d := new(big.Int).Set(num), new(big.Int).Set(den)
+		scale := new(big.Int).Exp(ten, big.NewInt(int64(abs(exp))), nil)
+		if exp < 0 {
+			n.Mul(n, scale)
+		} else {
+			d.Mul(d, scale)
+		}
+
+		q, rem := new(big.Int).QuoRem(n, d, new(big.Int))
+		if len(q.String()) > 34 {
+			exp++
+			continue
+		}
+
+		c := new(big.Int).Lsh(rem, 1).Cmp(d)
+		if c > 0 || c == 0 && q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(1))
+			if len(q.String()) > 34 {
+				q.Quo(q, ten)
+				exp++
+			}
+		}
+
+		// Remove the trailing zeros introduced by scaling.
+		m := new(big.Int)
+		for exp < 0 && q.Sign() != 0 {
+			qq, mm := new(big.Int).QuoRem(q, ten, m)
+			if mm.Sign() != 0 {
+				break
+			}
+			q = qq
+			exp++
+		}
+
+		if r.Sign() < 0 {
+			q.Neg(q)
+		}
+		d128, ok := decimal.ParseDecimal128FromBigInt(q, exp)
+		switch {
+		case ok:
+			return d128
+		case exp < 0:
+			return zero
+		case r.Sign() < 0:
+			return decimalNegInf
+		}
+		return decimalPosInf
+	}
+}
+
+// widest returns the wider of the numeric types a and b.
+func widest(a, b bson.Type) bson.Type {
+	if rank(b) > rank(a) {
+		return b
+	}
+
+	return a
+}
+
+func rank(t bson.Type) int {
+	switch t {
+	case bson.TypeInt32:
+		return 1
+	case bson.TypeInt64:
+		return 2
+	case bson.TypeDouble:
+		return 3
+	case bson.TypeDecimal128:
+		return 4
+	}
+
+	return 0
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
@@ -0,0 +1,956 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// compileFunc compiles the argument v of the operator found at path.
+type compileFunc func(v *bson.Value, path string, vars []string) (node, error)
+
+// operators are the functions which compile each operator.
+var operators map[string]compileFunc
+
+func init() {
+	operators = map[string]compileFunc{
+		"$add":          eager(1, -1, evalAdd),
+		"$subtract":     eager(2, 2, evalSubtract),
+		"$multiply":     eager(1, -1, evalMultiply),
+		"$divide":       eager(2, 2, evalDivide),
+		"$mod":          eager(2, 2, evalMod),
+		"$concat":       eager(0, -1, evalConcat),
+		"$substrCP":     eager(3, 3, evalSubstrCP),
+		"$toUpper":      eager(1, 1, evalToUpper),
+		"$toLower":      eager(1, 1, evalToLower),
+		"$cond":         compileCond,
+		"$ifNull":       compileIfNull,
+		"$switch":       compileSwitch,
+		"$and":          compileAnd,
+		"$or":           compileOr,
+		"$not":          eager(1, 1, evalNot),
+		"$eq":           comparison(func(c int) bool { return c == 0 }),
+		"$ne":           comparison(func(c int) bool { return c != 0 }),
+		"$gt":           comparison(func(c int) bool { return c > 0 }),
+		"$gte":          comparison(func(c int) bool { return c >= 0 }),
+		"$lt":           comparison(func(c int) bool { return c < 0 }),
+		"$lte":          comparison(func(c int) bool { return c <= 0 }),
+		"$cmp":          eager(2, 2, evalCmp),
+		"$arrayElemAt":  eager(2, 2, evalArrayElemAt),
+		"$size":         eager(1, 1, evalSize),
+		"$map":          compileMap,
+		"$filter":       compileFilter,
+		"$reduce":       compileReduce,
+		"$dateToString": compileDateToString,
+		"$toString":     eager(1, 1, evalToString),
+		"$toInt":        eager(1, 1, evalToInt),
+		"$type":         eager(1, 1, evalType),
+		"$let":          compileLet,
+		"$literal":      compileLiteral,
+	}
+}
+
+// opNode is an operator whose arguments are all evaluated before the operator.
+type opNode struct {
+	name string
+	args []node
+	f    func(op string, args []*bson.Value) (*bson.Value, error)
+}
+
+func (on opNode) eval(s *scope) (*bson.Value, error) {
+	args, err := evalAll(s, on.args)
+	if err != nil {
+		return nil, err
+	}
+
+	return on.f(on.name, args)
+}
+
+// eager returns a compileFunc for an operator taking between min and max arguments, all of which
+// are evaluated and given to f. A max of -1 means there is no limit.
+func eager(min, max int, f func(op string, args []*bson.Value) (*bson.Value, error)) compileFunc {
+	return func(v *bson.Value, path string, vars []string) (node, error) {
+		args, err := compileArgs(v, path, vars, min, max)
+		if err != nil {
+			return nil, err
+		}
+
+		return opNode{name: path[strings.LastIndex(path, ".")+1:], args: args, f: f}, nil
+	}
+}
+
+// compileArgs compiles the arguments of the operator at path, which are either an array of
+// expressions or a single expression.
+func compileArgs(v *bson.Value, path string, vars []string, min, max int) ([]node, error) {
+	values := []*bson.Value{v}
+	array := v.Type() == bson.TypeArray
+	if array {
+		var err error
+		values, err = elements(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(values) < min || max >= 0 && len(values) > max {
+		var want string
+		switch {
+		case min == max:
+			want = fmt.Sprintf("exactly %d", min)
+		case max < 0:
+			want = fmt.Sprintf("at least %d", min)
+		default:
+			want = fmt.Sprintf("between %d and %d", min, max)
+		}
+		return nil, CompileError{Path: path, Message: fmt.Sprintf("expected %s arguments, got %d", want, len(values))}
+	}
+
+	args := make([]node, 0, len(values))
+	for i, arg := range values {
+		apath := path
+		if array {
+			apath = joinPath(path, strconv.Itoa(i))
+		}
+
+		n, err := compile(arg, apath, vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, n)
+	}
+
+	return args, nil
+}
+
+// options returns the named arguments of the operator at path, which must be a document with each
+// of the required fields and any of the optional fields.
+func options(v *bson.Value, path string, required, optional []string) (map[string]*bson.Value, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "expected an object"}
+	}
+
+	itr, err := v.ReaderDocument().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := make(map[string]*bson.Value)
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		if !contains(required, elem.Key()) && !contains(optional, elem.Key()) {
+			return nil, CompileError{Path: joinPath(path, elem.Key()), Message: "unknown argument"}
+		}
+		opts[elem.Key()] = elem.Value()
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+
+	for _, name := range required {
+		if _, ok := opts[name]; !ok {
+			return nil, CompileError{Path: path, Message: fmt.Sprintf("missing required argument %q", name)}
+		}
+	}
+
+	return opts, nil
+}
+
+// compileOption compiles the named argument name of opts, or returns nil if it is not present.
+func compileOption(opts map[string]*bson.Value, name, path string, vars []string) (node, error) {
+	v, ok := opts[name]
+	if !ok {
+		return nil, nil
+	}
+
+	return compile(v, joinPath(path, name), vars)
+}
+
+// compileVariableName returns the name of a variable defined by the named argument name of opts,
+// or def if it is not present.
+func compileVariableName(opts map[string]*bson.Value, name, def, path string) (string, error) {
+	v, ok := opts[name]
+	if !ok {
+		return def, nil
+	}
+	if v.Type() != bson.TypeString || !validVariable(v.StringValue()) {
+		return "", CompileError{Path: joinPath(path, name), Message: "must be a valid variable name"}
+	}
+
+	return v.StringValue(), nil
+}
+
+// validVariable reports whether name can be defined as a variable. Variable names start with a
+// lowercase letter or a non-ASCII character and contain only letters, digits, and underscores.
+func validVariable(name string) bool {
+	for i, r := range name {
+		switch {
+		case i == 0 && !(r >= 'a' && r <= 'z' || r > unicode.MaxASCII):
+			return false
+		case !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'):
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// define returns vars with name added.
+func define(vars []string, names ...string) []string {
+	return append(vars[:len(vars):len(vars)], names...)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nullish reports whether v is missing, null, or undefined.
+func nullish(v *bson.Value) bool {
+	return v == nil || v.Type() == bson.TypeNull || v.Type() == bson.TypeUndefined
+}
+
+// truthy reports whether v is true when used as a condition. Missing, null, undefined, false, and
+// zero values are false and all other values are true.
+func truthy(v *bson.Value) bool {
+	if nullish(v) {
+		return false
+	}
+	if v.Type() == bson.TypeBoolean {
+		return v.Boolean()
+	}
+	if n, ok := numberOf(v); ok {
+		return !n.isZero()
+	}
+
+	return true
+}
+
+// typeName returns the type alias of v, or "missing" if v is nil.
+func typeName(v *bson.Value) string {
+	if v == nil {
+		return "missing"
+	}
+
+	return v.Type().Alias()
+}
+
+// integral returns the value of a number with an integral value which fits in a 64-bit integer.
+func integral(v *bson.Value) (int64, bool) {
+	n, ok := numberOf(v)
+	if !ok || n.r == nil || !n.r.IsInt() || !n.r.Num().IsInt64() {
+		return 0, false
+	}
+
+	return n.r.Num().Int64(), true
+}
+
+func evalAdd(op string, args []*bson.Value) (*bson.Value, error) {
+	sum := number{kind: bson.TypeInt32, r: new(big.Rat)}
+	var date *bson.Value
+	for _, arg := range args {
+		if nullish(arg) {
+			return bson.AC.Null(), nil
+		}
+		if arg.Type() == bson.TypeDateTime {
+			if date != nil {
+				return nil, EvalError{Operator: op, Message: "only one date allowed"}
+			}
+			date = arg
+			continue
+		}
+
+		n, ok := numberOf(arg)
+		if !ok {
+			return nil, EvalError{Operator: op, Message: fmt.Sprintf("only supports numeric or date types, not %s", typeName(arg))}
+		}
+		sum = add(sum, n)
+	}
+
+	if date != nil {
+		return addMillis(op, date, sum.float())
+	}
+
+	return sum.value(), nil
+}
+
+// addMillis returns the date ms milliseconds after date.
+func addMillis(op string, date *bson.Value, ms float64) (*bson.Value, error) {
+	if math.IsNaN(ms) || math.IsInf(ms, 0) {
+		return nil, EvalError{Operator: op, Message: "cannot add a non-finite number to a date"}
+	}
+
+	return bson.AC.DateTime(dateMillis(date) + int64(math.Round(ms))), nil
+}
+
+func evalSubtract(op string, args []*bson.Value) (*bson.Value, error) {
+	a, b := args[0], args[1]
+	if nullish(a) || nullish(b) {
+		return bson.AC.Null(), nil
+	}
+
+	if a.Type() == bson.TypeDateTime {
+		if b.Type() == bson.TypeDateTime {
+			return bson.AC.Int64(dateMillis(a) - dateMillis(b)), nil
+		}
+		if n, ok := numberOf(b); ok {
+			return addMillis(op, a, -n.float())
+		}
+	}
+
+	x, ok := numberOf(a)
+	y, ok2 := numberOf(b)
+	if !ok || !ok2 {
+		return nil, EvalError{Operator: op, Message: fmt.Sprintf("cannot subtract %s from %s", typeName(b), typeName(a))}
+	}
+
+	return subtract(x, y).value(), nil
+}
+
+func evalMultiply(op string, args []*bson.Value) (*bson.Value, error) {
+	product := number{kind: bson.TypeInt32, r: big.NewRat(1, 1)}
+	for _, arg := range args {
+		if nullish(arg) {
+			return bson.AC.Null(), nil
+		}
+
+		n, ok := numberOf(arg)
+		if !ok {
+			return nil, EvalError{Operator: op, Message: fmt.Sprintf("only supports numeric types, not %s", typeName(arg))}
+		}
+		product = multiply(product, n)
+	}
+
+	return product.value(), nil
+}
+
+// numericArgs returns the two numeric arguments of op, or false if either is null or missing.
+func numericArgs(op string, args []*bson.Value) (number, number, bool, error) {
+	if nullish(args[0]) || nullish(args[1]) {
+		return number{}, number{}, false, nil
+	}
+
+	x, ok := numberOf(args[0])
+	y, ok2 := numberOf(args[1])
+	if !ok || !ok2 {
+		return x, y, false, EvalError{
+			Operator: op,
+			Message:  fmt.Sprintf("only supports numeric types, not %s and %s", typeName(args[0]), typeName(args[1])),
+		}
+	}
+
+	return x, y, true, nil
+}
+
+func evalDivide(op string, args []*bson.Value) (*bson.Value, error) {
+	x, y, ok, err := numericArgs(op, args)
+	if !ok {
+		return bson.AC.Null(), err
+	}
+	if y.isZero() {
+		return nil, EvalError{Operator: op, Message: "can't divide by zero"}
+	}
+
+	return divide(x, y).value(), nil
+}
+
+func evalMod(op string, args []*bson.Value) (*bson.Value, error) {
+	x, y, ok, err := numericArgs(op, args)
+	if !ok {
+		return bson.AC.Null(), err
+	}
+	if y.isZero() {
+		return nil, EvalError{Operator: op, Message: "can't $mod by zero"}
+	}
+
+	return modulo(x, y).value(), nil
+}
+
+func evalConcat(op string, args []*bson.Value) (*bson.Value, error) {
+	var sb strings.Builder
+	for _, arg := range args {
+		if nullish(arg) {
+			return bson.AC.Null(), nil
+		}
+		if arg.Type() != bson.TypeString {
+			return nil, EvalError{Operator: op, Message: fmt.Sprintf("only supports strings, not %s", typeName(arg))}
+		}
+		sb.WriteString(arg.StringValue())
+	}
+
+	return bson.AC.String(sb.String()), nil
+}
+
+// coerceString returns v converted to a string for the string operators, which treat null and
+// missing values as the empty string.
+func coerceString(op string, v *bson.Value) (string, error) {
+	if nullish(v) {
+		return "", nil
+	}
+
+	s, ok := stringOf(v)
+	if !ok {
+		return "", EvalError{Operator: op, Message: fmt.Sprintf("can't convert from BSON type %s to String", typeName(v))}
+	}
+
+	return s, nil
+}
+
+func evalSubstrCP(op string, args []*bson.Value) (*bson.Value, error) {
+	s, err := coerceString(op, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	start, ok := integral(args[1])
+	if !ok || start < 0 {
+		return nil, EvalError{Operator: op, Message: "starting index must be a non-negative integer"}
+	}
+	count, ok := integral(args[2])
+	if !ok || count < 0 {
+		return nil, EvalError{Operator: op, Message: "length must be a non-negative integer"}
+	}
+
+	runes := []rune(s)
+	if start > int64(len(runes)) {
+		start = int64(len(runes))
+	}
+	end := int64(len(runes))
+	if count < end-start {
+		end = start + count
+	}
+
+	return bson.AC.String(string(runes[start:end])), nil
+}
+
+func evalToUpper(op string, args []*bson.Value) (*bson.Value, error) {
+	s, err := coerceString(op, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.AC.String(strings.ToUpper(s)), nil
+}
+
+func evalToLower(op string, args []*bson.Value) (*bson.Value, error) {
+	s, err := coerceString(op, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.AC.String(strings.ToLower(s)), nil
+}
+
+// condNode is $cond, which evaluates only the branch selected by its condition.
+type condNode struct {
+	cond, then, els node
+}
+
+func (cn condNode) eval(s *scope) (*bson.Value, error) {
+	v, err := cn.cond.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(v) {
+		return cn.then.eval(s)
+	}
+
+	return cn.els.eval(s)
+}
+
+// compileCond compiles $cond, which is either [if, then, else] or
+// {"if": <expr>, "then": <expr>, "else": <expr>}.
+func compileCond(v *bson.Value, path string, vars []string) (node, error) {
+	if v.Type() == bson.TypeArray {
+		args, err := compileArgs(v, path, vars, 3, 3)
+		if err != nil {
+			return nil, err
+		}
+		return condNode{cond: args[0], then: args[1], els: args[2]}, nil
+	}
+
+	opts, err := options(v, path, []string{"if", "then", "else"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var cn condNode
+	for _, f := range []struct {
+		name string
+		n    *node
+	}{{"if", &cn.cond}, {"then", &cn.then}, {"else", &cn.els}} {
+		*f.n, err = compileOption(opts, f.name, path, vars)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// ifNullNode is $ifNull, which evaluates to the first of its arguments which is not null or
+// missing, or to its last argument.
+type ifNullNode []node
+
+func (in ifNullNode) eval(s *scope) (*bson.Value, error) {
+	for _, n := range in[:len(in)-1] {
+		v, err := n.eval(s)
+		if err != nil || !nullish(v) {
+			return v, err
+		}
+	}
+
+	return in[len(in)-1].eval(s)
+}
+
+func compileIfNull(v *bson.Value, path string, vars []string) (node, error) {
+	args, err := compileArgs(v, path, vars, 2, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return ifNullNode(args), nil
+}
+
+// switchNode is $switch, which evaluates the first branch whose case is true.
+type switchNode struct {
+	cases, thens []node
+	def          node
+}
+
+func (sn switchNode) eval(s *scope) (*bson.Value, error) {
+	for i, c := range sn.cases {
+		v, err := c.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(v) {
+			return sn.thens[i].eval(s)
+		}
+	}
+
+	if sn.def == nil {
+		return nil, EvalError{Operator: "$switch", Message: "no branch matched and no default was specified"}
+	}
+
+	return sn.def.eval(s)
+}
+
+// compileSwitch compiles $switch, which is of the form
+// {"branches": [{"case": <expr>, "then": <expr>}, ...], "default": <expr>}.
+func compileSwitch(v *bson.Value, path string, vars []string) (node, error) {
+	opts, err := options(v, path, []string{"branches"}, []string{"default"})
+	if err != nil {
+		return nil, err
+	}
+
+	bpath := joinPath(path, "branches")
+	if opts["branches"].Type() != bson.TypeArray {
+		return nil, CompileError{Path: bpath, Message: "must be an array"}
+	}
+	branches, err := elements(opts["branches"])
+	if err != nil {
+		return nil, err
+	}
+
+	var sn switchNode
+	for i, branch := range branches {
+		ipath := joinPath(bpath, strconv.Itoa(i))
+		bopts, err := options(branch, ipath, []string{"case", "then"}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := compileOption(bopts, "case", ipath, vars)
+		if err != nil {
+			return nil, err
+		}
+		then, err := compileOption(bopts, "then", ipath, vars)
+		if err != nil {
+			return nil, err
+		}
+		sn.cases = append(sn.cases, c)
+		sn.thens = append(sn.thens, then)
+	}
+	if len(sn.cases) == 0 {
+		return nil, CompileError{Path: bpath, Message: "must contain at least one branch"}
+	}
+
+	sn.def, err = compileOption(opts, "default", path, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return sn, nil
+}
+
+// logicalNode is $and if all is true and $or otherwise. It stops evaluating its arguments once
+// the result is known.
+type logicalNode struct {
+	args []node
+	all  bool
+}
+
+func (ln logicalNode) eval(s *scope) (*bson.Value, error) {
+	for _, n := range ln.args {
+		v, err := n.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(v) != ln.all {
+			return bson.AC.Boolean(!ln.all), nil
+		}
+	}
+
+	return bson.AC.Boolean(ln.all), nil
+}
+
+func compileAnd(v *bson.Value, path string, vars []string) (node, error) {
+	args, err := compileArgs(v, path, vars, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return logicalNode{args: args, all: true}, nil
+}
+
+func compileOr(v *bson.Value, path string, vars []string) (node, error) {
+	args, err := compileArgs(v, path, vars, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	return logicalNode{args: args}, nil
+}
+
+func evalNot(op string, args []*bson.Value) (*bson.Value, error) {
+	return bson.AC.Boolean(!truthy(args[0])), nil
+}
+
+// compare compares a and b like bson.CompareValues, with missing values ordered after MinKey and
+// before all other values.
+func compare(a, b *bson.Value) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		if b.Type() == bson.TypeMinKey {
+			return 1
+		}
+		return -1
+	case b == nil:
+		return -compare(b, a)
+	}
+
+	return bson.CompareValues(a, b)
+}
+
+// comparison returns a compileFunc for a comparison operator, which is true if test returns true
+// for the result of comparing its two arguments.
+func comparison(test func(c int) bool) compileFunc {
+	return eager(2, 2, func(op string, args []*bson.Value) (*bson.Value, error) {
+		return bson.AC.Boolean(test(compare(args[0], args[1]))), nil
+	})
+}
+
+func evalCmp(op string, args []*bson.Value) (*bson.Value, error) {
+	return bson.AC.Int32(int32(compare(args[0], args[1]))), nil
+}
+
+func evalArrayElemAt(op string, args []*bson.Value) (*bson.Value, error) {
+	if nullish(args[0]) || nullish(args[1]) {
+		return bson.AC.Null(), nil
+	}
+	if args[0].Type() != bson.TypeArray {
+		return nil, EvalError{Operator: op, Message: fmt.Sprintf("first argument must be an array, not %s", typeName(args[0]))}
+	}
+	i, ok := integral(args[1])
+	if !ok {
+		return nil, EvalError{Operator: op, Message: "second argument must be an integer"}
+	}
+
+	values, err := elements(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		i += int64(len(values))
+	}
+	if i < 0 || i >= int64(len(values)) {
+		return nil, nil
+	}
+
+	return values[i], nil
+}
+
+func evalSize(op string, args []*bson.Value) (*bson.Value, error) {
+	if args[0] == nil || args[0].Type() != bson.TypeArray {
+		return nil, EvalError{Operator: op, Message: fmt.Sprintf("argument must be an array, not %s", typeName(args[0]))}
+	}
+
+	values, err := elements(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.AC.Int32(int32(len(values))), nil
+}
+
+// inputArray evaluates the input array of $map, $filter, or $reduce. It returns false if the input
+// is null or missing.
+func inputArray(op string, input node, s *scope) ([]*bson.Value, bool, error) {
+	v, err := input.eval(s)
+	if err != nil || nullish(v) {
+		return nil, false, err
+	}
+	if v.Type() != bson.TypeArray {
+		return nil, false, EvalError{Operator: op, Message: fmt.Sprintf("input must be an array, not %s", typeName(v))}
+	}
+
+	values, err := elements(v)
+	return values, err == nil, err
+}
+
+// mapNode is $map, which evaluates in for each element of input with the element as the variable
+// as.
+type mapNode struct {
+	input, in node
+	as        string
+}
+
+func (mn mapNode) eval(s *scope) (*bson.Value, error) {
+	values, ok, err := inputArray("$map", mn.input, s)
+	if !ok {
+		return bson.AC.Null(), err
+	}
+
+	for i, v := range values {
+		values[i], err = mn.in.eval(s.with(mn.as, v))
+		if err != nil {
+			return nil, err
+		}
+		if values[i] == nil {
+			values[i] = bson.AC.Null()
+		}
+	}
+
+	return bson.AC.ArrayFromValues(values...), nil
+}
+
+// compileMap compiles $map, which is of the form {"input": <expr>, "as": <name>, "in": <expr>}. The
+// variable name defaults to "this".
+func compileMap(v *bson.Value, path string, vars []string) (node, error) {
+	opts, err := options(v, path, []string{"input", "in"}, []string{"as"})
+	if err != nil {
+		return nil, err
+	}
+
+	var mn mapNode
+	if mn.as, err = compileVariableName(opts, "as", "this", path); err != nil {
+		return nil, err
+	}
+	if mn.input, err = compileOption(opts, "input", path, vars); err != nil {
+		return nil, err
+	}
+	if mn.in, err = compileOption(opts, "in", path, define(vars, mn.as)); err != nil {
+		return nil, err
+	}
+
+	return mn, nil
+}
+
+// filterNode is $filter, which selects the elements of input for which cond is true with the
+// element as the variable as, up to limit elements.
+type filterNode struct {
+	input, cond, limit node
+	as                 string
+}
+
+func (fn filterNode) eval(s *scope) (*bson.Value, error) {
+	values, ok, err := inputArray("$filter", fn.input, s)
+	if !ok {
+		return bson.AC.Null(), err
+	}
+
+	limit := int64(len(values))
+	if fn.limit != nil {
+		v, err := fn.limit.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if !nullish(v) {
+			n, ok := integral(v)
+			if !ok || n <= 0 {
+				return nil, EvalError{Operator: "$filter", Message: "limit must be a positive integer"}
+			}
+			limit = n
+		}
+	}
+
+	var out []*bson.Value
+	for _, v := range values {
+		if int64(len(out)) == limit {
+			break
+		}
+
+		c, err := fn.cond.eval(s.with(fn.as, v))
+		if err != nil {
+			return nil, err
+		}
+		if truthy(c) {
+			out = append(out, v)
+		}
+	}
+
+	return bson.AC.ArrayFromValues(out...), nil
+}
+
+// compileFilter compiles $filter, which is of the form
+// {"input": <expr>, "as": <name>, "cond": <expr>, "limit": <expr>}. The variable name defaults to
+// "this".
+func compileFilter(v *bson.Value, path string, vars []string) (node, error) {
+	opts, err := options(v, path, []string{"input", "cond"}, []string{"as", "limit"})
+	if err != nil {
+		return nil, err
+	}
+
+	var fn filterNode
+	if fn.as, err = compileVariableName(opts, "as", "this", path); err != nil {
+		return nil, err
+	}
+	if fn.input, err = compileOption(opts, "input", path, vars); err != nil {
+		return nil, err
+	}
+	if fn.cond, err = compileOption(opts, "cond", path, define(vars, fn.as)); err != nil {
+		return nil, err
+	}
+	if fn.limit, err = compileOption(opts, "limit", path, vars); err != nil {
+		return nil, err
+	}
+
+	return fn, nil
+}
+
+// reduceNode is $reduce, which evaluates in for each element of input with the element as the
+// variable this and the previous result, starting with initial, as the variable value.
+type reduceNode struct {
+	input, initial, in node
+}
+
+func (rn reduceNode) eval(s *scope) (*bson.Value, error) {
+	values, ok, err := inputArray("$reduce", rn.input, s)
+	if !ok {
+		return bson.AC.Null(), err
+	}
+
+	acc, err := rn.initial.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		acc, err = rn.in.eval(s.with("value", acc).with("this", v))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return acc, nil
+}
+
+// compileReduce compiles $reduce, which is of the form
+// {"input": <expr>, "initialValue": <expr>, "in": <expr>}.
+func compileReduce(v *bson.Value, path string, vars []string) (node, error) {
+	opts, err := options(v, path, []string{"input", "initialValue", "in"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var rn reduceNode
+	if rn.input, err = compileOption(opts, "input", path, vars); err != nil {
+		return nil, err
+	}
+	if rn.initial, err = compileOption(opts, "initialValue", path, vars); err != nil {
+		return nil, err
+	}
+	if rn.in, err = compileOption(opts, "in", path, define(vars, "value", "this")); err != nil {
+		return nil, err
+	}
+
+	return rn, nil
+}
+
+// letNode is $let, which evaluates in with additional variables.
+type letNode struct {
+	names  []string
+	values []node
+	in     node
+}
+
+func (ln letNode) eval(s *scope) (*bson.Value, error) {
+	values, err := evalAll(s, ln.values)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := s
+	for i, name := range ln.names {
+		inner = inner.with(name, values[i])
+	}
+
+	return ln.in.eval(inner)
+}
+
+// compileLet compiles $let, which is of the form {"vars": {<name>: <expr>, ...}, "in": <expr>}.
+func compileLet(v *bson.Value, path string, vars []string) (node, error) {
+	opts, err := options(v, path, []string{"vars", "in"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	vpath := joinPath(path, "vars")
+	if opts["vars"].Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: vpath, Message: "must be an object"}
+	}
+	itr, err := opts["vars"].ReaderDocument().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var ln letNode
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		name := elem.Key()
+		if !validVariable(name) {
+			return nil, CompileError{Path: joinPath(vpath, name), Message: "invalid variable name"}
+		}
+
+		n, err := compile(elem.Value(), joinPath(vpath, name), vars)
+		if err != nil {
+			return nil, err
+		}
+		ln.names = append(ln.names, name)
+		ln.values = append(ln.values, n)
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+
+	if ln.in, err = compileOption(opts, "in", path, define(vars, ln.names...)); err != nil {
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+func compileLiteral(v *bson.Value, path string, vars []string) (node, error) {
+	return literal{v}, nil
+}
@@ -5,12 +5,15 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"path"
 	"strconv"
 	"testing"
 	"unicode"
 
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/parser"
 	"github.com/stretchr/testify/require"
 	"github.com/tidwall/pretty"
 )
@@ -49,7 +52,7 @@ type parseErrorTestCase struct {
 
 const dataDir = "../../data"
 
-func findJSONFilesInDir(t *testing.T, dir string) []string {
+func findJSONFilesInDir(t testing.TB, dir string) []string {
 	files := make([]string, 0)
 
 	entries, err := ioutil.ReadDir(dir)
@@ -70,43 +73,19 @@ func needsEscapedUnicode(bsonType string) bool {
 	return bsonType == "0x02" || bsonType == "0x0D" || bsonType == "0x0F"
 }
 
+// escape escapes the non-ASCII characters in s if escapeUnicode is true, since the corpus
+// escapes them but BsonToExtJSON does not.
 func escape(s string, escapeUnicode bool) string {
-	newS := ""
-	lastWasBackslash := false
+	if !escapeUnicode {
+		return s
+	}
 
+	newS := ""
 	for _, r := range s {
-		switch r {
-		case 0x8:
-			newS += `\b`
-		case 0x9:
-			newS += `\t`
-		case 0xA:
-			newS += `\n`
-		case 0xC:
-			newS += `\f`
-		case 0xD:
-			newS += `\r`
-		case '\\':
-			newS += `\\`
-		case '"':
-			if lastWasBackslash {
-				newS += `\"`
-			} else {
-				newS += `"`
-			}
-		default:
-			if r < ' ' || (r > unicode.MaxASCII && escapeUnicode) {
-				code := fmt.Sprintf("%04x", r)
-				newS += `\u` + code
-			} else {
-				newS += string(r)
-			}
-		}
-
-		if r == '\\' {
-			lastWasBackslash = true
+		if r > unicode.MaxASCII {
+			newS += fmt.Sprintf(`\u%04x`, r)
 		} else {
-			lastWasBackslash = false
+			newS += string(r)
 		}
 	}
 
@@ -172,9 +151,67 @@ func runTest(t *testing.T, file string) {
 				require.True(t, bytes.Equal(cB, actualBytes))
 			}
 		}
+
+		for _, d := range test.DecodeErrors {
+			b, err := hex.DecodeString(d.Bson)
+			require.NoError(t, err)
+
+			// Validate allows trailing bytes, so a document which does not span the whole
+			// input is also rejected.
+			size, err := bson.Reader(b).Validate()
+			if err == nil {
+				require.NotEqual(t, len(b), int(size), d.Description)
+			} else {
+				requireDecodeError(t, err, d.Description)
+			}
+
+			_, err = bson.ReadDocument(b)
+			requireDecodeError(t, err, d.Description)
+
+			_, err = BsonToExtJSON(true, b)
+			requireDecodeError(t, err, d.Description)
+		}
+
+		for _, p := range test.ParseErrors {
+			_, err := ParseObjectToBuilder(p.String)
+			require.Error(t, err, p.Description)
+		}
 	})
 }
 
+// decodeErrors are the errors which may be returned for the documents in the decodeErrors
+// suites.
+var decodeErrors = []error{
+	bson.ErrInvalidReadOnlyDocument,
+	bson.ErrInvalidLength,
+	bson.ErrTooSmall,
+	bson.ErrInvalidKey,
+	bson.ErrInvalidString,
+	bson.ErrInvalidBinarySubtype,
+	bson.ErrInvalidBooleanType,
+	bson.ErrStringLargerThanContainer,
+	bson.ErrInvalidElement,
+	bson.ErrInvalidUTF8,
+	parser.ErrCorruptDocument,
+	parser.ErrUnknownSubtype,
+	parser.ErrInvalidUTF8,
+	io.ErrUnexpectedEOF,
+}
+
+// requireDecodeError requires err to be one of decodeErrors.
+func requireDecodeError(t *testing.T, err error, description string) {
+	t.Helper()
+
+	require.Error(t, err, description)
+	for _, want := range decodeErrors {
+		if err == want {
+			return
+		}
+	}
+
+	t.Fatalf("%s: unexpected error type: %v", description, err)
+}
+
 func Test_BsonCorpus(t *testing.T) {
 	for _, file := range findJSONFilesInDir(t, dataDir) {
 		runTest(t, file)
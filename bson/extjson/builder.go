@@ -6,6 +6,9 @@ import (
 	"errors"
 
 	"strconv"
+	"strings"
+
+	"unicode/utf8"
 
 	"github.com/buger/jsonparser"
 	"github.com/skriptble/wilson/bson/builder"
@@ -14,8 +17,15 @@ import (
 type docElementParser func([]byte, []byte, jsonparser.ValueType, int) error
 type arrayElementParser func([]byte, jsonparser.ValueType, int, error)
 
+// ErrInvalidUTF8 is returned when the JSON being parsed is not valid UTF-8.
+var ErrInvalidUTF8 = errors.New("extjson: invalid UTF-8")
+
 // ParseObjectToBuilder parses a JSON object string into a *builder.DocumentBuilder.
 func ParseObjectToBuilder(s string) (*builder.DocumentBuilder, error) {
+	if !utf8.ValidString(s) {
+		return nil, ErrInvalidUTF8
+	}
+
 	b := builder.NewDocumentBuilder()
 	err := parseObjectToBuilder(b, s, nil, true)
 	if err != nil {
@@ -27,6 +37,10 @@ func ParseObjectToBuilder(s string) (*builder.DocumentBuilder, error) {
 
 // ParseArrayToBuilder parses a JSON array string into a *builder.ArrayBuilder.
 func ParseArrayToBuilder(s string) (*builder.ArrayBuilder, error) {
+	if !utf8.ValidString(s) {
+		return nil, ErrInvalidUTF8
+	}
+
 	return parseArrayToBuilder(s, true)
 }
 
@@ -97,6 +111,10 @@ func parseObjectToBuilder(b *builder.DocumentBuilder, s string, containingKey *s
 
 func parseDocElement(b *builder.DocumentBuilder, ext bool) docElementParser {
 	return func(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
+		// Keys are null terminated in BSON, so they cannot contain a null byte.
+		if strings.IndexByte(string(key), 0x00) >= 0 {
+			return fmt.Errorf("key contains a null byte: %q", key)
+		}
 		name := string(key)
 
 		switch dataType {
@@ -33,6 +33,9 @@ func BsonToExtJSON(canonical bool, bson []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if int(doc.Length) != len(bson) {
+		return "", parser.ErrCorruptDocument
+	}
 
 	w := &extJSONWriter{bytes.NewBuffer([]byte{}), canonical}
 	err = w.writeDocument(doc)
@@ -43,11 +46,35 @@ func BsonToExtJSON(canonical bool, bson []byte) (string, error) {
 	return w.String(), nil
 }
 
+// writeStringLiteral writes s as a JSON string, escaping quotes, backslashes, and control
+// characters.
 func (w *extJSONWriter) writeStringLiteral(s string) error {
-	s = `"` + s + `"`
-	_, err := w.Write([]byte(s))
+	w.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			w.WriteByte('\\')
+			w.WriteRune(r)
+		case '\b':
+			w.WriteString(`\b`)
+		case '\f':
+			w.WriteString(`\f`)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\t':
+			w.WriteString(`\t`)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(w, `\u%04x`, r)
+				break
+			}
+			w.WriteRune(r)
+		}
+	}
 
-	return err
+	return w.WriteByte('"')
 }
 
 func (w *extJSONWriter) writeNonExtDocument(d *ast.Document) error {
@@ -217,7 +244,9 @@ func (w *extJSONWriter) writeFloatValue(f float64) error {
 
 	var err error
 
-	if w.canonical {
+	// Relaxed extended JSON has no representation for non-finite doubles, so they are always
+	// written in canonical form.
+	if w.canonical || math.IsInf(f, 0) || math.IsNaN(f) {
 		d := newDoc(newStringElement("$numberDouble", s))
 		err = w.writeDocument(d)
 	} else {
@@ -240,7 +269,11 @@ func formatDouble(f float64) string {
 		// perfectly represent it.
 		s = strconv.FormatFloat(f, 'G', -1, 64)
 		if !strings.ContainsRune(s, '.') {
-			s += ".0"
+			if i := strings.IndexByte(s, 'E'); i >= 0 {
+				s = s[:i] + ".0" + s[i:]
+			} else {
+				s += ".0"
+			}
 		}
 	}
 
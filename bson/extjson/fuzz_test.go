@@ -0,0 +1,101 @@
+package extjson
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// corpusSeeds returns the canonical BSON and extended JSON of the valid test cases, and the
+// strings of the parse error test cases, in the BSON corpus.
+func corpusSeeds(f *testing.F) (docs [][]byte, extJSON []string) {
+	for _, file := range findJSONFilesInDir(f, dataDir) {
+		content, err := ioutil.ReadFile(path.Join(dataDir, file))
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var test testCase
+		err = json.Unmarshal(content, &test)
+		if err != nil {
+			f.Fatalf("%s: %v", file, err)
+		}
+
+		for _, v := range test.Valid {
+			b, err := hex.DecodeString(v.CanonicalBson)
+			if err != nil {
+				f.Fatalf("%s: %v", file, err)
+			}
+			docs = append(docs, b)
+			extJSON = append(extJSON, v.CanonicalExtJSON)
+		}
+		for _, p := range test.ParseErrors {
+			extJSON = append(extJSON, p.String)
+		}
+	}
+
+	return docs, extJSON
+}
+
+func FuzzBsonToExtJSON(f *testing.F) {
+	docs, _ := corpusSeeds(f)
+	for _, b := range docs {
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// Keys starting with a $ may be read as the keys of a wrapper object, so documents
+		// which contain them cannot be round tripped.
+		keys, err := bson.Reader(b).Keys(true)
+		if err != nil {
+			return
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key.Name, "$") {
+				return
+			}
+		}
+
+		for _, canonical := range []bool{true, false} {
+			s, err := BsonToExtJSON(canonical, b)
+			if err != nil {
+				return
+			}
+
+			_, err = ParseObjectToBuilder(s)
+			if err != nil {
+				t.Fatalf("Unexpected error parsing %s: %v", s, err)
+			}
+		}
+	})
+}
+
+func FuzzParseObjectToBuilder(f *testing.F) {
+	_, extJSON := corpusSeeds(f)
+	for _, s := range extJSON {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		doc, err := ParseObjectToBuilder(s)
+		if err != nil {
+			return
+		}
+
+		b := make([]byte, doc.RequiredBytes())
+		_, err = doc.WriteDocument(b)
+		if err != nil {
+			return
+		}
+
+		_, err = BsonToExtJSON(true, b)
+		if err != nil {
+			t.Fatalf("Unexpected error converting %s back to extended JSON: %v", s, err)
+		}
+	})
+}
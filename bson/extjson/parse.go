@@ -34,8 +34,8 @@ func newParseState(b *builder.DocumentBuilder, containingKey *string) *parseStat
 func (s *parseState) parseElement(key []byte, value []byte, dataType jsonparser.ValueType, offset int) error {
 	wtype := wrapperKeyType(key)
 
-	// dbRef can have regular elements after $id and $db appear
-	if s.wtype == dbRef && s.idFound && s.dbFound && wtype == none {
+	// dbRef can have regular elements after $ref and $id appear
+	if s.wtype == dbRef && s.refFound && s.idFound && wtype == none {
 		return parseDocElement(s.subdocBuilder, true)(key, value, dataType, offset)
 	}
 
@@ -52,10 +52,10 @@ func (s *parseState) parseElement(key []byte, value []byte, dataType jsonparser.
 
 	// The only wrapper types that allow more than one top-level key are code/CodeWithScope and dbRef
 	if s.wtype != none && s.wtype != code && s.wtype != dbRef && !s.firstKey {
-		return errors.New("%s wrapper object cannot have more than one key")
+		return fmt.Errorf("%s wrapper object cannot have more than one key", s.wtype)
 	}
 
-	s.firstKey = true
+	s.firstKey = false
 
 	if s.wtype == none {
 		return parseDocElement(s.subdocBuilder, true)(key, value, dataType, offset)
@@ -456,7 +456,10 @@ func parseDBPointer(data []byte, dataType jsonparser.ValueType) (string, [12]byt
 				return fmt.Errorf("$dbPointer $ref value should be string, but instead is %s", dataType.String())
 			}
 
-			str := string(value)
+			str, err := jsonparser.ParseString(value)
+			if err != nil {
+				return fmt.Errorf("invalid escaping in $dbPointer $ref string: %s", string(value))
+			}
 			ns = &str
 		case "$id":
 			if oidFound {
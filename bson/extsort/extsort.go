@@ -0,0 +1,576 @@
+// Package extsort sorts streams of BSON documents which are too large to fit in memory.
+//
+// A Sorter orders documents by a MongoDB sort specification, such as {"a": 1, "b.c": -1}, with the
+// semantics of query.Sort. Documents are buffered in memory until their total size reaches the
+// memory limit, at which point they are sorted and spilled to a temporary file as a run, a BSON
+// sequence of documents concatenated without separators. Runs are merged in tiers: when
+// Options.MaxRuns runs of similar size accumulate they are merged into one larger run, so each
+// document is rewritten a number of times which grows logarithmically with the input. When the
+// input is exhausted the runs and the documents remaining in memory are merged. The sort is
+// stable: documents which compare equal are returned in the order they were added.
+//
+// Sort is a convenience which sorts a BSON sequence read from an io.Reader into an io.Writer.
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/query"
+)
+
+// ErrFinished is returned by Add after Iterator has been called.
+var ErrFinished = errors.New("extsort: sorter has finished accepting documents")
+
+// ErrInvalidLength is returned by Add when a document is larger than the largest document the
+// server stores, and by Sort when a document in its input has an invalid length or is too large.
+var ErrInvalidLength = errors.New("extsort: invalid document length")
+
+// ErrClosed is returned when the sorter has been closed.
+var ErrClosed = errors.New("extsort: sorter is closed")
+
+const (
+	// DefaultMemoryLimit is the memory limit used when Options.MemoryLimit is zero.
+	DefaultMemoryLimit = 64 << 20
+	// DefaultMaxRuns is the number of runs used when Options.MaxRuns is zero.
+	DefaultMaxRuns = 64
+
+	// itemOverhead approximates the memory used by a buffered document besides its bytes.
+	itemOverhead = 64
+	// maxDocumentSize is the size of the largest document accepted by Add and read by Sort, which
+	// is the largest size of a document stored by the server.
+	maxDocumentSize = 16*1024*1024 + 16*1024
+)
+
+// Options configures a Sorter.
+type Options struct {
+	// MemoryLimit is the approximate number of bytes of documents held in memory before they are
+	// spilled to a run.
+	MemoryLimit int
+	// MaxRuns is the largest number of runs merged at once, which bounds the number of files open
+	// while merging. When MaxRuns runs of the same tier accumulate they are merged into one run of
+	// the next tier.
+	MaxRuns int
+	// TempDir is the directory for the run files. The default directory for temporary files is
+	// used if it is empty.
+	TempDir string
+}
+
+// Sorter sorts documents which may not fit in memory. A Sorter is not safe for concurrent use.
+type Sorter struct {
+	sort *query.Sort
+	opts Options
+
+	buf  []item
+	size int
+	// runs are in the order of the ranges of the input they hold. Their levels never increase
+	// along the slice, so the runs of each level are consecutive.
+	runs []run
+
+	finished bool
+	closed   bool
+}
+
+// run is a sorted run file.
+type run struct {
+	name string
+	// level is the number of merges the documents of the run have been through. The runs of a
+	// level hold similar numbers of documents.
+	level int
+}
+
+// item is a buffered document and its sort key.
+type item struct {
+	doc bson.Reader
+	key []*bson.Value
+}
+
+// New returns a Sorter which orders documents by the sort specification spec. If opts is nil the
+// defaults are used.
+func New(spec bson.Reader, opts *Options) (*Sorter, error) {
+	s, err := query.CompileSort(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	sorter := &Sorter{sort: s}
+	if opts != nil {
+		sorter.opts = *opts
+	}
+	if sorter.opts.MemoryLimit <= 0 {
+		sorter.opts.MemoryLimit = DefaultMemoryLimit
+	}
+	if sorter.opts.MaxRuns < 2 {
+		sorter.opts.MaxRuns = DefaultMaxRuns
+	}
+
+	return sorter, nil
+}
+
+// Add adds a copy of the valid document doc to the sorter, spilling the buffered documents to a
+// run if the memory limit is reached.
+func (s *Sorter) Add(doc bson.Reader) error {
+	switch {
+	case s.closed:
+		return ErrClosed
+	case s.finished:
+		return ErrFinished
+	case len(doc) > maxDocumentSize:
+		return ErrInvalidLength
+	}
+	if _, err := doc.Validate(); err != nil {
+		return err
+	}
+
+	doc = append(bson.Reader(nil), doc...)
+	s.buf = append(s.buf, item{doc: doc, key: s.sort.Key(doc)})
+	s.size += len(doc) + itemOverhead
+	if s.size < s.opts.MemoryLimit {
+		return nil
+	}
+
+	return s.spill()
+}
+
+// spill sorts the buffered documents and writes them to a new run.
+func (s *Sorter) spill() error {
+	s.sortBuffer()
+	src := &sliceSource{items: s.buf}
+	if err := s.writeRun(src, 0); err != nil {
+		return err
+	}
+	s.buf, s.size = nil, 0
+
+	// Merging a level's runs may complete the runs of the level above it.
+	for {
+		last := len(s.runs) - 1
+		first := last
+		for first > 0 && s.runs[first-1].level == s.runs[last].level {
+			first--
+		}
+		if last-first+1 < s.opts.MaxRuns {
+			return nil
+		}
+		if err := s.mergeRuns(first); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeRuns merges the runs from the index first to the end into one run of the level after that
+// of the run at first. The runs hold consecutive ranges of the input in order, so merging them
+// keeps the sort stable.
+func (s *Sorter) mergeRuns(first int) error {
+	m, err := s.merge(s.runs[first:], nil)
+	if err != nil {
+		return err
+	}
+	old := append([]run(nil), s.runs[first:]...)
+	s.runs = s.runs[:first]
+	err = s.writeRun(m, old[0].level+1)
+	if cerr := m.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.runs = append(s.runs, old...)
+		return err
+	}
+	removeAll(old)
+
+	return nil
+}
+
+func (s *Sorter) sortBuffer() {
+	sort.SliceStable(s.buf, func(i, j int) bool {
+		return s.sort.CompareKeys(s.buf[i].key, s.buf[j].key) < 0
+	})
+}
+
+// writeRun writes the documents of src to a new run file of the given level.
+func (s *Sorter) writeRun(src source, level int) error {
+	f, err := os.CreateTemp(s.opts.TempDir, "extsort-*.bson")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for err == nil && src.next() {
+		_, err = w.Write(src.current().doc)
+	}
+	if err == nil {
+		err = src.err()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	s.runs = append(s.runs, run{name: f.Name(), level: level})
+	return nil
+}
+
+// Iterator stops the sorter from accepting documents and returns an iterator over the sorted
+// documents. Closing the iterator closes the sorter.
+func (s *Sorter) Iterator() (*Iterator, error) {
+	switch {
+	case s.closed:
+		return nil, ErrClosed
+	case s.finished:
+		return nil, ErrFinished
+	}
+	s.finished = true
+
+	// Merge the newest runs, which are the smallest, until the rest can be merged at once.
+	for len(s.runs) > s.opts.MaxRuns {
+		n := len(s.runs) - s.opts.MaxRuns + 1
+		if n > s.opts.MaxRuns {
+			n = s.opts.MaxRuns
+		}
+		if err := s.mergeRuns(len(s.runs) - n); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	s.sortBuffer()
+	m, err := s.merge(s.runs, &sliceSource{items: s.buf})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.buf, s.size = nil, 0
+
+	return &Iterator{s: s, m: m}, nil
+}
+
+// merge returns a merger of runs followed by extra, if it is not nil.
+func (s *Sorter) merge(runs []run, extra source) (*merger, error) {
+	m := &merger{sort: s.sort}
+	for _, r := range runs {
+		f, err := os.Open(r.name)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		m.sources = append(m.sources, &runSource{f: f, r: bufio.NewReader(f)})
+	}
+	if extra != nil {
+		m.sources = append(m.sources, extra)
+	}
+
+	if err := m.init(); err != nil {
+		m.close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close removes the runs of the sorter and discards its buffered documents.
+func (s *Sorter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.buf, s.size = nil, 0
+
+	err := removeAll(s.runs)
+	s.runs = nil
+	return err
+}
+
+func removeAll(runs []run) error {
+	var err error
+	for _, r := range runs {
+		if rerr := os.Remove(r.name); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Iterator iterates over the sorted documents of a Sorter.
+type Iterator struct {
+	s   *Sorter
+	m   *merger
+	doc bson.Reader
+	err error
+}
+
+// Next advances the iterator to the next document and reports whether there is one. It returns
+// false when the documents are exhausted or an error occurs.
+func (itr *Iterator) Next() bool {
+	if itr.err != nil || itr.m == nil {
+		return false
+	}
+	if !itr.m.next() {
+		itr.err = itr.m.err()
+		itr.doc = nil
+		return false
+	}
+
+	itr.doc = itr.m.current().doc
+	return true
+}
+
+// Document returns the current document.
+func (itr *Iterator) Document() bson.Reader {
+	return itr.doc
+}
+
+// Err returns the error which stopped the iterator, if any.
+func (itr *Iterator) Err() error {
+	return itr.err
+}
+
+// Close closes the iterator and its sorter.
+func (itr *Iterator) Close() error {
+	var err error
+	if itr.m != nil {
+		err = itr.m.close()
+		itr.m = nil
+	}
+	if serr := itr.s.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Sort reads a BSON sequence of documents from r, sorts them by spec, and writes them to w as a
+// BSON sequence. If opts is nil the defaults are used.
+func Sort(w io.Writer, r io.Reader, spec bson.Reader, opts *Options) error {
+	s, err := New(spec, opts)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	br := bufio.NewReader(r)
+	for {
+		doc, err := readDocument(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.Add(doc); err != nil {
+			return err
+		}
+	}
+
+	itr, err := s.Iterator()
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	bw := bufio.NewWriter(w)
+	for itr.Next() {
+		if _, err := bw.Write(itr.Document()); err != nil {
+			return err
+		}
+	}
+	if itr.Err() != nil {
+		return itr.Err()
+	}
+
+	return bw.Flush()
+}
+
+// readDocument reads a document of a BSON sequence from r. It returns io.EOF at the end of the
+// sequence, io.ErrUnexpectedEOF if the sequence ends within a document, and ErrInvalidLength if
+// the length of the document is out of range, so that a corrupt length does not cause a large
+// allocation.
+func readDocument(r io.Reader) (bson.Reader, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(length[:])
+	if n < 5 || n > maxDocumentSize {
+		return nil, ErrInvalidLength
+	}
+	doc := make(bson.Reader, n)
+	copy(doc, length[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// source is a sorted sequence of documents.
+type source interface {
+	next() bool
+	current() item
+	err() error
+	close() error
+}
+
+// sliceSource is a source of buffered documents.
+type sliceSource struct {
+	items []item
+	pos   int
+}
+
+func (ss *sliceSource) next() bool {
+	if ss.pos >= len(ss.items) {
+		return false
+	}
+	ss.pos++
+	return true
+}
+
+func (ss *sliceSource) current() item { return ss.items[ss.pos-1] }
+func (ss *sliceSource) err() error    { return nil }
+func (ss *sliceSource) close() error  { return nil }
+
+// runSource is a source reading a run file. Its documents are valid since they were validated
+// before they were spilled.
+type runSource struct {
+	f    *os.File
+	r    *bufio.Reader
+	item item
+	e    error
+}
+
+func (rs *runSource) next() bool {
+	if rs.e != nil {
+		return false
+	}
+
+	doc, err := readDocument(rs.r)
+	if err != nil {
+		if err != io.EOF {
+			rs.e = err
+		}
+		return false
+	}
+
+	rs.item = item{doc: doc}
+	return true
+}
+
+func (rs *runSource) current() item { return rs.item }
+func (rs *runSource) err() error    { return rs.e }
+func (rs *runSource) close() error  { return rs.f.Close() }
+
+// merger is a source which merges sources with a heap. Documents with equal keys are taken from
+// the earliest source first.
+type merger struct {
+	sort    *query.Sort
+	sources []source
+	heap    []cursor
+	item    item
+	e       error
+}
+
+// cursor is the current document of the source at index within the merger.
+type cursor struct {
+	item  item
+	index int
+}
+
+func (m *merger) init() error {
+	for i, src := range m.sources {
+		if err := m.push(src, i); err != nil {
+			return err
+		}
+	}
+	heap.Init(m)
+	return nil
+}
+
+// push adds the next document of src, the source at index, to the heap without restoring the heap
+// order.
+func (m *merger) push(src source, index int) error {
+	if !src.next() {
+		return src.err()
+	}
+
+	it := src.current()
+	if it.key == nil {
+		it.key = m.sort.Key(it.doc)
+	}
+	m.heap = append(m.heap, cursor{item: it, index: index})
+	return nil
+}
+
+func (m *merger) next() bool {
+	if m.e != nil || len(m.heap) == 0 {
+		return false
+	}
+
+	top := m.heap[0]
+	m.item = top.item
+	src := m.sources[top.index]
+	if src.next() {
+		it := src.current()
+		if it.key == nil {
+			it.key = m.sort.Key(it.doc)
+		}
+		m.heap[0] = cursor{item: it, index: top.index}
+		heap.Fix(m, 0)
+	} else {
+		if err := src.err(); err != nil {
+			m.e = err
+			return false
+		}
+		heap.Pop(m)
+	}
+
+	return true
+}
+
+func (m *merger) current() item { return m.item }
+func (m *merger) err() error    { return m.e }
+
+func (m *merger) close() error {
+	var err error
+	for _, src := range m.sources {
+		if cerr := src.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Len implements heap.Interface.
+func (m *merger) Len() int { return len(m.heap) }
+
+// Less implements heap.Interface.
+func (m *merger) Less(i, j int) bool {
+	if c := m.sort.CompareKeys(m.heap[i].item.key, m.heap[j].item.key); c != 0 {
+		return c < 0
+	}
+	return m.heap[i].index < m.heap[j].index
+}
+
+// Swap implements heap.Interface.
+func (m *merger) Swap(i, j int) { m.heap[i], m.heap[j] = m.heap[j], m.heap[i] }
+
+// Push implements heap.Interface.
+func (m *merger) Push(x interface{}) { m.heap = append(m.heap, x.(cursor)) }
+
+// Pop implements heap.Interface.
+func (m *merger) Pop() interface{} {
+	c := m.heap[len(m.heap)-1]
+	m.heap = m.heap[:len(m.heap)-1]
+	return c
+}
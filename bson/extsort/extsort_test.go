@@ -0,0 +1,253 @@
+package extsort
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+	"github.com/skriptble/wilson/bson/query"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+// input returns n documents with a field a of mixed types, a field b.c which is sometimes an array
+// or missing, and a field i holding their position.
+func input(t *testing.T, n int) []bson.Reader {
+	t.Helper()
+
+	rnd := rand.New(rand.NewSource(1))
+	docs := make([]bson.Reader, n)
+	for i := range docs {
+		var a *bson.Element
+		switch rnd.Intn(4) {
+		case 0:
+			a = C.Int32("a", int32(rnd.Intn(10)))
+		case 1:
+			a = C.Double("a", float64(rnd.Intn(10))/2)
+		case 2:
+			a = C.String("a", fmt.Sprint(rnd.Intn(10)))
+		default:
+			a = C.Null("a")
+		}
+
+		var b *bson.Element
+		switch rnd.Intn(3) {
+		case 0:
+			b = C.SubDocumentFromElements("b", C.Int64("c", int64(rnd.Intn(5))))
+		case 1:
+			b = C.ArrayFromElements("b",
+				AC.DocumentFromElements(C.Int32("c", int32(rnd.Intn(5)))),
+				AC.DocumentFromElements(C.Int32("c", int32(rnd.Intn(5)))),
+			)
+		default:
+			b = C.String("b", "no c")
+		}
+
+		docs[i] = bsontest.Marshal(t, bson.NewDocument(a, b, C.Int32("i", int32(i))))
+	}
+
+	return docs
+}
+
+// expected sorts docs in memory.
+func expected(t *testing.T, spec bson.Reader, docs []bson.Reader) []bson.Reader {
+	t.Helper()
+
+	s, err := query.CompileSort(spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := append([]bson.Reader(nil), docs...)
+	sort.SliceStable(want, func(i, j int) bool { return s.Compare(want[i], want[j]) < 0 })
+	return want
+}
+
+func assertDocuments(t *testing.T, got, want []bson.Reader) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected number of documents. got %d; want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("Unexpected document %d. got %v; want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func assertEmpty(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected the runs to be removed. got %d files", len(entries))
+	}
+}
+
+func TestSorter(t *testing.T) {
+	spec := bsontest.Marshal(t, bson.NewDocument(C.Int32("a", 1), C.Int32("b.c", -1)))
+	docs := input(t, 300)
+	want := expected(t, spec, docs)
+
+	testCases := []struct {
+		name string
+		opts Options
+	}{
+		{"in memory", Options{}},
+		{"one document per run", Options{MemoryLimit: 1, MaxRuns: 1000}},
+		{"several runs", Options{MemoryLimit: 2000}},
+		{"cascading merges", Options{MemoryLimit: 500, MaxRuns: 3}},
+		{"final merges", Options{MemoryLimit: 1, MaxRuns: 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.TempDir = t.TempDir()
+			s, err := New(spec, &tc.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			for _, doc := range docs {
+				if err := s.Add(doc); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			// Each level holds fewer than MaxRuns runs, and the levels never increase.
+			for i, count := 0, 1; i < len(s.runs); i++ {
+				switch {
+				case i == 0:
+				case s.runs[i].level > s.runs[i-1].level:
+					t.Errorf("Unexpected run levels. got %v", s.runs)
+				case s.runs[i].level == s.runs[i-1].level:
+					count++
+				default:
+					count = 1
+				}
+				if count >= s.opts.MaxRuns {
+					t.Errorf("Too many runs of level %d. got %v", s.runs[i].level, s.runs)
+				}
+			}
+
+			itr, err := s.Iterator()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var got []bson.Reader
+			for itr.Next() {
+				got = append(got, itr.Document())
+			}
+			if err := itr.Err(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assertDocuments(t, got, want)
+
+			if err := itr.Close(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assertEmpty(t, tc.opts.TempDir)
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	spec := bsontest.Marshal(t, bson.NewDocument(C.Int32("b.c", 1), C.Int32("i", -1)))
+	docs := input(t, 100)
+
+	var in bytes.Buffer
+	for _, doc := range docs {
+		in.Write(doc)
+	}
+	whole := in.Bytes()
+
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := Sort(&out, bytes.NewReader(whole), spec, &Options{MemoryLimit: 1000, TempDir: dir}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var got []bson.Reader
+	for {
+		doc, err := readDocument(&out)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got = append(got, doc)
+	}
+	assertDocuments(t, got, expected(t, spec, docs))
+	assertEmpty(t, dir)
+
+	testCases := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"empty", nil, nil},
+		{"torn length", whole[:len(whole)-len(docs[99])+2], io.ErrUnexpectedEOF},
+		{"torn document", whole[:len(whole)-1], io.ErrUnexpectedEOF},
+		{"invalid length", []byte{0x01, 0x00, 0x00, 0x00}, ErrInvalidLength},
+		{"document too large", []byte{0xff, 0xff, 0xff, 0x7f}, ErrInvalidLength},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Sort(io.Discard, bytes.NewReader(tc.input), spec, &Options{MemoryLimit: 1000, TempDir: dir})
+			if err != tc.err {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+			assertEmpty(t, dir)
+		})
+	}
+}
+
+func TestSorterErrors(t *testing.T) {
+	if _, err := New(bsontest.Marshal(t, bson.NewDocument(C.Int32("a", 2))), nil); err == nil {
+		t.Errorf("Expected an error for an invalid sort specification")
+	}
+
+	s, err := New(bsontest.Marshal(t, bson.NewDocument(C.Int32("a", 1))), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := s.Add(bson.Reader{0x05, 0x00, 0x00, 0x00, 0x01}); err == nil {
+		t.Errorf("Expected an error for an invalid document")
+	}
+	large := bsontest.Marshal(t, bson.NewDocument(C.Binary("a", make([]byte, maxDocumentSize))))
+	if err := s.Add(large); err != ErrInvalidLength {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidLength)
+	}
+
+	itr, err := s.Iterator()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if itr.Next() {
+		t.Errorf("Expected no documents")
+	}
+	if err := s.Add(bsontest.Marshal(t, bson.NewDocument(C.Int32("a", 1)))); err != ErrFinished {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrFinished)
+	}
+	if _, err := s.Iterator(); err != ErrFinished {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrFinished)
+	}
+
+	itr.Close()
+	if err := s.Add(bsontest.Marshal(t, bson.NewDocument(C.Int32("a", 1)))); err != ErrClosed {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,213 @@
+package bson
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skriptble/wilson/bson/decimal"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+// addCorpusSeeds adds the valid and invalid documents of the BSON corpus to the seed corpus of
+// f.
+func addCorpusSeeds(f *testing.F) {
+	files, err := filepath.Glob("../data/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var tc struct {
+			Valid []struct {
+				CanonicalBson string `json:"canonical_bson"`
+			} `json:"valid"`
+			DecodeErrors []struct {
+				Bson string `json:"bson"`
+			} `json:"decodeErrors"`
+		}
+		err = json.Unmarshal(content, &tc)
+		if err != nil {
+			f.Fatalf("%s: %v", file, err)
+		}
+
+		seeds := make([]string, 0, len(tc.Valid)+len(tc.DecodeErrors))
+		for _, v := range tc.Valid {
+			seeds = append(seeds, v.CanonicalBson)
+		}
+		for _, d := range tc.DecodeErrors {
+			seeds = append(seeds, d.Bson)
+		}
+		for _, seed := range seeds {
+			b, err := hex.DecodeString(seed)
+			if err != nil {
+				f.Fatalf("%s: %v", file, err)
+			}
+			f.Add(b)
+		}
+	}
+}
+
+func FuzzReaderValidate(f *testing.F) {
+	addCorpusSeeds(f)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		size, err := Reader(b).Validate()
+		if err != nil {
+			return
+		}
+		if int(size) > len(b) {
+			t.Fatalf("Size is larger than the document. got %d; want <= %d", size, len(b))
+		}
+
+		_, err = Reader(b).ValidateWithOptions(&ValidateOptions{UTF8: UTF8Replace})
+		if err != nil {
+			t.Fatalf("Valid document failed validation with options: %v", err)
+		}
+
+		itr, err := Reader(b).Iterator()
+		if err != nil {
+			t.Fatalf("Unexpected error creating iterator: %v", err)
+		}
+		for itr.Next() {
+			readValue(itr.Element().Value())
+		}
+		if err := itr.Err(); err != nil {
+			t.Fatalf("Unexpected error iterating: %v", err)
+		}
+	})
+}
+
+// readValue calls the accessor for the type of v.
+func readValue(v *Value) {
+	switch v.Type() {
+	case TypeDouble:
+		_ = v.Double()
+	case TypeString:
+		_ = v.StringValue()
+	case TypeEmbeddedDocument:
+		_ = v.MutableDocument()
+	case TypeArray:
+		_ = v.MutableArray()
+	case TypeBinary:
+		_, _ = v.Binary()
+	case TypeObjectID:
+		_ = v.ObjectID()
+	case TypeBoolean:
+		_ = v.Boolean()
+	case TypeDateTime:
+		_ = v.DateTime()
+	case TypeRegex:
+		_, _ = v.Regex()
+	case TypeDBPointer:
+		_, _ = v.DBPointer()
+	case TypeJavaScript:
+		_ = v.JavaScript()
+	case TypeSymbol:
+		_ = v.Symbol()
+	case TypeCodeWithScope:
+		_, _ = v.MutableJavaScriptWithScope()
+	case TypeInt32:
+		_ = v.Int32()
+	case TypeTimestamp:
+		_, _ = v.Timestamp()
+	case TypeInt64:
+		_ = v.Int64()
+	case TypeDecimal128:
+		_ = v.Decimal128()
+	}
+}
+
+func FuzzReaderLookup(f *testing.F) {
+	addCorpusSeeds(f)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r := Reader(b)
+
+		// None of these methods require a validated document.
+		keys, err := r.Keys(true)
+		if err != nil {
+			return
+		}
+		for _, key := range keys {
+			_, _ = r.Lookup(append(key.Prefix, key.Name)...)
+		}
+		_, _ = r.Lookup("a", "b")
+		_, _ = r.ElementAt(uint(len(keys)))
+	})
+}
+
+func FuzzReadDocument(f *testing.F) {
+	addCorpusSeeds(f)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		doc, err := ReadDocument(b)
+		if err != nil {
+			return
+		}
+
+		got, err := doc.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error marshaling document: %v", err)
+		}
+		if !bytes.Equal(got, b) {
+			t.Fatalf("Round trip does not match. got %v; want %v", got, b)
+		}
+	})
+}
+
+func FuzzDecoder(f *testing.F) {
+	addCorpusSeeds(f)
+
+	type nested struct {
+		A string
+		B []int64
+	}
+	type fuzzStruct struct {
+		A        float64
+		B        string
+		C        *nested
+		D        []interface{}
+		E        []byte
+		F        objectid.ObjectID
+		G        bool
+		H        time.Time
+		I        int32
+		J        int64
+		K        uint64
+		L        decimal.Decimal128
+		M        map[string]interface{}
+		X        interface{}
+		Y        *Document
+		Z        Reader
+		Element  *Element
+		Array    [3]string
+		Unsigned uint8
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		// The Decoder allocates the declared length of the document before reading it, so
+		// inputs shorter than their declared length are skipped to avoid large allocations.
+		if len(b) >= 4 && int(readi32(b)) > len(b) {
+			return
+		}
+
+		var s fuzzStruct
+		_ = NewDecoder(bytes.NewReader(b)).Decode(&s)
+
+		m := make(map[string]interface{})
+		_ = NewDecoder(bytes.NewReader(b)).Decode(m)
+
+		var ms map[string]string
+		_ = NewDecoder(bytes.NewReader(b)).Decode(&ms)
+	})
+}
@@ -0,0 +1,178 @@
+// Package gridfs splits large binary payloads into documents and reassembles them, following the
+// GridFS specification.
+//
+// A payload is stored as a files document, which describes it, and a sequence of chunks
+// documents, which hold its bytes. The files document has the fields _id, length, chunkSize,
+// uploadDate, filename, and an optional metadata document. Each chunks document has the fields
+// _id, files_id, which is the _id of its files document, n, the index of the chunk starting from
+// zero, and data, a binary value holding chunkSize bytes, or fewer for the last chunk. A payload
+// of length zero has no chunks.
+//
+// Split reads a payload and passes each chunks document to a function as it is encoded, so the
+// payload is never held in memory. NewReader reassembles a payload from its files document and
+// chunks, which may be in any order, and verifies that they are complete and consistent.
+package gridfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/builder"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+// DefaultChunkSize is the chunk size used when Options.ChunkSize is zero, 255 KiB, which is the
+// default of the drivers.
+const DefaultChunkSize = 255 * 1024
+
+// maxDocumentSize is the size of the largest document the server stores.
+const maxDocumentSize = 16 * 1024 * 1024
+
+// ErrChunkSize is returned by Split when the chunk size is negative or too large for a chunk to
+// fit in a document.
+var ErrChunkSize = errors.New("gridfs: chunk size is out of range")
+
+// FormatError is returned when a files or chunks document is invalid or inconsistent with the
+// others.
+type FormatError struct {
+	// Document is the document which is invalid, such as "files" or "chunk 3".
+	Document string
+	Message  string
+}
+
+func (fe FormatError) Error() string {
+	return fmt.Sprintf("gridfs: invalid %s: %s", fe.Document, fe.Message)
+}
+
+// File is the content of a files document.
+type File struct {
+	// ID is the _id of the file, which is also the files_id of its chunks.
+	ID         *bson.Value
+	Length     int64
+	ChunkSize  int32
+	UploadDate time.Time
+	Filename   string
+	// Metadata is the metadata document, or nil if there is none.
+	Metadata bson.Reader
+}
+
+// Chunks returns the number of chunks of the file.
+func (f File) Chunks() int64 {
+	if f.ChunkSize <= 0 {
+		return 0
+	}
+	return (f.Length + int64(f.ChunkSize) - 1) / int64(f.ChunkSize)
+}
+
+// MarshalBSON returns the files document of the file.
+func (f File) MarshalBSON() ([]byte, error) {
+	if f.ID == nil {
+		return nil, FormatError{Document: "files", Message: "missing _id"}
+	}
+	if f.Metadata != nil {
+		if _, err := f.Metadata.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	id, err := bson.NewDocument(bson.C.Value("_id", f.ID)).MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(
+		builder.C.ElementsFromReader(id),
+		builder.C.Int64("length", f.Length),
+		builder.C.Int32("chunkSize", f.ChunkSize),
+		builder.C.DateTime("uploadDate", f.UploadDate.UnixNano()/int64(time.Millisecond)),
+		builder.C.String("filename", f.Filename),
+		builder.C.If(f.Metadata != nil, builder.C.SubDocument("metadata", builder.NewDocumentBuilder().Append(builder.C.ElementsFromReader(f.Metadata)))),
+	), nil
+}
+
+// Options configures Split.
+type Options struct {
+	// ChunkSize is the number of bytes in each chunk but the last. If it is zero,
+	// DefaultChunkSize is used.
+	ChunkSize int32
+	// ID is the _id of the file. If it is nil, a new ObjectID is used.
+	ID       *bson.Value
+	Filename string
+	// Metadata is stored in the metadata field of the files document if it is not nil.
+	Metadata bson.Reader
+	// UploadDate is stored in the uploadDate field of the files document. If it is zero, the
+	// time Split returns is used.
+	UploadDate time.Time
+}
+
+// Split reads r until io.EOF and calls chunk with each chunks document of the file, in order. It
+// returns the file, whose files document is written by File.MarshalBSON. Drivers insert the files
+// document after the chunks, so a file is not visible until it is complete. If opts is nil the
+// defaults are used. The document passed to chunk may be retained.
+func Split(r io.Reader, opts *Options, chunk func(doc []byte) error) (File, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.ChunkSize == 0 {
+		o.ChunkSize = DefaultChunkSize
+	}
+	if o.ID == nil {
+		o.ID = bson.AC.ObjectID(objectid.New())
+	}
+	f := File{ID: o.ID, ChunkSize: o.ChunkSize, Filename: o.Filename, Metadata: o.Metadata}
+
+	filesID, err := bson.NewDocument(bson.C.Value("files_id", o.ID)).MarshalBSON()
+	if err != nil {
+		return File{}, err
+	}
+	// The chunk size must leave room for the other fields of a chunk.
+	if o.ChunkSize < 0 || int64(len(encodeChunk(filesID, 0, nil)))+int64(o.ChunkSize) > maxDocumentSize {
+		return File{}, ErrChunkSize
+	}
+
+	buf := make([]byte, o.ChunkSize)
+	for n := int32(0); ; n++ {
+		read, err := io.ReadFull(r, buf)
+		if read > 0 {
+			f.Length += int64(read)
+			if err := chunk(encodeChunk(filesID, n, buf[:read])); err != nil {
+				return File{}, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return File{}, err
+		}
+	}
+
+	f.UploadDate = o.UploadDate
+	if f.UploadDate.IsZero() {
+		f.UploadDate = time.Now()
+	}
+	return f, nil
+}
+
+// encodeChunk returns the chunks document with the index n and the data b of the file whose
+// files_id element is the only element of filesID.
+func encodeChunk(filesID []byte, n int32, b []byte) []byte {
+	return encode(
+		builder.C.ObjectID("_id", objectid.New()),
+		builder.C.ElementsFromReader(filesID),
+		builder.C.Int32("n", n),
+		builder.C.BinaryWithSubtype("data", b, 0x00),
+	)
+}
+
+func encode(elems ...builder.Elementer) []byte {
+	db := builder.NewDocumentBuilder().Append(elems...)
+	b := make([]byte, db.RequiredBytes())
+	// The elements are fixed size values or copies of valid documents, so writing them into a
+	// buffer of the required size cannot fail.
+	db.WriteDocument(b)
+	return b
+}
@@ -0,0 +1,265 @@
+package gridfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+// split splits content into chunks of size chunkSize for a file with the _id "f".
+func split(t *testing.T, content []byte, chunkSize int32) (File, []bson.Reader) {
+	t.Helper()
+
+	var chunks []bson.Reader
+	f, err := Split(bytes.NewReader(content), &Options{ChunkSize: chunkSize, ID: AC.String("f")}, func(doc []byte) error {
+		chunks = append(chunks, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return f, chunks
+}
+
+func TestSplit(t *testing.T) {
+	upload := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := &Options{
+		ChunkSize:  4,
+		ID:         AC.Int32(7),
+		Filename:   "hello.txt",
+		Metadata:   bsontest.Marshal(t, bson.NewDocument(C.String("type", "text"))),
+		UploadDate: upload,
+	}
+
+	var chunks []string
+	f, err := Split(strings.NewReader("hello, wor"), opts, func(doc []byte) error {
+		// Remove the generated _id.
+		d, err := bson.ReadDocument(doc)
+		if err != nil {
+			return err
+		}
+		if elem, err := d.Lookup("_id"); err != nil || elem.Value().Type() != bson.TypeObjectID {
+			t.Errorf("Expected an ObjectID _id. got %v, %v", elem, err)
+		}
+		d.Delete("_id")
+		chunks = append(chunks, bsontest.ToJSON(t, bsontest.Marshal(t, d)))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := []string{
+		`{"files_id":7,"n":0,"data":{"$binary":{"base64":"aGVsbA==","subType":"00"}}}`,
+		`{"files_id":7,"n":1,"data":{"$binary":{"base64":"bywgdw==","subType":"00"}}}`,
+		`{"files_id":7,"n":2,"data":{"$binary":{"base64":"b3I=","subType":"00"}}}`,
+	}
+	if strings.Join(chunks, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected chunks.\ngot  %s\nwant %s", strings.Join(chunks, "\n"), strings.Join(want, "\n"))
+	}
+	if f.Length != 10 || f.Chunks() != 3 || f.ChunkSize != 4 {
+		t.Errorf("Unexpected file. got %+v", f)
+	}
+
+	b, err := f.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantFile := `{"_id":7,"length":10,"chunkSize":4,"uploadDate":{"$date":"2024-01-02T03:04:05Z"},"filename":"hello.txt","metadata":{"type":"text"}}`
+	if got := bsontest.ToJSON(t, b); got != wantFile {
+		t.Errorf("Unexpected files document.\ngot  %s\nwant %s", got, wantFile)
+	}
+
+	decoded, err := DecodeFile(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !decoded.ID.Equal(f.ID) || decoded.Length != 10 || decoded.ChunkSize != 4 || !decoded.UploadDate.Equal(upload) ||
+		decoded.Filename != "hello.txt" || !bytes.Equal(decoded.Metadata, opts.Metadata) {
+		t.Errorf("Unexpected decoded file. got %+v; want %+v", decoded, f)
+	}
+}
+
+func TestSplitDefaults(t *testing.T) {
+	content := make([]byte, DefaultChunkSize+1)
+	var n int
+	f, err := Split(bytes.NewReader(content), nil, func(doc []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 2 || f.ChunkSize != DefaultChunkSize || f.Length != int64(len(content)) {
+		t.Errorf("Unexpected result. got %d chunks and %+v", n, f)
+	}
+	if f.ID == nil || f.ID.Type() != bson.TypeObjectID {
+		t.Errorf("Expected an ObjectID _id. got %v", f.ID)
+	}
+	if f.UploadDate.IsZero() {
+		t.Errorf("Expected an upload date")
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	discard := func([]byte) error { return nil }
+	for _, size := range []int32{-1, maxDocumentSize} {
+		if _, err := Split(strings.NewReader("x"), &Options{ChunkSize: size}, discard); err != ErrChunkSize {
+			t.Errorf("Did not get expected error for chunk size %d. got %v; want %v", size, err, ErrChunkSize)
+		}
+	}
+
+	errWrite := errors.New("write failed")
+	_, err := Split(strings.NewReader("abc"), &Options{ChunkSize: 1}, func([]byte) error { return errWrite })
+	if err != errWrite {
+		t.Errorf("Did not get expected error. got %v; want %v", err, errWrite)
+	}
+
+	errRead := errors.New("read failed")
+	_, err = Split(iotest.TimeoutReader(strings.NewReader("abcdef")), &Options{ChunkSize: 4}, discard)
+	if err != iotest.ErrTimeout {
+		t.Errorf("Did not get expected error. got %v; want %v", err, iotest.ErrTimeout)
+	}
+	_, err = Split(iotest.ErrReader(errRead), nil, discard)
+	if err != errRead {
+		t.Errorf("Did not get expected error. got %v; want %v", err, errRead)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	content := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	for _, length := range []int{0, 1, 15, 16, 17, 100} {
+		f, chunks := split(t, content[:length], 16)
+		// The chunks may be given in any order.
+		rand.New(rand.NewSource(int64(length))).Shuffle(len(chunks), func(i, j int) {
+			chunks[i], chunks[j] = chunks[j], chunks[i]
+		})
+
+		r, err := NewReader(f, chunks)
+		if err != nil {
+			t.Fatalf("Unexpected error for length %d: %v", length, err)
+		}
+		if r.Size() != int64(length) {
+			t.Errorf("Unexpected size. got %d; want %d", r.Size(), length)
+		}
+		// TestReader checks Read, Seek, and ReadAt.
+		if err := iotest.TestReader(r, content[:length]); err != nil {
+			t.Errorf("Unexpected error for length %d: %v", length, err)
+		}
+	}
+
+	f, chunks := split(t, content, 16)
+	r, err := NewReader(f, chunks)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err != ErrInvalidOffset {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidOffset)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), -1); err != ErrInvalidOffset {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidOffset)
+	}
+	if pos, err := r.Seek(10, io.SeekEnd); err != nil || pos != 110 {
+		t.Errorf("Unexpected result. got %d, %v; want 110, nil", pos, err)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Unexpected result. got %d, %v; want 0, %v", n, err, io.EOF)
+	}
+}
+
+func TestNewReaderErrors(t *testing.T) {
+	content := []byte("0123456789")
+	f, chunks := split(t, content, 4)
+	chunk := func(id *bson.Value, n int64, data string) bson.Reader {
+		return bsontest.Marshal(t, bson.NewDocument(C.Value("files_id", id), C.Int64("n", n), C.Binary("data", []byte(data))))
+	}
+	id := AC.String("f")
+
+	testCases := []struct {
+		name   string
+		chunks []bson.Reader
+		doc    string
+	}{
+		{"missing chunk", []bson.Reader{chunks[0], chunks[2]}, "chunks"},
+		{"missing last chunk", chunks[:2], "chunks"},
+		{"duplicate", []bson.Reader{chunks[0], chunks[1], chunks[1]}, "chunk 1"},
+		{"extra chunk", append(append([]bson.Reader(nil), chunks...), chunk(id, 3, "")), "chunks"},
+		{"out of range", []bson.Reader{chunks[0], chunks[1], chunk(id, 3, "89")}, "chunk 3"},
+		{"other file", []bson.Reader{chunks[0], chunk(AC.String("g"), 1, "4567"), chunks[2]}, "chunk 1"},
+		{"short chunk", []bson.Reader{chunks[0], chunk(id, 1, "456"), chunks[2]}, "chunk 1"},
+		{"long last chunk", []bson.Reader{chunks[0], chunks[1], chunk(id, 2, "890")}, "chunk 2"},
+		{"missing n", []bson.Reader{bsontest.Marshal(t, bson.NewDocument(C.Value("files_id", id), C.Binary("data", nil))), chunks[1], chunks[2]}, "chunk at position 0"},
+		{"missing data", []bson.Reader{bsontest.Marshal(t, bson.NewDocument(C.Value("files_id", id), C.Int32("n", 0))), chunks[1], chunks[2]}, "chunk at position 0"},
+		{"data type", []bson.Reader{bsontest.Marshal(t, bson.NewDocument(C.Value("files_id", id), C.Int32("n", 0), C.String("data", "x"))), chunks[1], chunks[2]}, "chunk at position 0"},
+		{"invalid document", []bson.Reader{chunks[0][:len(chunks[0])-1], chunks[1], chunks[2]}, "chunk at position 0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewReader(f, tc.chunks)
+			fe, ok := err.(FormatError)
+			if !ok || fe.Document != tc.doc {
+				t.Errorf("Did not get expected error. got %v; want an error for %s", err, tc.doc)
+			}
+		})
+	}
+	// The number of chunks is checked before allocating for it.
+	huge, err := DecodeFile(bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 1), C.Int64("length", 1<<62), C.Int32("chunkSize", 1))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := NewReader(huge, nil); err != (FormatError{Document: "chunks", Message: "found 0 chunks; want 4611686018427387904"}) {
+		t.Errorf("Did not get expected error. got %v; want an error for chunks", err)
+	}
+}
+
+func TestDecodeFileErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		elems []*bson.Element
+	}{
+		{"missing _id", []*bson.Element{C.Int64("length", 1), C.Int32("chunkSize", 1)}},
+		{"missing length", []*bson.Element{C.Int32("_id", 1), C.Int32("chunkSize", 1)}},
+		{"missing chunkSize", []*bson.Element{C.Int32("_id", 1), C.Int64("length", 1)}},
+		{"negative length", []*bson.Element{C.Int32("_id", 1), C.Int64("length", -1), C.Int32("chunkSize", 1)}},
+		{"zero chunkSize", []*bson.Element{C.Int32("_id", 1), C.Int64("length", 1), C.Int32("chunkSize", 0)}},
+		{"large chunkSize", []*bson.Element{C.Int32("_id", 1), C.Int64("length", 1), C.Int64("chunkSize", 1<<32+1)}},
+		{"negative chunkSize", []*bson.Element{C.Int32("_id", 1), C.Int64("length", 1), C.Int64("chunkSize", -(1<<32)+1)}},
+		{"length type", []*bson.Element{C.Int32("_id", 1), C.String("length", "1"), C.Int32("chunkSize", 1)}},
+		{"fractional length", []*bson.Element{C.Int32("_id", 1), C.Double("length", 1.5), C.Int32("chunkSize", 1)}},
+		{"metadata type", []*bson.Element{C.Int32("_id", 1), C.Int64("length", 1), C.Int32("chunkSize", 1), C.Int32("metadata", 1)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeFile(bsontest.Marshal(t, bson.NewDocument(tc.elems...)))
+			if fe, ok := err.(FormatError); !ok || fe.Document != "files" {
+				t.Errorf("Did not get expected error. got %v; want an error for files", err)
+			}
+		})
+	}
+
+	// Some drivers write the length as a double.
+	f, err := DecodeFile(bsontest.Marshal(t, bson.NewDocument(C.Int32("_id", 1), C.Double("length", 3), C.Int32("chunkSize", 2))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f.Length != 3 || f.Chunks() != 2 {
+		t.Errorf("Unexpected file. got %+v", f)
+	}
+}
@@ -0,0 +1,265 @@
+package gridfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// ErrInvalidOffset is returned by Seek and ReadAt for an offset before the start of the file.
+var ErrInvalidOffset = errors.New("gridfs: negative offset")
+
+// DecodeFile decodes the files document r. The metadata of the file shares the bytes of r.
+func DecodeFile(r bson.Reader) (File, error) {
+	var f File
+	var hasLength, hasChunkSize bool
+	err := decode(r, "files", func(key string, v *bson.Value) error {
+		var err error
+		switch key {
+		case "_id":
+			f.ID = v
+		case "length":
+			f.Length, err = integer("files", key, v)
+			hasLength = true
+		case "chunkSize":
+			var n int64
+			n, err = integer("files", key, v)
+			if err == nil && (n <= 0 || n > math.MaxInt32) {
+				return FormatError{Document: "files", Message: fmt.Sprintf("invalid chunkSize %d", n)}
+			}
+			f.ChunkSize = int32(n)
+			hasChunkSize = true
+		case "uploadDate":
+			if v.Type() != bson.TypeDateTime {
+				return typeError("files", key, v, "a datetime")
+			}
+			f.UploadDate = v.DateTime()
+		case "filename":
+			if v.Type() != bson.TypeString {
+				return typeError("files", key, v, "a string")
+			}
+			f.Filename = v.StringValue()
+		case "metadata":
+			if v.Type() != bson.TypeEmbeddedDocument {
+				return typeError("files", key, v, "a document")
+			}
+			f.Metadata = v.ReaderDocument()
+		}
+		return err
+	})
+	if err != nil {
+		return File{}, err
+	}
+
+	switch {
+	case f.ID == nil:
+		return File{}, FormatError{Document: "files", Message: "missing _id"}
+	case !hasLength:
+		return File{}, FormatError{Document: "files", Message: "missing length"}
+	case !hasChunkSize:
+		return File{}, FormatError{Document: "files", Message: "missing chunkSize"}
+	case f.Length < 0:
+		return File{}, FormatError{Document: "files", Message: fmt.Sprintf("negative length %d", f.Length)}
+	}
+
+	return f, nil
+}
+
+// Reader reads the bytes of a file from its chunks. It implements io.ReadSeeker and io.ReaderAt.
+type Reader struct {
+	file File
+	// chunks holds the data of each chunk in order.
+	chunks [][]byte
+	offset int64
+}
+
+// NewReader returns a Reader for the file f whose chunks are chunks, in any order. It verifies
+// that the files_id of each chunk is the _id of f, that there is exactly one chunk for each n from
+// zero to the number of chunks of f, and that each chunk holds chunkSize bytes, except the last,
+// which holds the rest of the file. The Reader shares the bytes of chunks.
+func NewReader(f File, chunks []bson.Reader) (*Reader, error) {
+	if f.ID == nil {
+		return nil, FormatError{Document: "files", Message: "missing _id"}
+	}
+	if f.Length < 0 || (f.Length > 0 && f.ChunkSize <= 0) {
+		return nil, FormatError{Document: "files", Message: fmt.Sprintf("invalid length %d or chunkSize %d", f.Length, f.ChunkSize)}
+	}
+
+	// The number of chunks comes from the files document, so check it against the chunks given
+	// before allocating for it.
+	count := f.Chunks()
+	if int64(len(chunks)) != count {
+		return nil, FormatError{Document: "chunks", Message: fmt.Sprintf("found %d chunks; want %d", len(chunks), count)}
+	}
+
+	data := make([][]byte, count)
+	for i, chunk := range chunks {
+		filesID, n, b, err := decodeChunk(chunk, fmt.Sprintf("chunk at position %d", i))
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("chunk %d", n)
+		if !filesID.Equal(f.ID) {
+			return nil, FormatError{Document: name, Message: "belongs to another file"}
+		}
+		if n < 0 || n >= count {
+			return nil, FormatError{Document: name, Message: fmt.Sprintf("n is out of range for %d chunks", count)}
+		}
+		if data[n] != nil {
+			return nil, FormatError{Document: name, Message: "duplicate n"}
+		}
+
+		want := int64(f.ChunkSize)
+		if n == count-1 {
+			want = f.Length - n*int64(f.ChunkSize)
+		}
+		if int64(len(b)) != want {
+			return nil, FormatError{Document: name, Message: fmt.Sprintf("holds %d bytes; want %d", len(b), want)}
+		}
+		data[n] = b
+	}
+
+	return &Reader{file: f, chunks: data}, nil
+}
+
+// File returns the file being read.
+func (r *Reader) File() File {
+	return r.file
+}
+
+// Size returns the length of the file.
+func (r *Reader) Size() int64 {
+	return r.file.Length
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(b []byte) (int, error) {
+	n, err := r.ReadAt(b, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *Reader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrInvalidOffset
+	}
+
+	var total int
+	for total < len(b) && off < r.file.Length {
+		chunk := r.chunks[off/int64(r.file.ChunkSize)]
+		n := copy(b[total:], chunk[off%int64(r.file.ChunkSize):])
+		total += n
+		off += int64(n)
+	}
+	if total < len(b) {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// Seek implements io.Seeker. Seeking past the end of the file is allowed, and reads there return
+// io.EOF.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.file.Length
+	default:
+		return r.offset, fmt.Errorf("gridfs: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return r.offset, ErrInvalidOffset
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+// decodeChunk returns the files_id, n, and data of the chunks document r, which is named document
+// in errors.
+func decodeChunk(r bson.Reader, document string) (*bson.Value, int64, []byte, error) {
+	var filesID *bson.Value
+	n := int64(-1)
+	var data []byte
+	err := decode(r, document, func(key string, v *bson.Value) error {
+		var err error
+		switch key {
+		case "files_id":
+			filesID = v
+		case "n":
+			n, err = integer(document, key, v)
+		case "data":
+			if v.Type() != bson.TypeBinary {
+				return typeError(document, key, v, "binary data")
+			}
+			_, data = v.Binary()
+			if data == nil {
+				data = []byte{}
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	switch {
+	case filesID == nil:
+		return nil, 0, nil, FormatError{Document: document, Message: "missing files_id"}
+	case n < 0:
+		return nil, 0, nil, FormatError{Document: document, Message: "missing or negative n"}
+	case data == nil:
+		return nil, 0, nil, FormatError{Document: document, Message: "missing data"}
+	}
+
+	return filesID, n, data, nil
+}
+
+// decode validates r and calls f with a copy of each of its elements.
+func decode(r bson.Reader, document string, f func(key string, v *bson.Value) error) error {
+	if _, err := r.Validate(); err != nil {
+		return FormatError{Document: document, Message: err.Error()}
+	}
+	itr, err := r.Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		// The iterator reuses its element, so f may keep the value of a clone.
+		elem := itr.Element().Clone()
+		if err := f(elem.Key(), elem.Value()); err != nil {
+			return err
+		}
+	}
+	return itr.Err()
+}
+
+func typeError(document, key string, v *bson.Value, want string) error {
+	return FormatError{Document: document, Message: fmt.Sprintf("%s must be %s, not %s", key, want, v.Type().Alias())}
+}
+
+// integer returns the value of a numeric field. Drivers write length as an int64 or, for small
+// files, an int32, and some write it as a double.
+func integer(document, key string, v *bson.Value) (int64, error) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), nil
+	case bson.TypeInt64:
+		return v.Int64(), nil
+	case bson.TypeDouble:
+		if f := v.Double(); f == float64(int64(f)) {
+			return int64(f), nil
+		}
+	}
+	return 0, typeError(document, key, v, "an integer")
+}
@@ -0,0 +1,323 @@
+package keystring
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// decoder reads a single component of a key. Every byte read is XORed with mask, which is 0xFF for
+// descending components.
+type decoder struct {
+	b    []byte
+	pos  int
+	mask byte
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.b) {
+		return 0, ErrInvalidKey
+	}
+
+	c := d.b[d.pos] ^ d.mask
+	d.pos++
+	return c, nil
+}
+
+func (d *decoder) bytes(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.pos < n {
+		return nil, ErrInvalidKey
+	}
+
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = d.b[d.pos+i] ^ d.mask
+	}
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	b, err := d.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (d *decoder) uint64() (uint64, error) {
+	b, err := d.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// string reads a string written by appendString.
+func (d *decoder) string() (string, error) {
+	var sb strings.Builder
+	for {
+		c, err := d.byte()
+		if err != nil {
+			return "", err
+		}
+		if c != 0 {
+			sb.WriteByte(c)
+			continue
+		}
+
+		c, err = d.byte()
+		switch {
+		case err != nil:
+			return "", err
+		case c == 0:
+			return sb.String(), nil
+		case c == 1:
+			sb.WriteByte(0)
+		default:
+			return "", ErrInvalidKey
+		}
+	}
+}
+
+// value reads a value written by appendValue.
+func (d *decoder) value() (*bson.Value, error) {
+	t, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.body(t)
+}
+
+// body reads the value of a type written by appendBody.
+func (d *decoder) body(t byte) (*bson.Value, error) {
+	switch t {
+	case typeMinKey:
+		return bson.AC.MinKey(), nil
+	case typeUndefined:
+		return bson.AC.Undefined(), nil
+	case typeNull:
+		return bson.AC.Null(), nil
+	case typeMaxKey:
+		return bson.AC.MaxKey(), nil
+	case typeNumber:
+		return d.number()
+	case typeString:
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.String(s), nil
+	case typeDocument:
+		doc, err := d.document()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.Document(doc), nil
+	case typeArray:
+		var values []*bson.Value
+		for {
+			et, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if et == end {
+				return bson.AC.ArrayFromValues(values...), nil
+			}
+
+			v, err := d.body(et)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	case typeBinary:
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if n < 5 {
+			return nil, ErrInvalidKey
+		}
+		b, err := d.bytes(int(n) - 4)
+		if err != nil {
+			return nil, err
+		}
+		var length [4]byte
+		binary.LittleEndian.PutUint32(length[:], n-5)
+		return rawValue(bson.TypeBinary, append(length[:], b...))
+	case typeObjectID:
+		b, err := d.bytes(12)
+		if err != nil {
+			return nil, err
+		}
+		return rawValue(bson.TypeObjectID, b)
+	case typeBoolean:
+		c, err := d.byte()
+		if err != nil || c > 1 {
+			return nil, ErrInvalidKey
+		}
+		return bson.AC.Boolean(c == 1), nil
+	case typeDateTime:
+		u, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.DateTime(int64(u ^ 1<<63)), nil
+	case typeTimestamp:
+		u, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.Timestamp(uint32(u>>32), uint32(u)), nil
+	case typeRegex:
+		pattern, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		options, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.Regex(pattern, options), nil
+	case typeDBPointer:
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return rawValue(bson.TypeDBPointer, b)
+	case typeJavaScript:
+		code, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.JavaScript(code), nil
+	case typeCodeWithScope:
+		code, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		scope, err := d.document()
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.CodeWithScope(code, scope), nil
+	}
+
+	return nil, ErrInvalidKey
+}
+
+// document reads the elements of a document written by appendDocument.
+func (d *decoder) document() (*bson.Document, error) {
+	doc := bson.NewDocument()
+	for {
+		et, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if et == end {
+			return doc, nil
+		}
+
+		key, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.body(et)
+		if err != nil {
+			return nil, err
+		}
+		doc.Append(bson.C.Value(key, v))
+	}
+}
+
+// number reads a number written by appendNumber.
+func (d *decoder) number() (*bson.Value, error) {
+	class, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch class {
+	case numberNaN:
+		return bson.AC.Double(math.NaN()), nil
+	case numberNegInf:
+		return bson.AC.Double(math.Inf(-1)), nil
+	case numberZero:
+		return bson.AC.Int32(0), nil
+	case numberPosInf:
+		return bson.AC.Double(math.Inf(1)), nil
+	case numberNegative, numberPositive:
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	neg := class == numberNegative
+	if neg {
+		d.mask = ^d.mask
+		defer func() { d.mask = ^d.mask }()
+	}
+
+	b, err := d.bytes(2)
+	if err != nil {
+		return nil, err
+	}
+	exp := int(binary.BigEndian.Uint16(b)) - exponentBias
+
+	var sb strings.Builder
+	for {
+		c, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			break
+		}
+		if c > 100 {
+			return nil, ErrInvalidKey
+		}
+		fmt.Fprintf(&sb, "%02d", c-1)
+	}
+
+	digits := strings.TrimRight(sb.String(), "0")
+	if digits == "" {
+		return nil, ErrInvalidKey
+	}
+	v := numberValue(neg, digits, exp)
+	if v == nil {
+		return nil, ErrInvalidKey
+	}
+
+	return v, nil
+}
+
+// rawValue returns the value of type t whose BSON encoding is raw.
+func rawValue(t bson.Type, raw []byte) (*bson.Value, error) {
+	// Wrap the value in a document with an empty key so that it can be validated and read.
+	b := make([]byte, 4, 4+1+1+len(raw)+1)
+	b = append(b, byte(t), 0)
+	b = append(b, raw...)
+	b = append(b, 0)
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+
+	r := bson.Reader(b)
+	if _, err := r.Validate(); err != nil {
+		return nil, ErrInvalidKey
+	}
+	elem, err := r.ElementAt(0)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	return elem.Value(), nil
+}
@@ -0,0 +1,270 @@
+// Package keystring encodes sequences of BSON values as byte strings whose bytewise order matches
+// the MongoDB sort order of the values, for use as keys in ordered key-value stores.
+//
+// A key is a sequence of components, each of which is a value and a Direction. Two keys compare
+// with bytes.Compare as their components compare in turn with bson.CompareValues, with the order
+// of descending components reversed. Values which compare as equal, such as the int32 1 and the
+// double 1.0, are encoded identically, so the order holds for equality as well.
+//
+// Each component is encoded as a byte identifying its position in the type order followed by a
+// self-delimiting encoding of its value, and descending components have every byte inverted.
+// Numbers of all types are encoded by their exact value, so the type of a number cannot be
+// recovered: Decode returns the narrowest of int32, int64, double, and decimal which represents
+// the value exactly. Symbols are likewise decoded as strings. All other values are decoded
+// exactly.
+package keystring
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// ErrInvalidKey is returned by Decode when a key was not produced by this package.
+var ErrInvalidKey = errors.New("keystring: invalid key")
+
+// Direction is the order in which a component of a key sorts.
+type Direction int
+
+// These constants are the directions in which components sort.
+const (
+	Ascending Direction = iota
+	Descending
+)
+
+// The bytes which begin the encoding of each value, in the MongoDB type order. Numbers share a
+// single byte, as do strings and symbols.
+const (
+	typeMinKey        byte = 0x0A
+	typeUndefined     byte = 0x0F
+	typeNull          byte = 0x14
+	typeNumber        byte = 0x1E
+	typeString        byte = 0x28
+	typeDocument      byte = 0x32
+	typeArray         byte = 0x3C
+	typeBinary        byte = 0x46
+	typeObjectID      byte = 0x50
+	typeBoolean       byte = 0x5A
+	typeDateTime      byte = 0x64
+	typeTimestamp     byte = 0x6E
+	typeRegex         byte = 0x78
+	typeDBPointer     byte = 0x82
+	typeJavaScript    byte = 0x8C
+	typeCodeWithScope byte = 0x96
+	typeMaxKey        byte = 0xF0
+)
+
+// end terminates the elements of documents and arrays. It is less than every type byte.
+const end byte = 0x00
+
+// Encode returns the key for values, where the component values[i] sorts in the direction dirs[i].
+// If dirs is nil, every component is ascending.
+func Encode(values []*bson.Value, dirs []Direction) ([]byte, error) {
+	if dirs != nil && len(dirs) != len(values) {
+		return nil, fmt.Errorf("keystring: %d values but %d directions", len(values), len(dirs))
+	}
+
+	var key []byte
+	for i, v := range values {
+		dir := Ascending
+		if dirs != nil {
+			dir = dirs[i]
+		}
+
+		var err error
+		key, err = AppendValue(key, v, dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// AppendValue appends the encoding of v as a component sorting in the direction dir to dst and
+// returns the extended buffer. Keys may be built one component at a time with AppendValue.
+func AppendValue(dst []byte, v *bson.Value, dir Direction) ([]byte, error) {
+	start := len(dst)
+	dst, err := appendValue(dst, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == Descending {
+		for i := start; i < len(dst); i++ {
+			dst[i] = ^dst[i]
+		}
+	}
+
+	return dst, nil
+}
+
+// Decode returns the values of the components of key, where the component i sorts in the direction
+// dirs[i]. Components beyond the end of dirs are ascending.
+func Decode(key []byte, dirs []Direction) ([]*bson.Value, error) {
+	var values []*bson.Value
+	for len(key) > 0 {
+		dir := Ascending
+		if len(values) < len(dirs) {
+			dir = dirs[len(values)]
+		}
+
+		d := &decoder{b: key}
+		if dir == Descending {
+			d.mask = 0xFF
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		key = key[d.pos:]
+	}
+
+	return values, nil
+}
+
+// typeBytes are the bytes which begin the encoding of values of each type.
+var typeBytes = map[bson.Type]byte{
+	bson.TypeMinKey:           typeMinKey,
+	bson.TypeUndefined:        typeUndefined,
+	bson.TypeNull:             typeNull,
+	bson.TypeDouble:           typeNumber,
+	bson.TypeInt32:            typeNumber,
+	bson.TypeInt64:            typeNumber,
+	bson.TypeDecimal128:       typeNumber,
+	bson.TypeString:           typeString,
+	bson.TypeSymbol:           typeString,
+	bson.TypeEmbeddedDocument: typeDocument,
+	bson.TypeArray:            typeArray,
+	bson.TypeBinary:           typeBinary,
+	bson.TypeObjectID:         typeObjectID,
+	bson.TypeBoolean:          typeBoolean,
+	bson.TypeDateTime:         typeDateTime,
+	bson.TypeTimestamp:        typeTimestamp,
+	bson.TypeRegex:            typeRegex,
+	bson.TypeDBPointer:        typeDBPointer,
+	bson.TypeJavaScript:       typeJavaScript,
+	bson.TypeCodeWithScope:    typeCodeWithScope,
+	bson.TypeMaxKey:           typeMaxKey,
+}
+
+// appendValue appends the ascending encoding of v to dst.
+func appendValue(dst []byte, v *bson.Value) ([]byte, error) {
+	t, ok := typeBytes[v.Type()]
+	if !ok {
+		return nil, fmt.Errorf("keystring: cannot encode values of type %v", v.Type())
+	}
+
+	return appendBody(append(dst, t), v)
+}
+
+// appendBody appends the encoding of v without its type byte to dst.
+func appendBody(dst []byte, v *bson.Value) ([]byte, error) {
+	switch v.Type() {
+	case bson.TypeDouble, bson.TypeInt32, bson.TypeInt64, bson.TypeDecimal128:
+		return appendNumber(dst, v), nil
+	case bson.TypeString:
+		return appendString(dst, v.StringValue()), nil
+	case bson.TypeSymbol:
+		return appendString(dst, v.Symbol()), nil
+	case bson.TypeEmbeddedDocument:
+		return appendDocument(dst, v.ReaderDocument(), true)
+	case bson.TypeArray:
+		return appendDocument(dst, v.ReaderArray(), false)
+	case bson.TypeBinary:
+		// Binary data is ordered by length, then subtype, then data, which is the order of its
+		// BSON encoding once the little-endian length is made big-endian.
+		raw := v.Bytes()
+		dst = appendUint32(dst, uint32(len(raw)))
+		return append(dst, raw[4:]...), nil
+	case bson.TypeObjectID:
+		id := v.ObjectID()
+		return append(dst, id[:]...), nil
+	case bson.TypeBoolean:
+		if v.Boolean() {
+			return append(dst, 1), nil
+		}
+		return append(dst, 0), nil
+	case bson.TypeDateTime:
+		// Flipping the sign bit orders negative times before positive ones.
+		return appendUint64(dst, binary.LittleEndian.Uint64(v.Bytes())^1<<63), nil
+	case bson.TypeTimestamp:
+		return appendUint64(dst, binary.LittleEndian.Uint64(v.Bytes())), nil
+	case bson.TypeRegex:
+		pattern, options := v.Regex()
+		return appendString(appendString(dst, pattern), options), nil
+	case bson.TypeDBPointer:
+		raw := v.Bytes()
+		return append(appendUint32(dst, uint32(len(raw))), raw...), nil
+	case bson.TypeJavaScript:
+		return appendString(dst, v.JavaScript()), nil
+	case bson.TypeCodeWithScope:
+		code, scope := v.ReaderJavaScriptWithScope()
+		return appendDocument(appendString(dst, code), scope, true)
+	}
+
+	// MinKey, undefined, null, and MaxKey have no value.
+	return dst, nil
+}
+
+// appendString appends s with each zero byte escaped as 0x00 0x01, followed by the terminator
+// 0x00 0x00. The terminator sorts before every byte of a longer string, and no encoded string is
+// a prefix of another.
+func appendString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			dst = append(dst, 0, 1)
+			continue
+		}
+		dst = append(dst, s[i])
+	}
+
+	return append(dst, 0, 0)
+}
+
+// appendDocument appends the elements of the document or array r, each as the type byte of its
+// value, its key if withKeys is true, and the encoding of its value, followed by end.
+func appendDocument(dst []byte, r bson.Reader, withKeys bool) ([]byte, error) {
+	itr, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	for itr.Next() {
+		elem := itr.Element()
+		t, ok := typeBytes[elem.Value().Type()]
+		if !ok {
+			return nil, fmt.Errorf("keystring: cannot encode values of type %v", elem.Value().Type())
+		}
+
+		dst = append(dst, t)
+		if withKeys {
+			dst = appendString(dst, elem.Key())
+		}
+		dst, err = appendBody(dst, elem.Value())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+
+	return append(dst, end), nil
+}
+
+func appendUint32(dst []byte, u uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], u)
+	return append(dst, b[:]...)
+}
+
+func appendUint64(dst []byte, u uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	return append(dst, b[:]...)
+}
@@ -0,0 +1,294 @@
+package keystring
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/decimal"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func dec(t *testing.T, s string) *bson.Value {
+	t.Helper()
+
+	d, err := decimal.ParseDecimal128(s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return AC.Decimal128(d)
+}
+
+// values returns values of every type in an arbitrary order.
+func values(t *testing.T) []*bson.Value {
+	oid1 := objectid.ObjectID{0x5a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	oid2 := objectid.ObjectID{0x5a, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0}
+
+	return []*bson.Value{
+		AC.MaxKey(),
+		AC.Int32(1),
+		AC.Double(1),
+		AC.Int64(-1),
+		AC.Double(0.5),
+		dec(t, "0.5"),
+		dec(t, "0.1"),
+		AC.Double(0.1),
+		AC.Double(-0.1),
+		AC.Double(-100),
+		AC.Int32(-99),
+		AC.Int64(math.MaxInt64),
+		AC.Int64(math.MinInt64),
+		AC.Double(1e300),
+		AC.Double(-1e-300),
+		dec(t, "1E+6000"),
+		dec(t, "-1E-6000"),
+		AC.Double(math.Inf(1)),
+		AC.Double(math.Inf(-1)),
+		AC.Double(math.NaN()),
+		dec(t, "NaN"),
+		dec(t, "-Infinity"),
+		AC.Int32(0),
+		dec(t, "-0.00"),
+		AC.Int32(10),
+		AC.Int32(100),
+		AC.Int32(101),
+		AC.String(""),
+		AC.String("a"),
+		AC.String("a\x00"),
+		AC.String("a\x00b"),
+		AC.String("a\x01"),
+		AC.Symbol("ab"),
+		AC.String("b"),
+		AC.Null(),
+		AC.Undefined(),
+		AC.MinKey(),
+		AC.DocumentFromElements(),
+		AC.DocumentFromElements(C.Int32("a", 1)),
+		AC.DocumentFromElements(C.Double("a", 1), C.Null("b")),
+		AC.DocumentFromElements(C.String("a", "x")),
+		AC.DocumentFromElements(C.Int32("b", 0)),
+		AC.DocumentFromElements(C.Int32("ab", 0)),
+		AC.DocumentFromElements(C.SubDocumentFromElements("a", C.Int32("x", 2))),
+		AC.ArrayFromValues(),
+		AC.ArrayFromValues(AC.Int32(1)),
+		AC.ArrayFromValues(AC.Int32(1), AC.Int32(2)),
+		AC.ArrayFromValues(AC.Int32(2)),
+		AC.ArrayFromValues(AC.String("a")),
+		AC.Binary([]byte{}),
+		AC.Binary([]byte{2}),
+		AC.Binary([]byte{1, 1}),
+		AC.BinaryWithSubtype([]byte{1}, 4),
+		AC.BinaryWithSubtype([]byte{1}, 2),
+		AC.ObjectID(oid1),
+		AC.ObjectID(oid2),
+		AC.Boolean(false),
+		AC.Boolean(true),
+		AC.DateTime(-1),
+		AC.DateTime(0),
+		AC.DateTime(1),
+		AC.DateTime(math.MinInt64),
+		AC.Timestamp(1, 2),
+		AC.Timestamp(2, 1),
+		AC.Regex("a", "i"),
+		AC.Regex("a", ""),
+		AC.Regex("a\x00", ""),
+		AC.DBPointer("db.c", oid1),
+		AC.DBPointer("db.coll", oid1),
+		AC.JavaScript("f()"),
+		AC.CodeWithScope("f()", bson.NewDocument(C.Int32("x", 1))),
+		AC.CodeWithScope("f()", bson.NewDocument()),
+	}
+}
+
+func sign(c int) int {
+	switch {
+	case c < 0:
+		return -1
+	case c > 0:
+		return 1
+	}
+
+	return 0
+}
+
+func TestEncodeOrder(t *testing.T) {
+	vals := values(t)
+	for _, dir := range []Direction{Ascending, Descending} {
+		keys := make([][]byte, len(vals))
+		for i, v := range vals {
+			key, err := Encode([]*bson.Value{v}, []Direction{dir})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			keys[i] = key
+		}
+
+		for i, a := range vals {
+			for j, b := range vals {
+				want := bson.CompareValues(a, b)
+				if dir == Descending {
+					want = -want
+				}
+				got := sign(bytes.Compare(keys[i], keys[j]))
+				if got != want {
+					t.Errorf("Unexpected result comparing %v and %v (direction %d). got %d; want %d", a, b, dir, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeCompound(t *testing.T) {
+	dirs := []Direction{Ascending, Descending}
+	keys := [][]*bson.Value{
+		{AC.Int32(1), AC.String("b")},
+		{AC.Int32(1), AC.String("a")},
+		{AC.Int32(1), AC.Null()},
+		{AC.Double(1.5), AC.String("z")},
+		{AC.String("a"), AC.String("a\x00")},
+		{AC.String("a"), AC.String("a")},
+		{AC.String("a\x00"), AC.MaxKey()},
+	}
+
+	var prev []byte
+	for i, values := range keys {
+		key, err := Encode(values, dirs)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			t.Errorf("Key %d does not sort after key %d. got %x; previous %x", i, i-1, key, prev)
+		}
+		prev = key
+	}
+
+	_, err := Encode([]*bson.Value{AC.Int32(1)}, dirs)
+	if err == nil {
+		t.Errorf("Expected an error for mismatched directions.")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	oid := objectid.ObjectID{0x5a, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+
+	testCases := []struct {
+		name string
+		v    *bson.Value
+		want *bson.Value
+	}{
+		{"minkey", AC.MinKey(), AC.MinKey()},
+		{"maxkey", AC.MaxKey(), AC.MaxKey()},
+		{"null", AC.Null(), AC.Null()},
+		{"undefined", AC.Undefined(), AC.Undefined()},
+		{"int32", AC.Int32(-42), AC.Int32(-42)},
+		{"int64", AC.Int64(1 << 40), AC.Int64(1 << 40)},
+		{"small int64", AC.Int64(7), AC.Int32(7)},
+		{"min int64", AC.Int64(math.MinInt64), AC.Int64(math.MinInt64)},
+		{"integral double", AC.Double(1), AC.Int32(1)},
+		{"double", AC.Double(-0.5), AC.Double(-0.5)},
+		{"large double", AC.Double(1e300), AC.Double(1e300)},
+		{"tiny double", AC.Double(5e-324), AC.Double(5e-324)},
+		{"inexact double", AC.Double(0.1), AC.Double(0.1)},
+		{"negative zero", AC.Double(math.Copysign(0, -1)), AC.Int32(0)},
+		{"infinity", AC.Double(math.Inf(-1)), AC.Double(math.Inf(-1))},
+		{"decimal", dec(t, "0.1"), dec(t, "0.1")},
+		{"exact decimal", dec(t, "2.50"), AC.Double(2.5)},
+		{"large decimal", dec(t, "1E+6000"), dec(t, "1E+6000")},
+		{"string", AC.String("a\x00b"), AC.String("a\x00b")},
+		{"symbol", AC.Symbol("s"), AC.String("s")},
+		{
+			"document",
+			AC.DocumentFromElements(C.Int32("a", 1), C.SubDocumentFromElements("b", C.String("c", "d")), C.ArrayFromElements("e", AC.Boolean(true))),
+			AC.DocumentFromElements(C.Int32("a", 1), C.SubDocumentFromElements("b", C.String("c", "d")), C.ArrayFromElements("e", AC.Boolean(true))),
+		},
+		{"array", AC.ArrayFromValues(AC.Int32(1), AC.Null(), AC.String("x")), AC.ArrayFromValues(AC.Int32(1), AC.Null(), AC.String("x"))},
+		{"binary", AC.BinaryWithSubtype([]byte{1, 2, 3}, 0x80), AC.BinaryWithSubtype([]byte{1, 2, 3}, 0x80)},
+		{"old binary", AC.BinaryWithSubtype([]byte{1, 2, 3}, 2), AC.BinaryWithSubtype([]byte{1, 2, 3}, 2)},
+		{"objectid", AC.ObjectID(oid), AC.ObjectID(oid)},
+		{"boolean", AC.Boolean(true), AC.Boolean(true)},
+		{"datetime", AC.DateTime(-1500), AC.DateTime(-1500)},
+		{"timestamp", AC.Timestamp(5, 6), AC.Timestamp(5, 6)},
+		{"regex", AC.Regex("^a", "im"), AC.Regex("^a", "im")},
+		{"dbpointer", AC.DBPointer("db.coll", oid), AC.DBPointer("db.coll", oid)},
+		{"javascript", AC.JavaScript("f()"), AC.JavaScript("f()")},
+		{"code with scope", AC.CodeWithScope("f(x)", bson.NewDocument(C.Int32("x", 1))), AC.CodeWithScope("f(x)", bson.NewDocument(C.Int32("x", 1)))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, dir := range []Direction{Ascending, Descending} {
+				dirs := []Direction{dir, Ascending}
+				key, err := Encode([]*bson.Value{tc.v, AC.Int32(9)}, dirs)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+
+				got, err := Decode(key, dirs)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if len(got) != 2 {
+					t.Fatalf("Unexpected number of values. got %d; want %d", len(got), 2)
+				}
+				if !got[0].Equal(tc.want) {
+					t.Errorf("Unexpected result. got %v; want %v", got[0], tc.want)
+				}
+				if !got[1].Equal(AC.Int32(9)) {
+					t.Errorf("Unexpected result. got %v; want %v", got[1], AC.Int32(9))
+				}
+			}
+		})
+	}
+
+	t.Run("nan", func(t *testing.T) {
+		key, err := Encode([]*bson.Value{dec(t, "NaN")}, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got, err := Decode(key, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got[0].Type() != bson.TypeDouble || !math.IsNaN(got[0].Double()) {
+			t.Errorf("Unexpected result. got %v; want NaN", got[0])
+		}
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid, err := Encode([]*bson.Value{AC.String("abc")}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		key  []byte
+	}{
+		{"unknown type", []byte{0x01}},
+		{"truncated string", valid[:len(valid)-1]},
+		{"bad escape", []byte{typeString, 'a', 0x00, 0x02}},
+		{"truncated number", []byte{typeNumber, numberPositive, 0x80}},
+		{"bad number class", []byte{typeNumber, 0x10}},
+		{"bad digit pair", []byte{typeNumber, numberPositive, 0x80, 0x01, 0xFF, 0x00}},
+		{"no digits", []byte{typeNumber, numberPositive, 0x80, 0x01, 0x00}},
+		{"bad boolean", []byte{typeBoolean, 0x02}},
+		{"truncated binary", []byte{typeBinary, 0, 0, 0, 9, 0}},
+		{"truncated document", []byte{typeDocument, typeNull, 'a', 0, 0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(tc.key, nil)
+			if err != ErrInvalidKey {
+				t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidKey)
+			}
+		})
+	}
+}
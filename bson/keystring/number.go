@@ -0,0 +1,168 @@
+package keystring
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/decimal"
+)
+
+// The classes of numbers, in order. A finite number other than zero is followed by its exponent
+// and digits, which are inverted for negative numbers.
+const (
+	numberNaN byte = iota
+	numberNegInf
+	numberNegative
+	numberZero
+	numberPositive
+	numberPosInf
+)
+
+// exponentBias is added to exponents so that they are encoded as unsigned integers.
+const exponentBias = 1 << 15
+
+// appendNumber appends the encoding of the number v. A finite number other than zero is written
+// as 0.d₁d₂d₃… × 10^e with d₁ non-zero and no trailing zeros, and encoded as e followed by its
+// digits in pairs, each pair as a byte from 1 to 100, and a zero byte. Since every value has a
+// single such form, numbers of different types which are equal have the same encoding.
+func appendNumber(dst []byte, v *bson.Value) []byte {
+	var neg bool
+	var digits string
+	var exp int
+
+	switch v.Type() {
+	case bson.TypeInt32, bson.TypeInt64:
+		var i int64
+		if v.Type() == bson.TypeInt32 {
+			i = int64(v.Int32())
+		} else {
+			i = v.Int64()
+		}
+		if i == 0 {
+			return append(dst, numberZero)
+		}
+
+		u := uint64(i)
+		if i < 0 {
+			neg, u = true, -u
+		}
+		digits = strconv.FormatUint(u, 10)
+		exp = len(digits)
+	case bson.TypeDouble:
+		f := v.Double()
+		switch {
+		case math.IsNaN(f):
+			return append(dst, numberNaN)
+		case math.IsInf(f, 1):
+			return append(dst, numberPosInf)
+		case math.IsInf(f, -1):
+			return append(dst, numberNegInf)
+		case f == 0:
+			return append(dst, numberZero)
+		}
+
+		neg = f < 0
+		digits, exp = floatDigits(math.Abs(f))
+	case bson.TypeDecimal128:
+		d := v.Decimal128()
+		switch {
+		case d.IsNaN():
+			return append(dst, numberNaN)
+		case d.IsInf(1):
+			return append(dst, numberPosInf)
+		case d.IsInf(-1):
+			return append(dst, numberNegInf)
+		}
+
+		bi, e, _ := d.BigInt()
+		if bi.Sign() == 0 {
+			return append(dst, numberZero)
+		}
+		neg = bi.Sign() < 0
+		digits = new(big.Int).Abs(bi).String()
+		exp = len(digits) + e
+	}
+
+	digits = strings.TrimRight(digits, "0")
+
+	class := numberPositive
+	if neg {
+		class = numberNegative
+	}
+	dst = append(dst, class)
+
+	start := len(dst)
+	dst = appendUint16(dst, uint16(exp+exponentBias))
+	for i := 0; i < len(digits); i += 2 {
+		pair := int(digits[i]-'0') * 10
+		if i+1 < len(digits) {
+			pair += int(digits[i+1] - '0')
+		}
+		dst = append(dst, byte(pair+1))
+	}
+	dst = append(dst, 0)
+
+	// The larger the magnitude of a negative number, the smaller it is.
+	if neg {
+		for i := start; i < len(dst); i++ {
+			dst[i] = ^dst[i]
+		}
+	}
+
+	return dst
+}
+
+// floatDigits returns the exact decimal digits and exponent of the positive finite f, such that f
+// is 0.digits × 10^exp. Every double has an exact decimal representation of at most 767
+// significant digits.
+func floatDigits(f float64) (string, int) {
+	s := strconv.FormatFloat(f, 'e', 766, 64)
+	i := strings.IndexByte(s, 'e')
+	exp, _ := strconv.Atoi(s[i+1:])
+
+	return strings.TrimRight(s[:1]+s[2:i], "0"), exp + 1
+}
+
+// numberValue returns the narrowest of an int32, int64, double, or decimal which is exactly
+// 0.digits × 10^exp, negated if neg is true. It returns nil if there is none.
+func numberValue(neg bool, digits string, exp int) *bson.Value {
+	sign := ""
+	if neg {
+		sign = "-"
+	}
+
+	if exp >= len(digits) && exp <= 19 {
+		i, err := strconv.ParseInt(sign+digits+strings.Repeat("0", exp-len(digits)), 10, 64)
+		if err == nil {
+			if int64(int32(i)) == i {
+				return bson.AC.Int32(int32(i))
+			}
+			return bson.AC.Int64(i)
+		}
+	}
+
+	f, err := strconv.ParseFloat(sign+"0."+digits+"e"+strconv.Itoa(exp), 64)
+	if err == nil && !math.IsInf(f, 0) && f != 0 {
+		if fd, fe := floatDigits(math.Abs(f)); fd == digits && fe == exp {
+			return bson.AC.Double(f)
+		}
+	}
+
+	bi, ok := new(big.Int).SetString(sign+digits, 10)
+	if !ok {
+		return nil
+	}
+	d, ok := decimal.ParseDecimal128FromBigInt(bi, exp-len(digits))
+	if !ok {
+		return nil
+	}
+
+	return bson.AC.Decimal128(d)
+}
+
+func appendUint16(dst []byte, u uint16) []byte {
+	return append(dst, byte(u>>8), byte(u))
+}
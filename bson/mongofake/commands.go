@@ -0,0 +1,736 @@
+package mongofake
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/builder"
+	"github.com/skriptble/wilson/bson/collection"
+	"github.com/skriptble/wilson/bson/extjson"
+	"github.com/skriptble/wilson/bson/pipeline"
+)
+
+// The limits and versions reported by the handshake commands.
+const (
+	maxDocumentSize   = 16 * 1024 * 1024
+	maxWriteBatchSize = 100000
+	maxWireVersion    = 17
+	version           = "6.0.0"
+)
+
+// defaultBatchSize is the number of documents in the first batch of a cursor when the command does
+// not give a batch size.
+const defaultBatchSize = 101
+
+// The error codes of the replies to failed commands.
+const (
+	codeBadValue         = 2
+	codeFailedToParse    = 9
+	codeTypeMismatch     = 14
+	codeCursorNotFound   = 43
+	codeCommandNotFound  = 59
+	codeImmutableField   = 66
+	codeInvalidNamespace = 73
+	codeDuplicateKey     = 11000
+)
+
+var codeNames = map[int32]string{
+	codeBadValue:         "BadValue",
+	codeFailedToParse:    "FailedToParse",
+	codeTypeMismatch:     "TypeMismatch",
+	codeCursorNotFound:   "CursorNotFound",
+	codeCommandNotFound:  "CommandNotFound",
+	codeImmutableField:   "ImmutableField",
+	codeInvalidNamespace: "InvalidNamespace",
+	codeDuplicateKey:     "DuplicateKey",
+}
+
+// commandError is an error which is reported in the reply to a command.
+type commandError struct {
+	code    int32
+	message string
+}
+
+func (ce commandError) Error() string {
+	return ce.message
+}
+
+func errorf(code int32, format string, args ...interface{}) commandError {
+	return commandError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// request is a command received on the connection conn for the database db.
+type request struct {
+	db   string
+	cmd  bson.Reader
+	conn int32
+}
+
+// handler runs a command and returns the elements of its reply other than ok.
+type handler func(s *Server, req request) ([]builder.Elementer, error)
+
+// commands holds the handler of each command by name.
+var commands = map[string]handler{
+	"hello":       (*Server).hello,
+	"isMaster":    (*Server).hello,
+	"ismaster":    (*Server).hello,
+	"buildInfo":   (*Server).buildInfo,
+	"buildinfo":   (*Server).buildInfo,
+	"ping":        (*Server).ping,
+	"insert":      (*Server).insert,
+	"find":        (*Server).find,
+	"getMore":     (*Server).getMore,
+	"killCursors": (*Server).killCursors,
+	"update":      (*Server).update,
+	"delete":      (*Server).delete,
+	"count":       (*Server).count,
+	"aggregate":   (*Server).aggregate,
+}
+
+// run runs the command of req and returns its reply. A failed command has a reply with ok set to
+// 0 and the error message and code.
+func (s *Server) run(req request) []byte {
+	var doc []byte
+	elems, err := s.dispatch(req)
+	if err == nil {
+		doc, err = build(append(elems, builder.C.Double("ok", 1))...)
+	}
+	if err == nil {
+		return doc
+	}
+
+	ce := toCommandError(err, "")
+	// An error document has no elements which can fail to be written.
+	doc, _ = build(
+		builder.C.Double("ok", 0),
+		builder.C.String("errmsg", ce.message),
+		builder.C.Int32("code", ce.code),
+		builder.C.String("codeName", codeNames[ce.code]),
+	)
+	return doc
+}
+
+func (s *Server) dispatch(req request) ([]builder.Elementer, error) {
+	elem, err := req.cmd.ElementAt(0)
+	if err != nil {
+		return nil, errorf(codeFailedToParse, "the command document is empty")
+	}
+
+	h, ok := commands[elem.Key()]
+	if !ok {
+		return nil, errorf(codeCommandNotFound, "no such command: '%s'", elem.Key())
+	}
+	return h(s, req)
+}
+
+// toCommandError returns the error reported for err by a command on the namespace ns.
+func toCommandError(err error, ns string) commandError {
+	var ce commandError
+	if errors.As(err, &ce) {
+		return ce
+	}
+
+	var dke collection.DuplicateKeyError
+	if errors.As(err, &dke) {
+		key := "{}"
+		if b, err := dke.Key.MarshalBSON(); err == nil {
+			if s, err := extjson.BsonToExtJSON(false, b); err == nil {
+				key = s
+			}
+		}
+		return errorf(codeDuplicateKey, "E11000 duplicate key error collection: %s index: %s dup key: %s", ns, dke.Index, key)
+	}
+
+	if errors.Is(err, collection.ErrIDChanged) {
+		return commandError{code: codeImmutableField, message: err.Error()}
+	}
+
+	return commandError{code: codeBadValue, message: err.Error()}
+}
+
+// writeError returns an element of the writeErrors array of a reply for the failure err of the
+// write at index within the command.
+func writeError(index int, err error, ns string) builder.ArrayElementer {
+	ce := toCommandError(err, ns)
+	return builder.AC.SubDocumentWithElements(
+		builder.C.Int32("index", int32(index)),
+		builder.C.Int32("code", ce.code),
+		builder.C.String("errmsg", ce.message),
+	)
+}
+
+func (s *Server) hello(req request) ([]builder.Elementer, error) {
+	// hello reports isWritablePrimary while the legacy isMaster reports ismaster.
+	primary := "isWritablePrimary"
+	if elem, _ := req.cmd.ElementAt(0); elem.Key() != "hello" {
+		primary = "ismaster"
+	}
+
+	return []builder.Elementer{
+		builder.C.Boolean(primary, true),
+		builder.C.Boolean("helloOk", true),
+		builder.C.Int32("maxBsonObjectSize", maxDocumentSize),
+		builder.C.Int32("maxMessageSizeBytes", maxMessageSize),
+		builder.C.Int32("maxWriteBatchSize", maxWriteBatchSize),
+		builder.C.DateTime("localTime", time.Now().UnixNano()/int64(time.Millisecond)),
+		builder.C.Int32("logicalSessionTimeoutMinutes", 30),
+		builder.C.Int32("connectionId", req.conn),
+		builder.C.Int32("minWireVersion", 0),
+		builder.C.Int32("maxWireVersion", maxWireVersion),
+		builder.C.Boolean("readOnly", false),
+	}, nil
+}
+
+func (s *Server) buildInfo(req request) ([]builder.Elementer, error) {
+	return []builder.Elementer{
+		builder.C.String("version", version),
+		builder.C.ArrayWithElements("versionArray",
+			builder.AC.Int32(6), builder.AC.Int32(0), builder.AC.Int32(0), builder.AC.Int32(0)),
+		builder.C.Int32("bits", 64),
+		builder.C.Boolean("debug", false),
+		builder.C.Int32("maxBsonObjectSize", maxDocumentSize),
+	}, nil
+}
+
+func (s *Server) ping(req request) ([]builder.Elementer, error) {
+	return nil, nil
+}
+
+func (s *Server) insert(req request) ([]builder.Elementer, error) {
+	c, ns, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := documents(req.cmd, "documents")
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := boolean(req.cmd, "ordered", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	var writeErrors []builder.ArrayElementer
+	for i, b := range docs {
+		d, err := bson.ReadDocument(b)
+		if err == nil {
+			_, err = c.Insert(d)
+		}
+		if err != nil {
+			writeErrors = append(writeErrors, writeError(i, err, ns))
+			if ordered {
+				break
+			}
+			continue
+		}
+		n++
+	}
+
+	return []builder.Elementer{
+		builder.C.Int32("n", int32(n)),
+		builder.C.If(len(writeErrors) > 0, builder.C.ArrayWithElements("writeErrors", writeErrors...)),
+	}, nil
+}
+
+func (s *Server) find(req request) ([]builder.Elementer, error) {
+	c, ns, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := document(req.cmd, "filter")
+	if err != nil {
+		return nil, err
+	}
+	sort, err := document(req.cmd, "sort")
+	if err != nil {
+		return nil, err
+	}
+	projection, err := document(req.cmd, "projection")
+	if err != nil {
+		return nil, err
+	}
+	skip, _, err := nonNegative(req.cmd, "skip")
+	if err != nil {
+		return nil, err
+	}
+	limit, _, err := integer(req.cmd, "limit")
+	if err != nil {
+		return nil, err
+	}
+	batchSize, ok, err := nonNegative(req.cmd, "batchSize")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		batchSize = defaultBatchSize
+	}
+	single, err := boolean(req.cmd, "singleBatch", false)
+	if err != nil {
+		return nil, err
+	}
+	// A negative limit is the legacy form of a limit with a single batch.
+	if limit < 0 {
+		limit, single = -limit, true
+	}
+
+	docs, err := c.Find(filter, sort, int(skip), int(limit), projection)
+	if err != nil {
+		return nil, err
+	}
+
+	return []builder.Elementer{s.openCursor(ns, docs, batchSize, single)}, nil
+}
+
+// openCursor returns the cursor element of the reply to a command which returns docs from the
+// namespace ns. The first batchSize documents are returned in the first batch, and unless single
+// is true the rest are kept for getMore.
+func (s *Server) openCursor(ns string, docs []bson.Reader, batchSize int64, single bool) builder.Elementer {
+	n := len(docs)
+	if int64(n) > batchSize {
+		n = int(batchSize)
+	}
+
+	var id int64
+	if n < len(docs) && !single {
+		s.mu.Lock()
+		s.lastCursor++
+		id = s.lastCursor
+		s.cursors[id] = &cursor{ns: ns, docs: docs[n:]}
+		s.mu.Unlock()
+	}
+
+	return cursorElement("firstBatch", docs[:n], id, ns)
+}
+
+// cursorElement returns the cursor element of a reply with the batch of docs in the array field.
+func cursorElement(field string, docs []bson.Reader, id int64, ns string) builder.Elementer {
+	batch := make([]builder.ArrayElementer, len(docs))
+	for i, doc := range docs {
+		batch[i] = builder.AC.SubDocumentWithElements(builder.C.ElementsFromReader(doc))
+	}
+
+	return builder.C.SubDocumentWithElements("cursor",
+		builder.C.ArrayWithElements(field, batch...),
+		builder.C.Int64("id", id),
+		builder.C.String("ns", ns),
+	)
+}
+
+func (s *Server) getMore(req request) ([]builder.Elementer, error) {
+	elem, _ := req.cmd.ElementAt(0)
+	if elem.Value().Type() != bson.TypeInt64 {
+		return nil, errorf(codeTypeMismatch, "field 'getMore' must be of type long, but found type %s", elem.Value().Type().Alias())
+	}
+	id := elem.Value().Int64()
+
+	name, err := stringField(req.cmd, "collection")
+	if err != nil {
+		return nil, err
+	}
+	ns := req.db + "." + name
+	batchSize, ok, err := nonNegative(req.cmd, "batchSize")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, found := s.cursors[id]
+	if !found || cur.ns != ns {
+		return nil, errorf(codeCursorNotFound, "cursor id %d not found for namespace %s", id, ns)
+	}
+
+	n := len(cur.docs)
+	if ok && batchSize > 0 && int64(n) > batchSize {
+		n = int(batchSize)
+	}
+	batch := cur.docs[:n]
+	cur.docs = cur.docs[n:]
+	if len(cur.docs) == 0 {
+		delete(s.cursors, id)
+		id = 0
+	}
+
+	return []builder.Elementer{cursorElement("nextBatch", batch, id, ns)}, nil
+}
+
+func (s *Server) killCursors(req request) ([]builder.Elementer, error) {
+	_, ns, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+	v := lookup(req.cmd, "cursors")
+	if v == nil || v.Type() != bson.TypeArray {
+		return nil, errorf(codeFailedToParse, "the 'cursors' field must be an array of cursor ids")
+	}
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var killed, notFound []builder.ArrayElementer
+	s.mu.Lock()
+	for itr.Next() {
+		v := itr.Element().Value()
+		if v.Type() != bson.TypeInt64 {
+			s.mu.Unlock()
+			return nil, errorf(codeFailedToParse, "cursor ids must be of type long")
+		}
+
+		id := v.Int64()
+		if cur, ok := s.cursors[id]; ok && cur.ns == ns {
+			delete(s.cursors, id)
+			killed = append(killed, builder.AC.Int64(id))
+		} else {
+			notFound = append(notFound, builder.AC.Int64(id))
+		}
+	}
+	s.mu.Unlock()
+	if err := itr.Err(); err != nil {
+		return nil, err
+	}
+
+	return []builder.Elementer{
+		builder.C.ArrayWithElements("cursorsKilled", killed...),
+		builder.C.ArrayWithElements("cursorsNotFound", notFound...),
+		builder.C.ArrayWithElements("cursorsAlive"),
+		builder.C.ArrayWithElements("cursorsUnknown"),
+	}, nil
+}
+
+func (s *Server) update(req request) ([]builder.Elementer, error) {
+	c, ns, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := documents(req.cmd, "updates")
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := boolean(req.cmd, "ordered", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var n, modified int
+	var upserted, writeErrors []builder.ArrayElementer
+	for i, stmt := range updates {
+		res, err := updateOne(c, stmt)
+		if err != nil {
+			writeErrors = append(writeErrors, writeError(i, err, ns))
+			if ordered {
+				break
+			}
+			continue
+		}
+
+		n += res.Matched
+		modified += res.Modified
+		if res.UpsertedID != nil {
+			n++
+			upserted = append(upserted, builder.AC.SubDocumentWithElements(
+				builder.C.Int32("index", int32(i)),
+				builder.C.ElementsFromDocument(bson.NewDocument(bson.C.Value("_id", res.UpsertedID))),
+			))
+		}
+	}
+
+	return []builder.Elementer{
+		builder.C.Int32("n", int32(n)),
+		builder.C.Int32("nModified", int32(modified)),
+		builder.C.If(len(upserted) > 0, builder.C.ArrayWithElements("upserted", upserted...)),
+		builder.C.If(len(writeErrors) > 0, builder.C.ArrayWithElements("writeErrors", writeErrors...)),
+	}, nil
+}
+
+// updateOne applies an update statement {q, u, upsert, multi} of an update command to c.
+func updateOne(c *collection.Collection, stmt bson.Reader) (collection.UpdateResult, error) {
+	var res collection.UpdateResult
+
+	q, err := document(stmt, "q")
+	if err != nil {
+		return res, err
+	}
+	if v := lookup(stmt, "u"); v != nil && v.Type() == bson.TypeArray {
+		return res, errorf(codeFailedToParse, "pipeline-style updates are not supported")
+	}
+	u, err := document(stmt, "u")
+	if err != nil {
+		return res, err
+	}
+	if q == nil || u == nil {
+		return res, errorf(codeFailedToParse, "an update statement must have the fields 'q' and 'u'")
+	}
+	upsert, err := boolean(stmt, "upsert", false)
+	if err != nil {
+		return res, err
+	}
+	multi, err := boolean(stmt, "multi", false)
+	if err != nil {
+		return res, err
+	}
+
+	return c.Update(q, u, upsert, multi)
+}
+
+func (s *Server) delete(req request) ([]builder.Elementer, error) {
+	c, ns, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+	deletes, err := documents(req.cmd, "deletes")
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := boolean(req.cmd, "ordered", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	var writeErrors []builder.ArrayElementer
+	for i, stmt := range deletes {
+		removed, err := deleteOne(c, stmt)
+		if err != nil {
+			writeErrors = append(writeErrors, writeError(i, err, ns))
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += removed
+	}
+
+	return []builder.Elementer{
+		builder.C.Int32("n", int32(n)),
+		builder.C.If(len(writeErrors) > 0, builder.C.ArrayWithElements("writeErrors", writeErrors...)),
+	}, nil
+}
+
+// deleteOne applies a delete statement {q, limit} of a delete command to c, where a limit of 0
+// removes every matching document and a limit of 1 removes the first.
+func deleteOne(c *collection.Collection, stmt bson.Reader) (int, error) {
+	q, err := document(stmt, "q")
+	if err != nil {
+		return 0, err
+	}
+	if q == nil {
+		return 0, errorf(codeFailedToParse, "a delete statement must have the field 'q'")
+	}
+	limit, _, err := integer(stmt, "limit")
+	if err != nil {
+		return 0, err
+	}
+	if limit != 0 && limit != 1 {
+		return 0, errorf(codeFailedToParse, "the limit of a delete statement must be 0 or 1")
+	}
+
+	return c.Delete(q, limit == 0)
+}
+
+func (s *Server) count(req request) ([]builder.Elementer, error) {
+	c, _, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := document(req.cmd, "query")
+	if err != nil {
+		return nil, err
+	}
+	skip, _, err := nonNegative(req.cmd, "skip")
+	if err != nil {
+		return nil, err
+	}
+	limit, _, err := integer(req.cmd, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := c.Count(filter)
+	if err != nil {
+		return nil, err
+	}
+	n := int64(count) - skip
+	if n < 0 {
+		n = 0
+	}
+	if limit < 0 {
+		limit = -limit
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	return []builder.Elementer{builder.C.Int32("n", int32(n))}, nil
+}
+
+func (s *Server) aggregate(req request) ([]builder.Elementer, error) {
+	c, ns, err := s.namespace(req)
+	if err != nil {
+		return nil, err
+	}
+	v := lookup(req.cmd, "pipeline")
+	if v == nil || v.Type() != bson.TypeArray {
+		return nil, errorf(codeFailedToParse, "the 'pipeline' field must be an array")
+	}
+	p, err := pipeline.CompileReader(v.ReaderArray())
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := int64(defaultBatchSize)
+	if v := lookup(req.cmd, "cursor"); v != nil {
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil, errorf(codeTypeMismatch, "the 'cursor' field must be a document")
+		}
+		n, ok, err := nonNegative(v.ReaderDocument(), "batchSize")
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			batchSize = n
+		}
+	}
+
+	docs, err := c.Find(nil, nil, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	docs, err = p.Aggregate(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []builder.Elementer{s.openCursor(ns, docs, batchSize, false)}, nil
+}
+
+// namespace returns the collection named by the first element of the command of req, and its
+// namespace.
+func (s *Server) namespace(req request) (*collection.Collection, string, error) {
+	elem, _ := req.cmd.ElementAt(0)
+	if elem.Value().Type() != bson.TypeString {
+		return nil, "", errorf(codeInvalidNamespace, "the collection name of '%s' must be a string", elem.Key())
+	}
+	name := elem.Value().StringValue()
+	if req.db == "" || name == "" {
+		return nil, "", errorf(codeInvalidNamespace, "invalid namespace '%s.%s'", req.db, name)
+	}
+
+	return s.Collection(req.db, name), req.db + "." + name, nil
+}
+
+// lookup returns the value of the field key of r, or nil if r has no such field.
+func lookup(r bson.Reader, key string) *bson.Value {
+	elem, err := r.Lookup(key)
+	if err != nil || elem == nil {
+		return nil
+	}
+
+	return elem.Value()
+}
+
+// document returns the embedded document in the field key of r, or nil if r has no such field.
+func document(r bson.Reader, key string) (*bson.Document, error) {
+	v := lookup(r, key)
+	if v == nil {
+		return nil, nil
+	}
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, errorf(codeTypeMismatch, "the '%s' field must be a document, but found type %s", key, v.Type().Alias())
+	}
+
+	return bson.ReadDocument(v.ReaderDocument())
+}
+
+// documents returns the documents of the array in the field key of r.
+func documents(r bson.Reader, key string) ([]bson.Reader, error) {
+	v := lookup(r, key)
+	if v == nil || v.Type() != bson.TypeArray {
+		return nil, errorf(codeFailedToParse, "the '%s' field must be an array of documents", key)
+	}
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.Reader
+	for itr.Next() {
+		v := itr.Element().Value()
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil, errorf(codeFailedToParse, "the '%s' field must be an array of documents", key)
+		}
+		docs = append(docs, v.ReaderDocument())
+	}
+
+	return docs, itr.Err()
+}
+
+// stringField returns the string in the field key of r.
+func stringField(r bson.Reader, key string) (string, error) {
+	v := lookup(r, key)
+	if v == nil || v.Type() != bson.TypeString {
+		return "", errorf(codeFailedToParse, "the '%s' field must be a string", key)
+	}
+
+	return v.StringValue(), nil
+}
+
+// integer returns the whole number in the field key of r, and false if r has no such field.
+func integer(r bson.Reader, key string) (int64, bool, error) {
+	v := lookup(r, key)
+	if v == nil {
+		return 0, false, nil
+	}
+
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true, nil
+	case bson.TypeInt64:
+		return v.Int64(), true, nil
+	case bson.TypeDouble:
+		f := v.Double()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), true, nil
+		}
+	}
+
+	return 0, false, errorf(codeTypeMismatch, "the '%s' field must be a whole number", key)
+}
+
+// nonNegative is like integer but fails if the number is negative.
+func nonNegative(r bson.Reader, key string) (int64, bool, error) {
+	n, ok, err := integer(r, key)
+	if err == nil && n < 0 {
+		err = errorf(codeBadValue, "the '%s' field must not be negative", key)
+	}
+
+	return n, ok, err
+}
+
+// boolean returns the truth of the field key of r, which may be a boolean or a number, or def if r
+// has no such field.
+func boolean(r bson.Reader, key string, def bool) (bool, error) {
+	v := lookup(r, key)
+	if v == nil {
+		return def, nil
+	}
+
+	switch v.Type() {
+	case bson.TypeBoolean:
+		return v.Boolean(), nil
+	case bson.TypeInt32:
+		return v.Int32() != 0, nil
+	case bson.TypeInt64:
+		return v.Int64() != 0, nil
+	case bson.TypeDouble:
+		return v.Double() != 0, nil
+	}
+
+	return false, errorf(codeTypeMismatch, "the '%s' field must be a boolean", key)
+}
@@ -0,0 +1,612 @@
+package mongofake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+// client sends commands to a server and reads their replies.
+type client struct {
+	t         *testing.T
+	conn      net.Conn
+	requestID int32
+}
+
+func dial(t *testing.T, s *Server) *client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+
+	return &client{t: t, conn: conn}
+}
+
+func listen(t *testing.T) *Server {
+	t.Helper()
+
+	s, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// write sends an OP_MSG with the flags, the command cmd for the database test as its body, and
+// the sequences, and returns its request ID.
+func (c *client) write(flags uint32, cmd *bson.Document, sequences ...sequence) int32 {
+	c.t.Helper()
+
+	c.requestID++
+	b := appendHeader(nil, c.requestID, 0, opMsg)
+	b = appendInt32(b, int32(flags))
+	b = append(b, 0)
+	b = append(b, bsontest.Marshal(c.t, cmd.Append(C.String("$db", "test")))...)
+	for _, seq := range sequences {
+		b = append(b, 1)
+		start := len(b)
+		b = appendInt32(b, 0)
+		b = append(b, seq.identifier...)
+		b = append(b, 0)
+		for _, doc := range seq.docs {
+			b = append(b, doc...)
+		}
+		binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start))
+	}
+	if flags&flagChecksumPresent != 0 {
+		b = appendInt32(b, 0)
+	}
+
+	if _, err := c.conn.Write(finishMessage(b)); err != nil {
+		c.t.Fatalf("Unexpected error: %v", err)
+	}
+	return c.requestID
+}
+
+// read reads a reply to the request requestID.
+func (c *client) read(requestID int32) message {
+	c.t.Helper()
+
+	m, err := readMessage(c.conn)
+	if err != nil {
+		c.t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.responseTo != requestID {
+		c.t.Fatalf("Unexpected responseTo. got %d; want %d", m.responseTo, requestID)
+	}
+	return m
+}
+
+// run sends cmd with OP_MSG and returns the body of the reply.
+func (c *client) run(cmd *bson.Document, sequences ...sequence) bson.Reader {
+	c.t.Helper()
+
+	m := c.read(c.write(0, cmd, sequences...))
+	if m.opCode != opMsg {
+		c.t.Fatalf("Unexpected opcode. got %d; want %d", m.opCode, opMsg)
+	}
+	reply, err := parseMsg(m.body)
+	if err != nil {
+		c.t.Fatalf("Unexpected error: %v", err)
+	}
+	return reply.body
+}
+
+// query sends q with OP_QUERY to the collection ns and returns the flags and the document of the
+// reply.
+func (c *client) query(ns string, q *bson.Document) (int32, bson.Reader) {
+	c.t.Helper()
+
+	c.requestID++
+	b := appendHeader(nil, c.requestID, 0, opQuery)
+	b = appendInt32(b, 0)
+	b = append(b, ns...)
+	b = append(b, 0)
+	b = appendInt32(b, 0)
+	b = appendInt32(b, -1)
+	b = append(b, bsontest.Marshal(c.t, q)...)
+	if _, err := c.conn.Write(finishMessage(b)); err != nil {
+		c.t.Fatalf("Unexpected error: %v", err)
+	}
+
+	m := c.read(c.requestID)
+	if m.opCode != opReply {
+		c.t.Fatalf("Unexpected opcode. got %d; want %d", m.opCode, opReply)
+	}
+	if n := binary.LittleEndian.Uint32(m.body[16:]); n != 1 {
+		c.t.Fatalf("Unexpected number of documents. got %d; want %d", n, 1)
+	}
+	doc, _, err := readDocument(m.body[20:])
+	if err != nil {
+		c.t.Fatalf("Unexpected error: %v", err)
+	}
+	return int32(binary.LittleEndian.Uint32(m.body)), doc
+}
+
+func value(t *testing.T, r bson.Reader, path ...string) *bson.Value {
+	t.Helper()
+
+	elem, err := r.Lookup(path...)
+	if err != nil || elem == nil {
+		t.Fatalf("Could not find %v in %v: %v", path, r, err)
+	}
+	return elem.Value()
+}
+
+func assertOK(t *testing.T, reply bson.Reader) {
+	t.Helper()
+
+	if ok := value(t, reply, "ok").Double(); ok != 1 {
+		t.Fatalf("Unexpected failure: %v", reply)
+	}
+}
+
+func assertCode(t *testing.T, reply bson.Reader, code int32, codeName string) {
+	t.Helper()
+
+	if ok := value(t, reply, "ok").Double(); ok != 0 {
+		t.Fatalf("Unexpected success: %v", reply)
+	}
+	if got := value(t, reply, "code").Int32(); got != code {
+		t.Errorf("Unexpected code. got %d; want %d", got, code)
+	}
+	if got := value(t, reply, "codeName").StringValue(); got != codeName {
+		t.Errorf("Unexpected codeName. got %s; want %s", got, codeName)
+	}
+}
+
+func assertInt32(t *testing.T, reply bson.Reader, want int32, path ...string) {
+	t.Helper()
+
+	if got := value(t, reply, path...).Int32(); got != want {
+		t.Errorf("Unexpected %v. got %d; want %d", path, got, want)
+	}
+}
+
+// assertBatch checks that the array at path within reply holds the documents want.
+func assertBatch(t *testing.T, reply bson.Reader, want []*bson.Document, path ...string) {
+	t.Helper()
+
+	itr, err := value(t, reply, path...).ReaderArray().Iterator()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got []bson.Reader
+	for itr.Next() {
+		got = append(got, itr.Element().Value().ReaderDocument())
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected number of documents. got %d; want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], bsontest.Marshal(t, want[i])) {
+			t.Errorf("Unexpected document %d. got %v; want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func numbered(ns ...int32) []*bson.Document {
+	docs := make([]*bson.Document, len(ns))
+	for i, n := range ns {
+		docs[i] = bson.NewDocument(C.Int32("_id", n), C.Int32("n", n%3))
+	}
+	return docs
+}
+
+// seed inserts the documents numbered 1 through 5 into test.c.
+func seed(t *testing.T, c *client) {
+	t.Helper()
+
+	seq := sequence{identifier: "documents"}
+	for _, d := range numbered(1, 2, 3, 4, 5) {
+		seq.docs = append(seq.docs, bsontest.Marshal(t, d))
+	}
+	reply := c.run(bson.NewDocument(C.String("insert", "c")), seq)
+	assertOK(t, reply)
+	assertInt32(t, reply, 5, "n")
+}
+
+func TestHandshake(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+
+	reply := c.run(bson.NewDocument(C.Int32("hello", 1)))
+	assertOK(t, reply)
+	if !value(t, reply, "isWritablePrimary").Boolean() {
+		t.Errorf("Expected isWritablePrimary to be true")
+	}
+	assertInt32(t, reply, maxWireVersion, "maxWireVersion")
+	assertInt32(t, reply, maxDocumentSize, "maxBsonObjectSize")
+	if value(t, reply, "localTime").Type() != bson.TypeDateTime {
+		t.Errorf("Expected localTime to be a datetime")
+	}
+
+	flags, reply := c.query("admin.$cmd", bson.NewDocument(
+		C.SubDocumentFromElements("$query", C.Int32("isMaster", 1)),
+		C.SubDocumentFromElements("$readPreference", C.String("mode", "primaryPreferred")),
+	))
+	if flags != 0 {
+		t.Errorf("Unexpected flags. got %d; want %d", flags, 0)
+	}
+	assertOK(t, reply)
+	if !value(t, reply, "ismaster").Boolean() {
+		t.Errorf("Expected ismaster to be true")
+	}
+	id := value(t, reply, "connectionId").Int32()
+
+	reply = dial(t, s).run(bson.NewDocument(C.Int32("isMaster", 1)))
+	if got := value(t, reply, "connectionId").Int32(); got == id {
+		t.Errorf("Expected a different connectionId for a different connection. got %d", got)
+	}
+
+	reply = c.run(bson.NewDocument(C.Int32("buildInfo", 1)))
+	assertOK(t, reply)
+	if got := value(t, reply, "version").StringValue(); got != version {
+		t.Errorf("Unexpected version. got %s; want %s", got, version)
+	}
+
+	_, reply = c.query("admin.$cmd", bson.NewDocument(C.Int32("ping", 1)))
+	assertOK(t, reply)
+}
+
+func TestFind(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	seed(t, c)
+
+	testCases := []struct {
+		name string
+		cmd  *bson.Document
+		want []*bson.Document
+	}{
+		{"all", bson.NewDocument(C.String("find", "c")), numbered(1, 2, 3, 4, 5)},
+		{
+			"filter",
+			bson.NewDocument(C.String("find", "c"), C.SubDocumentFromElements("filter", C.Int32("n", 1))),
+			numbered(1, 4),
+		},
+		{
+			"sort skip limit",
+			bson.NewDocument(
+				C.String("find", "c"),
+				C.SubDocumentFromElements("sort", C.Int32("_id", -1)),
+				C.Int32("skip", 1),
+				C.Int64("limit", 2),
+			),
+			numbered(4, 3),
+		},
+		{
+			"projection",
+			bson.NewDocument(
+				C.String("find", "c"),
+				C.SubDocumentFromElements("filter", C.SubDocumentFromElements("_id", C.Int32("$gte", 4))),
+				C.SubDocumentFromElements("projection", C.Int32("n", 0)),
+			),
+			[]*bson.Document{bson.NewDocument(C.Int32("_id", 4)), bson.NewDocument(C.Int32("_id", 5))},
+		},
+		{"missing collection", bson.NewDocument(C.String("find", "other")), nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reply := c.run(tc.cmd)
+			assertOK(t, reply)
+			assertBatch(t, reply, tc.want, "cursor", "firstBatch")
+			if id := value(t, reply, "cursor", "id").Int64(); id != 0 {
+				t.Errorf("Unexpected cursor id. got %d; want %d", id, 0)
+			}
+		})
+	}
+
+	reply := c.run(bson.NewDocument(C.String("find", "c"), C.String("filter", "a")))
+	assertCode(t, reply, codeTypeMismatch, "TypeMismatch")
+}
+
+func TestCursors(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	seed(t, c)
+
+	reply := c.run(bson.NewDocument(C.String("find", "c"), C.Int32("batchSize", 2)))
+	assertOK(t, reply)
+	assertBatch(t, reply, numbered(1, 2), "cursor", "firstBatch")
+	id := value(t, reply, "cursor", "id").Int64()
+	if id == 0 {
+		t.Fatalf("Expected an open cursor")
+	}
+	if ns := value(t, reply, "cursor", "ns").StringValue(); ns != "test.c" {
+		t.Errorf("Unexpected ns. got %s; want %s", ns, "test.c")
+	}
+
+	reply = c.run(bson.NewDocument(C.Int64("getMore", id), C.String("collection", "other")))
+	assertCode(t, reply, codeCursorNotFound, "CursorNotFound")
+
+	reply = c.run(bson.NewDocument(C.Int64("getMore", id), C.String("collection", "c"), C.Int32("batchSize", 2)))
+	assertOK(t, reply)
+	assertBatch(t, reply, numbered(3, 4), "cursor", "nextBatch")
+	if got := value(t, reply, "cursor", "id").Int64(); got != id {
+		t.Errorf("Unexpected cursor id. got %d; want %d", got, id)
+	}
+
+	// Another connection can continue the cursor.
+	reply = dial(t, s).run(bson.NewDocument(C.Int64("getMore", id), C.String("collection", "c")))
+	assertOK(t, reply)
+	assertBatch(t, reply, numbered(5), "cursor", "nextBatch")
+	if got := value(t, reply, "cursor", "id").Int64(); got != 0 {
+		t.Errorf("Unexpected cursor id. got %d; want %d", got, 0)
+	}
+
+	reply = c.run(bson.NewDocument(C.Int64("getMore", id), C.String("collection", "c")))
+	assertCode(t, reply, codeCursorNotFound, "CursorNotFound")
+
+	reply = c.run(bson.NewDocument(C.Int32("getMore", 1), C.String("collection", "c")))
+	assertCode(t, reply, codeTypeMismatch, "TypeMismatch")
+
+	// A single batch never leaves a cursor open.
+	reply = c.run(bson.NewDocument(C.String("find", "c"), C.Int32("limit", -2)))
+	assertBatch(t, reply, numbered(1, 2), "cursor", "firstBatch")
+	if got := value(t, reply, "cursor", "id").Int64(); got != 0 {
+		t.Errorf("Unexpected cursor id. got %d; want %d", got, 0)
+	}
+
+	reply = c.run(bson.NewDocument(C.String("find", "c"), C.Int32("batchSize", 0)))
+	assertBatch(t, reply, nil, "cursor", "firstBatch")
+	id = value(t, reply, "cursor", "id").Int64()
+
+	reply = c.run(bson.NewDocument(C.String("killCursors", "c"), C.ArrayFromElements("cursors", AC.Int64(id), AC.Int64(id+1))))
+	assertOK(t, reply)
+	if got := value(t, reply, "cursorsKilled", "0").Int64(); got != id {
+		t.Errorf("Unexpected killed cursor. got %d; want %d", got, id)
+	}
+	if got := value(t, reply, "cursorsNotFound", "0").Int64(); got != id+1 {
+		t.Errorf("Unexpected cursor not found. got %d; want %d", got, id+1)
+	}
+
+	reply = c.run(bson.NewDocument(C.Int64("getMore", id), C.String("collection", "c")))
+	assertCode(t, reply, codeCursorNotFound, "CursorNotFound")
+}
+
+func TestInsert(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	seed(t, c)
+
+	testCases := []struct {
+		name    string
+		ordered bool
+		n       int32
+		index   int32
+	}{
+		{"ordered", true, 1, 1},
+		{"unordered", false, 2, 1},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reply := c.run(bson.NewDocument(
+				C.String("insert", "c"),
+				C.ArrayFromElements("documents",
+					AC.DocumentFromElements(C.Int32("_id", int32(10+2*i))),
+					AC.DocumentFromElements(C.Int32("_id", 1)),
+					AC.DocumentFromElements(C.Int32("_id", int32(11+2*i))),
+				),
+				C.Boolean("ordered", tc.ordered),
+			))
+			assertOK(t, reply)
+			assertInt32(t, reply, tc.n, "n")
+			assertInt32(t, reply, tc.index, "writeErrors", "0", "index")
+			assertInt32(t, reply, codeDuplicateKey, "writeErrors", "0", "code")
+			want := `E11000 duplicate key error collection: test.c index: _id_ dup key: {"_id":1}`
+			if got := value(t, reply, "writeErrors", "0", "errmsg").StringValue(); got != want {
+				t.Errorf("Unexpected errmsg. got %s; want %s", got, want)
+			}
+		})
+	}
+
+	n, err := s.Collection("test", "c").Count(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != 8 {
+		t.Errorf("Unexpected count. got %d; want %d", n, 8)
+	}
+
+	reply := c.run(bson.NewDocument(C.String("insert", "c")))
+	assertCode(t, reply, codeFailedToParse, "FailedToParse")
+}
+
+func TestUpdate(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	seed(t, c)
+
+	reply := c.run(bson.NewDocument(
+		C.String("update", "c"),
+		C.ArrayFromElements("updates",
+			AC.DocumentFromElements(
+				C.SubDocumentFromElements("q", C.Int32("n", 1)),
+				C.SubDocumentFromElements("u", C.SubDocumentFromElements("$inc", C.Int32("n", 10))),
+				C.Boolean("multi", true),
+			),
+			AC.DocumentFromElements(
+				C.SubDocumentFromElements("q", C.Int32("_id", 2)),
+				C.SubDocumentFromElements("u", C.SubDocumentFromElements("$set", C.Int32("n", 2))),
+			),
+			AC.DocumentFromElements(
+				C.SubDocumentFromElements("q", C.Int32("_id", 6)),
+				C.SubDocumentFromElements("u", C.SubDocumentFromElements("$set", C.Int32("n", 0))),
+				C.Boolean("upsert", true),
+			),
+			AC.DocumentFromElements(
+				C.SubDocumentFromElements("q", C.Int32("_id", 3)),
+				C.SubDocumentFromElements("u", C.SubDocumentFromElements("$set", C.Int32("_id", 7))),
+			),
+		),
+	))
+	assertOK(t, reply)
+	assertInt32(t, reply, 4, "n")
+	assertInt32(t, reply, 2, "nModified")
+	assertInt32(t, reply, 2, "upserted", "0", "index")
+	assertInt32(t, reply, 6, "upserted", "0", "_id")
+	assertInt32(t, reply, 3, "writeErrors", "0", "index")
+	assertInt32(t, reply, codeImmutableField, "writeErrors", "0", "code")
+
+	reply = c.run(bson.NewDocument(C.String("find", "c"), C.SubDocumentFromElements("sort", C.Int32("_id", 1))))
+	assertBatch(t, reply, []*bson.Document{
+		bson.NewDocument(C.Int32("_id", 1), C.Int32("n", 11)),
+		bson.NewDocument(C.Int32("_id", 2), C.Int32("n", 2)),
+		bson.NewDocument(C.Int32("_id", 3), C.Int32("n", 0)),
+		bson.NewDocument(C.Int32("_id", 4), C.Int32("n", 11)),
+		bson.NewDocument(C.Int32("_id", 5), C.Int32("n", 2)),
+		bson.NewDocument(C.Int32("_id", 6), C.Int32("n", 0)),
+	}, "cursor", "firstBatch")
+
+	reply = c.run(bson.NewDocument(
+		C.String("update", "c"),
+		C.ArrayFromElements("updates", AC.DocumentFromElements(
+			C.SubDocumentFromElements("q", C.Int32("_id", 1)),
+			C.ArrayFromElements("u", AC.DocumentFromElements(C.SubDocumentFromElements("$set", C.Int32("n", 1)))),
+		)),
+	))
+	assertOK(t, reply)
+	assertInt32(t, reply, codeFailedToParse, "writeErrors", "0", "code")
+}
+
+func TestDeleteAndCount(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	seed(t, c)
+
+	reply := c.run(bson.NewDocument(C.String("count", "c"), C.SubDocumentFromElements("query", C.Int32("n", 2))))
+	assertOK(t, reply)
+	assertInt32(t, reply, 2, "n")
+
+	reply = c.run(bson.NewDocument(C.String("count", "c"), C.Int32("skip", 1), C.Int32("limit", 3)))
+	assertInt32(t, reply, 3, "n")
+
+	reply = c.run(bson.NewDocument(C.String("count", "c"), C.Int32("skip", 10)))
+	assertInt32(t, reply, 0, "n")
+
+	reply = c.run(bson.NewDocument(
+		C.String("delete", "c"),
+		C.ArrayFromElements("deletes",
+			AC.DocumentFromElements(C.SubDocumentFromElements("q", C.Int32("n", 1)), C.Int32("limit", 1)),
+			AC.DocumentFromElements(C.SubDocumentFromElements("q", C.Int32("n", 2)), C.Int32("limit", 0)),
+		),
+	))
+	assertOK(t, reply)
+	assertInt32(t, reply, 3, "n")
+
+	reply = c.run(bson.NewDocument(C.String("find", "c")))
+	assertBatch(t, reply, numbered(3, 4), "cursor", "firstBatch")
+
+	reply = c.run(bson.NewDocument(
+		C.String("delete", "c"),
+		C.ArrayFromElements("deletes", AC.DocumentFromElements(C.SubDocumentFromElements("q"), C.Int32("limit", 2))),
+	))
+	assertInt32(t, reply, 0, "n")
+	assertInt32(t, reply, codeFailedToParse, "writeErrors", "0", "code")
+}
+
+func TestAggregate(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	seed(t, c)
+
+	reply := c.run(bson.NewDocument(
+		C.String("aggregate", "c"),
+		C.ArrayFromElements("pipeline",
+			AC.DocumentFromElements(C.SubDocumentFromElements("$group",
+				C.String("_id", "$n"),
+				C.SubDocumentFromElements("count", C.Int32("$sum", 1)),
+			)),
+			AC.DocumentFromElements(C.SubDocumentFromElements("$sort", C.Int32("_id", 1))),
+		),
+		C.SubDocumentFromElements("cursor", C.Int32("batchSize", 2)),
+	))
+	assertOK(t, reply)
+	assertBatch(t, reply, []*bson.Document{
+		bson.NewDocument(C.Int32("_id", 0), C.Int32("count", 1)),
+		bson.NewDocument(C.Int32("_id", 1), C.Int32("count", 2)),
+	}, "cursor", "firstBatch")
+	id := value(t, reply, "cursor", "id").Int64()
+
+	reply = c.run(bson.NewDocument(C.Int64("getMore", id), C.String("collection", "c")))
+	assertBatch(t, reply, []*bson.Document{bson.NewDocument(C.Int32("_id", 2), C.Int32("count", 2))}, "cursor", "nextBatch")
+
+	reply = c.run(bson.NewDocument(
+		C.String("aggregate", "c"),
+		C.ArrayFromElements("pipeline", AC.DocumentFromElements(C.Int32("$bogus", 1))),
+		C.SubDocumentFromElements("cursor"),
+	))
+	assertCode(t, reply, codeBadValue, "BadValue")
+}
+
+func TestErrors(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+
+	reply := c.run(bson.NewDocument(C.Int32("frobnicate", 1)))
+	assertCode(t, reply, codeCommandNotFound, "CommandNotFound")
+
+	reply = c.run(bson.NewDocument(C.Int32("find", 1)))
+	assertCode(t, reply, codeInvalidNamespace, "InvalidNamespace")
+
+	flags, reply := c.query("test.c", bson.NewDocument(C.Int32("a", 1)))
+	if flags&queryFailure == 0 {
+		t.Errorf("Expected the QueryFailure flag to be set. got %d", flags)
+	}
+	if _, err := reply.Lookup("$err"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// The connection remains usable after failed commands.
+	assertOK(t, c.run(bson.NewDocument(C.Int32("ping", 1))))
+}
+
+func TestMoreToCome(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+
+	c.write(flagMoreToCome|flagChecksumPresent, bson.NewDocument(
+		C.String("insert", "c"),
+		C.ArrayFromElements("documents", AC.DocumentFromElements(C.Int32("_id", 1))),
+	))
+
+	// The reply read by run must be to the count rather than to the insert.
+	reply := c.run(bson.NewDocument(C.String("count", "c")))
+	assertOK(t, reply)
+	assertInt32(t, reply, 1, "n")
+}
+
+func TestClose(t *testing.T) {
+	s := listen(t)
+	c := dial(t, s)
+	assertOK(t, c.run(bson.NewDocument(C.Int32("ping", 1))))
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := readMessage(c.conn); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+	if _, err := net.Dial("tcp", s.Addr()); err == nil {
+		t.Errorf("Expected the listener to be closed")
+	}
+}
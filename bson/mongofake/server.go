@@ -0,0 +1,286 @@
+// Package mongofake provides a server which speaks the MongoDB wire protocol and stores its data in
+// memory, intended for testing clients without a running MongoDB deployment.
+//
+// The server accepts commands sent with OP_MSG, including document sequences, and with the legacy
+// OP_QUERY against the $cmd collection of a database. It handles the handshake commands hello,
+// isMaster, buildInfo, and ping, and the data commands insert, find, getMore, killCursors, update,
+// delete, count, and aggregate. Each collection is a collection.Collection, created the first time
+// it is used, so filters, updates, and pipelines have the meaning given by the query, update, and
+// pipeline packages. Other commands fail with the CommandNotFound error code.
+//
+// Every reply is built with builder.DocumentBuilder.
+package mongofake
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/builder"
+	"github.com/skriptble/wilson/bson/collection"
+)
+
+// ErrServerClosed is returned by Serve after Close is called.
+var ErrServerClosed = errors.New("mongofake: server closed")
+
+// Server is a fake MongoDB server. A Server is safe for concurrent use.
+type Server struct {
+	l net.Listener
+
+	mu          sync.Mutex
+	collections map[string]*collection.Collection
+	cursors     map[int64]*cursor
+	lastCursor  int64
+	conns       map[net.Conn]struct{}
+	lastConn    int32
+	lastRequest int32
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// cursor holds the documents of a find or aggregate command which have not been returned yet.
+type cursor struct {
+	ns   string
+	docs []bson.Reader
+}
+
+// NewServer returns a server with no data which is not yet serving.
+func NewServer() *Server {
+	return &Server{
+		collections: make(map[string]*collection.Collection),
+		cursors:     make(map[int64]*cursor),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen returns a new server listening on the TCP address addr, such as "127.0.0.1:0", which
+// serves connections in a separate goroutine until it is closed.
+func Listen(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewServer()
+	s.l = l
+	go s.Serve(l)
+
+	return s, nil
+}
+
+// Serve accepts connections on l and serves each in a separate goroutine. It blocks until l fails
+// or the server is closed, and then closes l.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.l = l
+	s.mu.Unlock()
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.lastConn++
+		id := s.lastConn
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn, id)
+	}
+}
+
+// Addr returns the address the server is listening on, or an empty string if it is not serving.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.l == nil {
+		return ""
+	}
+	return s.l.Addr().String()
+}
+
+// Close stops the server, closes its connections, and waits for them to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.l != nil {
+		err = s.l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Collection returns the collection name of the database db, creating it if it does not exist. It
+// can be used to seed or inspect the data of the server.
+func (s *Server) Collection(db, name string) *collection.Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ns := db + "." + name
+	c, ok := s.collections[ns]
+	if !ok {
+		c = collection.New()
+		s.collections[ns] = c
+	}
+
+	return c
+}
+
+// serveConn reads requests from conn and writes their replies until conn fails or a request
+// cannot be handled.
+func (s *Server) serveConn(conn net.Conn, id int32) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	for {
+		m, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+
+		reply, err := s.handle(m, id)
+		if err != nil {
+			return
+		}
+		if reply == nil {
+			continue
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the reply to the message m received on the connection id, or nil if m does not
+// expect a reply. An error is returned if the connection should be closed.
+func (s *Server) handle(m message, id int32) ([]byte, error) {
+	switch m.opCode {
+	case opMsg:
+		msg, err := parseMsg(m.body)
+		if err != nil {
+			return nil, err
+		}
+		cmd, err := msg.command()
+		if err != nil {
+			return nil, err
+		}
+
+		var db string
+		if elem, err := cmd.Lookup("$db"); err == nil && elem != nil && elem.Value().Type() == bson.TypeString {
+			db = elem.Value().StringValue()
+		}
+		doc := s.run(request{db: db, cmd: cmd, conn: id})
+		if msg.flags&flagMoreToCome != 0 {
+			return nil, nil
+		}
+		return appendMsg(nil, s.requestID(), m.requestID, doc), nil
+	case opQuery:
+		q, err := parseQuery(m.body)
+		if err != nil {
+			return nil, err
+		}
+
+		db := strings.TrimSuffix(q.collection, ".$cmd")
+		if db == q.collection {
+			doc, err := build(builder.C.String("$err", "OP_QUERY is only supported for commands"), builder.C.Int32("code", codeCommandNotFound))
+			if err != nil {
+				return nil, err
+			}
+			return appendReply(nil, s.requestID(), m.requestID, queryFailure, doc), nil
+		}
+
+		doc := s.run(request{db: db, cmd: unwrapQuery(q.query), conn: id})
+		return appendReply(nil, s.requestID(), m.requestID, 0, doc), nil
+	default:
+		return nil, errors.New("mongofake: unsupported opcode")
+	}
+}
+
+func (s *Server) requestID() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastRequest++
+	return s.lastRequest
+}
+
+// command returns the body of m with each document sequence added as an array field.
+func (m msg) command() (bson.Reader, error) {
+	if len(m.sequences) == 0 {
+		return m.body, nil
+	}
+
+	elems := []builder.Elementer{builder.C.ElementsFromReader(m.body)}
+	for _, seq := range m.sequences {
+		docs := make([]builder.ArrayElementer, len(seq.docs))
+		for i, doc := range seq.docs {
+			docs[i] = builder.AC.SubDocumentWithElements(builder.C.ElementsFromReader(doc))
+		}
+		elems = append(elems, builder.C.ArrayWithElements(seq.identifier, docs...))
+	}
+
+	return build(elems...)
+}
+
+// unwrapQuery returns the command of a legacy query, which may be wrapped in a $query or query
+// field together with modifiers such as $readPreference.
+func unwrapQuery(q bson.Reader) bson.Reader {
+	elem, err := q.ElementAt(0)
+	if err != nil || (elem.Key() != "$query" && elem.Key() != "query") {
+		return q
+	}
+	if elem.Value().Type() != bson.TypeEmbeddedDocument {
+		return q
+	}
+
+	return elem.Value().ReaderDocument()
+}
+
+// build returns the document made of elems.
+func build(elems ...builder.Elementer) ([]byte, error) {
+	db := builder.NewDocumentBuilder().Append(elems...)
+	b := make([]byte, db.RequiredBytes())
+	if _, err := db.WriteDocument(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
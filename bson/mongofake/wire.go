@@ -0,0 +1,264 @@
+package mongofake
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// The opcodes of the messages handled by the server.
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+// The flags of OP_MSG.
+const (
+	flagChecksumPresent = 1 << 0
+	flagMoreToCome      = 1 << 1
+)
+
+// queryFailure is the OP_REPLY flag set when a query fails.
+const queryFailure = 1 << 1
+
+const (
+	headerSize = 16
+	// maxMessageSize is the largest message the server accepts, as reported by hello.
+	maxMessageSize = 48000000
+)
+
+// errMalformed is returned when a message cannot be parsed.
+var errMalformed = errors.New("mongofake: malformed message")
+
+// header is the header of a wire protocol message.
+type header struct {
+	length     int32
+	requestID  int32
+	responseTo int32
+	opCode     int32
+}
+
+// message is a request read from a connection.
+type message struct {
+	header
+	// body is the message without its header.
+	body []byte
+}
+
+// readMessage reads a message from r.
+func readMessage(r io.Reader) (message, error) {
+	var b [headerSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return message{}, err
+	}
+
+	m := message{header: header{
+		length:     int32(binary.LittleEndian.Uint32(b[0:])),
+		requestID:  int32(binary.LittleEndian.Uint32(b[4:])),
+		responseTo: int32(binary.LittleEndian.Uint32(b[8:])),
+		opCode:     int32(binary.LittleEndian.Uint32(b[12:])),
+	}}
+	if m.length < headerSize || m.length > maxMessageSize {
+		return message{}, fmt.Errorf("mongofake: invalid message length %d", m.length)
+	}
+
+	m.body = make([]byte, m.length-headerSize)
+	if _, err := io.ReadFull(r, m.body); err != nil {
+		return message{}, err
+	}
+
+	return m, nil
+}
+
+// appendHeader appends a header to dst with a length of zero, which is set by finishMessage.
+func appendHeader(dst []byte, requestID, responseTo, opCode int32) []byte {
+	dst = appendInt32(dst, 0)
+	dst = appendInt32(dst, requestID)
+	dst = appendInt32(dst, responseTo)
+	return appendInt32(dst, opCode)
+}
+
+// finishMessage sets the length in the header of the message b.
+func finishMessage(b []byte) []byte {
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func appendInt32(dst []byte, i int32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(i))
+	return append(dst, b[:]...)
+}
+
+func appendInt64(dst []byte, i int64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(i))
+	return append(dst, b[:]...)
+}
+
+// msg is the content of an OP_MSG.
+type msg struct {
+	flags uint32
+	// body is the document of the section of kind 0.
+	body bson.Reader
+	// sequences holds the documents of the sections of kind 1 by identifier.
+	sequences []sequence
+}
+
+// sequence is a section of kind 1, a sequence of documents which is a field of the command.
+type sequence struct {
+	identifier string
+	docs       []bson.Reader
+}
+
+// parseMsg parses the body of an OP_MSG.
+func parseMsg(b []byte) (msg, error) {
+	var m msg
+	if len(b) < 4 {
+		return m, errMalformed
+	}
+	m.flags = binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	if m.flags&flagChecksumPresent != 0 {
+		if len(b) < 4 {
+			return m, errMalformed
+		}
+		b = b[:len(b)-4]
+	}
+
+	for len(b) > 0 {
+		kind := b[0]
+		b = b[1:]
+
+		switch kind {
+		case 0:
+			doc, rest, err := readDocument(b)
+			if err != nil {
+				return m, err
+			}
+			if m.body != nil {
+				return m, errMalformed
+			}
+			m.body, b = doc, rest
+		case 1:
+			if len(b) < 4 {
+				return m, errMalformed
+			}
+			size := int(binary.LittleEndian.Uint32(b))
+			if size < 4 || size > len(b) {
+				return m, errMalformed
+			}
+			section := b[4:size]
+			b = b[size:]
+
+			id, section, err := readCString(section)
+			if err != nil {
+				return m, err
+			}
+			seq := sequence{identifier: id}
+			for len(section) > 0 {
+				var doc bson.Reader
+				doc, section, err = readDocument(section)
+				if err != nil {
+					return m, err
+				}
+				seq.docs = append(seq.docs, doc)
+			}
+			m.sequences = append(m.sequences, seq)
+		default:
+			return m, fmt.Errorf("mongofake: unknown OP_MSG section kind %d", kind)
+		}
+	}
+	if m.body == nil {
+		return m, errMalformed
+	}
+
+	return m, nil
+}
+
+// appendMsg appends an OP_MSG with the single document doc to dst.
+func appendMsg(dst []byte, requestID, responseTo int32, doc []byte) []byte {
+	dst = appendHeader(dst, requestID, responseTo, opMsg)
+	dst = appendInt32(dst, 0)
+	dst = append(dst, 0)
+	return finishMessage(append(dst, doc...))
+}
+
+// query is the content of an OP_QUERY.
+type query struct {
+	flags          int32
+	collection     string
+	numberToSkip   int32
+	numberToReturn int32
+	query          bson.Reader
+}
+
+// parseQuery parses the body of an OP_QUERY. The optional field selector is ignored.
+func parseQuery(b []byte) (query, error) {
+	var q query
+	if len(b) < 4 {
+		return q, errMalformed
+	}
+	q.flags = int32(binary.LittleEndian.Uint32(b))
+
+	var err error
+	q.collection, b, err = readCString(b[4:])
+	if err != nil {
+		return q, err
+	}
+	if len(b) < 8 {
+		return q, errMalformed
+	}
+	q.numberToSkip = int32(binary.LittleEndian.Uint32(b))
+	q.numberToReturn = int32(binary.LittleEndian.Uint32(b[4:]))
+
+	q.query, _, err = readDocument(b[8:])
+	return q, err
+}
+
+// appendReply appends an OP_REPLY containing docs to dst.
+func appendReply(dst []byte, requestID, responseTo, flags int32, docs ...[]byte) []byte {
+	dst = appendHeader(dst, requestID, responseTo, opReply)
+	dst = appendInt32(dst, flags)
+	dst = appendInt64(dst, 0)
+	dst = appendInt32(dst, 0)
+	dst = appendInt32(dst, int32(len(docs)))
+	for _, doc := range docs {
+		dst = append(dst, doc...)
+	}
+
+	return finishMessage(dst)
+}
+
+// readDocument reads a valid BSON document from the start of b and returns it and the rest of b.
+func readDocument(b []byte) (bson.Reader, []byte, error) {
+	if len(b) < 5 {
+		return nil, nil, errMalformed
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	if size < 5 || size > len(b) {
+		return nil, nil, errMalformed
+	}
+
+	doc := bson.Reader(b[:size])
+	if _, err := doc.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return doc, b[size:], nil
+}
+
+// readCString reads a null-terminated string from the start of b and returns it and the rest of b.
+func readCString(b []byte) (string, []byte, error) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:], nil
+		}
+	}
+
+	return "", nil, errMalformed
+}
@@ -0,0 +1,684 @@
+package oplog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/collection"
+	"github.com/skriptble/wilson/bson/update"
+)
+
+// ErrNotFound is returned when the document updated or deleted by an entry does not exist.
+var ErrNotFound = errors.New("oplog: document not found")
+
+// ApplyDocument returns the document which results from replaying the insert, update, delete, or
+// no-op entry e against doc, which is not modified. A delete returns nil, and an update of a nil
+// document returns ErrNotFound.
+func ApplyDocument(doc *bson.Document, e Entry) (*bson.Document, error) {
+	switch e.Op {
+	case OpInsert:
+		return readDocument(e.Object)
+	case OpUpdate:
+		if doc == nil {
+			return nil, ErrNotFound
+		}
+		return applyUpdate(doc, e.Object)
+	case OpDelete:
+		return nil, nil
+	case OpNoop:
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("oplog: %q entries cannot be applied to a document", e.Op)
+	}
+}
+
+// readDocument returns a document holding a copy of r.
+func readDocument(r bson.Reader) (*bson.Document, error) {
+	return bson.ReadDocument(append([]byte(nil), r...))
+}
+
+// applyUpdate returns doc updated by the o field of an update entry, which is either an update
+// document or a $v: 2 diff.
+func applyUpdate(doc *bson.Document, o bson.Reader) (*bson.Document, error) {
+	b, err := doc.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	version := int64(1)
+	elem, err := o.Lookup("$v")
+	if err != nil {
+		return nil, err
+	}
+	if elem != nil {
+		if version, err = integer("o.$v", elem.Value()); err != nil {
+			return nil, err
+		}
+	}
+
+	switch version {
+	case 1:
+		u, err := update.CompileReader(without(o, "$v"))
+		if err != nil {
+			return nil, err
+		}
+		d, err := bson.ReadDocument(b)
+		if err != nil {
+			return nil, err
+		}
+		return d, u.Apply(d)
+	case 2:
+		elem, err := o.Lookup("diff")
+		if err != nil {
+			return nil, err
+		}
+		if elem == nil {
+			return nil, DecodeError{Field: "o.diff", Message: "missing"}
+		}
+		diff, err := document("o.diff", elem.Value())
+		if err != nil {
+			return nil, err
+		}
+		return applyDocumentDiff(b, diff, "")
+	default:
+		return nil, DecodeError{Field: "o.$v", Message: fmt.Sprintf("unsupported update version %d", version)}
+	}
+}
+
+// without returns r without its field key.
+func without(r bson.Reader, key string) bson.Reader {
+	elem, err := r.Lookup(key)
+	if err != nil || elem == nil {
+		return r
+	}
+
+	d := bson.NewDocument()
+	itr, err := r.Iterator()
+	if err != nil {
+		return r
+	}
+	for itr.Next() {
+		if elem := itr.Element(); elem.Key() != key {
+			d.Append(elem.Clone())
+		}
+	}
+	b, err := d.MarshalBSON()
+	if err != nil {
+		return r
+	}
+	return b
+}
+
+// applyDocumentDiff returns the document doc, at the dotted path within the updated document,
+// changed by a $v: 2 diff. A diff holds the fields to delete in d, the fields to update in u, the
+// fields to insert in i, and a subdiff for each changed embedded document or array in a field
+// named s followed by the name of the field.
+func applyDocumentDiff(doc, diff bson.Reader, path string) (*bson.Document, error) {
+	deletes := make(map[string]bool)
+	updates := make(map[string]*bson.Value)
+	var updated, inserts []*bson.Element
+	subdiffs := make(map[string]bson.Reader)
+
+	err := decode(diff, func(key string, v *bson.Value) error {
+		switch {
+		case key == "d":
+			return elements(diffPath(path, key), v, func(elem *bson.Element) {
+				deletes[elem.Key()] = true
+			})
+		case key == "u":
+			return elements(diffPath(path, key), v, func(elem *bson.Element) {
+				updates[elem.Key()] = elem.Value()
+				updated = append(updated, elem)
+			})
+		case key == "i":
+			return elements(diffPath(path, key), v, func(elem *bson.Element) {
+				inserts = append(inserts, elem)
+			})
+		case len(key) > 1 && key[0] == 's':
+			sub, err := document(diffPath(path, key), v)
+			subdiffs[key[1:]] = sub
+			return err
+		default:
+			return DecodeError{Field: diffPath(path, key), Message: "unknown diff field"}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := bson.NewDocument()
+	itr, err := doc.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key := elem.Key()
+
+		if v, ok := updates[key]; ok {
+			out.Append(bson.C.Value(key, v))
+			delete(updates, key)
+			continue
+		}
+		if sub, ok := subdiffs[key]; ok {
+			v, err := applySubdiff(elem.Value(), sub, joinPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			out.Append(bson.C.Value(key, v))
+			delete(subdiffs, key)
+			continue
+		}
+		if !deletes[key] {
+			out.Append(elem)
+		}
+	}
+	if err := itr.Err(); err != nil {
+		return nil, err
+	}
+
+	for key := range subdiffs {
+		return nil, fmt.Errorf("oplog: cannot apply a diff to the missing field %q", joinPath(path, key))
+	}
+	// Updated fields are usually present, but are added like inserted fields if they are not.
+	for _, elem := range updated {
+		if _, ok := updates[elem.Key()]; ok {
+			out.Append(bson.C.Value(elem.Key(), elem.Value()))
+		}
+	}
+	for _, elem := range inserts {
+		out.Set(bson.C.Value(elem.Key(), elem.Value()))
+	}
+
+	return out, nil
+}
+
+// applySubdiff returns the value v, at path, changed by a subdiff, which is an array diff if its
+// field a is true and a document diff otherwise.
+func applySubdiff(v *bson.Value, sub bson.Reader, path string) (*bson.Value, error) {
+	elem, err := sub.Lookup("a")
+	if err != nil {
+		return nil, err
+	}
+
+	if elem == nil {
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil, fmt.Errorf("oplog: cannot apply a document diff to the %s at %q", typeName(v), path)
+		}
+		d, err := applyDocumentDiff(v.ReaderDocument(), sub, path)
+		if err != nil {
+			return nil, err
+		}
+		return bson.AC.Document(d), nil
+	}
+
+	if v.Type() != bson.TypeArray {
+		return nil, fmt.Errorf("oplog: cannot apply an array diff to the %s at %q", typeName(v), path)
+	}
+	values, err := applyArrayDiff(v.ReaderArray(), sub, path)
+	if err != nil {
+		return nil, err
+	}
+	return bson.AC.ArrayFromValues(values...), nil
+}
+
+// applyArrayDiff returns the elements of the array arr, at path, changed by an array diff. An array
+// diff holds the new length of the array in l if it was resized, and the new value of each
+// updated element in a field named u followed by its index, or a subdiff in a field named s
+// followed by its index.
+func applyArrayDiff(arr, diff bson.Reader, path string) ([]*bson.Value, error) {
+	length := int64(-1)
+	updates := make(map[int]*bson.Value)
+	subdiffs := make(map[int]bson.Reader)
+
+	err := decode(diff, func(key string, v *bson.Value) error {
+		switch {
+		case key == "a":
+			return nil
+		case key == "l":
+			n, err := integer(diffPath(path, key), v)
+			if err == nil && n < 0 {
+				err = DecodeError{Field: diffPath(path, key), Message: "must not be negative"}
+			}
+			length = n
+			return err
+		case len(key) > 1 && (key[0] == 'u' || key[0] == 's'):
+			i, err := strconv.Atoi(key[1:])
+			if err != nil || i < 0 {
+				return DecodeError{Field: diffPath(path, key), Message: "invalid array index"}
+			}
+			if key[0] == 'u' {
+				updates[i] = v
+				return nil
+			}
+			subdiffs[i], err = document(diffPath(path, key), v)
+			return err
+		default:
+			return DecodeError{Field: diffPath(path, key), Message: "unknown diff field"}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*bson.Value
+	itr, err := arr.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	for itr.Next() {
+		values = append(values, itr.Element().Clone().Value())
+	}
+	if err := itr.Err(); err != nil {
+		return nil, err
+	}
+
+	if length >= 0 {
+		values, err = resize(values, length)
+		if err != nil {
+			return nil, err
+		}
+	}
+	end := len(values)
+	for i := range updates {
+		// As for bson.Document.SetPath, an index may be at most bson.MaxArrayPadding past the end.
+		if i-len(values) > bson.MaxArrayPadding {
+			return nil, bson.ErrPaddingLimit
+		}
+		if i >= end {
+			end = i + 1
+		}
+	}
+	for len(values) < end {
+		values = append(values, bson.AC.Null())
+	}
+	for i, v := range updates {
+		values[i] = bson.C.Value("", v).Value()
+	}
+	for i, sub := range subdiffs {
+		if i >= len(values) {
+			return nil, fmt.Errorf("oplog: cannot apply a diff to the missing element %q", joinPath(path, strconv.Itoa(i)))
+		}
+		v, err := applySubdiff(values[i], sub, joinPath(path, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+// resize truncates values to n elements or pads it with nulls. It returns bson.ErrPaddingLimit if
+// more than bson.MaxArrayPadding nulls would be needed.
+func resize(values []*bson.Value, n int64) ([]*bson.Value, error) {
+	if n <= int64(len(values)) {
+		return values[:n], nil
+	}
+	if n-int64(len(values)) > bson.MaxArrayPadding {
+		return nil, bson.ErrPaddingLimit
+	}
+	for int64(len(values)) < n {
+		values = append(values, bson.AC.Null())
+	}
+	return values, nil
+}
+
+// elements calls f with each element of the document v.
+func elements(key string, v *bson.Value, f func(*bson.Element)) error {
+	r, err := document(key, v)
+	if err != nil {
+		return err
+	}
+	itr, err := r.Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		f(itr.Element().Clone())
+	}
+	return itr.Err()
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// diffPath returns the path of the field key of the diff of the field at path, for errors.
+func diffPath(path, key string) string {
+	return joinPath(joinPath("o.diff", path), key)
+}
+
+func typeName(v *bson.Value) string {
+	if v.Type() == bson.TypeEmbeddedDocument {
+		return "document"
+	}
+	return strings.ToLower(v.Type().String())
+}
+
+// ApplyCollection replays the insert, update, delete, or no-op entry e against c. An insert
+// replaces any document with the same _id, as when a secondary replays the oplog, so replaying an
+// insert more than once has no further effect. An update or delete of a document which does not
+// exist returns ErrNotFound, unless the update is an upsert.
+func ApplyCollection(c *collection.Collection, e Entry) error {
+	switch e.Op {
+	case OpInsert:
+		doc, err := readDocument(e.Object)
+		if err != nil {
+			return err
+		}
+		filter, err := idDocument(e.Object)
+		if err != nil {
+			return err
+		}
+		_, err = c.Update(filter, doc, true, false)
+		return err
+	case OpUpdate:
+		filter, err := readDocument(e.Object2)
+		if err != nil {
+			return err
+		}
+		docs, err := c.Find(filter, nil, 0, 1, nil)
+		if err != nil {
+			return err
+		}
+
+		var doc *bson.Document
+		switch {
+		case len(docs) > 0:
+			doc, err = readDocument(docs[0])
+		case e.Upsert:
+			doc, err = idDocument(e.Object2)
+		default:
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		updated, err := applyUpdate(doc, e.Object)
+		if err != nil {
+			return err
+		}
+		b, err := doc.MarshalBSON()
+		if err != nil {
+			return err
+		}
+		if filter, err = idDocument(b); err != nil {
+			return err
+		}
+		_, err = c.Update(filter, updated, true, false)
+		return err
+	case OpDelete:
+		filter, err := readDocument(e.Object)
+		if err != nil {
+			return err
+		}
+		n, err := c.Delete(filter, false)
+		if err == nil && n == 0 {
+			err = ErrNotFound
+		}
+		return err
+	case OpNoop:
+		return nil
+	default:
+		return fmt.Errorf("oplog: %q entries cannot be applied to a collection", e.Op)
+	}
+}
+
+// idDocument returns a document holding only the _id of doc, which is also a filter matching it.
+func idDocument(doc bson.Reader) (*bson.Document, error) {
+	elem, err := doc.Lookup("_id")
+	if err != nil {
+		return nil, err
+	}
+	if elem == nil {
+		return nil, DecodeError{Field: "_id", Message: "missing"}
+	}
+
+	return bson.NewDocument(bson.C.Value("_id", elem.Value())), nil
+}
+
+// Applier replays oplog entries against in-memory collections, which are created when an entry
+// first refers to them. An Applier is not safe for concurrent use, but its collections are.
+type Applier struct {
+	collections map[string]*collection.Collection
+}
+
+// NewApplier returns an Applier with no collections.
+func NewApplier() *Applier {
+	return &Applier{collections: make(map[string]*collection.Collection)}
+}
+
+// Collection returns the collection with the namespace ns, such as "db.coll", creating it if it
+// does not exist.
+func (a *Applier) Collection(ns string) *collection.Collection {
+	c, ok := a.collections[ns]
+	if !ok {
+		c = collection.New()
+		a.collections[ns] = c
+	}
+	return c
+}
+
+// Namespaces returns the namespaces of the collections in sorted order.
+func (a *Applier) Namespaces() []string {
+	namespaces := make([]string, 0, len(a.collections))
+	for ns := range a.collections {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// Apply replays the entry e. Insert, update, and delete entries are replayed with ApplyCollection
+// against the collection of their namespace. Command entries are replayed for the commands create,
+// drop, dropDatabase, renameCollection, createIndexes, commitIndexBuild, dropIndexes, and
+// applyOps, and ignored for startIndexBuild, abortIndexBuild, and collMod, which do not affect an
+// in-memory collection. Other commands return an error.
+func (a *Applier) Apply(e Entry) error {
+	switch e.Op {
+	case OpCommand:
+		return a.command(e)
+	case OpNoop:
+		return nil
+	default:
+		return ApplyCollection(a.Collection(e.Namespace), e)
+	}
+}
+
+func (a *Applier) command(e Entry) error {
+	db := strings.TrimSuffix(e.Namespace, ".$cmd")
+	if db == e.Namespace || db == "" {
+		return DecodeError{Field: "ns", Message: fmt.Sprintf("invalid namespace %q for a command", e.Namespace)}
+	}
+	elem, err := e.Object.ElementAt(0)
+	if err != nil {
+		return DecodeError{Field: "o", Message: "empty command"}
+	}
+	name, arg := elem.Key(), elem.Value()
+
+	switch name {
+	case "create":
+		coll, err := str("o.create", arg)
+		if err != nil {
+			return err
+		}
+		a.Collection(db + "." + coll)
+	case "drop":
+		coll, err := str("o.drop", arg)
+		if err != nil {
+			return err
+		}
+		delete(a.collections, db+"."+coll)
+	case "dropDatabase":
+		for ns := range a.collections {
+			if strings.HasPrefix(ns, db+".") {
+				delete(a.collections, ns)
+			}
+		}
+	case "renameCollection":
+		return a.rename(e.Object)
+	case "createIndexes":
+		coll, err := str("o.createIndexes", arg)
+		if err != nil {
+			return err
+		}
+		return createIndex(a.Collection(db+"."+coll), e.Object, "o")
+	case "commitIndexBuild":
+		coll, err := str("o.commitIndexBuild", arg)
+		if err != nil {
+			return err
+		}
+		c := a.Collection(db + "." + coll)
+		v, err := e.Object.Lookup("indexes")
+		if err != nil || v == nil {
+			return DecodeError{Field: "o.indexes", Message: "missing"}
+		}
+		return eachElement("o.indexes", v.Value(), func(v *bson.Value) error {
+			spec, err := document("o.indexes", v)
+			if err != nil {
+				return err
+			}
+			return createIndex(c, spec, "o.indexes")
+		})
+	case "dropIndexes":
+		coll, err := str("o.dropIndexes", arg)
+		if err != nil {
+			return err
+		}
+		return dropIndex(a.Collection(db+"."+coll), e.Object)
+	case "applyOps":
+		return eachElement("o.applyOps", arg, func(v *bson.Value) error {
+			r, err := document("o.applyOps", v)
+			if err != nil {
+				return err
+			}
+			op, err := DecodeEntry(r)
+			if err != nil {
+				return err
+			}
+			return a.Apply(op)
+		})
+	case "startIndexBuild", "abortIndexBuild", "collMod":
+	default:
+		return fmt.Errorf("oplog: unsupported command %q", name)
+	}
+
+	return nil
+}
+
+// rename replays a renameCollection command, whose namespaces are complete.
+func (a *Applier) rename(cmd bson.Reader) error {
+	var from, to string
+	var dropTarget bool
+	err := decode(cmd, func(key string, v *bson.Value) error {
+		var err error
+		switch key {
+		case "renameCollection":
+			from, err = str("o.renameCollection", v)
+		case "to":
+			to, err = str("o.to", v)
+		case "dropTarget":
+			// dropTarget is the UUID of the dropped collection since 4.2 and a boolean before.
+			dropTarget = v.Type() != bson.TypeBoolean || v.Boolean()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		return DecodeError{Field: "o.to", Message: "missing"}
+	}
+
+	c, ok := a.collections[from]
+	if !ok {
+		return fmt.Errorf("oplog: cannot rename the missing collection %q", from)
+	}
+	if _, exists := a.collections[to]; exists && !dropTarget {
+		return fmt.Errorf("oplog: cannot rename %q to the existing collection %q", from, to)
+	}
+	delete(a.collections, from)
+	a.collections[to] = c
+
+	return nil
+}
+
+// createIndex creates the index described by spec, which has the fields key, name, and unique of an
+// index specification, at path within the entry.
+func createIndex(c *collection.Collection, spec bson.Reader, path string) error {
+	var idx collection.Index
+	err := decode(spec, func(key string, v *bson.Value) error {
+		var err error
+		switch key {
+		case "key":
+			var r bson.Reader
+			if r, err = document(path+".key", v); err == nil {
+				idx.Keys, err = readDocument(r)
+			}
+		case "name":
+			idx.Name, err = str(path+".name", v)
+		case "unique":
+			idx.Unique, err = boolean(path+".unique", v)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if idx.Keys == nil {
+		return DecodeError{Field: path + ".key", Message: "missing"}
+	}
+
+	_, err = c.CreateIndex(idx)
+	return err
+}
+
+// dropIndex replays a dropIndexes command, whose index field is the name of an index, an array of
+// names, or "*" for every index except the one on _id.
+func dropIndex(c *collection.Collection, cmd bson.Reader) error {
+	elem, err := cmd.Lookup("index")
+	if err != nil || elem == nil {
+		return DecodeError{Field: "o.index", Message: "missing"}
+	}
+
+	var names []string
+	switch v := elem.Value(); v.Type() {
+	case bson.TypeString:
+		names = append(names, v.StringValue())
+	case bson.TypeArray:
+		err := eachElement("o.index", v, func(v *bson.Value) error {
+			name, err := str("o.index", v)
+			names = append(names, name)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	default:
+		return typeError("o.index", v, "a string or an array")
+	}
+
+	if len(names) == 1 && names[0] == "*" {
+		names = names[:0]
+		for _, idx := range c.Indexes()[1:] {
+			names = append(names, idx.Name)
+		}
+	}
+	for _, name := range names {
+		if err := c.DropIndex(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package oplog
+
+import (
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// Namespace is the namespace of a change event.
+type Namespace struct {
+	Database   string
+	Collection string
+}
+
+// ChangeEvent is an event of a change stream.
+type ChangeEvent struct {
+	// ID is the resume token of the event, from the _id field.
+	ID bson.Reader
+	// OperationType is the kind of event, such as "insert", "update", "replace", "delete",
+	// "drop", "rename", "dropDatabase", or "invalidate".
+	OperationType string
+	ClusterTime   Timestamp
+	WallTime      time.Time
+	// Namespace is the namespace of the event, from the ns field.
+	Namespace Namespace
+	// To is the new namespace of a rename event.
+	To Namespace
+	// DocumentKey holds the _id and shard key of the changed document.
+	DocumentKey              bson.Reader
+	FullDocument             bson.Reader
+	FullDocumentBeforeChange bson.Reader
+	// UpdateDescription describes the changes of an update event, or is nil for other events.
+	UpdateDescription *UpdateDescription
+	TxnNumber         int64
+	LSID              bson.Reader
+	// Raw is the whole event, from which the fields without a struct field can be read.
+	Raw bson.Reader
+}
+
+// UpdateDescription describes the fields changed by an update event.
+type UpdateDescription struct {
+	// UpdatedFields holds the new value of each updated field by its dotted path.
+	UpdatedFields   bson.Reader
+	RemovedFields   []string
+	TruncatedArrays []TruncatedArray
+}
+
+// TruncatedArray describes an array which was shortened by an update.
+type TruncatedArray struct {
+	Field   string
+	NewSize int32
+}
+
+// DecodeChangeEvent decodes the change event r. The documents of the event share the bytes of r.
+func DecodeChangeEvent(r bson.Reader) (ChangeEvent, error) {
+	ce := ChangeEvent{Raw: r}
+	err := decode(r, func(key string, v *bson.Value) error {
+		var err error
+		switch key {
+		case "_id":
+			ce.ID, err = document(key, v)
+		case "operationType":
+			ce.OperationType, err = str(key, v)
+		case "clusterTime":
+			ce.ClusterTime, err = timestamp(key, v)
+		case "wallTime":
+			ce.WallTime, err = dateTime(key, v)
+		case "ns":
+			ce.Namespace, err = namespace(key, v)
+		case "to":
+			ce.To, err = namespace(key, v)
+		case "documentKey":
+			ce.DocumentKey, err = document(key, v)
+		case "fullDocument":
+			// The full document is null when it is not available.
+			if v.Type() != bson.TypeNull {
+				ce.FullDocument, err = document(key, v)
+			}
+		case "fullDocumentBeforeChange":
+			if v.Type() != bson.TypeNull {
+				ce.FullDocumentBeforeChange, err = document(key, v)
+			}
+		case "updateDescription":
+			var ud UpdateDescription
+			ud, err = updateDescription(key, v)
+			ce.UpdateDescription = &ud
+		case "txnNumber":
+			ce.TxnNumber, err = integer(key, v)
+		case "lsid":
+			ce.LSID, err = document(key, v)
+		}
+		return err
+	})
+	if err != nil {
+		return ce, err
+	}
+	if ce.OperationType == "" {
+		return ce, DecodeError{Field: "operationType", Message: "missing"}
+	}
+
+	return ce, nil
+}
+
+func namespace(key string, v *bson.Value) (Namespace, error) {
+	var ns Namespace
+	r, err := document(key, v)
+	if err != nil {
+		return ns, err
+	}
+
+	err = decode(r, func(field string, v *bson.Value) error {
+		var err error
+		switch field {
+		case "db":
+			ns.Database, err = str(key+".db", v)
+		case "coll":
+			ns.Collection, err = str(key+".coll", v)
+		}
+		return err
+	})
+	return ns, err
+}
+
+func updateDescription(key string, v *bson.Value) (UpdateDescription, error) {
+	var ud UpdateDescription
+	r, err := document(key, v)
+	if err != nil {
+		return ud, err
+	}
+
+	err = decode(r, func(field string, v *bson.Value) error {
+		path := key + "." + field
+		switch field {
+		case "updatedFields":
+			var err error
+			ud.UpdatedFields, err = document(path, v)
+			return err
+		case "removedFields":
+			return eachElement(path, v, func(v *bson.Value) error {
+				s, err := str(path, v)
+				ud.RemovedFields = append(ud.RemovedFields, s)
+				return err
+			})
+		case "truncatedArrays":
+			return eachElement(path, v, func(v *bson.Value) error {
+				r, err := document(path, v)
+				if err != nil {
+					return err
+				}
+				var ta TruncatedArray
+				err = decode(r, func(field string, v *bson.Value) error {
+					var err error
+					switch field {
+					case "field":
+						ta.Field, err = str(path+".field", v)
+					case "newSize":
+						var n int64
+						n, err = integer(path+".newSize", v)
+						ta.NewSize = int32(n)
+					}
+					return err
+				})
+				ud.TruncatedArrays = append(ud.TruncatedArrays, ta)
+				return err
+			})
+		}
+		return nil
+	})
+	return ud, err
+}
+
+// eachElement calls f with each element of the array v.
+func eachElement(key string, v *bson.Value, f func(*bson.Value) error) error {
+	if v.Type() != bson.TypeArray {
+		return typeError(key, v, "an array")
+	}
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		if err := f(itr.Element().Value()); err != nil {
+			return err
+		}
+	}
+	return itr.Err()
+}
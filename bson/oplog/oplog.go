@@ -0,0 +1,266 @@
+// Package oplog decodes MongoDB oplog entries and change stream events and replays oplog entries.
+//
+// DecodeEntry and DecodeChangeEvent read the fields of an oplog entry, such as ts, op, ns, o, o2,
+// and ui, or of a change event into typed structs. The documents within them are bson.Readers
+// which share the bytes of the decoded document. Timestamp orders oplog positions.
+//
+// ApplyDocument replays an insert, update, or delete entry against a single document, and
+// ApplyCollection replays one against a collection.Collection. Updates may be either update
+// documents, as written by servers before 5.0 and with $v: 1, or the diffs written with $v: 2. An
+// Applier routes entries to collections by namespace and also replays the commands in c entries
+// which affect an in-memory collection, such as drop, renameCollection, createIndexes, and
+// applyOps.
+package oplog
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// Timestamp is a BSON timestamp, the position of an entry in the oplog. T is the number of seconds
+// since the Unix epoch and I orders the entries within a second.
+type Timestamp struct {
+	T uint32
+	I uint32
+}
+
+// Compare returns -1 if ts is before other, +1 if ts is after other, and 0 if they are equal.
+func (ts Timestamp) Compare(other Timestamp) int {
+	switch {
+	case ts.T < other.T:
+		return -1
+	case ts.T > other.T:
+		return 1
+	case ts.I < other.I:
+		return -1
+	case ts.I > other.I:
+		return 1
+	}
+	return 0
+}
+
+// Before reports whether ts is before other.
+func (ts Timestamp) Before(other Timestamp) bool {
+	return ts.Compare(other) < 0
+}
+
+// After reports whether ts is after other.
+func (ts Timestamp) After(other Timestamp) bool {
+	return ts.Compare(other) > 0
+}
+
+// IsZero reports whether ts is the zero timestamp.
+func (ts Timestamp) IsZero() bool {
+	return ts.T == 0 && ts.I == 0
+}
+
+// Time returns the time of ts, which has a precision of one second.
+func (ts Timestamp) Time() time.Time {
+	return time.Unix(int64(ts.T), 0)
+}
+
+// Value returns ts as a BSON value.
+func (ts Timestamp) Value() *bson.Value {
+	return bson.AC.Timestamp(ts.T, ts.I)
+}
+
+func (ts Timestamp) String() string {
+	return fmt.Sprintf("Timestamp(%d, %d)", ts.T, ts.I)
+}
+
+// OpType is the kind of operation of an oplog entry.
+type OpType string
+
+// The kinds of operations.
+const (
+	OpInsert  OpType = "i"
+	OpUpdate  OpType = "u"
+	OpDelete  OpType = "d"
+	OpCommand OpType = "c"
+	OpNoop    OpType = "n"
+)
+
+// Entry is an oplog entry.
+type Entry struct {
+	// Timestamp is the position of the entry in the oplog, from the ts field.
+	Timestamp Timestamp
+	// Term is the election term of the primary which wrote the entry, from the t field.
+	Term int64
+	// Hash is the unique identifier of the entry written by servers before 4.2, from the h field.
+	Hash int64
+	// Version is the version of the entry format, from the v field.
+	Version int32
+	Op      OpType
+	// Namespace is the namespace the entry applies to, such as "db.coll" or "db.$cmd", from the
+	// ns field.
+	Namespace string
+	// UUID is the UUID of the collection, from the ui field, or nil if there is none.
+	UUID []byte
+	// Object is the document inserted by an insert, the update of an update, the filter of a
+	// delete, or the command of a command, from the o field.
+	Object bson.Reader
+	// Object2 is the filter of an update, from the o2 field.
+	Object2 bson.Reader
+	// WallTime is the time the entry was written, from the wall field.
+	WallTime time.Time
+	// Upsert is true for an update which may insert a document, from the b field.
+	Upsert      bool
+	FromMigrate bool
+	// Raw is the whole entry, from which the fields without a struct field can be read.
+	Raw bson.Reader
+}
+
+// DecodeError is returned when a field of an oplog entry or change event is invalid.
+type DecodeError struct {
+	Field   string
+	Message string
+}
+
+func (de DecodeError) Error() string {
+	return fmt.Sprintf("oplog: %s: %s", de.Field, de.Message)
+}
+
+// DecodeEntry decodes the oplog entry r. The documents of the entry share the bytes of r.
+func DecodeEntry(r bson.Reader) (Entry, error) {
+	e := Entry{Raw: r}
+	err := decode(r, func(key string, v *bson.Value) error {
+		var err error
+		switch key {
+		case "ts":
+			e.Timestamp, err = timestamp(key, v)
+		case "t":
+			e.Term, err = integer(key, v)
+		case "h":
+			e.Hash, err = integer(key, v)
+		case "v":
+			var n int64
+			n, err = integer(key, v)
+			e.Version = int32(n)
+		case "op":
+			var s string
+			s, err = str(key, v)
+			e.Op = OpType(s)
+		case "ns":
+			e.Namespace, err = str(key, v)
+		case "ui":
+			e.UUID, err = uuid(key, v)
+		case "o":
+			e.Object, err = document(key, v)
+		case "o2":
+			e.Object2, err = document(key, v)
+		case "wall":
+			e.WallTime, err = dateTime(key, v)
+		case "b":
+			e.Upsert, err = boolean(key, v)
+		case "fromMigrate":
+			e.FromMigrate, err = boolean(key, v)
+		}
+		return err
+	})
+	if err != nil {
+		return e, err
+	}
+
+	switch e.Op {
+	case OpInsert, OpUpdate, OpDelete, OpCommand, OpNoop:
+	case "":
+		return e, DecodeError{Field: "op", Message: "missing"}
+	default:
+		return e, DecodeError{Field: "op", Message: fmt.Sprintf("unknown operation %q", e.Op)}
+	}
+	if e.Op != OpNoop && e.Object == nil {
+		return e, DecodeError{Field: "o", Message: "missing"}
+	}
+	if e.Op == OpUpdate && e.Object2 == nil {
+		return e, DecodeError{Field: "o2", Message: "missing"}
+	}
+
+	return e, nil
+}
+
+// decode validates r and calls f with each of its elements.
+func decode(r bson.Reader, f func(key string, v *bson.Value) error) error {
+	if _, err := r.Validate(); err != nil {
+		return err
+	}
+	itr, err := r.Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		// The iterator reuses its element, so f may keep the value of a clone.
+		elem := itr.Element().Clone()
+		if err := f(elem.Key(), elem.Value()); err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+func typeError(key string, v *bson.Value, want string) error {
+	return DecodeError{Field: key, Message: fmt.Sprintf("must be %s, not %s", want, v.Type().Alias())}
+}
+
+func timestamp(key string, v *bson.Value) (Timestamp, error) {
+	if v.Type() != bson.TypeTimestamp {
+		return Timestamp{}, typeError(key, v, "a timestamp")
+	}
+	// Value.Timestamp returns the two halves in the order they are stored, and the increment is
+	// stored before the seconds.
+	i, t := v.Timestamp()
+	return Timestamp{T: t, I: i}, nil
+}
+
+func integer(key string, v *bson.Value) (int64, error) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), nil
+	case bson.TypeInt64:
+		return v.Int64(), nil
+	}
+	return 0, typeError(key, v, "an integer")
+}
+
+func str(key string, v *bson.Value) (string, error) {
+	if v.Type() != bson.TypeString {
+		return "", typeError(key, v, "a string")
+	}
+	return v.StringValue(), nil
+}
+
+func boolean(key string, v *bson.Value) (bool, error) {
+	if v.Type() != bson.TypeBoolean {
+		return false, typeError(key, v, "a boolean")
+	}
+	return v.Boolean(), nil
+}
+
+func document(key string, v *bson.Value) (bson.Reader, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, typeError(key, v, "a document")
+	}
+	return v.ReaderDocument(), nil
+}
+
+func dateTime(key string, v *bson.Value) (time.Time, error) {
+	if v.Type() != bson.TypeDateTime {
+		return time.Time{}, typeError(key, v, "a datetime")
+	}
+	return v.DateTime(), nil
+}
+
+// uuid returns the bytes of a binary value of the UUID subtype.
+func uuid(key string, v *bson.Value) ([]byte, error) {
+	if v.Type() != bson.TypeBinary {
+		return nil, typeError(key, v, "a UUID")
+	}
+	subtype, data := v.Binary()
+	if subtype != 0x04 || len(data) != 16 {
+		return nil, DecodeError{Field: key, Message: "must be a UUID"}
+	}
+	return data, nil
+}
@@ -0,0 +1,531 @@
+package oplog
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+	"github.com/skriptble/wilson/bson/collection"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func docJSON(t *testing.T, d *bson.Document) string {
+	t.Helper()
+
+	if d == nil {
+		return "null"
+	}
+	return bsontest.ToJSON(t, bsontest.Marshal(t, d))
+}
+
+// entry returns an entry with the operation op on the namespace db.c.
+func entry(t *testing.T, op OpType, o, o2 *bson.Document) Entry {
+	t.Helper()
+
+	e := Entry{Op: op, Namespace: "db.c", Object: bsontest.Marshal(t, o)}
+	if o2 != nil {
+		e.Object2 = bsontest.Marshal(t, o2)
+	}
+	return e
+}
+
+func diff(elems ...*bson.Element) *bson.Document {
+	return bson.NewDocument(C.Int32("$v", 2), C.SubDocumentFromElements("diff", elems...))
+}
+
+func TestTimestamp(t *testing.T) {
+	testCases := []struct {
+		a, b Timestamp
+		want int
+	}{
+		{Timestamp{1, 1}, Timestamp{1, 1}, 0},
+		{Timestamp{1, 2}, Timestamp{1, 1}, 1},
+		{Timestamp{1, 2}, Timestamp{2, 1}, -1},
+		{Timestamp{3, 0}, Timestamp{2, 9}, 1},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.a.Compare(tc.b); got != tc.want {
+			t.Errorf("Unexpected result for %v.Compare(%v). got %d; want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := tc.a.Before(tc.b); got != (tc.want < 0) {
+			t.Errorf("Unexpected result for %v.Before(%v). got %v; want %v", tc.a, tc.b, got, tc.want < 0)
+		}
+		if got := tc.a.After(tc.b); got != (tc.want > 0) {
+			t.Errorf("Unexpected result for %v.After(%v). got %v; want %v", tc.a, tc.b, got, tc.want > 0)
+		}
+	}
+
+	if !(Timestamp{}).IsZero() || (Timestamp{I: 1}).IsZero() {
+		t.Errorf("Unexpected result from IsZero")
+	}
+	if got := (Timestamp{T: 60}).Time(); !got.Equal(time.Unix(60, 0)) {
+		t.Errorf("Unexpected result. got %v; want %v", got, time.Unix(60, 0))
+	}
+	if ts, err := timestamp("ts", (Timestamp{T: 5, I: 7}).Value()); err != nil || ts != (Timestamp{T: 5, I: 7}) {
+		t.Errorf("Unexpected result. got %v, %v; want %v", ts, err, Timestamp{T: 5, I: 7})
+	}
+}
+
+func TestDecodeEntry(t *testing.T) {
+	uuid := make([]byte, 16)
+	uuid[0] = 0xab
+	wall := time.Unix(1700000000, 0).UTC()
+
+	raw := bsontest.Marshal(t, bson.NewDocument(
+		C.Timestamp("ts", 1700000000, 3),
+		C.Int64("t", 7),
+		C.Int32("v", 2),
+		C.String("op", "u"),
+		C.String("ns", "db.c"),
+		C.BinaryWithSubtype("ui", uuid, 0x04),
+		C.SubDocumentFromElements("o", C.SubDocumentFromElements("$set", C.Int32("a", 1))),
+		C.SubDocumentFromElements("o2", C.Int32("_id", 1)),
+		C.DateTime("wall", wall.UnixNano()/int64(time.Millisecond)),
+		C.Boolean("b", true),
+	))
+	e, err := DecodeEntry(raw)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e.Timestamp != (Timestamp{1700000000, 3}) || e.Term != 7 || e.Version != 2 || e.Op != OpUpdate ||
+		e.Namespace != "db.c" || !e.Upsert || e.FromMigrate {
+		t.Errorf("Unexpected entry. got %+v", e)
+	}
+	if len(e.UUID) != 16 || e.UUID[0] != 0xab {
+		t.Errorf("Unexpected UUID. got %x", e.UUID)
+	}
+	if !e.WallTime.Equal(wall) {
+		t.Errorf("Unexpected wall time. got %v; want %v", e.WallTime, wall)
+	}
+	if got := bsontest.ToJSON(t, e.Object); got != `{"$set":{"a":1}}` {
+		t.Errorf("Unexpected o. got %s", got)
+	}
+	if got := bsontest.ToJSON(t, e.Object2); got != `{"_id":1}` {
+		t.Errorf("Unexpected o2. got %s", got)
+	}
+
+	testCases := []struct {
+		name  string
+		elems []*bson.Element
+		field string
+	}{
+		{"missing op", []*bson.Element{C.SubDocumentFromElements("o")}, "op"},
+		{"unknown op", []*bson.Element{C.String("op", "x"), C.SubDocumentFromElements("o")}, "op"},
+		{"missing o", []*bson.Element{C.String("op", "i")}, "o"},
+		{"missing o2", []*bson.Element{C.String("op", "u"), C.SubDocumentFromElements("o")}, "o2"},
+		{"ts type", []*bson.Element{C.Int64("ts", 1), C.String("op", "n")}, "ts"},
+		{"o type", []*bson.Element{C.String("op", "i"), C.String("o", "x")}, "o"},
+		{"ui subtype", []*bson.Element{C.String("op", "n"), C.Binary("ui", []byte{1, 2})}, "ui"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeEntry(bsontest.Marshal(t, bson.NewDocument(tc.elems...)))
+			de, ok := err.(DecodeError)
+			if !ok || de.Field != tc.field {
+				t.Errorf("Did not get expected error. got %v; want an error for %s", err, tc.field)
+			}
+		})
+	}
+
+	// A no-op entry does not need an o field.
+	if _, err := DecodeEntry(bsontest.Marshal(t, bson.NewDocument(C.String("op", "n")))); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDecodeChangeEvent(t *testing.T) {
+	raw := bsontest.Marshal(t, bson.NewDocument(
+		C.SubDocumentFromElements("_id", C.String("_data", "token")),
+		C.String("operationType", "update"),
+		C.Timestamp("clusterTime", 10, 2),
+		C.SubDocumentFromElements("ns", C.String("db", "db"), C.String("coll", "c")),
+		C.SubDocumentFromElements("documentKey", C.Int32("_id", 1)),
+		C.Null("fullDocument"),
+		C.SubDocumentFromElements("updateDescription",
+			C.SubDocumentFromElements("updatedFields", C.Int32("a.b", 2)),
+			C.ArrayFromElements("removedFields", AC.String("x"), AC.String("y")),
+			C.ArrayFromElements("truncatedArrays", AC.DocumentFromElements(C.String("field", "arr"), C.Int32("newSize", 3))),
+		),
+		C.Int64("txnNumber", 4),
+	))
+
+	ce, err := DecodeChangeEvent(raw)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ce.OperationType != "update" || ce.ClusterTime != (Timestamp{10, 2}) || ce.TxnNumber != 4 ||
+		ce.Namespace != (Namespace{Database: "db", Collection: "c"}) || ce.FullDocument != nil {
+		t.Errorf("Unexpected event. got %+v", ce)
+	}
+	if got := bsontest.ToJSON(t, ce.ID); got != `{"_data":"token"}` {
+		t.Errorf("Unexpected _id. got %s", got)
+	}
+	if got := bsontest.ToJSON(t, ce.DocumentKey); got != `{"_id":1}` {
+		t.Errorf("Unexpected documentKey. got %s", got)
+	}
+
+	ud := ce.UpdateDescription
+	if ud == nil {
+		t.Fatalf("Expected an update description")
+	}
+	if got := bsontest.ToJSON(t, ud.UpdatedFields); got != `{"a.b":2}` {
+		t.Errorf("Unexpected updatedFields. got %s", got)
+	}
+	if strings.Join(ud.RemovedFields, ",") != "x,y" {
+		t.Errorf("Unexpected removedFields. got %v", ud.RemovedFields)
+	}
+	if len(ud.TruncatedArrays) != 1 || ud.TruncatedArrays[0] != (TruncatedArray{Field: "arr", NewSize: 3}) {
+		t.Errorf("Unexpected truncatedArrays. got %v", ud.TruncatedArrays)
+	}
+
+	_, err = DecodeChangeEvent(bsontest.Marshal(t, bson.NewDocument(C.String("ns", "db.c"))))
+	if de, ok := err.(DecodeError); !ok || de.Field != "ns" {
+		t.Errorf("Did not get expected error. got %v; want an error for ns", err)
+	}
+	_, err = DecodeChangeEvent(bsontest.Marshal(t, bson.NewDocument()))
+	if de, ok := err.(DecodeError); !ok || de.Field != "operationType" {
+		t.Errorf("Did not get expected error. got %v; want an error for operationType", err)
+	}
+}
+
+func TestApplyDocument(t *testing.T) {
+	original := bson.NewDocument(
+		C.Int32("_id", 1),
+		C.Int32("a", 1),
+		C.String("b", "x"),
+		C.SubDocumentFromElements("sub", C.Int32("p", 1), C.Int32("q", 2)),
+		C.ArrayFromElements("arr", AC.Int32(1), AC.Int32(2), AC.DocumentFromElements(C.Int32("z", 1))),
+	)
+	before := docJSON(t, original)
+
+	testCases := []struct {
+		name string
+		op   OpType
+		o    *bson.Document
+		want string
+	}{
+		{
+			"insert", OpInsert,
+			bson.NewDocument(C.Int32("_id", 2), C.Int32("a", 5)),
+			`{"_id":2,"a":5}`,
+		},
+		{"delete", OpDelete, bson.NewDocument(C.Int32("_id", 1)), `null`},
+		{
+			"v1 $set", OpUpdate,
+			bson.NewDocument(C.Int32("$v", 1), C.SubDocumentFromElements("$set", C.Int32("a", 2), C.Int32("sub.p", 9))),
+			`{"_id":1,"a":2,"b":"x","sub":{"p":9,"q":2},"arr":[1,2,{"z":1}]}`,
+		},
+		{
+			"v1 $unset without $v", OpUpdate,
+			bson.NewDocument(C.SubDocumentFromElements("$unset", C.String("b", ""))),
+			`{"_id":1,"a":1,"sub":{"p":1,"q":2},"arr":[1,2,{"z":1}]}`,
+		},
+		{
+			"replacement", OpUpdate,
+			bson.NewDocument(C.String("c", "new")),
+			`{"_id":1,"c":"new"}`,
+		},
+		{
+			"v2 update, insert, and delete", OpUpdate,
+			diff(
+				C.SubDocumentFromElements("d", C.Boolean("b", false)),
+				C.SubDocumentFromElements("u", C.Int32("a", 3), C.Int32("missing", 4)),
+				C.SubDocumentFromElements("i", C.String("c", "y")),
+			),
+			`{"_id":1,"a":3,"sub":{"p":1,"q":2},"arr":[1,2,{"z":1}],"missing":4,"c":"y"}`,
+		},
+		{
+			"v2 document subdiff", OpUpdate,
+			diff(C.SubDocumentFromElements("ssub",
+				C.SubDocumentFromElements("u", C.Int32("q", 7)),
+				C.SubDocumentFromElements("i", C.Int32("r", 8)),
+			)),
+			`{"_id":1,"a":1,"b":"x","sub":{"p":1,"q":7,"r":8},"arr":[1,2,{"z":1}]}`,
+		},
+		{
+			"v2 array subdiff", OpUpdate,
+			diff(C.SubDocumentFromElements("sarr",
+				C.Boolean("a", true),
+				C.Int32("u0", 10),
+				C.SubDocumentFromElements("s2", C.SubDocumentFromElements("u", C.Int32("z", 2))),
+				C.Int32("u4", 12),
+			)),
+			`{"_id":1,"a":1,"b":"x","sub":{"p":1,"q":2},"arr":[10,2,{"z":2},null,12]}`,
+		},
+		{
+			"v2 array truncate", OpUpdate,
+			diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.Int32("l", 1))),
+			`{"_id":1,"a":1,"b":"x","sub":{"p":1,"q":2},"arr":[1]}`,
+		},
+		{
+			"v2 array extend", OpUpdate,
+			diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.Int32("l", 4))),
+			`{"_id":1,"a":1,"b":"x","sub":{"p":1,"q":2},"arr":[1,2,{"z":1},null]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ApplyDocument(original, entry(t, tc.op, tc.o, bson.NewDocument(C.Int32("_id", 1))))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if s := docJSON(t, got); s != tc.want {
+				t.Errorf("Unexpected result. got %s; want %s", s, tc.want)
+			}
+			if after := docJSON(t, original); after != before {
+				t.Errorf("The original document was modified. got %s; want %s", after, before)
+			}
+		})
+	}
+}
+
+func TestApplyDocumentErrors(t *testing.T) {
+	original := bson.NewDocument(C.Int32("_id", 1), C.Int32("a", 1), C.ArrayFromElements("arr", AC.Int32(1)))
+
+	testCases := []struct {
+		name string
+		doc  *bson.Document
+		op   OpType
+		o    *bson.Document
+	}{
+		{"missing document", nil, OpUpdate, bson.NewDocument(C.SubDocumentFromElements("$set", C.Int32("a", 1)))},
+		{"command", original, OpCommand, bson.NewDocument(C.String("drop", "c"))},
+		{"unknown version", original, OpUpdate, bson.NewDocument(C.Int32("$v", 3))},
+		{"missing diff", original, OpUpdate, bson.NewDocument(C.Int32("$v", 2))},
+		{"unknown diff field", original, OpUpdate, diff(C.Int32("x", 1))},
+		{"subdiff of missing field", original, OpUpdate, diff(C.SubDocumentFromElements("snope"))},
+		{"document subdiff of integer", original, OpUpdate, diff(C.SubDocumentFromElements("sa"))},
+		{"array subdiff of document", original, OpUpdate, diff(C.SubDocumentFromElements("sa", C.Boolean("a", true)))},
+		{"invalid array index", original, OpUpdate, diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.Int32("ux", 1)))},
+		{"subdiff of missing element", original, OpUpdate, diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.SubDocumentFromElements("s3")))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ApplyDocument(tc.doc, entry(t, tc.op, tc.o, bson.NewDocument(C.Int32("_id", 1)))); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+
+	_, err := ApplyDocument(nil, entry(t, OpUpdate, bson.NewDocument(), bson.NewDocument()))
+	if err != ErrNotFound {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrNotFound)
+	}
+
+	// Padding an array is limited as it is for bson.Document.SetPath.
+	for _, o := range []*bson.Document{
+		diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.Int64("l", math.MaxInt64))),
+		diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.Int32("u"+strconv.Itoa(bson.MaxArrayPadding+2), 1))),
+		diff(C.SubDocumentFromElements("sarr", C.Boolean("a", true), C.Int32("u"+strconv.Itoa(math.MaxInt), 1))),
+	} {
+		_, err := ApplyDocument(original, entry(t, OpUpdate, o, bson.NewDocument(C.Int32("_id", 1))))
+		if err != bson.ErrPaddingLimit {
+			t.Errorf("Did not get expected error. got %v; want %v", err, bson.ErrPaddingLimit)
+		}
+	}
+}
+
+func find(t *testing.T, c *collection.Collection) string {
+	t.Helper()
+
+	docs, err := c.Find(bson.NewDocument(), bson.NewDocument(C.Int32("_id", 1)), 0, 0, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var s []string
+	for _, doc := range docs {
+		s = append(s, bsontest.ToJSON(t, doc))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestApplyCollection(t *testing.T) {
+	c := collection.New()
+	id := func(n int32) *bson.Document { return bson.NewDocument(C.Int32("_id", n)) }
+
+	steps := []struct {
+		e    Entry
+		want string
+	}{
+		{entry(t, OpInsert, bson.NewDocument(C.Int32("_id", 1), C.Int32("a", 1)), nil), `{"_id":1,"a":1}`},
+		{entry(t, OpInsert, bson.NewDocument(C.Int32("_id", 2), C.Int32("a", 2)), nil), `{"_id":1,"a":1},{"_id":2,"a":2}`},
+		// Replaying an insert replaces the document.
+		{entry(t, OpInsert, bson.NewDocument(C.Int32("_id", 1), C.Int32("a", 5)), nil), `{"_id":1,"a":5},{"_id":2,"a":2}`},
+		{
+			entry(t, OpUpdate, diff(C.SubDocumentFromElements("u", C.Int32("a", 6))), id(1)),
+			`{"_id":1,"a":6},{"_id":2,"a":2}`,
+		},
+		{
+			entry(t, OpUpdate, bson.NewDocument(C.SubDocumentFromElements("$inc", C.Int32("a", 1))), id(2)),
+			`{"_id":1,"a":6},{"_id":2,"a":3}`,
+		},
+		{entry(t, OpDelete, id(1), nil), `{"_id":2,"a":3}`},
+		{Entry{Op: OpNoop}, `{"_id":2,"a":3}`},
+	}
+	upsert := entry(t, OpUpdate, bson.NewDocument(C.SubDocumentFromElements("$set", C.Int32("a", 7))), id(3))
+	upsert.Upsert = true
+	steps = append(steps, struct {
+		e    Entry
+		want string
+	}{upsert, `{"_id":2,"a":3},{"_id":3,"a":7}`})
+
+	for i, step := range steps {
+		if err := ApplyCollection(c, step.e); err != nil {
+			t.Fatalf("Unexpected error in step %d: %v", i, err)
+		}
+		if got := find(t, c); got != step.want {
+			t.Errorf("Unexpected result after step %d. got %s; want %s", i, got, step.want)
+		}
+	}
+
+	if err := ApplyCollection(c, entry(t, OpDelete, id(9), nil)); err != ErrNotFound {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrNotFound)
+	}
+	missing := entry(t, OpUpdate, bson.NewDocument(C.SubDocumentFromElements("$set", C.Int32("a", 1))), id(9))
+	if err := ApplyCollection(c, missing); err != ErrNotFound {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrNotFound)
+	}
+	if err := ApplyCollection(c, entry(t, OpCommand, bson.NewDocument(C.String("drop", "c")), nil)); err == nil {
+		t.Errorf("Expected an error for a command")
+	}
+}
+
+func command(t *testing.T, db string, elems ...*bson.Element) Entry {
+	t.Helper()
+
+	return Entry{Op: OpCommand, Namespace: db + ".$cmd", Object: bsontest.Marshal(t, bson.NewDocument(elems...))}
+}
+
+func indexNames(c *collection.Collection) string {
+	var names []string
+	for _, idx := range c.Indexes() {
+		names = append(names, idx.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestApplier(t *testing.T) {
+	a := NewApplier()
+	insert := func(ns string, id int32) Entry {
+		e := entry(t, OpInsert, bson.NewDocument(C.Int32("_id", id)), nil)
+		e.Namespace = ns
+		return e
+	}
+	applyOps := bsontest.Marshal(t, bson.NewDocument(
+		C.String("op", "i"), C.String("ns", "db.b"),
+		C.SubDocumentFromElements("o", C.Int32("_id", 2)),
+	))
+
+	entries := []Entry{
+		insert("db.a", 1),
+		command(t, "db", C.String("create", "empty")),
+		command(t, "db", C.ArrayFromElements("applyOps", AC.Document(bson.NewDocument()), AC.Null())),
+	}
+	if err := a.Apply(entries[0]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := a.Apply(entries[1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := a.Apply(entries[2]); err == nil {
+		t.Errorf("Expected an error for an invalid applyOps entry")
+	}
+
+	steps := []struct {
+		name       string
+		e          Entry
+		namespaces string
+	}{
+		{"applyOps", command(t, "db", C.ArrayFromElements("applyOps", AC.DocumentFromReader(applyOps))), "db.a,db.b,db.empty"},
+		{"insert other database", insert("other.c", 1), "db.a,db.b,db.empty,other.c"},
+		{"drop", command(t, "db", C.String("drop", "empty")), "db.a,db.b,other.c"},
+		{
+			"rename",
+			command(t, "db", C.String("renameCollection", "db.a"), C.String("to", "db.renamed")),
+			"db.b,db.renamed,other.c",
+		},
+		{
+			"rename dropTarget",
+			command(t, "db", C.String("renameCollection", "db.b"), C.String("to", "db.renamed"), C.Boolean("dropTarget", true)),
+			"db.renamed,other.c",
+		},
+		{"collMod", command(t, "db", C.String("collMod", "renamed")), "db.renamed,other.c"},
+		{"noop", Entry{Op: OpNoop}, "db.renamed,other.c"},
+		{"dropDatabase", command(t, "other", C.Int32("dropDatabase", 1)), "db.renamed"},
+	}
+
+	for _, step := range steps {
+		if err := a.Apply(step.e); err != nil {
+			t.Fatalf("Unexpected error in %s: %v", step.name, err)
+		}
+		if got := strings.Join(a.Namespaces(), ","); got != step.namespaces {
+			t.Errorf("Unexpected namespaces after %s. got %s; want %s", step.name, got, step.namespaces)
+		}
+	}
+	if got := find(t, a.Collection("db.renamed")); got != `{"_id":2}` {
+		t.Errorf("Unexpected documents. got %s; want %s", got, `{"_id":2}`)
+	}
+
+	c := a.Collection("db.renamed")
+	indexSteps := []struct {
+		name  string
+		e     Entry
+		names string
+	}{
+		{
+			"createIndexes",
+			command(t, "db", C.String("createIndexes", "renamed"), C.SubDocumentFromElements("key", C.Int32("a", 1)), C.String("name", "a_1"), C.Boolean("unique", true)),
+			"_id_,a_1",
+		},
+		{
+			"commitIndexBuild",
+			command(t, "db", C.String("commitIndexBuild", "renamed"), C.ArrayFromElements("indexes",
+				AC.DocumentFromElements(C.SubDocumentFromElements("key", C.Int32("b", 1)), C.String("name", "b_1")),
+				AC.DocumentFromElements(C.SubDocumentFromElements("key", C.Int32("c", -1)), C.String("name", "c_-1")),
+			)),
+			"_id_,a_1,b_1,c_-1",
+		},
+		{"dropIndexes", command(t, "db", C.String("dropIndexes", "renamed"), C.String("index", "b_1")), "_id_,a_1,c_-1"},
+		{"dropIndexes *", command(t, "db", C.String("dropIndexes", "renamed"), C.String("index", "*")), "_id_"},
+	}
+
+	for _, step := range indexSteps {
+		if err := a.Apply(step.e); err != nil {
+			t.Fatalf("Unexpected error in %s: %v", step.name, err)
+		}
+		if got := indexNames(c); got != step.names {
+			t.Errorf("Unexpected indexes after %s. got %s; want %s", step.name, got, step.names)
+		}
+	}
+
+	errorCases := []struct {
+		name string
+		e    Entry
+	}{
+		{"unsupported", command(t, "db", C.Int32("convertToCapped", 1))},
+		{"invalid namespace", Entry{Op: OpCommand, Namespace: "db.c", Object: bsontest.Marshal(t, bson.NewDocument(C.String("drop", "c")))}},
+		{"rename missing", command(t, "db", C.String("renameCollection", "db.nope"), C.String("to", "db.x"))},
+		{"rename onto existing", command(t, "db", C.String("renameCollection", "db.renamed"), C.String("to", "db.renamed"))},
+		{"drop missing index", command(t, "db", C.String("dropIndexes", "renamed"), C.String("index", "nope"))},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := a.Apply(tc.e); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
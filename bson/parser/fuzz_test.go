@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzParseDocument(f *testing.F) {
+	files, err := filepath.Glob("../../data/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		var tc struct {
+			Valid []struct {
+				CanonicalBson string `json:"canonical_bson"`
+			} `json:"valid"`
+			DecodeErrors []struct {
+				Bson string `json:"bson"`
+			} `json:"decodeErrors"`
+		}
+		err = json.Unmarshal(content, &tc)
+		if err != nil {
+			f.Fatalf("%s: %v", file, err)
+		}
+
+		for _, v := range tc.Valid {
+			b, _ := hex.DecodeString(v.CanonicalBson)
+			f.Add(b)
+		}
+		for _, d := range tc.DecodeErrors {
+			b, _ := hex.DecodeString(d.Bson)
+			f.Add(b)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p, err := NewBSONParser(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Unexpected error creating parser: %v", err)
+		}
+
+		doc, err := p.ParseDocument()
+		if err != nil {
+			return
+		}
+		if int(doc.Length) > len(b) {
+			t.Fatalf("Document length is larger than the input. got %d; want <= %d", doc.Length, len(b))
+		}
+	})
+}
@@ -2,10 +2,12 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"io"
 	"math"
+	"unicode/utf8"
 
 	"github.com/skriptble/wilson/bson/decimal"
 	"github.com/skriptble/wilson/bson/parser/ast"
@@ -18,12 +20,18 @@ var ErrCorruptDocument = errors.New("wilson/parser: corrupted document")
 // ErrUnknownSubtype is returned when the subtype of a binary node is undefined.
 var ErrUnknownSubtype = errors.New("wilson/parser: unknown binary subtype")
 
+// ErrInvalidUTF8 is returned when a key or string is not valid UTF-8.
+var ErrInvalidUTF8 = errors.New("wilson/parser: invalid UTF-8")
+
 // ErrNilReader is returned when a nil reader is passed to NewBSONParser.
 var ErrNilReader = errors.New("wilson/parser: nil or invalid reader provided")
 
 // Parser is a BSON parser.
 type Parser struct {
 	r *bufio.Reader
+
+	// lr is the reader underlying r for a parser created by limit.
+	lr *io.LimitedReader
 }
 
 // NewBSONParser instantiates a new BSON Parser with the given reader.
@@ -85,7 +93,26 @@ func (p *Parser) readBoolean() (bool, error) {
 	return bv, nil
 }
 
-// ParseDocument parses an entire document from the parser's reader.
+// readBytes reads n bytes from the parser's reader. Large values are read incrementally so that a
+// corrupt length cannot cause a large allocation.
+func (p *Parser) readBytes(n int32) ([]byte, error) {
+	if int(n) <= p.r.Size() {
+		b := make([]byte, n)
+		_, err := io.ReadFull(p.r, b)
+		return b, err
+	}
+
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, p.r, int64(n))
+	if err == io.EOF && read > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return buf.Bytes(), err
+}
+
+// ParseDocument parses an entire document from the parser's reader. The elements of the document
+// must end exactly at the length given by its header.
 func (p *Parser) ParseDocument() (*ast.Document, error) {
 	doc := new(ast.Document)
 	// Lex document length
@@ -93,26 +120,68 @@ func (p *Parser) ParseDocument() (*ast.Document, error) {
 	if err != nil {
 		return nil, err
 	}
+	if l < 5 {
+		return nil, ErrCorruptDocument
+	}
 	doc.Length = l
+
+	sub := p.limit(l - 4)
 	// Lex and parse each item of the list
-	elist, err := p.ParseEList()
+	elist, err := sub.ParseEList()
 	if err != nil {
-		return nil, err
+		return nil, unexpectedEOF(err)
 	}
 	doc.EList = elist
 
 	// ensure the document ends with \x00
-	eol, err := p.r.ReadByte()
+	eol, err := sub.r.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, unexpectedEOF(err)
 	}
 	if eol != '\x00' {
 		return nil, ErrCorruptDocument
 	}
 
+	err = sub.end()
+	if err != nil {
+		return nil, err
+	}
+
 	return doc, nil
 }
 
+// limit returns a parser which reads the next n bytes from the parser's reader.
+func (p *Parser) limit(n int32) *Parser {
+	lr := &io.LimitedReader{R: p.r, N: int64(n)}
+	return &Parser{r: bufio.NewReader(lr), lr: lr}
+}
+
+// end returns an error unless every byte of a parser created by limit has been read. If the
+// underlying reader ended early, io.ErrUnexpectedEOF is returned.
+func (p *Parser) end() error {
+	_, err := p.r.ReadByte()
+	switch {
+	case err == io.EOF && p.lr.N > 0:
+		return io.ErrUnexpectedEOF
+	case err == io.EOF:
+		return nil
+	case err != nil:
+		return err
+	}
+
+	return ErrCorruptDocument
+}
+
+// unexpectedEOF converts io.EOF, which a parser created by limit returns when it reaches the end
+// of its bytes, to io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
 // ParseEList parses an entire element list from the parser's reader.
 func (p *Parser) ParseEList() ([]ast.Element, error) {
 	var element ast.Element
@@ -344,6 +413,8 @@ func (p *Parser) ParseElement() (ast.Element, error) {
 		el = &ast.MaxKeyElement{
 			Name: key,
 		}
+	default:
+		return nil, ErrCorruptDocument
 	}
 
 	return el, nil
@@ -371,12 +442,12 @@ func (p *Parser) ParseString() (string, error) {
 		return "", err
 	}
 
-	if l > 0 {
-		l--
+	if l < 1 {
+		return "", ErrCorruptDocument
 	}
+	l--
 
-	b := make([]byte, l)
-	_, err = io.ReadFull(p.r, b)
+	b, err := p.readBytes(l)
 	if err != nil {
 		return "", err
 	}
@@ -387,6 +458,9 @@ func (p *Parser) ParseString() (string, error) {
 	if eol != '\x00' {
 		return "", ErrCorruptDocument
 	}
+	if !utf8.Valid(b) {
+		return "", ErrInvalidUTF8
+	}
 
 	return string(b), nil
 }
@@ -400,6 +474,9 @@ func (p *Parser) ParseCString() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if !utf8.Valid(b) {
+		return "", ErrInvalidUTF8
+	}
 	return string(b[:len(b)-1]), nil
 }
 
@@ -410,20 +487,24 @@ func (p *Parser) ParseBinary() (*ast.Binary, error) {
 		return nil, err
 	}
 
+	if l < 0 {
+		return nil, ErrCorruptDocument
+	}
+
 	bst, err := p.ParseSubtype()
 	if err != nil {
 		return nil, err
 	}
 
-	b := make([]byte, l)
-	_, err = io.ReadFull(p.r, b)
+	b, err := p.readBytes(l)
 	if err != nil {
 		return nil, err
 	}
 
 	if bst == ast.SubtypeBinaryOld {
-		if len(b) < 4 {
-			// TODO(skriptble): Return a more informative error
+		// The old binary subtype contains a second length, which must be the length of the
+		// rest of the data.
+		if len(b) < 4 || int32(binary.LittleEndian.Uint32(b)) != l-4 {
 			return nil, ErrCorruptDocument
 		}
 		b = b[4:]
@@ -482,16 +563,26 @@ func (p *Parser) ParseDouble() (float64, error) {
 // ParseCodeWithScope parses a JavaScript Code with Scope node from the
 // parser's reader.
 func (p *Parser) ParseCodeWithScope() (*ast.CodeWithScope, error) {
-	// TODO(skriptble): We should probably keep track of this length
-	_, err := p.readInt32()
+	l, err := p.readInt32()
 	if err != nil {
 		return nil, err
 	}
-	str, err := p.ParseString()
+	// The length includes itself, the length of the string, the null terminator of the
+	// string, and the minimum size of the scope document.
+	if l < 14 {
+		return nil, ErrCorruptDocument
+	}
+
+	sub := p.limit(l - 4)
+	str, err := sub.ParseString()
 	if err != nil {
-		return nil, err
+		return nil, unexpectedEOF(err)
+	}
+	doc, err := sub.ParseDocument()
+	if err != nil {
+		return nil, unexpectedEOF(err)
 	}
-	doc, err := p.ParseDocument()
+	err = sub.end()
 	if err != nil {
 		return nil, err
 	}
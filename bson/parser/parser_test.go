@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"io"
 	"math"
 	"reflect"
 	"testing"
@@ -188,6 +189,26 @@ func TestBSONParser(t *testing.T) {
 				t.Errorf("Expected error. got %s; want %s", got, want)
 			}
 		})
+		testCases := []struct {
+			name string
+			b    []byte
+			err  error
+		}{
+			{"length too small", []byte{'\x04', '\x00', '\x00', '\x00', '\x00'}, ErrCorruptDocument},
+			{"length too short", []byte{'\x05', '\x00', '\x00', '\x00', '\x0A', 'x', '\x00', '\x00'}, io.ErrUnexpectedEOF},
+			{"length too long", []byte{'\x06', '\x00', '\x00', '\x00', '\x00', '\x00'}, ErrCorruptDocument},
+			{"truncated", []byte{'\x08', '\x00', '\x00', '\x00', '\x0A', 'x', '\x00'}, io.ErrUnexpectedEOF},
+			{"invalid type", []byte{'\x08', '\x00', '\x00', '\x00', '\x20', 'x', '\x00', '\x00'}, ErrCorruptDocument},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				p := &Parser{r: bufio.NewReader(bytes.NewReader(tc.b))}
+				_, err := p.ParseDocument()
+				if err != tc.err {
+					t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+				}
+			})
+		}
 	})
 
 	t.Run("parse-elist", func(t *testing.T) {
@@ -497,7 +518,7 @@ func TestBSONParser(t *testing.T) {
 		})
 		t.Run("parse-string-error", func(t *testing.T) {
 			b := make([]byte, 4)
-			binary.LittleEndian.PutUint32(b, 4)
+			binary.LittleEndian.PutUint32(b, 14)
 			want := errors.New("parse-string-error")
 			p := &Parser{r: bufio.NewReader(&errReader{b: b, err: want})}
 			_, got := p.ParseCodeWithScope()
@@ -507,8 +528,8 @@ func TestBSONParser(t *testing.T) {
 		})
 		t.Run("parse-document-error", func(t *testing.T) {
 			b := make([]byte, 9)
-			binary.LittleEndian.PutUint32(b[:4], 4)
-			binary.LittleEndian.PutUint32(b[4:8], 0)
+			binary.LittleEndian.PutUint32(b[:4], 14)
+			binary.LittleEndian.PutUint32(b[4:8], 1)
 			b[8] = '\x00'
 			want := errors.New("parse-document-error")
 			p := &Parser{r: bufio.NewReader(&errReader{b: b, err: want})}
@@ -530,11 +551,12 @@ func TestBSONParser(t *testing.T) {
 		binary.LittleEndian.PutUint32(doclen, 5)
 		b = append(b, doclen...)
 		b = append(b, '\x00')
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(b)))
 		r := bytes.NewReader(b)
 		p := &Parser{r: bufio.NewReader(r)}
 		got, err := p.ParseCodeWithScope()
 		if err != nil {
-			t.Errorf("Unexpected error: %s", err)
+			t.Fatalf("Unexpected error: %s", err)
 		}
 		if got.String != want.String {
 			t.Errorf("String contents do not match. got %s; want %s", got.String, want.String)
@@ -745,7 +767,7 @@ func parseElementTest(t *testing.T) {
 		b[0] = '\x0F'
 		copy(b[1:7], []byte(key))
 		b[7] = '\x00'
-		binary.LittleEndian.PutUint32(b[8:12], uint32(4+len(js)+1+5))
+		binary.LittleEndian.PutUint32(b[8:12], uint32(4+4+len(js)+1+5))
 		binary.LittleEndian.PutUint32(b[12:16], uint32(len(js)+1))
 		copy(b[16:36], []byte(js))
 		b[36] = byte('\x00')
@@ -0,0 +1,507 @@
+package bson
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPath indicates that a dotted path contains an empty segment.
+var ErrInvalidPath = errors.New("bson: invalid path")
+
+// ErrInvalidPointer indicates that a JSON Pointer does not start with "/" or contains a "~"
+// which is not followed by "0" or "1".
+var ErrInvalidPointer = errors.New("bson: invalid JSON Pointer")
+
+// ErrInvalidIndex indicates that a path segment addressing an element of an array is not an
+// index.
+var ErrInvalidIndex = errors.New("bson: invalid array index")
+
+// ErrPaddingLimit indicates that setting an array index would pad the array with more than
+// MaxArrayPadding nulls.
+var ErrPaddingLimit = errors.New("bson: array index is too far past the end of the array")
+
+// MaxArrayPadding is the most nulls SetPath and SetPointer add to an array to reach an index, the
+// limit MongoDB applies to updates.
+const MaxArrayPadding = 1500000
+
+// The wildcard path segments. Both are only interpreted by LookupPathAll.
+const (
+	// wildcard matches every element of a document or an array.
+	wildcard = "*"
+	// allPositional matches every element of an array.
+	allPositional = "$[]"
+)
+
+// LookupPath returns the value at the dotted path, such as "a.b.0.c". The elements of arrays
+// are addressed by their index.
+func (d *Document) LookupPath(path string) (*Value, error) {
+	segs, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupPath(documentNode{d: d}, segs)
+}
+
+// LookupPointer returns the value at the RFC 6901 JSON Pointer ptr, such as "/a/b/0/c".
+func (d *Document) LookupPointer(ptr string) (*Value, error) {
+	segs, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupPath(documentNode{d: d}, segs)
+}
+
+// LookupPathAll returns the values which match the dotted path, in the order in which they
+// appear in the document. A "*" segment matches every element of a document or an array, and
+// a "$[]" segment matches every element of an array. Paths which do not exist are skipped.
+func (d *Document) LookupPathAll(path string) ([]*Value, error) {
+	segs, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*Value
+	err = lookupPathAll(documentNode{d: d}, segs, &values)
+	return values, err
+}
+
+// SetPath sets the value at the dotted path to v, replacing the existing value. Missing
+// documents and arrays along the path are created: an array if the segment that follows is an
+// index, and a document otherwise. Setting an index past the end of an array pads the array
+// with nulls, and ErrPaddingLimit is returned if more than MaxArrayPadding would be needed.
+func (d *Document) SetPath(path string, v *Value) error {
+	segs, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+
+	return d.setPath(segs, v)
+}
+
+// SetPointer sets the value at the RFC 6901 JSON Pointer ptr to v, as SetPath does. The
+// segment "-" addresses the end of an array, so v is appended to it.
+func (d *Document) SetPointer(ptr string, v *Value) error {
+	segs, err := splitPointer(ptr)
+	if err != nil {
+		return err
+	}
+
+	return d.setPath(segs, v)
+}
+
+// DeletePath removes the value at the dotted path and returns it.
+func (d *Document) DeletePath(path string) (*Value, error) {
+	segs, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.deletePath(segs)
+}
+
+// DeletePointer removes the value at the RFC 6901 JSON Pointer ptr and returns it.
+func (d *Document) DeletePointer(ptr string) (*Value, error) {
+	segs, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.deletePath(segs)
+}
+
+// LookupPath returns the value at the dotted path, such as "a.b.0.c". The elements of arrays
+// are addressed by their index.
+func (r Reader) LookupPath(path string) (*Value, error) {
+	segs, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupPath(readerNode{r: r}, segs)
+}
+
+// LookupPointer returns the value at the RFC 6901 JSON Pointer ptr, such as "/a/b/0/c".
+func (r Reader) LookupPointer(ptr string) (*Value, error) {
+	segs, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookupPath(readerNode{r: r}, segs)
+}
+
+// LookupPathAll returns the values which match the dotted path, in the order in which they
+// appear in the document. A "*" segment matches every element of a document or an array, and
+// a "$[]" segment matches every element of an array. Paths which do not exist are skipped.
+func (r Reader) LookupPathAll(path string) ([]*Value, error) {
+	segs, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*Value
+	err = lookupPathAll(readerNode{r: r}, segs, &values)
+	return values, err
+}
+
+// pathNode is a document or an array traversed by a path.
+type pathNode interface {
+	// child returns the value with the given key or index, or ErrElementNotFound.
+	child(seg string) (*Value, error)
+	// children returns the values of the document or array.
+	children() ([]*Value, error)
+	// isArray reports whether the node is an array.
+	isArray() bool
+	// node returns the node for the document or array v. It returns false if v is neither.
+	node(v *Value) (pathNode, bool)
+}
+
+// documentNode is a *Document traversed by a path.
+type documentNode struct {
+	d     *Document
+	array bool
+}
+
+func (n documentNode) child(seg string) (*Value, error) {
+	if !n.array {
+		elem, err := n.d.Lookup(seg)
+		if err != nil {
+			return nil, err
+		}
+		return elem.value, nil
+	}
+
+	i, ok := arrayIndex(seg)
+	if !ok || i >= len(n.d.elems) {
+		return nil, ErrElementNotFound
+	}
+	return n.d.elems[i].value, nil
+}
+
+func (n documentNode) children() ([]*Value, error) {
+	values := make([]*Value, len(n.d.elems))
+	for i, elem := range n.d.elems {
+		values[i] = elem.value
+	}
+
+	return values, nil
+}
+
+func (n documentNode) isArray() bool {
+	return n.array
+}
+
+func (documentNode) node(v *Value) (pathNode, bool) {
+	switch v.Type() {
+	case TypeEmbeddedDocument:
+		return documentNode{d: v.MutableDocument()}, true
+	case TypeArray:
+		return documentNode{d: v.MutableArray().doc, array: true}, true
+	}
+
+	return nil, false
+}
+
+// readerNode is a Reader traversed by a path.
+type readerNode struct {
+	r     Reader
+	array bool
+}
+
+func (n readerNode) child(seg string) (*Value, error) {
+	if !n.array {
+		elem, err := n.r.Lookup(seg)
+		if err != nil {
+			return nil, err
+		}
+		if elem == nil {
+			return nil, ErrElementNotFound
+		}
+		return elem.value, nil
+	}
+
+	i, ok := arrayIndex(seg)
+	if !ok {
+		return nil, ErrElementNotFound
+	}
+	elem, err := n.r.ElementAt(uint(i))
+	if err == ErrOutOfBounds {
+		return nil, ErrElementNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return elem.value, nil
+}
+
+func (n readerNode) children() ([]*Value, error) {
+	var values []*Value
+	_, err := n.r.readElements(func(elem *Element) error {
+		values = append(values, elem.value)
+		return nil
+	})
+
+	return values, err
+}
+
+func (n readerNode) isArray() bool {
+	return n.array
+}
+
+func (readerNode) node(v *Value) (pathNode, bool) {
+	switch v.Type() {
+	case TypeEmbeddedDocument:
+		return readerNode{r: v.ReaderDocument()}, true
+	case TypeArray:
+		return readerNode{r: v.ReaderArray(), array: true}, true
+	}
+
+	return nil, false
+}
+
+// lookupPath returns the value at the path segs within n.
+func lookupPath(n pathNode, segs []string) (*Value, error) {
+	if len(segs) == 0 {
+		return nil, ErrEmptyKey
+	}
+
+	for i, seg := range segs {
+		v, err := n.child(seg)
+		if err != nil {
+			return nil, err
+		}
+		if i == len(segs)-1 {
+			return v, nil
+		}
+
+		var ok bool
+		n, ok = n.node(v)
+		if !ok {
+			return nil, ErrInvalidDepthTraversal
+		}
+	}
+
+	return nil, ErrElementNotFound
+}
+
+// lookupPathAll appends the values matching the path segs within n to values.
+func lookupPathAll(n pathNode, segs []string, values *[]*Value) error {
+	if len(segs) == 0 {
+		return ErrEmptyKey
+	}
+
+	var matches []*Value
+	switch seg := segs[0]; {
+	case seg == wildcard || seg == allPositional && n.isArray():
+		var err error
+		matches, err = n.children()
+		if err != nil {
+			return err
+		}
+	case seg == allPositional:
+		return nil
+	default:
+		v, err := n.child(seg)
+		if err == ErrElementNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		matches = []*Value{v}
+	}
+
+	if len(segs) == 1 {
+		*values = append(*values, matches...)
+		return nil
+	}
+
+	for _, v := range matches {
+		child, ok := n.node(v)
+		if !ok {
+			continue
+		}
+		err := lookupPathAll(child, segs[1:], values)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setPath sets the value at the path segs to v, creating the documents and arrays along the path
+// which do not exist.
+func (d *Document) setPath(segs []string, v *Value) error {
+	if len(segs) == 0 {
+		return ErrEmptyKey
+	}
+
+	n := documentNode{d: d}
+	for i, seg := range segs {
+		last := i == len(segs)-1
+
+		var child *Value
+		if n.array {
+			arr := &Array{n.d}
+			idx, ok := arrayIndex(seg)
+			if seg == "-" {
+				idx, ok = arr.Len(), true
+			}
+			if !ok {
+				return ErrInvalidIndex
+			}
+			if idx-arr.Len() > MaxArrayPadding {
+				return ErrPaddingLimit
+			}
+			for arr.Len() < idx {
+				arr.Append(AC.Null())
+			}
+
+			switch {
+			case idx < arr.Len() && last:
+				n.d.elems[idx] = C.Value(n.d.elems[idx].Key(), v)
+				return nil
+			case idx < arr.Len():
+				child = n.d.elems[idx].value
+			case last:
+				n.d.Append(C.Value(strconv.Itoa(idx), v))
+				return nil
+			default:
+				child = newContainer(segs[i+1])
+				n.d.Append(C.Value(strconv.Itoa(idx), child))
+			}
+		} else {
+			if last {
+				n.d.Set(C.Value(seg, v))
+				return nil
+			}
+
+			elem, err := n.d.Lookup(seg)
+			switch {
+			case err == ErrElementNotFound:
+				child = newContainer(segs[i+1])
+				n.d.Append(C.Value(seg, child))
+			case err != nil:
+				return err
+			default:
+				child = elem.value
+			}
+		}
+
+		next, ok := n.node(child)
+		if !ok {
+			return ErrInvalidDepthTraversal
+		}
+		n = next.(documentNode)
+	}
+
+	return nil
+}
+
+// deletePath removes the value at the path segs and returns it.
+func (d *Document) deletePath(segs []string) (*Value, error) {
+	if len(segs) == 0 {
+		return nil, ErrEmptyKey
+	}
+
+	var n pathNode = documentNode{d: d}
+	for _, seg := range segs[:len(segs)-1] {
+		v, err := n.child(seg)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		n, ok = n.node(v)
+		if !ok {
+			return nil, ErrInvalidDepthTraversal
+		}
+	}
+
+	parent, seg := n.(documentNode), segs[len(segs)-1]
+	if parent.array {
+		i, ok := arrayIndex(seg)
+		if !ok || i >= len(parent.d.elems) {
+			return nil, ErrElementNotFound
+		}
+		return (&Array{parent.d}).Delete(uint(i)), nil
+	}
+
+	elem := parent.d.Delete(seg)
+	if elem == nil {
+		return nil, ErrElementNotFound
+	}
+	return elem.value, nil
+}
+
+// newContainer returns an empty array if seg addresses an element of an array, and an empty
+// document otherwise.
+func newContainer(seg string) *Value {
+	if _, ok := arrayIndex(seg); ok || seg == "-" {
+		return AC.Array(NewArray())
+	}
+
+	return AC.Document(NewDocument())
+}
+
+// splitPath returns the segments of the dotted path.
+func splitPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, ErrEmptyKey
+	}
+
+	segs := strings.Split(path, ".")
+	for _, seg := range segs {
+		if seg == "" {
+			return nil, ErrInvalidPath
+		}
+	}
+
+	return segs, nil
+}
+
+// splitPointer returns the unescaped segments of the JSON Pointer. The empty pointer, which
+// refers to the whole document, does not address a value.
+func splitPointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, ErrEmptyKey
+	}
+	if ptr[0] != '/' {
+		return nil, ErrInvalidPointer
+	}
+
+	segs := strings.Split(ptr[1:], "/")
+	for i, seg := range segs {
+		if !strings.Contains(seg, "~") {
+			continue
+		}
+		for j := 0; j < len(seg); j++ {
+			if seg[j] == '~' && (j+1 == len(seg) || seg[j+1] != '0' && seg[j+1] != '1') {
+				return nil, ErrInvalidPointer
+			}
+		}
+		segs[i] = strings.Replace(strings.Replace(seg, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return segs, nil
+}
+
+// arrayIndex returns the index that seg addresses. It returns false if seg is not a decimal
+// number without leading zeros.
+func arrayIndex(seg string) (int, bool) {
+	if seg == "" || len(seg) > 1 && seg[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(seg); i++ {
+		if seg[i] < '0' || seg[i] > '9' {
+			return 0, false
+		}
+	}
+
+	i, err := strconv.Atoi(seg)
+	return i, err == nil
+}
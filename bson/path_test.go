@@ -0,0 +1,268 @@
+package bson
+
+import (
+	"testing"
+)
+
+func pathTestDocument() *Document {
+	return NewDocument(
+		C.SubDocumentFromElements("a", C.Int32("b", 1), C.String("c", "x")),
+		C.ArrayFromElements("arr",
+			AC.DocumentFromElements(C.Int32("c", 2)),
+			AC.DocumentFromElements(C.Int32("c", 3), C.Int32("d", 4)),
+			AC.Int32(5),
+		),
+		C.Int32("a/b", 6),
+		C.Int32("m~n", 7),
+		C.Int32("", 8),
+	)
+}
+
+func TestLookupPath(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pointer bool
+		path    string
+		want    *Value
+		err     error
+	}{
+		{"top level", false, "a", AC.DocumentFromElements(C.Int32("b", 1), C.String("c", "x")), nil},
+		{"nested", false, "a.b", AC.Int32(1), nil},
+		{"array element", false, "arr.2", AC.Int32(5), nil},
+		{"array nested", false, "arr.1.d", AC.Int32(4), nil},
+		{"not found", false, "a.z", nil, ErrElementNotFound},
+		{"out of bounds", false, "arr.3", nil, ErrElementNotFound},
+		{"leading zero", false, "arr.01", nil, ErrElementNotFound},
+		{"non-index", false, "arr.c", nil, ErrElementNotFound},
+		{"wildcard is literal", false, "arr.*", nil, ErrElementNotFound},
+		{"through scalar", false, "a.b.c", nil, ErrInvalidDepthTraversal},
+		{"empty segment", false, "a..b", nil, ErrInvalidPath},
+		{"empty", false, "", nil, ErrEmptyKey},
+		{"pointer", true, "/arr/1/c", AC.Int32(3), nil},
+		{"pointer escaped slash", true, "/a~1b", AC.Int32(6), nil},
+		{"pointer escaped tilde", true, "/m~0n", AC.Int32(7), nil},
+		{"pointer empty key", true, "/", AC.Int32(8), nil},
+		{"pointer invalid escape", true, "/m~2n", nil, ErrInvalidPointer},
+		{"pointer no slash", true, "a", nil, ErrInvalidPointer},
+		{"pointer whole document", true, "", nil, ErrEmptyKey},
+	}
+
+	doc := pathTestDocument()
+	rdr, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookups := map[string]func(string) (*Value, error){
+				"Document": doc.LookupPath,
+				"Reader":   Reader(rdr).LookupPath,
+			}
+			if tc.pointer {
+				lookups = map[string]func(string) (*Value, error){
+					"Document": doc.LookupPointer,
+					"Reader":   Reader(rdr).LookupPointer,
+				}
+			}
+
+			for name, lookup := range lookups {
+				got, err := lookup(tc.path)
+				if err != tc.err {
+					t.Errorf("%s: Did not get expected error. got %v; want %v", name, err, tc.err)
+				}
+				if !got.Equal(tc.want) {
+					t.Errorf("%s: Returned value does not match. got %v; want %v", name, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLookupPathAll(t *testing.T) {
+	testCases := []struct {
+		name string
+		path string
+		want []*Value
+	}{
+		{"single", "a.b", []*Value{AC.Int32(1)}},
+		{"wildcard", "arr.*.c", []*Value{AC.Int32(2), AC.Int32(3)}},
+		{"all positional", "arr.$[].c", []*Value{AC.Int32(2), AC.Int32(3)}},
+		{"document wildcard", "*.c", []*Value{AC.String("x")}},
+		{"all positional on document", "a.$[]", nil},
+		{"nested wildcards", "*.*", []*Value{
+			AC.Int32(1), AC.String("x"),
+			AC.DocumentFromElements(C.Int32("c", 2)),
+			AC.DocumentFromElements(C.Int32("c", 3), C.Int32("d", 4)),
+			AC.Int32(5),
+		}},
+		{"not found", "arr.*.z", nil},
+	}
+
+	doc := pathTestDocument()
+	rdr, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookups := map[string]func(string) ([]*Value, error){
+				"Document": doc.LookupPathAll,
+				"Reader":   Reader(rdr).LookupPathAll,
+			}
+			for name, lookup := range lookups {
+				got, err := lookup(tc.path)
+				if err != nil {
+					t.Fatalf("%s: Unexpected error: %v", name, err)
+				}
+				if len(got) != len(tc.want) {
+					t.Fatalf("%s: Unexpected number of values. got %d; want %d", name, len(got), len(tc.want))
+				}
+				for i := range got {
+					if !got[i].Equal(tc.want[i]) {
+						t.Errorf("%s: Value %d does not match. got %v; want %v", name, i, got[i], tc.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pointer bool
+		path    string
+		want    *Document
+		err     error
+	}{
+		{
+			"replace", false, "a",
+			NewDocument(C.Int32("a", 0), C.Int32("b", 1)),
+			nil,
+		},
+		{
+			"replace second", false, "b",
+			NewDocument(C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1))), C.Int32("b", 0)),
+			nil,
+		},
+		{
+			"nested", false, "a.x.0",
+			NewDocument(C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(0))), C.Int32("b", 1)),
+			nil,
+		},
+		{
+			"new key", false, "a.y",
+			NewDocument(C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1)), C.Int32("y", 0)), C.Int32("b", 1)),
+			nil,
+		},
+		{
+			"create documents", false, "c.d.e",
+			NewDocument(
+				C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1))),
+				C.Int32("b", 1),
+				C.SubDocumentFromElements("c", C.SubDocumentFromElements("d", C.Int32("e", 0))),
+			),
+			nil,
+		},
+		{
+			"create array", false, "c.1.d",
+			NewDocument(
+				C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1))),
+				C.Int32("b", 1),
+				C.ArrayFromElements("c", AC.Null(), AC.DocumentFromElements(C.Int32("d", 0))),
+			),
+			nil,
+		},
+		{
+			"pad array", false, "a.x.2",
+			NewDocument(C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1), AC.Null(), AC.Int32(0))), C.Int32("b", 1)),
+			nil,
+		},
+		{
+			"pointer append", true, "/a/x/-",
+			NewDocument(C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1), AC.Int32(0))), C.Int32("b", 1)),
+			nil,
+		},
+		{"through scalar", false, "b.c", nil, ErrInvalidDepthTraversal},
+		{"invalid index", false, "a.x.y", nil, ErrInvalidIndex},
+		{"padding limit", false, "a.x.100000000", nil, ErrPaddingLimit},
+		{"empty segment", false, "a.", nil, ErrInvalidPath},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := NewDocument(C.SubDocumentFromElements("a", C.ArrayFromElements("x", AC.Int32(1))), C.Int32("b", 1))
+			set := doc.SetPath
+			if tc.pointer {
+				set = doc.SetPointer
+			}
+
+			err := set(tc.path, AC.Int32(0))
+			if err != tc.err {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+			if tc.want != nil && !doc.Equal(tc.want) {
+				t.Errorf("Documents do not match. got %v; want %v", Diff(doc, tc.want, nil), tc.want)
+			}
+		})
+	}
+}
+
+func TestDeletePath(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pointer bool
+		path    string
+		want    *Document
+		deleted *Value
+		err     error
+	}{
+		{
+			"nested", false, "a.c",
+			NewDocument(C.SubDocumentFromElements("a", C.Int32("b", 1)), C.ArrayFromElements("x", AC.Int32(2), AC.Int32(3))),
+			AC.Int32(4),
+			nil,
+		},
+		{
+			"array element", false, "x.0",
+			NewDocument(C.SubDocumentFromElements("a", C.Int32("b", 1), C.Int32("c", 4)), C.ArrayFromElements("x", AC.Int32(3))),
+			AC.Int32(2),
+			nil,
+		},
+		{
+			"pointer", true, "/a",
+			NewDocument(C.ArrayFromElements("x", AC.Int32(2), AC.Int32(3))),
+			AC.DocumentFromElements(C.Int32("b", 1), C.Int32("c", 4)),
+			nil,
+		},
+		{"not found", false, "a.z", nil, nil, ErrElementNotFound},
+		{"out of bounds", false, "x.2", nil, nil, ErrElementNotFound},
+		{"through scalar", false, "a.b.c", nil, nil, ErrInvalidDepthTraversal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := NewDocument(
+				C.SubDocumentFromElements("a", C.Int32("b", 1), C.Int32("c", 4)),
+				C.ArrayFromElements("x", AC.Int32(2), AC.Int32(3)),
+			)
+			del := doc.DeletePath
+			if tc.pointer {
+				del = doc.DeletePointer
+			}
+
+			got, err := del(tc.path)
+			if err != tc.err {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+			if !got.Equal(tc.deleted) {
+				t.Errorf("Deleted value does not match. got %v; want %v", got, tc.deleted)
+			}
+			if tc.want != nil && !doc.Equal(tc.want) {
+				t.Errorf("Documents do not match. got %v; want %v", Diff(doc, tc.want, nil), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/expression"
+)
+
+// accumulator computes a value from the documents of a group.
+type accumulator interface {
+	// add adds the value of the accumulator's expression for a document, which is nil if it is
+	// missing.
+	add(v *bson.Value) error
+	value() *bson.Value
+}
+
+// accumulators are the functions which create a new accumulator for each group.
+var accumulators = map[string]func() accumulator{
+	"$sum":      func() accumulator { return new(sumAccumulator) },
+	"$avg":      func() accumulator { return new(avgAccumulator) },
+	"$min":      func() accumulator { return &extremeAccumulator{sign: -1} },
+	"$max":      func() accumulator { return &extremeAccumulator{sign: 1} },
+	"$push":     func() accumulator { return new(pushAccumulator) },
+	"$addToSet": func() accumulator { return &addToSetAccumulator{seen: make(map[string]bool)} },
+	"$first":    func() accumulator { return new(firstAccumulator) },
+	"$last":     func() accumulator { return new(lastAccumulator) },
+}
+
+// groupField is an output field of $group computed by an accumulator.
+type groupField struct {
+	name string
+	op   string
+	e    *expression.Expression
+}
+
+// group is the state of a group while $group reads its input.
+type group struct {
+	id   *bson.Value
+	accs []accumulator
+}
+
+// compileGroup compiles $group, which is a document with the group key expression _id and an
+// accumulator for each other field, such as {"_id": "$a", "total": {"$sum": "$b"}}.
+func compileGroup(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	itr, err := v.ReaderDocument().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var id *expression.Expression
+	var fields []groupField
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key := elem.Key()
+		kpath := joinPath(path, key)
+
+		if key == "_id" {
+			id, err = compileExpression(elem.Value(), kpath)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if key == "" || strings.HasPrefix(key, "$") || strings.Contains(key, ".") {
+			return nil, CompileError{Path: kpath, Message: "invalid field name"}
+		}
+
+		if elem.Value().Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: kpath, Message: "must be an accumulator object"}
+		}
+		spec := elem.Value().ReaderDocument()
+		acc, err := spec.ElementAt(0)
+		if err == bson.ErrOutOfBounds {
+			return nil, CompileError{Path: kpath, Message: "must be an accumulator object"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := spec.ElementAt(1); err != bson.ErrOutOfBounds {
+			return nil, CompileError{Path: kpath, Message: "an accumulator must be the only field of its object"}
+		}
+
+		op := acc.Key()
+		if _, ok := accumulators[op]; !ok {
+			return nil, CompileError{Path: joinPath(kpath, op), Message: fmt.Sprintf("unknown accumulator %s", op)}
+		}
+		e, err := compileExpression(acc.Value(), joinPath(kpath, op))
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, groupField{name: key, op: op, e: e})
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+	if id == nil {
+		return nil, CompileError{Path: path, Message: "_id is required"}
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return blocking(input, func(docs []bson.Reader) ([]bson.Reader, error) {
+			return groupDocuments(docs, id, fields)
+		})
+	}), nil
+}
+
+// groupDocuments groups docs by the value of id and returns a document for each group, in the
+// order in which the groups were first seen.
+func groupDocuments(docs []bson.Reader, id *expression.Expression, fields []groupField) ([]bson.Reader, error) {
+	var groups []*group
+	index := make(map[string]*group)
+	for _, doc := range docs {
+		v, err := id.Evaluate(doc)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = bson.AC.Null()
+		}
+
+		key, err := valueKey(v)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := index[key]
+		if !ok {
+			g = &group{id: copyValue(v)}
+			for _, f := range fields {
+				g.accs = append(g.accs, accumulators[f.op]())
+			}
+			index[key] = g
+			groups = append(groups, g)
+		}
+
+		for i, f := range fields {
+			v, err := f.e.Evaluate(doc)
+			if err != nil {
+				return nil, err
+			}
+			if err = g.accs[i].add(v); err != nil {
+				return nil, fmt.Errorf("$group: %s: %v", f.name, err)
+			}
+		}
+	}
+
+	out := make([]bson.Reader, 0, len(groups))
+	for _, g := range groups {
+		doc := bson.NewDocument(bson.C.Value("_id", g.id))
+		for i, f := range fields {
+			doc.Append(bson.C.Value(f.name, g.accs[i].value()))
+		}
+
+		b, err := doc.MarshalBSON()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+
+	return out, nil
+}
+
+// valueKey returns a string which is equal for two values if and only if they are equivalent.
+func valueKey(v *bson.Value) (string, error) {
+	b, err := bson.NewDocument(bson.C.Value("", v)).MarshalBSON()
+	if err != nil {
+		return "", err
+	}
+
+	c, err := bson.CanonicalizeWithOptions(b, &bson.CanonicalOptions{PreserveKeyOrder: true})
+	if err != nil {
+		return "", err
+	}
+
+	return string(c), nil
+}
+
+// copyValue returns a copy of v which remains valid after the document containing it changes.
+func copyValue(v *bson.Value) *bson.Value {
+	return bson.C.Value("", v).Value()
+}
+
+type sumAccumulator struct {
+	sum expression.Sum
+}
+
+func (sa *sumAccumulator) add(v *bson.Value) error {
+	sa.sum.Add(v)
+	return nil
+}
+
+func (sa *sumAccumulator) value() *bson.Value {
+	return sa.sum.Value()
+}
+
+type avgAccumulator struct {
+	sum expression.Sum
+}
+
+func (aa *avgAccumulator) add(v *bson.Value) error {
+	aa.sum.Add(v)
+	return nil
+}
+
+func (aa *avgAccumulator) value() *bson.Value {
+	return aa.sum.Average()
+}
+
+// extremeAccumulator is $min if sign is -1 or $max if sign is 1. Null and missing values are
+// ignored.
+type extremeAccumulator struct {
+	sign int
+	best *bson.Value
+}
+
+func (ea *extremeAccumulator) add(v *bson.Value) error {
+	if v == nil || v.Type() == bson.TypeNull {
+		return nil
+	}
+	if ea.best == nil || bson.CompareValues(v, ea.best) == ea.sign {
+		ea.best = copyValue(v)
+	}
+
+	return nil
+}
+
+func (ea *extremeAccumulator) value() *bson.Value {
+	if ea.best == nil {
+		return bson.AC.Null()
+	}
+
+	return ea.best
+}
+
+type pushAccumulator struct {
+	values []*bson.Value
+}
+
+func (pa *pushAccumulator) add(v *bson.Value) error {
+	if v != nil {
+		pa.values = append(pa.values, copyValue(v))
+	}
+
+	return nil
+}
+
+func (pa *pushAccumulator) value() *bson.Value {
+	return bson.AC.ArrayFromValues(pa.values...)
+}
+
+type addToSetAccumulator struct {
+	seen   map[string]bool
+	values []*bson.Value
+}
+
+func (aa *addToSetAccumulator) add(v *bson.Value) error {
+	if v == nil {
+		return nil
+	}
+
+	key, err := valueKey(v)
+	if err != nil {
+		return err
+	}
+	if !aa.seen[key] {
+		aa.seen[key] = true
+		aa.values = append(aa.values, copyValue(v))
+	}
+
+	return nil
+}
+
+func (aa *addToSetAccumulator) value() *bson.Value {
+	return bson.AC.ArrayFromValues(aa.values...)
+}
+
+// firstAccumulator is $first, whose value is null if the expression is missing for the first
+// document.
+type firstAccumulator struct {
+	v    *bson.Value
+	seen bool
+}
+
+func (fa *firstAccumulator) add(v *bson.Value) error {
+	if !fa.seen {
+		fa.seen = true
+		if v != nil {
+			fa.v = copyValue(v)
+		}
+	}
+
+	return nil
+}
+
+func (fa *firstAccumulator) value() *bson.Value {
+	if fa.v == nil {
+		return bson.AC.Null()
+	}
+
+	return fa.v
+}
+
+type lastAccumulator struct {
+	v *bson.Value
+}
+
+func (la *lastAccumulator) add(v *bson.Value) error {
+	la.v = nil
+	if v != nil {
+		la.v = copyValue(v)
+	}
+
+	return nil
+}
+
+func (la *lastAccumulator) value() *bson.Value {
+	if la.v == nil {
+		return bson.AC.Null()
+	}
+
+	return la.v
+}
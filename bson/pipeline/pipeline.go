@@ -0,0 +1,292 @@
+// Package pipeline runs MongoDB aggregation pipelines over streams of BSON documents.
+//
+// A pipeline is an array of stage documents, such as
+//
+//	[
+//		{"$match": {"status": "A"}},
+//		{"$group": {"_id": "$cust_id", "total": {"$sum": "$amount"}}},
+//		{"$sort": {"total": -1}}
+//	]
+//
+// which is compiled once with Compile or CompileReader and can then be run over any number of
+// document streams. The supported stages are $match, $project, $addFields, $set, $unset, $group,
+// $sort, $skip, $limit, $unwind, $count, $replaceRoot, and $facet. Documents flow through the
+// stages one at a time, except for $group, $sort, $count, and $facet, which must read all of
+// their input before producing any output.
+//
+// Stages which compute values, such as $group and $addFields, accept aggregation expressions,
+// which are evaluated by the expression package.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/expression"
+)
+
+// errEndOfPipeline is never returned to the caller. It is used by stages to signal that they have
+// produced their last document.
+var errEndOfPipeline = errors.New("end of pipeline")
+
+// CompileError is returned when a pipeline is invalid.
+type CompileError struct {
+	// Path is the dotted path of the invalid value within the pipeline, starting with the index
+	// of the stage.
+	Path    string
+	Message string
+}
+
+func (ce CompileError) Error() string {
+	if ce.Path == "" {
+		return "pipeline: " + ce.Message
+	}
+
+	return "pipeline: " + ce.Path + ": " + ce.Message
+}
+
+// Iterator is a stream of documents.
+type Iterator interface {
+	// Next advances to the next document and reports whether there is one. When Next returns
+	// false, Err reports whether the stream ended because of an error.
+	Next() bool
+
+	// Document returns the current document. It is only valid until the next call to Next.
+	Document() bson.Reader
+
+	Err() error
+}
+
+// SliceIterator returns an Iterator over docs.
+func SliceIterator(docs []bson.Reader) Iterator {
+	return &sliceIterator{docs: docs, pos: -1}
+}
+
+type sliceIterator struct {
+	docs []bson.Reader
+	pos  int
+}
+
+func (si *sliceIterator) Next() bool {
+	if si.pos+1 >= len(si.docs) {
+		si.pos = len(si.docs)
+		return false
+	}
+
+	si.pos++
+	return true
+}
+
+func (si *sliceIterator) Document() bson.Reader {
+	return si.docs[si.pos]
+}
+
+func (si *sliceIterator) Err() error {
+	return nil
+}
+
+// funcIterator is an Iterator whose documents are produced by next, which returns
+// errEndOfPipeline after its last document.
+type funcIterator struct {
+	next func() (bson.Reader, error)
+	doc  bson.Reader
+	err  error
+	done bool
+}
+
+func (fi *funcIterator) Next() bool {
+	if fi.done {
+		return false
+	}
+
+	fi.doc, fi.err = fi.next()
+	if fi.err != nil {
+		fi.done, fi.doc = true, nil
+		if fi.err == errEndOfPipeline {
+			fi.err = nil
+		}
+		return false
+	}
+
+	return true
+}
+
+func (fi *funcIterator) Document() bson.Reader {
+	return fi.doc
+}
+
+func (fi *funcIterator) Err() error {
+	return fi.err
+}
+
+// Collect reads every document from itr.
+func Collect(itr Iterator) ([]bson.Reader, error) {
+	var docs []bson.Reader
+	for itr.Next() {
+		docs = append(docs, itr.Document())
+	}
+
+	return docs, itr.Err()
+}
+
+// Pipeline is a compiled aggregation pipeline. A Pipeline is safe for concurrent use.
+type Pipeline struct {
+	stages []stage
+}
+
+// stage is a compiled pipeline stage.
+type stage interface {
+	run(input Iterator) Iterator
+}
+
+// Compile compiles a pipeline given as an array of stage documents.
+func Compile(pipeline *bson.Array) (*Pipeline, error) {
+	b, err := pipeline.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileReader(b)
+}
+
+// CompileReader compiles a pipeline given as an array of stage documents provided as a
+// bson.Reader. See Compile.
+func CompileReader(pipeline bson.Reader) (*Pipeline, error) {
+	_, err := pipeline.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	stages, err := compileStages(pipeline, "", true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pipeline{stages: stages}, nil
+}
+
+// Run returns an Iterator over the output of the pipeline when given the documents read from
+// input. The documents are processed as the returned Iterator is advanced. An error is returned by
+// the Iterator if a document read from input is invalid or a stage fails.
+func (p *Pipeline) Run(input Iterator) Iterator {
+	itr := validating(input)
+	for _, s := range p.stages {
+		itr = s.run(itr)
+	}
+
+	return itr
+}
+
+// Aggregate runs the pipeline over docs and returns its output.
+func (p *Pipeline) Aggregate(docs []bson.Reader) ([]bson.Reader, error) {
+	return Collect(p.Run(SliceIterator(docs)))
+}
+
+// validating returns an Iterator over the documents of input which fails if one of them is
+// invalid, so that the stages can assume valid documents.
+func validating(input Iterator) Iterator {
+	return &funcIterator{next: func() (bson.Reader, error) {
+		if !input.Next() {
+			if err := input.Err(); err != nil {
+				return nil, err
+			}
+			return nil, errEndOfPipeline
+		}
+
+		doc := input.Document()
+		_, err := doc.Validate()
+		return doc, err
+	}}
+}
+
+// compileStages compiles the stages of the pipeline array at path. $facet is only allowed if
+// facet is true.
+func compileStages(pipeline bson.Reader, path string, facet bool) ([]stage, error) {
+	itr, err := pipeline.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var stages []stage
+	for i := 0; itr.Next(); i++ {
+		ipath := joinPath(path, strconv.Itoa(i))
+		v := itr.Element().Clone().Value()
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: ipath, Message: "stage must be an object"}
+		}
+
+		spec := v.ReaderDocument()
+		elem, err := spec.ElementAt(0)
+		if err == bson.ErrOutOfBounds {
+			return nil, CompileError{Path: ipath, Message: "stage must contain exactly one field"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := spec.ElementAt(1); err != bson.ErrOutOfBounds {
+			return nil, CompileError{Path: ipath, Message: "stage must contain exactly one field"}
+		}
+
+		name := elem.Key()
+		spath := joinPath(ipath, name)
+		if name == "$facet" && !facet {
+			return nil, CompileError{Path: spath, Message: "$facet cannot be used within $facet"}
+		}
+
+		compile, ok := stageCompilers[name]
+		if !ok {
+			return nil, CompileError{Path: spath, Message: fmt.Sprintf("unknown stage %s", name)}
+		}
+		s, err := compile(elem.Value(), spath)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, s)
+	}
+
+	return stages, itr.Err()
+}
+
+// stageCompilers are the functions which compile each stage given its value and path.
+var stageCompilers map[string]func(*bson.Value, string) (stage, error)
+
+func init() {
+	stageCompilers = map[string]func(*bson.Value, string) (stage, error){
+		"$match":       compileMatch,
+		"$project":     compileProject,
+		"$addFields":   compileAddFields,
+		"$set":         compileAddFields,
+		"$unset":       compileUnset,
+		"$group":       compileGroup,
+		"$sort":        compileSort,
+		"$skip":        compileSkip,
+		"$limit":       compileLimit,
+		"$unwind":      compileUnwind,
+		"$count":       compileCount,
+		"$replaceRoot": compileReplaceRoot,
+		"$facet":       compileFacet,
+	}
+}
+
+// compileExpression compiles the aggregation expression v found at path within the pipeline.
+func compileExpression(v *bson.Value, path string) (*expression.Expression, error) {
+	e, err := expression.Compile(v)
+	if err != nil {
+		return nil, prefixError(err, path)
+	}
+
+	return e, nil
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	if key == "" {
+		return parent
+	}
+
+	return parent + "." + key
+}
@@ -0,0 +1,322 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func doc(elems ...*bson.Element) *bson.Document {
+	return bson.NewDocument(elems...)
+}
+
+func stageDoc(name string, elems ...*bson.Element) *bson.Value {
+	return AC.DocumentFromElements(C.SubDocumentFromElements(name, elems...))
+}
+
+func readers(t *testing.T, docs ...*bson.Document) []bson.Reader {
+	t.Helper()
+
+	rdrs := make([]bson.Reader, 0, len(docs))
+	for _, d := range docs {
+		b, err := d.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rdrs = append(rdrs, b)
+	}
+
+	return rdrs
+}
+
+func TestAggregate(t *testing.T) {
+	input := []*bson.Document{
+		doc(C.Int32("_id", 1), C.String("item", "a"), C.Int32("qty", 2), C.Double("price", 1.5),
+			C.ArrayFromElements("tags", AC.String("x"), AC.String("y"))),
+		doc(C.Int32("_id", 2), C.String("item", "b"), C.Int32("qty", 5), C.Double("price", 3),
+			C.ArrayFromElements("tags")),
+		doc(C.Int32("_id", 3), C.String("item", "a"), C.Int64("qty", 1), C.Double("price", 1.5),
+			C.String("tags", "z")),
+	}
+
+	testCases := []struct {
+		name     string
+		pipeline []*bson.Value
+		want     []*bson.Document
+	}{
+		{"empty", nil, input},
+		{
+			"$match",
+			[]*bson.Value{stageDoc("$match", C.String("item", "a"))},
+			[]*bson.Document{input[0], input[2]},
+		},
+		{
+			"$project inclusion",
+			[]*bson.Value{stageDoc("$project", C.Boolean("item", true))},
+			[]*bson.Document{
+				doc(C.Int32("_id", 1), C.String("item", "a")),
+				doc(C.Int32("_id", 2), C.String("item", "b")),
+				doc(C.Int32("_id", 3), C.String("item", "a")),
+			},
+		},
+		{
+			"$project exclusion",
+			[]*bson.Value{stageDoc("$project", C.Int32("tags", 0), C.Int32("price", 0), C.Int32("qty", 0))},
+			[]*bson.Document{
+				doc(C.Int32("_id", 1), C.String("item", "a")),
+				doc(C.Int32("_id", 2), C.String("item", "b")),
+				doc(C.Int32("_id", 3), C.String("item", "a")),
+			},
+		},
+		{
+			"$project expressions",
+			[]*bson.Value{
+				stageDoc("$match", C.Int32("_id", 1)),
+				stageDoc("$project", C.Int32("_id", 0), C.String("name", "$item"),
+					C.SubDocumentFromElements("detail", C.String("n", "$qty"), C.String("missing", "$nope")),
+					C.SubDocumentFromElements("lit", C.String("$literal", "$item"))),
+			},
+			[]*bson.Document{
+				doc(C.String("name", "a"), C.SubDocumentFromElements("detail", C.Int32("n", 2)), C.String("lit", "$item")),
+			},
+		},
+		{
+			"$addFields",
+			[]*bson.Value{
+				stageDoc("$match", C.Int32("_id", 2)),
+				stageDoc("$addFields", C.String("copy", "$item"), C.String("qty", "$missing"),
+					C.ArrayFromElements("pair", AC.String("$_id"), AC.String("$nope"))),
+			},
+			[]*bson.Document{
+				doc(C.Int32("_id", 2), C.String("item", "b"), C.Double("price", 3),
+					C.ArrayFromElements("tags"), C.String("copy", "b"),
+					C.ArrayFromElements("pair", AC.Int32(2), AC.Null())),
+			},
+		},
+		{
+			"$set dotted",
+			[]*bson.Value{
+				stageDoc("$match", C.Int32("_id", 3)),
+				stageDoc("$set", C.SubDocumentFromElements("a", C.String("b", "$$ROOT.item"))),
+				stageDoc("$project", C.Int32("a", 1)),
+			},
+			[]*bson.Document{doc(C.Int32("_id", 3), C.SubDocumentFromElements("a", C.String("b", "a")))},
+		},
+		{
+			"$unset",
+			[]*bson.Value{
+				AC.DocumentFromElements(C.ArrayFromElements("$unset", AC.String("tags"), AC.String("price"), AC.String("qty"))),
+				AC.DocumentFromElements(C.String("$unset", "_id")),
+			},
+			[]*bson.Document{doc(C.String("item", "a")), doc(C.String("item", "b")), doc(C.String("item", "a"))},
+		},
+		{
+			"$group",
+			[]*bson.Value{stageDoc("$group", C.String("_id", "$item"),
+				C.SubDocumentFromElements("total", C.String("$sum", "$qty")),
+				C.SubDocumentFromElements("count", C.Int32("$sum", 1)),
+				C.SubDocumentFromElements("avg", C.String("$avg", "$price")),
+				C.SubDocumentFromElements("min", C.String("$min", "$qty")),
+				C.SubDocumentFromElements("max", C.String("$max", "$_id")),
+				C.SubDocumentFromElements("ids", C.String("$push", "$_id")),
+				C.SubDocumentFromElements("prices", C.String("$addToSet", "$price")),
+				C.SubDocumentFromElements("first", C.String("$first", "$_id")),
+				C.SubDocumentFromElements("last", C.String("$last", "$tags")),
+			)},
+			[]*bson.Document{
+				doc(C.String("_id", "a"), C.Int64("total", 3), C.Int32("count", 2), C.Double("avg", 1.5),
+					C.Int64("min", 1), C.Int32("max", 3), C.ArrayFromElements("ids", AC.Int32(1), AC.Int32(3)),
+					C.ArrayFromElements("prices", AC.Double(1.5)), C.Int32("first", 1), C.String("last", "z")),
+				doc(C.String("_id", "b"), C.Int32("total", 5), C.Int32("count", 1), C.Double("avg", 3),
+					C.Int32("min", 5), C.Int32("max", 2), C.ArrayFromElements("ids", AC.Int32(2)),
+					C.ArrayFromElements("prices", AC.Double(3)), C.Int32("first", 2), C.ArrayFromElements("last")),
+			},
+		},
+		{
+			"$group null _id",
+			[]*bson.Value{stageDoc("$group", C.Null("_id"), C.SubDocumentFromElements("n", C.String("$sum", "$price")))},
+			[]*bson.Document{doc(C.Null("_id"), C.Double("n", 6))},
+		},
+		{
+			"$group equivalent keys",
+			[]*bson.Value{stageDoc("$group", C.String("_id", "$price"))},
+			[]*bson.Document{doc(C.Double("_id", 1.5)), doc(C.Double("_id", 3))},
+		},
+		{
+			"$sort",
+			[]*bson.Value{stageDoc("$sort", C.Int32("item", -1), C.Int32("qty", 1))},
+			[]*bson.Document{input[1], input[2], input[0]},
+		},
+		{
+			"$skip and $limit",
+			[]*bson.Value{
+				AC.DocumentFromElements(C.Int32("$skip", 1)),
+				AC.DocumentFromElements(C.Int64("$limit", 1)),
+			},
+			[]*bson.Document{input[1]},
+		},
+		{
+			"$unwind",
+			[]*bson.Value{
+				AC.DocumentFromElements(C.String("$unwind", "$tags")),
+				stageDoc("$project", C.Int32("tags", 1)),
+			},
+			[]*bson.Document{
+				doc(C.Int32("_id", 1), C.String("tags", "x")),
+				doc(C.Int32("_id", 1), C.String("tags", "y")),
+				doc(C.Int32("_id", 3), C.String("tags", "z")),
+			},
+		},
+		{
+			"$unwind options",
+			[]*bson.Value{
+				stageDoc("$unwind", C.String("path", "$tags"), C.String("includeArrayIndex", "i"),
+					C.Boolean("preserveNullAndEmptyArrays", true)),
+				stageDoc("$project", C.Int32("tags", 1), C.Int32("i", 1)),
+			},
+			[]*bson.Document{
+				doc(C.Int32("_id", 1), C.String("tags", "x"), C.Int64("i", 0)),
+				doc(C.Int32("_id", 1), C.String("tags", "y"), C.Int64("i", 1)),
+				doc(C.Int32("_id", 2), C.Null("i")),
+				doc(C.Int32("_id", 3), C.String("tags", "z"), C.Null("i")),
+			},
+		},
+		{
+			"operator expressions",
+			[]*bson.Value{
+				stageDoc("$group", C.String("_id", "$item"), C.SubDocumentFromElements("revenue",
+					C.SubDocumentFromElements("$sum", C.ArrayFromElements("$multiply", AC.String("$qty"), AC.String("$price"))))),
+				stageDoc("$project", C.SubDocumentFromElements("label",
+					C.ArrayFromElements("$concat", AC.String("$_id"), AC.String(": "),
+						AC.DocumentFromElements(C.String("$toString", "$revenue"))))),
+			},
+			[]*bson.Document{
+				doc(C.String("_id", "a"), C.String("label", "a: 4.5")),
+				doc(C.String("_id", "b"), C.String("label", "b: 15")),
+			},
+		},
+		{
+			"$count",
+			[]*bson.Value{AC.DocumentFromElements(C.String("$count", "n"))},
+			[]*bson.Document{doc(C.Int32("n", 3))},
+		},
+		{
+			"$count no documents",
+			[]*bson.Value{
+				stageDoc("$match", C.String("item", "c")),
+				AC.DocumentFromElements(C.String("$count", "n")),
+			},
+			nil,
+		},
+		{
+			"$replaceRoot",
+			[]*bson.Value{
+				stageDoc("$match", C.Int32("_id", 2)),
+				stageDoc("$replaceRoot", C.SubDocumentFromElements("newRoot", C.String("i", "$item"))),
+			},
+			[]*bson.Document{doc(C.String("i", "b"))},
+		},
+		{
+			"$facet",
+			[]*bson.Value{stageDoc("$facet",
+				C.ArrayFromElements("count", AC.DocumentFromElements(C.String("$count", "n"))),
+				C.ArrayFromElements("first",
+					AC.DocumentFromElements(C.Int32("$limit", 1)),
+					stageDoc("$project", C.Int32("item", 1))),
+			)},
+			[]*bson.Document{doc(
+				C.ArrayFromElements("count", AC.DocumentFromElements(C.Int32("n", 3))),
+				C.ArrayFromElements("first", AC.DocumentFromElements(C.Int32("_id", 1), C.String("item", "a"))),
+			)},
+		},
+	}
+
+	docs := readers(t, input...)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Compile(bson.NewArray(tc.pipeline...))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := p.Aggregate(docs)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Unexpected number of documents. got %d; want %d", len(got), len(tc.want))
+			}
+			for i, r := range got {
+				d, err := bson.ReadDocument(r)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				bsontest.AssertEqual(t, d, tc.want[i])
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pipeline []*bson.Value
+		want     error
+	}{
+		{"not a document", []*bson.Value{AC.Int32(1)}, CompileError{Path: "0", Message: "stage must be an object"}},
+		{"two fields", []*bson.Value{AC.DocumentFromElements(C.Int32("$skip", 1), C.Int32("$limit", 1))},
+			CompileError{Path: "0", Message: "stage must contain exactly one field"}},
+		{"unknown stage", []*bson.Value{stageDoc("$lookup")}, CompileError{Path: "0.$lookup", Message: "unknown stage $lookup"}},
+		{"$match operator", []*bson.Value{stageDoc("$match", C.SubDocumentFromElements("a", C.Int32("$bogus", 1)))},
+			CompileError{Path: "0.$match.a.$bogus", Message: "unknown operator"}},
+		{"$limit zero", []*bson.Value{AC.DocumentFromElements(C.Int32("$limit", 0))},
+			CompileError{Path: "0.$limit", Message: "must be a positive integer"}},
+		{"$skip negative", []*bson.Value{AC.DocumentFromElements(C.Int32("$skip", -1))},
+			CompileError{Path: "0.$skip", Message: "must be a non-negative integer"}},
+		{"$group without _id", []*bson.Value{stageDoc("$group", C.SubDocumentFromElements("n", C.Int32("$sum", 1)))},
+			CompileError{Path: "0.$group", Message: "_id is required"}},
+		{"$group unknown accumulator", []*bson.Value{stageDoc("$group", C.Null("_id"), C.SubDocumentFromElements("n", C.Int32("$median", 1)))},
+			CompileError{Path: "0.$group.n.$median", Message: "unknown accumulator $median"}},
+		{"$project mixed", []*bson.Value{stageDoc("$project", C.Int32("a", 0), C.String("b", "$c"))},
+			CompileError{Path: "0.$project", Message: "cannot set fields to expressions in an exclusion projection"}},
+		{"$unwind path", []*bson.Value{AC.DocumentFromElements(C.String("$unwind", "tags"))},
+			CompileError{Path: "0.$unwind", Message: "path must be a field path starting with '$'"}},
+		{"$count name", []*bson.Value{AC.DocumentFromElements(C.String("$count", "a.b"))},
+			CompileError{Path: "0.$count", Message: "must be a non-empty field name without '$' or '.'"}},
+		{"unknown variable", []*bson.Value{stageDoc("$addFields", C.String("a", "$$NOW"))},
+			CompileError{Path: "0.$addFields.a", Message: `undefined variable "NOW"`}},
+		{"nested $facet", []*bson.Value{stageDoc("$facet", C.ArrayFromElements("f", stageDoc("$facet")))},
+			CompileError{Path: "0.$facet.f.0.$facet", Message: "$facet cannot be used within $facet"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(bson.NewArray(tc.pipeline...))
+			if err != tc.want {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	p, err := Compile(bson.NewArray(stageDoc("$replaceRoot", C.String("newRoot", "$a"))))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	_, err = p.Aggregate(readers(t, doc(C.Int32("a", 1))))
+	if err == nil {
+		t.Errorf("Expected an error for a newRoot which is not a document")
+	}
+
+	_, err = p.Aggregate([]bson.Reader{{0x05, 0x00}})
+	if err == nil {
+		t.Errorf("Expected an error for an invalid document")
+	}
+}
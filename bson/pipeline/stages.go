@@ -0,0 +1,733 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/expression"
+	"github.com/skriptble/wilson/bson/projection"
+	"github.com/skriptble/wilson/bson/query"
+)
+
+// transform returns an Iterator over the results of f for each document of input. Documents for
+// which f returns nil are skipped, and f may return errEndOfPipeline to end the output early.
+func transform(input Iterator, f func(bson.Reader) (bson.Reader, error)) Iterator {
+	return &funcIterator{next: func() (bson.Reader, error) {
+		for input.Next() {
+			out, err := f(input.Document())
+			if err != nil {
+				return nil, err
+			}
+			if out != nil {
+				return out, nil
+			}
+		}
+		if err := input.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errEndOfPipeline
+	}}
+}
+
+// blocking returns an Iterator over the documents returned by f, which is called with every
+// document of input once the Iterator is first advanced. The documents given to f are copies which
+// remain valid.
+func blocking(input Iterator, f func([]bson.Reader) ([]bson.Reader, error)) Iterator {
+	var out []bson.Reader
+	started := false
+	return &funcIterator{next: func() (bson.Reader, error) {
+		if !started {
+			started = true
+
+			var docs []bson.Reader
+			for input.Next() {
+				docs = append(docs, append(bson.Reader(nil), input.Document()...))
+			}
+			if err := input.Err(); err != nil {
+				return nil, err
+			}
+
+			var err error
+			out, err = f(docs)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(out) == 0 {
+			return nil, errEndOfPipeline
+		}
+		doc := out[0]
+		out = out[1:]
+		return doc, nil
+	}}
+}
+
+// stageFunc is a stage implemented by a function.
+type stageFunc func(Iterator) Iterator
+
+func (sf stageFunc) run(input Iterator) Iterator {
+	return sf(input)
+}
+
+// runStages returns an Iterator over the output of stages when given the documents of input.
+func runStages(stages []stage, input Iterator) Iterator {
+	for _, s := range stages {
+		input = s.run(input)
+	}
+
+	return input
+}
+
+// prefixError converts an error from compiling a query, sort, projection, or expression at path
+// into a CompileError.
+func prefixError(err error, path string) error {
+	switch e := err.(type) {
+	case query.CompileError:
+		return CompileError{Path: joinPath(path, e.Path), Message: e.Message}
+	case projection.CompileError:
+		return CompileError{Path: joinPath(path, e.Path), Message: e.Message}
+	case expression.CompileError:
+		return CompileError{Path: joinPath(path, e.Path), Message: e.Message}
+	}
+
+	return err
+}
+
+func compileMatch(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	m, err := query.CompileReader(v.ReaderDocument())
+	if err != nil {
+		return nil, prefixError(err, path)
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			ok, err := m.Matches(doc)
+			if err != nil || !ok {
+				return nil, err
+			}
+			return doc, nil
+		})
+	}), nil
+}
+
+// computedField is a field set to the value of an expression.
+type computedField struct {
+	path string
+	e    *expression.Expression
+}
+
+// setFields returns a copy of doc with each of the fields set to the value of its expression for
+// doc. Fields which evaluate to missing values are removed. base is the document the fields are
+// set in, which is doc if it is nil.
+func setFields(doc, base bson.Reader, fields []computedField) (bson.Reader, error) {
+	values := make([]*bson.Value, len(fields))
+	for i, f := range fields {
+		var err error
+		values[i], err = f.e.Evaluate(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if base == nil {
+		base = doc
+	}
+	d, err := bson.ReadDocument(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range fields {
+		if values[i] == nil {
+			_, err = d.DeletePath(f.path)
+			if err == bson.ErrElementNotFound || err == bson.ErrInvalidDepthTraversal {
+				err = nil
+			}
+		} else {
+			err = d.SetPath(f.path, values[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot set %s: %v", f.path, err)
+		}
+	}
+
+	return d.MarshalBSON()
+}
+
+// compileProject compiles $project, which is a projection in which fields may also be set to
+// expressions. Fields set to expressions are added after the fields included by the projection.
+func compileProject(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	spec := bson.NewDocument()
+	var fields []computedField
+	var include, exclude, excludeID bool
+	var walk func(r bson.Reader, prefix, path string) error
+	walk = func(r bson.Reader, prefix, path string) error {
+		itr, err := r.Iterator()
+		if err != nil {
+			return err
+		}
+
+		for itr.Next() {
+			elem := itr.Element().Clone()
+			key, v := elem.Key(), elem.Value()
+			field := joinPath(prefix, key)
+			kpath := joinPath(path, key)
+			if strings.HasPrefix(key, "$") {
+				return CompileError{Path: kpath, Message: "unknown operator"}
+			}
+
+			switch v.Type() {
+			case bson.TypeBoolean, bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+				included := bson.CompareValues(v, bson.AC.Boolean(false)) != 0
+				if v.Type() != bson.TypeBoolean {
+					included = bson.CompareValues(v, bson.AC.Int32(0)) != 0
+				}
+				switch {
+				case field == "_id":
+					excludeID = !included
+				case included:
+					include = true
+				default:
+					exclude = true
+				}
+				spec.Append(bson.C.Value(field, v))
+				continue
+			case bson.TypeEmbeddedDocument:
+				first, err := v.ReaderDocument().ElementAt(0)
+				if err == nil && !strings.HasPrefix(first.Key(), "$") {
+					if err = walk(v.ReaderDocument(), field, kpath); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			e, err := compileExpression(v, kpath)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, computedField{path: field, e: e})
+		}
+
+		return itr.Err()
+	}
+	err := walk(v.ReaderDocument(), "", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if exclude && len(fields) > 0 {
+		return nil, CompileError{Path: path, Message: "cannot set fields to expressions in an exclusion projection"}
+	}
+
+	// A projection of only expressions includes _id and the computed fields.
+	empty := false
+	if len(fields) > 0 && !include {
+		if excludeID {
+			empty = true
+		} else if _, err := spec.Lookup("_id"); err != nil {
+			spec.Append(bson.C.Int32("_id", 1))
+		}
+	}
+
+	p, err := projection.Compile(spec)
+	if err != nil {
+		return nil, prefixError(err, path)
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			var base bson.Reader
+			if empty {
+				base = bson.Reader{'\x05', '\x00', '\x00', '\x00', '\x00'}
+			} else {
+				var err error
+				base, err = p.Apply(doc)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if len(fields) == 0 {
+				return base, nil
+			}
+			return setFields(doc, base, fields)
+		})
+	}), nil
+}
+
+func compileAddFields(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	itr, err := v.ReaderDocument().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []computedField
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key := elem.Key()
+		kpath := joinPath(path, key)
+		if key == "" || strings.HasPrefix(key, "$") {
+			return nil, CompileError{Path: kpath, Message: "invalid field name"}
+		}
+
+		e, err := compileExpression(elem.Value(), kpath)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, computedField{path: key, e: e})
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			return setFields(doc, nil, fields)
+		})
+	}), nil
+}
+
+// compileUnset compiles $unset, which is an exclusion projection of a field or an array of
+// fields.
+func compileUnset(v *bson.Value, path string) (stage, error) {
+	var names []*bson.Value
+	switch v.Type() {
+	case bson.TypeString:
+		names = append(names, v)
+	case bson.TypeArray:
+		itr, err := v.ReaderArray().Iterator()
+		if err != nil {
+			return nil, err
+		}
+		for itr.Next() {
+			names = append(names, itr.Element().Clone().Value())
+		}
+		if itr.Err() != nil {
+			return nil, itr.Err()
+		}
+	}
+	if len(names) == 0 {
+		return nil, CompileError{Path: path, Message: "must be a field name or a non-empty array of field names"}
+	}
+
+	spec := bson.NewDocument()
+	for _, name := range names {
+		if name.Type() != bson.TypeString {
+			return nil, CompileError{Path: path, Message: "must be a field name or a non-empty array of field names"}
+		}
+		spec.Append(bson.C.Int32(name.StringValue(), 0))
+	}
+
+	p, err := projection.Compile(spec)
+	if err != nil {
+		return nil, prefixError(err, path)
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return transform(input, p.Apply)
+	}), nil
+}
+
+func compileReplaceRoot(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	r := v.ReaderDocument()
+	elem, err := r.Lookup("newRoot")
+	if err != nil {
+		return nil, err
+	}
+	if elem == nil {
+		return nil, CompileError{Path: path, Message: "newRoot is required"}
+	}
+	if _, err := r.ElementAt(1); err != bson.ErrOutOfBounds {
+		return nil, CompileError{Path: path, Message: "newRoot must be the only field"}
+	}
+
+	e, err := compileExpression(elem.Value(), joinPath(path, "newRoot"))
+	if err != nil {
+		return nil, err
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			v, err := e.Evaluate(doc)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil || v.Type() != bson.TypeEmbeddedDocument {
+				return nil, fmt.Errorf("$replaceRoot: newRoot must evaluate to a document, not %s", typeName(v))
+			}
+			return v.ReaderDocument(), nil
+		})
+	}), nil
+}
+
+func compileSort(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	s, err := query.CompileSort(v.ReaderDocument())
+	if err != nil {
+		return nil, prefixError(err, path)
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return blocking(input, func(docs []bson.Reader) ([]bson.Reader, error) {
+			sortDocuments(s, docs)
+			return docs, nil
+		})
+	}), nil
+}
+
+// sortDocuments sorts docs by s, keeping documents which compare equal in their original order.
+func sortDocuments(s *query.Sort, docs []bson.Reader) {
+	keys := make([][]*bson.Value, len(docs))
+	for i, doc := range docs {
+		keys[i] = s.Key(doc)
+	}
+
+	idx := make([]int, len(docs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return s.CompareKeys(keys[idx[i]], keys[idx[j]]) < 0
+	})
+
+	sorted := make([]bson.Reader, len(docs))
+	for i, j := range idx {
+		sorted[i] = docs[j]
+	}
+	copy(docs, sorted)
+}
+
+func compileSkip(v *bson.Value, path string) (stage, error) {
+	n, ok := integer(v)
+	if !ok || n < 0 {
+		return nil, CompileError{Path: path, Message: "must be a non-negative integer"}
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		skipped := int64(0)
+		return transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			if skipped < n {
+				skipped++
+				return nil, nil
+			}
+			return doc, nil
+		})
+	}), nil
+}
+
+func compileLimit(v *bson.Value, path string) (stage, error) {
+	n, ok := integer(v)
+	if !ok || n <= 0 {
+		return nil, CompileError{Path: path, Message: "must be a positive integer"}
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		returned := int64(0)
+		return transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			if returned == n {
+				return nil, errEndOfPipeline
+			}
+			returned++
+			return doc, nil
+		})
+	}), nil
+}
+
+// compileUnwind compiles $unwind, which is either a field path or a document of the form
+// {"path": <field path>, "includeArrayIndex": <field>, "preserveNullAndEmptyArrays": <bool>}.
+func compileUnwind(v *bson.Value, path string) (stage, error) {
+	var field, index string
+	var preserve bool
+	switch v.Type() {
+	case bson.TypeString:
+		field = v.StringValue()
+	case bson.TypeEmbeddedDocument:
+		itr, err := v.ReaderDocument().Iterator()
+		if err != nil {
+			return nil, err
+		}
+		for itr.Next() {
+			elem := itr.Element()
+			kpath := joinPath(path, elem.Key())
+			switch elem.Key() {
+			case "path":
+				if elem.Value().Type() != bson.TypeString {
+					return nil, CompileError{Path: kpath, Message: "must be a string"}
+				}
+				field = elem.Value().StringValue()
+			case "includeArrayIndex":
+				if elem.Value().Type() != bson.TypeString {
+					return nil, CompileError{Path: kpath, Message: "must be a string"}
+				}
+				index = elem.Value().StringValue()
+				if index == "" || strings.HasPrefix(index, "$") {
+					return nil, CompileError{Path: kpath, Message: "must be a field name"}
+				}
+			case "preserveNullAndEmptyArrays":
+				if elem.Value().Type() != bson.TypeBoolean {
+					return nil, CompileError{Path: kpath, Message: "must be a boolean"}
+				}
+				preserve = elem.Value().Boolean()
+			default:
+				return nil, CompileError{Path: kpath, Message: "unknown option"}
+			}
+		}
+		if itr.Err() != nil {
+			return nil, itr.Err()
+		}
+	default:
+		return nil, CompileError{Path: path, Message: "must be a field path or an object"}
+	}
+
+	if !strings.HasPrefix(field, "$") {
+		return nil, CompileError{Path: path, Message: "path must be a field path starting with '$'"}
+	}
+	field = field[1:]
+	for _, seg := range strings.Split(field, ".") {
+		if seg == "" || strings.HasPrefix(seg, "$") {
+			return nil, CompileError{Path: path, Message: fmt.Sprintf("invalid field path %q", "$"+field)}
+		}
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		var pending []bson.Reader
+		next := transform(input, func(doc bson.Reader) (bson.Reader, error) {
+			var err error
+			pending, err = unwind(doc, field, index, preserve)
+			if err != nil || len(pending) == 0 {
+				return nil, err
+			}
+			first := pending[0]
+			pending = pending[1:]
+			return first, nil
+		})
+		return &funcIterator{next: func() (bson.Reader, error) {
+			if len(pending) > 0 {
+				doc := pending[0]
+				pending = pending[1:]
+				return doc, nil
+			}
+			if !next.Next() {
+				if err := next.Err(); err != nil {
+					return nil, err
+				}
+				return nil, errEndOfPipeline
+			}
+			return next.Document(), nil
+		}}
+	}), nil
+}
+
+// unwind returns a copy of doc for each element of the array at field, with the array replaced by
+// the element.
+func unwind(doc bson.Reader, field, index string, preserve bool) ([]bson.Reader, error) {
+	v, err := doc.LookupPath(field)
+	if err != nil && err != bson.ErrElementNotFound && err != bson.ErrInvalidDepthTraversal {
+		return nil, err
+	}
+
+	var elems []*bson.Value
+	if v != nil && v.Type() == bson.TypeArray {
+		itr, err := v.ReaderArray().Iterator()
+		if err != nil {
+			return nil, err
+		}
+		for itr.Next() {
+			elems = append(elems, itr.Element().Clone().Value())
+		}
+		if itr.Err() != nil {
+			return nil, itr.Err()
+		}
+	}
+
+	d, err := bson.ReadDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(elems) > 0:
+	case v != nil && v.Type() != bson.TypeArray && v.Type() != bson.TypeNull:
+		// A value which is not an array is treated as an array of one element, but its index is
+		// null.
+		if index != "" {
+			if err := d.SetPath(index, bson.AC.Null()); err != nil {
+				return nil, err
+			}
+		}
+		out, err := d.MarshalBSON()
+		return []bson.Reader{out}, err
+	case !preserve:
+		return nil, nil
+	default:
+		if v != nil && v.Type() == bson.TypeArray {
+			if _, err := d.DeletePath(field); err != nil {
+				return nil, err
+			}
+		}
+		if index != "" {
+			if err := d.SetPath(index, bson.AC.Null()); err != nil {
+				return nil, err
+			}
+		}
+		out, err := d.MarshalBSON()
+		return []bson.Reader{out}, err
+	}
+
+	docs := make([]bson.Reader, 0, len(elems))
+	for i, elem := range elems {
+		if err := d.SetPath(field, elem); err != nil {
+			return nil, err
+		}
+		if index != "" {
+			if err := d.SetPath(index, bson.AC.Int64(int64(i))); err != nil {
+				return nil, err
+			}
+		}
+		out, err := d.MarshalBSON()
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, out)
+	}
+
+	return docs, nil
+}
+
+func compileCount(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeString {
+		return nil, CompileError{Path: path, Message: "must be a string"}
+	}
+	name := v.StringValue()
+	if name == "" || strings.HasPrefix(name, "$") || strings.Contains(name, ".") {
+		return nil, CompileError{Path: path, Message: "must be a non-empty field name without '$' or '.'"}
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return blocking(input, func(docs []bson.Reader) ([]bson.Reader, error) {
+			if len(docs) == 0 {
+				return nil, nil
+			}
+			out, err := bson.NewDocument(number(int64(len(docs)), name)).MarshalBSON()
+			return []bson.Reader{out}, err
+		})
+	}), nil
+}
+
+func compileFacet(v *bson.Value, path string) (stage, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	itr, err := v.ReaderDocument().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var facets [][]stage
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		kpath := joinPath(path, elem.Key())
+		if elem.Key() == "" || strings.HasPrefix(elem.Key(), "$") || strings.Contains(elem.Key(), ".") {
+			return nil, CompileError{Path: kpath, Message: "invalid facet name"}
+		}
+		if elem.Value().Type() != bson.TypeArray {
+			return nil, CompileError{Path: kpath, Message: "must be an array of stages"}
+		}
+
+		stages, err := compileStages(elem.Value().ReaderArray(), kpath, false)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, elem.Key())
+		facets = append(facets, stages)
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+	if len(facets) == 0 {
+		return nil, CompileError{Path: path, Message: "must contain at least one facet"}
+	}
+
+	return stageFunc(func(input Iterator) Iterator {
+		return blocking(input, func(docs []bson.Reader) ([]bson.Reader, error) {
+			out := bson.NewDocument()
+			for i, stages := range facets {
+				results, err := Collect(runStages(stages, SliceIterator(docs)))
+				if err != nil {
+					return nil, err
+				}
+
+				arr := bson.NewArray()
+				for _, r := range results {
+					arr.Append(bson.AC.DocumentFromReader(append(bson.Reader(nil), r...)))
+				}
+				out.Append(bson.C.Array(names[i], arr))
+			}
+
+			b, err := out.MarshalBSON()
+			return []bson.Reader{b}, err
+		})
+	}), nil
+}
+
+// integer returns the value of a 32-bit integer, a 64-bit integer, or a double with an integral
+// value.
+func integer(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != float64(int64(f)) {
+			return 0, false
+		}
+		return int64(f), true
+	}
+
+	return 0, false
+}
+
+// number returns an element with the given key and the value n as a 32-bit integer if it fits, or
+// a 64-bit integer otherwise.
+func number(n int64, key string) *bson.Element {
+	if int64(int32(n)) == n {
+		return bson.C.Int32(key, int32(n))
+	}
+
+	return bson.C.Int64(key, n)
+}
+
+// typeName returns the name of the type of v, or "missing" if v is nil.
+func typeName(v *bson.Value) string {
+	if v == nil {
+		return "missing"
+	}
+
+	return v.Type().String()
+}
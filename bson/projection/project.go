@@ -0,0 +1,141 @@
+package projection
+
+import (
+	"strconv"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// The documents given to the methods in this file have been validated, so iterating over them
+// does not fail.
+
+// project appends the projection of the document r to dst.
+func (n *node) project(dst []byte, r bson.Reader, inclusion bool) []byte {
+	dst, pos := startDocument(dst)
+
+	itr, _ := r.Iterator()
+	for itr.Next() {
+		elem := itr.Element()
+		dst = n.projectElement(dst, elem.Key(), elem.Value(), inclusion)
+	}
+
+	return endDocument(dst, pos)
+}
+
+// projectElement appends the projection of the element with the given key and value v, which is
+// within the document projected by n, to dst.
+func (n *node) projectElement(dst []byte, key string, v *bson.Value, inclusion bool) []byte {
+	child, ok := n.fields[key]
+	if !ok {
+		if inclusion {
+			return dst
+		}
+		return appendElement(dst, key, v)
+	}
+
+	switch child.action {
+	case actionInclude:
+		return appendElement(dst, key, v)
+	case actionExclude:
+		return dst
+	case actionSlice:
+		if v.Type() != bson.TypeArray {
+			return appendElement(dst, key, v)
+		}
+		return child.slice(dst, key, v.ReaderArray())
+	case actionElemMatch:
+		if v.Type() != bson.TypeArray {
+			return dst
+		}
+		return child.matchElement(dst, key, v.ReaderArray())
+	}
+
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		dst = appendHeader(dst, bson.TypeEmbeddedDocument, key)
+		return child.project(dst, v.ReaderDocument(), inclusion)
+	case bson.TypeArray:
+		dst = appendHeader(dst, bson.TypeArray, key)
+		return child.projectArray(dst, v.ReaderArray(), inclusion)
+	}
+
+	if inclusion {
+		return dst
+	}
+	return appendElement(dst, key, v)
+}
+
+// projectArray appends the projection of the array arr to dst. The projection n is applied to each
+// document in the array and, recursively, to each document in nested arrays. Other elements are
+// dropped by an inclusion projection and kept by an exclusion projection.
+func (n *node) projectArray(dst []byte, arr bson.Reader, inclusion bool) []byte {
+	dst, pos := startDocument(dst)
+
+	i := 0
+	itr, _ := arr.Iterator()
+	for itr.Next() {
+		v := itr.Element().Value()
+		key := strconv.Itoa(i)
+
+		switch v.Type() {
+		case bson.TypeEmbeddedDocument:
+			dst = appendHeader(dst, bson.TypeEmbeddedDocument, key)
+			dst = n.project(dst, v.ReaderDocument(), inclusion)
+		case bson.TypeArray:
+			dst = appendHeader(dst, bson.TypeArray, key)
+			dst = n.projectArray(dst, v.ReaderArray(), inclusion)
+		default:
+			if inclusion {
+				continue
+			}
+			dst = appendElement(dst, key, v)
+		}
+		i++
+	}
+
+	return endDocument(dst, pos)
+}
+
+// slice appends an array element with the given key containing the elements of arr kept by
+// $slice to dst.
+func (n *node) slice(dst []byte, key string, arr bson.Reader) []byte {
+	var length int64
+	itr, _ := arr.Iterator()
+	for itr.Next() {
+		length++
+	}
+	start, end := n.bounds(length)
+
+	dst = appendHeader(dst, bson.TypeArray, key)
+	dst, pos := startDocument(dst)
+
+	var i int64
+	itr, _ = arr.Iterator()
+	for itr.Next() && i < end {
+		if i >= start {
+			dst = appendElement(dst, strconv.FormatInt(i-start, 10), itr.Element().Value())
+		}
+		i++
+	}
+
+	return endDocument(dst, pos)
+}
+
+// matchElement appends an array element with the given key containing the first element of arr
+// matching $elemMatch to dst. Nothing is appended if no element matches.
+func (n *node) matchElement(dst []byte, key string, arr bson.Reader) []byte {
+	itr, _ := arr.Iterator()
+	for itr.Next() {
+		v := itr.Element().Value()
+		if !n.elemMatch.MatchesValue(v) {
+			continue
+		}
+
+		dst = appendHeader(dst, bson.TypeArray, key)
+		dst, pos := startDocument(dst)
+		dst = appendElement(dst, "0", v)
+		return endDocument(dst, pos)
+	}
+
+	return dst
+}
@@ -0,0 +1,448 @@
+// Package projection applies MongoDB projection documents to BSON documents.
+//
+// A projection is compiled once with Compile or CompileReader and can then be applied to any
+// number of documents. Apply copies the selected fields directly from the bytes of the source
+// document, so projecting a few fields out of a large document does not decode the rest of it.
+//
+// A projection either includes or excludes fields. In an inclusion projection, such as
+// {"name": 1, "address.city": 1}, only the listed fields are copied, and the _id field is copied
+// unless it is excluded with {"_id": 0}. In an exclusion projection, such as {"history": 0}, every
+// field except the listed ones is copied. The two may not be mixed, except for excluding _id from
+// an inclusion projection. Explicitly including _id, as in {"_id": 1}, also makes a projection an
+// inclusion projection.
+//
+// Fields are identified by dotted paths, or equivalently by nested projection documents, so
+// {"a.b": 1} and {"a": {"b": 1}} are the same projection. When a path traverses an array, the
+// rest of the path is applied to each document in the array. In an inclusion projection the other
+// elements of the array are dropped, and in an exclusion projection they are kept.
+//
+// The $slice operator limits the elements of an array. {"$slice": n} keeps the first n elements,
+// or the last -n elements if n is negative, and {"$slice": [skip, limit]} skips the first skip
+// elements, or starts -skip elements from the end if skip is negative, and keeps at most limit
+// elements. $slice does not by itself make a projection an inclusion projection, so {"a":
+// {"$slice": 2}} copies every field of the document.
+//
+// The $elemMatch operator, which may only be used on top-level fields, replaces an array with an
+// array containing the first element matching the filter, or omits the field if no element
+// matches. It is applied using query.CompileElemMatch, and makes a projection an inclusion
+// projection.
+//
+// The positional $ operator and aggregation expressions are not supported.
+package projection
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/query"
+)
+
+// CompileError is returned when a projection is invalid.
+type CompileError struct {
+	// Path is the dotted path of the invalid field within the projection.
+	Path    string
+	Message string
+}
+
+func (ce CompileError) Error() string {
+	if ce.Path == "" {
+		return "projection: " + ce.Message
+	}
+
+	return "projection: " + ce.Path + ": " + ce.Message
+}
+
+// Projection is a compiled projection. A Projection is safe for concurrent use.
+type Projection struct {
+	root      *node
+	inclusion bool
+}
+
+// Compile compiles a MongoDB projection document.
+func Compile(spec *bson.Document) (*Projection, error) {
+	b, err := spec.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileReader(b)
+}
+
+// CompileReader compiles a projection provided as a bson.Reader. See Compile.
+func CompileReader(spec bson.Reader) (*Projection, error) {
+	_, err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	root := newNode()
+	err = root.compile(spec, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Projection{root: root}
+	var include, exclude bool
+	var conflict string
+	root.walk("", func(path string, n *node) {
+		if path == "_id" {
+			return
+		}
+		switch n.action {
+		case actionInclude, actionElemMatch:
+			include = true
+		case actionExclude:
+			exclude = true
+		default:
+			return
+		}
+		if include && exclude && conflict == "" {
+			conflict = path
+		}
+	})
+	if conflict != "" {
+		return nil, CompileError{Path: conflict, Message: "cannot mix inclusion and exclusion"}
+	}
+
+	id, ok := root.fields["_id"]
+	switch {
+	case include:
+		p.inclusion = true
+	case exclude:
+	case ok && id.action == actionInclude:
+		// An explicitly included _id makes a projection such as {"_id": 1} an inclusion projection.
+		p.inclusion = true
+	}
+
+	if p.inclusion && !ok {
+		root.add("_id", &node{action: actionInclude})
+	}
+
+	return p, nil
+}
+
+// Apply returns a new document containing the fields of r selected by the projection. An error is
+// returned only if r is not a valid BSON document.
+func (p *Projection) Apply(r bson.Reader) (bson.Reader, error) {
+	b, err := p.AppendTo(nil, r)
+	return bson.Reader(b), err
+}
+
+// AppendTo appends the projection of r to dst and returns the extended buffer.
+func (p *Projection) AppendTo(dst []byte, r bson.Reader) ([]byte, error) {
+	_, err := r.Validate()
+	if err != nil {
+		return dst, err
+	}
+
+	return p.root.project(dst, r, p.inclusion), nil
+}
+
+// AppendDocument appends the projection of the document d to dst and returns the extended buffer.
+func (p *Projection) AppendDocument(dst []byte, d *bson.Document) ([]byte, error) {
+	b, err := d.MarshalBSON()
+	if err != nil {
+		return dst, err
+	}
+
+	return p.AppendTo(dst, b)
+}
+
+type action int
+
+const (
+	actionNone action = iota
+	actionInclude
+	actionExclude
+	actionSlice
+	actionElemMatch
+)
+
+// node is the projection of a field. Leaf nodes have an action, and the other nodes have the
+// projections of the fields of the embedded document at their path.
+type node struct {
+	action action
+
+	// skip and limit are the bounds of $slice. If limit is negative, skip is the number of elements
+	// to keep and skip elements are kept from the end if it is negative.
+	skip, limit int64
+	elemMatch   *query.Matcher
+
+	keys   []string
+	fields map[string]*node
+}
+
+func newNode() *node {
+	return &node{fields: make(map[string]*node)}
+}
+
+func (n *node) add(key string, child *node) {
+	n.keys = append(n.keys, key)
+	n.fields[key] = child
+}
+
+// walk calls f with the path of each leaf below n.
+func (n *node) walk(path string, f func(string, *node)) {
+	for _, key := range n.keys {
+		child := n.fields[key]
+		cpath := key
+		if path != "" {
+			cpath = path + "." + key
+		}
+
+		if child.action != actionNone {
+			f(cpath, child)
+			continue
+		}
+		child.walk(cpath, f)
+	}
+}
+
+// compile adds the fields of spec, which is nested within the projection at the path prefix, to
+// the projection n.
+func (n *node) compile(spec bson.Reader, prefix []string, path string) error {
+	itr, err := spec.Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+		kpath := key
+		if path != "" {
+			kpath = path + "." + key
+		}
+
+		if strings.HasPrefix(key, "$") {
+			return CompileError{Path: kpath, Message: "unknown operator"}
+		}
+		segs := strings.Split(key, ".")
+		for _, seg := range segs {
+			if seg == "" || seg == "$" {
+				return CompileError{Path: kpath, Message: "invalid field path"}
+			}
+		}
+		segs = append(append([]string(nil), prefix...), segs...)
+
+		switch v.Type() {
+		case bson.TypeEmbeddedDocument:
+			first, err := v.ReaderDocument().ElementAt(0)
+			if err == bson.ErrOutOfBounds {
+				return CompileError{Path: kpath, Message: "must not be an empty document"}
+			}
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(first.Key(), "$") {
+				err = n.compile(v.ReaderDocument(), segs, kpath)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			leaf, err := compileOperator(v.ReaderDocument(), len(segs), kpath)
+			if err != nil {
+				return err
+			}
+			err = n.insert(segs, leaf, kpath)
+			if err != nil {
+				return err
+			}
+		case bson.TypeBoolean, bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+			leaf := &node{action: actionExclude}
+			if truthy(v) {
+				leaf.action = actionInclude
+			}
+			err = n.insert(segs, leaf, kpath)
+			if err != nil {
+				return err
+			}
+		default:
+			return CompileError{Path: kpath, Message: "must be a number, a boolean, or a document"}
+		}
+	}
+
+	return itr.Err()
+}
+
+// insert adds the leaf at the path segs below n.
+func (n *node) insert(segs []string, leaf *node, path string) error {
+	for _, seg := range segs[:len(segs)-1] {
+		child, ok := n.fields[seg]
+		if !ok {
+			child = newNode()
+			n.add(seg, child)
+		}
+		if child.action != actionNone {
+			return CompileError{Path: path, Message: "path collision"}
+		}
+		n = child
+	}
+
+	key := segs[len(segs)-1]
+	if _, ok := n.fields[key]; ok {
+		return CompileError{Path: path, Message: "path collision"}
+	}
+	n.add(key, leaf)
+
+	return nil
+}
+
+// compileOperator compiles a document containing a projection operator for the field at a path
+// with depth segments.
+func compileOperator(spec bson.Reader, depth int, path string) (*node, error) {
+	keys, err := spec.Keys(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, CompileError{Path: path, Message: "must contain exactly one operator"}
+	}
+
+	elem, err := spec.ElementAt(0)
+	if err != nil {
+		return nil, err
+	}
+	kpath := path + "." + elem.Key()
+	v := elem.Value()
+
+	switch elem.Key() {
+	case "$slice":
+		return compileSlice(v, kpath)
+	case "$elemMatch":
+		if depth != 1 {
+			return nil, CompileError{Path: kpath, Message: "cannot be used on a nested field"}
+		}
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: kpath, Message: "must be an object"}
+		}
+		m, err := query.CompileElemMatch(v.ReaderDocument())
+		if err != nil {
+			return nil, err
+		}
+		return &node{action: actionElemMatch, elemMatch: m}, nil
+	}
+
+	return nil, CompileError{Path: kpath, Message: "unknown operator"}
+}
+
+// compileSlice compiles the operand of $slice, which is either a number of elements or an array
+// of the number of elements to skip and the number of elements to keep.
+func compileSlice(v *bson.Value, path string) (*node, error) {
+	if n, ok := integer(v); ok {
+		return &node{action: actionSlice, skip: n, limit: -1}, nil
+	}
+	if v.Type() != bson.TypeArray {
+		return nil, CompileError{Path: path, Message: "must be an integer or an array of two integers"}
+	}
+
+	skip, err := v.ReaderArray().ElementAt(0)
+	if err != nil {
+		return nil, CompileError{Path: path, Message: "must be an integer or an array of two integers"}
+	}
+	limit, err := v.ReaderArray().ElementAt(1)
+	if err != nil {
+		return nil, CompileError{Path: path, Message: "must be an integer or an array of two integers"}
+	}
+	if _, err := v.ReaderArray().ElementAt(2); err != bson.ErrOutOfBounds {
+		return nil, CompileError{Path: path, Message: "must be an integer or an array of two integers"}
+	}
+
+	s, ok := integer(skip.Value())
+	if !ok {
+		return nil, CompileError{Path: path, Message: "skip must be an integer"}
+	}
+	l, ok := integer(limit.Value())
+	if !ok || l <= 0 {
+		return nil, CompileError{Path: path, Message: "limit must be a positive integer"}
+	}
+
+	return &node{action: actionSlice, skip: s, limit: l}, nil
+}
+
+// integer returns the value of a 32-bit integer, a 64-bit integer, or a double with an integral
+// value.
+func integer(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != float64(int64(f)) {
+			return 0, false
+		}
+		return int64(f), true
+	}
+
+	return 0, false
+}
+
+// truthy reports whether a boolean or number includes a field.
+func truthy(v *bson.Value) bool {
+	if v.Type() == bson.TypeBoolean {
+		return v.Boolean()
+	}
+
+	return bson.CompareValues(v, bson.AC.Int32(0)) != 0
+}
+
+// bounds returns the range of elements kept by $slice from an array of the given length.
+func (n *node) bounds(length int64) (start, end int64) {
+	if n.limit < 0 {
+		if n.skip >= 0 {
+			return 0, min64(n.skip, length)
+		}
+		return max64(length+n.skip, 0), length
+	}
+
+	start = min64(n.skip, length)
+	if n.skip < 0 {
+		start = max64(length+n.skip, 0)
+	}
+	return start, min64(start+n.limit, length)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// appendHeader appends the type and key of an element to dst.
+func appendHeader(dst []byte, t bson.Type, key string) []byte {
+	dst = append(dst, byte(t))
+	dst = append(dst, key...)
+	return append(dst, 0)
+}
+
+// appendElement appends an element with the given key and the value v to dst.
+func appendElement(dst []byte, key string, v *bson.Value) []byte {
+	dst = appendHeader(dst, v.Type(), key)
+	return append(dst, v.Bytes()...)
+}
+
+// startDocument appends the length placeholder of a document to dst and returns the position of
+// the document.
+func startDocument(dst []byte) ([]byte, int) {
+	return append(dst, 0, 0, 0, 0), len(dst)
+}
+
+// endDocument terminates the document started at pos and sets its length.
+func endDocument(dst []byte, pos int) []byte {
+	dst = append(dst, 0)
+	binary.LittleEndian.PutUint32(dst[pos:], uint32(len(dst)-pos))
+	return dst
+}
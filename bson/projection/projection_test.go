@@ -0,0 +1,252 @@
+package projection
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func spec(elems ...*bson.Element) *bson.Document {
+	return bson.NewDocument(elems...)
+}
+
+func TestApply(t *testing.T) {
+	doc := bson.NewDocument(
+		C.Int32("_id", 1),
+		C.String("name", "Alice"),
+		C.SubDocumentFromElements("address", C.String("city", "Oslo"), C.String("zip", "0150")),
+		C.ArrayFromElements("orders",
+			AC.DocumentFromElements(C.Int32("qty", 1), C.String("item", "x")),
+			AC.Int32(7),
+			AC.DocumentFromElements(C.Int32("qty", 5), C.String("item", "y")),
+			AC.ArrayFromValues(AC.DocumentFromElements(C.Int32("qty", 9), C.String("item", "z"))),
+		),
+		C.ArrayFromElements("scores", AC.Int32(1), AC.Int32(2), AC.Int32(3), AC.Int32(4), AC.Int32(5)),
+	)
+
+	testCases := []struct {
+		name string
+		spec *bson.Document
+		want *bson.Document
+	}{
+		{"empty", spec(), doc},
+		{
+			"inclusion",
+			spec(C.Int32("name", 1)),
+			bson.NewDocument(C.Int32("_id", 1), C.String("name", "Alice")),
+		},
+		{
+			"inclusion keeps document order",
+			spec(C.Boolean("name", true), C.Int32("_id", 1)),
+			bson.NewDocument(C.Int32("_id", 1), C.String("name", "Alice")),
+		},
+		{
+			"inclusion without _id",
+			spec(C.Int32("name", 1), C.Int32("_id", 0)),
+			bson.NewDocument(C.String("name", "Alice")),
+		},
+		{"only _id", spec(C.Int32("_id", 1)), bson.NewDocument(C.Int32("_id", 1))},
+		{"missing field", spec(C.Int32("missing", 1), C.Int32("_id", 0)), bson.NewDocument()},
+		{
+			"dotted inclusion",
+			spec(C.Int32("address.city", 1), C.Int32("_id", 0)),
+			bson.NewDocument(C.SubDocumentFromElements("address", C.String("city", "Oslo"))),
+		},
+		{
+			"nested inclusion",
+			spec(C.SubDocumentFromElements("address", C.Int32("zip", 1)), C.Int32("_id", 0)),
+			bson.NewDocument(C.SubDocumentFromElements("address", C.String("zip", "0150"))),
+		},
+		{
+			"inclusion through scalar",
+			spec(C.Int32("name.first", 1), C.Int32("_id", 0)),
+			bson.NewDocument(),
+		},
+		{
+			"inclusion through array",
+			spec(C.Int32("orders.item", 1), C.Int32("_id", 0)),
+			bson.NewDocument(C.ArrayFromElements("orders",
+				AC.DocumentFromElements(C.String("item", "x")),
+				AC.DocumentFromElements(C.String("item", "y")),
+				AC.ArrayFromValues(AC.DocumentFromElements(C.String("item", "z"))),
+			)),
+		},
+		{
+			"exclusion",
+			spec(C.Int32("address", 0), C.Int32("orders", 0), C.Int32("scores", 0)),
+			bson.NewDocument(C.Int32("_id", 1), C.String("name", "Alice")),
+		},
+		{
+			"exclusion of _id",
+			spec(C.Int32("_id", 0), C.Int32("address", 0), C.Int32("orders", 0), C.Int32("scores", 0)),
+			bson.NewDocument(C.String("name", "Alice")),
+		},
+		{
+			"dotted exclusion through array",
+			spec(C.Int32("orders.qty", 0), C.Int32("_id", 0), C.Int32("name", 0), C.Int32("address", 0), C.Int32("scores", 0)),
+			bson.NewDocument(C.ArrayFromElements("orders",
+				AC.DocumentFromElements(C.String("item", "x")),
+				AC.Int32(7),
+				AC.DocumentFromElements(C.String("item", "y")),
+				AC.ArrayFromValues(AC.DocumentFromElements(C.String("item", "z"))),
+			)),
+		},
+		{
+			"$slice",
+			spec(C.SubDocumentFromElements("scores", C.Int32("$slice", 2)), C.Int32("_id", 0), C.Int32("name", 0), C.Int32("address", 0), C.Int32("orders", 0)),
+			bson.NewDocument(C.ArrayFromElements("scores", AC.Int32(1), AC.Int32(2))),
+		},
+		{
+			"$slice keeps other fields",
+			spec(C.SubDocumentFromElements("scores", C.Int32("$slice", -2))),
+			bson.NewDocument(
+				C.Int32("_id", 1),
+				C.String("name", "Alice"),
+				C.SubDocumentFromElements("address", C.String("city", "Oslo"), C.String("zip", "0150")),
+				C.ArrayFromElements("orders",
+					AC.DocumentFromElements(C.Int32("qty", 1), C.String("item", "x")),
+					AC.Int32(7),
+					AC.DocumentFromElements(C.Int32("qty", 5), C.String("item", "y")),
+					AC.ArrayFromValues(AC.DocumentFromElements(C.Int32("qty", 9), C.String("item", "z"))),
+				),
+				C.ArrayFromElements("scores", AC.Int32(4), AC.Int32(5)),
+			),
+		},
+		{
+			"$slice skip and limit",
+			spec(C.Int32("_id", 1), C.SubDocumentFromElements("scores", C.ArrayFromElements("$slice", AC.Int32(1), AC.Int32(2)))),
+			bson.NewDocument(C.Int32("_id", 1), C.ArrayFromElements("scores", AC.Int32(2), AC.Int32(3))),
+		},
+		{
+			"$slice negative skip",
+			spec(C.Int32("_id", 1), C.SubDocumentFromElements("scores", C.ArrayFromElements("$slice", AC.Int32(-2), AC.Int32(5)))),
+			bson.NewDocument(C.Int32("_id", 1), C.ArrayFromElements("scores", AC.Int32(4), AC.Int32(5))),
+		},
+		{
+			"$slice past the end",
+			spec(C.Int32("_id", 1), C.SubDocumentFromElements("scores", C.ArrayFromElements("$slice", AC.Int32(10), AC.Int32(1)))),
+			bson.NewDocument(C.Int32("_id", 1), C.ArrayFromElements("scores")),
+		},
+		{
+			"$slice with inclusion",
+			spec(C.Int32("name", 1), C.SubDocumentFromElements("scores", C.Int32("$slice", 1))),
+			bson.NewDocument(C.Int32("_id", 1), C.String("name", "Alice"), C.ArrayFromElements("scores", AC.Int32(1))),
+		},
+		{
+			"$slice on a scalar",
+			spec(C.SubDocumentFromElements("name", C.Int32("$slice", 1))),
+			doc,
+		},
+		{
+			"$elemMatch",
+			spec(C.SubDocumentFromElements("orders", C.SubDocumentFromElements("$elemMatch",
+				C.SubDocumentFromElements("qty", C.Int32("$gt", 2)),
+			))),
+			bson.NewDocument(
+				C.Int32("_id", 1),
+				C.ArrayFromElements("orders", AC.DocumentFromElements(C.Int32("qty", 5), C.String("item", "y"))),
+			),
+		},
+		{
+			"$elemMatch scalar",
+			spec(C.Int32("_id", 0), C.SubDocumentFromElements("scores", C.SubDocumentFromElements("$elemMatch", C.Int32("$gte", 3)))),
+			bson.NewDocument(C.ArrayFromElements("scores", AC.Int32(3))),
+		},
+		{
+			"$elemMatch no match",
+			spec(C.Int32("_id", 0), C.SubDocumentFromElements("scores", C.SubDocumentFromElements("$elemMatch", C.Int32("$gt", 5)))),
+			bson.NewDocument(),
+		},
+	}
+
+	rdr, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Compile(tc.spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := p.Apply(rdr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			want, err := tc.want.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Projections do not match. got %v; want %v", got, bson.Reader(want))
+			}
+		})
+	}
+}
+
+func TestAppendTo(t *testing.T) {
+	p, err := Compile(spec(C.Int32("a", 1), C.Int32("_id", 0)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dst := []byte{'x'}
+	got, err := p.AppendDocument(dst, bson.NewDocument(C.Int32("a", 1), C.Int32("b", 2)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []byte{'x', '\x0c', '\x00', '\x00', '\x00', '\x10', 'a', '\x00', '\x01', '\x00', '\x00', '\x00', '\x00'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected result. got %v; want %v", got, want)
+	}
+
+	_, err = p.Apply(bson.Reader{'\x05', '\x00', '\x00', '\x00', '\x01'})
+	if err == nil {
+		t.Errorf("Expected an error for an invalid document")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec *bson.Document
+		path string
+	}{
+		{"mixed", spec(C.Int32("a", 1), C.Int32("b", 0)), "b"},
+		{"mixed nested", spec(C.Int32("a.c", 0), C.Int32("b", 1)), "b"},
+		{"mixed $elemMatch", spec(C.Int32("a", 0), C.SubDocumentFromElements("b", C.SubDocumentFromElements("$elemMatch", C.Int32("c", 1)))), "b"},
+		{"collision", spec(C.Int32("a", 1), C.Int32("a.b", 1)), "a.b"},
+		{"collision nested", spec(C.Int32("a.b", 1), C.SubDocumentFromElements("a", C.Int32("b", 1))), "a.b"},
+		{"empty path segment", spec(C.Int32("a..b", 1)), "a..b"},
+		{"positional", spec(C.Int32("a.$", 1)), "a.$"},
+		{"top level operator", spec(C.Int32("$slice", 1)), "$slice"},
+		{"empty document", spec(C.SubDocumentFromElements("a")), "a"},
+		{"string", spec(C.String("a", "x")), "a"},
+		{"unknown operator", spec(C.SubDocumentFromElements("a", C.Int32("$size", 1))), "a.$size"},
+		{"two operators", spec(C.SubDocumentFromElements("a", C.Int32("$slice", 1), C.Int32("$foo", 1))), "a"},
+		{"$slice string", spec(C.SubDocumentFromElements("a", C.String("$slice", "x"))), "a.$slice"},
+		{"$slice limit", spec(C.SubDocumentFromElements("a", C.ArrayFromElements("$slice", AC.Int32(1), AC.Int32(0)))), "a.$slice"},
+		{"$slice length", spec(C.SubDocumentFromElements("a", C.ArrayFromElements("$slice", AC.Int32(1)))), "a.$slice"},
+		{"nested $elemMatch", spec(C.SubDocumentFromElements("a.b", C.SubDocumentFromElements("$elemMatch", C.Int32("c", 1)))), "a.b.$elemMatch"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.spec)
+			ce, ok := err.(CompileError)
+			if !ok {
+				t.Fatalf("Did not get expected error. got %v; want a CompileError", err)
+			}
+			if ce.Path != tc.path {
+				t.Errorf("Unexpected path. got %q; want %q", ce.Path, tc.path)
+			}
+		})
+	}
+}
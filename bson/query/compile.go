@@ -0,0 +1,518 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// allTypes are the types which may be named by the $type operator.
+var allTypes = []bson.Type{
+	bson.TypeDouble, bson.TypeString, bson.TypeEmbeddedDocument, bson.TypeArray, bson.TypeBinary,
+	bson.TypeUndefined, bson.TypeObjectID, bson.TypeBoolean, bson.TypeDateTime, bson.TypeNull,
+	bson.TypeRegex, bson.TypeDBPointer, bson.TypeJavaScript, bson.TypeSymbol,
+	bson.TypeCodeWithScope, bson.TypeInt32, bson.TypeTimestamp, bson.TypeInt64,
+	bson.TypeDecimal128, bson.TypeMinKey, bson.TypeMaxKey,
+}
+
+// compileFilter compiles a filter which matches embedded documents.
+func compileFilter(filter bson.Reader, path string) (expr, error) {
+	clauses, err := compileClauses(filter, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return documentExpr{clauses}, nil
+}
+
+// compileClauses compiles the elements of a filter, which must all match.
+func compileClauses(filter bson.Reader, path string) (andExpr, error) {
+	itr, err := filter.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var clauses andExpr
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+		kpath := joinPath(path, key)
+
+		switch {
+		case key == "$and" || key == "$or" || key == "$nor":
+			filters, err := compileFilterArray(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "$and":
+				clauses = append(clauses, andExpr(filters))
+			case "$or":
+				clauses = append(clauses, orExpr(filters))
+			default:
+				clauses = append(clauses, notExpr{orExpr(filters)})
+			}
+		case key == "$comment":
+		case strings.HasPrefix(key, "$"):
+			return nil, CompileError{Path: kpath, Message: "unknown top level operator"}
+		default:
+			segs := strings.Split(key, ".")
+			for _, seg := range segs {
+				if seg == "" {
+					return nil, CompileError{Path: kpath, Message: "invalid field path"}
+				}
+			}
+			clause, err := compileField(segs, v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, clause)
+		}
+	}
+
+	return clauses, itr.Err()
+}
+
+// compileFilterArray compiles the operand of $and, $or, or $nor.
+func compileFilterArray(v *bson.Value, path string) ([]expr, error) {
+	values, err := arrayOperand(v, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, CompileError{Path: path, Message: "must not be empty"}
+	}
+
+	filters := make([]expr, 0, len(values))
+	for i, value := range values {
+		ipath := joinPath(path, strconv.Itoa(i))
+		if value.Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: ipath, Message: "must be an object"}
+		}
+
+		clauses, err := compileClauses(value.ReaderDocument(), ipath)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, clauses)
+	}
+
+	return filters, nil
+}
+
+// compileElemMatch compiles the operand of $elemMatch, which matches the elements of an array.
+func compileElemMatch(spec bson.Reader, path string) (expr, error) {
+	keys, err := spec.Keys(false)
+	if err != nil {
+		return nil, err
+	}
+
+	operators := len(keys) > 0
+	for _, key := range keys {
+		switch key.Name {
+		case "$and", "$or", "$nor":
+			operators = false
+		default:
+			operators = operators && strings.HasPrefix(key.Name, "$")
+		}
+	}
+	if !operators {
+		return compileFilter(spec, path)
+	}
+
+	return compileOperators(nil, spec, path)
+}
+
+// compileField compiles the condition v on the field at the path segs.
+func compileField(segs []string, v *bson.Value, path string) (expr, error) {
+	switch {
+	case isOperatorDocument(v):
+		return compileOperators(segs, v.ReaderDocument(), path)
+	case v.Type() == bson.TypeRegex:
+		test, err := compileRegex(v, "", path)
+		if err != nil {
+			return nil, err
+		}
+		return fieldExpr{path: segs, test: test, expand: true}, nil
+	}
+
+	return fieldExpr{path: segs, test: equalTo(v), expand: true}, nil
+}
+
+// isOperatorDocument reports whether v is a document of operators, such as {"$gt": 1}.
+func isOperatorDocument(v *bson.Value) bool {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return false
+	}
+
+	elem, err := v.ReaderDocument().ElementAt(0)
+	return err == nil && strings.HasPrefix(elem.Key(), "$")
+}
+
+// compileOperators compiles a document of operators applied to the field at the path segs.
+func compileOperators(segs []string, ops bson.Reader, path string) (andExpr, error) {
+	options, err := ops.Lookup("$options")
+	if err != nil {
+		return nil, err
+	}
+
+	itr, err := ops.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var clauses andExpr
+	field := func(test func(*bson.Value) bool, expand bool) {
+		clauses = append(clauses, fieldExpr{path: segs, test: test, expand: expand})
+	}
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+		kpath := joinPath(path, key)
+
+		switch key {
+		case "$eq":
+			field(equalTo(v), true)
+		case "$ne":
+			clauses = append(clauses, notExpr{fieldExpr{path: segs, test: equalTo(v), expand: true}})
+		case "$gt", "$gte", "$lt", "$lte":
+			if v.Type() == bson.TypeRegex {
+				return nil, CompileError{Path: kpath, Message: "cannot compare to a regular expression"}
+			}
+			field(compareTo(key, v), true)
+		case "$in", "$nin":
+			test, err := compileIn(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			if key == "$in" {
+				field(test, true)
+			} else {
+				clauses = append(clauses, notExpr{fieldExpr{path: segs, test: test, expand: true}})
+			}
+		case "$exists":
+			field(exists(truthy(v)), false)
+		case "$type":
+			types, err := compileTypes(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			field(ofType(types), true)
+		case "$size":
+			n, ok := integerOperand(v)
+			if !ok || n < 0 {
+				return nil, CompileError{Path: kpath, Message: "must be a non-negative integer"}
+			}
+			field(size(n), false)
+		case "$all":
+			test, err := compileAll(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			field(test, false)
+		case "$elemMatch":
+			if v.Type() != bson.TypeEmbeddedDocument {
+				return nil, CompileError{Path: kpath, Message: "must be an object"}
+			}
+			e, err := compileElemMatch(v.ReaderDocument(), kpath)
+			if err != nil {
+				return nil, err
+			}
+			field(elemMatch(e), false)
+		case "$regex":
+			var opts string
+			if options != nil {
+				if options.Value().Type() != bson.TypeString {
+					return nil, CompileError{Path: joinPath(path, "$options"), Message: "must be a string"}
+				}
+				opts = options.Value().StringValue()
+			}
+			test, err := compileRegex(v, opts, kpath)
+			if err != nil {
+				return nil, err
+			}
+			field(test, true)
+		case "$options":
+			if elem, err := ops.Lookup("$regex"); err != nil || elem == nil {
+				return nil, CompileError{Path: kpath, Message: "$options requires $regex"}
+			}
+		case "$mod":
+			test, err := compileMod(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			field(test, true)
+		case "$not":
+			switch {
+			case isOperatorDocument(v):
+				sub, err := compileOperators(segs, v.ReaderDocument(), kpath)
+				if err != nil {
+					return nil, err
+				}
+				clauses = append(clauses, notExpr{sub})
+			case v.Type() == bson.TypeRegex:
+				test, err := compileRegex(v, "", kpath)
+				if err != nil {
+					return nil, err
+				}
+				clauses = append(clauses, notExpr{fieldExpr{path: segs, test: test, expand: true}})
+			default:
+				return nil, CompileError{Path: kpath, Message: "must be a regular expression or a document of operators"}
+			}
+		default:
+			return nil, CompileError{Path: kpath, Message: "unknown operator"}
+		}
+	}
+
+	return clauses, itr.Err()
+}
+
+// compileIn compiles the operand of $in or $nin.
+func compileIn(v *bson.Value, path string) (func(*bson.Value) bool, error) {
+	values, err := arrayOperand(v, path)
+	if err != nil {
+		return nil, err
+	}
+
+	tests := make([]func(*bson.Value) bool, 0, len(values))
+	for i, value := range values {
+		test, err := compileValue(value, joinPath(path, strconv.Itoa(i)))
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+
+	return func(v *bson.Value) bool {
+		for _, test := range tests {
+			if test(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// compileAll compiles the operand of $all.
+func compileAll(v *bson.Value, path string) (func(*bson.Value) bool, error) {
+	values, err := arrayOperand(v, path)
+	if err != nil {
+		return nil, err
+	}
+
+	tests := make([]func(*bson.Value) bool, 0, len(values))
+	for i, value := range values {
+		ipath := joinPath(path, strconv.Itoa(i))
+		if isOperatorDocument(value) {
+			elem, err := value.ReaderDocument().ElementAt(0)
+			if err != nil {
+				return nil, err
+			}
+			if elem.Key() != "$elemMatch" || elem.Value().Type() != bson.TypeEmbeddedDocument {
+				return nil, CompileError{Path: ipath, Message: "only $elemMatch may be used within $all"}
+			}
+			e, err := compileElemMatch(elem.Value().ReaderDocument(), joinPath(ipath, "$elemMatch"))
+			if err != nil {
+				return nil, err
+			}
+			tests = append(tests, elemMatch(e))
+			continue
+		}
+
+		test, err := compileValue(value, ipath)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+
+	return func(v *bson.Value) bool {
+		if v == nil || len(tests) == 0 {
+			return false
+		}
+		for _, test := range tests {
+			if !test(v) && !anyArrayElement(v, test) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compileValue compiles a value within $in, $nin, or $all, which is either a regular expression
+// or a value to compare for equality.
+func compileValue(v *bson.Value, path string) (func(*bson.Value) bool, error) {
+	if v.Type() == bson.TypeRegex {
+		return compileRegex(v, "", path)
+	}
+	if isOperatorDocument(v) {
+		return nil, CompileError{Path: path, Message: "cannot contain operators"}
+	}
+
+	return equalTo(v), nil
+}
+
+// compileTypes compiles the operand of $type, which is a type alias, a numeric type code, or an
+// array of either.
+func compileTypes(v *bson.Value, path string) (map[bson.Type]bool, error) {
+	values := []*bson.Value{v}
+	if v.Type() == bson.TypeArray {
+		var err error
+		values, err = arrayOperand(v, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	types := make(map[bson.Type]bool)
+	for _, value := range values {
+		if value.Type() == bson.TypeString && value.StringValue() == "number" {
+			types[bson.TypeDouble] = true
+			types[bson.TypeInt32] = true
+			types[bson.TypeInt64] = true
+			types[bson.TypeDecimal128] = true
+			continue
+		}
+
+		found := false
+		for _, t := range allTypes {
+			if value.Type() == bson.TypeString && t.Alias() == value.StringValue() {
+				types[t], found = true, true
+			}
+			if n, ok := integerOperand(value); ok && n == int64(int8(t)) {
+				types[t], found = true, true
+			}
+		}
+		if !found {
+			return nil, CompileError{Path: path, Message: fmt.Sprintf("unknown type %v", value)}
+		}
+	}
+
+	return types, nil
+}
+
+// compileRegex compiles a regular expression given either as a regex value or as a string with
+// separate options. The options of a regex value are used if options is empty.
+func compileRegex(v *bson.Value, options, path string) (func(*bson.Value) bool, error) {
+	var pattern string
+	switch v.Type() {
+	case bson.TypeRegex:
+		var opts string
+		pattern, opts = v.Regex()
+		if options == "" {
+			options = opts
+		}
+	case bson.TypeString:
+		pattern = v.StringValue()
+	default:
+		return nil, CompileError{Path: path, Message: "must be a string or a regular expression"}
+	}
+
+	var flags string
+	for _, o := range options {
+		switch o {
+		case 'i', 'm', 's':
+			flags += string(o)
+		default:
+			return nil, CompileError{Path: path, Message: fmt.Sprintf("unsupported regular expression option %q", o)}
+		}
+	}
+	expr := pattern
+	if flags != "" {
+		expr = "(?" + flags + ")" + pattern
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, CompileError{Path: path, Message: err.Error()}
+	}
+
+	return matchesRegex(re, pattern, options), nil
+}
+
+// compileMod compiles the operand of $mod, which is an array of a divisor and a remainder.
+func compileMod(v *bson.Value, path string) (func(*bson.Value) bool, error) {
+	values, err := arrayOperand(v, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 2 {
+		return nil, CompileError{Path: path, Message: "must be an array of a divisor and a remainder"}
+	}
+
+	divisor, ok := integerOperand(values[0])
+	if !ok || divisor == 0 {
+		return nil, CompileError{Path: path, Message: "divisor must be a non-zero integer"}
+	}
+	remainder, ok := integerOperand(values[1])
+	if !ok {
+		return nil, CompileError{Path: path, Message: "remainder must be an integer"}
+	}
+
+	return mod(divisor, remainder), nil
+}
+
+func arrayOperand(v *bson.Value, path string) ([]*bson.Value, error) {
+	if v.Type() != bson.TypeArray {
+		return nil, CompileError{Path: path, Message: "must be an array"}
+	}
+
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*bson.Value
+	for itr.Next() {
+		values = append(values, itr.Element().Clone().Value())
+	}
+
+	return values, itr.Err()
+}
+
+// integerOperand returns the value of a 32-bit integer, a 64-bit integer, or a double with an
+// integral value.
+func integerOperand(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+
+	return 0, false
+}
+
+// truthy reports whether the operand of $exists is true. False, zero, null, and undefined are
+// false, and every other value is true.
+func truthy(v *bson.Value) bool {
+	switch v.Type() {
+	case bson.TypeBoolean:
+		return v.Boolean()
+	case bson.TypeNull, bson.TypeUndefined:
+		return false
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble:
+		n, ok := integerOperand(v)
+		return !ok || n != 0
+	}
+
+	return true
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return parent + "." + key
+}
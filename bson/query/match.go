@@ -0,0 +1,276 @@
+package query
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// expr is a compiled filter or condition. The values given to match have been validated.
+type expr interface {
+	match(v *bson.Value) bool
+}
+
+// andExpr matches if all of its expressions match.
+type andExpr []expr
+
+func (a andExpr) match(v *bson.Value) bool {
+	for _, e := range a {
+		if !e.match(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// orExpr matches if any of its expressions match.
+type orExpr []expr
+
+func (o orExpr) match(v *bson.Value) bool {
+	for _, e := range o {
+		if e.match(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notExpr matches if its expression does not match.
+type notExpr struct {
+	e expr
+}
+
+func (n notExpr) match(v *bson.Value) bool {
+	return !n.e.match(v)
+}
+
+// documentExpr matches embedded documents which match its expression.
+type documentExpr struct {
+	e expr
+}
+
+func (d documentExpr) match(v *bson.Value) bool {
+	return v.Type() == bson.TypeEmbeddedDocument && d.e.match(v)
+}
+
+// fieldExpr applies a test to the values at a path within a document. The test is given nil if
+// the path does not exist. If the path is empty, the test is applied to the value itself.
+type fieldExpr struct {
+	path []string
+	test func(*bson.Value) bool
+
+	// expand is true if the test is also applied to the elements of an array at the end of the
+	// path.
+	expand bool
+}
+
+func (f fieldExpr) match(v *bson.Value) bool {
+	if len(f.path) == 0 {
+		return f.test(v)
+	}
+
+	return f.matchPath(v, f.path)
+}
+
+// matchPath applies the test to the values at the path segs within v.
+func (f fieldExpr) matchPath(v *bson.Value, segs []string) bool {
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		elem, err := v.ReaderDocument().Lookup(segs[0])
+		if err != nil || elem == nil {
+			return f.test(nil)
+		}
+		return f.matchChild(elem.Value(), segs[1:])
+	case bson.TypeArray:
+		arr := v.ReaderArray()
+
+		// A numeric segment selects the element at that index, and is also used as a key
+		// within the documents in the array.
+		_, err := strconv.ParseUint(segs[0], 10, 32)
+		index := err == nil
+		missing := false
+		if index {
+			elem, err := arr.Lookup(segs[0])
+			if err != nil || elem == nil {
+				missing = true
+			} else if f.matchChild(elem.Value(), segs[1:]) {
+				return true
+			}
+		}
+
+		empty := true
+		matched := anyElement(arr, func(elem *bson.Value) bool {
+			empty = false
+			if elem.Type() == bson.TypeEmbeddedDocument {
+				return f.matchPath(elem, segs)
+			}
+			missing = missing || !index
+			return false
+		})
+		if matched {
+			return true
+		}
+		return (missing || empty) && f.test(nil)
+	}
+
+	return f.test(nil)
+}
+
+// matchChild applies the test to the values at the path segs within v, or to v itself if the
+// path is empty.
+func (f fieldExpr) matchChild(v *bson.Value, segs []string) bool {
+	if len(segs) > 0 {
+		return f.matchPath(v, segs)
+	}
+
+	return f.test(v) || (f.expand && anyArrayElement(v, f.test))
+}
+
+// anyArrayElement reports whether v is an array with an element for which test returns true.
+func anyArrayElement(v *bson.Value, test func(*bson.Value) bool) bool {
+	return v.Type() == bson.TypeArray && anyElement(v.ReaderArray(), test)
+}
+
+func equalTo(operand *bson.Value) func(*bson.Value) bool {
+	if operand.Type() == bson.TypeNull {
+		return func(v *bson.Value) bool {
+			return v == nil || v.Type() == bson.TypeNull
+		}
+	}
+
+	return func(v *bson.Value) bool {
+		return v != nil && bson.CompareTypes(v.Type(), operand.Type()) == 0 && bson.CompareValues(v, operand) == 0
+	}
+}
+
+// compareTo returns the test for the comparison operator op. Only values in the same type
+// bracket as the operand match.
+func compareTo(op string, operand *bson.Value) func(*bson.Value) bool {
+	if operand.Type() == bson.TypeNull && (op == "$gte" || op == "$lte") {
+		return equalTo(operand)
+	}
+
+	return func(v *bson.Value) bool {
+		if v == nil || bson.CompareTypes(v.Type(), operand.Type()) != 0 {
+			return false
+		}
+
+		c := bson.CompareValues(v, operand)
+		switch op {
+		case "$gt":
+			return c > 0
+		case "$gte":
+			return c >= 0
+		case "$lt":
+			return c < 0
+		}
+		return c <= 0
+	}
+}
+
+func exists(want bool) func(*bson.Value) bool {
+	return func(v *bson.Value) bool {
+		return (v != nil) == want
+	}
+}
+
+func ofType(types map[bson.Type]bool) func(*bson.Value) bool {
+	return func(v *bson.Value) bool {
+		return v != nil && types[v.Type()]
+	}
+}
+
+func size(n int64) func(*bson.Value) bool {
+	return func(v *bson.Value) bool {
+		if v == nil || v.Type() != bson.TypeArray {
+			return false
+		}
+		var length int64
+		anyElement(v.ReaderArray(), func(*bson.Value) bool {
+			length++
+			return false
+		})
+		return length == n
+	}
+}
+
+func elemMatch(e expr) func(*bson.Value) bool {
+	return func(v *bson.Value) bool {
+		return v != nil && anyArrayElement(v, e.match)
+	}
+}
+
+// matchesRegex returns a test which matches strings and symbols matching re, and regular
+// expressions with the same pattern and options.
+func matchesRegex(re *regexp.Regexp, pattern, options string) func(*bson.Value) bool {
+	return func(v *bson.Value) bool {
+		if v == nil {
+			return false
+		}
+
+		switch v.Type() {
+		case bson.TypeString:
+			return re.MatchString(v.StringValue())
+		case bson.TypeSymbol:
+			return re.MatchString(v.Symbol())
+		case bson.TypeRegex:
+			p, o := v.Regex()
+			return p == pattern && o == options
+		}
+		return false
+	}
+}
+
+// mod returns a test which matches numbers whose integral part has the given remainder when
+// divided by divisor.
+func mod(divisor, remainder int64) func(*bson.Value) bool {
+	return func(v *bson.Value) bool {
+		if v == nil {
+			return false
+		}
+
+		var n int64
+		switch v.Type() {
+		case bson.TypeInt32:
+			n = int64(v.Int32())
+		case bson.TypeInt64:
+			n = v.Int64()
+		case bson.TypeDouble:
+			f := v.Double()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return false
+			}
+			n = int64(f)
+		case bson.TypeDecimal128:
+			f, err := strconv.ParseFloat(v.Decimal128().String(), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return false
+			}
+			n = int64(f)
+		default:
+			return false
+		}
+		return n%divisor == remainder
+	}
+}
+
+// anyElement reports whether f returns true for any element of the validated array arr. The
+// values given to f are only valid until f returns.
+func anyElement(arr bson.Reader, f func(*bson.Value) bool) bool {
+	itr, err := arr.Iterator()
+	if err != nil {
+		return false
+	}
+
+	for itr.Next() {
+		if f(itr.Element().Value()) {
+			return true
+		}
+	}
+
+	return false
+}
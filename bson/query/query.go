@@ -0,0 +1,122 @@
+// Package query matches BSON documents against MongoDB query filters.
+//
+// A filter is compiled once with Compile or CompileReader and the resulting Matcher can then be
+// used to test any number of documents:
+//
+//	m, err := query.Compile(bson.NewDocument(
+//		bson.C.SubDocumentFromElements("qty", bson.C.Int32("$gte", 10)),
+//		bson.C.String("status", "A"),
+//	))
+//	...
+//	ok, err := m.Matches(doc)
+//
+// Fields are identified by dotted paths. When a path traverses an array, each document in the
+// array is searched for the rest of the path, and a numeric path segment also selects the array
+// element at that index. When the value at the end of a path is an array, most operators match if
+// either the array itself or any of its elements matches. Values are compared using the BSON sort
+// order of bson.CompareValues, and comparison operators only match values of the same type
+// bracket as their operand, so {"a": {"$gt": 1}} matches numbers but not strings.
+package query
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+// CompileError is returned when a filter is invalid.
+type CompileError struct {
+	// Path is the dotted path of the invalid operator within the filter.
+	Path    string
+	Message string
+}
+
+func (ce CompileError) Error() string {
+	if ce.Path == "" {
+		return "query: " + ce.Message
+	}
+
+	return "query: " + ce.Path + ": " + ce.Message
+}
+
+// Matcher matches documents against a compiled filter. A Matcher is safe for concurrent use.
+type Matcher struct {
+	root expr
+}
+
+// Compile compiles a MongoDB query filter.
+//
+// The supported operators are $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $type, $size,
+// $all, $elemMatch, $regex, $options, $mod, $not, $and, $or, and $nor. The $comment operator is
+// accepted and ignored. Any other operator results in a CompileError. A regular expression used
+// as a value, either directly or within $in, $nin, or $all, matches strings using the pattern.
+// Patterns use the syntax of the regexp package and support the i, m, and s options.
+func Compile(filter *bson.Document) (*Matcher, error) {
+	b, err := filter.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileReader(b)
+}
+
+// CompileReader compiles a query filter provided as a bson.Reader. See Compile.
+func CompileReader(filter bson.Reader) (*Matcher, error) {
+	_, err := filter.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := compileFilter(filter, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Matcher{root: root}, nil
+}
+
+// CompileElemMatch compiles the operand of an $elemMatch operator. The resulting Matcher is used
+// with MatchesValue to test the elements of an array.
+//
+// If every key of spec is an operator other than $and, $or, and $nor, such as {"$gte": 80}, the
+// operators are applied to the element itself. Otherwise spec is a filter, and only elements
+// which are documents matching the filter match.
+func CompileElemMatch(spec bson.Reader) (*Matcher, error) {
+	_, err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := compileElemMatch(spec, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Matcher{root: root}, nil
+}
+
+// Matches reports whether the document r matches the filter. An error is returned only if r is
+// not a valid BSON document.
+func (m *Matcher) Matches(r bson.Reader) (bool, error) {
+	_, err := r.Validate()
+	if err != nil {
+		return false, err
+	}
+
+	return m.root.match(bson.AC.DocumentFromReader(r)), nil
+}
+
+// MatchesDocument reports whether the document d matches the filter.
+func (m *Matcher) MatchesDocument(d *bson.Document) (bool, error) {
+	b, err := d.MarshalBSON()
+	if err != nil {
+		return false, err
+	}
+
+	return m.Matches(b)
+}
+
+// MatchesValue reports whether v matches. For a Matcher returned by CompileElemMatch, v is an
+// element of an array. For any other Matcher, only embedded documents which match the filter
+// match. The value must be valid.
+func (m *Matcher) MatchesValue(v *bson.Value) bool {
+	return m.root.match(v)
+}
@@ -0,0 +1,286 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func filter(elems ...*bson.Element) *bson.Document {
+	return bson.NewDocument(elems...)
+}
+
+func ops(key string, elems ...*bson.Element) *bson.Element {
+	return C.SubDocumentFromElements(key, elems...)
+}
+
+func TestMatches(t *testing.T) {
+	doc := bson.NewDocument(
+		C.String("name", "Alice"),
+		C.Int32("age", 30),
+		C.Double("score", 7.5),
+		C.ArrayFromElements("tags", AC.String("a"), AC.String("b")),
+		C.ArrayFromElements("orders",
+			AC.DocumentFromElements(C.Int32("qty", 1), C.String("item", "x")),
+			AC.DocumentFromElements(C.Int32("qty", 5), C.String("item", "y")),
+		),
+		C.SubDocumentFromElements("address", C.String("city", "Oslo"), C.Null("zip")),
+		C.ArrayFromElements("matrix", AC.ArrayFromValues(AC.Int32(1), AC.Int32(2)), AC.Int32(3)),
+	)
+
+	testCases := []struct {
+		name   string
+		filter *bson.Document
+		want   bool
+	}{
+		{"empty", filter(), true},
+		{"equality", filter(C.String("name", "Alice")), true},
+		{"equality mismatch", filter(C.String("name", "Bob")), false},
+		{"numeric mismatch", filter(C.Int64("score", 7)), false},
+		{"numeric equality", filter(C.Double("age", 30)), true},
+		{"dotted path", filter(C.String("address.city", "Oslo")), true},
+		{"null matches null", filter(C.Null("address.zip")), true},
+		{"null matches missing", filter(C.Null("missing")), true},
+		{"array element equality", filter(C.String("tags", "b")), true},
+		{"whole array equality", filter(C.ArrayFromElements("tags", AC.String("a"), AC.String("b"))), true},
+		{"nested array equality", filter(C.ArrayFromElements("matrix", AC.Int32(1), AC.Int32(2))), true},
+		{"array of documents", filter(C.Int32("orders.qty", 5)), true},
+		{"array index", filter(C.String("orders.1.item", "y")), true},
+		{"array index mismatch", filter(C.String("orders.0.item", "y")), false},
+		{"array index scalar", filter(C.String("tags.0", "a")), true},
+		{"$eq", filter(ops("age", C.Int32("$eq", 30))), true},
+		{"$ne", filter(ops("tags", C.String("$ne", "a"))), false},
+		{"$ne missing", filter(ops("missing", C.Int32("$ne", 1))), true},
+		{"$gt", filter(ops("age", C.Int32("$gt", 29))), true},
+		{"$gt type bracketing", filter(ops("name", C.Int32("$gt", 0))), false},
+		{"$gte", filter(ops("score", C.Double("$gte", 7.5))), true},
+		{"$lt", filter(ops("name", C.String("$lt", "B"))), true},
+		{"$lte array", filter(ops("orders.qty", C.Int32("$lte", 1))), true},
+		{"range", filter(ops("age", C.Int32("$gt", 20), C.Int32("$lt", 25))), false},
+		{"$gte null matches missing", filter(ops("missing", C.Null("$gte"))), true},
+		{"$in", filter(ops("name", C.ArrayFromElements("$in", AC.String("Bob"), AC.String("Alice")))), true},
+		{"$in regex", filter(ops("name", C.ArrayFromElements("$in", AC.Regex("^al", "i")))), true},
+		{"$nin", filter(ops("tags", C.ArrayFromElements("$nin", AC.String("c"), AC.String("b")))), false},
+		{"$exists", filter(ops("address.zip", C.Boolean("$exists", true))), true},
+		{"$exists false", filter(ops("orders.price", C.Boolean("$exists", false))), true},
+		{"$exists number", filter(ops("missing", C.Int32("$exists", 1))), false},
+		{"$type alias", filter(ops("age", C.String("$type", "int"))), true},
+		{"$type number", filter(ops("score", C.String("$type", "number"))), true},
+		{"$type code", filter(ops("address.zip", C.Int32("$type", 10))), true},
+		{"$type array element", filter(ops("tags", C.String("$type", "string"))), true},
+		{"$type array", filter(ops("tags", C.String("$type", "array"))), true},
+		{"$type list", filter(ops("age", C.ArrayFromElements("$type", AC.String("string"), AC.String("long")))), false},
+		{"$size", filter(ops("tags", C.Int32("$size", 2))), true},
+		{"$size mismatch", filter(ops("tags", C.Int32("$size", 1))), false},
+		{"$all", filter(ops("tags", C.ArrayFromElements("$all", AC.String("b"), AC.String("a")))), true},
+		{"$all missing element", filter(ops("tags", C.ArrayFromElements("$all", AC.String("a"), AC.String("c")))), false},
+		{"$all empty", filter(ops("tags", C.ArrayFromElements("$all"))), false},
+		{"$all $elemMatch", filter(ops("orders", C.ArrayFromElements("$all",
+			AC.DocumentFromElements(ops("$elemMatch", C.Int32("qty", 1))),
+			AC.DocumentFromElements(ops("$elemMatch", ops("qty", C.Int32("$gt", 4)))),
+		))), true},
+		{"$elemMatch", filter(ops("orders", ops("$elemMatch", C.Int32("qty", 5), C.String("item", "y")))), true},
+		{"$elemMatch same element", filter(ops("orders", ops("$elemMatch", C.Int32("qty", 5), C.String("item", "x")))), false},
+		{"$elemMatch operators", filter(ops("tags", ops("$elemMatch", C.String("$gt", "a")))), true},
+		{"$elemMatch scalar", filter(ops("age", ops("$elemMatch", C.Int32("$gt", 0)))), false},
+		{"$regex", filter(ops("name", C.String("$regex", "^A.*e$"))), true},
+		{"$regex $options", filter(ops("name", C.String("$regex", "^alice"), C.String("$options", "i"))), true},
+		{"$regex case", filter(ops("name", C.String("$regex", "^alice"))), false},
+		{"regex literal", filter(C.Regex("address.city", "sl", "")), true},
+		{"$mod", filter(ops("age", C.ArrayFromElements("$mod", AC.Int32(7), AC.Int32(2)))), true},
+		{"$mod double", filter(ops("score", C.ArrayFromElements("$mod", AC.Int32(4), AC.Int32(3)))), true},
+		{"$not", filter(ops("age", ops("$not", C.Int32("$gt", 40)))), true},
+		{"$not regex", filter(ops("name", C.Regex("$not", "^A", ""))), false},
+		{"$not missing", filter(ops("missing", ops("$not", C.Int32("$gt", 40)))), true},
+		{"$and", filter(C.ArrayFromElements("$and",
+			AC.DocumentFromElements(C.String("name", "Alice")),
+			AC.DocumentFromElements(ops("age", C.Int32("$lt", 18))),
+		)), false},
+		{"$or", filter(C.ArrayFromElements("$or",
+			AC.DocumentFromElements(C.String("name", "Bob")),
+			AC.DocumentFromElements(ops("age", C.Int32("$gte", 18))),
+		)), true},
+		{"$nor", filter(C.ArrayFromElements("$nor",
+			AC.DocumentFromElements(C.String("name", "Bob")),
+			AC.DocumentFromElements(C.String("tags", "c")),
+		)), true},
+		{"$comment", filter(C.String("$comment", "ignored"), C.Int32("age", 30)), true},
+		{"embedded document equality", filter(ops("address", C.String("city", "Oslo"), C.Null("zip"))), true},
+		{"embedded document order", filter(ops("address", C.Null("zip"), C.String("city", "Oslo"))), false},
+	}
+
+	rdr, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := Compile(tc.filter)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := m.Matches(rdr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Unexpected result for %v. got %v; want %v", tc.filter, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchesArrayTraversal(t *testing.T) {
+	testCases := []struct {
+		name   string
+		doc    *bson.Document
+		filter *bson.Document
+		want   bool
+	}{
+		{
+			"null matches scalars in array",
+			bson.NewDocument(C.ArrayFromElements("a", AC.Int32(1))),
+			filter(C.Null("a.b")),
+			true,
+		},
+		{
+			"null does not match present field",
+			bson.NewDocument(C.ArrayFromElements("a", AC.DocumentFromElements(C.Int32("b", 1)))),
+			filter(C.Null("a.b")),
+			false,
+		},
+		{
+			"null matches empty array",
+			bson.NewDocument(C.ArrayFromElements("a")),
+			filter(C.Null("a.b")),
+			true,
+		},
+		{
+			"index does not match scalars as missing",
+			bson.NewDocument(C.ArrayFromElements("a", AC.Int32(5))),
+			filter(C.Null("a.0")),
+			false,
+		},
+		{
+			"index out of bounds is missing",
+			bson.NewDocument(C.ArrayFromElements("a", AC.Int32(5))),
+			filter(C.Null("a.1")),
+			true,
+		},
+		{
+			"numeric key in documents",
+			bson.NewDocument(C.ArrayFromElements("a", AC.DocumentFromElements(C.Int32("0", 7)))),
+			filter(C.Int32("a.0", 7)),
+			true,
+		},
+		{
+			"nested arrays of documents",
+			bson.NewDocument(C.ArrayFromElements("a", AC.DocumentFromElements(
+				C.ArrayFromElements("b", AC.DocumentFromElements(C.Int32("c", 3))),
+			))),
+			filter(C.Int32("a.b.c", 3)),
+			true,
+		},
+		{
+			"$ne over array of documents",
+			bson.NewDocument(C.ArrayFromElements("a",
+				AC.DocumentFromElements(C.Int32("b", 1)),
+				AC.DocumentFromElements(C.Int32("b", 2)),
+			)),
+			filter(ops("a.b", C.Int32("$ne", 2))),
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := Compile(tc.filter)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got, err := m.MatchesDocument(tc.doc)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Unexpected result. got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter *bson.Document
+		path   string
+	}{
+		{"unknown top level operator", filter(C.Int32("$where", 1)), "$where"},
+		{"unknown operator", filter(ops("a", C.Int32("$foo", 1))), "a.$foo"},
+		{"mixed operators and fields", filter(ops("a", C.Int32("$gt", 1), C.Int32("b", 1))), "a.b"},
+		{"$and not an array", filter(C.Int32("$and", 1)), "$and"},
+		{"$or empty", filter(C.ArrayFromElements("$or")), "$or"},
+		{"$or element", filter(C.ArrayFromElements("$or", AC.Int32(1))), "$or.0"},
+		{"$in not an array", filter(ops("a", C.Int32("$in", 1))), "a.$in"},
+		{"$size negative", filter(ops("a", C.Int32("$size", -1))), "a.$size"},
+		{"$type unknown", filter(ops("a", C.String("$type", "thing"))), "a.$type"},
+		{"$mod divisor", filter(ops("a", C.ArrayFromElements("$mod", AC.Int32(0), AC.Int32(0)))), "a.$mod"},
+		{"$regex invalid", filter(ops("a", C.String("$regex", "("))), "a.$regex"},
+		{"$regex option", filter(ops("a", C.String("$regex", "a"), C.String("$options", "x"))), "a.$regex"},
+		{"$options alone", filter(ops("a", C.String("$options", "i"))), "a.$options"},
+		{"$not", filter(ops("a", C.Int32("$not", 1))), "a.$not"},
+		{"empty path segment", filter(C.Int32("a..b", 1)), "a..b"},
+		{"nested in $or", filter(C.ArrayFromElements("$or", AC.DocumentFromElements(ops("a", C.Int32("$bad", 1))))), "$or.0.a.$bad"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.filter)
+			ce, ok := err.(CompileError)
+			if !ok {
+				t.Fatalf("Did not get expected error. got %v; want a CompileError", err)
+			}
+			if ce.Path != tc.path {
+				t.Errorf("Unexpected path. got %q; want %q", ce.Path, tc.path)
+			}
+		})
+	}
+}
+
+func TestCompileElemMatch(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec *bson.Document
+		v    *bson.Value
+		want bool
+	}{
+		{"operators", filter(C.Int32("$gte", 80)), AC.Int32(85), true},
+		{"operators mismatch", filter(C.Int32("$gte", 80)), AC.Int32(75), false},
+		{"filter", filter(C.String("a", "x")), AC.DocumentFromElements(C.String("a", "x")), true},
+		{"filter scalar", filter(C.String("a", "x")), AC.String("x"), false},
+		{"logical operators are a filter", filter(C.ArrayFromElements("$or", AC.DocumentFromElements(C.Int32("a", 1)))),
+			AC.DocumentFromElements(C.Int32("a", 1)), true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.spec.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			m, err := CompileElemMatch(b)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := m.MatchesValue(tc.v); got != tc.want {
+				t.Errorf("Unexpected result. got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
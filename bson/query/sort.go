@@ -0,0 +1,151 @@
+package query
+
+import (
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// Sort orders documents by a MongoDB sort specification, such as {"a": 1, "b.c": -1}. A Sort is
+// safe for concurrent use.
+type Sort struct {
+	keys []sortKey
+}
+
+type sortKey struct {
+	path       []string
+	descending bool
+}
+
+// CompileSort compiles a sort specification. Each field is a dotted path with the value 1 for
+// ascending order or -1 for descending order.
+//
+// Documents are compared by the value at each path in turn using bson.CompareValues. A missing
+// value sorts as null. When a path refers to an array, or traverses an array of documents, the
+// smallest of the values is used for ascending order and the largest for descending order.
+func CompileSort(spec bson.Reader) (*Sort, error) {
+	_, err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	itr, err := spec.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(Sort)
+	for itr.Next() {
+		elem := itr.Element()
+		key := elem.Key()
+
+		segs := strings.Split(key, ".")
+		for _, seg := range segs {
+			if seg == "" || strings.HasPrefix(seg, "$") {
+				return nil, CompileError{Path: key, Message: "invalid field path"}
+			}
+		}
+
+		n, ok := integerOperand(elem.Value())
+		if !ok || (n != 1 && n != -1) {
+			return nil, CompileError{Path: key, Message: "sort order must be 1 or -1"}
+		}
+		s.keys = append(s.keys, sortKey{path: segs, descending: n == -1})
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+	if len(s.keys) == 0 {
+		return nil, CompileError{Message: "sort specification must not be empty"}
+	}
+
+	return s, nil
+}
+
+// Compare compares the documents a and b, which must be valid, and returns -1 if a sorts before
+// b, +1 if a sorts after b, and 0 if their order is not determined by the sort specification.
+func (s *Sort) Compare(a, b bson.Reader) int {
+	return s.CompareKeys(s.Key(a), s.Key(b))
+}
+
+// Key returns the values of the valid document r which determine its order. Computing the keys
+// of each document once and comparing them with CompareKeys is faster than using Compare.
+func (s *Sort) Key(r bson.Reader) []*bson.Value {
+	root := bson.AC.DocumentFromReader(r)
+
+	key := make([]*bson.Value, 0, len(s.keys))
+	for _, k := range s.keys {
+		key = append(key, k.value(root))
+	}
+
+	return key
+}
+
+// CompareKeys compares the keys returned by Key for two documents.
+func (s *Sort) CompareKeys(a, b []*bson.Value) int {
+	for i, k := range s.keys {
+		c := bson.CompareValues(a[i], b[i])
+		if k.descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// value returns the value of the document root which determines its order.
+func (k sortKey) value(root *bson.Value) *bson.Value {
+	var best *bson.Value
+	sortValues(root, k.path, func(v *bson.Value) {
+		if best != nil {
+			c := bson.CompareValues(v, best)
+			if c == 0 || (c > 0) != k.descending {
+				return
+			}
+		}
+		best = bson.C.Value("", v).Value()
+	})
+
+	if best == nil {
+		return bson.AC.Null()
+	}
+
+	return best
+}
+
+// sortValues calls visit with each value at the path segs within v. The elements of an array at
+// the end of the path are visited instead of the array itself, and an array within the path is
+// searched by searching each document within it.
+func sortValues(v *bson.Value, segs []string, visit func(*bson.Value)) {
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		elem, err := v.ReaderDocument().Lookup(segs[0])
+		if err != nil || elem == nil {
+			return
+		}
+
+		child := elem.Value()
+		if len(segs) > 1 {
+			sortValues(child, segs[1:], visit)
+			return
+		}
+		if child.Type() != bson.TypeArray {
+			visit(child)
+			return
+		}
+		anyElement(child.ReaderArray(), func(elem *bson.Value) bool {
+			visit(elem)
+			return false
+		})
+	case bson.TypeArray:
+		anyElement(v.ReaderArray(), func(elem *bson.Value) bool {
+			if elem.Type() == bson.TypeEmbeddedDocument {
+				sortValues(elem, segs, visit)
+			}
+			return false
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+func TestSortCompare(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec *bson.Document
+		a, b *bson.Document
+		want int
+	}{
+		{
+			"ascending",
+			filter(C.Int32("a", 1)),
+			bson.NewDocument(C.Int32("a", 1)),
+			bson.NewDocument(C.Double("a", 2.5)),
+			-1,
+		},
+		{
+			"descending",
+			filter(C.Int32("a", -1)),
+			bson.NewDocument(C.Int32("a", 1)),
+			bson.NewDocument(C.Double("a", 2.5)),
+			1,
+		},
+		{
+			"type order",
+			filter(C.Int32("a", 1)),
+			bson.NewDocument(C.String("a", "x")),
+			bson.NewDocument(C.Int64("a", 100)),
+			1,
+		},
+		{
+			"missing sorts as null",
+			filter(C.Int32("a", 1)),
+			bson.NewDocument(),
+			bson.NewDocument(C.Null("a")),
+			0,
+		},
+		{
+			"second key",
+			filter(C.Int32("a", 1), C.Int32("b", -1)),
+			bson.NewDocument(C.Int32("a", 1), C.Int32("b", 1)),
+			bson.NewDocument(C.Int32("a", 1), C.Int32("b", 2)),
+			1,
+		},
+		{
+			"dotted path",
+			filter(C.Int32("a.b", 1)),
+			bson.NewDocument(C.SubDocumentFromElements("a", C.Int32("b", 3))),
+			bson.NewDocument(C.SubDocumentFromElements("a", C.Int32("b", 2))),
+			1,
+		},
+		{
+			"array ascending uses smallest element",
+			filter(C.Int32("a", 1)),
+			bson.NewDocument(C.ArrayFromElements("a", AC.Int32(5), AC.Int32(1))),
+			bson.NewDocument(C.Int32("a", 2)),
+			-1,
+		},
+		{
+			"array descending uses largest element",
+			filter(C.Int32("a", -1)),
+			bson.NewDocument(C.ArrayFromElements("a", AC.Int32(5), AC.Int32(1))),
+			bson.NewDocument(C.Int32("a", 2)),
+			-1,
+		},
+		{
+			"array of documents",
+			filter(C.Int32("a.b", 1)),
+			bson.NewDocument(C.ArrayFromElements("a",
+				AC.DocumentFromElements(C.Int32("b", 4)),
+				AC.DocumentFromElements(C.Int32("b", 3)),
+			)),
+			bson.NewDocument(C.ArrayFromElements("a", AC.DocumentFromElements(C.Int32("b", 2)))),
+			1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := tc.spec.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			s, err := CompileSort(spec)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			a, err := tc.a.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			b, err := tc.b.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := s.Compare(a, b); got != tc.want {
+				t.Errorf("Unexpected result. got %d; want %d", got, tc.want)
+			}
+			if got := s.Compare(b, a); got != -tc.want {
+				t.Errorf("Unexpected result for reversed arguments. got %d; want %d", got, -tc.want)
+			}
+		})
+	}
+}
+
+func TestCompileSortErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec *bson.Document
+		want error
+	}{
+		{"empty", filter(), CompileError{Message: "sort specification must not be empty"}},
+		{"invalid order", filter(C.Int32("a", 2)), CompileError{Path: "a", Message: "sort order must be 1 or -1"}},
+		{"string order", filter(C.String("a", "asc")), CompileError{Path: "a", Message: "sort order must be 1 or -1"}},
+		{"invalid path", filter(C.Int32("a..b", 1)), CompileError{Path: "a..b", Message: "invalid field path"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := tc.spec.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			_, err = CompileSort(spec)
+			if err != tc.want {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.want)
+			}
+		})
+	}
+}
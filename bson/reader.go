@@ -53,18 +53,11 @@ func NewFromIOReader(r io.Reader) (Reader, error) {
 }
 
 // Validate validates the document. This method only validates the first document in
-// the slice, to validate other documents, the slice must be resliced.
+// the slice, to validate other documents, the slice must be resliced. Keys and strings must be
+// valid UTF-8.
 func (r Reader) Validate() (size uint32, err error) {
-	return r.readElements(func(elem *Element) error {
-		var err error
-		switch elem.value.Type() {
-		case '\x03':
-			_, err = elem.value.ReaderDocument().Validate()
-		case '\x04':
-			_, err = elem.value.ReaderArray().Validate()
-		}
-		return err
-	})
+	v := validator{opts: &ValidateOptions{}}
+	return v.document(r, 1)
 }
 
 // validateKey will ensure the key is valid and return the length of the key
@@ -211,7 +204,7 @@ func (r Reader) readElements(f func(e *Element) error) (uint32, error) {
 	// slice without reslicing if we have pos as a parameter and use that to
 	// get the length of the document.
 	givenLength := readi32(r[0:4])
-	if len(r) < int(givenLength) {
+	if givenLength < 5 || len(r) < int(givenLength) {
 		return 0, ErrInvalidLength
 	}
 	var pos uint32 = 4
@@ -246,13 +239,18 @@ func (r Reader) readElements(f func(e *Element) error) (uint32, error) {
 			err = f(elem)
 			if err != nil {
 				if err == errValidateDone {
-					break
+					return pos + 1, nil
 				}
 				return pos, err
 			}
 		}
 	}
 
+	// The terminating null byte must be the last byte of the document.
+	if pos != end-1 {
+		return pos, ErrInvalidLength
+	}
+
 	// The size is always 1 larger than the position, since position is 0
 	// indexed.
 	return pos + 1, nil
@@ -16,7 +16,7 @@ func NewReaderIterator(r Reader) (*ReaderIterator, error) {
 		return nil, ErrTooSmall
 	}
 	givenLength := readi32(r[0:4])
-	if len(r) < int(givenLength) {
+	if givenLength < 5 || len(r) < int(givenLength) {
 		return nil, ErrInvalidLength
 	}
 
@@ -51,6 +51,8 @@ func (itr *ReaderIterator) Next() bool {
 	itr.elem.value.start = elemStart
 	itr.elem.value.offset = itr.pos
 	itr.elem.value.data = itr.r
+	// The Value is reused, so the document cached by a previous element must be cleared.
+	itr.elem.value.d = nil
 
 	n, err = itr.elem.value.validate(false)
 	itr.pos += n
@@ -105,6 +105,29 @@ func TestReader(t *testing.T) {
 				},
 				21, nil,
 			},
+			{"terminator before end",
+				Reader{'\x09', '\x00', '\x00', '\x00', '\x0A', 'x', '\x00', '\x00', '\x00'},
+				7, ErrInvalidLength,
+			},
+			{"binary negative length",
+				Reader{
+					'\x0D', '\x00', '\x00', '\x00',
+					'\x05', 'x', '\x00',
+					'\xFF', '\xFF', '\xFF', '\xFF', '\x00',
+					'\x00',
+				},
+				12, ErrInvalidLength,
+			},
+			{"binary old subtype length",
+				Reader{
+					'\x12', '\x00', '\x00', '\x00',
+					'\x05', 'x', '\x00',
+					'\x05', '\x00', '\x00', '\x00', '\x02',
+					'\x02', '\x00', '\x00', '\x00', 'a',
+					'\x00',
+				},
+				12, ErrInvalidLength,
+			},
 		}
 
 		for _, tc := range testCases {
@@ -0,0 +1,173 @@
+package schema
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+// typeOrder lists the BSON types in the order they are reported.
+var typeOrder = []bson.Type{
+	bson.TypeDouble,
+	bson.TypeString,
+	bson.TypeEmbeddedDocument,
+	bson.TypeArray,
+	bson.TypeBinary,
+	bson.TypeUndefined,
+	bson.TypeObjectID,
+	bson.TypeBoolean,
+	bson.TypeDateTime,
+	bson.TypeNull,
+	bson.TypeRegex,
+	bson.TypeDBPointer,
+	bson.TypeJavaScript,
+	bson.TypeSymbol,
+	bson.TypeCodeWithScope,
+	bson.TypeInt32,
+	bson.TypeTimestamp,
+	bson.TypeInt64,
+	bson.TypeDecimal128,
+	bson.TypeMinKey,
+	bson.TypeMaxKey,
+}
+
+// Document returns the statistics as a BSON document of the form:
+//
+//	{
+//		"count": <number of documents>,
+//		"maxDepth": <maximum nesting depth>,
+//		"paths": [{
+//			"path": <dotted path>,
+//			"count": <number of values>,
+//			"presence": <fraction of documents containing the path>,
+//			"types": {<bsonType alias>: <count>, ...},
+//			"cardinality": <estimated distinct values>,
+//			"minNumber": ..., "maxNumber": ...,
+//			"minString": ..., "maxString": ...,
+//			"minDate": ..., "maxDate": ...,
+//			"stringLengths": [<count of length 0>, <count of lengths [1, 2)>, ...],
+//			"arrayLengths": [...]
+//		}, ...]
+//	}
+//
+// The range and length fields are only present when values of the relevant type were observed.
+func (s *Schema) Document() *bson.Document {
+	paths := bson.NewArray()
+	for _, ps := range s.Paths() {
+		paths.Append(bson.AC.Document(ps.document()))
+	}
+
+	return bson.NewDocument(
+		bson.C.Int64("count", s.count),
+		bson.C.Int32("maxDepth", int32(s.maxDepth)),
+		bson.C.Array("paths", paths),
+	)
+}
+
+func (ps *PathStats) document() *bson.Document {
+	types := bson.NewDocument()
+	for _, t := range typeOrder {
+		if n := ps.types[t]; n > 0 {
+			types.Append(bson.C.Int64(t.Alias(), n))
+		}
+	}
+
+	doc := bson.NewDocument(
+		bson.C.String("path", ps.path),
+		bson.C.Int64("count", ps.count),
+		bson.C.Double("presence", ps.Presence()),
+		bson.C.SubDocument("types", types),
+		bson.C.Int64("cardinality", int64(ps.Cardinality())),
+	)
+
+	if ps.hasNumber {
+		doc.Append(bson.C.Double("minNumber", ps.minNumber), bson.C.Double("maxNumber", ps.maxNumber))
+	}
+	if ps.hasString {
+		doc.Append(bson.C.String("minString", ps.minString), bson.C.String("maxString", ps.maxString))
+	}
+	if ps.hasTime {
+		doc.Append(bson.C.DateTime("minDate", ps.minTime), bson.C.DateTime("maxDate", ps.maxTime))
+	}
+	if len(ps.stringLengths) > 0 {
+		doc.Append(bson.C.Array("stringLengths", histogramArray(ps.stringLengths)))
+	}
+	if len(ps.arrayLengths) > 0 {
+		doc.Append(bson.C.Array("arrayLengths", histogramArray(ps.arrayLengths)))
+	}
+
+	return doc
+}
+
+func histogramArray(h Histogram) *bson.Array {
+	arr := bson.NewArray()
+	for _, n := range h {
+		arr.Append(bson.AC.Int64(n))
+	}
+
+	return arr
+}
+
+// JSONSchema returns a $jsonSchema document describing the structure of the documents
+// observed. Each path is described with the bsonType aliases of the types observed at it.
+// Fields that were present in every document that could have contained them are listed as
+// required, and the elements of arrays are described by items. The result can be converted
+// to JSON with the extjson package.
+func (s *Schema) JSONSchema() *bson.Document {
+	root := bson.NewDocument(bson.C.String("bsonType", "object"))
+	s.root.appendObjectSchema(root)
+
+	return root
+}
+
+// jsonSchema returns the schema for the values observed at n.
+func (n *node) jsonSchema() *bson.Document {
+	doc := bson.NewDocument()
+
+	var aliases []string
+	for _, t := range typeOrder {
+		if n.stats.types[t] > 0 {
+			aliases = append(aliases, t.Alias())
+		}
+	}
+	switch len(aliases) {
+	case 0:
+	case 1:
+		doc.Append(bson.C.String("bsonType", aliases[0]))
+	default:
+		arr := bson.NewArray()
+		for _, alias := range aliases {
+			arr.Append(bson.AC.String(alias))
+		}
+		doc.Append(bson.C.Array("bsonType", arr))
+	}
+
+	if n.stats.types[bson.TypeEmbeddedDocument] > 0 {
+		n.appendObjectSchema(doc)
+	}
+	if n.elems != nil {
+		doc.Append(bson.C.SubDocument("items", n.elems.jsonSchema()))
+	}
+
+	return doc
+}
+
+// appendObjectSchema appends the required and properties keywords for the fields of n to doc.
+func (n *node) appendObjectSchema(doc *bson.Document) {
+	if len(n.keys) == 0 {
+		return
+	}
+
+	required := bson.NewArray()
+	properties := bson.NewDocument()
+	for _, key := range n.keys {
+		child := n.fields[key]
+		if child.stats.count >= child.stats.containers {
+			required.Append(bson.AC.String(key))
+		}
+		properties.Append(bson.C.SubDocument(key, child.jsonSchema()))
+	}
+
+	if required.Len() > 0 {
+		doc.Append(bson.C.Array("required", required))
+	}
+	doc.Append(bson.C.SubDocument("properties", properties))
+}
@@ -0,0 +1,248 @@
+// Package schema infers the structure of a set of BSON documents and validates documents
+// against a schema.
+//
+// A Schema accumulates statistics for every path observed in the documents given to it. Paths
+// are the keys of nested documents joined with dots, and the elements of an array are
+// identified by the path of the array followed by "[]". For example, given the documents
+//
+//	{"name": "Alice", "orders": [{"qty": 1}, {"qty": 2}]}
+//	{"name": "Bob", "orders": []}
+//
+// statistics are collected for the paths "name", "orders", "orders.[]", and "orders.[].qty".
+//
+// A Schema is not safe for concurrent use. To analyze documents in parallel, use a Schema for
+// each goroutine and combine them with Merge.
+package schema
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+// ElementsPath is the path segment used for the elements of an array.
+const ElementsPath = "[]"
+
+// Schema accumulates statistics about the documents it observes.
+type Schema struct {
+	count    int64
+	maxDepth int
+	root     *node
+}
+
+// node holds the statistics for a single path and the paths nested below it.
+type node struct {
+	stats *PathStats
+
+	// docs is the number of embedded documents observed at this path, or the number of
+	// top-level documents for the root.
+	docs int64
+
+	keys   []string
+	fields map[string]*node
+	elems  *node
+}
+
+func newNode(path string) *node {
+	return &node{stats: newPathStats(path), fields: make(map[string]*node)}
+}
+
+// New creates an empty Schema.
+func New() *Schema {
+	return &Schema{root: newNode("")}
+}
+
+// Count returns the number of documents observed.
+func (s *Schema) Count() int64 { return s.count }
+
+// MaxDepth returns the maximum nesting depth of the documents observed. A document without
+// embedded documents or arrays has a depth of 1.
+func (s *Schema) MaxDepth() int { return s.maxDepth }
+
+// Observe adds the document r to the statistics. The document is validated first, and if it is
+// invalid the statistics are not modified.
+func (s *Schema) Observe(r bson.Reader) error {
+	_, err := r.Validate()
+	if err != nil {
+		return err
+	}
+
+	depth, err := s.root.observeDocument(r, 1)
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	if depth > s.maxDepth {
+		s.maxDepth = depth
+	}
+
+	return nil
+}
+
+// Merge adds the statistics accumulated by other to s. other is not modified.
+func (s *Schema) Merge(other *Schema) {
+	s.count += other.count
+	if other.maxDepth > s.maxDepth {
+		s.maxDepth = other.maxDepth
+	}
+
+	s.root.merge(other.root)
+}
+
+// Paths returns the statistics for every path observed. Paths are returned depth first, with
+// the keys of each document in the order they were first observed.
+func (s *Schema) Paths() []*PathStats {
+	var paths []*PathStats
+	s.root.walk(func(n *node) {
+		paths = append(paths, n.stats)
+	})
+
+	return paths
+}
+
+// Path returns the statistics for the given dotted path, or nil if the path was not observed.
+func (s *Schema) Path(path string) *PathStats {
+	var found *PathStats
+	s.root.walk(func(n *node) {
+		if found == nil && n.stats.path == path {
+			found = n.stats
+		}
+	})
+
+	return found
+}
+
+// walk calls f for each node below n.
+func (n *node) walk(f func(*node)) {
+	for _, key := range n.keys {
+		child := n.fields[key]
+		f(child)
+		child.walk(f)
+	}
+
+	if n.elems != nil {
+		f(n.elems)
+		n.elems.walk(f)
+	}
+}
+
+func (n *node) field(key string) *node {
+	child, ok := n.fields[key]
+	if !ok {
+		child = newNode(joinPath(n.stats.path, key))
+		n.fields[key] = child
+		n.keys = append(n.keys, key)
+	}
+
+	return child
+}
+
+func (n *node) elements() *node {
+	if n.elems == nil {
+		n.elems = newNode(joinPath(n.stats.path, ElementsPath))
+	}
+
+	return n.elems
+}
+
+// observeDocument records the elements of r and returns the depth of r.
+func (n *node) observeDocument(r bson.Reader, depth int) (int, error) {
+	itr, err := r.Iterator()
+	if err != nil {
+		return 0, err
+	}
+
+	n.docs++
+	for _, child := range n.fields {
+		child.stats.containers++
+	}
+
+	max := depth
+	for itr.Next() {
+		elem := itr.Element()
+
+		child, ok := n.fields[elem.Key()]
+		if !ok {
+			child = n.field(elem.Key())
+			// The documents observed before this key was first seen could have contained it.
+			child.stats.containers = n.docs
+		}
+
+		d, err := child.observeValue(elem.Value(), depth)
+		if err != nil {
+			return 0, err
+		}
+		if d > max {
+			max = d
+		}
+	}
+
+	return max, itr.Err()
+}
+
+// observeValue records v and returns the depth of the document containing v, including any
+// documents nested within v.
+func (n *node) observeValue(v *bson.Value, depth int) (int, error) {
+	n.stats.add(v)
+
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		return n.observeDocument(v.ReaderDocument(), depth+1)
+	case bson.TypeArray:
+		itr, err := v.ReaderArray().Iterator()
+		if err != nil {
+			return 0, err
+		}
+
+		max := depth + 1
+		length := 0
+		for itr.Next() {
+			length++
+			d, err := n.elements().observeValue(itr.Element().Value(), depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if d > max {
+				max = d
+			}
+		}
+		n.stats.arrayLengths.add(length)
+
+		return max, itr.Err()
+	}
+
+	return depth, nil
+}
+
+func (n *node) merge(other *node) {
+	n.docs += other.docs
+	n.stats.merge(other.stats)
+
+	for _, key := range other.keys {
+		child, ok := n.fields[key]
+		if !ok {
+			child = n.field(key)
+			// The documents observed by n could have contained the key.
+			child.stats.containers = n.docs - other.docs
+		}
+		child.merge(other.fields[key])
+	}
+
+	// Keys that were observed by n but not by other could have appeared in other's documents.
+	for _, key := range n.keys {
+		if _, ok := other.fields[key]; !ok {
+			n.fields[key].stats.containers += other.docs
+		}
+	}
+
+	if other.elems != nil {
+		n.elements().merge(other.elems)
+	}
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return parent + "." + key
+}
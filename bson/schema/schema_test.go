@@ -0,0 +1,282 @@
+package schema
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+func sampleDocuments() []*bson.Document {
+	return []*bson.Document{
+		bson.NewDocument(
+			bson.C.String("name", "Alice"),
+			bson.C.Int32("age", 30),
+			bson.C.ArrayFromElements("orders",
+				bson.AC.DocumentFromElements(bson.C.Int32("qty", 1)),
+				bson.AC.DocumentFromElements(bson.C.Int32("qty", 2), bson.C.String("note", "gift")),
+			),
+			bson.C.DateTime("created", 1000),
+		),
+		bson.NewDocument(
+			bson.C.String("name", "Bob"),
+			bson.C.Double("age", 41.5),
+			bson.C.ArrayFromElements("orders"),
+			bson.C.SubDocumentFromElements("address", bson.C.String("city", "Springfield")),
+			bson.C.DateTime("created", 500),
+		),
+		bson.NewDocument(
+			bson.C.String("name", "Carol"),
+			bson.C.Null("age"),
+			bson.C.SubDocumentFromElements("address", bson.C.SubDocumentFromElements("geo", bson.C.Double("lat", 1))),
+		),
+	}
+}
+
+func TestSchemaObserve(t *testing.T) {
+	s := New()
+	for _, doc := range sampleDocuments() {
+		err := s.Observe(bsontest.Marshal(t, doc))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if s.Count() != 3 {
+		t.Errorf("Unexpected count. got %d; want 3", s.Count())
+	}
+	if s.MaxDepth() != 3 {
+		t.Errorf("Unexpected max depth. got %d; want 3", s.MaxDepth())
+	}
+
+	var paths []string
+	for _, ps := range s.Paths() {
+		paths = append(paths, ps.Path())
+	}
+	want := []string{
+		"name", "age", "orders", "orders.[]", "orders.[].qty", "orders.[].note", "created",
+		"address", "address.city", "address.geo", "address.geo.lat",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("Unexpected paths. got %v; want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Unexpected paths. got %v; want %v", paths, want)
+			break
+		}
+	}
+
+	t.Run("presence", func(t *testing.T) {
+		testCases := []struct {
+			path     string
+			presence float64
+		}{
+			{"name", 1},
+			{"created", 2.0 / 3},
+			{"address", 2.0 / 3},
+			{"address.city", 0.5},
+			{"orders.[]", 1},
+			{"orders.[].note", 0.5},
+		}
+		for _, tc := range testCases {
+			if got := s.Path(tc.path).Presence(); got != tc.presence {
+				t.Errorf("Unexpected presence for %s. got %v; want %v", tc.path, got, tc.presence)
+			}
+		}
+	})
+	t.Run("types", func(t *testing.T) {
+		types := s.Path("age").Types()
+		if types[bson.TypeInt32] != 1 || types[bson.TypeDouble] != 1 || types[bson.TypeNull] != 1 {
+			t.Errorf("Unexpected types for age: %v", types)
+		}
+	})
+	t.Run("ranges", func(t *testing.T) {
+		min, max, ok := s.Path("age").NumberRange()
+		if !ok || min != 30 || max != 41.5 {
+			t.Errorf("Unexpected number range. got %v, %v, %v; want 30, 41.5, true", min, max, ok)
+		}
+
+		smin, smax, ok := s.Path("name").StringRange()
+		if !ok || smin != "Alice" || smax != "Carol" {
+			t.Errorf("Unexpected string range. got %v, %v, %v; want Alice, Carol, true", smin, smax, ok)
+		}
+
+		tmin, tmax, ok := s.Path("created").TimeRange()
+		if !ok || timeToMS(tmin) != 500 || timeToMS(tmax) != 1000 {
+			t.Errorf("Unexpected time range. got %v, %v, %v", tmin, tmax, ok)
+		}
+
+		if _, _, ok := s.Path("name").NumberRange(); ok {
+			t.Errorf("Expected no number range for name")
+		}
+	})
+	t.Run("histograms", func(t *testing.T) {
+		// "Bob" has length 3 and "Alice" and "Carol" have length 5.
+		got := s.Path("name").StringLengths()
+		want := Histogram{0, 0, 1, 2}
+		if !equalHistograms(got, want) {
+			t.Errorf("Unexpected string lengths. got %v; want %v", got, want)
+		}
+
+		got = s.Path("orders").ArrayLengths()
+		want = Histogram{1, 0, 1}
+		if !equalHistograms(got, want) {
+			t.Errorf("Unexpected array lengths. got %v; want %v", got, want)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		err := s.Observe(bson.Reader{0x05, 0x00, 0x00, 0x00, 0x01})
+		if err == nil {
+			t.Errorf("Expected an error observing an invalid document")
+		}
+		if s.Count() != 3 {
+			t.Errorf("Invalid document should not be counted")
+		}
+	})
+}
+
+func equalHistograms(h1, h2 Histogram) bool {
+	if len(h1) != len(h2) {
+		return false
+	}
+	for i := range h1 {
+		if h1[i] != h2[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestCardinality(t *testing.T) {
+	s := New()
+	for i := 0; i < 5000; i++ {
+		err := s.Observe(bsontest.Marshal(t, bson.NewDocument(bson.C.Int64("a", int64(i%2000)), bson.C.Boolean("b", i%2 == 0))))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if got := s.Path("a").Cardinality(); got < 1800 || got > 2200 {
+		t.Errorf("Cardinality estimate is too far off. got %d; want about 2000", got)
+	}
+	if got := s.Path("b").Cardinality(); got != 2 {
+		t.Errorf("Unexpected cardinality. got %d; want 2", got)
+	}
+}
+
+func TestSchemaMerge(t *testing.T) {
+	docs := sampleDocuments()
+
+	all := New()
+	for _, doc := range docs {
+		if err := all.Observe(bsontest.Marshal(t, doc)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Merge the documents observed in a different order so the keys are first seen in a
+	// different order.
+	merged := New()
+	for _, split := range [][]*bson.Document{docs[2:], docs[:2]} {
+		part := New()
+		for _, doc := range split {
+			if err := part.Observe(bsontest.Marshal(t, doc)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		merged.Merge(part)
+	}
+
+	for _, want := range all.Paths() {
+		got := merged.Path(want.Path())
+		if got == nil {
+			t.Errorf("Path %s missing from merged schema", want.Path())
+			continue
+		}
+
+		if !bytes.Equal(bsontest.Marshal(t, got.document()), bsontest.Marshal(t, want.document())) {
+			t.Errorf("Merged statistics for %s do not match.\ngot  %v\nwant %v", want.Path(), got.document(), want.document())
+		}
+	}
+	if merged.Count() != all.Count() || merged.MaxDepth() != all.MaxDepth() {
+		t.Errorf("Merged totals do not match. got %d, %d; want %d, %d", merged.Count(), merged.MaxDepth(), all.Count(), all.MaxDepth())
+	}
+}
+
+func TestSchemaExport(t *testing.T) {
+	s := New()
+	for _, doc := range sampleDocuments()[:2] {
+		if err := s.Observe(bsontest.Marshal(t, doc)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	t.Run("JSONSchema", func(t *testing.T) {
+		want := bson.NewDocument(
+			bson.C.String("bsonType", "object"),
+			bson.C.ArrayFromElements("required", bson.AC.String("name"), bson.AC.String("age"), bson.AC.String("orders"), bson.AC.String("created")),
+			bson.C.SubDocumentFromElements("properties",
+				bson.C.SubDocumentFromElements("name", bson.C.String("bsonType", "string")),
+				bson.C.SubDocumentFromElements("age", bson.C.ArrayFromElements("bsonType", bson.AC.String("double"), bson.AC.String("int"))),
+				bson.C.SubDocumentFromElements("orders",
+					bson.C.String("bsonType", "array"),
+					bson.C.SubDocumentFromElements("items",
+						bson.C.String("bsonType", "object"),
+						bson.C.ArrayFromElements("required", bson.AC.String("qty")),
+						bson.C.SubDocumentFromElements("properties",
+							bson.C.SubDocumentFromElements("qty", bson.C.String("bsonType", "int")),
+							bson.C.SubDocumentFromElements("note", bson.C.String("bsonType", "string")),
+						),
+					),
+				),
+				bson.C.SubDocumentFromElements("created", bson.C.String("bsonType", "date")),
+				bson.C.SubDocumentFromElements("address",
+					bson.C.String("bsonType", "object"),
+					bson.C.ArrayFromElements("required", bson.AC.String("city")),
+					bson.C.SubDocumentFromElements("properties",
+						bson.C.SubDocumentFromElements("city", bson.C.String("bsonType", "string")),
+					),
+				),
+			),
+		)
+
+		got := s.JSONSchema()
+		if !bytes.Equal(bsontest.Marshal(t, got), bsontest.Marshal(t, want)) {
+			t.Errorf("JSON Schema does not match.\ngot  %v\nwant %v", got, want)
+		}
+	})
+	t.Run("Document", func(t *testing.T) {
+		doc := s.Document()
+		r := bson.Reader(bsontest.Marshal(t, doc))
+
+		elem, err := r.Lookup("count")
+		if err != nil || elem.Value().Int64() != 2 {
+			t.Errorf("Unexpected count: %v, %v", elem, err)
+		}
+
+		elem, err = r.Lookup("paths")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		first, err := elem.Value().MutableArray().Lookup(0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := bson.NewDocument(
+			bson.C.String("path", "name"),
+			bson.C.Int64("count", 2),
+			bson.C.Double("presence", 1),
+			bson.C.SubDocumentFromElements("types", bson.C.Int64("string", 2)),
+			bson.C.Int64("cardinality", 2),
+			bson.C.String("minString", "Alice"),
+			bson.C.String("maxString", "Bob"),
+			bson.C.ArrayFromElements("stringLengths", bson.AC.Int64(0), bson.AC.Int64(0), bson.AC.Int64(1), bson.AC.Int64(1)),
+		)
+		if !bytes.Equal(bsontest.Marshal(t, first.MutableDocument()), bsontest.Marshal(t, want)) {
+			t.Errorf("Path statistics do not match.\ngot  %v\nwant %v", first.MutableDocument(), want)
+		}
+	})
+}
@@ -0,0 +1,365 @@
+package schema
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// PathStats holds the statistics collected for a single path.
+type PathStats struct {
+	path  string
+	count int64
+	types map[bson.Type]int64
+
+	// containers is the number of documents in which the path could have appeared. It is zero
+	// for the elements of arrays.
+	containers int64
+
+	hasNumber            bool
+	minNumber, maxNumber float64
+
+	hasString            bool
+	minString, maxString string
+
+	hasTime          bool
+	minTime, maxTime int64
+
+	stringLengths Histogram
+	arrayLengths  Histogram
+	distinct      *hll
+}
+
+func newPathStats(path string) *PathStats {
+	return &PathStats{path: path, types: make(map[bson.Type]int64)}
+}
+
+// Path returns the dotted path the statistics are for.
+func (ps *PathStats) Path() string { return ps.path }
+
+// Count returns the number of values observed at the path.
+func (ps *PathStats) Count() int64 { return ps.count }
+
+// Presence returns the fraction of the documents that could contain the path that did contain
+// it. For example, the presence of "a.b" is the fraction of the embedded documents observed at
+// "a" which have a "b" key. The presence of the elements of an array is always 1.
+func (ps *PathStats) Presence() float64 {
+	if ps.containers == 0 {
+		return 1
+	}
+
+	return float64(ps.count) / float64(ps.containers)
+}
+
+// Types returns the number of values of each BSON type observed at the path.
+func (ps *PathStats) Types() map[bson.Type]int64 {
+	types := make(map[bson.Type]int64, len(ps.types))
+	for t, n := range ps.types {
+		types[t] = n
+	}
+
+	return types
+}
+
+// NumberRange returns the minimum and maximum of the double, 32-bit integer, and 64-bit integer
+// values observed at the path. ok is false if no such values were observed.
+func (ps *PathStats) NumberRange() (min, max float64, ok bool) {
+	return ps.minNumber, ps.maxNumber, ps.hasNumber
+}
+
+// StringRange returns the lexicographically smallest and largest string values observed at the
+// path. ok is false if no string values were observed.
+func (ps *PathStats) StringRange() (min, max string, ok bool) {
+	return ps.minString, ps.maxString, ps.hasString
+}
+
+// TimeRange returns the earliest and latest datetime values observed at the path. ok is false
+// if no datetime values were observed.
+func (ps *PathStats) TimeRange() (min, max time.Time, ok bool) {
+	return msToTime(ps.minTime), msToTime(ps.maxTime), ps.hasTime
+}
+
+// Cardinality returns an estimate of the number of distinct values observed at the path. The
+// estimate has a standard error of about 3%.
+func (ps *PathStats) Cardinality() uint64 {
+	if ps.distinct == nil {
+		return 0
+	}
+
+	return ps.distinct.estimate()
+}
+
+// StringLengths returns the distribution of the lengths, in bytes, of the string values
+// observed at the path.
+func (ps *PathStats) StringLengths() Histogram { return ps.stringLengths.clone() }
+
+// ArrayLengths returns the distribution of the lengths of the arrays observed at the path.
+func (ps *PathStats) ArrayLengths() Histogram { return ps.arrayLengths.clone() }
+
+// add records the value v.
+func (ps *PathStats) add(v *bson.Value) {
+	ps.count++
+	ps.types[v.Type()]++
+
+	if ps.distinct == nil {
+		ps.distinct = new(hll)
+	}
+	ps.distinct.add(hashValue(v))
+
+	switch v.Type() {
+	case bson.TypeDouble:
+		ps.addNumber(v.Double())
+	case bson.TypeInt32:
+		ps.addNumber(float64(v.Int32()))
+	case bson.TypeInt64:
+		ps.addNumber(float64(v.Int64()))
+	case bson.TypeString:
+		s := v.StringValue()
+		ps.addString(s)
+		ps.stringLengths.add(len(s))
+	case bson.TypeDateTime:
+		ps.addTime(timeToMS(v.DateTime()))
+	}
+}
+
+func (ps *PathStats) addNumber(f float64) {
+	if math.IsNaN(f) {
+		return
+	}
+	if !ps.hasNumber || f < ps.minNumber {
+		ps.minNumber = f
+	}
+	if !ps.hasNumber || f > ps.maxNumber {
+		ps.maxNumber = f
+	}
+	ps.hasNumber = true
+}
+
+func (ps *PathStats) addString(s string) {
+	if !ps.hasString || s < ps.minString {
+		ps.minString = s
+	}
+	if !ps.hasString || s > ps.maxString {
+		ps.maxString = s
+	}
+	ps.hasString = true
+}
+
+func (ps *PathStats) addTime(ms int64) {
+	if !ps.hasTime || ms < ps.minTime {
+		ps.minTime = ms
+	}
+	if !ps.hasTime || ms > ps.maxTime {
+		ps.maxTime = ms
+	}
+	ps.hasTime = true
+}
+
+// merge adds the statistics of other to ps.
+func (ps *PathStats) merge(other *PathStats) {
+	ps.count += other.count
+	ps.containers += other.containers
+	for t, n := range other.types {
+		ps.types[t] += n
+	}
+
+	if other.hasNumber {
+		ps.addNumber(other.minNumber)
+		ps.addNumber(other.maxNumber)
+	}
+	if other.hasString {
+		ps.addString(other.minString)
+		ps.addString(other.maxString)
+	}
+	if other.hasTime {
+		ps.addTime(other.minTime)
+		ps.addTime(other.maxTime)
+	}
+
+	ps.stringLengths.merge(other.stringLengths)
+	ps.arrayLengths.merge(other.arrayLengths)
+
+	if other.distinct != nil {
+		if ps.distinct == nil {
+			ps.distinct = new(hll)
+		}
+		ps.distinct.merge(other.distinct)
+	}
+}
+
+// Histogram counts lengths in buckets whose bounds are powers of two. Bucket 0 counts lengths
+// of 0 and bucket i counts lengths in the range [2^(i-1), 2^i).
+type Histogram []int64
+
+// Count returns the total number of lengths counted.
+func (h Histogram) Count() int64 {
+	var total int64
+	for _, n := range h {
+		total += n
+	}
+
+	return total
+}
+
+// Bounds returns the smallest and largest lengths counted by bucket i.
+func (h Histogram) Bounds(i int) (min, max int) {
+	if i == 0 {
+		return 0, 0
+	}
+
+	return 1 << uint(i-1), 1<<uint(i) - 1
+}
+
+func (h *Histogram) add(length int) {
+	bucket := bits.Len(uint(length))
+	for len(*h) <= bucket {
+		*h = append(*h, 0)
+	}
+	(*h)[bucket]++
+}
+
+func (h *Histogram) merge(other Histogram) {
+	for len(*h) < len(other) {
+		*h = append(*h, 0)
+	}
+	for i, n := range other {
+		(*h)[i] += n
+	}
+}
+
+func (h Histogram) clone() Histogram {
+	if h == nil {
+		return nil
+	}
+
+	return append(Histogram(nil), h...)
+}
+
+const hllPrecision = 10
+
+// hll is a HyperLogLog sketch used to estimate the number of distinct values at a path.
+// Sketches are merged by taking the maximum of each register.
+type hll [1 << hllPrecision]uint8
+
+func (h *hll) add(x uint64) {
+	idx := x >> (64 - hllPrecision)
+	rho := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rho > h[idx] {
+		h[idx] = rho
+	}
+}
+
+func (h *hll) merge(other *hll) {
+	for i, r := range other {
+		if r > h[i] {
+			h[i] = r
+		}
+	}
+}
+
+func (h *hll) estimate() uint64 {
+	m := float64(len(h))
+
+	var sum float64
+	var zeros int
+	for _, r := range h {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Use linear counting for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// hashValue returns a 64-bit hash of the type and contents of v.
+func hashValue(v *bson.Value) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	writeUint64 := func(u uint64) {
+		binary.LittleEndian.PutUint64(buf[:], u)
+		_, _ = h.Write(buf[:])
+	}
+
+	_, _ = h.Write([]byte{byte(v.Type())})
+	switch v.Type() {
+	case bson.TypeDouble:
+		writeUint64(math.Float64bits(v.Double()))
+	case bson.TypeString:
+		_, _ = h.Write([]byte(v.StringValue()))
+	case bson.TypeEmbeddedDocument:
+		_, _ = h.Write(v.ReaderDocument())
+	case bson.TypeArray:
+		_, _ = h.Write(v.ReaderArray())
+	case bson.TypeBinary:
+		subtype, data := v.Binary()
+		_, _ = h.Write([]byte{subtype})
+		_, _ = h.Write(data)
+	case bson.TypeObjectID:
+		oid := v.ObjectID()
+		_, _ = h.Write(oid[:])
+	case bson.TypeBoolean:
+		if v.Boolean() {
+			_, _ = h.Write([]byte{1})
+		}
+	case bson.TypeDateTime:
+		writeUint64(uint64(timeToMS(v.DateTime())))
+	case bson.TypeRegex:
+		pattern, options := v.Regex()
+		_, _ = h.Write([]byte(pattern + "\x00" + options))
+	case bson.TypeDBPointer:
+		ns, oid := v.DBPointer()
+		_, _ = h.Write([]byte(ns))
+		_, _ = h.Write(oid[:])
+	case bson.TypeJavaScript:
+		_, _ = h.Write([]byte(v.JavaScript()))
+	case bson.TypeSymbol:
+		_, _ = h.Write([]byte(v.Symbol()))
+	case bson.TypeCodeWithScope:
+		code, scope := v.ReaderJavaScriptWithScope()
+		_, _ = h.Write([]byte(code))
+		_, _ = h.Write(scope)
+	case bson.TypeInt32:
+		writeUint64(uint64(v.Int32()))
+	case bson.TypeTimestamp:
+		t, i := v.Timestamp()
+		writeUint64(uint64(t)<<32 | uint64(i))
+	case bson.TypeInt64:
+		writeUint64(uint64(v.Int64()))
+	case bson.TypeDecimal128:
+		high, low := v.Decimal128().GetBytes()
+		writeUint64(high)
+		writeUint64(low)
+	}
+
+	return mix(h.Sum64())
+}
+
+// mix is the finalizer of SplitMix64. It spreads the entropy of an FNV hash into the high bits,
+// which are used to choose HyperLogLog registers.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func timeToMS(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond()/1e6)
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(ms/1000, ms%1000*1e6)
+}
@@ -0,0 +1,726 @@
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// CompileError is returned by Compile when a schema is invalid.
+type CompileError struct {
+	// Path is the dotted path of the invalid keyword within the schema.
+	Path    string
+	Message string
+}
+
+func (ce CompileError) Error() string {
+	if ce.Path == "" {
+		return "schema: " + ce.Message
+	}
+
+	return "schema: " + ce.Path + ": " + ce.Message
+}
+
+// Violation describes a way in which a document does not conform to a schema.
+type Violation struct {
+	// Path is the dotted path of the value that violates the schema. The elements of arrays are
+	// identified by their index. The path of the document itself is empty.
+	Path string
+
+	// Keyword is the schema keyword that was violated, such as "bsonType" or "required".
+	Keyword string
+
+	Message string
+}
+
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "(root)"
+	}
+
+	return path + ": " + v.Message + " (" + v.Keyword + ")"
+}
+
+// Validator validates documents against a compiled $jsonSchema.
+type Validator struct {
+	root *rule
+}
+
+// Compile compiles a MongoDB $jsonSchema document. The document may either be the schema
+// itself, or a query document of the form {"$jsonSchema": <schema>}.
+//
+// The supported keywords are bsonType, type, required, properties, patternProperties,
+// additionalProperties, minProperties, maxProperties, items, additionalItems, minItems,
+// maxItems, uniqueItems, enum, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// minLength, maxLength, pattern, allOf, anyOf, oneOf, and not. The title and description
+// keywords are accepted and ignored. Any other keyword results in a CompileError. Patterns use
+// the syntax of the regexp package.
+func Compile(schema *bson.Document) (*Validator, error) {
+	b, err := schema.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileReader(b)
+}
+
+// CompileReader compiles a $jsonSchema document provided as a bson.Reader. See Compile.
+func CompileReader(schema bson.Reader) (*Validator, error) {
+	_, err := schema.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if elem, err := schema.ElementAt(0); err == nil && elem.Key() == "$jsonSchema" {
+		if _, err := schema.ElementAt(1); err == bson.ErrOutOfBounds {
+			if elem.Value().Type() != bson.TypeEmbeddedDocument {
+				return nil, CompileError{Message: "$jsonSchema must be an object"}
+			}
+			schema = elem.Value().ReaderDocument()
+		}
+	}
+
+	root, err := compileRule(schema, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{root: root}, nil
+}
+
+// Validate validates the document r and returns every violation of the schema. An error is
+// returned only if r is not a valid BSON document.
+func (v *Validator) Validate(r bson.Reader) ([]Violation, error) {
+	_, err := r.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []Violation
+	v.root.validate(bson.AC.DocumentFromReader(r), "", &violations)
+
+	return violations, nil
+}
+
+// ValidateDocument validates the document d and returns every violation of the schema.
+func (v *Validator) ValidateDocument(d *bson.Document) ([]Violation, error) {
+	b, err := d.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Validate(b)
+}
+
+// rule is a compiled schema.
+type rule struct {
+	types       map[bson.Type]bool
+	typeNames   []string
+	typeKeyword string
+
+	required          []string
+	properties        map[string]*rule
+	patternProperties []patternRule
+	additional        *rule
+	noAdditional      bool
+	minProperties     int
+	maxProperties     int
+
+	items             *rule
+	tupleItems        []*rule
+	additionalItems   *rule
+	noAdditionalItems bool
+	minItems          int
+	maxItems          int
+	uniqueItems       bool
+
+	enum []*bson.Value
+
+	minimum, maximum *float64
+	exclusiveMinimum bool
+	exclusiveMaximum bool
+
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+
+	allOf, anyOf, oneOf []*rule
+	not                 *rule
+}
+
+type patternRule struct {
+	re   *regexp.Regexp
+	rule *rule
+}
+
+// jsonTypes maps the values of the type keyword to BSON types.
+var jsonTypes = map[string][]bson.Type{
+	"object":  {bson.TypeEmbeddedDocument},
+	"array":   {bson.TypeArray},
+	"number":  {bson.TypeDouble, bson.TypeInt32, bson.TypeInt64, bson.TypeDecimal128},
+	"boolean": {bson.TypeBoolean},
+	"string":  {bson.TypeString},
+	"null":    {bson.TypeNull},
+}
+
+func compileRule(schema bson.Reader, path string) (*rule, error) {
+	r := &rule{minProperties: -1, maxProperties: -1, minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+
+	itr, err := schema.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var exclusiveMinimum, exclusiveMaximum bool
+	for itr.Next() {
+		elem := itr.Element()
+		key, v := elem.Key(), elem.Value()
+		kpath := joinPath(path, key)
+
+		switch key {
+		case "bsonType", "type":
+			names, err := stringOrStrings(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			if r.types == nil {
+				r.types = make(map[bson.Type]bool)
+			}
+			for _, name := range names {
+				types, ok := bsonTypes(key, name)
+				if !ok {
+					return nil, CompileError{Path: kpath, Message: fmt.Sprintf("unknown type %q", name)}
+				}
+				for _, t := range types {
+					r.types[t] = true
+				}
+				r.typeNames = append(r.typeNames, name)
+			}
+			r.typeKeyword = key
+		case "required":
+			r.required, err = stringOrStrings(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+		case "properties":
+			r.properties, err = compileRuleMap(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+		case "patternProperties":
+			rules, err := compileRuleMap(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+			patterns := make([]string, 0, len(rules))
+			for pattern := range rules {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+			for _, pattern := range patterns {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return nil, CompileError{Path: joinPath(kpath, pattern), Message: err.Error()}
+				}
+				r.patternProperties = append(r.patternProperties, patternRule{re: re, rule: rules[pattern]})
+			}
+		case "additionalProperties":
+			r.additional, r.noAdditional, err = compileBoolOrRule(v, kpath)
+			if err != nil {
+				return nil, err
+			}
+		case "minProperties":
+			r.minProperties, err = nonNegativeInt(v, kpath)
+		case "maxProperties":
+			r.maxProperties, err = nonNegativeInt(v, kpath)
+		case "items":
+			switch v.Type() {
+			case bson.TypeEmbeddedDocument:
+				r.items, err = compileRule(v.ReaderDocument(), kpath)
+			case bson.TypeArray:
+				r.tupleItems, err = compileRuleArray(v, kpath)
+			default:
+				err = CompileError{Path: kpath, Message: "must be an object or an array"}
+			}
+		case "additionalItems":
+			r.additionalItems, r.noAdditionalItems, err = compileBoolOrRule(v, kpath)
+		case "minItems":
+			r.minItems, err = nonNegativeInt(v, kpath)
+		case "maxItems":
+			r.maxItems, err = nonNegativeInt(v, kpath)
+		case "uniqueItems":
+			r.uniqueItems, err = boolean(v, kpath)
+		case "enum":
+			if v.Type() != bson.TypeArray {
+				return nil, CompileError{Path: kpath, Message: "must be an array"}
+			}
+			r.enum, err = arrayValues(v.ReaderArray())
+			if err == nil && len(r.enum) == 0 {
+				err = CompileError{Path: kpath, Message: "must not be empty"}
+			}
+		case "minimum":
+			r.minimum, err = number(v, kpath)
+		case "maximum":
+			r.maximum, err = number(v, kpath)
+		case "exclusiveMinimum":
+			exclusiveMinimum, err = boolean(v, kpath)
+		case "exclusiveMaximum":
+			exclusiveMaximum, err = boolean(v, kpath)
+		case "minLength":
+			r.minLength, err = nonNegativeInt(v, kpath)
+		case "maxLength":
+			r.maxLength, err = nonNegativeInt(v, kpath)
+		case "pattern":
+			if v.Type() != bson.TypeString {
+				return nil, CompileError{Path: kpath, Message: "must be a string"}
+			}
+			r.pattern, err = regexp.Compile(v.StringValue())
+			if err != nil {
+				err = CompileError{Path: kpath, Message: err.Error()}
+			}
+		case "allOf":
+			r.allOf, err = compileRuleArray(v, kpath)
+		case "anyOf":
+			r.anyOf, err = compileRuleArray(v, kpath)
+		case "oneOf":
+			r.oneOf, err = compileRuleArray(v, kpath)
+		case "not":
+			if v.Type() != bson.TypeEmbeddedDocument {
+				return nil, CompileError{Path: kpath, Message: "must be an object"}
+			}
+			r.not, err = compileRule(v.ReaderDocument(), kpath)
+		case "title", "description":
+		default:
+			return nil, CompileError{Path: kpath, Message: "unknown keyword"}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := itr.Err(); err != nil {
+		return nil, err
+	}
+
+	if exclusiveMinimum && r.minimum == nil {
+		return nil, CompileError{Path: path, Message: "exclusiveMinimum requires minimum"}
+	}
+	if exclusiveMaximum && r.maximum == nil {
+		return nil, CompileError{Path: path, Message: "exclusiveMaximum requires maximum"}
+	}
+	r.exclusiveMinimum, r.exclusiveMaximum = exclusiveMinimum, exclusiveMaximum
+
+	return r, nil
+}
+
+func bsonTypes(keyword, name string) ([]bson.Type, bool) {
+	if keyword == "type" {
+		types, ok := jsonTypes[name]
+		return types, ok
+	}
+
+	if name == "number" {
+		return jsonTypes["number"], true
+	}
+	for _, t := range typeOrder {
+		if t.Alias() == name {
+			return []bson.Type{t}, true
+		}
+	}
+
+	return nil, false
+}
+
+func compileRuleMap(v *bson.Value, path string) (map[string]*rule, error) {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, CompileError{Path: path, Message: "must be an object"}
+	}
+
+	itr, err := v.ReaderDocument().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make(map[string]*rule)
+	for itr.Next() {
+		elem := itr.Element()
+		kpath := joinPath(path, elem.Key())
+		if elem.Value().Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: kpath, Message: "must be an object"}
+		}
+
+		rules[elem.Key()], err = compileRule(elem.Value().ReaderDocument(), kpath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, itr.Err()
+}
+
+func compileRuleArray(v *bson.Value, path string) ([]*rule, error) {
+	if v.Type() != bson.TypeArray {
+		return nil, CompileError{Path: path, Message: "must be an array"}
+	}
+
+	values, err := arrayValues(v.ReaderArray())
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, CompileError{Path: path, Message: "must not be empty"}
+	}
+
+	rules := make([]*rule, 0, len(values))
+	for i, value := range values {
+		ipath := joinPath(path, strconv.Itoa(i))
+		if value.Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: ipath, Message: "must be an object"}
+		}
+
+		r, err := compileRule(value.ReaderDocument(), ipath)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+func compileBoolOrRule(v *bson.Value, path string) (*rule, bool, error) {
+	switch v.Type() {
+	case bson.TypeBoolean:
+		return nil, !v.Boolean(), nil
+	case bson.TypeEmbeddedDocument:
+		r, err := compileRule(v.ReaderDocument(), path)
+		return r, false, err
+	}
+
+	return nil, false, CompileError{Path: path, Message: "must be a boolean or an object"}
+}
+
+func arrayValues(r bson.Reader) ([]*bson.Value, error) {
+	itr, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*bson.Value
+	for itr.Next() {
+		values = append(values, itr.Element().Clone().Value())
+	}
+
+	return values, itr.Err()
+}
+
+func stringOrStrings(v *bson.Value, path string) ([]string, error) {
+	if v.Type() == bson.TypeString {
+		return []string{v.StringValue()}, nil
+	}
+	if v.Type() != bson.TypeArray {
+		return nil, CompileError{Path: path, Message: "must be a string or an array of strings"}
+	}
+
+	values, err := arrayValues(v.ReaderArray())
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if value.Type() != bson.TypeString {
+			return nil, CompileError{Path: path, Message: "must be a string or an array of strings"}
+		}
+		strs = append(strs, value.StringValue())
+	}
+
+	return strs, nil
+}
+
+func nonNegativeInt(v *bson.Value, path string) (int, error) {
+	f, ok := numberValue(v)
+	if !ok || f < 0 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, CompileError{Path: path, Message: "must be a non-negative integer"}
+	}
+
+	return int(f), nil
+}
+
+func number(v *bson.Value, path string) (*float64, error) {
+	f, ok := numberValue(v)
+	if !ok {
+		return nil, CompileError{Path: path, Message: "must be a number"}
+	}
+
+	return &f, nil
+}
+
+func boolean(v *bson.Value, path string) (bool, error) {
+	if v.Type() != bson.TypeBoolean {
+		return false, CompileError{Path: path, Message: "must be a boolean"}
+	}
+
+	return v.Boolean(), nil
+}
+
+// numberValue returns the value of a double, 32-bit integer, or 64-bit integer as a float64.
+func numberValue(v *bson.Value) (float64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		return v.Double(), true
+	case bson.TypeInt32:
+		return float64(v.Int32()), true
+	case bson.TypeInt64:
+		return float64(v.Int64()), true
+	}
+
+	return 0, false
+}
+
+// validate appends the violations of r by v, whose dotted path is path, to violations.
+func (r *rule) validate(v *bson.Value, path string, violations *[]Violation) {
+	report := func(keyword, format string, args ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	t := v.Type()
+	if r.types != nil && !r.types[t] {
+		report(r.typeKeyword, "expected type %s, got %s", strings.Join(r.typeNames, " or "), t.Alias())
+	}
+
+	if len(r.enum) > 0 {
+		found := false
+		for _, e := range r.enum {
+			if valuesEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("enum", "value is not one of the enumerated values")
+		}
+	}
+
+	switch t {
+	case bson.TypeEmbeddedDocument:
+		r.validateObject(v.ReaderDocument(), path, violations)
+	case bson.TypeArray:
+		r.validateArray(v.ReaderArray(), path, violations)
+	case bson.TypeString:
+		s := v.StringValue()
+		length := utf8.RuneCountInString(s)
+		if r.minLength >= 0 && length < r.minLength {
+			report("minLength", "string length %d is less than %d", length, r.minLength)
+		}
+		if r.maxLength >= 0 && length > r.maxLength {
+			report("maxLength", "string length %d is greater than %d", length, r.maxLength)
+		}
+		if r.pattern != nil && !r.pattern.MatchString(s) {
+			report("pattern", "string does not match pattern %q", r.pattern.String())
+		}
+	case bson.TypeDouble, bson.TypeInt32, bson.TypeInt64:
+		f, _ := numberValue(v)
+		if r.minimum != nil && (f < *r.minimum || r.exclusiveMinimum && f == *r.minimum) {
+			report("minimum", "%v is less than the minimum of %v", f, *r.minimum)
+		}
+		if r.maximum != nil && (f > *r.maximum || r.exclusiveMaximum && f == *r.maximum) {
+			report("maximum", "%v is greater than the maximum of %v", f, *r.maximum)
+		}
+	}
+
+	for _, sub := range r.allOf {
+		sub.validate(v, path, violations)
+	}
+
+	if len(r.anyOf) > 0 {
+		matched := false
+		for _, sub := range r.anyOf {
+			if sub.matches(v, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			report("anyOf", "value does not match any of the schemas")
+		}
+	}
+
+	if len(r.oneOf) > 0 {
+		matched := 0
+		for _, sub := range r.oneOf {
+			if sub.matches(v, path) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			report("oneOf", "value matches %d of the schemas, expected exactly 1", matched)
+		}
+	}
+
+	if r.not != nil && r.not.matches(v, path) {
+		report("not", "value matches a schema it must not match")
+	}
+}
+
+func (r *rule) matches(v *bson.Value, path string) bool {
+	var violations []Violation
+	r.validate(v, path, &violations)
+	return len(violations) == 0
+}
+
+func (r *rule) validateObject(doc bson.Reader, path string, violations *[]Violation) {
+	itr, err := doc.Iterator()
+	if err != nil {
+		return
+	}
+
+	present := make(map[string]bool)
+	count := 0
+	for itr.Next() {
+		elem := itr.Element()
+		key, v := elem.Key(), elem.Value()
+		kpath := joinPath(path, key)
+		present[key] = true
+		count++
+
+		matched := false
+		if sub, ok := r.properties[key]; ok {
+			sub.validate(v, kpath, violations)
+			matched = true
+		}
+		for _, pr := range r.patternProperties {
+			if pr.re.MatchString(key) {
+				pr.rule.validate(v, kpath, violations)
+				matched = true
+			}
+		}
+
+		if matched {
+			continue
+		}
+		switch {
+		case r.noAdditional:
+			*violations = append(*violations, Violation{Path: kpath, Keyword: "additionalProperties", Message: "field is not allowed"})
+		case r.additional != nil:
+			r.additional.validate(v, kpath, violations)
+		}
+	}
+
+	for _, key := range r.required {
+		if !present[key] {
+			*violations = append(*violations, Violation{Path: joinPath(path, key), Keyword: "required", Message: "required field is missing"})
+		}
+	}
+
+	if r.minProperties >= 0 && count < r.minProperties {
+		*violations = append(*violations, Violation{Path: path, Keyword: "minProperties", Message: fmt.Sprintf("object has %d fields, fewer than %d", count, r.minProperties)})
+	}
+	if r.maxProperties >= 0 && count > r.maxProperties {
+		*violations = append(*violations, Violation{Path: path, Keyword: "maxProperties", Message: fmt.Sprintf("object has %d fields, more than %d", count, r.maxProperties)})
+	}
+}
+
+func (r *rule) validateArray(arr bson.Reader, path string, violations *[]Violation) {
+	values, err := arrayValues(arr)
+	if err != nil {
+		return
+	}
+
+	for i, v := range values {
+		ipath := joinPath(path, strconv.Itoa(i))
+		switch {
+		case r.items != nil:
+			r.items.validate(v, ipath, violations)
+		case i < len(r.tupleItems):
+			r.tupleItems[i].validate(v, ipath, violations)
+		case r.tupleItems != nil && r.noAdditionalItems:
+			*violations = append(*violations, Violation{Path: ipath, Keyword: "additionalItems", Message: "array element is not allowed"})
+		case r.tupleItems != nil && r.additionalItems != nil:
+			r.additionalItems.validate(v, ipath, violations)
+		}
+	}
+
+	if r.minItems >= 0 && len(values) < r.minItems {
+		*violations = append(*violations, Violation{Path: path, Keyword: "minItems", Message: fmt.Sprintf("array has %d elements, fewer than %d", len(values), r.minItems)})
+	}
+	if r.maxItems >= 0 && len(values) > r.maxItems {
+		*violations = append(*violations, Violation{Path: path, Keyword: "maxItems", Message: fmt.Sprintf("array has %d elements, more than %d", len(values), r.maxItems)})
+	}
+
+	if r.uniqueItems {
+		for i := range values {
+			for j := i + 1; j < len(values); j++ {
+				if valuesEqual(values[i], values[j]) {
+					*violations = append(*violations, Violation{Path: path, Keyword: "uniqueItems", Message: fmt.Sprintf("elements %d and %d are equal", i, j)})
+					return
+				}
+			}
+		}
+	}
+}
+
+// valuesEqual reports whether a and b are equal. Numbers of different types are equal if they
+// have the same numeric value. Documents and arrays are equal if their bytes are equal.
+func valuesEqual(a, b *bson.Value) bool {
+	fa, aNum := numberValue(a)
+	fb, bNum := numberValue(b)
+	if aNum || bNum {
+		return aNum && bNum && fa == fb
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Type() {
+	case bson.TypeString:
+		return a.StringValue() == b.StringValue()
+	case bson.TypeEmbeddedDocument:
+		return bytes.Equal(a.ReaderDocument(), b.ReaderDocument())
+	case bson.TypeArray:
+		return bytes.Equal(a.ReaderArray(), b.ReaderArray())
+	case bson.TypeBinary:
+		as, ad := a.Binary()
+		bs, bd := b.Binary()
+		return as == bs && bytes.Equal(ad, bd)
+	case bson.TypeObjectID:
+		return a.ObjectID() == b.ObjectID()
+	case bson.TypeBoolean:
+		return a.Boolean() == b.Boolean()
+	case bson.TypeDateTime:
+		return a.DateTime().Equal(b.DateTime())
+	case bson.TypeRegex:
+		ap, ao := a.Regex()
+		bp, bo := b.Regex()
+		return ap == bp && ao == bo
+	case bson.TypeDBPointer:
+		ans, aoid := a.DBPointer()
+		bns, boid := b.DBPointer()
+		return ans == bns && aoid == boid
+	case bson.TypeJavaScript:
+		return a.JavaScript() == b.JavaScript()
+	case bson.TypeSymbol:
+		return a.Symbol() == b.Symbol()
+	case bson.TypeCodeWithScope:
+		ac, as := a.ReaderJavaScriptWithScope()
+		bc, bs := b.ReaderJavaScriptWithScope()
+		return ac == bc && bytes.Equal(as, bs)
+	case bson.TypeTimestamp:
+		at, ai := a.Timestamp()
+		bt, bi := b.Timestamp()
+		return at == bt && ai == bi
+	case bson.TypeDecimal128:
+		return a.Decimal128() == b.Decimal128()
+	}
+
+	// Null, undefined, min key, and max key have no value.
+	return true
+}
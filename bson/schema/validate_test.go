@@ -0,0 +1,296 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/skriptble/wilson/bson"
+)
+
+func personSchema() *bson.Document {
+	return bson.NewDocument(
+		bson.C.SubDocumentFromElements("$jsonSchema",
+			bson.C.String("bsonType", "object"),
+			bson.C.ArrayFromElements("required", bson.AC.String("name"), bson.AC.String("age")),
+			bson.C.SubDocumentFromElements("properties",
+				bson.C.SubDocumentFromElements("name",
+					bson.C.String("bsonType", "string"),
+					bson.C.Int32("minLength", 1),
+					bson.C.String("pattern", "^[A-Z]"),
+				),
+				bson.C.SubDocumentFromElements("age",
+					bson.C.String("bsonType", "number"),
+					bson.C.Int32("minimum", 0),
+					bson.C.Int32("maximum", 150),
+				),
+				bson.C.SubDocumentFromElements("status",
+					bson.C.ArrayFromElements("enum", bson.AC.String("active"), bson.AC.String("inactive")),
+				),
+				bson.C.SubDocumentFromElements("tags",
+					bson.C.String("bsonType", "array"),
+					bson.C.Int32("maxItems", 2),
+					bson.C.Boolean("uniqueItems", true),
+					bson.C.SubDocumentFromElements("items", bson.C.String("bsonType", "string")),
+				),
+				bson.C.SubDocumentFromElements("address",
+					bson.C.String("bsonType", "object"),
+					bson.C.ArrayFromElements("required", bson.AC.String("city")),
+					bson.C.Boolean("additionalProperties", false),
+					bson.C.SubDocumentFromElements("properties",
+						bson.C.SubDocumentFromElements("city", bson.C.String("bsonType", "string")),
+					),
+					bson.C.SubDocumentFromElements("patternProperties",
+						bson.C.SubDocumentFromElements("^x_", bson.C.String("bsonType", "int")),
+					),
+				),
+				bson.C.SubDocumentFromElements("contact",
+					bson.C.ArrayFromElements("oneOf",
+						bson.AC.DocumentFromElements(bson.C.ArrayFromElements("required", bson.AC.String("email"))),
+						bson.AC.DocumentFromElements(bson.C.ArrayFromElements("required", bson.AC.String("phone"))),
+					),
+				),
+				bson.C.SubDocumentFromElements("id",
+					bson.C.SubDocumentFromElements("not", bson.C.String("bsonType", "null")),
+				),
+			),
+		),
+	)
+}
+
+func TestValidate(t *testing.T) {
+	v, err := Compile(personSchema())
+	if err != nil {
+		t.Fatalf("Unexpected error compiling schema: %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		doc  *bson.Document
+		want []Violation
+	}{
+		{
+			"valid",
+			bson.NewDocument(
+				bson.C.String("name", "Alice"),
+				bson.C.Int64("age", 30),
+				bson.C.String("status", "active"),
+				bson.C.ArrayFromElements("tags", bson.AC.String("a"), bson.AC.String("b")),
+				bson.C.SubDocumentFromElements("address", bson.C.String("city", "Springfield"), bson.C.Int32("x_zip", 12345)),
+				bson.C.SubDocumentFromElements("contact", bson.C.String("email", "alice@example.com")),
+				bson.C.Int32("id", 1),
+			),
+			nil,
+		},
+		{
+			"missing required",
+			bson.NewDocument(bson.C.String("name", "Alice")),
+			[]Violation{{Path: "age", Keyword: "required", Message: "required field is missing"}},
+		},
+		{
+			"every violation",
+			bson.NewDocument(
+				bson.C.String("name", "alice"),
+				bson.C.Double("age", 200),
+				bson.C.String("status", "deleted"),
+				bson.C.ArrayFromElements("tags", bson.AC.String("a"), bson.AC.Int32(1), bson.AC.String("a")),
+				bson.C.SubDocumentFromElements("address", bson.C.String("x_zip", "12345"), bson.C.Boolean("extra", true)),
+				bson.C.SubDocumentFromElements("contact", bson.C.String("email", "a@example.com"), bson.C.String("phone", "555")),
+				bson.C.Null("id"),
+			),
+			[]Violation{
+				{Path: "name", Keyword: "pattern", Message: `string does not match pattern "^[A-Z]"`},
+				{Path: "age", Keyword: "maximum", Message: "200 is greater than the maximum of 150"},
+				{Path: "status", Keyword: "enum", Message: "value is not one of the enumerated values"},
+				{Path: "tags.1", Keyword: "bsonType", Message: "expected type string, got int"},
+				{Path: "tags", Keyword: "maxItems", Message: "array has 3 elements, more than 2"},
+				{Path: "tags", Keyword: "uniqueItems", Message: "elements 0 and 2 are equal"},
+				{Path: "address.x_zip", Keyword: "bsonType", Message: "expected type int, got string"},
+				{Path: "address.extra", Keyword: "additionalProperties", Message: "field is not allowed"},
+				{Path: "address.city", Keyword: "required", Message: "required field is missing"},
+				{Path: "contact", Keyword: "oneOf", Message: "value matches 2 of the schemas, expected exactly 1"},
+				{Path: "id", Keyword: "not", Message: "value matches a schema it must not match"},
+			},
+		},
+		{
+			"wrong root field types",
+			bson.NewDocument(
+				bson.C.Int32("name", 1),
+				bson.C.String("age", "thirty"),
+			),
+			[]Violation{
+				{Path: "name", Keyword: "bsonType", Message: "expected type string, got int"},
+				{Path: "age", Keyword: "bsonType", Message: "expected type number, got string"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := v.ValidateDocument(tc.doc)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Violations differ: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateKeywords(t *testing.T) {
+	testCases := []struct {
+		name   string
+		schema *bson.Document
+		doc    *bson.Document
+		want   []string
+	}{
+		{
+			"exclusive minimum",
+			bson.NewDocument(bson.C.SubDocumentFromElements("properties", bson.C.SubDocumentFromElements("a",
+				bson.C.Int32("minimum", 1), bson.C.Boolean("exclusiveMinimum", true)))),
+			bson.NewDocument(bson.C.Int32("a", 1)),
+			[]string{"a: 1 is less than the minimum of 1 (minimum)"},
+		},
+		{
+			"numeric enum across types",
+			bson.NewDocument(bson.C.SubDocumentFromElements("properties", bson.C.SubDocumentFromElements("a",
+				bson.C.ArrayFromElements("enum", bson.AC.Int32(1), bson.AC.Int32(2))))),
+			bson.NewDocument(bson.C.Double("a", 2)),
+			nil,
+		},
+		{
+			"min length counts code points",
+			bson.NewDocument(bson.C.SubDocumentFromElements("properties", bson.C.SubDocumentFromElements("a",
+				bson.C.Int32("maxLength", 2)))),
+			bson.NewDocument(bson.C.String("a", "éé")),
+			nil,
+		},
+		{
+			"tuple items",
+			bson.NewDocument(bson.C.SubDocumentFromElements("properties", bson.C.SubDocumentFromElements("a",
+				bson.C.ArrayFromElements("items", bson.AC.DocumentFromElements(bson.C.String("bsonType", "string"))),
+				bson.C.Boolean("additionalItems", false),
+				bson.C.Int32("minItems", 3)))),
+			bson.NewDocument(bson.C.ArrayFromElements("a", bson.AC.Int32(1), bson.AC.Int32(2))),
+			[]string{
+				"a.0: expected type string, got int (bsonType)",
+				"a.1: array element is not allowed (additionalItems)",
+				"a: array has 2 elements, fewer than 3 (minItems)",
+			},
+		},
+		{
+			"any of and all of",
+			bson.NewDocument(
+				bson.C.ArrayFromElements("anyOf",
+					bson.AC.DocumentFromElements(bson.C.ArrayFromElements("required", bson.AC.String("a"))),
+					bson.AC.DocumentFromElements(bson.C.ArrayFromElements("required", bson.AC.String("b"))),
+				),
+				bson.C.ArrayFromElements("allOf",
+					bson.AC.DocumentFromElements(bson.C.Int32("maxProperties", 1)),
+				),
+			),
+			bson.NewDocument(bson.C.Int32("c", 1), bson.C.Int32("d", 1)),
+			[]string{
+				"(root): object has 2 fields, more than 1 (maxProperties)",
+				"(root): value does not match any of the schemas (anyOf)",
+			},
+		},
+		{
+			"additional properties schema",
+			bson.NewDocument(
+				bson.C.SubDocumentFromElements("properties", bson.C.SubDocumentFromElements("a")),
+				bson.C.SubDocumentFromElements("additionalProperties", bson.C.String("type", "boolean")),
+			),
+			bson.NewDocument(bson.C.Int32("a", 1), bson.C.Boolean("b", true), bson.C.Int32("c", 1)),
+			[]string{"c: expected type boolean, got int (type)"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := Compile(tc.schema)
+			if err != nil {
+				t.Fatalf("Unexpected error compiling schema: %v", err)
+			}
+
+			violations, err := v.ValidateDocument(tc.doc)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, violation := range violations {
+				got = append(got, violation.String())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Violations differ: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		schema *bson.Document
+		err    error
+	}{
+		{
+			"unknown keyword",
+			bson.NewDocument(bson.C.SubDocumentFromElements("properties",
+				bson.C.SubDocumentFromElements("a", bson.C.Int32("minimun", 1)))),
+			CompileError{Path: "properties.a.minimun", Message: "unknown keyword"},
+		},
+		{
+			"unknown type",
+			bson.NewDocument(bson.C.String("bsonType", "integer")),
+			CompileError{Path: "bsonType", Message: `unknown type "integer"`},
+		},
+		{
+			"bad required",
+			bson.NewDocument(bson.C.Int32("required", 1)),
+			CompileError{Path: "required", Message: "must be a string or an array of strings"},
+		},
+		{
+			"negative length",
+			bson.NewDocument(bson.C.Int32("minLength", -1)),
+			CompileError{Path: "minLength", Message: "must be a non-negative integer"},
+		},
+		{
+			"exclusive without bound",
+			bson.NewDocument(bson.C.Boolean("exclusiveMaximum", true)),
+			CompileError{Message: "exclusiveMaximum requires maximum"},
+		},
+		{
+			"empty one of",
+			bson.NewDocument(bson.C.ArrayFromElements("oneOf")),
+			CompileError{Path: "oneOf", Message: "must not be empty"},
+		},
+		{
+			"json schema not an object",
+			bson.NewDocument(bson.C.Int32("$jsonSchema", 1)),
+			CompileError{Message: "$jsonSchema must be an object"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.schema)
+			if err != tc.err {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidDocument(t *testing.T) {
+	v, err := Compile(bson.NewDocument())
+	if err != nil {
+		t.Fatalf("Unexpected error compiling schema: %v", err)
+	}
+
+	_, err = v.Validate(bson.Reader{0x05, 0x00, 0x00})
+	if err == nil {
+		t.Error("Expected an error validating an invalid document")
+	}
+}
@@ -0,0 +1,544 @@
+// Package store provides an embedded document store which persists documents in a single
+// append-only file.
+//
+// The file starts with an 8 byte header, the magic string "WSTORE" followed by a little-endian
+// uint16 version, and holds a sequence of records. Each record is
+//
+//	length   uint32  the length of the document
+//	checksum uint32  the CRC-32 (Castagnoli) of the kind and the document
+//	kind     byte    1 for a document, 2 for a tombstone
+//	document         a BSON document of length bytes
+//
+// with the integers in little-endian order. Put appends a document record, which supersedes any
+// earlier record with the same _id, and Delete appends a tombstone, a document holding only the
+// _id of the deleted document. The store keeps an index from each _id to the offset of its latest
+// record in memory, which is rebuilt by Open.
+//
+// If a write is interrupted, for instance by a crash, the file may end with a partial record,
+// which Open truncates. A record which fails its checksum or is invalid but is followed by more
+// data is corruption rather than an interrupted write, and Open returns an error and leaves the
+// file unchanged. Superseded records and tombstones are removed by Compact, which rewrites the
+// live documents to a new file and replaces the old one.
+//
+// _id values are compared by their BSON sort order, so the int32 1, the int64 1, and the double
+// 1.0 are the same _id. A Store is safe for concurrent use.
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/keystring"
+)
+
+// ErrNotFound is returned when there is no document with the given _id.
+var ErrNotFound = errors.New("store: document not found")
+
+// ErrNoID is returned by Put when the document does not have an _id field.
+var ErrNoID = errors.New("store: document has no _id field")
+
+// ErrClosed is returned when the store has been closed.
+var ErrClosed = errors.New("store: store is closed")
+
+// ErrInvalidFile is returned by Open when the file is not a store file.
+var ErrInvalidFile = errors.New("store: not a store file")
+
+// ErrChecksum is returned when a record does not match its checksum.
+var ErrChecksum = errors.New("store: checksum mismatch")
+
+const (
+	magic      = "WSTORE"
+	version    = 1
+	headerSize = 8
+	// recordHeaderSize is the size of the length, checksum, and kind of a record.
+	recordHeaderSize = 9
+)
+
+// The kinds of records.
+const (
+	kindDocument  = 1
+	kindTombstone = 2
+)
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Stats describes the contents of a store file.
+type Stats struct {
+	// Documents is the number of live documents.
+	Documents int
+	// Size is the size of the file in bytes.
+	Size int64
+	// Garbage is the number of bytes of the file taken by superseded records and tombstones,
+	// which Compact would reclaim.
+	Garbage int64
+}
+
+// Store is a document store backed by a file.
+type Store struct {
+	mu   sync.RWMutex
+	path string
+	f    *os.File
+	// index holds the latest record of each live document by the keystring of its _id.
+	index   map[string]location
+	size    int64
+	garbage int64
+}
+
+// location is the position of a record within the file.
+type location struct {
+	offset int64
+	// size is the size of the record, including its header.
+	size int64
+}
+
+// Open opens the store in the file at path, creating it if it does not exist. A partial or
+// corrupted last record, left by an interrupted write, is truncated. Open returns an error if any
+// other record is corrupted.
+func Open(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path, f: f, index: make(map[string]location)}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load writes the header of an empty file, or reads the records of an existing one and truncates
+// a torn last record.
+func (s *Store) load() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err := s.f.WriteAt(fileHeader(), 0); err != nil {
+			return err
+		}
+		s.size = headerSize
+		return nil
+	}
+
+	var header [headerSize]byte
+	if _, err := s.f.ReadAt(header[:], 0); err != nil {
+		if err == io.EOF {
+			return ErrInvalidFile
+		}
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return ErrInvalidFile
+	}
+	if v := binary.LittleEndian.Uint16(header[len(magic):]); v != version {
+		return fmt.Errorf("store: unsupported version %d", v)
+	}
+
+	s.size = headerSize
+	for s.size < info.Size() {
+		kind, doc, err := s.read(s.size, info.Size()-s.size)
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			return err
+		}
+		if err == io.ErrUnexpectedEOF {
+			return s.f.Truncate(s.size)
+		}
+
+		loc := location{offset: s.size, size: recordHeaderSize + int64(len(doc))}
+		if err == nil {
+			err = s.apply(kind, doc, loc)
+		}
+		if err != nil {
+			// Only the last record can have been torn by an interrupted write.
+			if s.size+loc.size < info.Size() {
+				return err
+			}
+			return s.f.Truncate(s.size)
+		}
+		s.size += loc.size
+	}
+
+	return nil
+}
+
+// apply updates the index for the record of kind holding doc at loc.
+func (s *Store) apply(kind byte, doc bson.Reader, loc location) error {
+	if kind != kindDocument && kind != kindTombstone {
+		return fmt.Errorf("store: unknown record kind %d", kind)
+	}
+	key, err := idKey(doc)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := s.index[key]; ok {
+		s.garbage += old.size
+		delete(s.index, key)
+	}
+	if kind == kindDocument {
+		s.index[key] = loc
+	} else {
+		s.garbage += loc.size
+	}
+
+	return nil
+}
+
+// read reads the record at offset, which is at most limit bytes long, and returns its kind and
+// document. It returns io.ErrUnexpectedEOF if the record is longer than limit. If the record is
+// complete but fails its checksum or holds an invalid document, the document is returned along
+// with the error.
+func (s *Store) read(offset, limit int64) (byte, bson.Reader, error) {
+	if limit < recordHeaderSize {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	var header [recordHeaderSize]byte
+	if _, err := s.f.ReadAt(header[:], offset); err != nil {
+		return 0, nil, err
+	}
+
+	length := int64(binary.LittleEndian.Uint32(header[0:]))
+	if length > limit-recordHeaderSize {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	doc := make(bson.Reader, length)
+	if _, err := s.f.ReadAt(doc, offset+recordHeaderSize); err != nil {
+		return 0, nil, err
+	}
+
+	kind := header[8]
+	sum := crc32.Update(crc32.Checksum(header[8:], table), table, doc)
+	if sum != binary.LittleEndian.Uint32(header[4:]) {
+		return kind, doc, ErrChecksum
+	}
+	if _, err := doc.Validate(); err != nil {
+		return kind, doc, err
+	}
+
+	return kind, doc, nil
+}
+
+// Get returns the document with the given _id.
+func (s *Store) Get(id *bson.Value) (bson.Reader, error) {
+	key, err := keystring.AppendValue(nil, id, keystring.Ascending)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.get(string(key))
+}
+
+func (s *Store) get(key string) (bson.Reader, error) {
+	if s.f == nil {
+		return nil, ErrClosed
+	}
+	loc, ok := s.index[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	_, doc, err := s.read(loc.offset, loc.size)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Put stores doc, replacing any document with the same _id. doc must be a valid document with an
+// _id field.
+func (s *Store) Put(doc bson.Reader) error {
+	if _, err := doc.Validate(); err != nil {
+		return err
+	}
+	key, err := idKey(doc)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loc, err := s.append(kindDocument, doc)
+	if err != nil {
+		return err
+	}
+	if old, ok := s.index[key]; ok {
+		s.garbage += old.size
+	}
+	s.index[key] = loc
+
+	return nil
+}
+
+// Delete removes the document with the given _id.
+func (s *Store) Delete(id *bson.Value) error {
+	k, err := keystring.AppendValue(nil, id, keystring.Ascending)
+	if err != nil {
+		return err
+	}
+	key := string(k)
+
+	tombstone, err := bson.NewDocument(bson.C.Value("_id", id)).MarshalBSON()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return ErrClosed
+	}
+	old, ok := s.index[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	loc, err := s.append(kindTombstone, tombstone)
+	if err != nil {
+		return err
+	}
+	s.garbage += old.size + loc.size
+	delete(s.index, key)
+
+	return nil
+}
+
+// append writes a record to the end of the file. If the write fails, the file is truncated to its
+// previous size so that a later write does not follow a partial record.
+func (s *Store) append(kind byte, doc bson.Reader) (location, error) {
+	if s.f == nil {
+		return location{}, ErrClosed
+	}
+
+	b := appendRecord(nil, kind, doc)
+	if _, err := s.f.WriteAt(b, s.size); err != nil {
+		s.f.Truncate(s.size)
+		return location{}, err
+	}
+
+	loc := location{offset: s.size, size: int64(len(b))}
+	s.size += loc.size
+	return loc, nil
+}
+
+// Len returns the number of documents in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.index)
+}
+
+// Stats returns statistics about the store file.
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return Stats{Documents: len(s.index), Size: s.size, Garbage: s.garbage}
+}
+
+// Sync commits the contents of the store file to stable storage.
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return ErrClosed
+	}
+	return s.f.Sync()
+}
+
+// Close syncs and closes the store file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return ErrClosed
+	}
+	err := s.f.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+
+	return err
+}
+
+// Compact rewrites the live documents, in the order of their latest writes, to a new file which
+// replaces the store file, reclaiming the space of superseded records and tombstones. The
+// documents are copied one at a time rather than held in memory, but reads and writes wait until
+// compaction finishes. If it fails the store is left unchanged.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return ErrClosed
+	}
+
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	index, size, err := s.copyTo(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	s.f.Close()
+	s.f, s.index, s.size, s.garbage = f, index, size, 0
+	return nil
+}
+
+// copyTo writes the header and the live documents to f one record at a time and returns their
+// index and the size of f.
+func (s *Store) copyTo(f *os.File) (map[string]location, int64, error) {
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(fileHeader()); err != nil {
+		return nil, 0, err
+	}
+
+	index := make(map[string]location, len(s.index))
+	size := int64(headerSize)
+	var b []byte
+	for _, key := range s.keys() {
+		_, doc, err := s.read(s.index[key].offset, s.index[key].size)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		b = appendRecord(b[:0], kindDocument, doc)
+		if _, err := w.Write(b); err != nil {
+			return nil, 0, err
+		}
+		index[key] = location{offset: size, size: int64(len(b))}
+		size += int64(len(b))
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, 0, err
+	}
+	return index, size, nil
+}
+
+// keys returns the keys of the live documents in the order of their records.
+func (s *Store) keys() []string {
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.index[keys[i]].offset < s.index[keys[j]].offset })
+
+	return keys
+}
+
+// Iterator returns an iterator over the documents in the store, in the order of their latest
+// writes. The iterator visits the documents which were live when it was created; those deleted
+// since are skipped, and those replaced since are returned in their current version.
+func (s *Store) Iterator() *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return &Iterator{s: s, keys: s.keys()}
+}
+
+// Iterator iterates over the documents of a store.
+type Iterator struct {
+	s    *Store
+	keys []string
+	doc  bson.Reader
+	err  error
+}
+
+// Next advances the iterator to the next document and reports whether there is one. It returns
+// false when the documents are exhausted or an error occurs.
+func (itr *Iterator) Next() bool {
+	for itr.err == nil && len(itr.keys) > 0 {
+		key := itr.keys[0]
+		itr.keys = itr.keys[1:]
+
+		itr.s.mu.RLock()
+		doc, err := itr.s.get(key)
+		itr.s.mu.RUnlock()
+
+		switch err {
+		case nil:
+			itr.doc = doc
+			return true
+		case ErrNotFound:
+		default:
+			itr.err = err
+		}
+	}
+
+	itr.doc = nil
+	return false
+}
+
+// Document returns the current document.
+func (itr *Iterator) Document() bson.Reader {
+	return itr.doc
+}
+
+// Err returns the error which stopped the iterator, if any.
+func (itr *Iterator) Err() error {
+	return itr.err
+}
+
+func fileHeader() []byte {
+	b := make([]byte, headerSize)
+	copy(b, magic)
+	binary.LittleEndian.PutUint16(b[len(magic):], version)
+	return b
+}
+
+func appendRecord(dst []byte, kind byte, doc bson.Reader) []byte {
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(doc)))
+	header[8] = kind
+	binary.LittleEndian.PutUint32(header[4:], crc32.Update(crc32.Checksum(header[8:], table), table, doc))
+
+	dst = append(dst, header[:]...)
+	return append(dst, doc...)
+}
+
+// idKey returns the keystring of the _id of doc.
+func idKey(doc bson.Reader) (string, error) {
+	elem, err := doc.Lookup("_id")
+	if err != nil {
+		return "", err
+	}
+	if elem == nil {
+		return "", ErrNoID
+	}
+
+	key, err := keystring.AppendValue(nil, elem.Value(), keystring.Ascending)
+	return string(key), err
+}
@@ -0,0 +1,301 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func document(t *testing.T, elems ...*bson.Element) bson.Reader {
+	t.Helper()
+
+	return bsontest.Marshal(t, bson.NewDocument(elems...))
+}
+
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func put(t *testing.T, s *Store, docs ...bson.Reader) {
+	t.Helper()
+
+	for _, doc := range docs {
+		if err := s.Put(doc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+// assertContents checks that iterating s returns want.
+func assertContents(t *testing.T, s *Store, want ...bson.Reader) {
+	t.Helper()
+
+	var got []bson.Reader
+	itr := s.Iterator()
+	for itr.Next() {
+		got = append(got, itr.Document())
+	}
+	if err := itr.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Unexpected number of documents. got %d; want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("Unexpected document %d. got %v; want %v", i, got[i], want[i])
+		}
+	}
+	if s.Len() != len(want) {
+		t.Errorf("Unexpected length. got %d; want %d", s.Len(), len(want))
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	s := open(t, path)
+
+	a := document(t, C.Int32("_id", 1), C.String("name", "a"))
+	b := document(t, C.String("_id", "b"), C.String("name", "b"))
+	a2 := document(t, C.Double("_id", 1), C.String("name", "a2"))
+	c := document(t, C.Int64("_id", 3))
+	put(t, s, a, b, c)
+
+	got, err := s.Get(AC.Int64(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, a) {
+		t.Errorf("Unexpected result. got %v; want %v", got, a)
+	}
+
+	put(t, s, a2)
+	if err := s.Delete(AC.Int32(3)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Get(AC.Int32(3)); err != ErrNotFound {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrNotFound)
+	}
+	if err := s.Delete(AC.Int32(3)); err != ErrNotFound {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrNotFound)
+	}
+	assertContents(t, s, b, a2)
+
+	if err := s.Put(document(t, C.Int32("a", 1))); err != ErrNoID {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrNoID)
+	}
+	if err := s.Put(bson.Reader{0x05, 0x00}); err == nil {
+		t.Errorf("Expected an error for an invalid document")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := s.Get(AC.Int32(1)); err != ErrClosed {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrClosed)
+	}
+
+	reopened := open(t, path)
+	assertContents(t, reopened, b, a2)
+	stats := reopened.Stats()
+	if stats.Documents != 2 || stats.Garbage == 0 {
+		t.Errorf("Unexpected stats. got %+v", stats)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	a := document(t, C.Int32("_id", 1))
+	b := document(t, C.Int32("_id", 2), C.String("x", "some data"))
+
+	// good is the contents of a file holding a, and full also holds b.
+	path := filepath.Join(dir, "source")
+	s := open(t, path)
+	put(t, s, a)
+	good := s.Stats().Size
+	put(t, s, b)
+	s.Close()
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	flipped := append([]byte(nil), full...)
+	flipped[len(flipped)-3] ^= 0xff
+
+	testCases := []struct {
+		name string
+		data []byte
+		size int64
+		want []bson.Reader
+	}{
+		{"intact", full, int64(len(full)), []bson.Reader{a, b}},
+		{"torn header", full[:good+4], good, []bson.Reader{a}},
+		{"torn document", full[:len(full)-1], good, []bson.Reader{a}},
+		{"checksum", flipped, good, []bson.Reader{a}},
+		{"trailing garbage", append(full[:len(full):len(full)], 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01), int64(len(full)), []bson.Reader{a, b}},
+		{"only header", full[:headerSize], headerSize, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			if err := os.WriteFile(path, tc.data, 0644); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			s := open(t, path)
+			assertContents(t, s, tc.want...)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if info.Size() != tc.size {
+				t.Errorf("Unexpected file size. got %d; want %d", info.Size(), tc.size)
+			}
+
+			// Writes after recovery follow the last valid record.
+			c := document(t, C.Int32("_id", 3))
+			put(t, s, c)
+			s.Close()
+			assertContents(t, open(t, path), append(tc.want, c)...)
+		})
+	}
+
+	// Corruption before the last record is not the result of an interrupted write, so Open fails
+	// and leaves the file alone.
+	corrupt := func(i int) []byte {
+		b := append([]byte(nil), full...)
+		b[i] ^= 0x01
+		return b
+	}
+	errorCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"checksum not at the end", corrupt(int(good) - 3), ErrChecksum},
+		{"kind not at the end", corrupt(headerSize + 8), ErrChecksum},
+		{"length not at the end", corrupt(headerSize), ErrChecksum},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			if err := os.WriteFile(path, tc.data, 0644); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if _, err := Open(path); err != tc.err {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tc.data) {
+				t.Errorf("Expected the file to be unchanged")
+			}
+		})
+	}
+
+	path = filepath.Join(dir, "invalid")
+	if err := os.WriteFile(path, []byte("not a store file"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := Open(path); err != ErrInvalidFile {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidFile)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	s := open(t, path)
+
+	var want []bson.Reader
+	for i := int32(0); i < 10; i++ {
+		put(t, s, document(t, C.Int32("_id", i), C.Int32("v", 0)))
+	}
+	for i := int32(0); i < 10; i++ {
+		switch {
+		case i%3 == 0:
+			if err := s.Delete(AC.Int32(i)); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		case i%3 == 1:
+			doc := document(t, C.Int32("_id", i), C.Int32("v", 1))
+			put(t, s, doc)
+			want = append(want, doc)
+		}
+	}
+	// The documents which were not replaced come first, since their latest records are oldest.
+	want = append([]bson.Reader{
+		document(t, C.Int32("_id", 2), C.Int32("v", 0)),
+		document(t, C.Int32("_id", 5), C.Int32("v", 0)),
+		document(t, C.Int32("_id", 8), C.Int32("v", 0)),
+	}, want...)
+
+	before := s.Stats()
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	after := s.Stats()
+	if after.Garbage != 0 || after.Size != before.Size-before.Garbage || after.Documents != len(want) {
+		t.Errorf("Unexpected stats. got %+v; before compaction %+v", after, before)
+	}
+	assertContents(t, s, want...)
+
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file to be removed. got %v", err)
+	}
+
+	extra := document(t, C.Int32("_id", 100))
+	put(t, s, extra)
+	s.Close()
+	assertContents(t, open(t, path), append(want, extra)...)
+}
+
+func TestIteratorConcurrentWrites(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "data"))
+	a := document(t, C.Int32("_id", 1))
+	b := document(t, C.Int32("_id", 2))
+	b2 := document(t, C.Int32("_id", 2), C.Boolean("updated", true))
+	put(t, s, a, b)
+
+	itr := s.Iterator()
+	if err := s.Delete(AC.Int32(1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	put(t, s, b2, document(t, C.Int32("_id", 3)))
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var got []bson.Reader
+	for itr.Next() {
+		got = append(got, itr.Document())
+	}
+	if itr.Err() != nil {
+		t.Fatalf("Unexpected error: %v", itr.Err())
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], b2) {
+		t.Errorf("Unexpected result. got %v; want %v", got, []bson.Reader{b2})
+	}
+}
@@ -0,0 +1,597 @@
+package update
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/expression"
+	"github.com/skriptble/wilson/bson/query"
+)
+
+// compiler compiles the operand v of the operator op applied to path. It returns the paths other
+// than path which the operation updates.
+type compiler func(op, path string, v *bson.Value) (operation, []string, error)
+
+var compilers map[string]compiler
+
+func init() {
+	compilers = map[string]compiler{
+		"$set":         compileSet,
+		"$setOnInsert": compileSet,
+		"$unset":       compileUnset,
+		"$inc":         compileArithmetic,
+		"$mul":         compileArithmetic,
+		"$min":         compileExtreme,
+		"$max":         compileExtreme,
+		"$rename":      compileRename,
+		"$currentDate": compileCurrentDate,
+		"$push":        compilePush,
+		"$addToSet":    compileAddToSet,
+		"$pop":         compilePop,
+		"$pull":        compilePull,
+		"$pullAll":     compilePullAll,
+	}
+}
+
+func compileSet(op, path string, v *bson.Value) (operation, []string, error) {
+	return operation{op: op, path: path, insertOnly: op == "$setOnInsert", apply: func(d *bson.Document) error {
+		return set(d, op, path, copyValue(v))
+	}}, nil, nil
+}
+
+func compileUnset(op, path string, v *bson.Value) (operation, []string, error) {
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		// An element of an array is set to null rather than removed, so that the indexes of the
+		// elements after it do not change.
+		if i := strings.LastIndexByte(path, '.'); i >= 0 {
+			parent, err := lookup(d, path[:i])
+			if err != nil {
+				return err
+			}
+			if parent != nil && parent.Type() == bson.TypeArray {
+				cur, err := lookup(d, path)
+				if cur == nil || err != nil {
+					return err
+				}
+				return set(d, op, path, bson.AC.Null())
+			}
+		}
+
+		_, err := d.DeletePath(path)
+		if err == bson.ErrElementNotFound || err == bson.ErrInvalidDepthTraversal {
+			return nil
+		}
+		return err
+	}}, nil, nil
+}
+
+// compileArithmetic compiles $inc and $mul.
+func compileArithmetic(op, path string, v *bson.Value) (operation, []string, error) {
+	if !isNumber(v) {
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: fmt.Sprintf("cannot apply %s with a non-numeric argument", op)}
+	}
+
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		cur, err := lookup(d, path)
+		if err != nil {
+			return err
+		}
+
+		if cur == nil {
+			// A missing field is treated as zero of the same type as the argument.
+			cur, _ = expression.Multiply(bson.AC.Int32(0), v)
+			if op == "$mul" {
+				return set(d, op, path, cur)
+			}
+		}
+		if !isNumber(cur) {
+			return ApplyError{Operator: op, Path: path, Message: fmt.Sprintf("cannot apply %s to a value of non-numeric type %s", op, typeName(cur))}
+		}
+
+		var result *bson.Value
+		if op == "$inc" {
+			var sum expression.Sum
+			sum.Add(cur)
+			sum.Add(v)
+			result = sum.Value()
+		} else {
+			result, _ = expression.Multiply(cur, v)
+		}
+		return set(d, op, path, result)
+	}}, nil, nil
+}
+
+// compileExtreme compiles $min and $max, which set the field if the operand is less or greater
+// than its current value.
+func compileExtreme(op, path string, v *bson.Value) (operation, []string, error) {
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		cur, err := lookup(d, path)
+		if err != nil {
+			return err
+		}
+
+		if cur != nil {
+			c := bson.CompareValues(v, cur)
+			if c == 0 || (c < 0) != (op == "$min") {
+				return nil
+			}
+		}
+		return set(d, op, path, copyValue(v))
+	}}, nil, nil
+}
+
+func compileRename(op, path string, v *bson.Value) (operation, []string, error) {
+	if v.Type() != bson.TypeString {
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: "the new name must be a string"}
+	}
+	target := v.StringValue()
+	if err := validatePath(target); err != nil {
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: err.Error()}
+	}
+
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		cur, err := lookup(d, path)
+		if cur == nil || err != nil {
+			return err
+		}
+
+		cur = copyValue(cur)
+		if _, err := d.DeletePath(path); err != nil {
+			return err
+		}
+		return set(d, op, target, cur)
+	}}, []string{target}, nil
+}
+
+// compileCurrentDate compiles $currentDate, whose operand is true, or {"$type": "date"} or
+// {"$type": "timestamp"}.
+func compileCurrentDate(op, path string, v *bson.Value) (operation, []string, error) {
+	timestamp := false
+	switch v.Type() {
+	case bson.TypeBoolean:
+	case bson.TypeEmbeddedDocument:
+		elem, err := v.ReaderDocument().Lookup("$type")
+		if err != nil {
+			return operation{}, nil, err
+		}
+		if elem == nil || elem.Value().Type() != bson.TypeString {
+			return operation{}, nil, CompileError{Path: op + "." + path, Message: "expected {$type: \"date\"} or {$type: \"timestamp\"}"}
+		}
+		switch elem.Value().StringValue() {
+		case "date":
+		case "timestamp":
+			timestamp = true
+		default:
+			return operation{}, nil, CompileError{Path: op + "." + path, Message: "$type must be \"date\" or \"timestamp\""}
+		}
+	default:
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: "operand must be a boolean or a document"}
+	}
+
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		now := time.Now()
+		if timestamp {
+			return set(d, op, path, bson.AC.Timestamp(uint32(now.Unix()), 1))
+		}
+		return set(d, op, path, bson.AC.DateTime(now.UnixNano()/int64(time.Millisecond)))
+	}}, nil, nil
+}
+
+// push holds the values and modifiers of $push.
+type push struct {
+	each     []*bson.Value
+	position int64
+	hasPos   bool
+	slice    int64
+	hasSlice bool
+	// sortOrder is 1 or -1 to sort the elements themselves, or 0 if sortSpec is used instead.
+	sortOrder int64
+	sortSpec  *query.Sort
+	hasSort   bool
+}
+
+func compilePush(op, path string, v *bson.Value) (operation, []string, error) {
+	p := push{each: []*bson.Value{v}}
+	if isModifiers(v) {
+		var err error
+		p, err = compilePushModifiers(v.ReaderDocument(), op+"."+path)
+		if err != nil {
+			return operation{}, nil, err
+		}
+	}
+
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		values, err := arrayAt(d, op, path)
+		if err != nil {
+			return err
+		}
+
+		pos := int64(len(values))
+		if p.hasPos {
+			pos = p.position
+			if pos < 0 {
+				pos += int64(len(values))
+			}
+			pos = clamp(pos, 0, int64(len(values)))
+		}
+		each := make([]*bson.Value, len(p.each))
+		for i, e := range p.each {
+			each[i] = copyValue(e)
+		}
+		values = append(values[:pos], append(each, values[pos:]...)...)
+
+		if p.hasSort {
+			p.sort(values)
+		}
+		if p.hasSlice {
+			n := int64(len(values))
+			if p.slice >= 0 {
+				values = values[:clamp(p.slice, 0, n)]
+			} else {
+				values = values[n-clamp(-p.slice, 0, n):]
+			}
+		}
+
+		return set(d, op, path, bson.AC.ArrayFromValues(values...))
+	}}, nil, nil
+}
+
+// compilePushModifiers compiles a $push operand of the form {"$each": [...], "$position": n,
+// "$slice": n, "$sort": <order>}.
+func compilePushModifiers(r bson.Reader, path string) (push, error) {
+	var p push
+	var hasEach bool
+
+	itr, err := r.Iterator()
+	if err != nil {
+		return p, err
+	}
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+		kpath := path + "." + key
+
+		switch key {
+		case "$each":
+			if v.Type() != bson.TypeArray {
+				return p, CompileError{Path: kpath, Message: "$each must be an array"}
+			}
+			p.each, err = arrayValues(v)
+			if err != nil {
+				return p, err
+			}
+			hasEach = true
+		case "$position":
+			n, ok := integer(v)
+			if !ok {
+				return p, CompileError{Path: kpath, Message: "$position must be an integer"}
+			}
+			p.position, p.hasPos = n, true
+		case "$slice":
+			n, ok := integer(v)
+			if !ok {
+				return p, CompileError{Path: kpath, Message: "$slice must be an integer"}
+			}
+			p.slice, p.hasSlice = n, true
+		case "$sort":
+			if n, ok := integer(v); ok && (n == 1 || n == -1) {
+				p.sortOrder, p.hasSort = n, true
+				continue
+			}
+			if v.Type() != bson.TypeEmbeddedDocument {
+				return p, CompileError{Path: kpath, Message: "$sort must be 1, -1, or a sort specification"}
+			}
+			p.sortSpec, err = query.CompileSort(v.ReaderDocument())
+			if err != nil {
+				return p, CompileError{Path: kpath, Message: err.Error()}
+			}
+			p.hasSort = true
+		default:
+			return p, CompileError{Path: kpath, Message: "unknown modifier"}
+		}
+	}
+	if itr.Err() != nil {
+		return p, itr.Err()
+	}
+	if !hasEach {
+		return p, CompileError{Path: path, Message: "modifiers require $each"}
+	}
+
+	return p, nil
+}
+
+// sort sorts the elements of an array by the $sort modifier. When sorting by a specification,
+// elements which are not documents are compared as empty documents.
+func (p push) sort(values []*bson.Value) {
+	if p.sortSpec == nil {
+		sort.SliceStable(values, func(i, j int) bool {
+			return bson.CompareValues(values[i], values[j])*int(p.sortOrder) < 0
+		})
+		return
+	}
+
+	empty, _ := bson.NewDocument().MarshalBSON()
+	keys := make([][]*bson.Value, len(values))
+	for i, v := range values {
+		r := bson.Reader(empty)
+		if v.Type() == bson.TypeEmbeddedDocument {
+			r = v.ReaderDocument()
+		}
+		keys[i] = p.sortSpec.Key(r)
+	}
+
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return p.sortSpec.CompareKeys(keys[idx[i]], keys[idx[j]]) < 0
+	})
+
+	sorted := make([]*bson.Value, len(values))
+	for i, j := range idx {
+		sorted[i] = values[j]
+	}
+	copy(values, sorted)
+}
+
+// compileAddToSet compiles $addToSet, whose operand is a value or {"$each": [...]}.
+func compileAddToSet(op, path string, v *bson.Value) (operation, []string, error) {
+	each := []*bson.Value{v}
+	if isModifiers(v) {
+		itr, err := v.ReaderDocument().Iterator()
+		if err != nil {
+			return operation{}, nil, err
+		}
+		for itr.Next() {
+			elem := itr.Element().Clone()
+			if elem.Key() != "$each" {
+				return operation{}, nil, CompileError{Path: op + "." + path + "." + elem.Key(), Message: "unknown modifier"}
+			}
+			if elem.Value().Type() != bson.TypeArray {
+				return operation{}, nil, CompileError{Path: op + "." + path + ".$each", Message: "$each must be an array"}
+			}
+			each, err = arrayValues(elem.Value())
+			if err != nil {
+				return operation{}, nil, err
+			}
+		}
+		if itr.Err() != nil {
+			return operation{}, nil, itr.Err()
+		}
+	}
+
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		values, err := arrayAt(d, op, path)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range each {
+			if indexOf(values, e) < 0 {
+				values = append(values, copyValue(e))
+			}
+		}
+		return set(d, op, path, bson.AC.ArrayFromValues(values...))
+	}}, nil, nil
+}
+
+// compilePop compiles $pop, which removes the last element of an array if its operand is 1 and the
+// first if it is -1.
+func compilePop(op, path string, v *bson.Value) (operation, []string, error) {
+	n, ok := integer(v)
+	if !ok || (n != 1 && n != -1) {
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: "operand must be 1 or -1"}
+	}
+
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		cur, err := lookup(d, path)
+		if cur == nil || err != nil {
+			return err
+		}
+		values, err := arrayAt(d, op, path)
+		if err != nil || len(values) == 0 {
+			return err
+		}
+
+		if n == 1 {
+			values = values[:len(values)-1]
+		} else {
+			values = values[1:]
+		}
+		return set(d, op, path, bson.AC.ArrayFromValues(values...))
+	}}, nil, nil
+}
+
+// compilePull compiles $pull, which removes the elements of an array matching a condition. A
+// document is compiled with query.CompileElemMatch, and any other value is compiled as
+// {"$in": [value]}, so that a regular expression matches strings.
+func compilePull(op, path string, v *bson.Value) (operation, []string, error) {
+	var cond bson.Reader
+	if v.Type() == bson.TypeEmbeddedDocument {
+		cond = v.ReaderDocument()
+	} else {
+		b, err := bson.NewDocument(bson.C.ArrayFromElements("$in", v)).MarshalBSON()
+		if err != nil {
+			return operation{}, nil, err
+		}
+		cond = b
+	}
+	m, err := query.CompileElemMatch(cond)
+	if err != nil {
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: err.Error()}
+	}
+
+	return pullOperation(op, path, m.MatchesValue), nil, nil
+}
+
+// compilePullAll compiles $pullAll, which removes the elements of an array equal to any of the
+// elements of its operand.
+func compilePullAll(op, path string, v *bson.Value) (operation, []string, error) {
+	if v.Type() != bson.TypeArray {
+		return operation{}, nil, CompileError{Path: op + "." + path, Message: "operand must be an array"}
+	}
+	remove, err := arrayValues(v)
+	if err != nil {
+		return operation{}, nil, err
+	}
+
+	return pullOperation(op, path, func(e *bson.Value) bool {
+		return indexOf(remove, e) >= 0
+	}), nil, nil
+}
+
+// pullOperation returns an operation which removes the elements of the array at path for which
+// match returns true.
+func pullOperation(op, path string, match func(*bson.Value) bool) operation {
+	return operation{op: op, path: path, apply: func(d *bson.Document) error {
+		cur, err := lookup(d, path)
+		if cur == nil || err != nil {
+			return err
+		}
+		values, err := arrayAt(d, op, path)
+		if err != nil {
+			return err
+		}
+
+		kept := values[:0]
+		for _, e := range values {
+			if !match(e) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(values) {
+			return nil
+		}
+		return set(d, op, path, bson.AC.ArrayFromValues(kept...))
+	}}
+}
+
+// lookup returns the value at path within d, or nil if there is none.
+func lookup(d *bson.Document, path string) (*bson.Value, error) {
+	v, err := d.LookupPath(path)
+	switch err {
+	case nil:
+		return v, nil
+	case bson.ErrElementNotFound, bson.ErrInvalidDepthTraversal:
+		return nil, nil
+	}
+
+	return nil, err
+}
+
+// set sets the value at path within d to v.
+func set(d *bson.Document, op, path string, v *bson.Value) error {
+	err := d.SetPath(path, v)
+	switch err {
+	case nil:
+		return nil
+	case bson.ErrInvalidDepthTraversal:
+		return ApplyError{Operator: op, Path: path, Message: "cannot create a field within a value which is not a document or an array"}
+	case bson.ErrInvalidIndex:
+		return ApplyError{Operator: op, Path: path, Message: "cannot create a field which is not an index within an array"}
+	}
+
+	return err
+}
+
+// arrayAt returns the elements of the array at path within d, which is empty if there is no value
+// at path.
+func arrayAt(d *bson.Document, op, path string) ([]*bson.Value, error) {
+	cur, err := lookup(d, path)
+	if cur == nil || err != nil {
+		return nil, err
+	}
+	if cur.Type() != bson.TypeArray {
+		return nil, ApplyError{Operator: op, Path: path, Message: fmt.Sprintf("the field must be an array but is of type %s", typeName(cur))}
+	}
+
+	return arrayValues(cur)
+}
+
+// arrayValues returns copies of the elements of the array v.
+func arrayValues(v *bson.Value) ([]*bson.Value, error) {
+	itr, err := v.ReaderArray().Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []*bson.Value
+	for itr.Next() {
+		values = append(values, itr.Element().Clone().Value())
+	}
+
+	return values, itr.Err()
+}
+
+// indexOf returns the index of the first of values which compares equal to v, or -1.
+func indexOf(values []*bson.Value, v *bson.Value) int {
+	for i, e := range values {
+		if bson.CompareValues(e, v) == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// isModifiers reports whether v is a document whose first key is an operator, such as {"$each":
+// [...]}.
+func isModifiers(v *bson.Value) bool {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return false
+	}
+
+	elem, err := v.ReaderDocument().ElementAt(0)
+	return err == nil && strings.HasPrefix(elem.Key(), "$")
+}
+
+func copyValue(v *bson.Value) *bson.Value {
+	return bson.C.Value("", v).Value()
+}
+
+func isNumber(v *bson.Value) bool {
+	switch v.Type() {
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return true
+	}
+
+	return false
+}
+
+// integer returns the value of v if it is an integer, or a double with an integral value.
+func integer(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+
+	return 0, false
+}
+
+func clamp(n, lo, hi int64) int64 {
+	switch {
+	case n < lo:
+		return lo
+	case n > hi:
+		return hi
+	}
+
+	return n
+}
+
+func typeName(v *bson.Value) string {
+	return v.Type().Alias()
+}
@@ -0,0 +1,246 @@
+// Package update applies MongoDB update documents to BSON documents.
+//
+// An update is compiled once with Compile or CompileReader and can then be applied to any number
+// of documents. An update document either consists entirely of update operators, such as
+//
+//	{"$set": {"status": "A"}, "$inc": {"qty": -1}, "$push": {"log": "sold"}}
+//
+// or contains no operators at all, in which case it is a replacement document which replaces every
+// field of the updated document except _id.
+//
+// The supported operators are $set, $unset, $setOnInsert, $inc, $mul, $min, $max, $rename,
+// $currentDate, $push, $addToSet, $pop, $pull, and $pullAll. $push accepts the $each, $position,
+// $slice, and $sort modifiers and $addToSet accepts $each. The condition of $pull is applied with
+// query.CompileElemMatch. Fields are identified by dotted paths, and documents and arrays missing
+// along a path are created. Two operators may not update the same path, or a path and one of its
+// ancestors. Positional operators such as $ and $[] are not supported.
+package update
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// CompileError is returned when an update document is invalid.
+type CompileError struct {
+	// Path is the dotted path of the invalid value within the update document.
+	Path    string
+	Message string
+}
+
+func (ce CompileError) Error() string {
+	if ce.Path == "" {
+		return "update: " + ce.Message
+	}
+
+	return "update: " + ce.Path + ": " + ce.Message
+}
+
+// ApplyError is returned when an operator cannot be applied to a document, such as when $inc is
+// applied to a string.
+type ApplyError struct {
+	Operator string
+	// Path is the dotted path of the field the operator was applied to.
+	Path    string
+	Message string
+}
+
+func (ae ApplyError) Error() string {
+	return "update: " + ae.Operator + ": " + ae.Path + ": " + ae.Message
+}
+
+// Update is a compiled update document. An Update is safe for concurrent use.
+type Update struct {
+	// replacement is the replacement document, or nil if the update consists of operators.
+	replacement bson.Reader
+	ops         []operation
+}
+
+// operation is an operator applied to a single field.
+type operation struct {
+	op   string
+	path string
+	// insertOnly is true for operators which are only applied by Upsert.
+	insertOnly bool
+	apply      func(d *bson.Document) error
+}
+
+// Compile compiles a MongoDB update document.
+func Compile(spec *bson.Document) (*Update, error) {
+	b, err := spec.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileReader(b)
+}
+
+// CompileReader compiles an update document provided as a bson.Reader. See Compile.
+func CompileReader(spec bson.Reader) (*Update, error) {
+	_, err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	itr, err := spec.Iterator()
+	if err != nil {
+		return nil, err
+	}
+
+	var operators, fields bool
+	u := new(Update)
+	var paths []string
+	for itr.Next() {
+		elem := itr.Element().Clone()
+		key, v := elem.Key(), elem.Value()
+
+		if !strings.HasPrefix(key, "$") {
+			if operators {
+				return nil, CompileError{Path: key, Message: "update operators and fields cannot be mixed"}
+			}
+			fields = true
+			continue
+		}
+		if fields {
+			return nil, CompileError{Path: key, Message: "a replacement document cannot contain update operators"}
+		}
+		operators = true
+
+		compile, ok := compilers[key]
+		if !ok {
+			return nil, CompileError{Path: key, Message: "unknown update operator"}
+		}
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil, CompileError{Path: key, Message: "operand must be a document"}
+		}
+
+		fitr, err := v.ReaderDocument().Iterator()
+		if err != nil {
+			return nil, err
+		}
+		for fitr.Next() {
+			felem := fitr.Element().Clone()
+			path := felem.Key()
+			if err := validatePath(path); err != nil {
+				return nil, CompileError{Path: key + "." + path, Message: err.Error()}
+			}
+
+			op, targets, err := compile(key, path, felem.Value())
+			if err != nil {
+				return nil, err
+			}
+			u.ops = append(u.ops, op)
+			paths = append(paths, path)
+			paths = append(paths, targets...)
+		}
+		if fitr.Err() != nil {
+			return nil, fitr.Err()
+		}
+	}
+	if itr.Err() != nil {
+		return nil, itr.Err()
+	}
+
+	if !operators {
+		u.replacement = append(bson.Reader(nil), spec...)
+		return u, nil
+	}
+
+	for i, a := range paths {
+		for _, b := range paths[i+1:] {
+			if conflicts(a, b) {
+				return nil, CompileError{Path: b, Message: fmt.Sprintf("updating the path %q would create a conflict at %q", b, a)}
+			}
+		}
+	}
+
+	return u, nil
+}
+
+// IsReplacement reports whether the update is a replacement document rather than a list of update
+// operators.
+func (u *Update) IsReplacement() bool {
+	return u.replacement != nil
+}
+
+// Apply applies the update to d. A replacement document replaces every field of d except _id,
+// unless the replacement itself contains _id. If an operator fails, the operators before it have
+// already been applied and d should be discarded.
+func (u *Update) Apply(d *bson.Document) error {
+	return u.apply(d, false)
+}
+
+// Upsert applies the update to d, which is a document being inserted because no document matched
+// the filter of an update. It differs from Apply only in applying $setOnInsert.
+func (u *Update) Upsert(d *bson.Document) error {
+	return u.apply(d, true)
+}
+
+func (u *Update) apply(d *bson.Document, insert bool) error {
+	if u.replacement != nil {
+		return u.replace(d)
+	}
+
+	for _, op := range u.ops {
+		if op.insertOnly && !insert {
+			continue
+		}
+		if err := op.apply(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replace replaces the fields of d with those of the replacement document.
+func (u *Update) replace(d *bson.Document) error {
+	replacement, err := bson.ReadDocument(u.replacement)
+	if err != nil {
+		return err
+	}
+
+	id, err := d.Lookup("_id")
+	if err != nil && err != bson.ErrElementNotFound {
+		return err
+	}
+	d.Reset()
+	if _, err := replacement.Lookup("_id"); err == bson.ErrElementNotFound && id != nil {
+		d.Append(id)
+	}
+
+	itr := replacement.Iterator()
+	for itr.Next() {
+		d.Append(itr.Element())
+	}
+
+	return itr.Err()
+}
+
+var errPositional = errors.New("positional operators are not supported")
+
+// validatePath checks that path is a dotted path with no empty or positional segments.
+func validatePath(path string) error {
+	for _, seg := range strings.Split(path, ".") {
+		switch {
+		case seg == "":
+			return errors.New("invalid field path")
+		case strings.HasPrefix(seg, "$"):
+			return errPositional
+		}
+	}
+
+	return nil
+}
+
+// conflicts reports whether the paths a and b are the same or one is an ancestor of the other.
+func conflicts(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	return a == b || strings.HasPrefix(b, a+".")
+}
@@ -0,0 +1,336 @@
+package update
+
+import (
+	"math"
+	"testing"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/bsontest"
+)
+
+var (
+	C  = bson.C
+	AC = bson.AC
+)
+
+func doc(elems ...*bson.Element) *bson.Document {
+	return bson.NewDocument(elems...)
+}
+
+func TestApply(t *testing.T) {
+	original := func() *bson.Document {
+		return doc(
+			C.Int32("_id", 1),
+			C.Int32("qty", 5),
+			C.String("name", "x"),
+			C.SubDocumentFromElements("a", C.Double("b", 1.5)),
+			C.ArrayFromElements("tags", AC.String("red"), AC.String("blue"), AC.String("red")),
+			C.ArrayFromElements("items",
+				AC.DocumentFromElements(C.String("sku", "p"), C.Int32("n", 2)),
+				AC.DocumentFromElements(C.String("sku", "q"), C.Int32("n", 8)),
+			),
+		)
+	}
+	with := func(f func(d *bson.Document)) *bson.Document {
+		d := original()
+		f(d)
+		return d
+	}
+
+	testCases := []struct {
+		name   string
+		update *bson.Document
+		want   *bson.Document
+	}{
+		{
+			"replacement",
+			doc(C.String("name", "y"), C.Int32("qty", 1)),
+			doc(C.Int32("_id", 1), C.String("name", "y"), C.Int32("qty", 1)),
+		},
+		{
+			"empty replacement",
+			doc(),
+			doc(C.Int32("_id", 1)),
+		},
+		{
+			"$set",
+			doc(C.SubDocumentFromElements("$set", C.String("name", "y"), C.Int32("c.d", 1), C.Int32("a.e", 2))),
+			with(func(d *bson.Document) {
+				d.Set(C.String("name", "y"))
+				d.Append(C.SubDocumentFromElements("c", C.Int32("d", 1)))
+				d.Set(C.SubDocumentFromElements("a", C.Double("b", 1.5), C.Int32("e", 2)))
+			}),
+		},
+		{
+			"$set array element",
+			doc(C.SubDocumentFromElements("$set", C.Int32("items.1.n", 9))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("items",
+					AC.DocumentFromElements(C.String("sku", "p"), C.Int32("n", 2)),
+					AC.DocumentFromElements(C.String("sku", "q"), C.Int32("n", 9)),
+				))
+			}),
+		},
+		{
+			"$setOnInsert ignored",
+			doc(C.SubDocumentFromElements("$setOnInsert", C.Int32("qty", 0))),
+			original(),
+		},
+		{
+			"$unset",
+			doc(C.SubDocumentFromElements("$unset", C.String("name", ""), C.String("a.b", ""), C.String("missing", ""), C.String("tags.1", ""))),
+			with(func(d *bson.Document) {
+				d.Delete("name")
+				d.Set(C.SubDocumentFromElements("a"))
+				d.Set(C.ArrayFromElements("tags", AC.String("red"), AC.Null(), AC.String("red")))
+			}),
+		},
+		{
+			"$inc",
+			doc(C.SubDocumentFromElements("$inc", C.Int32("qty", 2), C.Int32("a.b", 1), C.Int64("new", 3))),
+			with(func(d *bson.Document) {
+				d.Set(C.Int32("qty", 7))
+				d.Set(C.SubDocumentFromElements("a", C.Double("b", 2.5)))
+				d.Append(C.Int64("new", 3))
+			}),
+		},
+		{
+			"$inc overflow",
+			doc(C.SubDocumentFromElements("$inc", C.Int32("qty", math.MaxInt32))),
+			with(func(d *bson.Document) { d.Set(C.Int64("qty", math.MaxInt32+5)) }),
+		},
+		{
+			"$mul",
+			doc(C.SubDocumentFromElements("$mul", C.Double("qty", 0.5), C.Int64("new", 3))),
+			with(func(d *bson.Document) {
+				d.Set(C.Double("qty", 2.5))
+				d.Append(C.Int64("new", 0))
+			}),
+		},
+		{
+			"$min and $max",
+			doc(
+				C.SubDocumentFromElements("$min", C.Int32("qty", 3), C.Int32("a.b", 7)),
+				C.SubDocumentFromElements("$max", C.String("name", "z"), C.Int32("low", 1)),
+			),
+			with(func(d *bson.Document) {
+				d.Set(C.Int32("qty", 3))
+				d.Set(C.String("name", "z"))
+				d.Append(C.Int32("low", 1))
+			}),
+		},
+		{
+			"$rename",
+			doc(C.SubDocumentFromElements("$rename", C.String("name", "title"), C.String("a.b", "c.d"), C.String("missing", "other"))),
+			with(func(d *bson.Document) {
+				d.Delete("name")
+				d.Set(C.SubDocumentFromElements("a"))
+				d.Append(C.String("title", "x"))
+				d.Append(C.SubDocumentFromElements("c", C.Double("d", 1.5)))
+			}),
+		},
+		{
+			"$push",
+			doc(C.SubDocumentFromElements("$push", C.String("tags", "green"), C.Int32("new", 1))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("red"), AC.String("blue"), AC.String("red"), AC.String("green")))
+				d.Append(C.ArrayFromElements("new", AC.Int32(1)))
+			}),
+		},
+		{
+			"$push modifiers",
+			doc(C.SubDocumentFromElements("$push", C.SubDocumentFromElements("tags",
+				C.ArrayFromElements("$each", AC.String("a"), AC.String("z")),
+				C.Int32("$position", 0),
+				C.Int32("$sort", -1),
+				C.Int32("$slice", 3),
+			))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("z"), AC.String("red"), AC.String("red")))
+			}),
+		},
+		{
+			"$push sort by field",
+			doc(C.SubDocumentFromElements("$push", C.SubDocumentFromElements("items",
+				C.ArrayFromElements("$each", AC.DocumentFromElements(C.String("sku", "r"), C.Int32("n", 5))),
+				C.SubDocumentFromElements("$sort", C.Int32("n", -1)),
+				C.Int32("$slice", -2),
+			))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("items",
+					AC.DocumentFromElements(C.String("sku", "r"), C.Int32("n", 5)),
+					AC.DocumentFromElements(C.String("sku", "p"), C.Int32("n", 2)),
+				))
+			}),
+		},
+		{
+			"$addToSet",
+			doc(C.SubDocumentFromElements("$addToSet",
+				C.SubDocumentFromElements("tags", C.ArrayFromElements("$each", AC.String("blue"), AC.String("green"), AC.String("green"))),
+			)),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("red"), AC.String("blue"), AC.String("red"), AC.String("green")))
+			}),
+		},
+		{
+			"$pop",
+			doc(C.SubDocumentFromElements("$pop", C.Int32("tags", -1), C.Int32("items", 1), C.Int32("missing", 1))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("blue"), AC.String("red")))
+				d.Set(C.ArrayFromElements("items", AC.DocumentFromElements(C.String("sku", "p"), C.Int32("n", 2))))
+			}),
+		},
+		{
+			"$pull",
+			doc(C.SubDocumentFromElements("$pull",
+				C.String("tags", "red"),
+				C.SubDocumentFromElements("items", C.SubDocumentFromElements("n", C.Int32("$gt", 5))),
+			)),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("blue")))
+				d.Set(C.ArrayFromElements("items", AC.DocumentFromElements(C.String("sku", "p"), C.Int32("n", 2))))
+			}),
+		},
+		{
+			"$pull regex",
+			doc(C.SubDocumentFromElements("$pull", C.Regex("tags", "^b", ""))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("red"), AC.String("red")))
+			}),
+		},
+		{
+			"$pullAll",
+			doc(C.SubDocumentFromElements("$pullAll", C.ArrayFromElements("tags", AC.String("red"), AC.String("black")))),
+			with(func(d *bson.Document) {
+				d.Set(C.ArrayFromElements("tags", AC.String("blue")))
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := Compile(tc.update)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			d := original()
+			err = u.Apply(d)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			bsontest.AssertEqual(t, d, tc.want)
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	u, err := Compile(doc(
+		C.SubDocumentFromElements("$set", C.Int32("a", 1)),
+		C.SubDocumentFromElements("$setOnInsert", C.Int32("b", 2)),
+	))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if u.IsReplacement() {
+		t.Errorf("Unexpected replacement update.")
+	}
+
+	d := doc(C.Int32("_id", 7))
+	err = u.Upsert(d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bsontest.AssertEqual(t, d, doc(C.Int32("_id", 7), C.Int32("a", 1), C.Int32("b", 2)))
+}
+
+func TestCurrentDate(t *testing.T) {
+	u, err := Compile(doc(C.SubDocumentFromElements("$currentDate",
+		C.Boolean("d", true),
+		C.SubDocumentFromElements("ts", C.String("$type", "timestamp")),
+	)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	d := doc()
+	err = u.Apply(d)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for key, want := range map[string]bson.Type{"d": bson.TypeDateTime, "ts": bson.TypeTimestamp} {
+		elem, err := d.Lookup(key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if elem.Value().Type() != want {
+			t.Errorf("Unexpected type for %s. got %v; want %v", key, elem.Value().Type(), want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		update *bson.Document
+		path   string
+	}{
+		{"mixed", doc(C.SubDocumentFromElements("$set", C.Int32("a", 1)), C.Int32("b", 1)), "b"},
+		{"operator in replacement", doc(C.Int32("b", 1), C.SubDocumentFromElements("$set", C.Int32("a", 1))), "$set"},
+		{"unknown operator", doc(C.SubDocumentFromElements("$frob", C.Int32("a", 1))), "$frob"},
+		{"operand not a document", doc(C.Int32("$set", 1)), "$set"},
+		{"empty segment", doc(C.SubDocumentFromElements("$set", C.Int32("a..b", 1))), "$set.a..b"},
+		{"positional", doc(C.SubDocumentFromElements("$set", C.Int32("a.$.b", 1))), "$set.a.$.b"},
+		{"conflict", doc(C.SubDocumentFromElements("$set", C.Int32("a", 1)), C.SubDocumentFromElements("$inc", C.Int32("a.b", 1))), "a.b"},
+		{"rename conflict", doc(C.SubDocumentFromElements("$rename", C.String("a", "b")), C.SubDocumentFromElements("$set", C.Int32("b", 1))), "b"},
+		{"non-numeric $inc", doc(C.SubDocumentFromElements("$inc", C.String("a", "1"))), "$inc.a"},
+		{"bad $pop", doc(C.SubDocumentFromElements("$pop", C.Int32("a", 2))), "$pop.a"},
+		{"$each not array", doc(C.SubDocumentFromElements("$push", C.SubDocumentFromElements("a", C.Int32("$each", 1)))), "$push.a.$each"},
+		{"modifiers without $each", doc(C.SubDocumentFromElements("$push", C.SubDocumentFromElements("a", C.Int32("$slice", 1)))), "$push.a"},
+		{"bad $currentDate", doc(C.SubDocumentFromElements("$currentDate", C.SubDocumentFromElements("a", C.String("$type", "x")))), "$currentDate.a"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.update)
+			ce, ok := err.(CompileError)
+			if !ok {
+				t.Fatalf("Did not get expected error. got %v; want a CompileError", err)
+			}
+			if ce.Path != tc.path {
+				t.Errorf("Unexpected path. got %q; want %q", ce.Path, tc.path)
+			}
+		})
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		update *bson.Document
+		op     string
+	}{
+		{"$inc string", doc(C.SubDocumentFromElements("$inc", C.Int32("s", 1))), "$inc"},
+		{"$push non-array", doc(C.SubDocumentFromElements("$push", C.Int32("s", 1))), "$push"},
+		{"$set within scalar", doc(C.SubDocumentFromElements("$set", C.Int32("s.x", 1))), "$set"},
+		{"$set field of array", doc(C.SubDocumentFromElements("$set", C.Int32("arr.x", 1))), "$set"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := Compile(tc.update)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			err = u.Apply(doc(C.String("s", "x"), C.ArrayFromElements("arr", AC.Int32(1))))
+			ae, ok := err.(ApplyError)
+			if !ok {
+				t.Fatalf("Did not get expected error. got %v; want an ApplyError", err)
+			}
+			if ae.Operator != tc.op {
+				t.Errorf("Unexpected operator. got %q; want %q", ae.Operator, tc.op)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package bson
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/skriptble/wilson/bson/elements"
+)
+
+// ErrInvalidUTF8 is returned when a key or string is not valid UTF-8.
+var ErrInvalidUTF8 = errors.New("bson: invalid UTF-8")
+
+// UTF8Mode determines how keys and strings which are not valid UTF-8 are handled.
+type UTF8Mode uint8
+
+// These constants are the supported UTF8Modes.
+const (
+	// UTF8Reject rejects invalid UTF-8 with ErrInvalidUTF8. This is the default.
+	UTF8Reject UTF8Mode = iota
+
+	// UTF8Replace replaces each invalid sequence of bytes with the Unicode replacement
+	// character, U+FFFD.
+	UTF8Replace
+)
+
+// replacementChar is used by UTF8Replace in place of invalid sequences.
+const replacementChar = "�"
+
+// repair returns s with invalid UTF-8 replaced if the mode is UTF8Replace.
+func (m UTF8Mode) repair(s string) string {
+	if m != UTF8Replace || utf8.ValidString(s) {
+		return s
+	}
+
+	return strings.ToValidUTF8(s, replacementChar)
+}
+
+// RepairUTF8 returns a copy of the document r in which each invalid sequence of UTF-8 in its
+// keys and strings, including those of embedded documents, has been replaced with U+FFFD. If r
+// contains only valid UTF-8, it is returned unmodified. The structure of r is validated first,
+// and an error is returned if it is invalid.
+func RepairUTF8(r Reader) (Reader, error) {
+	_, err := r.ValidateWithOptions(&ValidateOptions{UTF8: UTF8Replace})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.Validate()
+	if err != ErrInvalidUTF8 {
+		return r, err
+	}
+
+	return repairDocument(nil, r), nil
+}
+
+// repairDocument appends the document r to dst with its invalid UTF-8 replaced. r must have a
+// valid structure.
+func repairDocument(dst []byte, r Reader) []byte {
+	start := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+
+	_, _ = r.readElements(func(elem *Element) error {
+		v := elem.value
+		t := v.data[v.start]
+		dst = append(dst, t)
+		dst = appendCString(dst, string(v.data[v.start+1:v.offset-1]))
+
+		data := v.data[v.offset:]
+		switch Type(t) {
+		case TypeString, TypeJavaScript, TypeSymbol:
+			dst = appendString(dst, data)
+		case TypeDBPointer:
+			n := 4 + readi32(data)
+			dst = appendString(dst, data)
+			dst = append(dst, data[n:n+12]...)
+		case TypeRegex:
+			pattern := strings.IndexByte(string(data), 0x00)
+			options := strings.IndexByte(string(data[pattern+1:]), 0x00)
+			dst = appendCString(dst, string(data[:pattern]))
+			dst = appendCString(dst, string(data[pattern+1:pattern+1+options]))
+		case TypeEmbeddedDocument, TypeArray:
+			dst = repairDocument(dst, Reader(data[:readi32(data)]))
+		case TypeCodeWithScope:
+			cws := len(dst)
+			dst = append(dst, 0, 0, 0, 0)
+			dst = appendString(dst, data[4:])
+			n := 8 + readi32(data[4:])
+			dst = repairDocument(dst, Reader(data[n:readi32(data)]))
+			_, _ = elements.Int32.Encode(uint(cws), dst, int32(len(dst)-cws))
+		default:
+			size, _ := v.valueSize()
+			dst = append(dst, data[:size]...)
+		}
+
+		return nil
+	})
+
+	dst = append(dst, 0x00)
+	_, _ = elements.Int32.Encode(uint(start), dst, int32(len(dst)-start))
+
+	return dst
+}
+
+// appendCString appends s, with its invalid UTF-8 replaced, to dst as a null terminated string.
+func appendCString(dst []byte, s string) []byte {
+	dst = append(dst, UTF8Replace.repair(s)...)
+	return append(dst, 0x00)
+}
+
+// appendString appends the length-prefixed string at the start of data, with its invalid UTF-8
+// replaced, to dst.
+func appendString(dst []byte, data []byte) []byte {
+	s := UTF8Replace.repair(string(data[4 : 4+readi32(data)-1]))
+
+	start := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+	_, _ = elements.Int32.Encode(uint(start), dst, int32(len(s)+1))
+	dst = append(dst, s...)
+
+	return append(dst, 0x00)
+}
@@ -0,0 +1,153 @@
+package bson
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRepairUTF8(t *testing.T) {
+	testCases := []struct {
+		name string
+		doc  *Document
+		want *Document
+	}{
+		{
+			"valid",
+			NewDocument(C.String("é", "日本"), C.Int32("a", 1)),
+			NewDocument(C.String("é", "日本"), C.Int32("a", 1)),
+		},
+		{
+			"key and string",
+			NewDocument(C.String("aÿ", "ÿbÿ"), C.Int32("c", 1)),
+			NewDocument(C.String("a�(", "�(b�("), C.Int32("c", 1)),
+		},
+		{
+			"nested",
+			NewDocument(
+				C.SubDocumentFromElements("a", C.ArrayFromElements("b", AC.String("ÿ"), AC.Symbol("ÿ"))),
+				C.JavaScript("c", "ÿ"),
+			),
+			NewDocument(
+				C.SubDocumentFromElements("a", C.ArrayFromElements("b", AC.String("�("), AC.Symbol("�("))),
+				C.JavaScript("c", "�("),
+			),
+		},
+		{
+			"regex and dbpointer",
+			NewDocument(C.Regex("a", "ÿ", "i"), C.DBPointer("b", "ÿ", [12]byte{1, 2, 3})),
+			NewDocument(C.Regex("a", "�(", "i"), C.DBPointer("b", "�(", [12]byte{1, 2, 3})),
+		},
+		{
+			"code with scope",
+			NewDocument(C.CodeWithScope("a", "ÿ", NewDocument(C.String("ÿ", "x")))),
+			NewDocument(C.CodeWithScope("a", "�(", NewDocument(C.String("�(", "x")))),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RepairUTF8(marshalInvalidUTF8(t, tc.doc))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			want, err := tc.want.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error marshaling document: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Repaired document does not match. got %v; want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid document", func(t *testing.T) {
+		_, err := RepairUTF8(Reader{0x05, 0x00, 0x00, 0x00, 0x01})
+		if err != ErrInvalidKey {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidKey)
+		}
+	})
+}
+
+func TestUTF8Encoding(t *testing.T) {
+	type foo struct {
+		Name string
+		Tags map[string]string
+	}
+	v := foo{Name: "a\xffb", Tags: map[string]string{"\xc3\x28": "ok"}}
+
+	t.Run("Document", func(t *testing.T) {
+		doc := NewDocument(C.String("a", "\xff"))
+		_, err := doc.MarshalBSON()
+		if err != ErrInvalidUTF8 {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidUTF8)
+		}
+
+		doc = NewDocument(C.Int32("\xff", 1))
+		_, err = doc.MarshalBSON()
+		if err != ErrInvalidUTF8 {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidUTF8)
+		}
+	})
+	t.Run("Reject", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := NewEncoder(&buf).Encode(v)
+		if err != ErrInvalidUTF8 {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidUTF8)
+		}
+
+		_, err = NewDocumentEncoder().EncodeDocument(v)
+		if err != ErrInvalidUTF8 {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidUTF8)
+		}
+	})
+	t.Run("Replace", func(t *testing.T) {
+		want, err := NewDocument(
+			C.String("name", "a�b"),
+			C.SubDocumentFromElements("tags", C.String("�(", "ok")),
+		).MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error marshaling document: %v", err)
+		}
+
+		var buf bytes.Buffer
+		err = NewEncoderWithOptions(&buf, &EncodeOptions{UTF8: UTF8Replace}).Encode(v)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Encoded document does not match. got %v; want %v", buf.Bytes(), want)
+		}
+
+		doc, err := NewDocumentEncoderWithOptions(&EncodeOptions{UTF8: UTF8Replace}).EncodeDocument(v)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got, err := doc.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error marshaling document: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Encoded document does not match. got %v; want %v", got, want)
+		}
+	})
+}
+
+func TestUTF8Decoding(t *testing.T) {
+	b := marshalInvalidUTF8(t, NewDocument(C.String("name", "ÿ")))
+
+	var reject struct{ Name string }
+	err := NewDecoder(bytes.NewReader(b)).Decode(&reject)
+	if err != ErrInvalidUTF8 {
+		t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidUTF8)
+	}
+
+	var replace struct{ Name string }
+	err = NewDecoderWithOptions(bytes.NewReader(b), &ValidateOptions{UTF8: UTF8Replace}).Decode(&replace)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if replace.Name != "�(" {
+		t.Errorf("Unexpected name. got %q; want %q", replace.Name, "�(")
+	}
+}
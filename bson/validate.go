@@ -0,0 +1,270 @@
+package bson
+
+import (
+	"errors"
+	"io"
+	"unicode/utf8"
+)
+
+// ErrDocumentTooLarge is returned when a document is larger than ValidateOptions.MaxDocumentSize.
+var ErrDocumentTooLarge = errors.New("bson: document exceeds the maximum size")
+
+// ErrMaxDepthExceeded is returned when documents and arrays are nested more deeply than
+// ValidateOptions.MaxDepth.
+var ErrMaxDepthExceeded = errors.New("bson: document exceeds the maximum nesting depth")
+
+// ErrTooManyElements is returned when a document contains more elements than
+// ValidateOptions.MaxElements.
+var ErrTooManyElements = errors.New("bson: document exceeds the maximum number of elements")
+
+// ErrKeyTooLong is returned when a key is longer than ValidateOptions.MaxKeyLength.
+var ErrKeyTooLong = errors.New("bson: key exceeds the maximum length")
+
+// ErrDuplicateKey is returned when a document contains the same key more than once and
+// ValidateOptions.RejectDuplicateKeys is set.
+var ErrDuplicateKey = errors.New("bson: duplicate key")
+
+// ErrDeprecatedType is returned when a document contains a value of a deprecated type and
+// ValidateOptions.RejectDeprecatedTypes is set.
+var ErrDeprecatedType = errors.New("bson: deprecated type")
+
+// ValidateOptions configures the checks performed when validating documents that come from an
+// untrusted source, such as a network connection. A zero limit means the limit is not enforced.
+type ValidateOptions struct {
+	// MaxDocumentSize is the maximum size of the document in bytes. NewFromIOReaderWithOptions
+	// and Decoders check the size before reading the document, so an oversized document is
+	// never allocated.
+	MaxDocumentSize int
+
+	// MaxDepth is the maximum nesting depth. A document with no embedded documents or arrays
+	// has a depth of 1. The scope of JavaScript code with scope counts as an embedded document.
+	MaxDepth int
+
+	// MaxElements is the maximum number of elements in the document, including the elements
+	// of embedded documents and arrays.
+	MaxElements int
+
+	// MaxKeyLength is the maximum length of a key in bytes.
+	MaxKeyLength int
+
+	// UTF8 determines how keys and the strings within string, JavaScript, symbol, regex, and
+	// DBPointer values which are not valid UTF-8 are handled. With UTF8Replace,
+	// ValidateWithOptions accepts invalid UTF-8, and NewFromIOReaderWithOptions,
+	// ReadDocumentWithOptions, and Decoders return a repaired copy of the document.
+	UTF8 UTF8Mode
+
+	// RejectDuplicateKeys rejects documents which contain the same key more than once.
+	RejectDuplicateKeys bool
+
+	// RejectDeprecatedTypes rejects undefined, DBPointer, symbol, and JavaScript code with
+	// scope values.
+	RejectDeprecatedTypes bool
+}
+
+// NewFromIOReaderWithOptions reads in a document from the given io.Reader, constructs a
+// bson.Reader from it, and validates it with the given options. If opts is nil it behaves like
+// NewFromIOReader.
+func NewFromIOReaderWithOptions(r io.Reader, opts *ValidateOptions) (Reader, error) {
+	if opts == nil {
+		return NewFromIOReader(r)
+	}
+	if r == nil {
+		return nil, ErrNilReader
+	}
+
+	var lengthBytes [4]byte
+	_, err := io.ReadFull(r, lengthBytes[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := readi32(lengthBytes[:])
+	err = opts.checkLength(length)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := make(Reader, length)
+	copy(reader, lengthBytes[:])
+
+	_, err = io.ReadFull(r, reader[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	return reader.validateAndRepair(opts)
+}
+
+// ReadDocumentWithOptions validates b with the given options and then reads it as a Document.
+// If opts is nil it behaves like ReadDocument.
+func ReadDocumentWithOptions(b []byte, opts *ValidateOptions) (*Document, error) {
+	if opts != nil {
+		r, err := Reader(b).validateAndRepair(opts)
+		if err != nil {
+			return nil, err
+		}
+		b = r
+	}
+
+	return ReadDocument(b)
+}
+
+// validateAndRepair validates r with opts and, if opts.UTF8 is UTF8Replace, repairs any invalid
+// UTF-8 it contains.
+func (r Reader) validateAndRepair(opts *ValidateOptions) (Reader, error) {
+	_, err := r.ValidateWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.UTF8 == UTF8Replace {
+		return RepairUTF8(r)
+	}
+
+	return r, nil
+}
+
+// ValidateWithOptions validates the document in the same way as Validate, and additionally
+// enforces the limits and checks in opts. If opts is nil it behaves like Validate.
+func (r Reader) ValidateWithOptions(opts *ValidateOptions) (size uint32, err error) {
+	if opts == nil {
+		return r.Validate()
+	}
+	if len(r) < 5 {
+		return 0, ErrTooSmall
+	}
+
+	err = opts.checkLength(readi32(r[0:4]))
+	if err != nil {
+		return 0, err
+	}
+
+	v := validator{opts: opts}
+	return v.document(r, 1)
+}
+
+// checkLength checks the declared length of a document before it is read.
+func (opts *ValidateOptions) checkLength(length int32) error {
+	if length < 5 {
+		return ErrInvalidLength
+	}
+	if opts.MaxDocumentSize > 0 && int64(length) > int64(opts.MaxDocumentSize) {
+		return ErrDocumentTooLarge
+	}
+
+	return nil
+}
+
+// validator holds the state of a validation with options.
+type validator struct {
+	opts     *ValidateOptions
+	elements int
+}
+
+// document validates the document r, which is nested at the given depth.
+func (v *validator) document(r Reader, depth int) (uint32, error) {
+	if v.opts.MaxDepth > 0 && depth > v.opts.MaxDepth {
+		return 0, ErrMaxDepthExceeded
+	}
+
+	var keys map[string]struct{}
+	if v.opts.RejectDuplicateKeys {
+		keys = make(map[string]struct{})
+	}
+
+	return r.readElements(func(elem *Element) error {
+		v.elements++
+		if v.opts.MaxElements > 0 && v.elements > v.opts.MaxElements {
+			return ErrTooManyElements
+		}
+
+		key := elem.value.data[elem.value.start+1 : elem.value.offset-1]
+		if v.opts.MaxKeyLength > 0 && len(key) > v.opts.MaxKeyLength {
+			return ErrKeyTooLong
+		}
+		if v.opts.UTF8 == UTF8Reject && !utf8.Valid(key) {
+			return ErrInvalidUTF8
+		}
+		if keys != nil {
+			if _, ok := keys[string(key)]; ok {
+				return ErrDuplicateKey
+			}
+			keys[string(key)] = struct{}{}
+		}
+
+		return v.value(elem.value, depth)
+	})
+}
+
+// value validates a value within a document nested at the given depth. The size of the value
+// has already been validated by readElements.
+func (v *validator) value(val *Value, depth int) error {
+	t := Type(val.data[val.start])
+	switch t {
+	case TypeUndefined, TypeDBPointer, TypeSymbol, TypeCodeWithScope:
+		if v.opts.RejectDeprecatedTypes {
+			return ErrDeprecatedType
+		}
+	}
+
+	data := val.data[val.offset:]
+	switch t {
+	case TypeEmbeddedDocument, TypeArray:
+		_, err := v.document(Reader(data[:readi32(data)]), depth+1)
+		return err
+	case TypeString, TypeJavaScript, TypeSymbol, TypeDBPointer:
+		_, err := v.string(data)
+		return err
+	case TypeRegex:
+		for i := 0; i < 2; i++ {
+			end := 0
+			for data[end] != 0x00 {
+				end++
+			}
+			if v.opts.UTF8 == UTF8Reject && !utf8.Valid(data[:end]) {
+				return ErrInvalidUTF8
+			}
+			data = data[end+1:]
+		}
+	case TypeCodeWithScope:
+		length := readi32(data)
+		if length < 14 {
+			return ErrInvalidLength
+		}
+		data = data[4:length]
+
+		n, err := v.string(data)
+		if err != nil {
+			return err
+		}
+
+		scope := Reader(data[n:])
+		if len(scope) < 5 || int(readi32(scope)) != len(scope) {
+			return ErrInvalidLength
+		}
+		_, err = v.document(scope, depth+1)
+		return err
+	}
+
+	return nil
+}
+
+// string validates the length-prefixed, null terminated string at the start of data and returns
+// its size including the length.
+func (v *validator) string(data []byte) (int, error) {
+	if len(data) < 5 {
+		return 0, ErrTooSmall
+	}
+	length := readi32(data)
+	if length < 1 || int64(length) > int64(len(data)-4) {
+		return 0, ErrInvalidString
+	}
+	if data[4+length-1] != 0x00 {
+		return 0, ErrInvalidString
+	}
+	if v.opts.UTF8 == UTF8Reject && !utf8.Valid(data[4:4+length-1]) {
+		return 0, ErrInvalidUTF8
+	}
+
+	return 4 + int(length), nil
+}
@@ -0,0 +1,185 @@
+package bson
+
+import (
+	"bytes"
+	"testing"
+)
+
+// marshalInvalidUTF8 marshals doc and then replaces each "ÿ" in the result with an invalid UTF-8
+// sequence of the same length, since documents containing invalid UTF-8 cannot be marshaled.
+func marshalInvalidUTF8(t *testing.T, doc *Document) []byte {
+	t.Helper()
+
+	b, err := doc.MarshalBSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling document: %v", err)
+	}
+
+	return bytes.Replace(b, []byte("ÿ"), []byte{0xC3, 0x28}, -1)
+}
+
+func TestValidateWithOptions(t *testing.T) {
+	nested := func(depth int) *Document {
+		doc := NewDocument(C.Int32("x", 1))
+		for i := 1; i < depth; i++ {
+			doc = NewDocument(C.SubDocument("x", doc))
+		}
+		return doc
+	}
+
+	testCases := []struct {
+		name string
+		doc  *Document
+		opts *ValidateOptions
+		err  error
+	}{
+		{"nil options", NewDocument(C.Symbol("a", "b"), C.Int32("a", 1)), nil, nil},
+		{"zero options", NewDocument(C.Symbol("a", "b"), C.Int32("a", 1)), &ValidateOptions{}, nil},
+		{
+			"document size",
+			NewDocument(C.String("a", "hello")),
+			&ValidateOptions{MaxDocumentSize: 17},
+			ErrDocumentTooLarge,
+		},
+		{
+			"document size exact",
+			NewDocument(C.String("a", "hello")),
+			&ValidateOptions{MaxDocumentSize: 18},
+			nil,
+		},
+		{"depth", nested(3), &ValidateOptions{MaxDepth: 2}, ErrMaxDepthExceeded},
+		{"depth exact", nested(3), &ValidateOptions{MaxDepth: 3}, nil},
+		{
+			"array depth",
+			NewDocument(C.ArrayFromElements("a", AC.ArrayFromValues(AC.Int32(1)))),
+			&ValidateOptions{MaxDepth: 2},
+			ErrMaxDepthExceeded,
+		},
+		{
+			"scope depth",
+			NewDocument(C.CodeWithScope("a", "x", NewDocument(C.Int32("x", 1)))),
+			&ValidateOptions{MaxDepth: 1},
+			ErrMaxDepthExceeded,
+		},
+		{
+			"elements",
+			NewDocument(C.Int32("a", 1), C.SubDocumentFromElements("b", C.Int32("c", 1), C.Int32("d", 1))),
+			&ValidateOptions{MaxElements: 3},
+			ErrTooManyElements,
+		},
+		{
+			"elements exact",
+			NewDocument(C.Int32("a", 1), C.SubDocumentFromElements("b", C.Int32("c", 1), C.Int32("d", 1))),
+			&ValidateOptions{MaxElements: 4},
+			nil,
+		},
+		{"key length", NewDocument(C.Int32("abcd", 1)), &ValidateOptions{MaxKeyLength: 3}, ErrKeyTooLong},
+		{"key UTF-8", NewDocument(C.Int32("aÿb", 1)), &ValidateOptions{}, ErrInvalidUTF8},
+		{"string UTF-8", NewDocument(C.String("a", "ÿ")), &ValidateOptions{}, ErrInvalidUTF8},
+		{"valid UTF-8", NewDocument(C.String("é", "日本")), &ValidateOptions{}, nil},
+		{
+			"nested string UTF-8",
+			NewDocument(C.ArrayFromElements("a", AC.String("ÿ"))),
+			&ValidateOptions{},
+			ErrInvalidUTF8,
+		},
+		{"regex UTF-8", NewDocument(C.Regex("a", "x", "ÿ")), &ValidateOptions{}, ErrInvalidUTF8},
+		{
+			"scope UTF-8",
+			NewDocument(C.CodeWithScope("a", "x", NewDocument(C.String("x", "ÿ")))),
+			&ValidateOptions{},
+			ErrInvalidUTF8,
+		},
+		{
+			"replace UTF-8",
+			NewDocument(C.String("aÿ", "ÿ"), C.CodeWithScope("a", "x", NewDocument(C.String("x", "ÿ")))),
+			&ValidateOptions{UTF8: UTF8Replace},
+			nil,
+		},
+		{
+			"duplicate keys",
+			NewDocument(C.Int32("a", 1), C.Int32("b", 1), C.Int32("a", 2)),
+			&ValidateOptions{RejectDuplicateKeys: true},
+			ErrDuplicateKey,
+		},
+		{
+			"same key in different documents",
+			NewDocument(C.Int32("a", 1), C.SubDocumentFromElements("b", C.Int32("a", 1))),
+			&ValidateOptions{RejectDuplicateKeys: true},
+			nil,
+		},
+		{"undefined", NewDocument(C.Undefined("a")), &ValidateOptions{RejectDeprecatedTypes: true}, ErrDeprecatedType},
+		{"symbol", NewDocument(C.Symbol("a", "b")), &ValidateOptions{RejectDeprecatedTypes: true}, ErrDeprecatedType},
+		{
+			"nested code with scope",
+			NewDocument(C.SubDocumentFromElements("a", C.CodeWithScope("b", "x", NewDocument()))),
+			&ValidateOptions{RejectDeprecatedTypes: true},
+			ErrDeprecatedType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := marshalInvalidUTF8(t, tc.doc)
+
+			t.Run("Reader", func(t *testing.T) {
+				size, err := Reader(b).ValidateWithOptions(tc.opts)
+				if err != tc.err {
+					t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+				}
+				if err == nil && int(size) != len(b) {
+					t.Errorf("Unexpected size. got %d; want %d", size, len(b))
+				}
+			})
+			t.Run("NewFromIOReader", func(t *testing.T) {
+				_, err := NewFromIOReaderWithOptions(bytes.NewReader(b), tc.opts)
+				if err != tc.err {
+					t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+				}
+			})
+			t.Run("ReadDocument", func(t *testing.T) {
+				_, err := ReadDocumentWithOptions(b, tc.opts)
+				if err != tc.err {
+					t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+				}
+			})
+			t.Run("Decoder", func(t *testing.T) {
+				err := NewDecoderWithOptions(bytes.NewReader(b), tc.opts).Decode(NewDocument())
+				if err != tc.err {
+					t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+				}
+
+				err = NewDecoderWithOptions(bytes.NewReader(b), tc.opts).Decode(make(Reader, len(b)))
+				if err != tc.err {
+					t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+				}
+			})
+		})
+	}
+}
+
+func TestValidateWithOptionsLength(t *testing.T) {
+	opts := &ValidateOptions{MaxDocumentSize: 16}
+
+	t.Run("declared length checked before reading", func(t *testing.T) {
+		// Only the length is available, so reading the document would fail with an unexpected
+		// EOF if the declared length were not checked first.
+		_, err := NewFromIOReaderWithOptions(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x7F}), opts)
+		if err != ErrDocumentTooLarge {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrDocumentTooLarge)
+		}
+	})
+	t.Run("negative length", func(t *testing.T) {
+		_, err := NewFromIOReaderWithOptions(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF}), opts)
+		if err != ErrInvalidLength {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidLength)
+		}
+	})
+	t.Run("invalid string", func(t *testing.T) {
+		b := []byte{0x0D, 0x00, 0x00, 0x00, 0x02, 'a', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		_, err := Reader(b).ValidateWithOptions(opts)
+		if err != ErrInvalidString {
+			t.Errorf("Did not get expected error. got %v; want %v", err, ErrInvalidString)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"math"
 	"time"
+	"unicode/utf8"
 
 	"github.com/skriptble/wilson/bson/decimal"
 	"github.com/skriptble/wilson/bson/objectid"
@@ -51,15 +52,21 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 		}
 		l := readi32(v.data[v.offset : v.offset+4])
 		total += 4
-		if int32(v.offset)+4+l > int32(len(v.data)) {
+		if l < 1 {
+			return total, ErrInvalidString
+		}
+		if int64(v.offset)+4+int64(l) > int64(len(v.data)) {
 			return total, ErrTooSmall
 		}
 		// We check if the value that is the last element of the string is a
 		// null terminator. We take the value offset, add 4 to account for the
 		// length, add the length of the string, and subtract one since the size
 		// isn't zero indexed.
-		if !sizeOnly && v.data[v.offset+4+uint32(l)-1] != 0x00 {
-			return total, ErrInvalidString
+		if !sizeOnly {
+			err := validateString(v.data[v.offset:])
+			if err != nil {
+				return total, err
+			}
 		}
 		total += uint32(l)
 	case '\x03':
@@ -80,7 +87,7 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 		if l < 5 {
 			return total, ErrInvalidReadOnlyDocument
 		}
-		if int32(v.offset)+l > int32(len(v.data)) {
+		if int64(v.offset)+int64(l) > int64(len(v.data)) {
 			return total, ErrTooSmall
 		}
 		if !sizeOnly {
@@ -110,7 +117,7 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 		if l < 5 {
 			return total, ErrInvalidReadOnlyDocument
 		}
-		if int32(v.offset)+l > int32(len(v.data)) {
+		if int64(v.offset)+int64(l) > int64(len(v.data)) {
 			return total, ErrTooSmall
 		}
 		if !sizeOnly {
@@ -128,12 +135,20 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 		}
 		l := readi32(v.data[v.offset : v.offset+4])
 		total += 5
+		if l < 0 {
+			return total, ErrInvalidLength
+		}
 		if v.data[v.offset+4] > '\x05' && v.data[v.offset+4] < '\x80' {
 			return total, ErrInvalidBinarySubtype
 		}
-		if int32(v.offset)+5+l > int32(len(v.data)) {
+		if int64(v.offset)+5+int64(l) > int64(len(v.data)) {
 			return total, ErrTooSmall
 		}
+		// The old binary subtype contains a second length, which must be the length of the rest
+		// of the data.
+		if v.data[v.offset+4] == '\x02' && (l < 4 || readi32(v.data[v.offset+5:v.offset+9]) != l-4) {
+			return total, ErrInvalidLength
+		}
 		total += uint32(l)
 	case '\x07':
 		if int(v.offset+12) > len(v.data) {
@@ -170,15 +185,27 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 			return total, ErrInvalidString
 		}
 		total++
+		if !sizeOnly && !utf8.Valid(v.data[v.offset:i]) {
+			return total, ErrInvalidUTF8
+		}
 	case '\x0C':
 		if int(v.offset+4) > len(v.data) {
 			return total, ErrTooSmall
 		}
 		l := readi32(v.data[v.offset : v.offset+4])
 		total += 4
-		if int32(v.offset)+4+l+12 > int32(len(v.data)) {
+		if l < 1 {
+			return total, ErrInvalidString
+		}
+		if int64(v.offset)+4+int64(l)+12 > int64(len(v.data)) {
 			return total, ErrTooSmall
 		}
+		if !sizeOnly {
+			err := validateString(v.data[v.offset:])
+			if err != nil {
+				return total, err
+			}
+		}
 		total += uint32(l) + 12
 	case '\x0F':
 		if v.d != nil {
@@ -212,12 +239,21 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 		}
 		l := readi32(v.data[v.offset : v.offset+4])
 		total += 4
-		if int32(v.offset)+l > int32(len(v.data)) {
+		if l < 4 {
+			return total, ErrInvalidLength
+		}
+		if int64(v.offset)+int64(l) > int64(len(v.data)) {
 			return total, ErrTooSmall
 		}
 		if !sizeOnly {
+			if l < 8 {
+				return total, ErrInvalidLength
+			}
 			sLength := readi32(v.data[v.offset+4 : v.offset+8])
 			total += 4
+			if sLength < 1 {
+				return total, ErrInvalidString
+			}
 			// If the length of the string is larger than the total length of the
 			// field minus the int32 for length, 5 bytes for a minimum document
 			// size, and an int32 for the string length the value is invalid.
@@ -234,6 +270,9 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 			if v.data[v.offset+8+uint32(sLength)-1] != 0x00 {
 				return total, ErrInvalidString
 			}
+			if !utf8.Valid(v.data[v.offset+8 : v.offset+8+uint32(sLength)-1]) {
+				return total, ErrInvalidUTF8
+			}
 			total += uint32(sLength)
 			n, err := Reader(v.data[v.offset+8+uint32(sLength) : v.offset+uint32(l)]).Validate()
 			total += n
@@ -266,6 +305,19 @@ func (v *Value) validate(sizeOnly bool) (uint32, error) {
 	return total, nil
 }
 
+// validateString validates the length-prefixed, null terminated string at the start of data.
+func validateString(data []byte) error {
+	l := readi32(data)
+	if l < 1 || int64(l) > int64(len(data)-4) || data[4+l-1] != 0x00 {
+		return ErrInvalidString
+	}
+	if !utf8.Valid(data[4 : 4+l-1]) {
+		return ErrInvalidUTF8
+	}
+
+	return nil
+}
+
 // valueSize returns the size of the value in bytes.
 func (v *Value) valueSize() (uint32, error) {
 	return v.validate(true)
@@ -280,6 +332,29 @@ func (v *Value) Type() Type {
 	return Type(v.data[v.start])
 }
 
+// Bytes returns the BSON encoding of the value, without its type and key. If the value was read
+// from a Reader, the returned slice shares the Reader's bytes and must not be modified. It panics
+// if the value is invalid.
+func (v *Value) Bytes() []byte {
+	if v == nil || v.offset == 0 || v.data == nil {
+		panic(ErrUninitializedElement)
+	}
+
+	if v.d != nil {
+		b, err := (&Element{v}).MarshalBSON()
+		if err != nil {
+			panic(err)
+		}
+		return b[v.offset-v.start:]
+	}
+
+	size, err := v.valueSize()
+	if err != nil {
+		panic(err)
+	}
+	return v.data[v.offset : v.offset+size]
+}
+
 // Double returns the float64 value for this element.
 // It panics if e's BSON type is not double ('\x01') or if e is uninitialized.
 func (v *Value) Double() float64 {
@@ -565,17 +640,12 @@ func (v *Value) MutableJavaScriptWithScope() (code string, d *Document) {
 	if v.data[v.start] != '\x0F' {
 		panic(ElementTypeError{"compact.Element.JavaScriptWithScope", Type(v.data[v.start])})
 	}
-	// TODO(skriptble): This is wrong and could cause a panic.
-	l := int32(binary.LittleEndian.Uint32(v.data[v.offset : v.offset+4]))
-	// TODO(skriptble): This is wrong and could cause a panic.
-	sLength := int32(binary.LittleEndian.Uint32(v.data[v.offset+4 : v.offset+8]))
-	// If the length of the string is larger than the total length of the
-	// field minus the int32 for length, 5 bytes for a minimum document
-	// size, and an int32 for the string length the value is invalid.
-	str := string(v.data[v.offset+4 : v.offset+4+uint32(sLength)])
+	l := readi32(v.data[v.offset : v.offset+4])
+	sLength := readi32(v.data[v.offset+4 : v.offset+8])
+	str := string(v.data[v.offset+8 : v.offset+8+uint32(sLength)-1])
 	if v.d == nil {
 		var err error
-		v.d, err = ReadDocument(v.data[v.offset+4+uint32(sLength) : v.offset+uint32(l)])
+		v.d, err = ReadDocument(v.data[v.offset+8+uint32(sLength) : v.offset+uint32(l)])
 		if err != nil {
 			panic(err)
 		}
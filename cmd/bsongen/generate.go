@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+)
+
+// generator emits the source of the generated file.
+type generator struct {
+	buf bytes.Buffer
+	tmp int
+
+	// math is set when the generated methods refer to the math package.
+	math bool
+}
+
+// generate generates the methods for the named struct types of p, and the struct types they
+// refer to, returning the formatted source of the file. The methods call the functions generated
+// by generateHelpers, which must be present once in the package.
+func generate(p *pkg, names []string) ([]byte, error) {
+	structs, err := p.structs(names)
+	if err != nil {
+		return nil, err
+	}
+
+	g := new(generator)
+	for _, st := range structs {
+		g.generateStruct(st)
+	}
+
+	var imports string
+	if g.math {
+		imports = "\"math\"\n\n"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, fileHeader, p.name, imports)
+	buf.Write(g.buf.Bytes())
+
+	return formatSource(buf.Bytes())
+}
+
+// generateHelpers returns the formatted source of the file holding the functions shared by the
+// methods generated for the package p.
+func generateHelpers(p *pkg) ([]byte, error) {
+	return formatSource([]byte(fmt.Sprintf(helpersHeader, p.name) + fileHelpers))
+}
+
+func formatSource(src []byte) ([]byte, error) {
+	src, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated source: %v", err)
+	}
+
+	return src, nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// name returns a unique variable name with the given prefix.
+func (g *generator) name(prefix string) string {
+	g.tmp++
+	return prefix + strconv.Itoa(g.tmp)
+}
+
+func (g *generator) generateStruct(st *structType) {
+	g.printf(`
+// MarshalBSON implements the bson.Marshaler interface.
+func (t %[1]s) MarshalBSON() ([]byte, error) {
+	size, err := t.bsonElementsSize()
+	if err != nil {
+		return nil, err
+	}
+	size += 5
+
+	b := make([]byte, size)
+	_, err = elements.Int32.Encode(0, b, int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := t.bsonWriteElements(4, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elements.Byte.Encode(4+n, b, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalBSONDocument implements the bson.DocumentMarshaler interface.
+func (t %[1]s) MarshalBSONDocument() (*bson.Document, error) {
+	b, err := t.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
+
+// UnmarshalBSON implements the bson.Unmarshaler interface.
+func (t *%[1]s) UnmarshalBSON(b []byte) error {
+	itr, err := bson.Reader(b).Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		_, err = t.bsonUnmarshalElement(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+`, st.name)
+
+	g.generateSize(st)
+	g.generateWrite(st)
+	g.generateUnmarshal(st)
+}
+
+// generateSize emits the method that computes the size of the elements of a struct.
+func (g *generator) generateSize(st *structType) {
+	g.tmp = 0
+	g.printf("\n// bsonElementsSize returns the number of bytes needed to write the elements of t.\n")
+	g.printf("func (t %s) bsonElementsSize() (uint, error) {\n", st.name)
+	g.printf("var size uint\n")
+
+	for _, f := range st.fields {
+		expr := "t." + f.name
+		header := 2 + len(f.key)
+
+		switch {
+		case f.inline && f.typ.kind == kindStruct:
+			g.printf("{\ns, err := %s.bsonElementsSize()\nif err != nil {\nreturn 0, err\n}\nsize += s\n}\n", expr)
+		case f.inline:
+			g.sizeMapElements(expr, f.typ)
+		case f.typ.kind == kindElement:
+			g.printf("if %s == nil {\nreturn 0, bsongenErrNilElement\n}\n", expr)
+			g.printf("{\ns, err := %s.Validate()\nif err != nil {\nreturn 0, err\n}\nsize += uint(s)\n}\n", expr)
+		case f.typ.kind == kindDocument, f.typ.kind == kindReader:
+			g.printf("size += %d\n", header)
+			g.sizeValue(expr, f.typ, f.minsize)
+		default:
+			cond := ""
+			if f.omitempty {
+				cond = nonZero(expr, f.typ)
+			}
+			if cond != "" {
+				g.printf("if %s {\n", cond)
+			}
+			g.printf("size += %d\n", header)
+			g.sizeValue(expr, f.typ, f.minsize)
+			if cond != "" {
+				g.printf("}\n")
+			}
+		}
+	}
+
+	g.printf("return size, nil\n}\n")
+}
+
+// sizeValue emits statements that add the size of the value expr of type t to size.
+func (g *generator) sizeValue(expr string, t *typ, minsize bool) {
+	switch t.kind {
+	case kindBool:
+		g.printf("size++\n")
+	case kindInt32, kindSmallUint:
+		g.printf("size += 4\n")
+	case kindInt64:
+		if !minsize {
+			g.printf("size += 8\n")
+			return
+		}
+		g.math = true
+		g.printf("if i := int64(%s); i >= math.MinInt32 && i < math.MaxInt32 {\nsize += 4\n} else {\nsize += 8\n}\n", expr)
+	case kindUint:
+		g.math = true
+		g.printf("switch u := uint64(%s); {\n", expr)
+		if minsize {
+			g.printf("case u < math.MaxInt32:\nsize += 4\n")
+		}
+		g.printf("case u < math.MaxInt64:\nsize += 8\ndefault:\nreturn 0, bsongenOverflowError(u)\n}\n")
+	case kindFloat:
+		g.printf("size += 8\n")
+	case kindString, kindBytes, kindByteArray:
+		g.printf("size += 5 + uint(len(%s))\n", expr)
+	case kindSlice, kindArray:
+		i := g.name("i")
+		g.printf("size += 5\n")
+		g.printf("for %[1]s := range %[2]s {\nsize += 2 + uint(len(bsongenArrayKey(%[1]s)))\n", i, expr)
+		g.sizeValue(expr+"["+i+"]", t.elem, minsize)
+		g.printf("}\n")
+	case kindMap:
+		g.printf("size += 5\n")
+		g.sizeMapElements(expr, t)
+	case kindStruct:
+		g.printf("{\ns, err := %s.bsonElementsSize()\nif err != nil {\nreturn 0, err\n}\nsize += 5 + s\n}\n", expr)
+	case kindPtr:
+		g.printf("if %s == nil {\nreturn 0, bsongenErrNilPointer\n}\n", expr)
+		g.sizeValue("(*"+expr+")", t.elem, minsize)
+	case kindDocument:
+		g.printf("if %s == nil {\nreturn 0, bsongenErrNilDocument\n}\n", expr)
+		g.printf("{\ns, err := %s.Validate()\nif err != nil {\nreturn 0, err\n}\nsize += uint(s)\n}\n", expr)
+	case kindReader:
+		g.printf("size += uint(len(%s))\n", expr)
+	}
+}
+
+// sizeMapElements emits statements that add the size of the elements of the map expr to size.
+// Like the encoder, map values are always encoded with minsize.
+func (g *generator) sizeMapElements(expr string, t *typ) {
+	k, v := g.name("k"), g.name("v")
+	g.printf("for %s, %s := range %s {\nsize += 2 + uint(len(%s))\n", k, v, expr, k)
+	g.sizeValue(v, t.elem, true)
+	g.printf("}\n")
+}
+
+// generateWrite emits the method that writes the elements of a struct.
+func (g *generator) generateWrite(st *structType) {
+	g.tmp = 0
+	g.printf("\n// bsonWriteElements writes the elements of t into b at start. b must have room for the\n")
+	g.printf("// number of bytes returned by bsonElementsSize.\n")
+	g.printf("func (t %s) bsonWriteElements(start uint, b []byte) (uint, error) {\n", st.name)
+	g.printf("pos := start\n")
+
+	for _, f := range st.fields {
+		expr := "t." + f.name
+		key := strconv.Quote(f.key)
+
+		switch {
+		case f.inline && f.typ.kind == kindStruct:
+			g.writeCall(fmt.Sprintf("%s.bsonWriteElements(pos, b)", expr), false)
+		case f.inline:
+			g.writeMapElements(expr, f.typ)
+		case f.typ.kind == kindElement:
+			g.printf("if %s == nil {\nreturn pos - start, bsongenErrNilElement\n}\n", expr)
+			g.writeCall(fmt.Sprintf("%s.WriteElement(pos, b)", expr), true)
+		case f.typ.kind == kindDocument, f.typ.kind == kindReader:
+			g.writeValue(key, expr, f.typ, f.minsize)
+		default:
+			cond := ""
+			if f.omitempty {
+				cond = nonZero(expr, f.typ)
+			}
+			if cond != "" {
+				g.printf("if %s {\n", cond)
+			}
+			g.writeValue(key, expr, f.typ, f.minsize)
+			if cond != "" {
+				g.printf("}\n")
+			}
+		}
+	}
+
+	g.printf("return pos - start, nil\n}\n")
+}
+
+// writeCall emits a call that writes n bytes into b at pos and advances pos.
+func (g *generator) writeCall(call string, convert bool) {
+	g.printf("{\nn, err := %s\n", call)
+	if convert {
+		g.printf("pos += uint(n)\n")
+	} else {
+		g.printf("pos += n\n")
+	}
+	g.printf("if err != nil {\nreturn pos - start, err\n}\n}\n")
+}
+
+// writeValue emits statements that write the value expr of type t as an element with the key
+// given by the Go expression key.
+func (g *generator) writeValue(key, expr string, t *typ, minsize bool) {
+	switch t.kind {
+	case kindBool:
+		g.writeCall(fmt.Sprintf("elements.Boolean.Element(pos, b, %s, bool(%s))", key, expr), true)
+	case kindInt32, kindSmallUint:
+		g.writeCall(fmt.Sprintf("elements.Int32.Element(pos, b, %s, int32(%s))", key, expr), true)
+	case kindInt64:
+		if !minsize {
+			g.writeCall(fmt.Sprintf("elements.Int64.Element(pos, b, %s, int64(%s))", key, expr), true)
+			return
+		}
+		g.math = true
+		g.printf("{\nvar n int\nvar err error\n")
+		g.printf("if i := int64(%s); i >= math.MinInt32 && i < math.MaxInt32 {\n", expr)
+		g.printf("n, err = elements.Int32.Element(pos, b, %s, int32(i))\n", key)
+		g.printf("} else {\nn, err = elements.Int64.Element(pos, b, %s, i)\n}\n", key)
+		g.printf("pos += uint(n)\nif err != nil {\nreturn pos - start, err\n}\n}\n")
+	case kindUint:
+		g.math = true
+		g.printf("{\nvar n int\nvar err error\n")
+		g.printf("switch u := uint64(%s); {\n", expr)
+		if minsize {
+			g.printf("case u < math.MaxInt32:\nn, err = elements.Int32.Element(pos, b, %s, int32(u))\n", key)
+		}
+		g.printf("case u < math.MaxInt64:\nn, err = elements.Int64.Element(pos, b, %s, int64(u))\n", key)
+		g.printf("default:\nreturn pos - start, bsongenOverflowError(u)\n}\n")
+		g.printf("pos += uint(n)\nif err != nil {\nreturn pos - start, err\n}\n}\n")
+	case kindFloat:
+		g.writeCall(fmt.Sprintf("elements.Double.Element(pos, b, %s, float64(%s))", key, expr), true)
+	case kindString:
+		g.writeCall(fmt.Sprintf("elements.String.Element(pos, b, %s, string(%s))", key, expr), true)
+	case kindBytes:
+		g.writeCall(fmt.Sprintf("elements.Binary.Element(pos, b, %s, %s, 0)", key, expr), true)
+	case kindByteArray:
+		g.writeCall(fmt.Sprintf("elements.Binary.Element(pos, b, %s, %s[:], 0)", key, expr), true)
+	case kindSlice, kindArray:
+		g.beginDocument(key, '\x04', func(doc string) {
+			i := g.name("i")
+			g.printf("for %s := range %s {\n", i, expr)
+			g.writeValue("bsongenArrayKey("+i+")", expr+"["+i+"]", t.elem, minsize)
+			g.printf("}\n")
+		})
+	case kindMap:
+		g.beginDocument(key, '\x03', func(doc string) {
+			g.writeMapElements(expr, t)
+		})
+	case kindStruct:
+		g.beginDocument(key, '\x03', func(doc string) {
+			g.writeCall(fmt.Sprintf("%s.bsonWriteElements(pos, b)", expr), false)
+		})
+	case kindPtr:
+		g.printf("if %s == nil {\nreturn pos - start, bsongenErrNilPointer\n}\n", expr)
+		g.writeValue(key, "(*"+expr+")", t.elem, minsize)
+	case kindDocument:
+		g.printf("if %s == nil {\nreturn pos - start, bsongenErrNilDocument\n}\n", expr)
+		g.printf("{\nn, err := bsongenWriteHeader(pos, b, '\\x03', %s)\nif err != nil {\nreturn pos - start, err\n}\npos = n\n}\n", key)
+		g.writeCall(fmt.Sprintf("%s.WriteDocument(pos, b)", expr), true)
+	case kindReader:
+		g.writeCall(fmt.Sprintf("elements.Document.Element(pos, b, %s, %s)", key, expr), true)
+	}
+}
+
+// beginDocument emits the header of an embedded document or array element, the statements
+// emitted by body, and the end of the document.
+func (g *generator) beginDocument(key string, t byte, body func(doc string)) {
+	doc := g.name("doc")
+	g.printf("{\n%s, err := bsongenWriteHeader(pos, b, '\\x%02x', %s)\n", doc, t, key)
+	g.printf("if err != nil {\nreturn pos - start, err\n}\npos = %s + 4\n", doc)
+	body(doc)
+	g.printf("pos, err = bsongenEndDocument(%s, pos, b)\nif err != nil {\nreturn pos - start, err\n}\n}\n", doc)
+}
+
+// writeMapElements emits statements that write the elements of the map expr.
+func (g *generator) writeMapElements(expr string, t *typ) {
+	k, v := g.name("k"), g.name("v")
+	g.printf("for %s, %s := range %s {\n", k, v, expr)
+	g.writeValue("string("+k+")", v, t.elem, true)
+	g.printf("}\n")
+}
+
+// nonZero returns a boolean Go expression that is false when expr should be omitted by
+// omitempty. Pointers are dereferenced before checking for the zero value, so a nil pointer is
+// never omitted, matching the encoder.
+func nonZero(expr string, t *typ) string {
+	switch t.kind {
+	case kindBool:
+		return "bool(" + expr + ")"
+	case kindInt32, kindInt64, kindSmallUint, kindUint, kindFloat:
+		return expr + " != 0"
+	case kindString, kindBytes, kindByteArray, kindSlice, kindArray, kindMap:
+		return "len(" + expr + ") != 0"
+	case kindStruct:
+		return expr + " != (" + t.expr + "{})"
+	case kindPtr:
+		if cond := nonZero("(*"+expr+")", t.elem); cond != "" {
+			return expr + " == nil || " + cond
+		}
+	}
+
+	return ""
+}
+
+// generateUnmarshal emits the method that decodes a single element into a struct.
+func (g *generator) generateUnmarshal(st *structType) {
+	g.tmp = 0
+	g.printf("\n// bsonUnmarshalElement decodes elem into the field of t with a matching key and reports\n")
+	g.printf("// whether such a field was found.\n")
+	g.printf("func (t *%s) bsonUnmarshalElement(elem *bson.Element) (bool, error) {\n", st.name)
+
+	var cases, inlineStructs []*field
+	var inlineMap *field
+	for _, f := range st.fields {
+		switch {
+		case f.inline && f.typ.kind == kindStruct:
+			inlineStructs = append(inlineStructs, f)
+		case f.inline:
+			if inlineMap == nil {
+				inlineMap = f
+			}
+		case f.typ.kind == kindElement:
+			// The key of an *bson.Element field is the key of the element itself, so it cannot be
+			// matched.
+		default:
+			cases = append(cases, f)
+		}
+	}
+
+	if len(cases) > 0 {
+		g.printf("switch key := elem.Key(); {\n")
+		for _, f := range cases {
+			if f.tagged {
+				g.printf("case key == %s:\n", strconv.Quote(f.key))
+			} else {
+				g.printf("case bsongenKeyMatches(key, %s):\n", strconv.Quote(f.name))
+			}
+			g.printf("v := elem.Value()\n")
+			g.decodeValue("t."+f.name, f.typ, "v", "")
+			g.printf("return true, nil\n")
+		}
+		g.printf("}\n")
+	}
+
+	for _, f := range inlineStructs {
+		g.printf("if ok, err := t.%s.bsonUnmarshalElement(elem); ok || err != nil {\nreturn ok, err\n}\n", f.name)
+	}
+
+	if inlineMap == nil {
+		g.printf("return false, nil\n}\n")
+		return
+	}
+
+	expr := "t." + inlineMap.name
+	val, ok := g.name("val"), g.name("ok")
+	g.printf("v := elem.Value()\nvar %s %s\n%s := false\n", val, inlineMap.typ.elem.expr, ok)
+	g.decodeValue(val, inlineMap.typ.elem, "v", ok)
+	g.printf("if %s {\nif %s == nil {\n%s = make(%s)\n}\n", ok, expr, expr, inlineMap.typ.expr)
+	g.printf("%s[%s(elem.Key())] = %s\n}\n", expr, inlineMap.typ.key, val)
+	g.printf("return true, nil\n}\n")
+}
+
+// decodeValue emits statements that decode the *bson.Value v into target, which has type t.
+// Values of a BSON type that cannot be converted to t are skipped. If ok is not empty, it names
+// a boolean variable that is set when target is assigned.
+func (g *generator) decodeValue(target string, t *typ, v string, ok string) {
+	setOK := func() {
+		if ok != "" {
+			g.printf("%s = true\n", ok)
+		}
+	}
+
+	switch t.kind {
+	case kindBool:
+		g.printf("if %s.Type() == bson.TypeBoolean {\n%s = %s(%s.Boolean())\n", v, target, t.expr, v)
+		setOK()
+		g.printf("}\n")
+	case kindInt32, kindInt64:
+		i, isInt := g.name("i"), g.name("ok")
+		g.printf("if %[1]s, %[2]s := bsongenInt64(%[3]s); %[2]s && int64(%[4]s(%[1]s)) == %[1]s {\n", i, isInt, v, t.expr)
+		g.printf("%s = %s(%s)\n", target, t.expr, i)
+		setOK()
+		g.printf("}\n")
+	case kindSmallUint, kindUint:
+		u, isUint := g.name("u"), g.name("ok")
+		g.printf("if %[1]s, %[2]s := bsongenUint64(%[3]s); %[2]s && uint64(%[4]s(%[1]s)) == %[1]s {\n", u, isUint, v, t.expr)
+		g.printf("%s = %s(%s)\n", target, t.expr, u)
+		setOK()
+		g.printf("}\n")
+	case kindFloat:
+		f, isFloat := g.name("f"), g.name("ok")
+		g.printf("if %[1]s, %[2]s := bsongenFloat64(%[3]s); %[2]s {\n%[4]s = %[5]s(%[1]s)\n", f, isFloat, v, target, t.expr)
+		setOK()
+		g.printf("}\n")
+	case kindString:
+		s, isString := g.name("s"), g.name("ok")
+		g.printf("if %[1]s, %[2]s := bsongenString(%[3]s); %[2]s {\n%[4]s = %[5]s(%[1]s)\n", s, isString, v, target, t.expr)
+		setOK()
+		g.printf("}\n")
+	case kindBytes:
+		g.printf("if %s.Type() == bson.TypeBinary {\n_, data := %s.Binary()\n", v, v)
+		g.printf("%s = append(%s(nil), data...)\n", target, t.expr)
+		setOK()
+		g.printf("}\n")
+	case kindByteArray:
+		g.printf("if %s.Type() == bson.TypeBinary {\n", v)
+		g.printf("if _, data := %s.Binary(); len(data) == len(%s) {\ncopy(%s[:], data)\n", v, target, target)
+		setOK()
+		g.printf("}\n}\n")
+	case kindSlice, kindArray:
+		itr, elem, elemOK := g.name("itr"), g.name("elem"), g.name("ok")
+		g.printf("if %s.Type() == bson.TypeArray {\n", v)
+		g.printf("%s, err := %s.ReaderArray().Iterator()\nif err != nil {\nreturn true, err\n}\n", itr, v)
+
+		if t.kind == kindSlice {
+			s := g.name("s")
+			g.printf("%s := make(%s, 0)\n", s, t.expr)
+			g.printf("for %s.Next() {\nvar %s %s\n%s := false\n", itr, elem, t.elem.expr, elemOK)
+			g.decodeValue(elem, t.elem, itr+".Element().Value()", elemOK)
+			g.printf("if %s {\n%s = append(%s, %s)\n}\n}\n", elemOK, s, s, elem)
+			g.printf("if err := %s.Err(); err != nil {\nreturn true, err\n}\n", itr)
+			g.printf("%s = %s\n", target, s)
+		} else {
+			a, idx := g.name("a"), g.name("i")
+			g.printf("var %s %s\n", a, t.expr)
+			g.printf("for %[1]s := 0; %[1]s < len(%[2]s) && %[3]s.Next(); %[1]s++ {\n", idx, a, itr)
+			g.decodeValue(a+"["+idx+"]", t.elem, itr+".Element().Value()", "")
+			g.printf("}\n")
+			g.printf("if err := %s.Err(); err != nil {\nreturn true, err\n}\n", itr)
+			g.printf("%s = %s\n", target, a)
+		}
+
+		setOK()
+		g.printf("}\n")
+	case kindMap:
+		itr, m, elem, elemOK, e := g.name("itr"), g.name("m"), g.name("elem"), g.name("ok"), g.name("e")
+		g.printf("if %s.Type() == bson.TypeEmbeddedDocument {\n", v)
+		g.printf("%s, err := %s.ReaderDocument().Iterator()\nif err != nil {\nreturn true, err\n}\n", itr, v)
+		g.printf("%s := make(%s)\n", m, t.expr)
+		g.printf("for %s.Next() {\n%s := %s.Element()\nvar %s %s\n%s := false\n", itr, e, itr, elem, t.elem.expr, elemOK)
+		g.decodeValue(elem, t.elem, e+".Value()", elemOK)
+		g.printf("if %s {\n%s[%s(%s.Key())] = %s\n}\n}\n", elemOK, m, t.key, e, elem)
+		g.printf("if err := %s.Err(); err != nil {\nreturn true, err\n}\n", itr)
+		g.printf("%s = %s\n", target, m)
+		setOK()
+		g.printf("}\n")
+	case kindStruct:
+		s := g.name("s")
+		g.printf("if %s.Type() == bson.TypeEmbeddedDocument {\nvar %s %s\n", v, s, t.expr)
+		g.printf("if err := %s.UnmarshalBSON(%s.ReaderDocument()); err != nil {\nreturn true, err\n}\n", s, v)
+		g.printf("%s = %s\n", target, s)
+		setOK()
+		g.printf("}\n")
+	case kindPtr:
+		p, ptrOK := g.name("p"), g.name("ok")
+		g.printf("{\n%s := new(%s)\n%s := false\n", p, t.elem.expr, ptrOK)
+		g.decodeValue("(*"+p+")", t.elem, v, ptrOK)
+		g.printf("if %s {\n%s = %s\n", ptrOK, target, p)
+		setOK()
+		g.printf("}\n}\n")
+	case kindDocument:
+		d := g.name("d")
+		g.printf("if %s.Type() == bson.TypeEmbeddedDocument {\n", v)
+		g.printf("%s, err := bson.ReadDocument(append([]byte(nil), %s.ReaderDocument()...))\n", d, v)
+		g.printf("if err != nil {\nreturn true, err\n}\n%s = %s\n", target, d)
+		setOK()
+		g.printf("}\n")
+	case kindReader:
+		g.printf("if %s.Type() == bson.TypeEmbeddedDocument {\n", v)
+		g.printf("%s = %s(append([]byte(nil), %s.ReaderDocument()...))\n", target, t.expr, v)
+		setOK()
+		g.printf("}\n")
+	}
+}
+
+const fileHeader = `// Code generated by bsongen. DO NOT EDIT.
+
+package %s
+
+import (
+	%s"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/elements"
+)
+`
+
+const helpersHeader = `// Code generated by bsongen. DO NOT EDIT.
+
+package %s
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/elements"
+)
+`
+
+const fileHelpers = `
+var (
+	bsongenErrNilPointer  = errors.New("bsongen: cannot encode a nil pointer")
+	bsongenErrNilDocument = errors.New("bsongen: cannot encode a nil *bson.Document")
+	bsongenErrNilElement  = errors.New("bsongen: cannot encode a nil *bson.Element")
+)
+
+func bsongenOverflowError(u uint64) error {
+	return fmt.Errorf("BSON only has signed integer types and %d overflows an int64", u)
+}
+
+func bsongenArrayKey(i int) string {
+	return strconv.Itoa(i)
+}
+
+func bsongenKeyMatches(key, name string) bool {
+	return strings.ToLower(key) == strings.ToLower(name)
+}
+
+// bsongenWriteHeader writes the type and key of an element into b at pos and returns the
+// position following them.
+func bsongenWriteHeader(pos uint, b []byte, t byte, key string) (uint, error) {
+	n, err := elements.Byte.Encode(pos, b, t)
+	pos += uint(n)
+	if err != nil {
+		return pos, err
+	}
+
+	n, err = elements.CString.Encode(pos, b, key)
+	return pos + uint(n), err
+}
+
+// bsongenEndDocument writes the null terminator of the document that starts at start and ends
+// at pos, backfills its length, and returns the position following the document.
+func bsongenEndDocument(start, pos uint, b []byte) (uint, error) {
+	n, err := elements.Byte.Encode(pos, b, '\x00')
+	pos += uint(n)
+	if err != nil {
+		return pos, err
+	}
+
+	_, err = elements.Int32.Encode(start, b, int32(pos-start))
+	return pos, err
+}
+
+func bsongenInt64(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	}
+
+	return 0, false
+}
+
+func bsongenUint64(v *bson.Value) (uint64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	case bson.TypeInt32:
+		i := v.Int32()
+		return uint64(i), i >= 0
+	case bson.TypeInt64:
+		i := v.Int64()
+		return uint64(i), i >= 0
+	}
+
+	return 0, false
+}
+
+func bsongenFloat64(v *bson.Value) (float64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		return v.Double(), true
+	case bson.TypeInt32:
+		return float64(v.Int32()), true
+	case bson.TypeInt64:
+		return float64(v.Int64()), true
+	}
+
+	return 0, false
+}
+
+func bsongenString(v *bson.Value) (string, bool) {
+	switch v.Type() {
+	case bson.TypeString:
+		return v.StringValue(), true
+	case bson.TypeJavaScript:
+		return v.JavaScript(), true
+	case bson.TypeSymbol:
+		return v.Symbol(), true
+	}
+
+	return "", false
+}
+`
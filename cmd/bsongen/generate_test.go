@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateGolden(t *testing.T) {
+	dir := filepath.Join("internal", "gentest")
+	p, err := parseDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing %s: %v", dir, err)
+	}
+
+	testCases := []struct {
+		file  string
+		names []string
+	}{
+		{"bson_gen.go", []string{"Composite", "Inline"}},
+		{"scalars_bson_gen.go", []string{"Scalars", "Specials"}},
+		{helpersFile, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			var got []byte
+			if tc.names == nil {
+				got, err = generateHelpers(p)
+			} else {
+				got, err = generate(p, tc.names)
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			want, err := ioutil.ReadFile(filepath.Join(dir, tc.file))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s is out of date. Run go generate in that directory.", filepath.Join(dir, tc.file))
+			}
+		})
+	}
+}
+
+func TestGenerateImports(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		math bool
+	}{
+		{"string", `type U struct{ A string }`, false},
+		{"int64", `type U struct{ A int64 }`, false},
+		{"minsize", `type U struct{ A int64 "bson:\",minsize\"" }`, true},
+		{"uint", `type U struct{ A uint }`, true},
+		{"map", `type U struct{ A map[string]int }`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bsongen")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			err = ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte("package p\n"+tc.src+"\n"), 0644)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			p, err := parseDir(dir)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			src, err := generate(p, []string{"U"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var math bool
+			for _, imp := range f.Imports {
+				math = math || imp.Path.Value == `"math"`
+			}
+			if math != tc.math {
+				t.Errorf("Unexpected math import. got %v; want %v", math, tc.math)
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"not found", `type T struct{}`, "type U not found"},
+		{"not a struct", `type U int`, "type U is not a struct"},
+		{"interface", `type U struct{ A interface{} }`, "U.A: unsupported type interface{}"},
+		{"other package", `import "time"; type U struct{ A time.Time }`, "U.A: unsupported type time.Time"},
+		{"map key", `type U struct{ A map[int]string }`, "U.A: unsupported map key type int"},
+		{"inline", `type U struct{ A int "bson:\",inline\"" }`, "U.A: inline is only supported for map and struct types"},
+		{"nested", `type U struct{ A []V }; type V struct{ B chan int }`, "V.B: unsupported type chan int"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bsongen")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			err = ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte("package p\n"+tc.src+"\n"), 0644)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			p, err := parseDir(dir)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			_, err = generate(p, []string{"U"})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Did not get expected error. got %v; want %v", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1255 @@
+// Code generated by bsongen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"math"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/elements"
+)
+
+// MarshalBSON implements the bson.Marshaler interface.
+func (t Address) MarshalBSON() ([]byte, error) {
+	size, err := t.bsonElementsSize()
+	if err != nil {
+		return nil, err
+	}
+	size += 5
+
+	b := make([]byte, size)
+	_, err = elements.Int32.Encode(0, b, int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := t.bsonWriteElements(4, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elements.Byte.Encode(4+n, b, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalBSONDocument implements the bson.DocumentMarshaler interface.
+func (t Address) MarshalBSONDocument() (*bson.Document, error) {
+	b, err := t.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
+
+// UnmarshalBSON implements the bson.Unmarshaler interface.
+func (t *Address) UnmarshalBSON(b []byte) error {
+	itr, err := bson.Reader(b).Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		_, err = t.bsonUnmarshalElement(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// bsonElementsSize returns the number of bytes needed to write the elements of t.
+func (t Address) bsonElementsSize() (uint, error) {
+	var size uint
+	size += 8
+	size += 5 + uint(len(t.Street))
+	if len(t.City) != 0 {
+		size += 6
+		size += 5 + uint(len(t.City))
+	}
+	return size, nil
+}
+
+// bsonWriteElements writes the elements of t into b at start. b must have room for the
+// number of bytes returned by bsonElementsSize.
+func (t Address) bsonWriteElements(start uint, b []byte) (uint, error) {
+	pos := start
+	{
+		n, err := elements.String.Element(pos, b, "street", string(t.Street))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	if len(t.City) != 0 {
+		{
+			n, err := elements.String.Element(pos, b, "city", string(t.City))
+			pos += uint(n)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	return pos - start, nil
+}
+
+// bsonUnmarshalElement decodes elem into the field of t with a matching key and reports
+// whether such a field was found.
+func (t *Address) bsonUnmarshalElement(elem *bson.Element) (bool, error) {
+	switch key := elem.Key(); {
+	case key == "street":
+		v := elem.Value()
+		if s1, ok2 := bsongenString(v); ok2 {
+			t.Street = string(s1)
+		}
+		return true, nil
+	case key == "city":
+		v := elem.Value()
+		if s3, ok4 := bsongenString(v); ok4 {
+			t.City = string(s3)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// MarshalBSON implements the bson.Marshaler interface.
+func (t Composite) MarshalBSON() ([]byte, error) {
+	size, err := t.bsonElementsSize()
+	if err != nil {
+		return nil, err
+	}
+	size += 5
+
+	b := make([]byte, size)
+	_, err = elements.Int32.Encode(0, b, int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := t.bsonWriteElements(4, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elements.Byte.Encode(4+n, b, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalBSONDocument implements the bson.DocumentMarshaler interface.
+func (t Composite) MarshalBSONDocument() (*bson.Document, error) {
+	b, err := t.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
+
+// UnmarshalBSON implements the bson.Unmarshaler interface.
+func (t *Composite) UnmarshalBSON(b []byte) error {
+	itr, err := bson.Reader(b).Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		_, err = t.bsonUnmarshalElement(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// bsonElementsSize returns the number of bytes needed to write the elements of t.
+func (t Composite) bsonElementsSize() (uint, error) {
+	var size uint
+	size += 7
+	size += 5 + uint(len(t.Bytes))
+	size += 4
+	size += 5 + uint(len(t.ID))
+	size += 6
+	size += 5
+	for i1 := range t.Blob {
+		size += 2 + uint(len(bsongenArrayKey(i1)))
+		size += 4
+	}
+	size += 6
+	size += 5
+	for i2 := range t.Ints {
+		size += 2 + uint(len(bsongenArrayKey(i2)))
+		size += 8
+	}
+	size += 9
+	size += 5
+	for i3 := range t.MinInts {
+		size += 2 + uint(len(bsongenArrayKey(i3)))
+		if i := int64(t.MinInts[i3]); i >= math.MinInt32 && i < math.MaxInt32 {
+			size += 4
+		} else {
+			size += 8
+		}
+	}
+	size += 7
+	size += 5
+	for i4 := range t.Array {
+		size += 2 + uint(len(bsongenArrayKey(i4)))
+		size += 8
+	}
+	size += 6
+	size += 5
+	for i5 := range t.Tags {
+		size += 2 + uint(len(bsongenArrayKey(i5)))
+		size += 5 + uint(len(t.Tags[i5]))
+	}
+	size += 8
+	size += 5
+	for i6 := range t.Nested {
+		size += 2 + uint(len(bsongenArrayKey(i6)))
+		size += 5
+		for i7 := range t.Nested[i6] {
+			size += 2 + uint(len(bsongenArrayKey(i7)))
+			size += 5 + uint(len(t.Nested[i6][i7]))
+		}
+	}
+	size += 9
+	{
+		s, err := t.Address.bsonElementsSize()
+		if err != nil {
+			return 0, err
+		}
+		size += 5 + s
+	}
+	size += 11
+	size += 5
+	for i8 := range t.Addresses {
+		size += 2 + uint(len(bsongenArrayKey(i8)))
+		{
+			s, err := t.Addresses[i8].bsonElementsSize()
+			if err != nil {
+				return 0, err
+			}
+			size += 5 + s
+		}
+	}
+	size += 9
+	if t.Pointer == nil {
+		return 0, bsongenErrNilPointer
+	}
+	{
+		s, err := (*t.Pointer).bsonElementsSize()
+		if err != nil {
+			return 0, err
+		}
+		size += 5 + s
+	}
+	size += 8
+	if t.IntPtr == nil {
+		return 0, bsongenErrNilPointer
+	}
+	size += 8
+	size += 5
+	size += 5
+	for k9, v10 := range t.Map {
+		size += 2 + uint(len(k9))
+		if i := int64(v10); i >= math.MinInt32 && i < math.MaxInt32 {
+			size += 4
+		} else {
+			size += 8
+		}
+	}
+	size += 10
+	size += 5
+	for k11, v12 := range t.MapSlice {
+		size += 2 + uint(len(k11))
+		size += 5
+		for i13 := range v12 {
+			size += 2 + uint(len(bsongenArrayKey(i13)))
+			if i := int64(v12[i13]); i >= math.MinInt32 && i < math.MaxInt32 {
+				size += 4
+			} else {
+				size += 8
+			}
+		}
+	}
+	if len(t.Empty) != 0 {
+		size += 7
+		size += 5 + uint(len(t.Empty))
+	}
+	if t.EmptyPtr == nil || len((*t.EmptyPtr)) != 0 {
+		size += 10
+		if t.EmptyPtr == nil {
+			return 0, bsongenErrNilPointer
+		}
+		size += 5 + uint(len((*t.EmptyPtr)))
+	}
+	if t.EmptyAddr != (Address{}) {
+		size += 11
+		{
+			s, err := t.EmptyAddr.bsonElementsSize()
+			if err != nil {
+				return 0, err
+			}
+			size += 5 + s
+		}
+	}
+	if len(t.EmptyList) != 0 {
+		size += 11
+		size += 5
+		for i14 := range t.EmptyList {
+			size += 2 + uint(len(bsongenArrayKey(i14)))
+			size += 5 + uint(len(t.EmptyList[i14]))
+		}
+	}
+	if t.Zero != 0 {
+		size += 6
+		size += 8
+	}
+	size += 10
+	{
+		s, err := t.Address2.bsonElementsSize()
+		if err != nil {
+			return 0, err
+		}
+		size += 5 + s
+	}
+	return size, nil
+}
+
+// bsonWriteElements writes the elements of t into b at start. b must have room for the
+// number of bytes returned by bsonElementsSize.
+func (t Composite) bsonWriteElements(start uint, b []byte) (uint, error) {
+	pos := start
+	{
+		n, err := elements.Binary.Element(pos, b, "bytes", t.Bytes, 0)
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Binary.Element(pos, b, "id", t.ID[:], 0)
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc1, err := bsongenWriteHeader(pos, b, '\x04', "blob")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc1 + 4
+		for i2 := range t.Blob {
+			{
+				n, err := elements.Int32.Element(pos, b, bsongenArrayKey(i2), int32(t.Blob[i2]))
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc1, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc3, err := bsongenWriteHeader(pos, b, '\x04', "ints")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc3 + 4
+		for i4 := range t.Ints {
+			{
+				n, err := elements.Int64.Element(pos, b, bsongenArrayKey(i4), int64(t.Ints[i4]))
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc3, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc5, err := bsongenWriteHeader(pos, b, '\x04', "minints")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc5 + 4
+		for i6 := range t.MinInts {
+			{
+				var n int
+				var err error
+				if i := int64(t.MinInts[i6]); i >= math.MinInt32 && i < math.MaxInt32 {
+					n, err = elements.Int32.Element(pos, b, bsongenArrayKey(i6), int32(i))
+				} else {
+					n, err = elements.Int64.Element(pos, b, bsongenArrayKey(i6), i)
+				}
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc5, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc7, err := bsongenWriteHeader(pos, b, '\x04', "array")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc7 + 4
+		for i8 := range t.Array {
+			{
+				n, err := elements.Int64.Element(pos, b, bsongenArrayKey(i8), int64(t.Array[i8]))
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc7, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc9, err := bsongenWriteHeader(pos, b, '\x04', "tags")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc9 + 4
+		for i10 := range t.Tags {
+			{
+				n, err := elements.String.Element(pos, b, bsongenArrayKey(i10), string(t.Tags[i10]))
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc9, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc11, err := bsongenWriteHeader(pos, b, '\x04', "nested")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc11 + 4
+		for i12 := range t.Nested {
+			{
+				doc13, err := bsongenWriteHeader(pos, b, '\x04', bsongenArrayKey(i12))
+				if err != nil {
+					return pos - start, err
+				}
+				pos = doc13 + 4
+				for i14 := range t.Nested[i12] {
+					{
+						n, err := elements.String.Element(pos, b, bsongenArrayKey(i14), string(t.Nested[i12][i14]))
+						pos += uint(n)
+						if err != nil {
+							return pos - start, err
+						}
+					}
+				}
+				pos, err = bsongenEndDocument(doc13, pos, b)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc11, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc15, err := bsongenWriteHeader(pos, b, '\x03', "address")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc15 + 4
+		{
+			n, err := t.Address.bsonWriteElements(pos, b)
+			pos += n
+			if err != nil {
+				return pos - start, err
+			}
+		}
+		pos, err = bsongenEndDocument(doc15, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc16, err := bsongenWriteHeader(pos, b, '\x04', "addresses")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc16 + 4
+		for i17 := range t.Addresses {
+			{
+				doc18, err := bsongenWriteHeader(pos, b, '\x03', bsongenArrayKey(i17))
+				if err != nil {
+					return pos - start, err
+				}
+				pos = doc18 + 4
+				{
+					n, err := t.Addresses[i17].bsonWriteElements(pos, b)
+					pos += n
+					if err != nil {
+						return pos - start, err
+					}
+				}
+				pos, err = bsongenEndDocument(doc18, pos, b)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc16, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	if t.Pointer == nil {
+		return pos - start, bsongenErrNilPointer
+	}
+	{
+		doc19, err := bsongenWriteHeader(pos, b, '\x03', "pointer")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc19 + 4
+		{
+			n, err := (*t.Pointer).bsonWriteElements(pos, b)
+			pos += n
+			if err != nil {
+				return pos - start, err
+			}
+		}
+		pos, err = bsongenEndDocument(doc19, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	if t.IntPtr == nil {
+		return pos - start, bsongenErrNilPointer
+	}
+	{
+		n, err := elements.Int64.Element(pos, b, "intptr", int64((*t.IntPtr)))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc20, err := bsongenWriteHeader(pos, b, '\x03', "map")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc20 + 4
+		for k21, v22 := range t.Map {
+			{
+				var n int
+				var err error
+				if i := int64(v22); i >= math.MinInt32 && i < math.MaxInt32 {
+					n, err = elements.Int32.Element(pos, b, string(k21), int32(i))
+				} else {
+					n, err = elements.Int64.Element(pos, b, string(k21), i)
+				}
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc20, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc23, err := bsongenWriteHeader(pos, b, '\x03', "mapslice")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc23 + 4
+		for k24, v25 := range t.MapSlice {
+			{
+				doc26, err := bsongenWriteHeader(pos, b, '\x04', string(k24))
+				if err != nil {
+					return pos - start, err
+				}
+				pos = doc26 + 4
+				for i27 := range v25 {
+					{
+						var n int
+						var err error
+						if i := int64(v25[i27]); i >= math.MinInt32 && i < math.MaxInt32 {
+							n, err = elements.Int32.Element(pos, b, bsongenArrayKey(i27), int32(i))
+						} else {
+							n, err = elements.Int64.Element(pos, b, bsongenArrayKey(i27), i)
+						}
+						pos += uint(n)
+						if err != nil {
+							return pos - start, err
+						}
+					}
+				}
+				pos, err = bsongenEndDocument(doc26, pos, b)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc23, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	if len(t.Empty) != 0 {
+		{
+			n, err := elements.String.Element(pos, b, "empty", string(t.Empty))
+			pos += uint(n)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	if t.EmptyPtr == nil || len((*t.EmptyPtr)) != 0 {
+		if t.EmptyPtr == nil {
+			return pos - start, bsongenErrNilPointer
+		}
+		{
+			n, err := elements.String.Element(pos, b, "emptyptr", string((*t.EmptyPtr)))
+			pos += uint(n)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	if t.EmptyAddr != (Address{}) {
+		{
+			doc28, err := bsongenWriteHeader(pos, b, '\x03', "emptyaddr")
+			if err != nil {
+				return pos - start, err
+			}
+			pos = doc28 + 4
+			{
+				n, err := t.EmptyAddr.bsonWriteElements(pos, b)
+				pos += n
+				if err != nil {
+					return pos - start, err
+				}
+			}
+			pos, err = bsongenEndDocument(doc28, pos, b)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	if len(t.EmptyList) != 0 {
+		{
+			doc29, err := bsongenWriteHeader(pos, b, '\x04', "emptylist")
+			if err != nil {
+				return pos - start, err
+			}
+			pos = doc29 + 4
+			for i30 := range t.EmptyList {
+				{
+					n, err := elements.String.Element(pos, b, bsongenArrayKey(i30), string(t.EmptyList[i30]))
+					pos += uint(n)
+					if err != nil {
+						return pos - start, err
+					}
+				}
+			}
+			pos, err = bsongenEndDocument(doc29, pos, b)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	if t.Zero != 0 {
+		{
+			n, err := elements.Int64.Element(pos, b, "zero", int64(t.Zero))
+			pos += uint(n)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	{
+		doc31, err := bsongenWriteHeader(pos, b, '\x03', "address2")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc31 + 4
+		{
+			n, err := t.Address2.bsonWriteElements(pos, b)
+			pos += n
+			if err != nil {
+				return pos - start, err
+			}
+		}
+		pos, err = bsongenEndDocument(doc31, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	return pos - start, nil
+}
+
+// bsonUnmarshalElement decodes elem into the field of t with a matching key and reports
+// whether such a field was found.
+func (t *Composite) bsonUnmarshalElement(elem *bson.Element) (bool, error) {
+	switch key := elem.Key(); {
+	case key == "bytes":
+		v := elem.Value()
+		if v.Type() == bson.TypeBinary {
+			_, data := v.Binary()
+			t.Bytes = append([]byte(nil), data...)
+		}
+		return true, nil
+	case key == "id":
+		v := elem.Value()
+		if v.Type() == bson.TypeBinary {
+			if _, data := v.Binary(); len(data) == len(t.ID) {
+				copy(t.ID[:], data)
+			}
+		}
+		return true, nil
+	case key == "blob":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr1, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s4 := make(Blob, 0)
+			for itr1.Next() {
+				var elem2 byte
+				ok3 := false
+				if u5, ok6 := bsongenUint64(itr1.Element().Value()); ok6 && uint64(byte(u5)) == u5 {
+					elem2 = byte(u5)
+					ok3 = true
+				}
+				if ok3 {
+					s4 = append(s4, elem2)
+				}
+			}
+			if err := itr1.Err(); err != nil {
+				return true, err
+			}
+			t.Blob = s4
+		}
+		return true, nil
+	case key == "ints":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr7, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s10 := make([]int, 0)
+			for itr7.Next() {
+				var elem8 int
+				ok9 := false
+				if i11, ok12 := bsongenInt64(itr7.Element().Value()); ok12 && int64(int(i11)) == i11 {
+					elem8 = int(i11)
+					ok9 = true
+				}
+				if ok9 {
+					s10 = append(s10, elem8)
+				}
+			}
+			if err := itr7.Err(); err != nil {
+				return true, err
+			}
+			t.Ints = s10
+		}
+		return true, nil
+	case key == "minints":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr13, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s16 := make([]int64, 0)
+			for itr13.Next() {
+				var elem14 int64
+				ok15 := false
+				if i17, ok18 := bsongenInt64(itr13.Element().Value()); ok18 && int64(int64(i17)) == i17 {
+					elem14 = int64(i17)
+					ok15 = true
+				}
+				if ok15 {
+					s16 = append(s16, elem14)
+				}
+			}
+			if err := itr13.Err(); err != nil {
+				return true, err
+			}
+			t.MinInts = s16
+		}
+		return true, nil
+	case key == "array":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr19, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			var a22 [2]int
+			for i23 := 0; i23 < len(a22) && itr19.Next(); i23++ {
+				if i24, ok25 := bsongenInt64(itr19.Element().Value()); ok25 && int64(int(i24)) == i24 {
+					a22[i23] = int(i24)
+				}
+			}
+			if err := itr19.Err(); err != nil {
+				return true, err
+			}
+			t.Array = a22
+		}
+		return true, nil
+	case key == "tags":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr26, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s29 := make(Tags, 0)
+			for itr26.Next() {
+				var elem27 string
+				ok28 := false
+				if s30, ok31 := bsongenString(itr26.Element().Value()); ok31 {
+					elem27 = string(s30)
+					ok28 = true
+				}
+				if ok28 {
+					s29 = append(s29, elem27)
+				}
+			}
+			if err := itr26.Err(); err != nil {
+				return true, err
+			}
+			t.Tags = s29
+		}
+		return true, nil
+	case key == "nested":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr32, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s35 := make([][]string, 0)
+			for itr32.Next() {
+				var elem33 []string
+				ok34 := false
+				if itr32.Element().Value().Type() == bson.TypeArray {
+					itr36, err := itr32.Element().Value().ReaderArray().Iterator()
+					if err != nil {
+						return true, err
+					}
+					s39 := make([]string, 0)
+					for itr36.Next() {
+						var elem37 string
+						ok38 := false
+						if s40, ok41 := bsongenString(itr36.Element().Value()); ok41 {
+							elem37 = string(s40)
+							ok38 = true
+						}
+						if ok38 {
+							s39 = append(s39, elem37)
+						}
+					}
+					if err := itr36.Err(); err != nil {
+						return true, err
+					}
+					elem33 = s39
+					ok34 = true
+				}
+				if ok34 {
+					s35 = append(s35, elem33)
+				}
+			}
+			if err := itr32.Err(); err != nil {
+				return true, err
+			}
+			t.Nested = s35
+		}
+		return true, nil
+	case key == "address":
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			var s42 Address
+			if err := s42.UnmarshalBSON(v.ReaderDocument()); err != nil {
+				return true, err
+			}
+			t.Address = s42
+		}
+		return true, nil
+	case key == "addresses":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr43, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s46 := make([]Address, 0)
+			for itr43.Next() {
+				var elem44 Address
+				ok45 := false
+				if itr43.Element().Value().Type() == bson.TypeEmbeddedDocument {
+					var s47 Address
+					if err := s47.UnmarshalBSON(itr43.Element().Value().ReaderDocument()); err != nil {
+						return true, err
+					}
+					elem44 = s47
+					ok45 = true
+				}
+				if ok45 {
+					s46 = append(s46, elem44)
+				}
+			}
+			if err := itr43.Err(); err != nil {
+				return true, err
+			}
+			t.Addresses = s46
+		}
+		return true, nil
+	case key == "pointer":
+		v := elem.Value()
+		{
+			p48 := new(Address)
+			ok49 := false
+			if v.Type() == bson.TypeEmbeddedDocument {
+				var s50 Address
+				if err := s50.UnmarshalBSON(v.ReaderDocument()); err != nil {
+					return true, err
+				}
+				(*p48) = s50
+				ok49 = true
+			}
+			if ok49 {
+				t.Pointer = p48
+			}
+		}
+		return true, nil
+	case key == "intptr":
+		v := elem.Value()
+		{
+			p51 := new(int)
+			ok52 := false
+			if i53, ok54 := bsongenInt64(v); ok54 && int64(int(i53)) == i53 {
+				(*p51) = int(i53)
+				ok52 = true
+			}
+			if ok52 {
+				t.IntPtr = p51
+			}
+		}
+		return true, nil
+	case key == "map":
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			itr55, err := v.ReaderDocument().Iterator()
+			if err != nil {
+				return true, err
+			}
+			m56 := make(map[string]int)
+			for itr55.Next() {
+				e59 := itr55.Element()
+				var elem57 int
+				ok58 := false
+				if i60, ok61 := bsongenInt64(e59.Value()); ok61 && int64(int(i60)) == i60 {
+					elem57 = int(i60)
+					ok58 = true
+				}
+				if ok58 {
+					m56[string(e59.Key())] = elem57
+				}
+			}
+			if err := itr55.Err(); err != nil {
+				return true, err
+			}
+			t.Map = m56
+		}
+		return true, nil
+	case key == "mapslice":
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			itr62, err := v.ReaderDocument().Iterator()
+			if err != nil {
+				return true, err
+			}
+			m63 := make(map[string][]int64)
+			for itr62.Next() {
+				e66 := itr62.Element()
+				var elem64 []int64
+				ok65 := false
+				if e66.Value().Type() == bson.TypeArray {
+					itr67, err := e66.Value().ReaderArray().Iterator()
+					if err != nil {
+						return true, err
+					}
+					s70 := make([]int64, 0)
+					for itr67.Next() {
+						var elem68 int64
+						ok69 := false
+						if i71, ok72 := bsongenInt64(itr67.Element().Value()); ok72 && int64(int64(i71)) == i71 {
+							elem68 = int64(i71)
+							ok69 = true
+						}
+						if ok69 {
+							s70 = append(s70, elem68)
+						}
+					}
+					if err := itr67.Err(); err != nil {
+						return true, err
+					}
+					elem64 = s70
+					ok65 = true
+				}
+				if ok65 {
+					m63[string(e66.Key())] = elem64
+				}
+			}
+			if err := itr62.Err(); err != nil {
+				return true, err
+			}
+			t.MapSlice = m63
+		}
+		return true, nil
+	case key == "empty":
+		v := elem.Value()
+		if s73, ok74 := bsongenString(v); ok74 {
+			t.Empty = string(s73)
+		}
+		return true, nil
+	case key == "emptyptr":
+		v := elem.Value()
+		{
+			p75 := new(string)
+			ok76 := false
+			if s77, ok78 := bsongenString(v); ok78 {
+				(*p75) = string(s77)
+				ok76 = true
+			}
+			if ok76 {
+				t.EmptyPtr = p75
+			}
+		}
+		return true, nil
+	case key == "emptyaddr":
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			var s79 Address
+			if err := s79.UnmarshalBSON(v.ReaderDocument()); err != nil {
+				return true, err
+			}
+			t.EmptyAddr = s79
+		}
+		return true, nil
+	case key == "emptylist":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr80, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s83 := make([]string, 0)
+			for itr80.Next() {
+				var elem81 string
+				ok82 := false
+				if s84, ok85 := bsongenString(itr80.Element().Value()); ok85 {
+					elem81 = string(s84)
+					ok82 = true
+				}
+				if ok82 {
+					s83 = append(s83, elem81)
+				}
+			}
+			if err := itr80.Err(); err != nil {
+				return true, err
+			}
+			t.EmptyList = s83
+		}
+		return true, nil
+	case key == "zero":
+		v := elem.Value()
+		if i86, ok87 := bsongenInt64(v); ok87 && int64(int(i86)) == i86 {
+			t.Zero = int(i86)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Address2"):
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			var s88 Address
+			if err := s88.UnmarshalBSON(v.ReaderDocument()); err != nil {
+				return true, err
+			}
+			t.Address2 = s88
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// MarshalBSON implements the bson.Marshaler interface.
+func (t Inline) MarshalBSON() ([]byte, error) {
+	size, err := t.bsonElementsSize()
+	if err != nil {
+		return nil, err
+	}
+	size += 5
+
+	b := make([]byte, size)
+	_, err = elements.Int32.Encode(0, b, int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := t.bsonWriteElements(4, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elements.Byte.Encode(4+n, b, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalBSONDocument implements the bson.DocumentMarshaler interface.
+func (t Inline) MarshalBSONDocument() (*bson.Document, error) {
+	b, err := t.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
+
+// UnmarshalBSON implements the bson.Unmarshaler interface.
+func (t *Inline) UnmarshalBSON(b []byte) error {
+	itr, err := bson.Reader(b).Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		_, err = t.bsonUnmarshalElement(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// bsonElementsSize returns the number of bytes needed to write the elements of t.
+func (t Inline) bsonElementsSize() (uint, error) {
+	var size uint
+	size += 6
+	size += 5 + uint(len(t.Name))
+	{
+		s, err := t.Address.bsonElementsSize()
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	for k1, v2 := range t.Extra {
+		size += 2 + uint(len(k1))
+		size += 5 + uint(len(v2))
+	}
+	return size, nil
+}
+
+// bsonWriteElements writes the elements of t into b at start. b must have room for the
+// number of bytes returned by bsonElementsSize.
+func (t Inline) bsonWriteElements(start uint, b []byte) (uint, error) {
+	pos := start
+	{
+		n, err := elements.String.Element(pos, b, "name", string(t.Name))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := t.Address.bsonWriteElements(pos, b)
+		pos += n
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	for k1, v2 := range t.Extra {
+		{
+			n, err := elements.String.Element(pos, b, string(k1), string(v2))
+			pos += uint(n)
+			if err != nil {
+				return pos - start, err
+			}
+		}
+	}
+	return pos - start, nil
+}
+
+// bsonUnmarshalElement decodes elem into the field of t with a matching key and reports
+// whether such a field was found.
+func (t *Inline) bsonUnmarshalElement(elem *bson.Element) (bool, error) {
+	switch key := elem.Key(); {
+	case key == "name":
+		v := elem.Value()
+		if s1, ok2 := bsongenString(v); ok2 {
+			t.Name = string(s1)
+		}
+		return true, nil
+	}
+	if ok, err := t.Address.bsonUnmarshalElement(elem); ok || err != nil {
+		return ok, err
+	}
+	v := elem.Value()
+	var val3 string
+	ok4 := false
+	if s5, ok6 := bsongenString(v); ok6 {
+		val3 = string(s5)
+		ok4 = true
+	}
+	if ok4 {
+		if t.Extra == nil {
+			t.Extra = make(map[string]string)
+		}
+		t.Extra[string(elem.Key())] = val3
+	}
+	return true, nil
+}
@@ -0,0 +1,120 @@
+// Code generated by bsongen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/elements"
+)
+
+var (
+	bsongenErrNilPointer  = errors.New("bsongen: cannot encode a nil pointer")
+	bsongenErrNilDocument = errors.New("bsongen: cannot encode a nil *bson.Document")
+	bsongenErrNilElement  = errors.New("bsongen: cannot encode a nil *bson.Element")
+)
+
+func bsongenOverflowError(u uint64) error {
+	return fmt.Errorf("BSON only has signed integer types and %d overflows an int64", u)
+}
+
+func bsongenArrayKey(i int) string {
+	return strconv.Itoa(i)
+}
+
+func bsongenKeyMatches(key, name string) bool {
+	return strings.ToLower(key) == strings.ToLower(name)
+}
+
+// bsongenWriteHeader writes the type and key of an element into b at pos and returns the
+// position following them.
+func bsongenWriteHeader(pos uint, b []byte, t byte, key string) (uint, error) {
+	n, err := elements.Byte.Encode(pos, b, t)
+	pos += uint(n)
+	if err != nil {
+		return pos, err
+	}
+
+	n, err = elements.CString.Encode(pos, b, key)
+	return pos + uint(n), err
+}
+
+// bsongenEndDocument writes the null terminator of the document that starts at start and ends
+// at pos, backfills its length, and returns the position following the document.
+func bsongenEndDocument(start, pos uint, b []byte) (uint, error) {
+	n, err := elements.Byte.Encode(pos, b, '\x00')
+	pos += uint(n)
+	if err != nil {
+		return pos, err
+	}
+
+	_, err = elements.Int32.Encode(start, b, int32(pos-start))
+	return pos, err
+}
+
+func bsongenInt64(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	}
+
+	return 0, false
+}
+
+func bsongenUint64(v *bson.Value) (uint64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		f := v.Double()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	case bson.TypeInt32:
+		i := v.Int32()
+		return uint64(i), i >= 0
+	case bson.TypeInt64:
+		i := v.Int64()
+		return uint64(i), i >= 0
+	}
+
+	return 0, false
+}
+
+func bsongenFloat64(v *bson.Value) (float64, bool) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		return v.Double(), true
+	case bson.TypeInt32:
+		return float64(v.Int32()), true
+	case bson.TypeInt64:
+		return float64(v.Int64()), true
+	}
+
+	return 0, false
+}
+
+func bsongenString(v *bson.Value) (string, bool) {
+	switch v.Type() {
+	case bson.TypeString:
+		return v.StringValue(), true
+	case bson.TypeJavaScript:
+		return v.JavaScript(), true
+	case bson.TypeSymbol:
+		return v.Symbol(), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,222 @@
+package gentest
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/skriptble/wilson/bson"
+)
+
+// reflectionEncode encodes v using the reflection based encoder. Since the types in this
+// package implement bson.Marshaler, v is inlined into a struct type without methods so the
+// encoder cannot use MarshalBSON.
+func reflectionEncode(v interface{}) ([]byte, error) {
+	rt := reflect.StructOf([]reflect.StructField{
+		{Name: "V", Type: reflect.TypeOf(v), Tag: `bson:",inline"`},
+	})
+	wrapper := reflect.New(rt).Elem()
+	wrapper.Field(0).Set(reflect.ValueOf(v))
+
+	var buf bytes.Buffer
+	err := bson.NewEncoder(&buf).Encode(wrapper.Interface())
+	return buf.Bytes(), err
+}
+
+func intPtr(i int) *int          { return &i }
+func stringPtr(s string) *string { return &s }
+
+func newComposite() Composite {
+	return Composite{
+		Bytes:     []byte{0x01, 0x02, 0x03},
+		ID:        ID{0xDE, 0xAD, 0xBE, 0xEF},
+		Blob:      Blob{0x04, 0x05},
+		Ints:      []int{1, -2, math.MaxInt32},
+		MinInts:   []int64{1, math.MaxInt32, math.MaxInt64, math.MinInt32},
+		Array:     [2]int{3, 4},
+		Tags:      Tags{"a", "b"},
+		Nested:    [][]string{{"x"}, {}, {"y", "z"}},
+		Address:   Address{Street: "1 Main St", City: "Springfield"},
+		Addresses: []Address{{Street: "2 Elm St"}, {City: "Shelbyville"}},
+		Pointer:   &Address{Street: "3 Oak St"},
+		IntPtr:    intPtr(42),
+		Map:       map[string]int{"answer": 42},
+		MapSlice:  map[string][]int64{"big": {1, math.MaxInt64}},
+		EmptyPtr:  stringPtr(""),
+		Address2:  Address{City: "Capital City"},
+	}
+}
+
+func TestMarshalBSONMatchesEncoder(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    interface{}
+	}{
+		{"Scalars/zero", Scalars{}},
+		{"Scalars", Scalars{
+			Bool: true, Int8: math.MinInt8, Int16: math.MaxInt16, Int32: math.MinInt32, Int: -1,
+			Int64: math.MaxInt64, Uint8: math.MaxUint8, Uint16: math.MaxUint16, Uint: 12, Uint32: math.MaxUint32,
+			Uint64: math.MaxInt64 - 1, Float32: 3.25, Float64: math.Pi, String: "hello, world", Status: 7,
+			MinInt: 1, MinUint: 2, Ignored: "ignored", private: "private",
+		}},
+		{"Scalars/minsize-boundary", Scalars{MinInt: math.MaxInt32, MinUint: math.MaxInt32}},
+		{"Scalars/minsize-below-boundary", Scalars{MinInt: math.MaxInt32 - 1, MinUint: math.MaxInt32 - 1}},
+		{"Scalars/minsize-negative", Scalars{MinInt: math.MinInt64}},
+		{"Composite", newComposite()},
+		{"Composite/omitempty", func() Composite {
+			c := newComposite()
+			c.Empty, c.EmptyPtr, c.EmptyAddr, c.EmptyList, c.Zero = "e", stringPtr("p"), Address{City: "c"}, []string{"l"}, 1
+			return c
+		}()},
+		{"Inline", Inline{Name: "foo", Address: Address{Street: "bar"}, Extra: map[string]string{"baz": "qux"}}},
+		{"Specials", Specials{
+			Doc:    bson.NewDocument(bson.C.String("foo", "bar")),
+			Reader: bson.Reader{0x05, 0x00, 0x00, 0x00, 0x00},
+			Elem:   bson.C.Int32("elem", 1),
+			Docs:   []*bson.Document{bson.NewDocument(), bson.NewDocument(bson.C.Null("a"))},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := reflectionEncode(tc.v)
+			if err != nil {
+				t.Fatalf("Unexpected error from the reflection encoder: %v", err)
+			}
+
+			got, err := tc.v.(bson.Marshaler).MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error from MarshalBSON: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("MarshalBSON output does not match the encoder.\ngot  %v\nwant %v", got, want)
+			}
+
+			doc, err := tc.v.(bson.DocumentMarshaler).MarshalBSONDocument()
+			if err != nil {
+				t.Fatalf("Unexpected error from MarshalBSONDocument: %v", err)
+			}
+			got, err = doc.MarshalBSON()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("MarshalBSONDocument output does not match the encoder.\ngot  %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMarshalBSONErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		v    interface{}
+	}{
+		{"uint overflow", Scalars{Uint64: math.MaxUint64}},
+		{"nil pointer", Composite{}},
+		{"nil pointer omitempty", func() Composite {
+			c := newComposite()
+			c.EmptyPtr = nil
+			return c
+		}()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := reflectionEncode(tc.v)
+			if err == nil {
+				t.Fatalf("Expected the reflection encoder to return an error")
+			}
+
+			_, err = tc.v.(bson.Marshaler).MarshalBSON()
+			if err == nil {
+				t.Errorf("Expected MarshalBSON to return an error")
+			}
+		})
+	}
+}
+
+func TestUnmarshalBSON(t *testing.T) {
+	t.Run("Composite", func(t *testing.T) {
+		want := newComposite()
+		b, err := want.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var got Composite
+		err = got.UnmarshalBSON(b)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// Omitted fields are left unset.
+		want.EmptyPtr = nil
+		if !cmp.Equal(got, want) {
+			t.Errorf("Decoded value does not match.\n%s", cmp.Diff(got, want))
+		}
+	})
+	t.Run("Scalars", func(t *testing.T) {
+		want := Scalars{
+			Bool: true, Int8: -8, Int16: 16, Int32: -32, Int: math.MinInt64, Int64: math.MaxInt64, Uint8: 8,
+			Uint16: 16, Uint: 1, Uint32: math.MaxUint32, Uint64: 64, Float32: 1.5, Float64: -2.25, String: "foo",
+			Status: 3, MinInt: 4, MinUint: 5,
+		}
+		b, err := want.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var got Scalars
+		err = bson.NewDecoder(bytes.NewReader(b)).Decode(&got)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("Decoded value does not match. got %+v; want %+v", got, want)
+		}
+	})
+	t.Run("Conversions", func(t *testing.T) {
+		doc := bson.NewDocument(
+			bson.C.Double("int8", 12),
+			bson.C.Int64("int32", math.MaxInt64),
+			bson.C.Int32("uint", -1),
+			bson.C.Int32("float64", 7),
+			bson.C.Symbol("string", "sym"),
+			bson.C.String("bool", "not a bool"),
+			bson.C.Int32("STATUS", 2),
+		)
+		b, err := doc.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var got Scalars
+		err = got.UnmarshalBSON(b)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := Scalars{Int8: 12, Float64: 7, String: "sym", Status: 2}
+		if got != want {
+			t.Errorf("Decoded value does not match. got %+v; want %+v", got, want)
+		}
+	})
+	t.Run("Inline", func(t *testing.T) {
+		want := Inline{Name: "foo", Address: Address{Street: "bar", City: "baz"}, Extra: map[string]string{"qux": "quux"}}
+		b, err := want.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		var got Inline
+		err = got.UnmarshalBSON(b)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !cmp.Equal(got, want) {
+			t.Errorf("Decoded value does not match.\n%s", cmp.Diff(got, want))
+		}
+	})
+}
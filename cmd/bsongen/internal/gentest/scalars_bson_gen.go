@@ -0,0 +1,624 @@
+// Code generated by bsongen. DO NOT EDIT.
+
+package gentest
+
+import (
+	"math"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/elements"
+)
+
+// MarshalBSON implements the bson.Marshaler interface.
+func (t Scalars) MarshalBSON() ([]byte, error) {
+	size, err := t.bsonElementsSize()
+	if err != nil {
+		return nil, err
+	}
+	size += 5
+
+	b := make([]byte, size)
+	_, err = elements.Int32.Encode(0, b, int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := t.bsonWriteElements(4, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elements.Byte.Encode(4+n, b, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalBSONDocument implements the bson.DocumentMarshaler interface.
+func (t Scalars) MarshalBSONDocument() (*bson.Document, error) {
+	b, err := t.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
+
+// UnmarshalBSON implements the bson.Unmarshaler interface.
+func (t *Scalars) UnmarshalBSON(b []byte) error {
+	itr, err := bson.Reader(b).Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		_, err = t.bsonUnmarshalElement(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// bsonElementsSize returns the number of bytes needed to write the elements of t.
+func (t Scalars) bsonElementsSize() (uint, error) {
+	var size uint
+	size += 6
+	size++
+	size += 6
+	size += 4
+	size += 7
+	size += 4
+	size += 7
+	size += 4
+	size += 5
+	size += 8
+	size += 7
+	size += 8
+	size += 7
+	size += 4
+	size += 8
+	size += 4
+	size += 6
+	switch u := uint64(t.Uint); {
+	case u < math.MaxInt64:
+		size += 8
+	default:
+		return 0, bsongenOverflowError(u)
+	}
+	size += 8
+	switch u := uint64(t.Uint32); {
+	case u < math.MaxInt64:
+		size += 8
+	default:
+		return 0, bsongenOverflowError(u)
+	}
+	size += 8
+	switch u := uint64(t.Uint64); {
+	case u < math.MaxInt64:
+		size += 8
+	default:
+		return 0, bsongenOverflowError(u)
+	}
+	size += 9
+	size += 8
+	size += 9
+	size += 8
+	size += 8
+	size += 5 + uint(len(t.String))
+	size += 8
+	size += 8
+	size += 8
+	if i := int64(t.MinInt); i >= math.MinInt32 && i < math.MaxInt32 {
+		size += 4
+	} else {
+		size += 8
+	}
+	size += 9
+	switch u := uint64(t.MinUint); {
+	case u < math.MaxInt32:
+		size += 4
+	case u < math.MaxInt64:
+		size += 8
+	default:
+		return 0, bsongenOverflowError(u)
+	}
+	return size, nil
+}
+
+// bsonWriteElements writes the elements of t into b at start. b must have room for the
+// number of bytes returned by bsonElementsSize.
+func (t Scalars) bsonWriteElements(start uint, b []byte) (uint, error) {
+	pos := start
+	{
+		n, err := elements.Boolean.Element(pos, b, "bool", bool(t.Bool))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int32.Element(pos, b, "int8", int32(t.Int8))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int32.Element(pos, b, "int16", int32(t.Int16))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int32.Element(pos, b, "int32", int32(t.Int32))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int64.Element(pos, b, "int", int64(t.Int))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int64.Element(pos, b, "int64", int64(t.Int64))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int32.Element(pos, b, "uint8", int32(t.Uint8))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int32.Element(pos, b, "uint16", int32(t.Uint16))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		var n int
+		var err error
+		switch u := uint64(t.Uint); {
+		case u < math.MaxInt64:
+			n, err = elements.Int64.Element(pos, b, "uint", int64(u))
+		default:
+			return pos - start, bsongenOverflowError(u)
+		}
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		var n int
+		var err error
+		switch u := uint64(t.Uint32); {
+		case u < math.MaxInt64:
+			n, err = elements.Int64.Element(pos, b, "uint32", int64(u))
+		default:
+			return pos - start, bsongenOverflowError(u)
+		}
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		var n int
+		var err error
+		switch u := uint64(t.Uint64); {
+		case u < math.MaxInt64:
+			n, err = elements.Int64.Element(pos, b, "uint64", int64(u))
+		default:
+			return pos - start, bsongenOverflowError(u)
+		}
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Double.Element(pos, b, "float32", float64(t.Float32))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Double.Element(pos, b, "float64", float64(t.Float64))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.String.Element(pos, b, "string", string(t.String))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Int64.Element(pos, b, "status", int64(t.Status))
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		var n int
+		var err error
+		if i := int64(t.MinInt); i >= math.MinInt32 && i < math.MaxInt32 {
+			n, err = elements.Int32.Element(pos, b, "minint", int32(i))
+		} else {
+			n, err = elements.Int64.Element(pos, b, "minint", i)
+		}
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		var n int
+		var err error
+		switch u := uint64(t.MinUint); {
+		case u < math.MaxInt32:
+			n, err = elements.Int32.Element(pos, b, "minuint", int32(u))
+		case u < math.MaxInt64:
+			n, err = elements.Int64.Element(pos, b, "minuint", int64(u))
+		default:
+			return pos - start, bsongenOverflowError(u)
+		}
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	return pos - start, nil
+}
+
+// bsonUnmarshalElement decodes elem into the field of t with a matching key and reports
+// whether such a field was found.
+func (t *Scalars) bsonUnmarshalElement(elem *bson.Element) (bool, error) {
+	switch key := elem.Key(); {
+	case bsongenKeyMatches(key, "Bool"):
+		v := elem.Value()
+		if v.Type() == bson.TypeBoolean {
+			t.Bool = bool(v.Boolean())
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Int8"):
+		v := elem.Value()
+		if i1, ok2 := bsongenInt64(v); ok2 && int64(int8(i1)) == i1 {
+			t.Int8 = int8(i1)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Int16"):
+		v := elem.Value()
+		if i3, ok4 := bsongenInt64(v); ok4 && int64(int16(i3)) == i3 {
+			t.Int16 = int16(i3)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Int32"):
+		v := elem.Value()
+		if i5, ok6 := bsongenInt64(v); ok6 && int64(int32(i5)) == i5 {
+			t.Int32 = int32(i5)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Int"):
+		v := elem.Value()
+		if i7, ok8 := bsongenInt64(v); ok8 && int64(int(i7)) == i7 {
+			t.Int = int(i7)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Int64"):
+		v := elem.Value()
+		if i9, ok10 := bsongenInt64(v); ok10 && int64(int64(i9)) == i9 {
+			t.Int64 = int64(i9)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Uint8"):
+		v := elem.Value()
+		if u11, ok12 := bsongenUint64(v); ok12 && uint64(uint8(u11)) == u11 {
+			t.Uint8 = uint8(u11)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Uint16"):
+		v := elem.Value()
+		if u13, ok14 := bsongenUint64(v); ok14 && uint64(uint16(u13)) == u13 {
+			t.Uint16 = uint16(u13)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Uint"):
+		v := elem.Value()
+		if u15, ok16 := bsongenUint64(v); ok16 && uint64(uint(u15)) == u15 {
+			t.Uint = uint(u15)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Uint32"):
+		v := elem.Value()
+		if u17, ok18 := bsongenUint64(v); ok18 && uint64(uint32(u17)) == u17 {
+			t.Uint32 = uint32(u17)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Uint64"):
+		v := elem.Value()
+		if u19, ok20 := bsongenUint64(v); ok20 && uint64(uint64(u19)) == u19 {
+			t.Uint64 = uint64(u19)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Float32"):
+		v := elem.Value()
+		if f21, ok22 := bsongenFloat64(v); ok22 {
+			t.Float32 = float32(f21)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Float64"):
+		v := elem.Value()
+		if f23, ok24 := bsongenFloat64(v); ok24 {
+			t.Float64 = float64(f23)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "String"):
+		v := elem.Value()
+		if s25, ok26 := bsongenString(v); ok26 {
+			t.String = string(s25)
+		}
+		return true, nil
+	case bsongenKeyMatches(key, "Status"):
+		v := elem.Value()
+		if i27, ok28 := bsongenInt64(v); ok28 && int64(Status(i27)) == i27 {
+			t.Status = Status(i27)
+		}
+		return true, nil
+	case key == "minint":
+		v := elem.Value()
+		if i29, ok30 := bsongenInt64(v); ok30 && int64(int(i29)) == i29 {
+			t.MinInt = int(i29)
+		}
+		return true, nil
+	case key == "minuint":
+		v := elem.Value()
+		if u31, ok32 := bsongenUint64(v); ok32 && uint64(uint64(u31)) == u31 {
+			t.MinUint = uint64(u31)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// MarshalBSON implements the bson.Marshaler interface.
+func (t Specials) MarshalBSON() ([]byte, error) {
+	size, err := t.bsonElementsSize()
+	if err != nil {
+		return nil, err
+	}
+	size += 5
+
+	b := make([]byte, size)
+	_, err = elements.Int32.Encode(0, b, int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := t.bsonWriteElements(4, b)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = elements.Byte.Encode(4+n, b, '\x00')
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MarshalBSONDocument implements the bson.DocumentMarshaler interface.
+func (t Specials) MarshalBSONDocument() (*bson.Document, error) {
+	b, err := t.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
+
+// UnmarshalBSON implements the bson.Unmarshaler interface.
+func (t *Specials) UnmarshalBSON(b []byte) error {
+	itr, err := bson.Reader(b).Iterator()
+	if err != nil {
+		return err
+	}
+
+	for itr.Next() {
+		_, err = t.bsonUnmarshalElement(itr.Element())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// bsonElementsSize returns the number of bytes needed to write the elements of t.
+func (t Specials) bsonElementsSize() (uint, error) {
+	var size uint
+	size += 5
+	if t.Doc == nil {
+		return 0, bsongenErrNilDocument
+	}
+	{
+		s, err := t.Doc.Validate()
+		if err != nil {
+			return 0, err
+		}
+		size += uint(s)
+	}
+	size += 8
+	size += uint(len(t.Reader))
+	if t.Elem == nil {
+		return 0, bsongenErrNilElement
+	}
+	{
+		s, err := t.Elem.Validate()
+		if err != nil {
+			return 0, err
+		}
+		size += uint(s)
+	}
+	size += 6
+	size += 5
+	for i1 := range t.Docs {
+		size += 2 + uint(len(bsongenArrayKey(i1)))
+		if t.Docs[i1] == nil {
+			return 0, bsongenErrNilDocument
+		}
+		{
+			s, err := t.Docs[i1].Validate()
+			if err != nil {
+				return 0, err
+			}
+			size += uint(s)
+		}
+	}
+	return size, nil
+}
+
+// bsonWriteElements writes the elements of t into b at start. b must have room for the
+// number of bytes returned by bsonElementsSize.
+func (t Specials) bsonWriteElements(start uint, b []byte) (uint, error) {
+	pos := start
+	if t.Doc == nil {
+		return pos - start, bsongenErrNilDocument
+	}
+	{
+		n, err := bsongenWriteHeader(pos, b, '\x03', "doc")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = n
+	}
+	{
+		n, err := t.Doc.WriteDocument(pos, b)
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		n, err := elements.Document.Element(pos, b, "reader", t.Reader)
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	if t.Elem == nil {
+		return pos - start, bsongenErrNilElement
+	}
+	{
+		n, err := t.Elem.WriteElement(pos, b)
+		pos += uint(n)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	{
+		doc1, err := bsongenWriteHeader(pos, b, '\x04', "docs")
+		if err != nil {
+			return pos - start, err
+		}
+		pos = doc1 + 4
+		for i2 := range t.Docs {
+			if t.Docs[i2] == nil {
+				return pos - start, bsongenErrNilDocument
+			}
+			{
+				n, err := bsongenWriteHeader(pos, b, '\x03', bsongenArrayKey(i2))
+				if err != nil {
+					return pos - start, err
+				}
+				pos = n
+			}
+			{
+				n, err := t.Docs[i2].WriteDocument(pos, b)
+				pos += uint(n)
+				if err != nil {
+					return pos - start, err
+				}
+			}
+		}
+		pos, err = bsongenEndDocument(doc1, pos, b)
+		if err != nil {
+			return pos - start, err
+		}
+	}
+	return pos - start, nil
+}
+
+// bsonUnmarshalElement decodes elem into the field of t with a matching key and reports
+// whether such a field was found.
+func (t *Specials) bsonUnmarshalElement(elem *bson.Element) (bool, error) {
+	switch key := elem.Key(); {
+	case key == "doc":
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			d1, err := bson.ReadDocument(append([]byte(nil), v.ReaderDocument()...))
+			if err != nil {
+				return true, err
+			}
+			t.Doc = d1
+		}
+		return true, nil
+	case key == "reader":
+		v := elem.Value()
+		if v.Type() == bson.TypeEmbeddedDocument {
+			t.Reader = bson.Reader(append([]byte(nil), v.ReaderDocument()...))
+		}
+		return true, nil
+	case key == "docs":
+		v := elem.Value()
+		if v.Type() == bson.TypeArray {
+			itr2, err := v.ReaderArray().Iterator()
+			if err != nil {
+				return true, err
+			}
+			s5 := make([]*bson.Document, 0)
+			for itr2.Next() {
+				var elem3 *bson.Document
+				ok4 := false
+				if itr2.Element().Value().Type() == bson.TypeEmbeddedDocument {
+					d6, err := bson.ReadDocument(append([]byte(nil), itr2.Element().Value().ReaderDocument()...))
+					if err != nil {
+						return true, err
+					}
+					elem3 = d6
+					ok4 = true
+				}
+				if ok4 {
+					s5 = append(s5, elem3)
+				}
+			}
+			if err := itr2.Err(); err != nil {
+				return true, err
+			}
+			t.Docs = s5
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,89 @@
+// Package gentest contains types used to test the code generated by bsongen.
+package gentest
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+//go:generate go run github.com/skriptble/wilson/cmd/bsongen -type=Composite,Inline
+//go:generate go run github.com/skriptble/wilson/cmd/bsongen -type=Scalars,Specials -output=scalars_bson_gen.go
+
+// Status is a named integer type.
+type Status int
+
+// Tags is a named slice type.
+type Tags []string
+
+// Blob is a named byte slice, which the encoder treats as an array rather than binary.
+type Blob []byte
+
+// ID is a named byte array, which the encoder treats as binary.
+type ID [4]byte
+
+// Scalars has a field of each scalar type.
+type Scalars struct {
+	Bool    bool
+	Int8    int8
+	Int16   int16
+	Int32   int32
+	Int     int
+	Int64   int64
+	Uint8   uint8
+	Uint16  uint16
+	Uint    uint
+	Uint32  uint32
+	Uint64  uint64
+	Float32 float32
+	Float64 float64
+	String  string
+	Status  Status
+	MinInt  int    `bson:"minint,minsize"`
+	MinUint uint64 `bson:",minsize"`
+	Ignored string `bson:"-"`
+	private string
+}
+
+// Address is a nested struct.
+type Address struct {
+	Street string `bson:"street"`
+	City   string `bson:"city,omitempty"`
+}
+
+// Composite has fields of composite types.
+type Composite struct {
+	Bytes     []byte             `bson:"bytes"`
+	ID        ID                 `bson:"id"`
+	Blob      Blob               `bson:"blob"`
+	Ints      []int              `bson:"ints"`
+	MinInts   []int64            `bson:"minints,minsize"`
+	Array     [2]int             `bson:"array"`
+	Tags      Tags               `bson:"tags"`
+	Nested    [][]string         `bson:"nested"`
+	Address   Address            `bson:"address"`
+	Addresses []Address          `bson:"addresses"`
+	Pointer   *Address           `bson:"pointer"`
+	IntPtr    *int               `bson:"intptr"`
+	Map       map[string]int     `bson:"map"`
+	MapSlice  map[string][]int64 `bson:"mapslice"`
+	Empty     string             `bson:"empty,omitempty"`
+	EmptyPtr  *string            `bson:"emptyptr,omitempty"`
+	EmptyAddr Address            `bson:"emptyaddr,omitempty"`
+	EmptyList []string           `bson:"emptylist,omitempty"`
+	Zero      int                `bson:"zero,omitempty"`
+	Address2  Address
+}
+
+// Inline has inlined fields.
+type Inline struct {
+	Name    string            `bson:"name"`
+	Address Address           `bson:",inline"`
+	Extra   map[string]string `bson:",inline"`
+}
+
+// Specials has fields of the bson package types the encoder handles directly.
+type Specials struct {
+	Doc    *bson.Document   `bson:"doc"`
+	Reader bson.Reader      `bson:"reader"`
+	Elem   *bson.Element    `bson:"ignored"`
+	Docs   []*bson.Document `bson:"docs"`
+}
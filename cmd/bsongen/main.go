@@ -0,0 +1,104 @@
+// Bsongen generates reflection-free implementations of bson.Marshaler, bson.DocumentMarshaler,
+// and bson.Unmarshaler for struct types.
+//
+// Given the name of one or more struct types in a package, bsongen writes a file containing
+// MarshalBSON, MarshalBSONDocument, and UnmarshalBSON methods for those types, as well as for
+// any struct types of the same package that they refer to, along with a bsongen_helpers.go file
+// in the same directory holding the functions those methods share. Every bsongen invocation for a
+// package writes the same helpers file, so a package may contain several generated files as long
+// as no struct type has methods generated in more than one of them. It is intended to be used
+// with go generate:
+//
+//	//go:generate bsongen -type=Person,Address
+//
+// The generated MarshalBSON computes the size of the document up front and writes it into a
+// single slice using the elements package. The output is byte-for-byte identical to that of the
+// reflection based bson.Encoder: field keys, the bson struct tag, and the omitempty, minsize,
+// and inline options are handled exactly as the encoder handles them. In particular a nil
+// pointer is never omitted and results in an error, and minsize applies to the values of a
+// slice or array field while map values are always encoded with minsize.
+//
+// Fields may be of the predeclared boolean, numeric, and string types, of slices, arrays,
+// pointers, and maps with string keys of supported types, of struct types declared in the same
+// package, or of type *bson.Document, bson.Reader, or *bson.Element. Types declared in the
+// package with one of these as their underlying type are also supported. Using omitempty on a
+// struct field requires the struct to be comparable.
+//
+// The generated UnmarshalBSON matches keys the same way the bson.Decoder does and converts
+// numeric values between BSON types when the conversion is lossless. Values of other BSON types
+// are ignored.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// helpersFile is the name of the file holding the functions shared by the generated methods.
+const helpersFile = "bsongen_helpers.go"
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	output    = flag.String("output", "", "output file name; default <dir>/bson_gen.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of bsongen:\n")
+	fmt.Fprintf(os.Stderr, "\tbsongen -type T [directory]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+
+	p, err := parseDir(dir)
+	if err != nil {
+		fatal(err)
+	}
+
+	src, err := generate(p, strings.Split(*typeNames, ","))
+	if err != nil {
+		fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = filepath.Join(dir, "bson_gen.go")
+	}
+
+	err = ioutil.WriteFile(out, src, 0644)
+	if err != nil {
+		fatal(err)
+	}
+
+	helpers, err := generateHelpers(p)
+	if err != nil {
+		fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(filepath.Dir(out), helpersFile), helpers, 0644)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "bsongen: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const bsonImportPath = "github.com/skriptble/wilson/bson"
+
+// kind is the shape of a Go type as far as the BSON encoder is concerned. The kinds mirror the
+// reflect.Kind groupings used by the reflection based encoder.
+type kind int
+
+const (
+	kindBool      kind = iota
+	kindInt32          // int8, int16, int32
+	kindInt64          // int, int64
+	kindSmallUint      // uint8, uint16
+	kindUint           // uint, uint32, uint64
+	kindFloat          // float32, float64
+	kindString
+	kindBytes     // []byte, encoded as binary
+	kindByteArray // [N]byte, encoded as binary
+	kindSlice
+	kindArray
+	kindMap
+	kindStruct
+	kindPtr
+	kindDocument // *bson.Document
+	kindReader   // bson.Reader
+	kindElement  // *bson.Element
+)
+
+// typ describes a field type.
+type typ struct {
+	kind kind
+
+	// expr is the Go source for the type, e.g. "[]string" or "Status".
+	expr string
+
+	// basic is the name of the predeclared type underlying a scalar, e.g. "int8".
+	basic string
+
+	// elem is the element type of slices, arrays, maps, and pointers.
+	elem *typ
+
+	// length is the length expression of an array.
+	length string
+
+	// key is the Go source for the key type of a map.
+	key string
+
+	// name is the name of a struct type.
+	name string
+}
+
+// field is a single exported struct field.
+type field struct {
+	name      string
+	key       string
+	tagged    bool
+	omitempty bool
+	minsize   bool
+	inline    bool
+	typ       *typ
+}
+
+// structType is a struct type for which methods are generated.
+type structType struct {
+	name   string
+	fields []*field
+}
+
+// pkg holds the parsed type declarations of a package.
+type pkg struct {
+	name  string
+	fset  *token.FileSet
+	specs map[string]*ast.TypeSpec
+	files map[string]*ast.File
+}
+
+// parseDir parses the non-test Go files in dir.
+func parseDir(dir string) (*pkg, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected exactly one package in %s, found %d", dir, len(pkgs))
+	}
+
+	p := &pkg{fset: fset, specs: make(map[string]*ast.TypeSpec), files: make(map[string]*ast.File)}
+	for name, astPkg := range pkgs {
+		p.name = name
+		for _, file := range astPkg.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					ts := spec.(*ast.TypeSpec)
+					p.specs[ts.Name.Name] = ts
+					p.files[ts.Name.Name] = file
+				}
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// structs resolves the named struct types, and any struct types of the package they refer to,
+// returning them sorted by name.
+func (p *pkg) structs(names []string) ([]*structType, error) {
+	done := make(map[string]*structType)
+	queue := append([]string(nil), names...)
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, ok := done[name]; ok {
+			continue
+		}
+
+		st, refs, err := p.structType(name)
+		if err != nil {
+			return nil, err
+		}
+		done[name] = st
+		queue = append(queue, refs...)
+	}
+
+	structs := make([]*structType, 0, len(done))
+	for _, st := range done {
+		structs = append(structs, st)
+	}
+	sort.Slice(structs, func(i, j int) bool { return structs[i].name < structs[j].name })
+
+	return structs, nil
+}
+
+func (p *pkg) structType(name string) (*structType, []string, error) {
+	ts, ok := p.specs[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("type %s not found", name)
+	}
+	astStruct, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return nil, nil, fmt.Errorf("type %s is not a struct", name)
+	}
+
+	st := &structType{name: name}
+	var refs []string
+
+	for _, astField := range astStruct.Fields.List {
+		names := make([]string, 0, len(astField.Names))
+		for _, ident := range astField.Names {
+			names = append(names, ident.Name)
+		}
+		if len(names) == 0 {
+			// Embedded fields are named after their type.
+			names = append(names, embeddedName(astField.Type))
+		}
+
+		var tag reflect.StructTag
+		if astField.Tag != nil {
+			s, err := strconv.Unquote(astField.Tag.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			tag = reflect.StructTag(s)
+		}
+
+		for _, fname := range names {
+			if !ast.IsExported(fname) {
+				continue
+			}
+
+			f, skip := parseTag(fname, tag)
+			if skip {
+				continue
+			}
+
+			t, err := p.resolve(astField.Type, p.files[name])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s.%s: %v", name, fname, err)
+			}
+			f.typ = t
+
+			if f.inline && t.kind != kindStruct && t.kind != kindMap {
+				return nil, nil, fmt.Errorf("%s.%s: inline is only supported for map and struct types", name, fname)
+			}
+
+			refs = append(refs, t.structRefs()...)
+			st.fields = append(st.fields, f)
+		}
+	}
+
+	return st, refs, nil
+}
+
+// parseTag parses the struct tag of a field the same way the encoder does.
+func parseTag(name string, tag reflect.StructTag) (*field, bool) {
+	f := &field{name: name, key: strings.ToLower(name)}
+
+	str, ok := tag.Lookup("bson")
+	switch {
+	case ok:
+		if str == "-" {
+			return nil, true
+		}
+		f.tagged = true
+		for idx, part := range strings.Split(str, ",") {
+			if idx == 0 && part != "" {
+				f.key = part
+			}
+			switch part {
+			case "omitempty":
+				f.omitempty = true
+			case "minsize":
+				f.minsize = true
+			case "inline":
+				f.inline = true
+			}
+		}
+	case !strings.Contains(string(tag), ":") && len(tag) > 0:
+		f.key = string(tag)
+		f.tagged = true
+	}
+
+	return f, false
+}
+
+func embeddedName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+
+	return ""
+}
+
+var basicKinds = map[string]kind{
+	"bool":    kindBool,
+	"int8":    kindInt32,
+	"int16":   kindInt32,
+	"int32":   kindInt32,
+	"rune":    kindInt32,
+	"int":     kindInt64,
+	"int64":   kindInt64,
+	"uint8":   kindSmallUint,
+	"byte":    kindSmallUint,
+	"uint16":  kindSmallUint,
+	"uint":    kindUint,
+	"uint32":  kindUint,
+	"uint64":  kindUint,
+	"float32": kindFloat,
+	"float64": kindFloat,
+	"string":  kindString,
+}
+
+// resolve converts a type expression from file into a typ.
+func (p *pkg) resolve(expr ast.Expr, file *ast.File) (*typ, error) {
+	src := p.source(expr)
+
+	switch t := expr.(type) {
+	case *ast.ParenExpr:
+		return p.resolve(t.X, file)
+	case *ast.Ident:
+		if k, ok := basicKinds[t.Name]; ok {
+			return &typ{kind: k, expr: src, basic: t.Name}, nil
+		}
+
+		ts, ok := p.specs[t.Name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported type %s", src)
+		}
+		if _, ok := ts.Type.(*ast.StructType); ok {
+			return &typ{kind: kindStruct, expr: src, name: t.Name}, nil
+		}
+
+		underlying, err := p.resolve(ts.Type, p.files[t.Name])
+		if err != nil {
+			return nil, err
+		}
+		if underlying.kind == kindStruct {
+			if !ts.Assign.IsValid() {
+				return nil, fmt.Errorf("unsupported type %s: defined struct types must be declared as structs", src)
+			}
+			return underlying, nil
+		}
+		// A named type keeps the shape of its underlying type, with the exception of []byte,
+		// which the encoder only treats as binary when the type is exactly []byte.
+		named := *underlying
+		named.expr = src
+		if named.kind == kindBytes {
+			named.kind = kindSlice
+			named.elem = &typ{kind: kindSmallUint, expr: "byte", basic: "uint8"}
+		}
+		return &named, nil
+	case *ast.SelectorExpr:
+		if isBSONSelector(t, file, "Reader") {
+			return &typ{kind: kindReader, expr: src}, nil
+		}
+	case *ast.StarExpr:
+		if sel, ok := t.X.(*ast.SelectorExpr); ok {
+			switch {
+			case isBSONSelector(sel, file, "Document"):
+				return &typ{kind: kindDocument, expr: src}, nil
+			case isBSONSelector(sel, file, "Element"):
+				return &typ{kind: kindElement, expr: src}, nil
+			}
+		}
+
+		elem, err := p.resolve(t.X, file)
+		if err != nil {
+			return nil, err
+		}
+		if !elem.encodable() {
+			return nil, fmt.Errorf("unsupported type %s", src)
+		}
+		return &typ{kind: kindPtr, expr: src, elem: elem}, nil
+	case *ast.ArrayType:
+		elem, err := p.resolve(t.Elt, file)
+		if err != nil {
+			return nil, err
+		}
+		if !elem.encodable() {
+			return nil, fmt.Errorf("unsupported type %s", src)
+		}
+
+		isByte := false
+		if ident, ok := t.Elt.(*ast.Ident); ok && (ident.Name == "byte" || ident.Name == "uint8") {
+			isByte = true
+		}
+
+		if t.Len == nil {
+			if isByte {
+				return &typ{kind: kindBytes, expr: src}, nil
+			}
+			return &typ{kind: kindSlice, expr: src, elem: elem}, nil
+		}
+
+		if isByte {
+			return &typ{kind: kindByteArray, expr: src, length: p.source(t.Len)}, nil
+		}
+		return &typ{kind: kindArray, expr: src, elem: elem, length: p.source(t.Len)}, nil
+	case *ast.MapType:
+		key, err := p.resolve(t.Key, file)
+		if err != nil {
+			return nil, err
+		}
+		if key.kind != kindString {
+			return nil, fmt.Errorf("unsupported map key type %s", key.expr)
+		}
+
+		elem, err := p.resolve(t.Value, file)
+		if err != nil {
+			return nil, err
+		}
+		if !elem.encodable() {
+			return nil, fmt.Errorf("unsupported type %s", src)
+		}
+		return &typ{kind: kindMap, expr: src, elem: elem, key: key.expr}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", src)
+}
+
+// encodable reports whether t can be used as a value within a pointer, slice, array, or map.
+// *bson.Element is only supported directly as a struct field since it carries its own key.
+func (t *typ) encodable() bool {
+	return t.kind != kindElement
+}
+
+// structRefs returns the names of the struct types referred to by t.
+func (t *typ) structRefs() []string {
+	switch {
+	case t.kind == kindStruct:
+		return []string{t.name}
+	case t.elem != nil:
+		return t.elem.structRefs()
+	}
+
+	return nil
+}
+
+func isBSONSelector(sel *ast.SelectorExpr, file *ast.File, name string) bool {
+	ident, ok := sel.X.(*ast.Ident)
+	if !ok || sel.Sel.Name != name || file == nil {
+		return false
+	}
+
+	for _, imp := range file.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || path != bsonImportPath {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name == ident.Name
+		}
+		return ident.Name == "bson"
+	}
+
+	return false
+}
+
+func (p *pkg) source(node ast.Node) string {
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, p.fset, node)
+	return buf.String()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/skriptble/wilson/bson"
+)
+
+// goStruct is a struct type to be emitted.
+type goStruct struct {
+	name   string
+	fields []*goField
+}
+
+// goField is a field of an emitted struct type.
+type goField struct {
+	name     string
+	typ      string
+	tag      string
+	comments []string
+}
+
+// generator converts document shapes into Go struct definitions.
+type generator struct {
+	structs []*goStruct
+	names   map[string]bool
+	imports map[string]bool
+}
+
+// generate returns the formatted source of a file in package pkg that declares a struct type
+// named root for the documents described by ds, along with a struct type for each embedded
+// document.
+func generate(pkg, root string, ds *docShape) ([]byte, error) {
+	g := &generator{names: make(map[string]bool), imports: make(map[string]bool)}
+	g.structFor(root, ds)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by bsonstruct from %d sample documents.\n\npackage %s\n", ds.count, pkg)
+
+	if len(g.imports) > 0 {
+		imports := make([]string, 0, len(g.imports))
+		for imp := range g.imports {
+			imports = append(imports, imp)
+		}
+		sort.Strings(imports)
+
+		buf.WriteString("\nimport (\n")
+		for _, imp := range imports {
+			fmt.Fprintf(&buf, "%q\n", imp)
+		}
+		buf.WriteString(")\n")
+	}
+
+	for _, st := range g.structs {
+		fmt.Fprintf(&buf, "\ntype %s struct {\n", st.name)
+		for _, f := range st.fields {
+			for _, c := range f.comments {
+				fmt.Fprintf(&buf, "// %s\n", c)
+			}
+			fmt.Fprintf(&buf, "%s %s %s\n", f.name, f.typ, f.tag)
+		}
+		buf.WriteString("}\n")
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// structFor declares a struct type for the documents described by ds and returns its name.
+func (g *generator) structFor(name string, ds *docShape) string {
+	name = g.uniqueName(name)
+	st := &goStruct{name: name}
+	g.structs = append(g.structs, st)
+
+	fieldNames := make(map[string]bool)
+	for _, key := range ds.keys {
+		vs := ds.fields[key]
+
+		fname := fieldName(key)
+		for i := 2; fieldNames[fname]; i++ {
+			fname = fieldName(key) + strconv.Itoa(i)
+		}
+		fieldNames[fname] = true
+
+		f := &goField{name: fname}
+		var conflicts []string
+		f.typ, conflicts = g.typeFor(name+fname, vs)
+
+		opts := ""
+		if vs.count < ds.count {
+			f.comments = append(f.comments, fmt.Sprintf("optional: present in %d of %d documents", vs.count, ds.count))
+			opts = ",omitempty"
+		}
+		if vs.nullable() {
+			f.comments = append(f.comments, "nullable: null or undefined in some documents")
+			opts = ",omitempty"
+		}
+		f.comments = append(f.comments, conflicts...)
+
+		tag := "bson:" + strconv.Quote(key+opts)
+		if strconv.CanBackquote(tag) {
+			f.tag = "`" + tag + "`"
+		} else {
+			f.tag = strconv.Quote(tag)
+		}
+
+		st.fields = append(st.fields, f)
+	}
+
+	return name
+}
+
+// typeFor returns the Go type for the values described by vs, declaring struct types for
+// embedded documents as needed. When the values have conflicting types, the returned type is
+// interface{} and a description of each conflict is returned.
+func (g *generator) typeFor(name string, vs *valueShape) (string, []string) {
+	types := vs.concreteTypes()
+
+	switch {
+	case len(types) == 0:
+		return "interface{}", nil
+	case isNumeric(types):
+		switch {
+		case vs.types[bson.TypeDouble] > 0:
+			return "float64", nil
+		case vs.types[bson.TypeInt64] > 0:
+			return "int64", nil
+		}
+		return "int32", nil
+	case len(types) > 1:
+		desc := make([]string, 0, len(types))
+		for _, t := range types {
+			desc = append(desc, fmt.Sprintf("%s (%d)", t, vs.types[t]))
+		}
+		return "interface{}", []string{"conflicting types: " + strings.Join(desc, ", ")}
+	}
+
+	switch types[0] {
+	case bson.TypeString:
+		return "string", nil
+	case bson.TypeEmbeddedDocument:
+		return g.structFor(name, vs.doc), nil
+	case bson.TypeArray:
+		elem, conflicts := g.typeFor(name, vs.elem)
+		for i, c := range conflicts {
+			conflicts[i] = "array elements have " + c
+		}
+		return "[]" + elem, conflicts
+	case bson.TypeBinary:
+		return "[]byte", nil
+	case bson.TypeObjectID:
+		g.imports["github.com/skriptble/wilson/bson/objectid"] = true
+		return "objectid.ObjectID", nil
+	case bson.TypeBoolean:
+		return "bool", nil
+	case bson.TypeDateTime:
+		g.imports["time"] = true
+		return "time.Time", nil
+	case bson.TypeRegex:
+		return g.bsonType("Regex"), nil
+	case bson.TypeDBPointer:
+		return g.bsonType("DBPointer"), nil
+	case bson.TypeJavaScript:
+		return g.bsonType("JavaScriptCode"), nil
+	case bson.TypeSymbol:
+		return g.bsonType("Symbol"), nil
+	case bson.TypeCodeWithScope:
+		return g.bsonType("CodeWithScope"), nil
+	case bson.TypeTimestamp:
+		return g.bsonType("Timestamp"), nil
+	case bson.TypeDecimal128:
+		g.imports["github.com/skriptble/wilson/bson/decimal"] = true
+		return "decimal.Decimal128", nil
+	}
+
+	// Min and max keys can only be decoded into an empty interface.
+	return "interface{}", nil
+}
+
+func (g *generator) bsonType(name string) string {
+	g.imports["github.com/skriptble/wilson/bson"] = true
+	return "bson." + name
+}
+
+func (g *generator) uniqueName(name string) string {
+	unique := name
+	for i := 2; g.names[unique]; i++ {
+		unique = name + strconv.Itoa(i)
+	}
+	g.names[unique] = true
+
+	return unique
+}
+
+func isNumeric(types []bson.Type) bool {
+	for _, t := range types {
+		if t != bson.TypeDouble && t != bson.TypeInt32 && t != bson.TypeInt64 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// initialisms are words that are written in all capitals in Go identifiers.
+var initialisms = map[string]bool{
+	"API": true, "DB": true, "HTML": true, "HTTP": true, "ID": true, "IP": true,
+	"JSON": true, "URI": true, "URL": true, "UUID": true, "XML": true,
+}
+
+// fieldName converts a document key into an exported Go identifier. The key is split into
+// words at non-alphanumeric characters and at lower to upper case transitions, and each word is
+// capitalized.
+func fieldName(key string) string {
+	var words []string
+	var word []rune
+	var prev rune
+	for _, r := range key {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = word[:0]
+		case unicode.IsUpper(r) && unicode.IsLower(prev) && len(word) > 0:
+			words = append(words, string(word))
+			word = append(word[:0], r)
+		default:
+			word = append(word, r)
+		}
+		prev = r
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	var name bytes.Buffer
+	for _, w := range words {
+		if upper := strings.ToUpper(w); initialisms[upper] {
+			name.WriteString(upper)
+			continue
+		}
+		runes := []rune(w)
+		runes[0] = unicode.ToUpper(runes[0])
+		name.WriteString(string(runes))
+	}
+
+	switch {
+	case name.Len() == 0:
+		return "Field"
+	case !unicode.IsUpper([]rune(name.String())[0]):
+		return "F" + name.String()
+	}
+
+	return name.String()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+func TestGenerate(t *testing.T) {
+	docs := []*bson.Document{
+		bson.NewDocument(
+			bson.C.ObjectID("_id", objectid.ObjectID{0x01}),
+			bson.C.String("name", "Alice"),
+			bson.C.Int32("age", 30),
+			bson.C.SubDocumentFromElements("address", bson.C.String("city", "Springfield")),
+			bson.C.ArrayFromElements("orders",
+				bson.AC.DocumentFromElements(bson.C.Int32("qty", 1), bson.C.Double("price", 1.5)),
+			),
+			bson.C.Int32("score", 7),
+			bson.C.ArrayFromElements("tags", bson.AC.String("a")),
+			bson.C.String("code", "x"),
+		),
+		bson.NewDocument(
+			bson.C.ObjectID("_id", objectid.ObjectID{0x02}),
+			bson.C.String("name", "Bob"),
+			bson.C.Int64("age", 40),
+			bson.C.SubDocumentFromElements("address", bson.C.String("city", "Shelbyville"), bson.C.String("zip", "12345")),
+			bson.C.ArrayFromElements("orders",
+				bson.AC.DocumentFromElements(bson.C.Int32("qty", 2), bson.C.Int32("price", 3)),
+				bson.AC.DocumentFromElements(bson.C.Int32("qty", 3), bson.C.Null("price")),
+			),
+			bson.C.String("score", "high"),
+			bson.C.ArrayFromElements("tags", bson.AC.Int32(1)),
+			bson.C.Boolean("isAdmin", true),
+			bson.C.Symbol("code", "y"),
+		),
+	}
+
+	ds := newDocShape()
+	for _, doc := range docs {
+		b, err := doc.MarshalBSON()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		err = ds.observe(b)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	got, err := generate("people", "Person", ds)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := `// Code generated by bsonstruct from 2 sample documents.
+
+package people
+
+import (
+	"github.com/skriptble/wilson/bson/objectid"
+)
+
+type Person struct {
+	ID      objectid.ObjectID ` + "`bson:\"_id\"`" + `
+	Name    string            ` + "`bson:\"name\"`" + `
+	Age     int64             ` + "`bson:\"age\"`" + `
+	Address PersonAddress     ` + "`bson:\"address\"`" + `
+	Orders  []PersonOrders    ` + "`bson:\"orders\"`" + `
+	// conflicting types: string (1), 32-bit integer (1)
+	Score interface{} ` + "`bson:\"score\"`" + `
+	// array elements have conflicting types: string (1), 32-bit integer (1)
+	Tags []interface{} ` + "`bson:\"tags\"`" + `
+	// conflicting types: string (1), symbol (1)
+	Code interface{} ` + "`bson:\"code\"`" + `
+	// optional: present in 1 of 2 documents
+	IsAdmin bool ` + "`bson:\"isAdmin,omitempty\"`" + `
+}
+
+type PersonAddress struct {
+	City string ` + "`bson:\"city\"`" + `
+	// optional: present in 1 of 2 documents
+	Zip string ` + "`bson:\"zip,omitempty\"`" + `
+}
+
+type PersonOrders struct {
+	Qty int32 ` + "`bson:\"qty\"`" + `
+	// nullable: null or undefined in some documents
+	Price float64 ` + "`bson:\"price,omitempty\"`" + `
+}
+`
+	if diff := cmp.Diff(string(got), want); diff != "" {
+		t.Errorf("Generated source does not match (-got +want):\n%s", diff)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	testCases := []struct {
+		key  string
+		want string
+	}{
+		{"name", "Name"},
+		{"_id", "ID"},
+		{"user_id", "UserID"},
+		{"createdAt", "CreatedAt"},
+		{"home-page.url", "HomePageURL"},
+		{"2fa", "F2fa"},
+		{"$", "Field"},
+		{"ABC", "ABC"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			got := fieldName(tc.key)
+			if got != tc.want {
+				t.Errorf("Unexpected field name. got %s; want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadExtJSON(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{"lines", "{\"a\": {\"$numberLong\": \"1\"}}\n{\"a\": 2.5, \"b\": \"c\"}\n"},
+		{"array", `[{"a": {"$numberLong": "1"}}, {"a": 2.5, "b": "c"}]`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ds := newDocShape()
+			err := readExtJSON(strings.NewReader(tc.input), ds.observe)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if ds.count != 2 {
+				t.Errorf("Unexpected number of documents. got %d; want 2", ds.count)
+			}
+			if got := ds.fields["a"].types; got[bson.TypeInt64] != 1 || got[bson.TypeDouble] != 1 {
+				t.Errorf("Unexpected types for a: %v", got)
+			}
+			if got := ds.fields["b"].count; got != 1 {
+				t.Errorf("Unexpected count for b. got %d; want 1", got)
+			}
+		})
+	}
+}
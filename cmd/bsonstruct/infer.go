@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/skriptble/wilson/bson"
+)
+
+// docShape accumulates the keys and value types observed across a set of documents.
+type docShape struct {
+	count  int
+	keys   []string
+	fields map[string]*valueShape
+}
+
+func newDocShape() *docShape {
+	return &docShape{fields: make(map[string]*valueShape)}
+}
+
+// observe adds the document r to the shape.
+func (ds *docShape) observe(r bson.Reader) error {
+	itr, err := r.Iterator()
+	if err != nil {
+		return err
+	}
+
+	ds.count++
+	for itr.Next() {
+		elem := itr.Element()
+
+		key := elem.Key()
+		vs, ok := ds.fields[key]
+		if !ok {
+			vs = newValueShape()
+			ds.fields[key] = vs
+			ds.keys = append(ds.keys, key)
+		}
+
+		err = vs.observe(elem.Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	return itr.Err()
+}
+
+// valueShape accumulates the types of the values observed for a single key, or for the
+// elements of an array.
+type valueShape struct {
+	count int
+	types map[bson.Type]int
+
+	// doc is the merged shape of the embedded documents observed.
+	doc *docShape
+
+	// elem is the merged shape of the elements of the arrays observed.
+	elem *valueShape
+}
+
+func newValueShape() *valueShape {
+	return &valueShape{types: make(map[bson.Type]int)}
+}
+
+func (vs *valueShape) observe(v *bson.Value) error {
+	vs.count++
+	vs.types[v.Type()]++
+
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		if vs.doc == nil {
+			vs.doc = newDocShape()
+		}
+		return vs.doc.observe(v.ReaderDocument())
+	case bson.TypeArray:
+		if vs.elem == nil {
+			vs.elem = newValueShape()
+		}
+
+		itr, err := v.ReaderArray().Iterator()
+		if err != nil {
+			return err
+		}
+		for itr.Next() {
+			err = vs.elem.observe(itr.Element().Value())
+			if err != nil {
+				return err
+			}
+		}
+		return itr.Err()
+	}
+
+	return nil
+}
+
+// nullable reports whether null or undefined values were observed.
+func (vs *valueShape) nullable() bool {
+	return vs.types[bson.TypeNull] > 0 || vs.types[bson.TypeUndefined] > 0
+}
+
+// concreteTypes returns the observed types other than null and undefined.
+func (vs *valueShape) concreteTypes() []bson.Type {
+	var types []bson.Type
+	for _, t := range typeOrder {
+		if t == bson.TypeNull || t == bson.TypeUndefined {
+			continue
+		}
+		if vs.types[t] > 0 {
+			types = append(types, t)
+		}
+	}
+
+	return types
+}
+
+// typeOrder lists the BSON types in a stable order for reporting.
+var typeOrder = []bson.Type{
+	bson.TypeDouble,
+	bson.TypeString,
+	bson.TypeEmbeddedDocument,
+	bson.TypeArray,
+	bson.TypeBinary,
+	bson.TypeUndefined,
+	bson.TypeObjectID,
+	bson.TypeBoolean,
+	bson.TypeDateTime,
+	bson.TypeNull,
+	bson.TypeRegex,
+	bson.TypeDBPointer,
+	bson.TypeJavaScript,
+	bson.TypeSymbol,
+	bson.TypeCodeWithScope,
+	bson.TypeInt32,
+	bson.TypeTimestamp,
+	bson.TypeInt64,
+	bson.TypeDecimal128,
+	bson.TypeMinKey,
+	bson.TypeMaxKey,
+}
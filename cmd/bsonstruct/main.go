@@ -0,0 +1,183 @@
+// Bsonstruct generates Go struct definitions from sample BSON documents.
+//
+// Bsonstruct reads a stream of BSON documents, such as a collection file written by mongodump,
+// or a stream of Extended JSON objects, such as the output of mongoexport, and infers the type
+// of each field across all of the samples. It writes struct definitions with bson tags that can
+// be used with bson.Decoder:
+//
+//	bsonstruct -name Person people.bson > person.go
+//	mongoexport -c people | bsonstruct -extjson -name Person > person.go
+//
+// A struct type is declared for every embedded document, including the documents within
+// arrays. Fields that are missing from some documents, or that are null in some documents, are
+// tagged with omitempty. Values with a mix of numeric types use the widest of those types, while
+// fields whose values have otherwise conflicting types are declared as interface{} and flagged
+// with a comment listing the types observed.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/skriptble/wilson/bson"
+	"github.com/skriptble/wilson/bson/extjson"
+)
+
+var (
+	extJSON = flag.Bool("extjson", false, "read Extended JSON instead of BSON")
+	name    = flag.String("name", "Document", "name of the generated struct type")
+	pkgName = flag.String("package", "main", "package name of the generated file")
+	limit   = flag.Int("limit", 0, "maximum number of documents to sample; 0 samples all documents")
+	output  = flag.String("output", "", "output file name; default standard output")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of bsonstruct:\n")
+	fmt.Fprintf(os.Stderr, "\tbsonstruct [flags] [file ...]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	ds := newDocShape()
+	read := readBSON
+	if *extJSON {
+		read = readExtJSON
+	}
+
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	for _, input := range inputs {
+		err := readInput(input, read, func(doc bson.Reader) error {
+			if *limit > 0 && ds.count >= *limit {
+				return errLimit
+			}
+			return ds.observe(doc)
+		})
+		if err == errLimit {
+			break
+		}
+		if err != nil {
+			fatal(fmt.Errorf("%s: %v", input, err))
+		}
+	}
+
+	src, err := generate(*pkgName, *name, ds)
+	if err != nil {
+		fatal(err)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(*output, src, 0644)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "bsonstruct: %v\n", err)
+	os.Exit(1)
+}
+
+var errLimit = errors.New("sample limit reached")
+
+// readInput calls f for each of the documents read by read from the named file, or from the
+// standard input if input is "-". The file is closed before readInput returns.
+func readInput(input string, read func(io.Reader, func(bson.Reader) error) error, f func(bson.Reader) error) error {
+	if input == "-" {
+		return read(os.Stdin, f)
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return read(file, f)
+}
+
+// readBSON calls f for each of the concatenated BSON documents read from r.
+func readBSON(r io.Reader, f func(bson.Reader) error) error {
+	br := bufio.NewReader(r)
+	for {
+		doc, err := bson.NewFromIOReader(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = f(doc)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readExtJSON calls f for each of the Extended JSON objects read from r. The objects may be
+// separated by whitespace, as written by mongoexport, or be the elements of an array.
+func readExtJSON(r io.Reader, f func(bson.Reader) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(raw) == 0 || raw[0] != '[' {
+			err = readExtJSONObject(raw, f)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var docs []json.RawMessage
+		err = json.Unmarshal(raw, &docs)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			err = readExtJSONObject(doc, f)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func readExtJSONObject(raw json.RawMessage, f func(bson.Reader) error) error {
+	b, err := extjson.ParseObjectToBuilder(string(raw))
+	if err != nil {
+		return err
+	}
+
+	doc := make([]byte, b.RequiredBytes())
+	_, err = b.WriteDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	return f(doc)
+}